@@ -13,6 +13,19 @@ const (
 	BusMessageEventUpdated = "moo.messages.updated"
 	BusMessageEventDeleted = "moo.messages.deleted"
 
+	BusSessionTerminated = "moo.sessions.terminated"
+
+	BusUserLockout  = "moo.users.lockout"
+	BusUserUnlocked = "moo.users.unlocked"
+
+	// BusUserLogin 用户登录成功的事件, 数据为 *authn.UserLogin
+	BusUserLogin = "moo.users.login"
+
+	// BusUserChanged 用户被修改的事件, 数据为 *ChangedEvent
+	BusUserChanged = "moo.users.changed"
+	// BusUsergroupChanged 用户组被修改的事件, 数据为 *ChangedEvent
+	BusUsergroupChanged = "moo.usergroups.changed"
+
 	EventAlerts = "event.alerts"
 )
 
@@ -24,6 +37,33 @@ type SysKeepaliveEvent struct {
 	Action string `json:"action,omitempty"`
 }
 
+// ChangedEvent 的 Action
+const (
+	ChangeCreated           = "created"
+	ChangeUpdated           = "updated"
+	ChangeEnabled           = "enabled"
+	ChangeDisabled          = "disabled"
+	ChangeDeleted           = "deleted"
+	ChangeRolesChanged      = "roles_changed"
+	ChangeUsergroupsChanged = "usergroups_changed"
+	ChangeMembersChanged    = "members_changed"
+	ChangeRefresh           = "refresh"
+)
+
+// ChangedEvent 用户或用户组被修改的事件, 缓存按 IDs 删除对应的项, IDs 为空或 Action 为 ChangeRefresh 时刷新全部缓存
+type ChangedEvent struct {
+	Action string  `json:"action"`
+	IDs    []int64 `json:"ids,omitempty"`
+
+	// Node 发出事件的节点, 本节点产生的事件为空
+	Node string `json:"node,omitempty"`
+}
+
+// IsRefresh 是否需要刷新全部缓存
+func (evt *ChangedEvent) IsRefresh() bool {
+	return evt.Action == ChangeRefresh || len(evt.IDs) == 0
+}
+
 type Sender interface {
 	Send(ctx context.Context, toppic, source string, payload interface{}) error
 }
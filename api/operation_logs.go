@@ -25,6 +25,10 @@ type OperationLog struct {
 	Content    string              `json:"content,omitempty" xorm:"content null"`
 	Fields     *OperationLogRecord `json:"attributes,omitempty" xorm:"attributes json null"`
 	CreatedAt  time.Time           `json:"created_at,omitempty" xorm:"created_at"`
+
+	// 管理员模拟其它用户登录时做的操作, 这里记录管理员
+	ImpersonatorID   int64  `json:"impersonator_id,omitempty" xorm:"impersonator_id null"`
+	ImpersonatorName string `json:"impersonator_name,omitempty" xorm:"impersonator_name null"`
 }
 
 type ChangeRecord struct {
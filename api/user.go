@@ -43,21 +43,138 @@ const (
 	CfgUserCasServer            = "users.cas.server"
 	CfgUserCasRoles             = "users.cas.roles"
 	CfgUserCasFieldPrefix       = "users.cas.fields."
+	CfgUserCasValidatePath      = "users.cas.validate_path"
+	CfgUserCasRoleAttribute     = "users.cas.role_attribute"
 	CfgSysDisableUsers          = "users.disabled_list"
 
+	CfgUserSamlRootURL            = "users.saml.root_url"
+	CfgUserSamlEntityID           = "users.saml.entity_id"
+	CfgUserSamlIdpMetadataURL     = "users.saml.idp_metadata_url"
+	CfgUserSamlIdpMetadataFile    = "users.saml.idp_metadata_file"
+	CfgUserSamlCertFile           = "users.saml.cert_file"
+	CfgUserSamlKeyFile            = "users.saml.key_file"
+	CfgUserSamlBinding            = "users.saml.binding"
+	CfgUserSamlAllowIdpInitiated  = "users.saml.allow_idp_initiated"
+	CfgUserSamlUserPrefix         = "users.saml.user_prefix"
+	CfgUserSamlUsernameAttribute  = "users.saml.username_attribute"
+	CfgUserSamlNicknameAttribute  = "users.saml.nickname_attribute"
+	CfgUserSamlRoleAttribute      = "users.saml.role_attribute"
+	CfgUserSamlRoles              = "users.saml.roles"
+	CfgUserSamlFieldPrefix        = "users.saml.fields."
+
 	CfgUserLoginURL               = "users.login_url"
 	CfgUserRedirectMode           = "users.redirect_mode"
 	CfgUserRedirectTo             = "users.redirect_to"
 	CfgUserLoginConflict          = "users.login_conflict"
+	CfgUserMaxSessions            = "users.max_sessions"
+	CfgUserMaxSessionsPolicy      = "users.max_sessions_policy"
 	CfgUserMaxLoginFailCount      = "users.max_login_fail_count"
 	CfgUserCaptchaDisabled        = "users.captcha.disabled"
 	CfgUserUsbKeyListenAddress    = "users.usbkey.listen_address"
+	CfgUserLoginLinkSecretKey     = "users.login_link.secret_key"
+	CfgUserLoginLinkBaseURL       = "users.login_link.base_url"
+	CfgUserLoginLinkExpires       = "users.login_link.expires"
+	CfgUserLoginLinkMaxExpires    = "users.login_link.max_expires"
+
+	CfgUserCertLoginEnabled = "users.certlogin.enabled"
+	CfgUserCertLoginCAFile  = "users.certlogin.ca_file"
+	CfgUserCertLoginCRLFile = "users.certlogin.crl_file"
+	// CfgUserCertLoginUsernameFields 按顺序从证书中取用户名的字段, 如 san.upn,san.email,subject.cn
+	CfgUserCertLoginUsernameFields = "users.certlogin.username_fields"
+	// CfgUserCertLoginUsernamePattern 对字段值做匹配的正则表达式, 有分组时取第一个分组
+	CfgUserCertLoginUsernamePattern = "users.certlogin.username_pattern"
+	// CfgUserCertLoginRequireBinding 为 true 时证书必须先绑定到用户才能登录
+	CfgUserCertLoginRequireBinding = "users.certlogin.require_binding"
+	// CfgUserCertLoginSecondFactor 为 true 时绑定了证书的用户用密码登录时也必须出示绑定的证书
+	CfgUserCertLoginSecondFactor = "users.certlogin.second_factor"
+
+	// CfgUserAuthzEnabled 为 true 时使用数据库中的授权来检查权限
+	CfgUserAuthzEnabled = "users.authz.enabled"
+	// CfgUserAuthzSuperRoles 拥有全部权限的角色, 默认为 super,administrator
+	CfgUserAuthzSuperRoles = "users.authz.super_roles"
+	// CfgUserAuthzRoutesStrict 为 true 时路由对应的权限必须明确授予, 否则 PermissionNeglected 也可以访问
+	CfgUserAuthzRoutesStrict = "users.authz.routes.strict"
+
 	CfgUserFilename               = "users.filename"
 	CfgUserSyncDbFind             = "users.sync.db.find"
 	CfgUserJumpToWelcomeIfNewUser = "users.jump_to_welcome_if_new_user"
 	CfgSSOContextPath             = "sso.context_path"
 	CfgUserAppSecret              = "app.secret"
 
+	CfgUserLoginFailWindow          = "users.login_fail.window"
+	CfgUserLoginFailMaxAddressCount = "users.login_fail.max_address_count"
+	CfgUserLoginFailDelayBase       = "users.login_fail.delay_base"
+	CfgUserLoginFailDelayMax        = "users.login_fail.delay_max"
+	CfgUserLoginFailCheckInterval   = "users.login_fail.check_interval"
+
+	CfgUserAPITokenDisabled       = "users.api_tokens.disabled"
+	CfgUserAPITokenAudit          = "users.api_tokens.audit"
+	CfgUserAPITokenDefaultExpires = "users.api_tokens.default_expires"
+
+	CfgUserPasswordMinLength          = "users.password.min_length"
+	CfgUserPasswordMaxLength          = "users.password.max_length"
+	CfgUserPasswordMinCharClasses     = "users.password.min_char_classes"
+	CfgUserPasswordRequireUpper       = "users.password.require_upper"
+	CfgUserPasswordRequireLower       = "users.password.require_lower"
+	CfgUserPasswordRequireDigit       = "users.password.require_digit"
+	CfgUserPasswordRequireSpecial     = "users.password.require_special"
+	CfgUserPasswordDictionary         = "users.password.dictionary"
+	CfgUserPasswordRejectWeak         = "users.password.reject_weak"
+	CfgUserPasswordNotContainUsername = "users.password.not_contain_username"
+	CfgUserPasswordHistory            = "users.password.history"
+	CfgUserPasswordMaxAge             = "users.password.max_age"
+	CfgUserPasswordWarnBefore         = "users.password.warn_before"
+	CfgUserPasswordChangeAfterReset   = "users.password.change_after_reset"
+
+	CfgUserPasswordAlgorithm        = "users.password.algorithm"
+	CfgUserPasswordRehash           = "users.password.rehash"
+	CfgUserPasswordBcryptCost       = "users.password.bcrypt.cost"
+	CfgUserPasswordArgon2Time       = "users.password.argon2id.time"
+	CfgUserPasswordArgon2Memory     = "users.password.argon2id.memory"
+	CfgUserPasswordArgon2Threads    = "users.password.argon2id.threads"
+	CfgUserPasswordScryptLogN       = "users.password.scrypt.ln"
+	CfgUserPasswordScryptR          = "users.password.scrypt.r"
+	CfgUserPasswordScryptP          = "users.password.scrypt.p"
+	CfgUserPasswordPBKDF2Iterations = "users.password.pbkdf2.iterations"
+
+	// CfgUserHTTPAPIDisabled 为 true 时不注册用户, 用户组和角色管理的 REST 接口
+	CfgUserHTTPAPIDisabled = "users.httpapi.disabled"
+
+	// CfgUserCacheSyncDisabled 为 true 时不通过 pubsub 在节点之间同步用户和用户组的修改事件
+	CfgUserCacheSyncDisabled = "users.cache_sync.disabled"
+	// CfgUserCacheSyncTopic 同步修改事件的 pubsub 主题, 默认为 moo.users.changes
+	CfgUserCacheSyncTopic = "users.cache_sync.topic"
+	// CfgUserCacheSyncNodeID 本节点的标识, 用于忽略自已发出的事件, 默认为主机名加进程号
+	CfgUserCacheSyncNodeID = "users.cache_sync.node_id"
+
+	// CfgUserLifecycleDisabled 为 true 时不自动处理过期, 长期未登录和已删除的用户
+	CfgUserLifecycleDisabled = "users.lifecycle.disabled"
+	// CfgUserLifecycleInterval 自动检查的间隔, 默认为 24h, 为 0 时只能手动执行
+	CfgUserLifecycleInterval = "users.lifecycle.interval"
+	// CfgUserLifecycleInactiveDays 超过多少天没有登录的用户将被禁用, 为 0 时不禁用
+	CfgUserLifecycleInactiveDays = "users.lifecycle.inactive_days"
+	// CfgUserLifecyclePurgeAfterDays 软删除的用户保留多少天后彻底删除, 为 0 时不删除
+	CfgUserLifecyclePurgeAfterDays = "users.lifecycle.purge_after_days"
+	// CfgUserLifecycleWarnBeforeDays 提前多少天发出警告, 默认为 7
+	CfgUserLifecycleWarnBeforeDays = "users.lifecycle.warn_before_days"
+	// CfgUserLifecycleNotifyTo 接收彻底删除用户的警告的邮箱, 多个时用逗号分隔
+	CfgUserLifecycleNotifyTo = "users.lifecycle.notify_to"
+
+	// CfgUserFieldsValidationDisabled 为 true 时不按 user_fields*.json 中的定义检查用户属性
+	CfgUserFieldsValidationDisabled = "users.fields.validation.disabled"
+	// CfgUserFieldsCheckInterval 检查 user_fields*.json 是否改变的间隔, 改变后记录一个新的版本, 为 0 时只在启动时记录
+	CfgUserFieldsCheckInterval = "users.fields.check_interval"
+
+	CfgUserRecoveryDisabled        = "users.recovery.disabled"
+	CfgUserRecoverySecretKey       = "users.recovery.secret_key"
+	CfgUserRecoveryBaseURL         = "users.recovery.base_url"
+	CfgUserRecoveryResetExpires    = "users.recovery.reset_expires"
+	CfgUserRecoveryActivateExpires = "users.recovery.activate_expires"
+	// 在时间窗口内同一个帐号或同一个地址请求重置密码的次数限制
+	CfgUserRecoveryRequestWindow   = "users.recovery.request_window"
+	CfgUserRecoveryAccountRequests = "users.recovery.account_requests"
+	CfgUserRecoveryAddressRequests = "users.recovery.address_requests"
+
 	CfgUserLdapEnabled      = "users.ldap_enabled"
 	CfgUserLdapAddress      = "users.ldap_address"
 	CfgUserLdapTLS          = "users.ldap_tls"
@@ -68,6 +185,55 @@ const (
 	CfgUserLdapLoginRoleField  = "users.ldap_login_role_field"
 	CfgUserLdapLoginRoleName    = "users.ldap_login_role"
 
+	CfgUserLdapCAFile              = "users.ldap_ca_file"
+	CfgUserLdapServerName          = "users.ldap_server_name"
+	CfgUserLdapInsecureSkipVerify  = "users.ldap_insecure_skip_verify"
+	CfgUserLdapBindDN              = "users.ldap_bind_dn"
+	CfgUserLdapBindPassword        = "users.ldap_bind_password"
+	CfgUserLdapSearchBind          = "users.ldap_search_bind"
+	CfgUserLdapTimeout             = "users.ldap_timeout"
+	CfgUserLdapPoolSize            = "users.ldap_pool_size"
+	CfgUserLdapHealthCheckInterval = "users.ldap_health_check_interval"
+
+	CfgUserLdapSyncAddress           = "users.ldap_sync.address"
+	CfgUserLdapSyncTLS               = "users.ldap_sync.tls"
+	CfgUserLdapSyncBindDN            = "users.ldap_sync.bind_dn"
+	CfgUserLdapSyncBindPassword      = "users.ldap_sync.bind_password"
+	CfgUserLdapSyncBaseDN            = "users.ldap_sync.base_dn"
+	CfgUserLdapSyncFilter            = "users.ldap_sync.filter"
+	CfgUserLdapSyncPageSize          = "users.ldap_sync.page_size"
+	CfgUserLdapSyncTimeout           = "users.ldap_sync.timeout"
+	CfgUserLdapSyncUsernameAttribute = "users.ldap_sync.username_attribute"
+	CfgUserLdapSyncNicknameAttribute = "users.ldap_sync.nickname_attribute"
+	CfgUserLdapSyncFieldPrefix       = "users.ldap_sync.fields."
+	CfgUserLdapSyncGroups            = "users.ldap_sync.groups"
+	CfgUserLdapSyncDisableMissing    = "users.ldap_sync.disable_missing"
+	CfgUserLdapSyncInterval          = "users.ldap_sync.interval"
+
+	CfgUserProvisionSource         = "users.provision.source"
+	CfgUserProvisionSourceName     = "users.provision.source_name"
+	CfgUserProvisionSQLDriver      = "users.provision.sql.driver"
+	CfgUserProvisionSQLURL         = "users.provision.sql.url"
+	CfgUserProvisionSQLList        = "users.provision.sql.list"
+	CfgUserProvisionSQLIncremental = "users.provision.sql.incremental"
+	CfgUserProvisionSQLFind        = "users.provision.sql.find"
+	CfgUserProvisionFile           = "users.provision.file"
+	CfgUserProvisionHTTPURL        = "users.provision.http.url"
+	CfgUserProvisionHTTPHeaders    = "users.provision.http.headers."
+	CfgUserProvisionHTTPTimeout    = "users.provision.http.timeout"
+	CfgUserProvisionNameField      = "users.provision.name_field"
+	CfgUserProvisionNicknameField  = "users.provision.nickname_field"
+	CfgUserProvisionDisabledField  = "users.provision.disabled_field"
+	CfgUserProvisionUpdatedAtField = "users.provision.updated_at_field"
+	CfgUserProvisionFieldPrefix    = "users.provision.fields."
+	CfgUserProvisionRoleField      = "users.provision.role_field"
+	CfgUserProvisionRoleSeparator  = "users.provision.role_separator"
+	CfgUserProvisionRoleMapPrefix  = "users.provision.role_map."
+	CfgUserProvisionRoles          = "users.provision.roles"
+	CfgUserProvisionDisableMissing = "users.provision.disable_missing"
+	CfgUserProvisionInterval       = "users.provision.interval"
+	CfgUserProvisionFullInterval   = "users.provision.full_interval"
+
 	CfgRootEndpoint = "moo_root_endpoint"
 	CfgHomeURL      = "home_url"
 
@@ -92,6 +258,15 @@ const (
 	CfgDbPrefix          = ".db_prefix"
 	CfgDbDataPrefix      = ".db_data_prefix"
 
+	CfgMailSender         = "mail.sender"
+	CfgMailFrom           = "mail.from"
+	CfgMailSMTPAddress    = "mail.smtp.address"
+	CfgMailSMTPUsername   = "mail.smtp.username"
+	CfgMailSMTPPassword   = "mail.smtp.password"
+	CfgMailSMTPTLS        = "mail.smtp.tls"
+	CfgMailSMTPSkipVerify = "mail.smtp.insecure_skip_verify"
+	CfgMailFileDir        = "mail.file.dir"
+
 	CfgHealthKeepliveTimeout  = "health.keeplive.timeout_sec"
 	CfgOperationLoggerVersion = "operation_logger.version"
 
@@ -187,6 +362,11 @@ type UserManager interface {
 	UserByID(ctx context.Context, userID int64, opts ...Option) (User, error)
 }
 
+// UserSyncer 从外部的用户目录中读取用户的姓名和属性, 登录模块(如 CAS)在创建新用户时用它补全用户信息
+type UserSyncer interface {
+	Read(ctx context.Context, name string) (string, map[string]string, error)
+}
+
 // User 用户信息
 type User interface {
 	ID() int64
@@ -260,6 +440,24 @@ type Usergroup interface {
 
 	// 用户成员
 	Users(ctx context.Context, opts ...Option) ([]User, error)
+
+	// 组或它的下级用户组中是不是有这个用户
+	HasUserRecursive(ctx context.Context, user User) bool
+
+	// 组或它的下级用户组中是不是有这个用户
+	HasUserIDRecursive(ctx context.Context, userID int64) bool
+
+	// 组和它的所有下级用户组的用户成员, 已去重
+	UsersRecursive(ctx context.Context, opts ...Option) ([]User, error)
+
+	// 所有的上级用户组, 从父用户组开始一直到顶级用户组
+	Ancestors(ctx context.Context) ([]Usergroup, error)
+
+	// 直接下级用户组, 按顺序排列
+	Children(ctx context.Context, opts ...Option) ([]Usergroup, error)
+
+	// 所有的下级用户组, 按层次先序排列
+	Descendants(ctx context.Context, opts ...Option) ([]Usergroup, error)
 }
 
 // UsergroupManager 用户管理
@@ -303,15 +501,84 @@ func ReadUserFromContext(ctx context.Context) (User, error) {
 	}
 	f, ok := o.(ReadCurrentUserFunc)
 	if ok {
-		return f(ctx)
+		u, err := f(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return withImpersonation(ctx, u), nil
 	}
 	u, ok := o.(User)
 	if ok {
-		return u, nil
+		return withImpersonation(ctx, u), nil
 	}
 	return nil, errors.NewError(http.StatusInternalServerError, fmt.Sprintf("user is unknown type - %T", o))
 }
 
+// Impersonation 管理员以其它用户的身份登录时, 记录原来的用户
+type Impersonation struct {
+	ImpersonatorID   int64  `json:"impersonator_id"`
+	ImpersonatorName string `json:"impersonator_name"`
+	// SessionID 管理员原来的会话, 结束模拟登录时恢复它
+	SessionID string `json:"-"`
+}
+
+type impersonationKey struct{}
+
+func (*impersonationKey) String() string {
+	return "moo-impersonation-key"
+}
+
+var ImpersonationKey = &impersonationKey{}
+
+func ContextWithImpersonation(ctx context.Context, impersonation *Impersonation) context.Context {
+	return context.WithValue(ctx, ImpersonationKey, impersonation)
+}
+
+// ImpersonationFromContext 当前请求是模拟登录的会话时返回原来的用户, 否则返回 nil
+func ImpersonationFromContext(ctx context.Context) *Impersonation {
+	if ctx == nil {
+		return nil
+	}
+	impersonation, _ := ctx.Value(ImpersonationKey).(*Impersonation)
+	return impersonation
+}
+
+// ImpersonatedUser 模拟登录时 ReadUserFromContext 返回的用户, 它是被模拟的用户, 同时带有原来的用户
+type ImpersonatedUser interface {
+	User
+
+	Impersonation() *Impersonation
+}
+
+type impersonatedUser struct {
+	User
+
+	impersonation *Impersonation
+}
+
+func (u *impersonatedUser) Impersonation() *Impersonation {
+	return u.impersonation
+}
+
+// Unwrap 返回被模拟的用户
+func (u *impersonatedUser) Unwrap() User {
+	return u.User
+}
+
+func withImpersonation(ctx context.Context, u User) User {
+	if u == nil {
+		return u
+	}
+	impersonation := ImpersonationFromContext(ctx)
+	if impersonation == nil {
+		return u
+	}
+	if _, ok := u.(ImpersonatedUser); ok {
+		return u
+	}
+	return &impersonatedUser{User: u, impersonation: impersonation}
+}
+
 func MakeMockUser(id int64, name string) *mockUser {
 	return &mockUser{id: id, name: name}
 }
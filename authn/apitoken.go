@@ -0,0 +1,28 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// HeaderAPIToken 个人访问令牌的请求头
+const HeaderAPIToken = "X-Api-Token"
+
+// APITokenVerifier 校验个人访问令牌，成功时返回令牌所属的用户
+type APITokenVerifier interface {
+	Verify(ctx context.Context, req *http.Request, token string) (int64, error)
+}
+
+type ArgAPITokenVerifier struct {
+	fx.In
+
+	Verifier APITokenVerifier `optional:"true"`
+}
+
+// APITokenFromRequest 从请求头中读个人访问令牌, 不接受查询参数, 以免令牌被记录到访问日志和 Referer 中
+func APITokenFromRequest(req *http.Request) string {
+	return strings.TrimSpace(req.Header.Get(HeaderAPIToken))
+}
@@ -0,0 +1,325 @@
+package apitokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/netutil"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+)
+
+// PermissionManage 管理其它用户的访问令牌的权限
+const PermissionManage = "um.api_tokens.manage"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionManage, Title: "管理 API 令牌", Group: "会话管理"})
+}
+
+const tokenPrefix = "moo_"
+
+var (
+	ErrTokenInvalid    = errors.NewError(http.StatusUnauthorized, "访问令牌无效")
+	ErrTokenRevoked    = errors.NewError(http.StatusUnauthorized, "访问令牌已被吊销")
+	ErrTokenExpired    = errors.NewError(http.StatusUnauthorized, "访问令牌已过期")
+	ErrTokenIPBlocked  = errors.NewError(http.StatusUnauthorized, "访问令牌不允许从该地址使用")
+	ErrOwnerDisabled   = errors.NewError(http.StatusUnauthorized, "访问令牌所属的用户已被禁用")
+	ErrOwnerLocked     = errors.NewError(http.StatusUnauthorized, "访问令牌所属的用户已被锁定")
+	ErrTokenNotFound   = errors.ErrNotFoundWithText("访问令牌不存在!")
+	ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有管理访问令牌的权限")
+)
+
+// CreateRequest 创建访问令牌的参数
+type CreateRequest struct {
+	UserID    int64      `json:"user_id,omitempty"`
+	Name      string     `json:"name"`
+	IPList    []string   `json:"ip_list,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateResult 创建访问令牌的结果，令牌明文只在这里返回一次
+type CreateResult struct {
+	usermodels.APIToken
+	Token string `json:"token"`
+}
+
+type Tokens struct {
+	logger            log.Logger
+	dao               usermodels.APITokenDao
+	users             usermodels.UserQueryer
+	opLogger          api.OperationLogger
+	audit             bool
+	defaultExpires    time.Duration
+	lockedTimeExpires time.Duration
+}
+
+func NewTokens(env *moo.Environment, dao usermodels.APITokenDao, users usermodels.UserQueryer, opLogger api.OperationLogger) *Tokens {
+	return &Tokens{
+		logger:            env.Logger.Named("apitokens"),
+		dao:               dao,
+		users:             users,
+		opLogger:          opLogger,
+		audit:             env.Config.BoolWithDefault(api.CfgUserAPITokenAudit, true),
+		defaultExpires:    env.Config.DurationWithDefault(api.CfgUserAPITokenDefaultExpires, 0),
+		lockedTimeExpires: env.Config.DurationWithDefault(api.CfgUserLockedTimeExpiresKey, 0),
+	}
+}
+
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func generateToken() (string, error) {
+	var bs [24]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(bs[:]), nil
+}
+
+// canManage 用户总是可以管理自已的令牌，管理别人的令牌需要权限
+func canManage(ctx context.Context, currentUser api.User, userID int64) error {
+	if currentUser.ID() == userID {
+		return nil
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionManage)
+	if err != nil {
+		return errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return ErrPermissionDenny
+	}
+	return nil
+}
+
+func (tokens *Tokens) Create(ctx context.Context, currentUser api.User, req *CreateRequest) (*CreateResult, error) {
+	if req.UserID == 0 {
+		req.UserID = currentUser.ID()
+	}
+	if err := canManage(ctx, currentUser, req.UserID); err != nil {
+		return nil, err
+	}
+	if len(req.IPList) > 0 {
+		if _, err := netutil.ToCheckers(req.IPList); err != nil {
+			return nil, errors.WithHTTPCode(errors.Wrap(err, "IP 列表不正确"), http.StatusBadRequest)
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成访问令牌失败")
+	}
+
+	record := &usermodels.APIToken{
+		UserID:    req.UserID,
+		Name:      req.Name,
+		Prefix:    token[:len(tokenPrefix)+6],
+		TokenHash: HashToken(token),
+		IPList:    req.IPList,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if record.ExpiresAt == nil && tokens.defaultExpires > 0 {
+		expiresAt := time.Now().Add(tokens.defaultExpires)
+		record.ExpiresAt = &expiresAt
+	}
+
+	validator := validation.Default.New()
+	if record.Validate(validator) {
+		return nil, validator.ToError()
+	}
+
+	record.ID, err = tokens.dao.Create(ctx, record)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建访问令牌失败")
+	}
+
+	if err := tokens.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "add_api_token",
+		Successful: true,
+		Content:    "创建访问令牌: " + record.Name + "(" + record.Prefix + ")",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "api_token",
+			ObjectID:   record.ID,
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "添加操作日志失败")
+	}
+	return &CreateResult{APIToken: *record, Token: token}, nil
+}
+
+// List 查询用户的访问令牌, userID 为 0 时为当前用户; all 为 true 时忽略 userID, 返回所有用户的令牌, 这需要管理权限
+func (tokens *Tokens) List(ctx context.Context, currentUser api.User, userID int64, all, includeRevoked bool) ([]usermodels.APIToken, error) {
+	var id sql.NullInt64
+	if all {
+		ok, err := currentUser.HasPermission(ctx, PermissionManage)
+		if err != nil {
+			return nil, errors.Wrap(err, "检查权限失败")
+		}
+		if !ok {
+			return nil, ErrPermissionDenny
+		}
+	} else {
+		if userID == 0 {
+			userID = currentUser.ID()
+		}
+		if err := canManage(ctx, currentUser, userID); err != nil {
+			return nil, err
+		}
+		id.Valid = true
+		id.Int64 = userID
+	}
+
+	list, err := tokens.dao.List(ctx, id, includeRevoked)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询访问令牌失败")
+	}
+	return list, nil
+}
+
+func (tokens *Tokens) Revoke(ctx context.Context, currentUser api.User, id int64) error {
+	var record usermodels.APIToken
+	if err := tokens.dao.GetByID(ctx, id)(&record); err != nil {
+		if errors.IsNotFound(err) || err == sql.ErrNoRows {
+			return ErrTokenNotFound
+		}
+		return errors.Wrap(err, "查询访问令牌失败")
+	}
+	if err := canManage(ctx, currentUser, record.UserID); err != nil {
+		return err
+	}
+
+	if _, err := tokens.dao.Revoke(ctx, id); err != nil {
+		return errors.Wrap(err, "吊销访问令牌失败")
+	}
+
+	if err := tokens.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "revoke_api_token",
+		Successful: true,
+		Content:    "吊销访问令牌: " + record.Name + "(" + record.Prefix + ")",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "api_token",
+			ObjectID:   record.ID,
+		},
+	}); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
+
+// checkOwner 检查令牌所属的用户是否可以使用, 锁定的判断和用户登录时的相同
+func checkOwner(owner *usermodels.User, lockedTimeExpires time.Duration, now time.Time) error {
+	if owner.IsDisabled() {
+		return ErrOwnerDisabled
+	}
+	if owner.LockedAt == nil || owner.LockedAt.IsZero() || owner.Name == api.UserAdmin {
+		return nil
+	}
+	if lockedTimeExpires == 0 || now.Before(owner.LockedAt.Add(lockedTimeExpires)) {
+		return ErrOwnerLocked
+	}
+	return nil
+}
+
+// Verify 实现 authn.APITokenVerifier
+func (tokens *Tokens) Verify(ctx context.Context, req *http.Request, token string) (int64, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return 0, ErrTokenInvalid
+	}
+
+	var record usermodels.APIToken
+	if err := tokens.dao.GetByHash(ctx, HashToken(token))(&record); err != nil {
+		if errors.IsNotFound(err) || err == sql.ErrNoRows {
+			return 0, ErrTokenInvalid
+		}
+		return 0, errors.Wrap(err, "查询访问令牌失败")
+	}
+
+	address := authn.RealIP(req)
+	logger := tokens.logger.With(log.Any("token_id", record.ID),
+		log.Any("user_id", record.UserID),
+		log.String("address", address))
+
+	if record.IsRevoked() {
+		logger.Info("访问令牌已被吊销")
+		return 0, ErrTokenRevoked
+	}
+	now := time.Now()
+	if record.IsExpired(now) {
+		logger.Info("访问令牌已过期")
+		return 0, ErrTokenExpired
+	}
+
+	// 用户被禁用或锁定后令牌不能再使用, 每次都要检查, 因为令牌不会随之被吊销
+	var owner usermodels.User
+	if err := tokens.users.GetUserByID(ctx, record.UserID)(&owner); err != nil {
+		if errors.IsNotFound(err) || err == sql.ErrNoRows {
+			logger.Info("访问令牌所属的用户不存在")
+			return 0, ErrTokenInvalid
+		}
+		return 0, errors.Wrap(err, "查询访问令牌所属的用户失败")
+	}
+	if err := checkOwner(&owner, tokens.lockedTimeExpires, now); err != nil {
+		logger.Info(err.Error())
+		return 0, err
+	}
+
+	if len(record.IPList) > 0 {
+		checkers, err := netutil.ToCheckers(record.IPList)
+		if err != nil {
+			logger.Warn("访问令牌的 IP 列表不正确", log.Any("ip_list", record.IPList), log.Error(err))
+			return 0, ErrTokenIPBlocked
+		}
+		ip := net.ParseIP(address)
+		blocked := true
+		if ip != nil {
+			for _, checker := range checkers {
+				if checker.Contains(ip) {
+					blocked = false
+					break
+				}
+			}
+		}
+		if blocked {
+			logger.Info("访问令牌不允许从该地址使用")
+			return 0, ErrTokenIPBlocked
+		}
+	}
+
+	if err := tokens.dao.Touch(ctx, record.ID, address); err != nil {
+		logger.Warn("更新访问令牌的最后使用时间失败", log.Error(err))
+	}
+
+	if tokens.audit {
+		if err := tokens.opLogger.LogRecord(ctx, &api.OperationLog{
+			UserID:     record.UserID,
+			Type:       "use_api_token",
+			Successful: true,
+			Content:    "使用访问令牌: " + record.Name + "(" + record.Prefix + "), 地址: " + address + ", 请求: " + req.Method + " " + req.URL.Path,
+			Fields: &api.OperationLogRecord{
+				ObjectType: "api_token",
+				ObjectID:   record.ID,
+			},
+		}); err != nil {
+			logger.Warn("添加操作日志失败", log.Error(err))
+		}
+	}
+	return record.UserID, nil
+}
@@ -0,0 +1,182 @@
+package apitokens
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type testUser struct {
+	api.User
+
+	id          int64
+	permissions map[string]bool
+}
+
+func (u *testUser) ID() int64    { return u.id }
+func (u *testUser) Name() string { return "user" }
+func (u *testUser) HasPermission(ctx context.Context, permissionID string) (bool, error) {
+	return u.permissions[permissionID], nil
+}
+
+type testDao struct {
+	usermodels.APITokenDao
+
+	tokens  []usermodels.APIToken
+	listArg sql.NullInt64
+	touched int
+}
+
+func (dao *testDao) GetByHash(ctx context.Context, hash string) func(*usermodels.APIToken) error {
+	return func(token *usermodels.APIToken) error {
+		for idx := range dao.tokens {
+			if dao.tokens[idx].TokenHash == hash {
+				*token = dao.tokens[idx]
+				return nil
+			}
+		}
+		return sql.ErrNoRows
+	}
+}
+
+func (dao *testDao) List(ctx context.Context, userID sql.NullInt64, includeRevoked bool) ([]usermodels.APIToken, error) {
+	dao.listArg = userID
+	return dao.tokens, nil
+}
+
+func (dao *testDao) Touch(ctx context.Context, id int64, address string) error {
+	dao.touched++
+	return nil
+}
+
+type testUsers struct {
+	usermodels.UserQueryer
+
+	users map[int64]usermodels.User
+}
+
+func (users *testUsers) GetUserByID(ctx context.Context, id int64) func(*usermodels.User) error {
+	return func(u *usermodels.User) error {
+		found, ok := users.users[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*u = found
+		return nil
+	}
+}
+
+func TestAPITokenFromRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/users?api_token=moo_abc", nil)
+	if s := authn.APITokenFromRequest(req); s != "" {
+		t.Error("token in query is accepted:", s)
+	}
+	req.Header.Set(authn.HeaderAPIToken, " moo_abc ")
+	if s := authn.APITokenFromRequest(req); s != "moo_abc" {
+		t.Error(s)
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	now := time.Now()
+	lockedAt := now.Add(-10 * time.Minute)
+
+	for _, test := range []struct {
+		name    string
+		user    usermodels.User
+		expires time.Duration
+		err     error
+	}{
+		{name: "ok", user: usermodels.User{Name: "tom"}},
+		{name: "disabled", user: usermodels.User{Name: "tom", Disabled: true}, err: ErrOwnerDisabled},
+		{name: "locked", user: usermodels.User{Name: "tom", LockedAt: &lockedAt}, err: ErrOwnerLocked},
+		{name: "still locked", user: usermodels.User{Name: "tom", LockedAt: &lockedAt}, expires: time.Hour, err: ErrOwnerLocked},
+		{name: "lock expired", user: usermodels.User{Name: "tom", LockedAt: &lockedAt}, expires: time.Minute},
+		{name: "admin", user: usermodels.User{Name: api.UserAdmin, LockedAt: &lockedAt}},
+	} {
+		if err := checkOwner(&test.user, test.expires, now); err != test.err {
+			t.Error(test.name, "want", test.err, "got", err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	dao := &testDao{tokens: []usermodels.APIToken{
+		{ID: 1, UserID: 1, TokenHash: HashToken("moo_ok")},
+		{ID: 2, UserID: 2, TokenHash: HashToken("moo_disabled")},
+		{ID: 3, UserID: 1, TokenHash: HashToken("moo_revoked"), RevokedAt: &past},
+		{ID: 4, UserID: 1, TokenHash: HashToken("moo_expired"), ExpiresAt: &past},
+		{ID: 5, UserID: 1, TokenHash: HashToken("moo_ip"), IPList: []string{"10.0.0.0/8"}},
+	}}
+	tokens := &Tokens{
+		logger: log.Empty(),
+		dao:    dao,
+		users: &testUsers{users: map[int64]usermodels.User{
+			1: {ID: 1, Name: "tom"},
+			2: {ID: 2, Name: "jerry", Disabled: true},
+		}},
+	}
+
+	for _, test := range []struct {
+		token string
+		err   error
+	}{
+		{token: "moo_ok"},
+		{token: "abc", err: ErrTokenInvalid},
+		{token: "moo_unknown", err: ErrTokenInvalid},
+		{token: "moo_disabled", err: ErrOwnerDisabled},
+		{token: "moo_revoked", err: ErrTokenRevoked},
+		{token: "moo_expired", err: ErrTokenExpired},
+		{token: "moo_ip", err: ErrTokenIPBlocked},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.2:1234"
+		userID, err := tokens.Verify(context.Background(), req, test.token)
+		if err != test.err {
+			t.Error(test.token, "want", test.err, "got", err)
+		} else if err == nil && userID != 1 {
+			t.Error(test.token, "user is", userID)
+		}
+	}
+	if dao.touched != 1 {
+		t.Error("touched", dao.touched)
+	}
+}
+
+func TestList(t *testing.T) {
+	dao := &testDao{}
+	tokens := &Tokens{logger: log.Empty(), dao: dao}
+	ctx := context.Background()
+
+	user := &testUser{id: 1}
+	if _, err := tokens.List(ctx, user, 0, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if !dao.listArg.Valid || dao.listArg.Int64 != 1 {
+		t.Error("list", dao.listArg)
+	}
+	if _, err := tokens.List(ctx, user, 2, false, false); errors.HTTPCode(err) != http.StatusForbidden {
+		t.Error("list other's tokens without permission", err)
+	}
+	if _, err := tokens.List(ctx, user, 0, true, false); errors.HTTPCode(err) != http.StatusForbidden {
+		t.Error("list all tokens without permission", err)
+	}
+
+	admin := &testUser{id: 3, permissions: map[string]bool{PermissionManage: true}}
+	if _, err := tokens.List(ctx, admin, 0, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if dao.listArg.Valid {
+		t.Error("list all", dao.listArg)
+	}
+}
@@ -0,0 +1,111 @@
+package apitokens
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/db"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserAPITokenDisabled, false) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, model db.InModelFactory, opLogger api.OperationLogger) *Tokens {
+			session := model.Factory.SessionReference()
+			return NewTokens(env, usermodels.NewAPITokenDao(session), usermodels.NewUserQueryer(session), opLogger)
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserAPITokenDisabled, false) {
+			return moo.None
+		}
+		return moo.Provide(func(tokens *Tokens) authn.APITokenVerifier {
+			return tokens
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserAPITokenDisabled, false) {
+			return moo.None
+		}
+		return moo.Invoke(func(tokens *Tokens, httpSrv *moo.HTTPServer, logger log.Logger) {
+			mux := httpSrv.Engine().Group("api/api_tokens", httpSrv.AuthMiddlewares())
+			initRoutes(mux, tokens)
+			logger.Info("api tokens started")
+		})
+	})
+}
+
+func initRoutes(mux loong.Party, tokens *Tokens) {
+	list := func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+
+		var userID int64
+		if s := ctx.QueryParam("user_id"); s != "" {
+			userID, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("user_id", s, err), http.StatusBadRequest)
+			}
+		}
+		all := ctx.QueryParam("all") == "true"
+		includeRevoked := ctx.QueryParam("include_revoked") == "true"
+
+		result, err := tokens.List(ctx.StdContext, currentUser, userID, all, includeRevoked)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	}
+	mux.GET("", list)
+	mux.GET("/", list)
+
+	create := func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+
+		var req CreateRequest
+		if err := ctx.Bind(&req); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("body", "", err), http.StatusBadRequest)
+		}
+
+		result, err := tokens.Create(ctx.StdContext, currentUser, &req)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult(result)
+	}
+	mux.POST("", create)
+	mux.POST("/", create)
+
+	mux.DELETE("/:id", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+
+		s := ctx.Param("id")
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", s, err), http.StatusBadRequest)
+		}
+
+		if err := tokens.Revoke(ctx.StdContext, currentUser, id); err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+}
@@ -0,0 +1,185 @@
+package cas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/users/usermodels"
+	gocas "gopkg.in/cas.v2"
+)
+
+// attrRoles 保存在用户属性中的由 CAS 授于的角色，再次登录时只回收这些角色
+const attrRoles = "cas_roles"
+
+// validateTicketP3 用 CAS 3.0 的 /p3/serviceValidate 验证 ticket, 它会返回用户的属性
+func (c *CASClient) validateTicketP3(serviceURL *url.URL, ticket string) (*gocas.AuthenticationResponse, error) {
+	u, err := c.casURL.Parse(path.Join(c.casURL.Path, c.validatePath))
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Add("service", sanitisedURLString(serviceURL))
+	q.Add("ticket", ticket)
+	u.RawQuery = q.Encode()
+
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cas: validate ticket: %v", string(body))
+	}
+	return gocas.ParseServiceResponse(body)
+}
+
+// mapAttributes 按 users.cas.fields. 的配置将 CAS 返回的属性复制到用户属性中, 返回是否有修改
+func (c *CASClient) mapAttributes(user *usermodels.User, attributes gocas.UserAttributes) bool {
+	if attributes == nil {
+		return false
+	}
+	if user.Attributes == nil {
+		user.Attributes = map[string]interface{}{}
+	}
+
+	changed := false
+	for key, field := range c.fields {
+		values := attributes[key]
+		if len(values) == 0 {
+			continue
+		}
+
+		var value interface{} = values[0]
+		if len(values) > 1 {
+			value = values
+		}
+		if old, ok := user.Attributes[field]; ok && reflect.DeepEqual(normalize(old), value) {
+			continue
+		}
+		user.Attributes[field] = value
+		changed = true
+	}
+	return changed
+}
+
+// normalize 将从数据库中读出的 []interface{} 转成 []string 以便比较
+func normalize(value interface{}) interface{} {
+	if list, ok := value.([]interface{}); ok {
+		results := make([]string, 0, len(list))
+		for _, v := range list {
+			results = append(results, as.StringWithDefault(v, ""))
+		}
+		return results
+	}
+	return value
+}
+
+// roleNames 返回 CAS 属性中的角色名
+func (c *CASClient) roleNames(attributes gocas.UserAttributes) []string {
+	if c.roleAttribute == "" || attributes == nil {
+		return nil
+	}
+	return attributes[c.roleAttribute]
+}
+
+// lookupRoles 将角色名转为角色 id, 不存在的角色被忽略
+func (c *CASClient) lookupRoles(ctx context.Context, names []string) ([]string, []int64) {
+	var found []string
+	var idList []int64
+	for _, name := range names {
+		role, err := c.users.GetRoleByName(ctx, name)
+		if err != nil {
+			if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+				c.logger.Warn("查询角色失败", log.String("role", name), log.Error(err))
+			}
+			continue
+		}
+		found = append(found, name)
+		idList = append(idList, role.ID)
+	}
+	return found, idList
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// updateUser 每次登录时用 CAS 返回的属性更新已有用户的字段和角色
+func (c *CASClient) updateUser(ctx context.Context, user *usermodels.User, attributes gocas.UserAttributes) error {
+	if attributes == nil {
+		// CAS 2.0 的服务器不返回属性
+		return nil
+	}
+	changed := c.mapAttributes(user, attributes)
+
+	var addRoles, removeRoles []int64
+	if c.roleAttribute != "" {
+		names, idList := c.lookupRoles(ctx, c.roleNames(attributes))
+		oldNames := normalize(user.Attributes[attrRoles])
+		oldList, _ := oldNames.([]string)
+
+		for idx, name := range names {
+			if !containsString(oldList, name) {
+				addRoles = append(addRoles, idList[idx])
+			}
+		}
+		for _, name := range oldList {
+			if containsString(names, name) {
+				continue
+			}
+			role, err := c.users.GetRoleByName(ctx, name)
+			if err != nil {
+				continue
+			}
+			removeRoles = append(removeRoles, role.ID)
+		}
+		if len(addRoles) > 0 || len(removeRoles) > 0 || user.Attributes[attrRoles] == nil {
+			if names == nil {
+				names = []string{}
+			}
+			user.Attributes[attrRoles] = names
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	// 直接用 UserDao 更新，避免密码被再次加密
+	if _, err := c.users.UserDao.UpdateUser(ctx, user.ID, user); err != nil {
+		return errors.Wrap(err, "更新用户属性失败")
+	}
+	for _, roleID := range addRoles {
+		if err := c.users.UserDao.AddRoleToUser(ctx, user.ID, roleID); err != nil {
+			return errors.Wrap(err, "授于角色失败")
+		}
+	}
+	for _, roleID := range removeRoles {
+		if err := c.users.UserDao.RemoveRoleFromUser(ctx, user.ID, roleID); err != nil {
+			return errors.Wrap(err, "收回角色失败")
+		}
+	}
+	c.logger.Info("用 CAS 属性更新了用户", log.String("username", user.Name),
+		log.Any("add_roles", len(addRoles)), log.Any("remove_roles", len(removeRoles)))
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/runner-mei/errors"
 	"github.com/runner-mei/log"
@@ -36,6 +37,14 @@ type CASOptions struct {
 	Roles      []string
 	UserSyncer UserSyncer
 	Users      *usermodels.Users
+
+	// ValidatePath 验证 ticket 的路径, 为 p3/serviceValidate 时使用 CAS 3.0 协议并返回用户属性,
+	// 为空时使用 CAS 2.0 的 serviceValidate
+	ValidatePath string
+	// RoleAttribute CAS 属性中角色的属性名，为空时不同步角色
+	RoleAttribute string
+	Tickets       TicketStore
+	Terminator    SessionTerminator
 }
 
 // CASClient implements the main protocol
@@ -56,6 +65,12 @@ type CASClient struct {
 	users      *usermodels.Users
 	userSyncer UserSyncer
 
+	casURL        *url.URL
+	validatePath  string
+	roleAttribute string
+	tickets       TicketStore
+	terminator    SessionTerminator
+
 	stValidator *gocas.ServiceTicketValidator
 }
 
@@ -105,6 +120,11 @@ func NewCASClient(options *CASOptions) *CASClient {
 		roles = append(roles, role.ID)
 	}
 
+	tickets := options.Tickets
+	if tickets == nil {
+		tickets = NewMemTicketStore(24 * time.Hour)
+	}
+
 	return &CASClient{
 		logger:         options.Logger,
 		userPrefix:     options.UserPrefix,
@@ -121,6 +141,11 @@ func NewCASClient(options *CASOptions) *CASClient {
 		fields:         options.Fields,
 		users:          options.Users,
 		userSyncer:     options.UserSyncer,
+		casURL:         options.URL,
+		validatePath:   options.ValidatePath,
+		roleAttribute:  options.RoleAttribute,
+		tickets:        tickets,
+		terminator:     options.Terminator,
 	}
 }
 
@@ -274,10 +299,19 @@ func (c *CASClient) validateTicket(ticket string, service *http.Request) (*gocas
 		return nil, err
 	}
 
+	if c.validatePath != "" {
+		return c.validateTicketP3(serviceURL, ticket)
+	}
 	return c.stValidator.ValidateTicket(serviceURL, ticket)
 }
 
 func (c *CASClient) LoginCallback(w http.ResponseWriter, r *http.Request) {
+	// CAS 服务器将单点退出请求发到登录时的 service 地址上
+	if IsLogoutRequest(r) {
+		c.LogoutCallback(w, r)
+		return
+	}
+
 	q := r.URL.Query()
 	ticket := q.Get("ticket")
 	if ticket == "" {
@@ -321,19 +355,16 @@ func (c *CASClient) LoginCallback(w http.ResponseWriter, r *http.Request) {
 				return fmt.Sprintf("%#v", *response)
 			})))
 
-		if response.Attributes != nil {
-			for key, value := range c.fields {
-				values := response.Attributes[key]
-				if len(values) == 0 {
-					continue
-				}
+		c.mapAttributes(user, response.Attributes)
 
-				if len(values) == 1 {
-					user.Attributes[value] = values[0]
-				} else {
-					user.Attributes[value] = values
-				}
+		roles := c.roles
+		if c.roleAttribute != "" && response.Attributes != nil {
+			names, idList := c.lookupRoles(r.Context(), c.roleNames(response.Attributes))
+			if names == nil {
+				names = []string{}
 			}
+			user.Attributes[attrRoles] = names
+			roles = append(append([]int64{}, c.roles...), idList...)
 		}
 
 		if c.userSyncer != nil {
@@ -355,7 +386,7 @@ func (c *CASClient) LoginCallback(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		userid, err := c.users.CreateUser(r.Context(), user, c.roles)
+		userid, err := c.users.CreateUser(r.Context(), user, roles)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, err.Error())
@@ -368,6 +399,8 @@ func (c *CASClient) LoginCallback(w http.ResponseWriter, r *http.Request) {
 		user.ID = userid
 
 		c.logger.Info("新用户登陆，创建新用户成功", log.String("username", username))
+	} else if err := c.updateUser(r.Context(), user, response.Attributes); err != nil {
+		c.logger.Warn("用 CAS 属性更新用户失败", log.String("username", username), log.Error(err))
 	}
 
 	address := authn.RealIP(r)
@@ -380,6 +413,12 @@ func (c *CASClient) LoginCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err == nil && sessionID != "" {
+		if err := c.tickets.Put(r.Context(), ticket, sessionID); err != nil {
+			c.logger.Warn("保存 ticket 和会话的对应关系失败", log.String("username", username), log.Error(err))
+		}
+	}
+
 	redirect := q.Get("redirect")
 
 	c.logger.Info("Login successful, redirecting to system.", log.String("redirect", redirect))
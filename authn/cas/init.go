@@ -33,7 +33,10 @@ type Params struct {
 }
 
 func init() {
-	moo.On(func(*moo.Environment) moo.Option {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.StringWithDefault(api.CfgUserSyncDbFind, "") == "" {
+			return moo.None
+		}
 		return fx.Provide(func(env *moo.Environment, db *db.InModelDB, ologger operation_logs.OperationLogger) (UserSyncer, error) {
 			return CreateUserSyncer(env, db.DB)
 		})
@@ -69,7 +72,6 @@ func init() {
 				Renderer:      params.Renderer,
 				SendService:   true,
 				LoginCallback: urlutil.Join(casPrefix, "login_callback"),
-				// LogoutCallback: urlutil.Join(casPrefix, "logout_callback"),
 				IgnoreList: []string{
 					urlutil.Join(casPrefix, "login"),
 					urlutil.Join(casPrefix, "logout"),
@@ -78,6 +80,10 @@ func init() {
 				Fields:     fields,
 				Users:      params.Users,
 				UserSyncer: params.UserSyncer,
+
+				ValidatePath:  env.Config.StringWithDefault(api.CfgUserCasValidatePath, "p3/serviceValidate"),
+				RoleAttribute: env.Config.StringWithDefault(api.CfgUserCasRoleAttribute, ""),
+				Terminator:    params.LoginManager,
 			}
 			casClient := NewCASClient(authOpts)
 
@@ -105,7 +111,8 @@ func init() {
 			}))
 
 			ssoEcho.GET("/login_callback", loong.WrapHandlerFunc(casClient.LoginCallback))
-			// ssoEcho.GET(urlutil.Join(casPrefix, "logout_callback"), loong.WrapHandlerFunc(casClient.LogoutCallback))
+			ssoEcho.POST("/login_callback", loong.WrapHandlerFunc(casClient.LoginCallback))
+			ssoEcho.POST("/logout_callback", loong.WrapHandlerFunc(casClient.LogoutCallback))
 			logger.Info("cas started")
 			return nil
 		})
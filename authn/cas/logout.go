@@ -0,0 +1,147 @@
+package cas
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+)
+
+// SessionTerminator 终止一个会话, authn.LoginManager 实现了它
+type SessionTerminator interface {
+	Terminate(ctx context.Context, sessionID, reason, operator string) error
+}
+
+// TicketStore 保存 service ticket 到会话的映射，CAS 发出单点退出请求时用它找到对应的会话
+type TicketStore interface {
+	Put(ctx context.Context, ticket, sessionID string) error
+	Take(ctx context.Context, ticket string) (string, error)
+}
+
+type ticketEntry struct {
+	sessionID string
+	expiredAt time.Time
+}
+
+type memTicketStore struct {
+	lock    sync.Mutex
+	expires time.Duration
+	tickets map[string]ticketEntry
+}
+
+// NewMemTicketStore 创建一个内存中的 TicketStore, 超过 expires 的映射会被清除
+func NewMemTicketStore(expires time.Duration) TicketStore {
+	return &memTicketStore{
+		expires: expires,
+		tickets: map[string]ticketEntry{},
+	}
+}
+
+func (store *memTicketStore) Put(ctx context.Context, ticket, sessionID string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	for key, entry := range store.tickets {
+		if now.After(entry.expiredAt) {
+			delete(store.tickets, key)
+		}
+	}
+	store.tickets[ticket] = ticketEntry{sessionID: sessionID, expiredAt: now.Add(store.expires)}
+	return nil
+}
+
+func (store *memTicketStore) Take(ctx context.Context, ticket string) (string, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	entry, ok := store.tickets[ticket]
+	if !ok {
+		return "", nil
+	}
+	delete(store.tickets, ticket)
+	if time.Now().After(entry.expiredAt) {
+		return "", nil
+	}
+	return entry.sessionID, nil
+}
+
+// logoutRequest CAS 发出的 SAML 退出请求, 如
+//
+//	<samlp:LogoutRequest ID="..." Version="2.0" IssueInstant="...">
+//	  <saml:NameID>@NOT_USED@</saml:NameID>
+//	  <samlp:SessionIndex>ST-1-xxxx</samlp:SessionIndex>
+//	</samlp:LogoutRequest>
+type logoutRequest struct {
+	XMLName      xml.Name `xml:"LogoutRequest"`
+	ID           string   `xml:"ID,attr"`
+	NameID       string   `xml:"NameID"`
+	SessionIndex string   `xml:"SessionIndex"`
+}
+
+func parseLogoutRequest(s string) (*logoutRequest, error) {
+	var req logoutRequest
+	if err := xml.Unmarshal([]byte(s), &req); err != nil {
+		return nil, errors.Wrap(err, "logoutRequest 格式不正确")
+	}
+	req.SessionIndex = strings.TrimSpace(req.SessionIndex)
+	if req.SessionIndex == "" {
+		return nil, errors.New("logoutRequest 中没有 SessionIndex")
+	}
+	return &req, nil
+}
+
+// IsLogoutRequest 判断是否是 CAS 服务器发来的单点退出请求
+func IsLogoutRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.FormValue("logoutRequest") != ""
+}
+
+// LogoutCallback 处理 CAS 服务器发来的单点退出请求(back-channel), 终止用该 ticket 登录的会话
+func (c *CASClient) LogoutCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parseLogoutRequest(r.FormValue("logoutRequest"))
+	if err != nil {
+		c.logger.Warn("收到不正确的单点退出请求", log.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	sessionID, err := c.tickets.Take(r.Context(), req.SessionIndex)
+	if err != nil {
+		c.logger.Warn("查询 ticket 对应的会话失败", log.String("ticket", req.SessionIndex), log.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	if sessionID == "" {
+		c.logger.Info("单点退出请求的 ticket 没有对应的会话", log.String("ticket", req.SessionIndex))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if c.terminator != nil {
+		err = c.terminator.Terminate(r.Context(), sessionID, "cas_logout", "")
+	} else {
+		err = c.sessions.Logout(r.Context(), sessionID)
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		c.logger.Warn("单点退出时终止会话失败", log.String("ticket", req.SessionIndex), log.String("session", sessionID), log.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	c.logger.Info("单点退出成功", log.String("ticket", req.SessionIndex), log.String("session", sessionID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,44 @@
+package cas
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLogoutRequest(t *testing.T) {
+	req, err := parseLogoutRequest(`<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"
+ xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="LR-1" Version="2.0" IssueInstant="2020-01-01T00:00:00Z">
+  <saml:NameID>@NOT_USED@</saml:NameID>
+  <samlp:SessionIndex> ST-1-abc </samlp:SessionIndex>
+</samlp:LogoutRequest>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != "LR-1" || req.SessionIndex != "ST-1-abc" {
+		t.Error("got", req.ID, req.SessionIndex)
+	}
+
+	if _, err := parseLogoutRequest(`<samlp:LogoutRequest ID="LR-2"></samlp:LogoutRequest>`); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestMemTicketStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemTicketStore(time.Minute)
+	store.Put(ctx, "ST-1", "session-1")
+
+	if sessionID, _ := store.Take(ctx, "ST-1"); sessionID != "session-1" {
+		t.Error("want session-1 got", sessionID)
+	}
+	if sessionID, _ := store.Take(ctx, "ST-1"); sessionID != "" {
+		t.Error("ticket should be removed after take, got", sessionID)
+	}
+
+	store = NewMemTicketStore(-time.Minute)
+	store.Put(ctx, "ST-2", "session-2")
+	if sessionID, _ := store.Take(ctx, "ST-2"); sessionID != "" {
+		t.Error("ticket should be expired, got", sessionID)
+	}
+}
@@ -10,9 +10,8 @@ import (
 	"github.com/runner-mei/moo/api"
 )
 
-type UserSyncer interface {
-	Read(ctx context.Context, id string) (string, map[string]string, error)
-}
+// UserSyncer 与 api.UserSyncer 相同, users/provision 也提供了它的实现
+type UserSyncer = api.UserSyncer
 
 func CreateUserSyncer(env *moo.Environment, conn *sql.DB) (UserSyncer, error) {
 	find := env.Config.StringWithDefault(api.CfgUserSyncDbFind, "")
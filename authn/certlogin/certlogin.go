@@ -0,0 +1,517 @@
+package certlogin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// PermissionManage 管理其它用户绑定的证书的权限
+const PermissionManage = "um.users.certificates"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionManage, Title: "管理用户的客户端证书", Group: "用户管理"})
+}
+
+var (
+	ErrCertificateMissing  = errors.NewError(http.StatusUnauthorized, "没有出示客户端证书")
+	ErrCertificateInvalid  = errors.NewError(http.StatusUnauthorized, "客户端证书不是由信任的 CA 签发的")
+	ErrCertificateRevoked  = errors.NewError(http.StatusUnauthorized, "客户端证书已被吊销")
+	ErrCertificateNotBound = errors.NewError(http.StatusUnauthorized, "客户端证书没有绑定到用户")
+	ErrCertificateRequired = errors.NewError(http.StatusUnauthorized, "必须出示绑定到该用户的客户端证书")
+	ErrUsernameNotFound    = errors.NewError(http.StatusUnauthorized, "无法从客户端证书中取得用户名")
+	ErrUserDisabled        = errors.NewError(http.StatusUnauthorized, "用户已被禁用")
+	ErrCertificateBound    = errors.NewError(http.StatusConflict, "证书已经绑定到了用户")
+	ErrCertificateNotFound = errors.ErrNotFoundWithText("证书不存在!")
+	ErrPermissionDenny     = errors.NewError(http.StatusForbidden, "没有管理其它用户证书的权限")
+)
+
+// Fingerprint 证书的 SHA-256 指纹
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseCertificate 解析 PEM 格式的证书
+func ParseCertificate(s string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.NewError(http.StatusBadRequest, "证书不是 PEM 格式")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.WithHTTPCode(errors.Wrap(err, "解析证书失败"), http.StatusBadRequest)
+	}
+	return cert, nil
+}
+
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "读 CA 文件失败")
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "解析 CA 文件失败")
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("CA 文件 '" + filename + "' 中没有证书")
+	}
+	return certs, nil
+}
+
+// CertLogin 用 https 的客户端证书登录
+type CertLogin struct {
+	logger         log.Logger
+	renderer       *authn.Renderer
+	sessions       authn.Sessions
+	users          *usermodels.Users
+	certs          usermodels.UserCertificateDao
+	opLogger       api.OperationLogger
+	roots          *x509.CertPool
+	crl            *crlChecker
+	rule           *Rule
+	requireBinding bool
+	secondFactor   bool
+	redirectURL    string
+}
+
+func NewCertLogin(env *moo.Environment,
+	renderer *authn.Renderer,
+	sessions authn.Sessions,
+	users *usermodels.Users,
+	certs usermodels.UserCertificateDao,
+	opLogger api.OperationLogger) (*CertLogin, error) {
+	logger := env.Logger.Named("certlogin")
+
+	caFile := env.Config.StringWithDefault(api.CfgUserCertLoginCAFile, "")
+	if caFile == "" {
+		return nil, errors.New("'" + api.CfgUserCertLoginCAFile + "' 没有配置")
+	}
+	if !filepath.IsAbs(caFile) {
+		caFile = env.Fs.FromConfig(caFile)
+	}
+	cas, err := readCertificates(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+
+	var crl *crlChecker
+	if crlFile := env.Config.StringWithDefault(api.CfgUserCertLoginCRLFile, ""); crlFile != "" {
+		if !filepath.IsAbs(crlFile) {
+			crlFile = env.Fs.FromConfig(crlFile)
+		}
+		crl, err = newCRLChecker(logger, crlFile, cas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rule, err := NewRule(env.Config.StringsWithDefault(api.CfgUserCertLoginUsernameFields, nil),
+		env.Config.StringWithDefault(api.CfgUserCertLoginUsernamePattern, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURL := env.Config.StringWithDefault(api.CfgUserRedirectTo, "")
+	if redirectURL != "" {
+		redirectURL = strings.Replace(redirectURL, "\\$\\{appRoot}", env.DaemonUrlPath, -1)
+		redirectURL = strings.Replace(redirectURL, "${appRoot}", env.DaemonUrlPath, -1)
+	}
+
+	return &CertLogin{
+		logger:         logger,
+		renderer:       renderer,
+		sessions:       sessions,
+		users:          users,
+		certs:          certs,
+		opLogger:       opLogger,
+		roots:          roots,
+		crl:            crl,
+		rule:           rule,
+		requireBinding: env.Config.BoolWithDefault(api.CfgUserCertLoginRequireBinding, false),
+		secondFactor:   env.Config.BoolWithDefault(api.CfgUserCertLoginSecondFactor, false),
+		redirectURL:    redirectURL,
+	}, nil
+}
+
+// TLSConfig 让 https 在握手时请求客户端证书, 没有出示证书的客户端仍然可以用其它方式登录
+func (c *CertLogin) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  c.roots,
+	}
+}
+
+// Verify 检查证书是否由信任的 CA 签发, 是否在有效期内, 是否被吊销
+func (c *CertLogin) Verify(cert *x509.Certificate, intermediates []*x509.Certificate) error {
+	opts := x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, intermediate := range intermediates {
+		opts.Intermediates.AddCert(intermediate)
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		c.logger.Info("客户端证书校验失败", log.String("subject", cert.Subject.String()), log.Error(err))
+		return ErrCertificateInvalid
+	}
+	if c.crl != nil && c.crl.IsRevoked(cert) {
+		return ErrCertificateRevoked
+	}
+	return nil
+}
+
+func (c *CertLogin) binding(ctx context.Context, cert *x509.Certificate) (*usermodels.UserCertificate, error) {
+	var record usermodels.UserCertificate
+	err := c.certs.GetByFingerprint(ctx, Fingerprint(cert))(&record)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "查询证书失败")
+	}
+	return &record, nil
+}
+
+// Authenticate 返回证书对应的用户, 证书绑定了用户时用绑定的用户, 否则按规则从证书中取用户名
+func (c *CertLogin) Authenticate(ctx context.Context, chain []*x509.Certificate) (*usermodels.User, error) {
+	if len(chain) == 0 {
+		return nil, ErrCertificateMissing
+	}
+	cert := chain[0]
+	if err := c.Verify(cert, chain[1:]); err != nil {
+		return nil, err
+	}
+
+	record, err := c.binding(ctx, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *usermodels.User
+	if record != nil {
+		user, err = c.users.GetUserByID(ctx, record.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.certs.Touch(ctx, record.ID); err != nil {
+			c.logger.Warn("更新证书的使用时间失败", log.Error(err))
+		}
+	} else {
+		if c.requireBinding {
+			return nil, ErrCertificateNotBound
+		}
+
+		username, err := c.rule.Username(cert)
+		if err != nil {
+			return nil, err
+		}
+		if username == "" {
+			return nil, ErrUsernameNotFound
+		}
+		user, err = c.users.GetUserByName(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if user.IsDisabled() {
+		return user, ErrUserDisabled
+	}
+	return user, nil
+}
+
+func (c *CertLogin) logLogin(ctx context.Context, user *usermodels.User, cert *x509.Certificate, address string, err error) {
+	ol := &api.OperationLog{
+		UserID:     user.ID,
+		Username:   user.Name,
+		Type:       "cert_login",
+		Successful: err == nil,
+		Content:    "用户 '" + user.Name + "' 从 " + address + " 用证书 '" + cert.Subject.String() + "' 登录",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+		},
+	}
+	if err != nil {
+		ol.Content = ol.Content + "失败: " + err.Error()
+	}
+	if e := c.opLogger.LogRecord(ctx, ol); e != nil {
+		c.logger.Warn("添加操作日志失败", log.Error(e))
+	}
+}
+
+func (c *CertLogin) Login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	renderError := func(statusCode int, err string) {
+		if c.redirectURL == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(statusCode)
+			io.WriteString(w, err)
+			return
+		}
+
+		http.Redirect(w, r, c.redirectURL+"?message="+url.QueryEscape(err), http.StatusTemporaryRedirect)
+	}
+
+	var chain []*x509.Certificate
+	if r.TLS != nil {
+		chain = r.TLS.PeerCertificates
+	}
+	address := authn.RealIP(r)
+
+	user, err := c.Authenticate(ctx, chain)
+	if user != nil {
+		c.logLogin(ctx, user, chain[0], address, err)
+	}
+	if err != nil {
+		c.logger.Info("证书登录失败", log.String("address", address), log.Error(err))
+		if errors.IsNotFound(err) {
+			renderError(http.StatusUnauthorized, "用户信息没找到，请添加这个用户： "+err.Error())
+			return
+		}
+		renderError(errors.HTTPCode(err), err.Error())
+		return
+	}
+
+	sessionID, err := c.sessions.Login(ctx, user.ID, user.Name, address)
+	if err != nil && errors.IsNotFound(err) {
+		c.logger.Info("创建在线用户信息失败", log.Error(err))
+
+		renderError(http.StatusUnauthorized, "创建在线用户信息失败： "+err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	redirect := q.Get("redirect")
+	if redirect == "" {
+		redirect = q.Get("service")
+		if redirect == "" {
+			redirect = q.Get("returnTo")
+		}
+	}
+	authCtx := &services.AuthContext{
+		Ctx:    ctx,
+		Logger: c.logger,
+		Request: services.LoginRequest{
+			UserID:   user.ID,
+			Username: user.Name,
+			Service:  redirect,
+			Address:  address,
+		},
+		Response: services.LoginResult{
+			IsOK:      true,
+			SessionID: sessionID,
+			IsNewUser: false,
+		},
+	}
+
+	c.renderer.LoginOK(authCtx, w, r)
+}
+
+func canManage(ctx context.Context, currentUser api.User, userID int64) error {
+	if userID == currentUser.ID() {
+		return nil
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionManage)
+	if err != nil {
+		return errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return ErrPermissionDenny
+	}
+	return nil
+}
+
+// Bind 将证书绑定到用户
+func (c *CertLogin) Bind(ctx context.Context, currentUser api.User, userID int64, chain []*x509.Certificate) (*usermodels.UserCertificate, error) {
+	if userID == 0 {
+		userID = currentUser.ID()
+	}
+	if err := canManage(ctx, currentUser, userID); err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, ErrCertificateMissing
+	}
+	cert := chain[0]
+	if err := c.Verify(cert, chain[1:]); err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusBadRequest)
+	}
+
+	user, err := c.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := c.binding(ctx, cert)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		return nil, ErrCertificateBound
+	}
+
+	record := &usermodels.UserCertificate{
+		UserID:       userID,
+		Fingerprint:  Fingerprint(cert),
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		NotAfter:     cert.NotAfter,
+	}
+	record.ID, err = c.certs.Create(ctx, record)
+	if err != nil {
+		return nil, errors.Wrap(err, "绑定证书失败")
+	}
+
+	if err := c.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "bind_certificate",
+		Successful: true,
+		Content:    "给用户 '" + user.Name + "' 绑定证书 '" + record.Subject + "'",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+			Records: []api.ChangeRecord{
+				{Name: "fingerprint", NewValue: record.Fingerprint},
+			},
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "添加操作日志失败")
+	}
+	return record, nil
+}
+
+// List 列出用户绑定的证书
+func (c *CertLogin) List(ctx context.Context, currentUser api.User, userID int64) ([]usermodels.UserCertificate, error) {
+	if userID == 0 {
+		userID = currentUser.ID()
+	}
+	if err := canManage(ctx, currentUser, userID); err != nil {
+		return nil, err
+	}
+	return c.certs.ListByUserID(ctx, userID)
+}
+
+// Unbind 解除证书的绑定
+func (c *CertLogin) Unbind(ctx context.Context, currentUser api.User, id int64) error {
+	var record usermodels.UserCertificate
+	err := c.certs.GetByID(ctx, id)(&record)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return ErrCertificateNotFound
+		}
+		return errors.Wrap(err, "查询证书失败")
+	}
+	if err := canManage(ctx, currentUser, record.UserID); err != nil {
+		return err
+	}
+
+	if _, err := c.certs.DeleteByID(ctx, id); err != nil {
+		return errors.Wrap(err, "解除证书的绑定失败")
+	}
+
+	if err := c.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "unbind_certificate",
+		Successful: true,
+		Content:    "解除用户证书 '" + record.Subject + "' 的绑定",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   record.UserID,
+			Records: []api.ChangeRecord{
+				{Name: "fingerprint", OldValue: record.Fingerprint},
+			},
+		},
+	}); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
+
+// SecondFactor 绑定了证书的用户用密码登录时, 必须同时出示其中一个证书
+func (c *CertLogin) SecondFactor() services.AuthOption {
+	return services.AuthOptionFunc(func(auth *services.AuthService) error {
+		auth.OnAfterAuth(services.AuthFunc(func(ctx *services.AuthContext) error {
+			if !ctx.Response.IsOK {
+				return nil
+			}
+			userID, ok := ctx.Request.UserID.(int64)
+			if !ok {
+				return nil
+			}
+
+			count, err := c.certs.CountByUserID(ctx.Ctx, userID)
+			if err != nil {
+				return errors.Wrap(err, "查询用户绑定的证书失败")
+			}
+			if count == 0 {
+				return nil
+			}
+
+			cert := ctx.Request.ClientCertificate
+			if cert == nil {
+				return ErrCertificateRequired
+			}
+			record, err := c.binding(ctx.Ctx, cert)
+			if err != nil {
+				return err
+			}
+			if record == nil || record.UserID != userID {
+				return ErrCertificateRequired
+			}
+			if time.Now().After(cert.NotAfter) {
+				return ErrCertificateInvalid
+			}
+			if c.crl != nil && c.crl.IsRevoked(cert) {
+				return ErrCertificateRevoked
+			}
+			if err := c.certs.Touch(ctx.Ctx, record.ID); err != nil {
+				c.logger.Warn("更新证书的使用时间失败", log.Error(err))
+			}
+			return nil
+		}))
+		return nil
+	})
+}
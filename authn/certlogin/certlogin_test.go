@@ -0,0 +1,140 @@
+package certlogin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func upnExtension(t *testing.T, upn string) pkix.Extension {
+	value, err := asn1.MarshalWithParams(upn, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := asn1.MarshalWithParams(struct {
+		TypeID asn1.ObjectIdentifier
+		Value  asn1.RawValue
+	}{oidUPN, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value}}, "tag:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte("tom@mail.example.com")}
+	san, err := asn1.Marshal([]asn1.RawValue{{FullBytes: other}, email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: san}
+}
+
+func createCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestRule(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.com/users/jerry")
+	cert, _ := createCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			CommonName: "Tom Cat",
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidUID, Value: "tom"}},
+		},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{upnExtension(t, "tom@corp.example.com")},
+	}, nil, nil)
+	cert.URIs = []*url.URL{u}
+
+	for _, test := range []struct {
+		fields   []string
+		pattern  string
+		excepted string
+	}{
+		{nil, "", "tom@corp.example.com"},
+		{[]string{"san.upn"}, `^([^@]+)@corp\.example\.com$`, "tom"},
+		{[]string{"san.email"}, "", "tom@mail.example.com"},
+		{[]string{"subject.uid"}, "", "tom"},
+		{[]string{"subject.serial_number", "subject.cn"}, "", "Tom Cat"},
+		{[]string{"san.upn", "san.uri"}, `^spiffe://example\.com/users/(.+)$`, "jerry"},
+		{[]string{"san.dns"}, "", ""},
+	} {
+		rule, err := NewRule(test.fields, test.pattern)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		username, err := rule.Username(cert)
+		if err != nil {
+			t.Error(test.fields, err)
+			continue
+		}
+		if username != test.excepted {
+			t.Error(test.fields, "want", test.excepted, "got", username)
+		}
+	}
+
+	if _, err := NewRule([]string{"subject.abc"}, ""); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestParseCRL(t *testing.T) {
+	ca, caKey := createCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+	other, _ := createCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+
+	crl, err := ca.CreateCRL(rand.Reader, caKey, []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(12), RevocationTime: time.Now()},
+	}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := parseCRL(crl, []*x509.Certificate{ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := revoked["12"]; !ok || len(revoked) != 1 {
+		t.Error("got", revoked)
+	}
+
+	if _, err := parseCRL(crl, []*x509.Certificate{other}); err == nil {
+		t.Error("want error got ok")
+	}
+}
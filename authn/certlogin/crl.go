@@ -0,0 +1,97 @@
+package certlogin
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+)
+
+// crlChecker 按 CRL 检查证书是否被吊销, CRL 文件被修改后自动重新加载
+type crlChecker struct {
+	logger   log.Logger
+	filename string
+	issuers  []*x509.Certificate
+
+	mu      sync.Mutex
+	modTime time.Time
+	revoked map[string]struct{}
+}
+
+func newCRLChecker(logger log.Logger, filename string, issuers []*x509.Certificate) (*crlChecker, error) {
+	c := &crlChecker{
+		logger:   logger,
+		filename: filename,
+		issuers:  issuers,
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *crlChecker) reload() error {
+	st, err := os.Stat(c.filename)
+	if err != nil {
+		return errors.Wrap(err, "读 CRL 文件失败")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.modTime.IsZero() && st.ModTime().Equal(c.modTime) {
+		return nil
+	}
+
+	bs, err := ioutil.ReadFile(c.filename)
+	if err != nil {
+		return errors.Wrap(err, "读 CRL 文件失败")
+	}
+	revoked, err := parseCRL(bs, c.issuers)
+	if err != nil {
+		return err
+	}
+	c.revoked = revoked
+	c.modTime = st.ModTime()
+	return nil
+}
+
+// parseCRL 解析 CRL(PEM 或 DER 格式), 它必须是由 issuers 中的某个 CA 签发的
+func parseCRL(bs []byte, issuers []*x509.Certificate) (map[string]struct{}, error) {
+	crl, err := x509.ParseCRL(bs)
+	if err != nil {
+		return nil, errors.Wrap(err, "解析 CRL 文件失败")
+	}
+
+	verified := false
+	for _, issuer := range issuers {
+		if issuer.CheckCRLSignature(crl) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("CRL 不是由信任的 CA 签发的")
+	}
+
+	revoked := map[string]struct{}{}
+	for _, cert := range crl.TBSCertList.RevokedCertificates {
+		revoked[cert.SerialNumber.String()] = struct{}{}
+	}
+	return revoked, nil
+}
+
+// IsRevoked 证书是否已被吊销, CRL 重新加载失败时继续使用旧的 CRL
+func (c *crlChecker) IsRevoked(cert *x509.Certificate) bool {
+	if err := c.reload(); err != nil {
+		c.logger.Warn("重新加载 CRL 失败", log.String("filename", c.filename), log.Error(err))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.revoked[cert.SerialNumber.String()]
+	return ok
+}
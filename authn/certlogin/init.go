@@ -0,0 +1,180 @@
+package certlogin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/db"
+	"github.com/runner-mei/moo/users/usermodels"
+	"go.uber.org/fx"
+)
+
+type Params struct {
+	fx.In
+
+	Renderer *authn.Renderer
+	Sessions authn.Sessions
+	Users    *usermodels.Users
+	OpLogger api.OperationLogger
+	Model    db.InModelFactory
+}
+
+type OutHTTPSConfig struct {
+	moo.Out
+
+	TLSConfig *tls.Config `name:"https-tls-config"`
+}
+
+// BindRequest 绑定证书的参数, Certificate 为空时绑定当前连接出示的客户端证书
+type BindRequest struct {
+	UserID      int64  `json:"user_id,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+func isEnabled(env *moo.Environment) bool {
+	return env.Config.BoolWithDefault(api.CfgUserCertLoginEnabled, false)
+}
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, params Params) (*CertLogin, error) {
+			return NewCertLogin(env, params.Renderer, params.Sessions, params.Users,
+				usermodels.NewUserCertificateDao(params.Model.Factory.SessionReference()), params.OpLogger)
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Provide(func(certLogin *CertLogin) OutHTTPSConfig {
+			return OutHTTPSConfig{TLSConfig: certLogin.TLSConfig()}
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) || !env.Config.BoolWithDefault(api.CfgUserCertLoginSecondFactor, false) {
+			return moo.None
+		}
+		return moo.Provide(func(certLogin *CertLogin) services.OutAuthOption {
+			return services.OutAuthOption{Opt: certLogin.SecondFactor()}
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Invoke(func(certLogin *CertLogin, httpSrv *moo.HTTPServer, logger log.Logger) {
+			httpSrv.FastRoute(false, "certlogin", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				certLogin.Login(r.Context(), w, r)
+			}))
+
+			mux := httpSrv.Engine().Group("api/users/certificates", httpSrv.AuthMiddlewares())
+			initRoutes(mux, certLogin)
+			logger.Info("certlogin started")
+		})
+	})
+}
+
+func queryUserID(ctx *loong.Context) (int64, error) {
+	s := ctx.QueryParam("user_id")
+	if s == "" {
+		return 0, nil
+	}
+	userID, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, loong.ErrBadArgument("user_id", s, err)
+	}
+	return userID, nil
+}
+
+func initRoutes(mux loong.Party, certLogin *CertLogin) {
+	list := func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		userID, err := queryUserID(ctx)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusBadRequest)
+		}
+
+		result, err := certLogin.List(ctx.StdContext, currentUser, userID)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	}
+	mux.GET("", list)
+	mux.GET("/", list)
+
+	bind := loong.WrapContextHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		currentUser, err := api.ReadUserFromContext(ctx)
+		if err != nil {
+			authn.ReturnError(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var req BindRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				authn.ReturnError(w, r, "请求参数不正确: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var chain []*x509.Certificate
+		if req.Certificate != "" {
+			cert, err := ParseCertificate(req.Certificate)
+			if err != nil {
+				authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+				return
+			}
+			chain = []*x509.Certificate{cert}
+		} else if r.TLS != nil {
+			chain = r.TLS.PeerCertificates
+		}
+
+		result, err := certLogin.Bind(ctx, currentUser, req.UserID, chain)
+		if err != nil {
+			authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+			return
+		}
+		authn.ReturnJSON(w, r, result, http.StatusCreated)
+	})
+	mux.POST("", bind)
+	mux.POST("/", bind)
+
+	mux.DELETE("/:id", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+
+		s := ctx.Param("id")
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", s, err), http.StatusBadRequest)
+		}
+
+		if err := certLogin.Unbind(ctx.StdContext, currentUser, id); err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+}
@@ -0,0 +1,195 @@
+package certlogin
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"regexp"
+	"strings"
+
+	"github.com/runner-mei/errors"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUPN            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+	oidUID            = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+	oidEmailAddress   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+)
+
+// Fields 支持的证书字段
+var Fields = []string{
+	"subject.cn",
+	"subject.uid",
+	"subject.email",
+	"subject.serial_number",
+	"san.upn",
+	"san.email",
+	"san.dns",
+	"san.uri",
+}
+
+// Rule 从证书中取用户名的规则, 按顺序取 Fields 中的字段, 第一个非空并且和 Pattern 匹配的值就是用户名
+type Rule struct {
+	Fields  []string
+	Pattern *regexp.Regexp
+}
+
+// NewRule 创建规则, fields 为空时默认为 san.upn,san.email,subject.cn
+func NewRule(fields []string, pattern string) (*Rule, error) {
+	if len(fields) == 0 {
+		fields = []string{"san.upn", "san.email", "subject.cn"}
+	}
+
+	rule := &Rule{}
+	for _, field := range fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+		if !containsString(Fields, field) {
+			return nil, errors.New("证书字段 '" + field + "' 不支持, 只支持 " + strings.Join(Fields, ", "))
+		}
+		rule.Fields = append(rule.Fields, field)
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "证书用户名的正则表达式不正确")
+		}
+		rule.Pattern = re
+	}
+	return rule, nil
+}
+
+// Username 返回证书对应的用户名, 没有找到时返回空字符串
+func (rule *Rule) Username(cert *x509.Certificate) (string, error) {
+	for _, field := range rule.Fields {
+		values, err := FieldValues(cert, field)
+		if err != nil {
+			return "", err
+		}
+		for _, value := range values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if rule.Pattern == nil {
+				return value, nil
+			}
+
+			matches := rule.Pattern.FindStringSubmatch(value)
+			if matches == nil {
+				continue
+			}
+			if len(matches) > 1 {
+				if matches[1] != "" {
+					return matches[1], nil
+				}
+				continue
+			}
+			return matches[0], nil
+		}
+	}
+	return "", nil
+}
+
+// FieldValues 返回证书中指定字段的值
+func FieldValues(cert *x509.Certificate, field string) ([]string, error) {
+	switch field {
+	case "subject.cn":
+		if cert.Subject.CommonName == "" {
+			return nil, nil
+		}
+		return []string{cert.Subject.CommonName}, nil
+	case "subject.uid":
+		return subjectValues(cert, oidUID), nil
+	case "subject.email":
+		return subjectValues(cert, oidEmailAddress), nil
+	case "subject.serial_number":
+		if cert.Subject.SerialNumber == "" {
+			return nil, nil
+		}
+		return []string{cert.Subject.SerialNumber}, nil
+	case "san.upn":
+		return upnValues(cert)
+	case "san.email":
+		return cert.EmailAddresses, nil
+	case "san.dns":
+		return cert.DNSNames, nil
+	case "san.uri":
+		var values []string
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+		return values, nil
+	}
+	return nil, errors.New("证书字段 '" + field + "' 不支持")
+}
+
+func subjectValues(cert *x509.Certificate, oid asn1.ObjectIdentifier) []string {
+	var values []string
+	for _, name := range cert.Subject.Names {
+		if !name.Type.Equal(oid) {
+			continue
+		}
+		if s, ok := name.Value.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// upnValues 从 SubjectAltName 的 otherName 中读取微软的 UPN(userPrincipalName)
+func upnValues(cert *x509.Certificate) ([]string, error) {
+	var values []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+			return nil, errors.Wrap(err, "解析证书的 SubjectAltName 失败")
+		}
+		rest := seq.Bytes
+		for len(rest) > 0 {
+			var name asn1.RawValue
+			var err error
+			rest, err = asn1.Unmarshal(rest, &name)
+			if err != nil {
+				return nil, errors.Wrap(err, "解析证书的 SubjectAltName 失败")
+			}
+			// otherName [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+
+			var other struct {
+				TypeID asn1.ObjectIdentifier
+				// Value 是 [0] EXPLICIT 的外层, Bytes 中才是 UPN 的值
+				Value asn1.RawValue
+			}
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil {
+				return nil, errors.Wrap(err, "解析证书的 otherName 失败")
+			}
+			if !other.TypeID.Equal(oidUPN) {
+				continue
+			}
+			var upn string
+			if _, err := asn1.Unmarshal(other.Value.Bytes, &upn); err != nil {
+				return nil, errors.Wrap(err, "解析证书的 UPN 失败")
+			}
+			values = append(values, upn)
+		}
+	}
+	return values, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/runner-mei/log"
+)
+
+// NewLoginManagerForTest 创建只用于检查会话的 LoginManager, 供 authn_test 包中的测试使用
+func NewLoginManagerForTest(cfg *Config, online Sessions) *LoginManager {
+	return &LoginManager{logger: log.Empty(), cfg: cfg, online: online}
+}
+
+func (mgr *LoginManager) ValidateSessionCookie(ctx context.Context, req *http.Request) (context.Context, error) {
+	return mgr.validateSessionCookie(ctx, req)
+}
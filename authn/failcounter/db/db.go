@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn/services"
+	moodb "github.com/runner-mei/moo/db"
+	"github.com/runner-mei/moo/users/usermodels"
+	"go.uber.org/fx"
+)
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return fx.Provide(func(lifecycle fx.Lifecycle, env *moo.Environment, model moodb.InModelFactory, bus *moo.Bus, logger log.Logger) services.FailCounter {
+			ref := model.Factory.SessionReference()
+			counter := NewFailCounter(env, usermodels.NewLoginFailureDao(ref), logger)
+			unlocker := &autoUnlocker{
+				logger:  counter.logger,
+				users:   usermodels.NewUserDao(ref, usermodels.NewUserQueryer(ref)),
+				bus:     bus,
+				expires: env.Config.DurationWithDefault(api.CfgUserLockedTimeExpiresKey, 0),
+			}
+			bus.RegisterTopics(api.BusUserUnlocked)
+
+			var timer util.Timer
+
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					timer.Start(env.Config.DurationWithDefault(api.CfgUserLoginFailCheckInterval, 60*time.Second),
+						func() bool {
+							ctx := context.Background()
+							if err := counter.DeleteExpired(ctx); err != nil {
+								counter.logger.Warn("删除过期的登录失败记录失败", log.Error(err))
+							}
+							unlocker.UnlockExpired(ctx)
+							return true
+						})
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					timer.Stop()
+					return nil
+				},
+			})
+			return counter
+		})
+	})
+}
+
+// FailCounter 将登录失败记录保存在 moo_login_failures 表中，多个节点共用一个数据库时失败次数是共享的
+type FailCounter struct {
+	logger   log.Logger
+	dao      usermodels.LoginFailureDao
+	interval string
+}
+
+var _ services.FailCounter = &FailCounter{}
+
+func NewFailCounter(env *moo.Environment, dao usermodels.LoginFailureDao, logger log.Logger) *FailCounter {
+	return &FailCounter{
+		logger:   logger.Named("login_failures"),
+		dao:      dao,
+		interval: toInterval(env.Config.DurationWithDefault(api.CfgUserLoginFailWindow, 30*time.Minute)),
+	}
+}
+
+func toInterval(window time.Duration) string {
+	if window <= 0 {
+		// 为 0 时失败次数不会过期
+		return "100 YEAR"
+	}
+	return strconv.FormatInt(int64(window/time.Second), 10) + " SECOND"
+}
+
+func toFailRecord(stat *usermodels.LoginFailureStat) services.FailRecord {
+	return services.FailRecord{
+		Kind:          stat.Kind,
+		Key:           stat.Target,
+		Count:         stat.Count,
+		FirstFailedAt: stat.FirstFailedAt,
+		LastFailedAt:  stat.LastFailedAt,
+	}
+}
+
+func (counter *FailCounter) Fail(ctx context.Context, username, address string) error {
+	if username != "" {
+		if err := counter.dao.Insert(ctx, services.FailByUsername, username, address); err != nil {
+			return errors.Wrap(err, "记录登录失败次数失败")
+		}
+	}
+	if address != "" {
+		if err := counter.dao.Insert(ctx, services.FailByAddress, address, address); err != nil {
+			return errors.Wrap(err, "记录登录失败次数失败")
+		}
+	}
+	return nil
+}
+
+func (counter *FailCounter) Count(ctx context.Context, kind, key string) (services.FailRecord, error) {
+	list, err := counter.dao.Count(ctx, kind, key, counter.interval)
+	if err != nil {
+		return services.FailRecord{}, errors.Wrap(err, "查询登录失败次数失败")
+	}
+	if len(list) == 0 {
+		return services.FailRecord{Kind: kind, Key: key}, nil
+	}
+	return toFailRecord(&list[0]), nil
+}
+
+func (counter *FailCounter) Zero(ctx context.Context, kind, key string) error {
+	_, err := counter.dao.Zero(ctx, kind, key)
+	if err != nil {
+		return errors.Wrap(err, "清除登录失败次数失败")
+	}
+	return nil
+}
+
+func (counter *FailCounter) List(ctx context.Context) ([]services.FailRecord, error) {
+	list, err := counter.dao.List(ctx, counter.interval)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询登录失败次数失败")
+	}
+	results := make([]services.FailRecord, 0, len(list))
+	for idx := range list {
+		results = append(results, toFailRecord(&list[idx]))
+	}
+	return results, nil
+}
+
+func (counter *FailCounter) DeleteExpired(ctx context.Context) error {
+	_, err := counter.dao.DeleteExpired(ctx, counter.interval)
+	return err
+}
+
+// autoUnlocker 定时解锁超过 users.locked_time_expires 的用户, 并在 Bus 上发送 api.BusUserUnlocked 事件
+type autoUnlocker struct {
+	logger  log.Logger
+	users   usermodels.UserDao
+	bus     *moo.Bus
+	expires time.Duration
+}
+
+func (u *autoUnlocker) UnlockExpired(ctx context.Context) {
+	if u.expires <= 0 {
+		return
+	}
+
+	list, err := u.users.GetExpiredLockedUsers(ctx, toInterval(u.expires))
+	if err != nil {
+		u.logger.Warn("查询锁定过期的用户失败", log.Error(err))
+		return
+	}
+	for idx := range list {
+		if err := u.users.UnlockUser(ctx, list[idx].ID); err != nil {
+			u.logger.Warn("自动解锁用户失败", log.String("username", list[idx].Name), log.Error(err))
+			continue
+		}
+		u.logger.Info("用户锁定已过期，自动解锁", log.String("username", list[idx].Name))
+
+		err = u.bus.Emit(ctx, api.BusUserUnlocked, &services.LockoutEvent{
+			Kind:     services.FailByUsername,
+			Key:      list[idx].Name,
+			Username: list[idx].Name,
+			At:       time.Now(),
+		})
+		if err != nil {
+			u.logger.Warn("发送解锁事件失败", log.String("username", list[idx].Name), log.Error(err))
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package authn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/api/authclient"
+	"github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/authz"
+	"go.uber.org/fx"
+)
+
+// PermissionImpersonate 以其它用户的身份登录的权限
+const PermissionImpersonate = "um.users.impersonate"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionImpersonate, Title: "以其它用户的身份登录", Group: "用户管理"})
+}
+
+// 模拟登录时会话中记录原来的用户的键
+const (
+	sessionImpersonatorIDKey      = "impersonator_id"
+	sessionImpersonatorNameKey    = "impersonator_name"
+	sessionImpersonatorSessionKey = "impersonator_session"
+)
+
+// jwtImpersonatorPrefix 模拟登录时 JWT 的 Subject 为 "impersonator:<id> <name>"
+const jwtImpersonatorPrefix = "impersonator:"
+
+var (
+	ErrAlreadyImpersonating = errors.NewError(http.StatusBadRequest, "已经在模拟其它用户了, 请先结束模拟")
+	ErrNotImpersonating     = errors.NewError(http.StatusBadRequest, "当前会话不是模拟登录的会话")
+	ErrImpersonateSelf      = errors.NewError(http.StatusBadRequest, "不能模拟自已")
+	ErrImpersonateAdmin     = errors.NewError(http.StatusForbidden, "不能模拟有模拟登录权限的用户")
+	ErrImpersonateDenied    = errors.NewError(http.StatusForbidden, "没有模拟其它用户登录的权限")
+	ErrImpersonateNoSession = errors.NewError(http.StatusUnauthorized, "模拟登录必须使用浏览器会话")
+)
+
+type ArgOperationLogger struct {
+	fx.In
+
+	Logger api.OperationLogger `optional:"true"`
+}
+
+// ImpersonateRequest 开始模拟登录的参数, Username 和 UserID 二选一
+type ImpersonateRequest struct {
+	UserID   int64  `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+// impersonationFromValues 从会话中读模拟登录的信息, 不是模拟登录的会话时返回 nil
+func impersonationFromValues(values url.Values) *api.Impersonation {
+	s := values.Get(sessionImpersonatorIDKey)
+	if s == "" {
+		return nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &api.Impersonation{
+		ImpersonatorID:   id,
+		ImpersonatorName: values.Get(sessionImpersonatorNameKey),
+		SessionID:        values.Get(sessionImpersonatorSessionKey),
+	}
+}
+
+func impersonationToSubject(impersonation *api.Impersonation) string {
+	if impersonation == nil {
+		return ""
+	}
+	return jwtImpersonatorPrefix + strconv.FormatInt(impersonation.ImpersonatorID, 10) + " " + impersonation.ImpersonatorName
+}
+
+// impersonationFromSubject 从 JWT 的 Subject 中读模拟登录的信息, 不是模拟登录的 token 时返回 nil
+func impersonationFromSubject(subject string) *api.Impersonation {
+	if !strings.HasPrefix(subject, jwtImpersonatorPrefix) {
+		return nil
+	}
+	ss := strings.SplitN(strings.TrimPrefix(subject, jwtImpersonatorPrefix), " ", 2)
+	if len(ss) < 2 {
+		return nil
+	}
+	id, err := strconv.ParseInt(ss[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &api.Impersonation{
+		ImpersonatorID:   id,
+		ImpersonatorName: ss[1],
+	}
+}
+
+func readImpersonateRequest(r *http.Request) (*ImpersonateRequest, error) {
+	var req ImpersonateRequest
+	if IsConsumeJSON(r) {
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return nil, errors.WithHTTPCode(errors.Wrap(err, "请求参数不正确"), http.StatusBadRequest)
+			}
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, errors.WithHTTPCode(errors.Wrap(err, "请求参数不正确"), http.StatusBadRequest)
+		}
+		req.Username = r.Form.Get("username")
+		req.Service = r.Form.Get("service")
+		if s := r.Form.Get("user_id"); s != "" {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, errors.NewError(http.StatusBadRequest, "user_id '"+s+"' 不正确")
+			}
+			req.UserID = id
+		}
+	}
+	if req.UserID == 0 && req.Username == "" {
+		return nil, errors.NewError(http.StatusBadRequest, "user_id 或 username 不能为空")
+	}
+	return &req, nil
+}
+
+// startImpersonation 检查 currentUser 能否模拟 req 指定的用户, 可以时为被模拟的用户创建新的会话,
+// values 为 currentUser 当前的会话
+func (mgr *LoginManager) startImpersonation(ctx context.Context, values url.Values, currentUser api.User, req *ImpersonateRequest, address string) (api.User, *api.Impersonation, string, error) {
+	if impersonationFromValues(values) != nil {
+		return nil, nil, "", ErrAlreadyImpersonating
+	}
+	if currentUser.Name() != values.Get(authclient.SESSION_USER_KEY) {
+		return nil, nil, "", ErrImpersonateNoSession
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionImpersonate)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, nil, "", ErrImpersonateDenied
+	}
+
+	var target api.User
+	if req.UserID != 0 {
+		target, err = mgr.userManager.UserByID(ctx, req.UserID)
+	} else {
+		target, err = mgr.userManager.UserByName(ctx, req.Username)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if target.ID() == currentUser.ID() {
+		return nil, nil, "", ErrImpersonateSelf
+	}
+	ok, err = target.HasPermission(ctx, PermissionImpersonate)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "检查权限失败")
+	}
+	if ok {
+		return nil, nil, "", ErrImpersonateAdmin
+	}
+
+	impersonation := &api.Impersonation{
+		ImpersonatorID:   currentUser.ID(),
+		ImpersonatorName: currentUser.Name(),
+		SessionID:        values.Get(authclient.SESSION_ID_KEY),
+	}
+
+	// 总是创建一个新的会话, 不能沿用用户自己在同一个地址上的会话, 否则结束模拟时会将用户自己的会话登出
+	sessionID, err := mgr.online.Impersonate(ctx, target.ID(), target.Name(), address, currentUser.ID())
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "registr user to online table fail")
+	}
+
+	logger := mgr.logger.With(log.String("impersonator", currentUser.Name()),
+		log.String("username", target.Name()),
+		log.String("session", sessionID),
+		log.String("address", address))
+	logger.Info("开始模拟登录")
+
+	if err := mgr.logImpersonation(ctx, currentUser, target, "impersonate_start", "以用户 '"+target.Name()+"' 的身份登录"); err != nil {
+		logger.Warn("添加操作日志失败", log.Error(err))
+	}
+	return target, impersonation, sessionID, nil
+}
+
+// Impersonate 管理员以其它用户的身份登录, 新会话中记录了原来的用户和会话, 结束模拟时恢复原来的会话
+func (mgr *LoginManager) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	values, err := mgr.GetSession(r)
+	if err != nil {
+		ReturnError(w, r, ErrImpersonateNoSession.Error(), errors.HTTPCode(ErrImpersonateNoSession))
+		return
+	}
+
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		ReturnError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	req, err := readImpersonateRequest(r)
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	address := RealIP(r)
+	target, impersonation, sessionID, err := mgr.startImpersonation(ctx, values, currentUser, req, address)
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	authCtx := &services.AuthContext{
+		Logger: mgr.logger.With(log.String("impersonator", currentUser.Name()),
+			log.String("username", target.Name()),
+			log.String("session", sessionID),
+			log.String("address", address)),
+		Ctx: ctx,
+	}
+	authCtx.Request.UserID = target.ID()
+	authCtx.Request.Username = target.Name()
+	authCtx.Request.Address = address
+	authCtx.Request.Service = req.Service
+	authCtx.Response.IsOK = true
+	authCtx.Response.SessionID = sessionID
+
+	mgr.Renderer.setSessionCookie(authCtx, impersonation, w, r)
+
+	tokenString, err := mgr.generateJWT(ctx, w, r, sessionID, target.ID(), target.Name(), impersonation)
+	if err != nil {
+		ReturnError(w, r, errors.Wrap(err, "Error while signing the token").Error(), http.StatusInternalServerError)
+		return
+	}
+	ReturnJSON(w, r, map[string]interface{}{
+		"id":            target.ID(),
+		"name":          target.Name(),
+		"token":         tokenString,
+		"expires_in":    int(mgr.expiresIn.Seconds()),
+		"impersonation": impersonation,
+		"redirect":      req.Service,
+	}, http.StatusOK)
+}
+
+// endImpersonation 登出模拟的会话, 返回管理员原来的会话, 原来的会话已经失效时返回 nil
+func (mgr *LoginManager) endImpersonation(ctx context.Context, values url.Values, address string) (*SessionInfo, error) {
+	impersonation := impersonationFromValues(values)
+	if impersonation == nil {
+		return nil, ErrNotImpersonating
+	}
+
+	sessionID := values.Get(authclient.SESSION_ID_KEY)
+	username := values.Get(authclient.SESSION_USER_KEY)
+	logger := mgr.logger.With(log.String("impersonator", impersonation.ImpersonatorName),
+		log.String("username", username),
+		log.String("session", sessionID),
+		log.String("address", address))
+
+	if err := mgr.online.Logout(ctx, sessionID); err != nil {
+		logger.Warn("登出模拟的会话失败", log.Error(err))
+	}
+
+	var target api.User
+	var err error
+	if mgr.userManager != nil {
+		target, err = mgr.userManager.UserByName(ctx, username, api.UserIncludeDisabled())
+		if err != nil {
+			logger.Warn("读被模拟的用户失败", log.Error(err))
+		}
+	}
+	var impersonator api.User
+	if mgr.userManager != nil {
+		impersonator, err = mgr.userManager.UserByID(ctx, impersonation.ImpersonatorID)
+		if err != nil {
+			logger.Warn("读原来的用户失败", log.Error(err))
+		}
+	}
+	if impersonator != nil && target != nil {
+		if err := mgr.logImpersonation(ctx, impersonator, target, "impersonate_end", "结束以用户 '"+username+"' 的身份登录"); err != nil {
+			logger.Warn("添加操作日志失败", log.Error(err))
+		}
+	}
+
+	sessionInfo, err := mgr.online.Get(ctx, impersonation.SessionID)
+	if err != nil || sessionInfo == nil {
+		logger.Info("结束模拟登录, 原来的会话已失效")
+		return nil, nil
+	}
+	logger.Info("结束模拟登录")
+	return sessionInfo, nil
+}
+
+// EndImpersonation 结束模拟登录, 登出模拟的会话并恢复管理员原来的会话
+func (mgr *LoginManager) EndImpersonation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	values, err := mgr.GetSession(r)
+	if err != nil {
+		ReturnError(w, r, "sess is missing", http.StatusUnauthorized)
+		return
+	}
+
+	sessionInfo, err := mgr.endImpersonation(ctx, values, RealIP(r))
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	if sessionInfo == nil {
+		if err := mgr.Renderer.Logout(ctx, w, r); err != nil {
+			mgr.logger.Warn("生成登出页面出错", log.Error(err))
+		}
+		return
+	}
+
+	authCtx := &services.AuthContext{
+		Logger: mgr.logger,
+		Ctx:    ctx,
+	}
+	authCtx.Request.UserID = sessionInfo.UserID
+	authCtx.Request.Username = sessionInfo.Username
+	authCtx.Request.Address = sessionInfo.Address
+	authCtx.Response.IsOK = true
+	authCtx.Response.SessionID = sessionInfo.UUID
+	mgr.Renderer.SetSessionCookie(authCtx, w, r)
+
+	ReturnJSON(w, r, map[string]interface{}{
+		"id":   sessionInfo.UserID,
+		"name": sessionInfo.Username,
+	}, http.StatusOK)
+}
+
+// GetImpersonation 返回当前会话的模拟登录信息, 页面用它显示正在模拟其它用户的提示
+func (mgr *LoginManager) GetImpersonation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	impersonation := api.ImpersonationFromContext(ctx)
+	if impersonation == nil {
+		ReturnJSON(w, r, map[string]interface{}{
+			"impersonating": false,
+		}, http.StatusOK)
+		return
+	}
+	ReturnJSON(w, r, map[string]interface{}{
+		"impersonating":     true,
+		"impersonator_id":   impersonation.ImpersonatorID,
+		"impersonator_name": impersonation.ImpersonatorName,
+	}, http.StatusOK)
+}
+
+func (mgr *LoginManager) logImpersonation(ctx context.Context, impersonator, target api.User, typ, content string) error {
+	if mgr.opLogger == nil {
+		return nil
+	}
+	err := mgr.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     impersonator.ID(),
+		Username:   impersonator.Name(),
+		Type:       typ,
+		Successful: true,
+		Content:    content,
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   target.ID(),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
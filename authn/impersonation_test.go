@@ -0,0 +1,232 @@
+package authn
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/api/authclient"
+)
+
+type testUser struct {
+	api.User
+
+	id          int64
+	name        string
+	impersonate bool
+}
+
+func (u *testUser) ID() int64    { return u.id }
+func (u *testUser) Name() string { return u.name }
+func (u *testUser) HasPermission(ctx context.Context, permissionID string) (bool, error) {
+	return permissionID == PermissionImpersonate && u.impersonate, nil
+}
+
+type testUserManager struct {
+	UserManager
+
+	users []*testUser
+}
+
+func (um *testUserManager) UserByID(ctx context.Context, userID int64, opts ...api.Option) (api.User, error) {
+	for _, u := range um.users {
+		if u.id == userID {
+			return u, nil
+		}
+	}
+	return nil, errors.ErrNotFoundWithText("该用户不存在!")
+}
+
+func (um *testUserManager) UserByName(ctx context.Context, username string, opts ...api.Option) (api.User, error) {
+	for _, u := range um.users {
+		if u.name == username {
+			return u, nil
+		}
+	}
+	return nil, errors.ErrNotFoundWithText("该用户不存在!")
+}
+
+type testOpLogger struct {
+	api.OperationLogger
+
+	records []*api.OperationLog
+}
+
+func (l *testOpLogger) LogRecord(ctx context.Context, ol *api.OperationLog) error {
+	l.records = append(l.records, ol)
+	return nil
+}
+
+func TestImpersonate(t *testing.T) {
+	ctx := context.Background()
+	admin := &testUser{id: 1, name: "admin", impersonate: true}
+	operator := &testUser{id: 2, name: "operator", impersonate: true}
+	tom := &testUser{id: 3, name: "tom"}
+
+	online := &testSessions{list: map[string]*SessionInfo{
+		"admin-session": {UUID: "admin-session", UserID: admin.id, Username: admin.name, Address: "192.168.1.2"},
+	}}
+	opLogger := &testOpLogger{}
+	mgr := &LoginManager{
+		logger:      log.Empty(),
+		online:      online,
+		opLogger:    opLogger,
+		userManager: &testUserManager{users: []*testUser{admin, operator, tom}},
+	}
+
+	values := url.Values{}
+	values.Set(authclient.SESSION_ID_KEY, "admin-session")
+	values.Set(authclient.SESSION_USER_KEY, admin.name)
+
+	_, _, _, err := mgr.startImpersonation(ctx, values, admin, &ImpersonateRequest{Username: admin.name}, "192.168.1.2")
+	if err != ErrImpersonateSelf {
+		t.Error("want ErrImpersonateSelf, got", err)
+	}
+	_, _, _, err = mgr.startImpersonation(ctx, values, admin, &ImpersonateRequest{UserID: operator.id}, "192.168.1.2")
+	if err != ErrImpersonateAdmin {
+		t.Error("want ErrImpersonateAdmin, got", err)
+	}
+	_, _, _, err = mgr.startImpersonation(ctx, values, tom, &ImpersonateRequest{UserID: operator.id}, "192.168.1.2")
+	if err != ErrImpersonateNoSession {
+		t.Error("want ErrImpersonateNoSession, got", err)
+	}
+	if len(opLogger.records) != 0 {
+		t.Error("refused impersonation is logged", opLogger.records)
+	}
+
+	target, impersonation, sessionID, err := mgr.startImpersonation(ctx, values, admin, &ImpersonateRequest{Username: tom.name}, "192.168.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.ID() != tom.id || sessionID == "" || online.list[sessionID] == nil {
+		t.Fatal("session of target isnot created", target, sessionID)
+	}
+	if impersonation.ImpersonatorID != admin.id ||
+		impersonation.ImpersonatorName != admin.name ||
+		impersonation.SessionID != "admin-session" {
+		t.Errorf("%#v", impersonation)
+	}
+	if len(opLogger.records) != 1 {
+		t.Fatal("want 1 operation log, got", len(opLogger.records))
+	}
+	if ol := opLogger.records[0]; ol.Type != "impersonate_start" ||
+		ol.UserID != admin.id ||
+		ol.Fields == nil || ol.Fields.ObjectID != tom.id {
+		t.Errorf("%#v", ol)
+	}
+
+	// 模拟的会话中不能再次模拟
+	impersonated := url.Values{}
+	impersonated.Set(authclient.SESSION_ID_KEY, sessionID)
+	impersonated.Set(authclient.SESSION_USER_KEY, tom.name)
+	impersonated.Set(sessionImpersonatorIDKey, "1")
+	impersonated.Set(sessionImpersonatorNameKey, admin.name)
+	impersonated.Set(sessionImpersonatorSessionKey, impersonation.SessionID)
+	_, _, _, err = mgr.startImpersonation(ctx, impersonated, admin, &ImpersonateRequest{UserID: tom.id}, "192.168.1.2")
+	if err != ErrAlreadyImpersonating {
+		t.Error("want ErrAlreadyImpersonating, got", err)
+	}
+
+	if _, err := mgr.endImpersonation(ctx, values, "192.168.1.2"); err != ErrNotImpersonating {
+		t.Error("want ErrNotImpersonating, got", err)
+	}
+
+	sessionInfo, err := mgr.endImpersonation(ctx, impersonated, "192.168.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionInfo == nil || sessionInfo.UUID != "admin-session" || sessionInfo.Username != admin.name {
+		t.Errorf("session of admin isnot restored - %#v", sessionInfo)
+	}
+	if online.list[sessionID] != nil {
+		t.Error("impersonated session isnot logout")
+	}
+	if len(opLogger.records) != 2 {
+		t.Fatal("want 2 operation logs, got", len(opLogger.records))
+	}
+	if ol := opLogger.records[1]; ol.Type != "impersonate_end" ||
+		ol.UserID != admin.id ||
+		ol.Fields == nil || ol.Fields.ObjectID != tom.id {
+		t.Errorf("%#v", ol)
+	}
+
+	// 原来的会话已失效时返回 nil, 由调用者登出
+	delete(online.list, "admin-session")
+	sessionInfo, err = mgr.endImpersonation(ctx, impersonated, "192.168.1.2")
+	if err != nil || sessionInfo != nil {
+		t.Error(sessionInfo, err)
+	}
+}
+
+func TestImpersonateWhenTargetIsOnline(t *testing.T) {
+	ctx := context.Background()
+	admin := &testUser{id: 1, name: "admin", impersonate: true}
+	tom := &testUser{id: 3, name: "tom"}
+
+	// tom 自己已经从同一个地址登录了
+	online := &testSessions{list: map[string]*SessionInfo{
+		"admin-session": {UUID: "admin-session", UserID: admin.id, Username: admin.name, Address: "192.168.1.2"},
+		"tom-session":   {UUID: "tom-session", UserID: tom.id, Username: tom.name, Address: "192.168.1.2"},
+	}}
+	mgr := &LoginManager{
+		logger:      log.Empty(),
+		online:      online,
+		opLogger:    &testOpLogger{},
+		userManager: &testUserManager{users: []*testUser{admin, tom}},
+	}
+
+	values := url.Values{}
+	values.Set(authclient.SESSION_ID_KEY, "admin-session")
+	values.Set(authclient.SESSION_USER_KEY, admin.name)
+
+	_, impersonation, sessionID, err := mgr.startImpersonation(ctx, values, admin, &ImpersonateRequest{UserID: tom.id}, "192.168.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID == "tom-session" {
+		t.Fatal("session of tom is reused")
+	}
+	if si := online.list[sessionID]; si == nil || si.ImpersonatorID != admin.id {
+		t.Errorf("%#v", si)
+	}
+
+	impersonated := url.Values{}
+	impersonated.Set(authclient.SESSION_ID_KEY, sessionID)
+	impersonated.Set(authclient.SESSION_USER_KEY, tom.name)
+	impersonated.Set(sessionImpersonatorIDKey, "1")
+	impersonated.Set(sessionImpersonatorNameKey, admin.name)
+	impersonated.Set(sessionImpersonatorSessionKey, impersonation.SessionID)
+	if _, err := mgr.endImpersonation(ctx, impersonated, "192.168.1.2"); err != nil {
+		t.Fatal(err)
+	}
+	if online.list[sessionID] != nil {
+		t.Error("impersonated session isnot logout")
+	}
+	if online.list["tom-session"] == nil {
+		t.Error("session of tom is logout")
+	}
+
+}
+
+func TestImpersonationSubject(t *testing.T) {
+	impersonation := &api.Impersonation{ImpersonatorID: 12, ImpersonatorName: "张 三"}
+	subject := impersonationToSubject(impersonation)
+	actual := impersonationFromSubject(subject)
+	if actual == nil ||
+		actual.ImpersonatorID != impersonation.ImpersonatorID ||
+		actual.ImpersonatorName != impersonation.ImpersonatorName {
+		t.Errorf("%q - %#v", subject, actual)
+	}
+
+	for _, s := range []string{"", "tom", "impersonator:", "impersonator:12", "impersonator:abc tom"} {
+		if impersonationFromSubject(s) != nil {
+			t.Error(s)
+		}
+	}
+	if impersonationToSubject(nil) != "" {
+		t.Error("subject of nil")
+	}
+}
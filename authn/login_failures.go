@@ -0,0 +1,136 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/authz"
+)
+
+// PermissionManageLoginFailures 查看和清除登录失败次数的权限
+const PermissionManageLoginFailures = "um.login_failures.manage"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionManageLoginFailures, Title: "管理登录失败记录", Group: "会话管理"})
+}
+
+// lockoutNotifier 在用户被锁定或地址被拒绝登录时在 Bus 上发送 api.BusUserLockout 事件
+type lockoutNotifier struct {
+	mgr *LoginManager
+}
+
+func (n *lockoutNotifier) OnLockout(ctx *services.AuthContext, evt *services.LockoutEvent) {
+	if n.mgr == nil {
+		return
+	}
+	n.mgr.logger.Warn("登录失败次数太多",
+		log.String("kind", evt.Kind),
+		log.String("key", evt.Key),
+		log.String("address", evt.Address),
+		log.Any("count", evt.Count))
+
+	if n.mgr.bus == nil {
+		return
+	}
+	if err := n.mgr.bus.Emit(ctx.Ctx, api.BusUserLockout, evt); err != nil {
+		n.mgr.logger.Warn("发送锁定事件失败", log.String("key", evt.Key), log.Error(err))
+	}
+}
+
+func checkManageLoginFailures(ctx context.Context) (api.User, error) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionManageLoginFailures)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, errors.NewError(http.StatusForbidden, "没有管理登录失败记录的权限")
+	}
+	return currentUser, nil
+}
+
+// ListLoginFailures 管理员查看登录失败的记录, 可以按 kind 和 key 过滤
+func (mgr *LoginManager) ListLoginFailures(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, err := checkManageLoginFailures(ctx); err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	queryParams := r.URL.Query()
+	kind := queryParams.Get("kind")
+	key := queryParams.Get("key")
+	if key != "" {
+		if kind == "" {
+			kind = services.FailByUsername
+		}
+		record, err := mgr.failCounter.Count(ctx, kind, key)
+		if err != nil {
+			ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+			return
+		}
+		var list = []services.FailRecord{}
+		if record.Count > 0 {
+			list = append(list, record)
+		}
+		ReturnJSON(w, r, list, http.StatusOK)
+		return
+	}
+
+	list, err := mgr.failCounter.List(ctx)
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	var results = make([]services.FailRecord, 0, len(list))
+	for idx := range list {
+		if kind == "" || list[idx].Kind == kind {
+			results = append(results, list[idx])
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LastFailedAt.After(results[j].LastFailedAt)
+	})
+	ReturnJSON(w, r, results, http.StatusOK)
+}
+
+// ClearLoginFailures 管理员清除某个用户名或地址的登录失败次数
+func (mgr *LoginManager) ClearLoginFailures(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := checkManageLoginFailures(ctx)
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	queryParams := r.URL.Query()
+	kind := queryParams.Get("kind")
+	key := queryParams.Get("key")
+	if key == "" {
+		ReturnError(w, r, "key is missing", http.StatusBadRequest)
+		return
+	}
+	switch kind {
+	case "":
+		kind = services.FailByUsername
+	case services.FailByUsername, services.FailByAddress:
+	default:
+		ReturnError(w, r, "kind '"+kind+"' is invalid", http.StatusBadRequest)
+		return
+	}
+
+	if err := mgr.failCounter.Zero(ctx, kind, key); err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	mgr.logger.Info("清除登录失败次数", log.String("kind", kind),
+		log.String("key", key),
+		log.String("operator", currentUser.Name()))
+	ReturnJSON(w, r, map[string]interface{}{"kind": kind, "key": key}, http.StatusOK)
+}
@@ -29,6 +29,12 @@ type ArgWelcomeLocator struct {
 	Locator WelcomeLocator `optional:"true"`
 }
 
+type ArgFailCounter struct {
+	fx.In
+
+	Counter services.FailCounter `optional:"true"`
+}
+
 type AuthOut struct {
 	fx.Out
 
@@ -36,6 +42,7 @@ type AuthOut struct {
 	Renderer     *Renderer
 	JWT          loong.AuthValidateFunc `group:"authValidate"`
 	Session      loong.AuthValidateFunc `group:"authValidate"`
+	APIToken     loong.AuthValidateFunc `group:"authValidate"`
 }
 
 type InAuthFunc = moo.InAuthFuncs
@@ -45,11 +52,21 @@ func init() {
 		return moo.Provide(ReadConfig)
 	})
 	moo.On(func(*moo.Environment) moo.Option {
-		return moo.Provide(func(env *moo.Environment, cfg *Config, userManager UserManager, online Sessions, locator ArgWelcomeLocator, authopts services.InAuthOpts) (AuthOut, error) {
-			loginManager, err := NewLoginManager(env, cfg, userManager, online, locator.Locator, authopts.Opts)
+		return moo.Provide(func(lifecycle fx.Lifecycle, env *moo.Environment, cfg *Config, userManager UserManager, online Sessions, locator ArgWelcomeLocator, authopts services.InAuthOpts, apiTokens ArgAPITokenVerifier, failCounter ArgFailCounter, opLogger ArgOperationLogger, bus *moo.Bus) (AuthOut, error) {
+			loginManager, err := NewLoginManager(env, cfg, userManager, online, failCounter.Counter, locator.Locator, authopts.Opts)
 			if err != nil {
 				return AuthOut{}, err
 			}
+			lifecycle.Append(fx.Hook{
+				OnStop: func(context.Context) error {
+					// 关闭 LDAP 连接池等登录插件的资源
+					return loginManager.Close()
+				},
+			})
+			loginManager.apiTokens = apiTokens.Verifier
+			loginManager.bus = bus
+			loginManager.opLogger = opLogger.Logger
+			bus.RegisterTopics(api.BusSessionTerminated, api.BusUserLockout, api.BusUserLogin)
 
 			authValidates := loginManager.AuthValidates()
 			return AuthOut{
@@ -57,6 +74,7 @@ func init() {
 				Renderer:     loginManager.Renderer,
 				JWT:          authValidates[0],
 				Session:      authValidates[1],
+				APIToken:     authValidates[2],
 			}, nil
 		})
 	})
@@ -72,13 +90,20 @@ type LoginManager struct {
 	authSrv     *services.AuthService
 	jwtConfig   *loong.JWTAuth
 	expiresIn   time.Duration
+	apiTokens   APITokenVerifier
+	failCounter services.FailCounter
+	bus         *moo.Bus
+	opLogger    api.OperationLogger
 }
 
 func (mgr *LoginManager) Close() error {
+	err := mgr.authSrv.Close()
 	if closer, ok := mgr.online.(io.Closer); ok {
-		return closer.Close()
+		if e := closer.Close(); e != nil {
+			return e
+		}
 	}
-	return nil
+	return err
 }
 
 func (mgr *LoginManager) StaticDir(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -272,6 +297,7 @@ func (mgr *LoginManager) LoginWith(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 	authCtx.Request.Address = RealIP(r)
+	authCtx.Request.ClientCertificate = ClientCertificate(r)
 
 	if loginType != tokenNone {
 		authCtx.SkipCaptcha = true
@@ -287,55 +313,48 @@ func (mgr *LoginManager) LoginWith(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	if authCtx.Response.UserSource != "api" {
-		if authCtx.Response.IsNewUser && authCtx.Request.UserID == nil {
-			var roles []string
-			u, ok := authCtx.Authentication.(services.User)
-			if ok {
-				roles = u.Roles()
-			}
-			userid, err := mgr.userManager.Create(ctx,
-				authCtx.Request.Username,
-				authCtx.Request.Username,
-				"ldap",
-				"",
-				map[string]interface{}{},
-				roles,
-				true)
-			if err != nil {
-				returnError(authCtx, w, r, &services.ErrExternalServer{
-					Msg: "内部错误",
-					Err: err,
-				})
-				return
-			}
-			authCtx.Request.UserID = userid
+	// 用户必须先修改密码, 这时不创建会话
+	if authCtx.Response.MustChangePassword {
+		if loginType != tokenNone {
+			returnError(authCtx, w, r, services.ErrPasswordMustChange)
+			return
 		}
 
-		authCtx.Response.SessionID, err = mgr.online.Login(ctx, authCtx.Request.UserID, authCtx.Request.Username, authCtx.Request.Address)
+		authCtx.Logger.Info("用户必须修改密码", log.String("username", authCtx.Request.Username),
+			log.String("address", authCtx.Request.Address))
+		err = mgr.Renderer.ChangePassword(authCtx, w, r, "密码已过期或被管理员重置，请先修改密码", nil)
 		if err != nil {
-			returnError(authCtx, w, r, &services.ErrExternalServer{
-				Msg: "内部错误",
-				Err: errors.Wrap(err, "registr user to online table fail"),
-			})
-			return
+			mgr.logger.Warn("生成修改密码页面出错", log.Error(err))
 		}
+		return
+	}
+
+	if err := mgr.createSession(ctx, authCtx); err != nil {
+		returnError(authCtx, w, r, err)
+		return
 	}
 
 	switch loginType {
 	case tokenNone:
 		returnOK(authCtx, w, r, nil)
 	case tokenJWT:
-		tokenString, err := mgr.generateJWT(authCtx.Ctx, w, r, authCtx.Response.SessionID, authCtx.Request.UserID, authCtx.Request.Username)
+		tokenString, err := mgr.generateJWT(authCtx.Ctx, w, r, authCtx.Response.SessionID, authCtx.Request.UserID, authCtx.Request.Username, nil)
 		if err != nil {
 			returnError(authCtx, w, r, errors.Wrap(err, "Error while signing the token"))
 			return
 		}
 
-		returnOK(authCtx, w, r, map[string]interface{}{
+		result := map[string]interface{}{
 			"token":      tokenString,
 			"expires_in": int(mgr.expiresIn.Seconds()),
-		})
+		}
+		if !authCtx.Response.PasswordExpiresAt.IsZero() {
+			result["password_expires_at"] = authCtx.Response.PasswordExpiresAt
+		}
+		if warning, ok := authCtx.Response.Data["password_warning"]; ok {
+			result["password_warning"] = warning
+		}
+		returnOK(authCtx, w, r, result)
 		return
 	default:
 		returnError(authCtx, w, r, errors.New("login is ok, but token type is unsupport - "+loginType.String()))
@@ -343,9 +362,62 @@ func (mgr *LoginManager) LoginWith(ctx context.Context, w http.ResponseWriter, r
 	}
 }
 
-func (mgr *LoginManager) generateJWT(ctx context.Context, w http.ResponseWriter, r *http.Request, sessionID string, userID interface{}, username string) (string, error) {
+// createSession 认证成功后为用户创建会话, ldap 等外部用户第一次登录时会先创建用户
+func (mgr *LoginManager) createSession(ctx context.Context, authCtx *services.AuthContext) error {
+	if authCtx.Response.UserSource == "api" {
+		return nil
+	}
+
+	if authCtx.Response.IsNewUser && authCtx.Request.UserID == nil {
+		var roles []string
+		u, ok := authCtx.Authentication.(services.User)
+		if ok {
+			roles = u.Roles()
+		}
+		userid, err := mgr.userManager.Create(ctx,
+			authCtx.Request.Username,
+			authCtx.Request.Username,
+			"ldap",
+			"",
+			map[string]interface{}{},
+			roles,
+			true)
+		if err != nil {
+			return &services.ErrExternalServer{
+				Msg: "内部错误",
+				Err: err,
+			}
+		}
+		authCtx.Request.UserID = userid
+	}
+
+	var err error
+	authCtx.Response.SessionID, err = mgr.online.Login(ctx, authCtx.Request.UserID, authCtx.Request.Username, authCtx.Request.Address)
+	if err != nil {
+		return &services.ErrExternalServer{
+			Msg: "内部错误",
+			Err: errors.Wrap(err, "registr user to online table fail"),
+		}
+	}
+
+	if mgr.bus != nil {
+		if err := mgr.bus.Emit(ctx, api.BusUserLogin, &UserLogin{
+			UserID:    authCtx.Request.UserID,
+			Username:  authCtx.Request.Username,
+			Address:   authCtx.Request.Address,
+			SessionID: authCtx.Response.SessionID,
+			LoginAt:   time.Now(),
+		}); err != nil {
+			mgr.logger.Warn("发送登录事件失败", log.String("username", authCtx.Request.Username), log.Error(err))
+		}
+	}
+	return nil
+}
+
+func (mgr *LoginManager) generateJWT(ctx context.Context, w http.ResponseWriter, r *http.Request, sessionID string, userID interface{}, username string, impersonation *api.Impersonation) (string, error) {
 	claims := &jwt.StandardClaims{
 		Id:        sessionID,
+		Subject:   impersonationToSubject(impersonation),
 		ExpiresAt: time.Now().Add(mgr.expiresIn).Unix(),
 		IssuedAt:  time.Now().Unix(),
 		NotBefore: time.Now().Unix(),
@@ -449,7 +521,7 @@ func (mgr *LoginManager) GetCurrentToken(ctx context.Context, w http.ResponseWri
 		return
 	}
 
-	tokenString, err := mgr.generateJWT(ctx, w, r, sessionInfo.UUID, sessionInfo.UserID, sessionInfo.Username)
+	tokenString, err := mgr.generateJWT(ctx, w, r, sessionInfo.UUID, sessionInfo.UserID, sessionInfo.Username, impersonationFromValues(values))
 	if err != nil {
 		ReturnError(w, r, errors.Wrap(err, "Error while signing the token").Error(), http.StatusUnauthorized)
 		return
@@ -477,8 +549,9 @@ func (mgr *LoginManager) Get(ctx context.Context, w http.ResponseWriter, r *http
 				// roles: data.roles,
 				// name: data.name,
 				// avatar: data.avatar,
-				"roles": []string{"admin"},
-				"name":  values.Get(authclient.SESSION_USER_KEY),
+				"roles":         []string{"admin"},
+				"name":          values.Get(authclient.SESSION_USER_KEY),
+				"impersonation": impersonationFromValues(values),
 			}, http.StatusOK)
 			return
 		}
@@ -492,8 +565,9 @@ func (mgr *LoginManager) Get(ctx context.Context, w http.ResponseWriter, r *http
 		// roles: data.roles,
 		// name: data.name,
 		// avatar: data.avatar,
-		"roles": []string{"admin"},
-		"name":  user.Nickname(),
+		"roles":         []string{"admin"},
+		"name":          user.Nickname(),
+		"impersonation": api.ImpersonationFromContext(ctx),
 	}, http.StatusOK)
 }
 
@@ -544,6 +618,56 @@ func (mgr *LoginManager) CurrentUsername(r *http.Request) string {
 	return values.Get(authclient.SESSION_USER_KEY)
 }
 
+// checkSession 检查会话是否还在, 并更新会话的最后活动时间, 会话的空闲超时是从最后一次活动开始算的
+//
+// 不保存会话时（sessions/empty）cookie 中仍然有一个随机的会话 ID, 这时不检查
+func (mgr *LoginManager) checkSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" || !mgr.online.IsTracked() {
+		return nil
+	}
+	sessionInfo, err := mgr.online.Get(ctx, sessionID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ErrSessionTerminated
+		}
+		return errors.Wrap(err, "读会话失败")
+	}
+	if sessionInfo == nil {
+		return ErrSessionTerminated
+	}
+	if err := mgr.online.UpdateNow(ctx, sessionID); err != nil {
+		mgr.logger.Warn("更新会话的活动时间失败", log.String("session", sessionID), log.Error(err))
+	}
+	return nil
+}
+
+// validateSessionCookie 从 cookie 中读会话, 会话已被终止时返回 ErrSessionTerminated
+func (mgr *LoginManager) validateSessionCookie(ctx context.Context, req *http.Request) (context.Context, error) {
+	values, err := mgr.GetSession(req)
+	if err != nil {
+		if err == authclient.ErrCookieNotFound || err == authclient.ErrCookieEmpty {
+			return nil, loong.ErrTokenNotFound
+		}
+		return nil, err
+	}
+	if err := mgr.checkSession(ctx, values.Get(authclient.SESSION_ID_KEY)); err != nil {
+		return nil, err
+	}
+
+	if mgr.userManager == nil {
+		return ctx, nil
+	}
+
+	if impersonation := impersonationFromValues(values); impersonation != nil {
+		ctx = api.ContextWithImpersonation(ctx, impersonation)
+	}
+
+	return api.ContextWithReadCurrentUser(ctx, api.ReadCurrentUserFunc(func(ctx context.Context) (api.User, error) {
+		username := values.Get(authclient.SESSION_USER_KEY)
+		return mgr.userManager.UserByName(ctx, username)
+	})), nil
+}
+
 func (mgr *LoginManager) AuthValidates() []loong.AuthValidateFunc {
 	return []loong.AuthValidateFunc{
 		loong.TokenVerify(
@@ -552,15 +676,22 @@ func (mgr *LoginManager) AuthValidates() []loong.AuthValidateFunc {
 				loong.TokenFromHeader,
 			},
 			[]loong.TokenCheckFunc{
-				tokenToUser(mgr.userManager, loong.JWTCheck(mgr.jwtConfig)),
+				tokenToUser(mgr.userManager, checkTokenSession(mgr.checkSession, loong.JWTCheck(mgr.jwtConfig))),
 			}),
 
+		loong.AuthValidateFunc(mgr.validateSessionCookie),
+
 		loong.AuthValidateFunc(func(ctx context.Context, req *http.Request) (context.Context, error) {
-			values, err := mgr.GetSession(req)
+			if mgr.apiTokens == nil {
+				return nil, loong.ErrTokenNotFound
+			}
+			token := APITokenFromRequest(req)
+			if token == "" {
+				return nil, loong.ErrTokenNotFound
+			}
+
+			userID, err := mgr.apiTokens.Verify(ctx, req, token)
 			if err != nil {
-				if err == authclient.ErrCookieNotFound || err == authclient.ErrCookieEmpty {
-					return nil, loong.ErrTokenNotFound
-				}
 				return nil, err
 			}
 
@@ -569,22 +700,34 @@ func (mgr *LoginManager) AuthValidates() []loong.AuthValidateFunc {
 			}
 
 			return api.ContextWithReadCurrentUser(ctx, api.ReadCurrentUserFunc(func(ctx context.Context) (api.User, error) {
-				username := values.Get(authclient.SESSION_USER_KEY)
-				return mgr.userManager.UserByName(ctx, username)
+				return mgr.userManager.UserByID(ctx, userID)
 			})), nil
 		}),
 	}
 }
 
-func NewLoginManager(env *moo.Environment, cfg *Config, userManager UserManager, online Sessions, locator WelcomeLocator, authOpts []services.AuthOption) (*LoginManager, error) {
+func NewLoginManager(env *moo.Environment, cfg *Config, userManager UserManager, online Sessions, counter services.FailCounter, locator WelcomeLocator, authOpts []services.AuthOption) (*LoginManager, error) {
 	logger := env.Logger.Named("sessions")
 
-	counter := services.CreateFailCounter()
+	if counter == nil {
+		counter = services.CreateFailCounter(env.Config.DurationWithDefault(api.CfgUserLoginFailWindow, 30*time.Minute))
+	}
+	limiter := &sessionLimiter{Sessions: online}
+	notifier := &lockoutNotifier{}
 	opts := []services.AuthOption{
 		services.Whitelist(),
-		services.ErrorCountCheck(userManager, counter, env.Config.IntWithDefault(api.CfgUserMaxLoginFailCount, 3)),
+		services.ErrorCountCheckWith(userManager, counter, services.FailPolicy{
+			MaxUserFailCount:    env.Config.IntWithDefault(api.CfgUserMaxLoginFailCount, 3),
+			MaxAddressFailCount: env.Config.IntWithDefault(api.CfgUserLoginFailMaxAddressCount, 0),
+			DelayBase:           env.Config.DurationWithDefault(api.CfgUserLoginFailDelayBase, 0),
+			DelayMax:            env.Config.DurationWithDefault(api.CfgUserLoginFailDelayMax, 0),
+			OnLockout:           notifier.OnLockout,
+		}),
 		services.LockCheck(),
-		services.OnlineCheck(online, env.Config.StringWithDefault(api.CfgUserLoginConflict, "")),
+		services.PasswordStateCheck(),
+		services.OnlineCheck(limiter, env.Config.StringWithDefault(api.CfgUserLoginConflict, ""),
+			env.Config.IntWithDefault(api.CfgUserMaxSessions, 0),
+			env.Config.StringWithDefault(api.CfgUserMaxSessionsPolicy, services.MaxSessionsReject)),
 		//services.TptInternalUserCheck(env),
 		services.DefaultUserCheck(),
 	}
@@ -626,7 +769,10 @@ func NewLoginManager(env *moo.Environment, cfg *Config, userManager UserManager,
 		authSrv:     authSrv,
 		expiresIn:   1 * time.Hour,
 		jwtConfig:   jwtToken,
+		failCounter: counter,
 	}
+	limiter.mgr = mgr
+	notifier.mgr = mgr
 	return mgr, nil
 }
 
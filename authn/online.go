@@ -2,6 +2,7 @@ package authn
 
 import (
 	"context"
+	"time"
 
 	"github.com/runner-mei/goutils/util"
 	"github.com/runner-mei/errors"
@@ -40,16 +41,42 @@ type SessionInfo struct {
 	Address   string
 	CreatedAt util.UnixTime
 	UpdatedAt util.UnixTime
+
+	// ImpersonatorID 不为 0 时表示这是管理员模拟该用户登录的会话
+	ImpersonatorID int64
 }
 
 type Sessions interface {
 	Login(ctx context.Context, userid interface{}, username, address string) (string, error)
+	// Impersonate 为模拟登录创建一个新的会话, 它不会沿用用户已有的会话, 也不会将用户的其它会话踢下线
+	Impersonate(ctx context.Context, userid interface{}, username, address string, impersonatorID int64) (string, error)
 	Logout(ctx context.Context, key string) error
 
 	services.OnlineChecker
 	Get(ctx context.Context, id string) (*SessionInfo, error)
+	// Query 按用户名和地址查询会话, 按最后活动时间倒序排列, limit 为 0 时不分页
+	Query(ctx context.Context, username, address string, offset, limit int64) ([]SessionInfo, error)
 	All(ctx context.Context) ([]SessionInfo, error)
 	UpdateNow(ctx context.Context, key string) error
+
+	// IsTracked 是否保存了会话, 不保存会话时(如 sessions/empty)无法知道会话是否已被终止
+	IsTracked() bool
+}
+
+// SessionTerminated 会话被终止时发送到 Bus 上的事件
+type SessionTerminated struct {
+	Session  SessionInfo `json:"session"`
+	Reason   string      `json:"reason"`
+	Operator string      `json:"operator,omitempty"`
+}
+
+// UserLogin 用户登录成功并创建会话后发送到 Bus 上的事件
+type UserLogin struct {
+	UserID    interface{} `json:"user_id"`
+	Username  string      `json:"username"`
+	Address   string      `json:"address,omitempty"`
+	SessionID string      `json:"session_id,omitempty"`
+	LoginAt   time.Time   `json:"login_at"`
 }
 
 type SessionsForTest interface {
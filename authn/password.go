@@ -0,0 +1,104 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/authn/services"
+)
+
+// PasswordChanger 用户登录时修改自已的密码, 由 UserManager 选择性地实现
+type PasswordChanger interface {
+	ChangePassword(ctx context.Context, userID interface{}, username, newPassword string) error
+}
+
+// ChangePassword 用户必须修改密码时，在修改密码页面提交新密码，成功后直接登录
+func (mgr *LoginManager) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	authCtx := &services.AuthContext{
+		Logger: mgr.logger,
+		Ctx:    ctx,
+	}
+
+	returnError := func(err error) {
+		authCtx.Logger.Warn("修改密码失败", log.String("username", authCtx.Request.Username),
+			log.String("address", authCtx.Request.Address), log.Error(err))
+
+		e := mgr.Renderer.ChangePassword(authCtx, w, r, "", err)
+		if e != nil {
+			mgr.logger.Warn("生成修改密码页面出错", log.Error(e))
+		}
+	}
+
+	if err := r.ParseForm(); err != nil {
+		returnError(errors.WithHTTPCode(err, http.StatusBadRequest))
+		return
+	}
+
+	authCtx.Request.Username = r.Form.Get("username")
+	authCtx.Request.Password = r.Form.Get("password")
+	authCtx.Request.Service = r.Form.Get("service")
+	authCtx.Request.Address = RealIP(r)
+	authCtx.Request.ClientCertificate = ClientCertificate(r)
+	authCtx.SkipCaptcha = true
+
+	newPassword := r.Form.Get("new_password")
+	if authCtx.Request.Username == "" {
+		returnError(errors.New("用户名不能为空"))
+		return
+	}
+	if authCtx.Request.Password == "" {
+		returnError(errors.New("原密码不能为空"))
+		return
+	}
+	if newPassword == "" {
+		returnError(errors.New("新密码不能为空"))
+		return
+	}
+	if newPassword != r.Form.Get("confirm_password") {
+		returnError(errors.New("两次输入的新密码不一致"))
+		return
+	}
+	if newPassword == authCtx.Request.Password {
+		returnError(errors.New("新密码不能与原密码相同"))
+		return
+	}
+
+	changer, ok := mgr.userManager.(PasswordChanger)
+	if !ok {
+		returnError(errors.New("不支持修改密码"))
+		return
+	}
+
+	err := mgr.authSrv.Auth(authCtx)
+	if err != nil {
+		returnError(errors.WithHTTPCode(err, http.StatusForbidden))
+		return
+	}
+	if !authCtx.Response.IsOK {
+		returnError(errors.New("原密码不正确"))
+		return
+	}
+
+	err = changer.ChangePassword(ctx, authCtx.Request.UserID, authCtx.Request.Username, newPassword)
+	if err != nil {
+		returnError(err)
+		return
+	}
+	authCtx.Response.MustChangePassword = false
+	delete(authCtx.Response.Data, "password_warning")
+
+	if err := mgr.createSession(ctx, authCtx); err != nil {
+		e := mgr.Renderer.ReturnError(authCtx, w, r, err)
+		if e != nil {
+			mgr.logger.Warn("生成登录页面出错", log.Error(e))
+		}
+		return
+	}
+
+	err = mgr.Renderer.LoginOK(authCtx, w, r)
+	if err != nil {
+		mgr.logger.Warn("生成登录页面出错", log.Error(err))
+	}
+}
@@ -115,10 +115,18 @@ func (srv *Renderer) ReturnError(authCtx *services.AuthContext, w http.ResponseW
 	} else if err == services.ErrUserLocked || rawerr == services.ErrUserLocked ||
 		err == services.ErrUserErrorCountExceedLimit || rawerr == services.ErrUserErrorCountExceedLimit {
 		message = gettext.Gettext("错误次数太多，帐号被锁定！")
+	} else if err == services.ErrAddressErrorCountExceedLimit || rawerr == services.ErrAddressErrorCountExceedLimit {
+		message = gettext.Gettext("该地址登录失败的次数太多，请稍后再试")
+	} else if err == services.ErrLoginTooFrequent || rawerr == services.ErrLoginTooFrequent {
+		message = gettext.Gettext("登录失败，请稍后再试")
 	} else if err == services.ErrPermissionDenied || rawerr == services.ErrPermissionDenied {
 		message = gettext.Gettext("用户没有访问权限")
 	} else if err == services.ErrMutiUsers || rawerr == services.ErrMutiUsers {
 		message = gettext.Gettext("同名的用户有多个")
+	} else if err == services.ErrMaxSessionsExceeded || rawerr == services.ErrMaxSessionsExceeded {
+		message = gettext.Gettext("用户同时在线的会话数已达到上限")
+	} else if err == services.ErrPasswordMustChange || rawerr == services.ErrPasswordMustChange {
+		message = gettext.Gettext("密码已过期，请先修改密码")
 	} else if services.IsErrExternalServer(err) {
 		message = err.Error()
 	} else if _, ok := IsOnlinedError(err); ok {
@@ -217,6 +225,36 @@ func (srv *Renderer) renderLogin(ctx context.Context, w http.ResponseWriter, r *
 	return srv.templates.Render(w, r, "login.html", data)
 }
 
+// SetGlobal 设置所有页面都可以用的变量，只能在启动时调用
+func (srv *Renderer) SetGlobal(key string, value interface{}) {
+	srv.data[key] = value
+}
+
+// RenderPage 用登录页面的模板目录显示一个页面，其它模块可以用它来显示自已的页面
+func (srv *Renderer) RenderPage(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["global"] = srv.data
+	data["context_path"] = srv.readContextPath(r)
+	return srv.templates.Render(w, r, name, data)
+}
+
+// ChangePassword 显示修改密码的页面，用户必须修改密码后才能登录
+func (srv *Renderer) ChangePassword(authCtx *services.AuthContext, w http.ResponseWriter, r *http.Request, message string, rawerr error) error {
+	data := map[string]interface{}{
+		"global":       srv.data,
+		"service":      authCtx.Request.Service,
+		"username":     authCtx.Request.Username,
+		"message":      message,
+		"context_path": srv.readContextPath(r),
+	}
+	if rawerr != nil {
+		data["errorMessage"] = rawerr.Error()
+	}
+	return srv.templates.Render(w, r, "change_password.html", data)
+}
+
 func (srv *Renderer) isRootPath(pa string) bool {
 	u, _ := url.Parse(pa)
 	if u != nil {
@@ -234,7 +272,12 @@ func (srv *Renderer) isRootPath(pa string) bool {
 	return false
 }
 
-func (srv *Renderer) LoginOK(authCtx *services.AuthContext, w http.ResponseWriter, r *http.Request) error {
+// SetSessionCookie 将登录成功后的会话写到 cookie 中, authCtx.Response.Data 中的值也会写到会话中
+func (srv *Renderer) SetSessionCookie(authCtx *services.AuthContext, w http.ResponseWriter, r *http.Request) {
+	srv.setSessionCookie(authCtx, nil, w, r)
+}
+
+func (srv *Renderer) setSessionCookie(authCtx *services.AuthContext, impersonation *api.Impersonation, w http.ResponseWriter, r *http.Request) {
 	for _, cookie := range r.Cookies() {
 		if cookie.Name == srv.config.SessionKey &&
 			cookie.Path != srv.config.SessionPath {
@@ -255,7 +298,10 @@ func (srv *Renderer) LoginOK(authCtx *services.AuthContext, w http.ResponseWrite
 			"name",
 			"expired_at",
 			"issued_at",
-			"admin"} {
+			"admin",
+			sessionImpersonatorIDKey,
+			sessionImpersonatorNameKey,
+			sessionImpersonatorSessionKey} {
 			if s == k {
 				found = true
 				break
@@ -272,6 +318,11 @@ func (srv *Renderer) LoginOK(authCtx *services.AuthContext, w http.ResponseWrite
 	values.Set(authclient.SESSION_EXPIRE_KEY, "session")
 	values.Set(authclient.SESSION_VALID_KEY, "true")
 	values.Set(authclient.SESSION_USER_KEY, authCtx.Request.Username)
+	if impersonation != nil {
+		values.Set(sessionImpersonatorIDKey, strconv.FormatInt(impersonation.ImpersonatorID, 10))
+		values.Set(sessionImpersonatorNameKey, impersonation.ImpersonatorName)
+		values.Set(sessionImpersonatorSessionKey, impersonation.SessionID)
+	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     srv.config.SessionKey,
@@ -281,6 +332,10 @@ func (srv *Renderer) LoginOK(authCtx *services.AuthContext, w http.ResponseWrite
 		Secure:   srv.config.SessionSecure,
 		HttpOnly: srv.config.SessionHttpOnly,
 	})
+}
+
+func (srv *Renderer) LoginOK(authCtx *services.AuthContext, w http.ResponseWriter, r *http.Request) error {
+	srv.SetSessionCookie(authCtx, w, r)
 
 	// return c.JSON(http.StatusOK, map[string]interface{}{
 	// 	"userid":     authCtx.Request.UserID,
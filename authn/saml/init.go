@@ -0,0 +1,150 @@
+package saml
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/httputil"
+	"github.com/runner-mei/goutils/urlutil"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/users/usermodels"
+	"go.uber.org/fx"
+)
+
+type Params struct {
+	fx.In
+
+	LoginManager *authn.LoginManager
+	Renderer     *authn.Renderer
+	Sessions     authn.Sessions
+	Users        *usermodels.Users
+}
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return fx.Invoke(func(env *moo.Environment, params Params, httpSrv *moo.HTTPServer, middlewares moo.Middlewares, logger log.Logger) error {
+			rootURL := strings.TrimSpace(env.Config.StringWithDefault(api.CfgUserSamlRootURL, ""))
+			if rootURL == "" {
+				logger.Info("saml skipped")
+				return nil
+			}
+
+			sp, err := NewServiceProvider(env, rootURL)
+			if err != nil {
+				return err
+			}
+
+			fields := map[string]string{}
+			env.Config.ForEachWithPrefix(api.CfgUserSamlFieldPrefix, func(key string, value interface{}) {
+				key = strings.TrimPrefix(key, api.CfgUserSamlFieldPrefix)
+				fields[key] = fmt.Sprint(value)
+			})
+
+			binding := gosaml.HTTPRedirectBinding
+			if strings.ToLower(env.Config.StringWithDefault(api.CfgUserSamlBinding, "redirect")) == "post" {
+				binding = gosaml.HTTPPostBinding
+			}
+
+			samlClient, err := NewSAMLClient(&SAMLOptions{
+				Logger:       logger.Named("saml"),
+				SP:           sp,
+				Renderer:     params.Renderer,
+				Sessions:     params.Sessions,
+				LoginManager: params.LoginManager,
+				Users:        params.Users,
+
+				Binding:           binding,
+				UserPrefix:        env.Config.StringWithDefault(api.CfgUserSamlUserPrefix, ""),
+				UsernameAttribute: env.Config.StringWithDefault(api.CfgUserSamlUsernameAttribute, ""),
+				NicknameAttribute: env.Config.StringWithDefault(api.CfgUserSamlNicknameAttribute, ""),
+				RoleAttribute:     env.Config.StringWithDefault(api.CfgUserSamlRoleAttribute, ""),
+				Fields:            fields,
+				Roles:             env.Config.StringsWithDefault(api.CfgUserSamlRoles, nil),
+			})
+			if err != nil {
+				return err
+			}
+
+			samlPrefix := urlutil.Join(env.DaemonUrlPath, "saml")
+			samlEcho := httpSrv.Engine().Group("saml", middlewares.Funcs...)
+			samlEcho.GET("/metadata", loong.WrapHandlerFunc(samlClient.Metadata))
+			samlEcho.GET("/login", loong.WrapHandlerFunc(samlClient.RedirectToLogin))
+			samlEcho.POST("/acs", loong.WrapHandlerFunc(samlClient.AssertionConsumer))
+			samlEcho.GET("/slo", loong.WrapHandlerFunc(samlClient.SingleLogout))
+			samlEcho.POST("/slo", loong.WrapHandlerFunc(samlClient.SingleLogout))
+			samlEcho.Any("/logout", loong.WrapHandlerFunc(samlClient.Logout))
+			logger.Info("saml started", log.String("prefix", samlPrefix), log.String("entity_id", sp.EntityID))
+			return nil
+		})
+	})
+}
+
+// NewServiceProvider 按配置创建 SP, rootURL 是外部访问 moo 的地址, 如 https://example.com/moo
+func NewServiceProvider(env *moo.Environment, rootURL string) (*gosaml.ServiceProvider, error) {
+	base, err := url.Parse(rootURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "users.saml.root_url is invalid url - '"+rootURL+"'")
+	}
+
+	var idpMetadata *gosaml.EntityDescriptor
+	if filename := env.Config.StringWithDefault(api.CfgUserSamlIdpMetadataFile, ""); filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, errors.Wrap(err, "读取 IdP 的元数据失败")
+		}
+		idpMetadata, err = ParseMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+	} else if metadataURL := env.Config.StringWithDefault(api.CfgUserSamlIdpMetadataURL, ""); metadataURL != "" {
+		idpMetadata, err = FetchMetadata(context.Background(), httputil.InsecureHttpClent, metadataURL)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("users.saml.idp_metadata_url 和 users.saml.idp_metadata_file 都没有配置")
+	}
+
+	certFile := env.Config.StringWithDefault(api.CfgUserSamlCertFile, "")
+	keyFile := env.Config.StringWithDefault(api.CfgUserSamlKeyFile, "")
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("users.saml.cert_file 或 users.saml.key_file 没有配置")
+	}
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取 SP 的证书失败")
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SP 的私钥必须是 RSA 密钥")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "读取 SP 的证书失败")
+	}
+
+	metadataURL := spURL(base, "saml/metadata")
+	entityID := env.Config.StringWithDefault(api.CfgUserSamlEntityID, metadataURL.String())
+	return &gosaml.ServiceProvider{
+		EntityID:          entityID,
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       metadataURL,
+		AcsURL:            spURL(base, "saml/acs"),
+		SloURL:            spURL(base, "saml/slo"),
+		IDPMetadata:       idpMetadata,
+		AllowIDPInitiated: env.Config.BoolWithDefault(api.CfgUserSamlAllowIdpInitiated, true),
+	}, nil
+}
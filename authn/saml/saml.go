@@ -0,0 +1,646 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	gosaml "github.com/crewjam/saml"
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api/authclient"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/users/usermodels"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// SAMLOptions configuration options
+type SAMLOptions struct {
+	Logger       log.Logger
+	SP           *gosaml.ServiceProvider
+	Renderer     *authn.Renderer
+	Sessions     authn.Sessions
+	LoginManager *authn.LoginManager
+	Users        *usermodels.Users
+
+	// Binding 发送 AuthnRequest 使用的绑定, 为 gosaml.HTTPRedirectBinding 或 gosaml.HTTPPostBinding
+	Binding           string
+	UserPrefix        string
+	UsernameAttribute string
+	NicknameAttribute string
+	RoleAttribute     string
+	Fields            map[string]string
+	Roles             []string
+	SessionExpires    time.Duration
+}
+
+// SAMLClient 是一个 SAML 2.0 的 SP, 支持 SP 和 IdP 发起的登录以及单点退出
+type SAMLClient struct {
+	logger       log.Logger
+	sp           *gosaml.ServiceProvider
+	renderer     *authn.Renderer
+	sessions     authn.Sessions
+	loginManager *authn.LoginManager
+	users        *usermodels.Users
+
+	binding           string
+	userPrefix        string
+	usernameAttribute string
+	nicknameAttribute string
+	roleAttribute     string
+	fields            map[string]string
+	roles             []int64
+
+	requests     *requestTracker
+	samlSessions *sessionStore
+}
+
+// NewSAMLClient creates a Client with the provided Options.
+func NewSAMLClient(options *SAMLOptions) (*SAMLClient, error) {
+	var roles []int64
+	for _, roleName := range options.Roles {
+		roleName = strings.TrimSpace(roleName)
+		if roleName == "" {
+			continue
+		}
+
+		role, err := options.Users.GetRoleByName(context.Background(), roleName)
+		if err != nil {
+			return nil, errors.Wrap(err, "加载角色 '"+roleName+"' 失败")
+		}
+		roles = append(roles, role.ID)
+	}
+
+	binding := options.Binding
+	if binding == "" {
+		binding = gosaml.HTTPRedirectBinding
+	}
+	sessionExpires := options.SessionExpires
+	if sessionExpires <= 0 {
+		sessionExpires = 24 * time.Hour
+	}
+
+	return &SAMLClient{
+		logger:       options.Logger,
+		sp:           options.SP,
+		renderer:     options.Renderer,
+		sessions:     options.Sessions,
+		loginManager: options.LoginManager,
+		users:        options.Users,
+
+		binding:           binding,
+		userPrefix:        options.UserPrefix,
+		usernameAttribute: options.UsernameAttribute,
+		nicknameAttribute: options.NicknameAttribute,
+		roleAttribute:     options.RoleAttribute,
+		fields:            options.Fields,
+		roles:             roles,
+
+		requests:     newRequestTracker(10 * time.Minute),
+		samlSessions: newSessionStore(sessionExpires),
+	}, nil
+}
+
+// ParseMetadata 解析 IdP 的元数据, 支持 EntityDescriptor 和 EntitiesDescriptor
+func ParseMetadata(data []byte) (*gosaml.EntityDescriptor, error) {
+	entity := &gosaml.EntityDescriptor{}
+	err := xml.Unmarshal(data, entity)
+	if err == nil {
+		return entity, nil
+	}
+
+	entities := &gosaml.EntitiesDescriptor{}
+	if e := xml.Unmarshal(data, entities); e != nil {
+		return nil, errors.Wrap(err, "IdP 的元数据格式不正确")
+	}
+	for idx := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[idx].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[idx], nil
+		}
+	}
+	return nil, errors.New("IdP 的元数据中没有找到 IDPSSODescriptor")
+}
+
+// FetchMetadata 从 URL 下载 IdP 的元数据
+func FetchMetadata(ctx context.Context, client *http.Client, metadataURL string) (*gosaml.EntityDescriptor, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "下载 IdP 的元数据失败")
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "下载 IdP 的元数据失败")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("下载 IdP 的元数据失败: " + resp.Status)
+	}
+	return ParseMetadata(data)
+}
+
+// safeRelayState 只允许跳转到本站的相对路径，防止开放重定向
+func safeRelayState(s string) string {
+	if s == "" || !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return ""
+	}
+	return s
+}
+
+// Metadata 返回 SP 的元数据
+func (c *SAMLClient) Metadata(w http.ResponseWriter, r *http.Request) {
+	buf, err := xml.MarshalIndent(c.sp.Metadata(), "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(buf)
+}
+
+func writePostForm(w http.ResponseWriter, form []byte) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, "<!DOCTYPE html><html><body>")
+	w.Write(form)
+	io.WriteString(w, "</body></html>")
+}
+
+// RedirectToLogin 发起 SP 登录，参数 redirect 为登录成功后跳转的地址
+func (c *SAMLClient) RedirectToLogin(w http.ResponseWriter, r *http.Request) {
+	binding := c.binding
+	location := c.sp.GetSSOBindingLocation(binding)
+	if location == "" {
+		if binding == gosaml.HTTPRedirectBinding {
+			binding = gosaml.HTTPPostBinding
+		} else {
+			binding = gosaml.HTTPRedirectBinding
+		}
+		location = c.sp.GetSSOBindingLocation(binding)
+	}
+	if location == "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "IdP 的元数据中没有找到 SSO 地址")
+		return
+	}
+
+	req, err := c.sp.MakeAuthenticationRequest(location, binding, gosaml.HTTPPostBinding)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	c.requests.Add(req.ID)
+
+	relayState := safeRelayState(r.URL.Query().Get("redirect"))
+	c.logger.Info("Logging in, redirecting client to idp", log.String("location", location), log.String("binding", binding))
+
+	if binding == gosaml.HTTPRedirectBinding {
+		u, err := req.Redirect(relayState, c.sp)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, err.Error())
+			return
+		}
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+	writePostForm(w, req.Post(relayState))
+}
+
+// parseResponse 验证 IdP 发回的 Response, 签名、InResponseTo、Audience 和有效期由 gosaml 检查,
+// 不允许 IdP 发起的登录时 InResponseTo 必须是 SP 发出的 AuthnRequest 的 ID
+func (c *SAMLClient) parseResponse(r *http.Request) (*gosaml.Assertion, error) {
+	assertion, err := c.sp.ParseResponse(r, c.requests.PossibleIDs())
+	if err != nil {
+		if target, ok := err.(*gosaml.InvalidResponseError); ok && target.PrivateErr != nil {
+			return nil, target.PrivateErr
+		}
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// AssertionConsumer 处理 IdP 用 HTTP-POST 发回的 Response, SP 和 IdP 发起的登录都由它处理
+func (c *SAMLClient) AssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	assertion, err := c.parseResponse(r)
+	if err != nil {
+		c.logger.Warn("SAML 断言验证失败", log.Error(err))
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "SAML 断言验证失败")
+		return
+	}
+
+	attributes := ReadAttributes(assertion)
+	username := c.username(assertion, attributes)
+	if username == "" {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "SAML 断言中没有用户名")
+		return
+	}
+
+	user, isNewUser, err := c.loadOrCreateUser(r.Context(), username, attributes)
+	if err != nil {
+		c.logger.Warn("SAML 登录时创建或更新用户失败", log.String("username", username), log.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	if user.IsDisabled() {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "用户 '"+username+"' 已被禁用")
+		return
+	}
+
+	sessionID, err := c.sessions.Login(r.Context(), user.ID, user.Name, authn.RealIP(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "创建在线用户信息失败：\r\n")
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	sess := Session{SessionID: sessionID, Username: user.Name}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		sess.NameID = assertion.Subject.NameID.Value
+	}
+	for _, stmt := range assertion.AuthnStatements {
+		if stmt.SessionIndex != "" {
+			sess.SessionIndex = stmt.SessionIndex
+			break
+		}
+	}
+	c.samlSessions.Put(sess)
+
+	redirect := safeRelayState(r.Form.Get("RelayState"))
+	c.logger.Info("Login successful, redirecting to system.", log.String("username", username), log.String("redirect", redirect))
+
+	authCtx := &services.AuthContext{
+		Logger: c.logger,
+		Request: services.LoginRequest{
+			UserID:   user.ID,
+			Username: user.Name,
+			Service:  redirect,
+		},
+		Response: services.LoginResult{
+			IsOK:      true,
+			SessionID: sessionID,
+			IsNewUser: isNewUser,
+		},
+	}
+	c.renderer.LoginOK(authCtx, w, r)
+}
+
+// Logout 退出本地会话，用户是通过 SAML 登录时再跳到 IdP 退出
+func (c *SAMLClient) Logout(w http.ResponseWriter, r *http.Request) {
+	var sess *Session
+	if values, err := c.loginManager.GetSession(r); err == nil {
+		if sessionID := values.Get(authclient.SESSION_ID_KEY); sessionID != "" {
+			sess = c.samlSessions.GetBySessionID(sessionID)
+			if err := c.loginManager.Terminate(r.Context(), sessionID, "saml_logout", values.Get(authclient.SESSION_USER_KEY)); err != nil && !errors.IsNotFound(err) {
+				c.logger.Warn("退出时终止会话失败", log.String("session", sessionID), log.Error(err))
+			}
+			c.samlSessions.Delete(sessionID)
+		}
+	}
+
+	if sess == nil || sess.NameID == "" || c.sp.GetSLOBindingLocation(gosaml.HTTPRedirectBinding) == "" {
+		c.renderer.Logout(r.Context(), w, r)
+		return
+	}
+
+	u, err := c.sp.MakeRedirectLogoutRequest(sess.NameID, safeRelayState(r.URL.Query().Get("redirect")))
+	if err != nil {
+		c.logger.Warn("生成 LogoutRequest 失败", log.Error(err))
+		c.renderer.Logout(r.Context(), w, r)
+		return
+	}
+	c.logger.Info("Logging out, redirecting client to idp", log.String("username", sess.Username))
+	c.renderer.LogoutWithRedirect(r.Context(), w, r, u.String())
+}
+
+// logoutRequest IdP 发来的 LogoutRequest
+type logoutRequest struct {
+	XMLName       xml.Name `xml:"LogoutRequest"`
+	ID            string   `xml:"ID,attr"`
+	Issuer        string   `xml:"Issuer"`
+	NameID        string   `xml:"NameID"`
+	SessionIndexs []string `xml:"SessionIndex"`
+}
+
+// decodeMessage 解码 SAMLRequest 或 SAMLResponse, deflated 为 true 表示是 HTTP-Redirect 绑定
+func decodeMessage(s string, deflated bool) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if deflated {
+		data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// decodeLogoutRequest 解析 LogoutRequest, deflated 为 true 表示是 HTTP-Redirect 绑定
+func decodeLogoutRequest(s string, deflated bool) (*logoutRequest, error) {
+	data, err := decodeMessage(s, deflated)
+	if err != nil {
+		return nil, errors.Wrap(err, "SAMLRequest 格式不正确")
+	}
+	return parseLogoutRequest(data)
+}
+
+func parseLogoutRequest(data []byte) (*logoutRequest, error) {
+	var req logoutRequest
+	if err := xml.Unmarshal(data, &req); err != nil {
+		return nil, errors.Wrap(err, "SAMLRequest 格式不正确")
+	}
+	req.Issuer = strings.TrimSpace(req.Issuer)
+	req.NameID = strings.TrimSpace(req.NameID)
+	for idx := range req.SessionIndexs {
+		req.SessionIndexs[idx] = strings.TrimSpace(req.SessionIndexs[idx])
+	}
+	return &req, nil
+}
+
+var whiteSpace = regexp.MustCompile(`\s+`)
+
+// idpSigningCerts 返回 IdP 元数据中用于签名的证书
+func idpSigningCerts(metadata *gosaml.EntityDescriptor) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, idpsso := range metadata.IDPSSODescriptors {
+		for _, keyDescriptor := range idpsso.KeyDescriptors {
+			if keyDescriptor.Use != "" && keyDescriptor.Use != "signing" {
+				continue
+			}
+			for _, x509Cert := range keyDescriptor.KeyInfo.X509Data.X509Certificates {
+				data, err := base64.StdEncoding.DecodeString(whiteSpace.ReplaceAllString(x509Cert.Data, ""))
+				if err != nil {
+					return nil, errors.Wrap(err, "IdP 元数据中的证书格式不正确")
+				}
+				cert, err := x509.ParseCertificate(data)
+				if err != nil {
+					return nil, errors.Wrap(err, "IdP 元数据中的证书格式不正确")
+				}
+				certs = append(certs, cert)
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("IdP 元数据中没有签名用的证书")
+	}
+	return certs, nil
+}
+
+// verifyRedirectSignature 验证 HTTP-Redirect 绑定的签名, 签名的内容是原始查询串中的
+// "SAMLRequest=...&RelayState=...&SigAlg=...", 所以必须用没有解码过的值, key 为 SAMLRequest 或 SAMLResponse
+func verifyRedirectSignature(rawQuery, key string, certs []*x509.Certificate) error {
+	values := map[string]string{}
+	for _, part := range strings.Split(rawQuery, "&") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if _, exists := values[kv[0]]; !exists {
+			values[kv[0]] = kv[1]
+		}
+	}
+	if values["Signature"] == "" || values["SigAlg"] == "" {
+		return errors.New(key + " 没有签名")
+	}
+
+	sigAlg, err := url.QueryUnescape(values["SigAlg"])
+	if err != nil {
+		return errors.Wrap(err, "SigAlg 格式不正确")
+	}
+	var hash crypto.Hash
+	switch sigAlg {
+	case dsig.RSASHA1SignatureMethod:
+		hash = crypto.SHA1
+	case dsig.RSASHA256SignatureMethod:
+		hash = crypto.SHA256
+	case dsig.RSASHA512SignatureMethod:
+		hash = crypto.SHA512
+	default:
+		return errors.New("不支持签名算法 '" + sigAlg + "'")
+	}
+
+	s, err := url.QueryUnescape(values["Signature"])
+	if err != nil {
+		return errors.Wrap(err, "Signature 格式不正确")
+	}
+	signature, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return errors.Wrap(err, "Signature 格式不正确")
+	}
+
+	signed := key + "=" + values[key]
+	if relayState, exists := values["RelayState"]; exists {
+		signed += "&RelayState=" + relayState
+	}
+	signed += "&SigAlg=" + values["SigAlg"]
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	for _, cert := range certs {
+		publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(publicKey, hash, digest, signature) == nil {
+			return nil
+		}
+	}
+	return errors.New(key + " 的签名不正确")
+}
+
+// verifyXMLSignature 验证 HTTP-POST 绑定中根元素的 XML 签名, 返回签名覆盖的内容, 调用者只能使用返回的内容
+func verifyXMLSignature(data []byte, certs []*x509.Certificate) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, errors.Wrap(err, "SAMLRequest 格式不正确")
+	}
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("SAMLRequest 格式不正确")
+	}
+	if root.FindElement("./Signature") == nil {
+		return nil, errors.New("SAMLRequest 没有签名")
+	}
+
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	validationContext.IdAttribute = "ID"
+	validated, err := validationContext.Validate(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "SAMLRequest 的签名不正确")
+	}
+
+	out := etree.NewDocument()
+	out.SetRoot(validated)
+	return out.WriteToBytes()
+}
+
+// readLogoutRequest 读取并验证 IdP 发来的 LogoutRequest, 没有签名或签名不正确时返回错误
+func (c *SAMLClient) readLogoutRequest(r *http.Request) (*logoutRequest, error) {
+	certs, err := idpSigningCerts(c.sp.IDPMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *logoutRequest
+	if r.Method == http.MethodGet {
+		if err := verifyRedirectSignature(r.URL.RawQuery, "SAMLRequest", certs); err != nil {
+			return nil, err
+		}
+		req, err = decodeLogoutRequest(r.URL.Query().Get("SAMLRequest"), true)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := decodeMessage(r.PostForm.Get("SAMLRequest"), false)
+		if err != nil {
+			return nil, errors.Wrap(err, "SAMLRequest 格式不正确")
+		}
+		data, err = verifyXMLSignature(data, certs)
+		if err != nil {
+			return nil, err
+		}
+		req, err = parseLogoutRequest(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Issuer != c.sp.IDPMetadata.EntityID {
+		return nil, errors.New("LogoutRequest 的 Issuer '" + req.Issuer + "' 不是 '" + c.sp.IDPMetadata.EntityID + "'")
+	}
+	return req, nil
+}
+
+// validateLogoutResponse 验证 SP 发起退出后 IdP 发回的 LogoutResponse,
+// gosaml 只验证 XML 中的签名, HTTP-Redirect 绑定的签名在查询参数中, 所以自已验证
+func (c *SAMLClient) validateLogoutResponse(r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return c.sp.ValidateLogoutResponseForm(r.PostForm.Get("SAMLResponse"))
+	}
+
+	certs, err := idpSigningCerts(c.sp.IDPMetadata)
+	if err != nil {
+		return err
+	}
+	if err := verifyRedirectSignature(r.URL.RawQuery, "SAMLResponse", certs); err != nil {
+		return err
+	}
+	data, err := decodeMessage(r.URL.Query().Get("SAMLResponse"), true)
+	if err != nil {
+		return errors.Wrap(err, "SAMLResponse 格式不正确")
+	}
+	var resp gosaml.LogoutResponse
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return errors.Wrap(err, "SAMLResponse 格式不正确")
+	}
+	if resp.Destination != c.sp.SloURL.String() {
+		return errors.New("LogoutResponse 的 Destination '" + resp.Destination + "' 不是 '" + c.sp.SloURL.String() + "'")
+	}
+	if resp.Issuer == nil || resp.Issuer.Value != c.sp.IDPMetadata.EntityID {
+		return errors.New("LogoutResponse 的 Issuer 不是 '" + c.sp.IDPMetadata.EntityID + "'")
+	}
+	if resp.Status.StatusCode.Value != gosaml.StatusSuccess {
+		return errors.New("LogoutResponse 的状态为 '" + resp.Status.StatusCode.Value + "'")
+	}
+	return nil
+}
+
+// SingleLogout 处理 IdP 发来的 LogoutRequest 和 LogoutResponse, 它们都必须有 IdP 的签名
+func (c *SAMLClient) SingleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	if r.Form.Get("SAMLResponse") != "" {
+		// SP 发起的退出，IdP 退出后跳回来
+		if err := c.validateLogoutResponse(r); err != nil {
+			c.logger.Warn("LogoutResponse 验证失败", log.Error(err))
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "LogoutResponse 验证失败")
+			return
+		}
+		if redirect := safeRelayState(r.Form.Get("RelayState")); redirect != "" {
+			http.Redirect(w, r, redirect, http.StatusFound)
+			return
+		}
+		c.renderer.Logout(r.Context(), w, r)
+		return
+	}
+
+	if r.Form.Get("SAMLRequest") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "SAMLRequest is missing")
+		return
+	}
+	req, err := c.readLogoutRequest(r)
+	if err != nil {
+		c.logger.Warn("LogoutRequest 验证失败", log.Error(err))
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "LogoutRequest 验证失败")
+		return
+	}
+
+	// 没有 SessionIndex 时不知道要退出的是哪个会话
+	if len(req.SessionIndexs) == 0 {
+		c.logger.Warn("LogoutRequest 中没有 SessionIndex, 忽略它", log.String("name_id", req.NameID))
+	} else {
+		for _, sess := range c.samlSessions.Take(req.NameID, req.SessionIndexs) {
+			err := c.loginManager.Terminate(r.Context(), sess.SessionID, "saml_logout", "")
+			if err != nil && !errors.IsNotFound(err) {
+				c.logger.Warn("单点退出时终止会话失败", log.String("session", sess.SessionID), log.Error(err))
+				continue
+			}
+			c.logger.Info("单点退出成功", log.String("username", sess.Username), log.String("session", sess.SessionID))
+		}
+	}
+
+	u, err := c.sp.MakeRedirectLogoutResponse(req.ID, r.Form.Get("RelayState"))
+	if err != nil {
+		c.logger.Warn("生成 LogoutResponse 失败", log.Error(err))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// spURL 生成 SP 中某个地址的完整 URL
+func spURL(base *url.URL, pa string) url.URL {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(pa, "/")
+	return u
+}
@@ -0,0 +1,490 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	gosaml "github.com/crewjam/saml"
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/users/usermodels"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+func TestParseMetadata(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/idp_metadata.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entity.EntityID != "https://idp.example.com/idp/shibboleth" {
+		t.Error("got", entity.EntityID)
+	}
+
+	sp := &gosaml.ServiceProvider{IDPMetadata: entity}
+	if location := sp.GetSSOBindingLocation(gosaml.HTTPPostBinding); location != "https://idp.example.com/idp/profile/SAML2/POST/SSO" {
+		t.Error("got", location)
+	}
+	if location := sp.GetSLOBindingLocation(gosaml.HTTPRedirectBinding); location != "https://idp.example.com/idp/profile/SAML2/Redirect/SLO" {
+		t.Error("got", location)
+	}
+}
+
+const logoutRequestXML = `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_lr1" Version="2.0">
+  <saml:Issuer>https://idp.example.com/idp/shibboleth</saml:Issuer>
+  <saml:NameID>tom@example.com</saml:NameID>
+  <samlp:SessionIndex>_s1</samlp:SessionIndex>
+</samlp:LogoutRequest>`
+
+func TestDecodeLogoutRequest(t *testing.T) {
+	req, err := decodeLogoutRequest(base64.StdEncoding.EncodeToString([]byte(logoutRequestXML)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != "_lr1" || req.NameID != "tom@example.com" || !reflect.DeepEqual(req.SessionIndexs, []string{"_s1"}) {
+		t.Error("got", req)
+	}
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write([]byte(logoutRequestXML))
+	w.Close()
+	req, err = decodeLogoutRequest(base64.StdEncoding.EncodeToString(buf.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != "_lr1" {
+		t.Error("got", req.ID)
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	store := newSessionStore(time.Minute)
+	store.Put(Session{SessionID: "a", Username: "tom", NameID: "tom@example.com", SessionIndex: "_s1"})
+	store.Put(Session{SessionID: "b", Username: "tom", NameID: "tom@example.com", SessionIndex: "_s2"})
+
+	if sess := store.GetBySessionID("a"); sess == nil || sess.SessionIndex != "_s1" {
+		t.Error("got", sess)
+	}
+	if list := store.Take("tom@example.com", []string{"_s2"}); len(list) != 1 || list[0].SessionID != "b" {
+		t.Error("got", list)
+	}
+	if list := store.Take("jerry@example.com", []string{"_s1"}); len(list) != 0 {
+		t.Error("got", list)
+	}
+	if sess := store.GetBySessionID("b"); sess != nil {
+		t.Error("session b should be removed")
+	}
+}
+
+func TestReadAttributes(t *testing.T) {
+	assertion := &gosaml.Assertion{
+		AttributeStatements: []gosaml.AttributeStatement{{
+			Attributes: []gosaml.Attribute{{
+				Name:         "urn:oid:0.9.2342.19200300.100.1.3",
+				FriendlyName: "mail",
+				Values:       []gosaml.AttributeValue{{Value: "tom@example.com"}},
+			}, {
+				Name:   "groups",
+				Values: []gosaml.AttributeValue{{Value: "admins"}, {Value: "ops"}},
+			}},
+		}},
+	}
+	attributes := ReadAttributes(assertion)
+	if attributes.Get("mail") != "tom@example.com" || attributes.Get("urn:oid:0.9.2342.19200300.100.1.3") != "tom@example.com" {
+		t.Error("got", attributes)
+	}
+	if !reflect.DeepEqual(attributes["groups"], []string{"admins", "ops"}) {
+		t.Error("got", attributes["groups"])
+	}
+}
+
+func TestSafeRelayState(t *testing.T) {
+	for s, excepted := range map[string]string{
+		"/moo/home":            "/moo/home",
+		"":                     "",
+		"https://evil.example": "",
+		"//evil.example":       "",
+		"/\\evil.example":      "",
+	} {
+		if actual := safeRelayState(s); actual != excepted {
+			t.Error(s, ": want", excepted, "got", actual)
+		}
+	}
+}
+
+func loadKeyPair(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	keyPair, err := tls.LoadX509KeyPair("testdata/"+name+"_cert.pem", "testdata/"+name+"_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair.PrivateKey.(*rsa.PrivateKey), cert
+}
+
+// newTestClient 创建一个 SP 和一个用 testdata 中的密钥签名的 IdP, IdP 的证书在 idp_metadata.xml 中
+func newTestClient(t *testing.T, allowIDPInitiated bool) (*SAMLClient, *gosaml.IdentityProvider) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile("testdata/idp_metadata.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idpMetadata, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, _ := url.Parse("https://sp.example.com/moo")
+	spKey, spCert := loadKeyPair(t, "sp")
+	sp := &gosaml.ServiceProvider{
+		EntityID:          "https://sp.example.com/moo/saml/metadata",
+		Key:               spKey,
+		Certificate:       spCert,
+		MetadataURL:       spURL(base, "saml/metadata"),
+		AcsURL:            spURL(base, "saml/acs"),
+		SloURL:            spURL(base, "saml/slo"),
+		IDPMetadata:       idpMetadata,
+		AllowIDPInitiated: allowIDPInitiated,
+	}
+
+	idpKey, idpCert := loadKeyPair(t, "idp")
+	idpURL, _ := url.Parse(idpMetadata.EntityID)
+	idp := &gosaml.IdentityProvider{
+		Key:         idpKey,
+		Certificate: idpCert,
+		MetadataURL: *idpURL,
+	}
+
+	c := &SAMLClient{
+		logger:       log.Empty(),
+		sp:           sp,
+		requests:     newRequestTracker(time.Minute),
+		samlSessions: newSessionStore(time.Minute),
+	}
+	return c, idp
+}
+
+// makeResponse 用 IdP 的密钥生成一个签了名的 Response, requestID 为空时表示 IdP 发起的登录
+func makeResponse(t *testing.T, idp *gosaml.IdentityProvider, sp *gosaml.ServiceProvider, requestID string) *etree.Document {
+	t.Helper()
+
+	spMetadata := sp.Metadata()
+	spsso := spMetadata.SPSSODescriptors[0]
+	// 不加密断言
+	spsso.KeyDescriptors = nil
+
+	req := &gosaml.IdpAuthnRequest{
+		IDP:                     idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, "https://idp.example.com/idp/profile/SAML2/POST/SSO", nil),
+		ServiceProviderMetadata: spMetadata,
+		SPSSODescriptor:         &spsso,
+		ACSEndpoint:             &spsso.AssertionConsumerServices[0],
+		Now:                     gosaml.TimeNow(),
+	}
+	req.Request.ID = requestID
+
+	err := gosaml.DefaultAssertionMaker{}.MakeAssertion(req, &gosaml.Session{
+		ID:        "_s1",
+		Index:     "_s1",
+		NameID:    "tom@example.com",
+		UserName:  "tom",
+		UserEmail: "tom@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.MakeResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+	return doc
+}
+
+func newACSRequest(t *testing.T, doc *etree.Document) *http.Request {
+	t.Helper()
+
+	buf, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Set("SAMLResponse", base64.StdEncoding.EncodeToString(buf))
+	form.Set("RelayState", "/moo/home")
+
+	r := httptest.NewRequest(http.MethodPost, "https://sp.example.com/moo/saml/acs", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+type testUserDao struct {
+	usermodels.UserDao
+
+	users []usermodels.User
+}
+
+func (dao *testUserDao) GetUserByName(ctx context.Context, name string) func(*usermodels.User) error {
+	return func(user *usermodels.User) error {
+		for idx := range dao.users {
+			if dao.users[idx].Name == name {
+				*user = dao.users[idx]
+				return nil
+			}
+		}
+		return errors.ErrNotFoundWithText("该用户不存在!")
+	}
+}
+
+func TestAssertionConsumer(t *testing.T) {
+	c, idp := newTestClient(t, false)
+
+	authnRequest, err := c.sp.MakeAuthenticationRequest(c.sp.GetSSOBindingLocation(gosaml.HTTPPostBinding), gosaml.HTTPPostBinding, gosaml.HTTPPostBinding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.requests.Add(authnRequest.ID)
+
+	assertion, err := c.parseResponse(newACSRequest(t, makeResponse(t, idp, c.sp, authnRequest.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value != "tom@example.com" {
+		t.Errorf("%#v", assertion.Subject)
+	}
+	if attributes := ReadAttributes(assertion); attributes.Get("uid") != "tom" {
+		t.Error("got", attributes)
+	}
+
+	// 验证通过后才会去读用户, 用户被禁用时返回 "已被禁用"
+	c.users = &usermodels.Users{UserDao: &testUserDao{users: []usermodels.User{
+		{ID: 1, Name: "tom@example.com", Source: "saml", Disabled: true},
+	}}}
+	w := httptest.NewRecorder()
+	c.AssertionConsumer(w, newACSRequest(t, makeResponse(t, idp, c.sp, authnRequest.ID)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "已被禁用") {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	// 没有签名的 Response
+	unsigned := makeResponse(t, idp, c.sp, authnRequest.ID)
+	for _, el := range unsigned.FindElements("//Signature") {
+		el.Parent().RemoveChild(el)
+	}
+	w = httptest.NewRecorder()
+	c.AssertionConsumer(w, newACSRequest(t, unsigned))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "SAML 断言验证失败") {
+		t.Error("unsigned response is accepted -", w.Code, w.Body.String())
+	}
+
+	// 签名后被修改的 Response
+	tampered := makeResponse(t, idp, c.sp, authnRequest.ID)
+	tampered.FindElement("//Assertion/Subject/NameID").SetText("admin@example.com")
+	w = httptest.NewRecorder()
+	c.AssertionConsumer(w, newACSRequest(t, tampered))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "SAML 断言验证失败") {
+		t.Error("tampered response is accepted -", w.Code, w.Body.String())
+	}
+
+	// 用不在元数据中的密钥签名的 Response
+	forged := *idp
+	forged.Key, forged.Certificate = loadKeyPair(t, "sp")
+	if _, err := c.parseResponse(newACSRequest(t, makeResponse(t, &forged, c.sp, authnRequest.ID))); err == nil {
+		t.Error("response isnot verified with certificate in metadata")
+	}
+}
+
+func TestIDPInitiated(t *testing.T) {
+	// 没有发出过 AuthnRequest, InResponseTo 为空
+	c, idp := newTestClient(t, true)
+	assertion, err := c.parseResponse(newACSRequest(t, makeResponse(t, idp, c.sp, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Subject.NameID.Value != "tom@example.com" {
+		t.Error("got", assertion.Subject.NameID.Value)
+	}
+
+	c, idp = newTestClient(t, false)
+	if _, err := c.parseResponse(newACSRequest(t, makeResponse(t, idp, c.sp, ""))); err == nil {
+		t.Error("idp initiated login is accepted")
+	}
+	if _, err := c.parseResponse(newACSRequest(t, makeResponse(t, idp, c.sp, "id-unknown"))); err == nil {
+		t.Error("response to unknown request is accepted")
+	}
+}
+
+// redirectQuery 按 HTTP-Redirect 绑定生成查询串, key 不为空时用它签名
+func redirectQuery(t *testing.T, name, message string, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write([]byte(message))
+	w.Close()
+
+	query := name + "=" + url.QueryEscape(base64.StdEncoding.EncodeToString(buf.Bytes())) +
+		"&RelayState=" + url.QueryEscape("/moo/home")
+	if key == nil {
+		return query
+	}
+	query += "&SigAlg=" + url.QueryEscape(dsig.RSASHA256SignatureMethod)
+
+	digest := sha256.Sum256([]byte(query))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query + "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+}
+
+func newSLORequest(t *testing.T, method, query string) *http.Request {
+	t.Helper()
+
+	var r *http.Request
+	if method == http.MethodGet {
+		r = httptest.NewRequest(http.MethodGet, "https://sp.example.com/moo/saml/slo?"+query, nil)
+	} else {
+		r = httptest.NewRequest(http.MethodPost, "https://sp.example.com/moo/saml/slo", strings.NewReader(query))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestLogoutRequestRedirectSignature(t *testing.T) {
+	c, _ := newTestClient(t, false)
+	idpKey, _ := loadKeyPair(t, "idp")
+	spKey, _ := loadKeyPair(t, "sp")
+
+	req, err := c.readLogoutRequest(newSLORequest(t, http.MethodGet, redirectQuery(t, "SAMLRequest", logoutRequestXML, idpKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.NameID != "tom@example.com" || !reflect.DeepEqual(req.SessionIndexs, []string{"_s1"}) {
+		t.Error("got", req)
+	}
+
+	signed := redirectQuery(t, "SAMLRequest", logoutRequestXML, idpKey)
+	otherIssuer := strings.Replace(logoutRequestXML, "https://idp.example.com/idp/shibboleth", "https://evil.example.com/idp", 1)
+	for name, query := range map[string]string{
+		"unsigned":  redirectQuery(t, "SAMLRequest", logoutRequestXML, nil),
+		"forged":    redirectQuery(t, "SAMLRequest", logoutRequestXML, spKey),
+		"tampered":  strings.Replace(signed, "RelayState=", "RelayState=%2Fadmin", 1),
+		"no_sigalg": strings.Replace(signed, "&SigAlg=", "&Alg=", 1),
+		"issuer":    redirectQuery(t, "SAMLRequest", otherIssuer, idpKey),
+	} {
+		if _, err := c.readLogoutRequest(newSLORequest(t, http.MethodGet, query)); err == nil {
+			t.Error(name, "logout request is accepted")
+		}
+	}
+}
+
+func TestLogoutRequestPostSignature(t *testing.T) {
+	c, _ := newTestClient(t, false)
+
+	sign := func(cert tls.Certificate) string {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromString(logoutRequestXML); err != nil {
+			t.Fatal(err)
+		}
+		signed, err := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(cert)).SignEnveloped(doc.Root())
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.SetRoot(signed)
+		buf, err := doc.WriteToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(buf)
+	}
+	idpCert, err := tls.LoadX509KeyPair("testdata/idp_cert.pem", "testdata/idp_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spCert, err := tls.LoadX509KeyPair("testdata/sp_cert.pem", "testdata/sp_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"SAMLRequest": {sign(idpCert)}}
+	req, err := c.readLogoutRequest(newSLORequest(t, http.MethodPost, form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != "_lr1" || req.NameID != "tom@example.com" {
+		t.Error("got", req)
+	}
+
+	for name, value := range map[string]string{
+		"unsigned": base64.StdEncoding.EncodeToString([]byte(logoutRequestXML)),
+		"forged":   sign(spCert),
+	} {
+		form := url.Values{"SAMLRequest": {value}}
+		if _, err := c.readLogoutRequest(newSLORequest(t, http.MethodPost, form.Encode())); err == nil {
+			t.Error(name, "logout request is accepted")
+		}
+	}
+}
+
+func TestSingleLogoutRejectUnsigned(t *testing.T) {
+	c, _ := newTestClient(t, false)
+	c.samlSessions.Put(Session{SessionID: "a", Username: "tom", NameID: "tom@example.com", SessionIndex: "_s1"})
+
+	w := httptest.NewRecorder()
+	c.SingleLogout(w, newSLORequest(t, http.MethodGet, redirectQuery(t, "SAMLRequest", logoutRequestXML, nil)))
+	if w.Code != http.StatusForbidden {
+		t.Error("unsigned logout request is accepted -", w.Code, w.Body.String())
+	}
+	if sess := c.samlSessions.GetBySessionID("a"); sess == nil {
+		t.Error("session is terminated by unsigned logout request")
+	}
+
+	logoutResponseXML := `<samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_lp1" Version="2.0" Destination="https://sp.example.com/moo/saml/slo">
+  <saml:Issuer>https://idp.example.com/idp/shibboleth</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+</samlp:LogoutResponse>`
+
+	idpKey, _ := loadKeyPair(t, "idp")
+	if err := c.validateLogoutResponse(newSLORequest(t, http.MethodGet, redirectQuery(t, "SAMLResponse", logoutResponseXML, idpKey))); err != nil {
+		t.Error(err)
+	}
+
+	w = httptest.NewRecorder()
+	c.SingleLogout(w, newSLORequest(t, http.MethodGet, redirectQuery(t, "SAMLResponse", logoutResponseXML, nil)))
+	if w.Code != http.StatusForbidden {
+		t.Error("unsigned logout response is accepted -", w.Code, w.Body.String())
+	}
+}
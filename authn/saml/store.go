@@ -0,0 +1,132 @@
+package saml
+
+import (
+	"sync"
+	"time"
+)
+
+// requestTracker 记录 SP 发出的还没有收到回应的 AuthnRequest 的 ID, 验证 Response 中的 InResponseTo
+type requestTracker struct {
+	lock     sync.Mutex
+	expires  time.Duration
+	requests map[string]time.Time
+}
+
+func newRequestTracker(expires time.Duration) *requestTracker {
+	return &requestTracker{
+		expires:  expires,
+		requests: map[string]time.Time{},
+	}
+}
+
+func (t *requestTracker) Add(id string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	for key, at := range t.requests {
+		if now.After(at) {
+			delete(t.requests, key)
+		}
+	}
+	t.requests[id] = now.Add(t.expires)
+}
+
+// PossibleIDs 返回所有未过期的 ID
+func (t *requestTracker) PossibleIDs() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	ids := make([]string, 0, len(t.requests))
+	for key, at := range t.requests {
+		if now.After(at) {
+			delete(t.requests, key)
+			continue
+		}
+		ids = append(ids, key)
+	}
+	return ids
+}
+
+// Session 一个通过 SAML 登录的会话, SLO 时用来查找会话
+type Session struct {
+	SessionID    string
+	Username     string
+	NameID       string
+	SessionIndex string
+	expiredAt    time.Time
+}
+
+// sessionStore 保存 IdP 的 SessionIndex 和 moo 会话的对应关系
+type sessionStore struct {
+	lock     sync.Mutex
+	expires  time.Duration
+	sessions map[string]*Session
+}
+
+func newSessionStore(expires time.Duration) *sessionStore {
+	return &sessionStore{
+		expires:  expires,
+		sessions: map[string]*Session{},
+	}
+}
+
+func (store *sessionStore) Put(sess Session) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	for key, s := range store.sessions {
+		if now.After(s.expiredAt) {
+			delete(store.sessions, key)
+		}
+	}
+	sess.expiredAt = now.Add(store.expires)
+	store.sessions[sess.SessionID] = &sess
+}
+
+func (store *sessionStore) GetBySessionID(sessionID string) *Session {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	sess := store.sessions[sessionID]
+	if sess == nil || time.Now().After(sess.expiredAt) {
+		return nil
+	}
+	return sess
+}
+
+// Take 取出并删除符合条件的会话, sessionIndex 为空时按 nameID 查找
+func (store *sessionStore) Take(nameID string, sessionIndexes []string) []Session {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var results []Session
+	for key, sess := range store.sessions {
+		if sess.NameID != nameID {
+			continue
+		}
+		if len(sessionIndexes) > 0 && !containsString(sessionIndexes, sess.SessionIndex) {
+			continue
+		}
+		delete(store.sessions, key)
+		results = append(results, *sess)
+	}
+	return results
+}
+
+func (store *sessionStore) Delete(sessionID string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.sessions, sessionID)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package saml
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// attrRoles 保存在用户属性中的由 SAML 授于的角色，再次登录时只回收这些角色
+const attrRoles = "saml_roles"
+
+// Attributes 断言中的属性，按 Name 和 FriendlyName 都可以查到
+type Attributes map[string][]string
+
+// ReadAttributes 读取断言中所有的属性
+func ReadAttributes(assertion *gosaml.Assertion) Attributes {
+	attributes := Attributes{}
+	for _, stmt := range assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			var values []string
+			for _, v := range attr.Values {
+				values = append(values, v.Value)
+			}
+			if attr.Name != "" {
+				attributes[attr.Name] = append(attributes[attr.Name], values...)
+			}
+			if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+				attributes[attr.FriendlyName] = append(attributes[attr.FriendlyName], values...)
+			}
+		}
+	}
+	return attributes
+}
+
+func (attributes Attributes) Get(name string) string {
+	if values := attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// username 按 users.saml.username_attribute 读取用户名, 没有配置时用 NameID
+func (c *SAMLClient) username(assertion *gosaml.Assertion, attributes Attributes) string {
+	var name string
+	if c.usernameAttribute != "" {
+		name = attributes.Get(c.usernameAttribute)
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		name = assertion.Subject.NameID.Value
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	return c.userPrefix + strings.ToLower(name)
+}
+
+// mapAttributes 按 users.saml.fields. 的配置将断言中的属性复制到用户属性中, 返回是否有修改
+func (c *SAMLClient) mapAttributes(user *usermodels.User, attributes Attributes) bool {
+	if user.Attributes == nil {
+		user.Attributes = map[string]interface{}{}
+	}
+
+	changed := false
+	for key, field := range c.fields {
+		values := attributes[key]
+		if len(values) == 0 {
+			continue
+		}
+
+		var value interface{} = values[0]
+		if len(values) > 1 {
+			value = values
+		}
+		if old, ok := user.Attributes[field]; ok && reflect.DeepEqual(normalize(old), value) {
+			continue
+		}
+		user.Attributes[field] = value
+		changed = true
+	}
+	return changed
+}
+
+// normalize 将从数据库中读出的 []interface{} 转成 []string 以便比较
+func normalize(value interface{}) interface{} {
+	if list, ok := value.([]interface{}); ok {
+		results := make([]string, 0, len(list))
+		for _, v := range list {
+			results = append(results, as.StringWithDefault(v, ""))
+		}
+		return results
+	}
+	return value
+}
+
+// lookupRoles 将断言中的角色名转为角色 id, 不存在的角色被忽略
+func (c *SAMLClient) lookupRoles(ctx context.Context, attributes Attributes) ([]string, []int64) {
+	if c.roleAttribute == "" {
+		return nil, nil
+	}
+
+	found := []string{}
+	var idList []int64
+	for _, name := range attributes[c.roleAttribute] {
+		role, err := c.users.GetRoleByName(ctx, name)
+		if err != nil {
+			if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+				c.logger.Warn("查询角色失败", log.String("role", name), log.Error(err))
+			}
+			continue
+		}
+		found = append(found, name)
+		idList = append(idList, role.ID)
+	}
+	return found, idList
+}
+
+// loadOrCreateUser 查找断言对应的用户, 不存在时创建它，存在时用断言中的属性更新它
+func (c *SAMLClient) loadOrCreateUser(ctx context.Context, username string, attributes Attributes) (*usermodels.User, bool, error) {
+	user, err := c.users.GetUserByName(ctx, username)
+	if err != nil && err != sql.ErrNoRows && !errors.IsNotFound(err) {
+		return nil, false, errors.Wrap(err, "从数据库中获取用户信息失败")
+	}
+
+	if user == nil {
+		user = &usermodels.User{
+			Name:       username,
+			Nickname:   username,
+			CanLogin:   true,
+			Attributes: map[string]interface{}{},
+			Source:     "saml",
+		}
+		if c.nicknameAttribute != "" {
+			if nickname := attributes.Get(c.nicknameAttribute); nickname != "" {
+				user.Nickname = nickname
+
+				exists, err := c.users.NicknameExists(ctx, nickname)
+				if err != nil {
+					c.logger.Error("新用户登陆，查询用户名是否存在", log.String("username", username), log.Error(err))
+				} else if exists {
+					user.Nickname = nickname + " - " + username
+				}
+			}
+		}
+		c.mapAttributes(user, attributes)
+
+		roles := append([]int64{}, c.roles...)
+		if c.roleAttribute != "" {
+			names, idList := c.lookupRoles(ctx, attributes)
+			user.Attributes[attrRoles] = names
+			roles = append(roles, idList...)
+		}
+
+		userid, err := c.users.CreateUser(ctx, user, roles)
+		if err != nil {
+			return nil, false, err
+		}
+		user.ID = userid
+		c.logger.Info("新用户登陆，创建新用户成功", log.String("username", username))
+		return user, true, nil
+	}
+
+	if user.Source != "saml" {
+		return nil, false, errors.New("用户 '" + username + "' 已存在, 但不是从 SAML 创建的")
+	}
+	if err := c.updateUser(ctx, user, attributes); err != nil {
+		c.logger.Warn("用 SAML 属性更新用户失败", log.String("username", username), log.Error(err))
+	}
+	return user, false, nil
+}
+
+// updateUser 每次登录时用断言中的属性更新已有用户的字段和角色
+func (c *SAMLClient) updateUser(ctx context.Context, user *usermodels.User, attributes Attributes) error {
+	changed := c.mapAttributes(user, attributes)
+
+	var addRoles, removeRoles []int64
+	if c.roleAttribute != "" {
+		names, idList := c.lookupRoles(ctx, attributes)
+		oldList, _ := normalize(user.Attributes[attrRoles]).([]string)
+
+		for idx, name := range names {
+			if !containsString(oldList, name) {
+				addRoles = append(addRoles, idList[idx])
+			}
+		}
+		for _, name := range oldList {
+			if containsString(names, name) {
+				continue
+			}
+			role, err := c.users.GetRoleByName(ctx, name)
+			if err != nil {
+				continue
+			}
+			removeRoles = append(removeRoles, role.ID)
+		}
+		if len(addRoles) > 0 || len(removeRoles) > 0 || user.Attributes[attrRoles] == nil {
+			user.Attributes[attrRoles] = names
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	// 直接用 UserDao 更新，避免密码被再次加密
+	if _, err := c.users.UserDao.UpdateUser(ctx, user.ID, user); err != nil {
+		return errors.Wrap(err, "更新用户属性失败")
+	}
+	for _, roleID := range addRoles {
+		if err := c.users.UserDao.AddRoleToUser(ctx, user.ID, roleID); err != nil {
+			return errors.Wrap(err, "授于角色失败")
+		}
+	}
+	for _, roleID := range removeRoles {
+		if err := c.users.UserDao.RemoveRoleFromUser(ctx, user.ID, roleID); err != nil {
+			return errors.Wrap(err, "收回角色失败")
+		}
+	}
+	return nil
+}
@@ -26,6 +26,12 @@ var (
 	// ErrUserErrorCountExceedLimit 用户未找到
 	ErrUserErrorCountExceedLimit = newHTTPError(http.StatusUnauthorized, "user isn't error count exceed limit")
 
+	// ErrAddressErrorCountExceedLimit 同一地址登录失败的次数太多
+	ErrAddressErrorCountExceedLimit = newHTTPError(http.StatusUnauthorized, "address error count exceed limit")
+
+	// ErrLoginTooFrequent 登录失败后必须等一段时间才能再次登录
+	ErrLoginTooFrequent = newHTTPError(http.StatusTooManyRequests, "login too frequent")
+
 	// ErrPasswordNotMatch 密码不正确
 	ErrPasswordNotMatch = newHTTPError(http.StatusUnauthorized, "password isn't match")
 
@@ -50,6 +56,12 @@ var (
 	// ErrUserAlreadyOnline 用户已登录
 	ErrUserAlreadyOnline = newHTTPError(http.StatusUnauthorized, "user is already online")
 
+	// ErrMaxSessionsExceeded 用户同时在线的会话数超过上限
+	ErrMaxSessionsExceeded = newHTTPError(http.StatusUnauthorized, "user sessions exceed limit")
+
+	// ErrPasswordMustChange 用户必须修改密码后才能登录
+	ErrPasswordMustChange = newHTTPError(http.StatusForbidden, "password must be changed")
+
 	// ErrPermissionDenied 没有权限
 	ErrPermissionDenied = newHTTPError(http.StatusUnauthorized, "permission is denied")
 
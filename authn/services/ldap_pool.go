@@ -0,0 +1,302 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// LdapServer 一个 LDAP 服务器地址, 支持 ldap://host:port, ldaps://host:port 和 host:port 三种格式
+type LdapServer struct {
+	Address string
+	LDAPS   bool
+}
+
+// ParseLdapServers 解析用逗号或空格分隔的多个服务器地址，登录时按顺序尝试
+func ParseLdapServers(s string) ([]LdapServer, error) {
+	var servers []LdapServer
+	for _, addr := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';'
+	}) {
+		server := LdapServer{Address: addr}
+		if strings.Contains(addr, "://") {
+			u, err := url.Parse(addr)
+			if err != nil {
+				return nil, errors.Wrap(err, "LDAP 服务器地址 '"+addr+"' 不正确")
+			}
+			switch strings.ToLower(u.Scheme) {
+			case "ldap":
+				server.Address = withDefaultPort(u.Host, "389")
+			case "ldaps":
+				server.Address = withDefaultPort(u.Host, "636")
+				server.LDAPS = true
+			default:
+				return nil, errors.New("LDAP 服务器地址 '" + addr + "' 的协议不支持")
+			}
+		} else {
+			server.Address = withDefaultPort(addr, "389")
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// ReadLdapTLSConfig 读取 LDAP 的证书配置，没有配置 CA 时使用系统的根证书
+func ReadLdapTLSConfig(env *moo.Environment) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         env.Config.StringWithDefault(api.CfgUserLdapServerName, ""),
+		InsecureSkipVerify: env.Config.BoolWithDefault(api.CfgUserLdapInsecureSkipVerify, false),
+	}
+	if caFile := env.Config.StringWithDefault(api.CfgUserLdapCAFile, ""); caFile != "" {
+		bs, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "读取 LDAP 的 CA 证书失败")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, errors.New("LDAP 的 CA 证书 '" + caFile + "' 中没有找到有效的证书")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// LdapDialer 连接 LDAP 服务器, 多个服务器时按顺序尝试，失败的服务器在一段时间内不再优先使用
+type LdapDialer struct {
+	Servers   []LdapServer
+	StartTLS  bool
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+	// RetryAfter 服务器连接失败后多长时间内排到最后
+	RetryAfter time.Duration
+
+	lock   sync.Mutex
+	failed map[string]time.Time
+}
+
+func (d *LdapDialer) tlsConfigFor(server LdapServer) *tls.Config {
+	var cfg *tls.Config
+	if d.TLSConfig != nil {
+		cfg = d.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(server.Address); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	return cfg
+}
+
+func (d *LdapDialer) ordered() []LdapServer {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	var ok, bad []LdapServer
+	for _, server := range d.Servers {
+		if at, exists := d.failed[server.Address]; exists && now.Before(at) {
+			bad = append(bad, server)
+		} else {
+			ok = append(ok, server)
+		}
+	}
+	return append(ok, bad...)
+}
+
+func (d *LdapDialer) markFailed(server LdapServer, failed bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !failed {
+		delete(d.failed, server.Address)
+		return
+	}
+	if d.failed == nil {
+		d.failed = map[string]time.Time{}
+	}
+	retryAfter := d.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 30 * time.Second
+	}
+	d.failed[server.Address] = time.Now().Add(retryAfter)
+}
+
+func (d *LdapDialer) dial(server LdapServer) (*ldap.Conn, error) {
+	var l *ldap.Conn
+	var err error
+	if server.LDAPS {
+		l, err = ldap.DialTLS("tcp", server.Address, d.tlsConfigFor(server))
+	} else {
+		l, err = ldap.Dial("tcp", server.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		l.SetTimeout(d.Timeout)
+	}
+	if d.StartTLS && !server.LDAPS {
+		if err = l.StartTLS(d.tlsConfigFor(server)); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Dial 返回第一个能连上的服务器的连接
+func (d *LdapDialer) Dial() (*ldap.Conn, error) {
+	servers := d.ordered()
+	if len(servers) == 0 {
+		return nil, errors.New("LDAP 服务器地址没有配置")
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		l, err := d.dial(server)
+		if err == nil {
+			d.markFailed(server, false)
+			return l, nil
+		}
+		d.markFailed(server, true)
+		lastErr = errors.Wrap(err, "无法连接到 LDAP 服务器 '"+server.Address+"'")
+	}
+	return nil, lastErr
+}
+
+// LdapPool 缓存已用服务帐号登录的连接
+type LdapPool struct {
+	Dialer       *LdapDialer
+	BindDN       string
+	BindPassword string
+	MaxIdle      int
+	// HealthCheckInterval 连接空闲超过这个时间后，取出时先检查它是否可用
+	HealthCheckInterval time.Duration
+
+	lock  sync.Mutex
+	idle  []pooledConn
+	close bool
+}
+
+type pooledConn struct {
+	conn     *ldap.Conn
+	lastUsed time.Time
+}
+
+func (p *LdapPool) bind(l *ldap.Conn) error {
+	if p.BindDN == "" {
+		return nil
+	}
+	if err := l.Bind(p.BindDN, p.BindPassword); err != nil {
+		return errors.Wrap(err, "LDAP 服务帐号登录失败")
+	}
+	return nil
+}
+
+func isAlive(l *ldap.Conn) bool {
+	if l.IsClosing() {
+		return false
+	}
+	// 读取 root DSE 来检查连接是否可用
+	_, err := l.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, 0, false, "(objectClass=*)", []string{"1.1"}, nil))
+	return err == nil
+}
+
+// Get 取出一个连接，没有空闲的连接时新建一个
+func (p *LdapPool) Get() (*ldap.Conn, error) {
+	for {
+		p.lock.Lock()
+		if len(p.idle) == 0 {
+			p.lock.Unlock()
+			break
+		}
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.lock.Unlock()
+
+		if pc.conn.IsClosing() {
+			continue
+		}
+		if p.HealthCheckInterval > 0 && time.Since(pc.lastUsed) > p.HealthCheckInterval && !isAlive(pc.conn) {
+			pc.conn.Close()
+			continue
+		}
+		return pc.conn, nil
+	}
+
+	l, err := p.Dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.bind(l); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Put 归还连接, rebind 为 true 表示连接上已经用其他帐号登录过，需要重新用服务帐号登录
+func (p *LdapPool) Put(l *ldap.Conn, rebind bool) {
+	if l.IsClosing() {
+		return
+	}
+	if rebind {
+		// 没有服务帐号时无法恢复连接的身份，直接关闭
+		if p.BindDN == "" || p.bind(l) != nil {
+			l.Close()
+			return
+		}
+	}
+
+	p.lock.Lock()
+	if p.close || len(p.idle) >= p.MaxIdle {
+		p.lock.Unlock()
+		l.Close()
+		return
+	}
+	p.idle = append(p.idle, pooledConn{conn: l, lastUsed: time.Now()})
+	p.lock.Unlock()
+}
+
+// Discard 关闭出错的连接
+func (p *LdapPool) Discard(l *ldap.Conn) {
+	l.Close()
+}
+
+func (p *LdapPool) Close() error {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.close = true
+	p.lock.Unlock()
+
+	for _, pc := range idle {
+		pc.conn.Close()
+	}
+	return nil
+}
+
+// LdapFilterFormat 用转义后的值替换 filter 中的 %s, 防止 LDAP 注入
+func LdapFilterFormat(filter, value string) string {
+	return strings.Replace(filter, "%s", ldap.EscapeFilter(value), -1)
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLdapServers(t *testing.T) {
+	servers, err := ParseLdapServers("ldaps://dc1.example.com, ldap://dc2.example.com:3268 10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	excepted := []LdapServer{
+		{Address: "dc1.example.com:636", LDAPS: true},
+		{Address: "dc2.example.com:3268"},
+		{Address: "10.0.0.1:389"},
+	}
+	if !reflect.DeepEqual(servers, excepted) {
+		t.Error("want", excepted, "got", servers)
+	}
+
+	if _, err := ParseLdapServers("http://dc1.example.com"); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestLdapDialerOrder(t *testing.T) {
+	dialer := &LdapDialer{Servers: []LdapServer{{Address: "a:389"}, {Address: "b:389"}}}
+	dialer.markFailed(LdapServer{Address: "a:389"}, true)
+	if servers := dialer.ordered(); servers[0].Address != "b:389" || servers[1].Address != "a:389" {
+		t.Error("failed server should be tried last, got", servers)
+	}
+	dialer.markFailed(LdapServer{Address: "a:389"}, false)
+	if servers := dialer.ordered(); servers[0].Address != "a:389" {
+		t.Error("want a:389 got", servers)
+	}
+}
+
+func TestLdapEscape(t *testing.T) {
+	filter := LdapFilterFormat("(&(objectClass=person)(sAMAccountName=%s))", "*)(uid=*")
+	if filter != `(&(objectClass=person)(sAMAccountName=\2a\29\28uid=\2a))` {
+		t.Error("got", filter)
+	}
+
+	if s := escapeDNValue("Tom, Jr"); s != `Tom\, Jr` {
+		t.Error("got", s)
+	}
+	if s := escapeDNValue("#admin "); s != `\#admin\ ` {
+		t.Error("got", s)
+	}
+}
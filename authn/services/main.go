@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"crypto/x509"
 	"strings"
+	"time"
 
 	"github.com/runner-mei/log"
 	"github.com/runner-mei/moo"
@@ -43,6 +45,11 @@ type LoginResult struct {
 
 	UserSource string
 	Data       map[string]interface{}
+
+	// MustChangePassword 用户必须修改密码后才能登录
+	MustChangePassword bool
+	// PasswordExpiresAt 密码的过期时间，为零值时表示不过期
+	PasswordExpiresAt time.Time
 }
 
 type LoginRequest struct {
@@ -55,6 +62,8 @@ type LoginRequest struct {
 	CaptchaValue string      `json:"captcha_value,omitempty" xml:"captcha_value" form:"captcha_value" query:"captcha_value"`
 
 	Address string
+	// ClientCertificate 通过 https 登录时客户端出示的证书, 没有时为 nil
+	ClientCertificate *x509.Certificate `json:"-" xml:"-" form:"-" query:"-"`
 }
 
 func (u *LoginRequest) IsForce() bool {
@@ -97,6 +106,7 @@ type AuthService struct {
 	authFuncs       []func(*AuthContext) (bool, error)
 	afterAuthFuncs  []AuthFunc
 	errFuncs        []func(ctx *AuthContext, err error) error
+	closeFuncs      []func() error
 }
 
 func (as *AuthService) OnBeforeLoad(cb AuthFunc) {
@@ -120,6 +130,23 @@ func (as *AuthService) OnAfterAuth(cb AuthFunc) {
 func (as *AuthService) OnError(cb func(ctx *AuthContext, err error) error) {
 	as.errFuncs = append(as.errFuncs, cb)
 }
+
+// OnClose 注册关闭时要调用的函数, 插件用它释放自已的资源, 如 LDAP 的连接池
+func (as *AuthService) OnClose(cb func() error) {
+	as.closeFuncs = append(as.closeFuncs, cb)
+}
+
+// Close 释放插件的资源
+func (as *AuthService) Close() error {
+	var lastErr error
+	for _, cb := range as.closeFuncs {
+		if err := cb(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
 func (as *AuthService) Auth(ctx *AuthContext) error {
 	ctx.Step = BeforeLoad
 
@@ -6,6 +6,7 @@ import (
 
 	"github.com/mojocn/base64Captcha"
 	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
 )
 
 // CaptchaConfig json request body.
@@ -72,9 +73,8 @@ func CaptchaCheck(store base64Captcha.Store, counter FailCounter) AuthOption {
 				return nil
 			}
 
-			errCount := counter.Count(ctx.Request.Username)
 			if ctx.Request.CaptchaKey == "" || ctx.Request.CaptchaValue == "" {
-				if errCount > 0 {
+				if hasFailed(ctx, counter) {
 					return ErrCaptchaMissing
 				}
 				return nil
@@ -90,6 +90,27 @@ func CaptchaCheck(store base64Captcha.Store, counter FailCounter) AuthOption {
 	})
 }
 
+// hasFailed 用户名或来源地址最近登录失败过时需要输入验证码
+func hasFailed(ctx *AuthContext, counter FailCounter) bool {
+	record, err := counter.Count(ctx.Ctx, FailByUsername, ctx.Request.Username)
+	if err != nil {
+		ctx.Logger.Warn("查询登录失败次数失败", log.String("username", ctx.Request.Username), log.Error(err))
+		return true
+	}
+	if record.Count > 0 {
+		return true
+	}
+	if ctx.Request.Address == "" {
+		return false
+	}
+	record, err = counter.Count(ctx.Ctx, FailByAddress, ctx.Request.Address)
+	if err != nil {
+		ctx.Logger.Warn("查询登录失败次数失败", log.String("address", ctx.Request.Address), log.Error(err))
+		return true
+	}
+	return record.Count > 0
+}
+
 // base64Captcha verify http handler
 func CaptchaVerify(store base64Captcha.Store) func(w http.ResponseWriter, r *http.Request) (bool, error) {
 	if store == nil {
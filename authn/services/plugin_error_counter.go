@@ -1,94 +1,292 @@
 package services
 
 import (
+	"context"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/runner-mei/errors"
 	"github.com/runner-mei/log"
 )
 
+const (
+	// FailByUsername 按用户名统计的登录失败次数
+	FailByUsername = "username"
+	// FailByAddress 按来源地址统计的登录失败次数
+	FailByAddress = "address"
+)
+
+// FailRecord 某个用户名或地址在时间窗口内的登录失败记录
+type FailRecord struct {
+	Kind          string    `json:"kind"`
+	Key           string    `json:"key"`
+	Count         int       `json:"count"`
+	FirstFailedAt time.Time `json:"first_failed_at,omitempty"`
+	LastFailedAt  time.Time `json:"last_failed_at,omitempty"`
+}
+
+// FailCounter 按用户名和来源地址记录登录失败的次数，只统计时间窗口内的失败
 type FailCounter interface {
-	Users() []string
-	Fail(username string)
-	Count(username string) int
-	Zero(username string)
+	// Fail 记录一次登录失败, 用户名和地址各算一次
+	Fail(ctx context.Context, username, address string) error
+	// Count 返回用户名或地址在时间窗口内的失败记录
+	Count(ctx context.Context, kind, key string) (FailRecord, error)
+	// Zero 清除用户名或地址的失败记录
+	Zero(ctx context.Context, kind, key string) error
+	// List 返回时间窗口内所有的失败记录
+	List(ctx context.Context) ([]FailRecord, error)
+}
+
+type failKey struct {
+	kind string
+	key  string
 }
 
 type memFailCounter struct {
-	lock  sync.Mutex
-	users map[string]int
+	lock    sync.Mutex
+	window  time.Duration
+	records map[failKey][]time.Time
+	now     func() time.Time
+}
+
+func (mem *memFailCounter) recent(key failKey, now time.Time) []time.Time {
+	list := mem.records[key]
+	if mem.window <= 0 {
+		return list
+	}
+	start := now.Add(-mem.window)
+	idx := 0
+	for idx < len(list) && !list[idx].After(start) {
+		idx++
+	}
+	if idx == 0 {
+		return list
+	}
+	list = list[idx:]
+	if len(list) == 0 {
+		delete(mem.records, key)
+	} else {
+		mem.records[key] = list
+	}
+	return list
 }
 
-func (mem *memFailCounter) Zero(username string) {
+func (mem *memFailCounter) Zero(ctx context.Context, kind, key string) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
-	delete(mem.users, username)
+	delete(mem.records, failKey{kind: kind, key: strings.ToLower(key)})
+	return nil
 }
 
-func (mem *memFailCounter) Fail(username string) {
+func (mem *memFailCounter) Fail(ctx context.Context, username, address string) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
-	count := mem.users[username]
-	count++
-	mem.users[username] = count
+
+	now := mem.now()
+	if username != "" {
+		key := failKey{kind: FailByUsername, key: strings.ToLower(username)}
+		mem.records[key] = append(mem.recent(key, now), now)
+	}
+	if address != "" {
+		key := failKey{kind: FailByAddress, key: strings.ToLower(address)}
+		mem.records[key] = append(mem.recent(key, now), now)
+	}
+	return nil
 }
 
-func (mem *memFailCounter) Count(username string) int {
+func (mem *memFailCounter) Count(ctx context.Context, kind, key string) (FailRecord, error) {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
-	return mem.users[username]
+
+	fk := failKey{kind: kind, key: strings.ToLower(key)}
+	return toFailRecord(fk, mem.recent(fk, mem.now())), nil
 }
 
-func (mem *memFailCounter) Users() []string {
+func (mem *memFailCounter) List(ctx context.Context) ([]FailRecord, error) {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
-	users := make([]string, 0, len(mem.users))
-	for k := range mem.users {
-		users = append(users, k)
+
+	now := mem.now()
+	results := make([]FailRecord, 0, len(mem.records))
+	for k := range mem.records {
+		list := mem.recent(k, now)
+		if len(list) == 0 {
+			continue
+		}
+		results = append(results, toFailRecord(k, list))
+	}
+	return results, nil
+}
+
+func toFailRecord(key failKey, list []time.Time) FailRecord {
+	record := FailRecord{Kind: key.kind, Key: key.key, Count: len(list)}
+	if len(list) > 0 {
+		record.FirstFailedAt = list[0]
+		record.LastFailedAt = list[len(list)-1]
 	}
-	return users
+	return record
+}
+
+// NewMemFailCounter 创建一个保存在内存中的 FailCounter, window 为 0 时失败次数不会过期
+func NewMemFailCounter(window time.Duration) FailCounter {
+	return &memFailCounter{window: window, records: map[failKey][]time.Time{}, now: time.Now}
 }
 
-var CreateFailCounter = func() FailCounter {
-	return &memFailCounter{users: map[string]int{}}
+var CreateFailCounter = NewMemFailCounter
+
+// LockoutEvent 用户因失败次数太多被锁定或地址被拒绝登录时的事件
+type LockoutEvent struct {
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Username string    `json:"username,omitempty"`
+	Address  string    `json:"address,omitempty"`
+	Count    int       `json:"count"`
+	At       time.Time `json:"at"`
+}
+
+// FailPolicy 登录失败的处理策略
+type FailPolicy struct {
+	// MaxUserFailCount 用户在时间窗口内失败的次数达到它时锁定用户
+	MaxUserFailCount int
+	// MaxAddressFailCount 同一地址在时间窗口内失败的次数达到它时拒绝该地址登录, 为 0 时不限制
+	MaxAddressFailCount int
+	// DelayBase 失败后必须等待这么久才能再次登录, 每多失败一次等待的时间加倍, 为 0 时不等待
+	DelayBase time.Duration
+	// DelayMax 等待时间的上限
+	DelayMax time.Duration
+	// OnLockout 用户被锁定或地址被拒绝登录时调用
+	OnLockout func(ctx *AuthContext, evt *LockoutEvent)
+}
+
+// Delay 返回失败 count 次后再次登录前需要等待的时间
+func (policy *FailPolicy) Delay(count int) time.Duration {
+	if policy.DelayBase <= 0 || count <= 0 {
+		return 0
+	}
+	max := policy.DelayMax
+	if max <= 0 {
+		max = 10 * time.Minute
+	}
+	delay := policy.DelayBase
+	for i := 1; i < count; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func (policy *FailPolicy) isTooFrequent(record FailRecord, now time.Time) bool {
+	if record.Count == 0 || record.LastFailedAt.IsZero() {
+		return false
+	}
+	return now.Before(record.LastFailedAt.Add(policy.Delay(record.Count)))
 }
 
 func ErrorCountCheck(um UserManager, counter FailCounter, maxLoginFailCount int) AuthOption {
+	return ErrorCountCheckWith(um, counter, FailPolicy{MaxUserFailCount: maxLoginFailCount})
+}
+
+func ErrorCountCheckWith(um UserManager, counter FailCounter, policy FailPolicy) AuthOption {
 	return AuthOptionFunc(func(auth *AuthService) error {
-		if maxLoginFailCount <= 0 {
-			maxLoginFailCount = 3
+		if policy.MaxUserFailCount <= 0 {
+			policy.MaxUserFailCount = 3
 		}
 
-		auth.OnBeforeLoad(AuthFunc(func(ctx *AuthContext) error {
-			errCount := counter.Count(ctx.Request.Username)
-			ctx.ErrorCount = errCount
+		lockout := func(ctx *AuthContext, kind, key string, count int) {
+			if policy.OnLockout == nil {
+				return
+			}
+			policy.OnLockout(ctx, &LockoutEvent{
+				Kind:     kind,
+				Key:      key,
+				Username: ctx.Request.Username,
+				Address:  ctx.Request.Address,
+				Count:    count,
+				At:       time.Now(),
+			})
+		}
 
-			if errCount >= maxLoginFailCount {
+		lockUser := func(ctx *AuthContext, count int) {
+			if err := um.Lock(ctx); err != nil {
+				ctx.Logger.Error("出错次数太多，锁住用户失败", log.Error(err))
+				return
+			}
+			if err := counter.Zero(ctx.Ctx, FailByUsername, ctx.Request.Username); err != nil {
+				ctx.Logger.Warn("清除登录失败次数失败", log.String("username", ctx.Request.Username), log.Error(err))
+			}
+			lockout(ctx, FailByUsername, ctx.Request.Username, count)
+		}
+
+		auth.OnBeforeLoad(AuthFunc(func(ctx *AuthContext) error {
+			now := time.Now()
 
-				if err := um.Lock(ctx); err != nil {
-					ctx.Logger.Error("出错次数太多，锁住用户失败", log.Error(err))
-				} else {
-					counter.Zero(ctx.Request.Username)
+			if ctx.Request.Address != "" {
+				record, err := counter.Count(ctx.Ctx, FailByAddress, ctx.Request.Address)
+				if err != nil {
+					return errors.Wrap(err, "查询登录失败次数失败")
 				}
+				if policy.MaxAddressFailCount > 0 && record.Count >= policy.MaxAddressFailCount {
+					return ErrAddressErrorCountExceedLimit
+				}
+				if policy.isTooFrequent(record, now) {
+					return ErrLoginTooFrequent
+				}
+			}
+
+			record, err := counter.Count(ctx.Ctx, FailByUsername, ctx.Request.Username)
+			if err != nil {
+				return errors.Wrap(err, "查询登录失败次数失败")
+			}
+			ctx.ErrorCount = record.Count
 
+			if record.Count >= policy.MaxUserFailCount {
+				lockUser(ctx, record.Count)
 				return ErrUserErrorCountExceedLimit
 			}
+			if policy.isTooFrequent(record, now) {
+				return ErrLoginTooFrequent
+			}
 			return nil
 		}))
 
 		auth.OnAfterAuth(func(ctx *AuthContext) error {
 			if ctx.Response.IsOK {
-				counter.Zero(ctx.Request.Username)
-			} else {
-				counter.Fail(ctx.Request.Username)
-
-				errCount := counter.Count(ctx.Request.Username)
-				ctx.ErrorCount = errCount
-				if errCount >= maxLoginFailCount {
-					if err := um.Lock(ctx); err != nil {
-						ctx.Logger.Error("出错次数太多，锁信用户失败", log.Error(err))
-					} else {
-						counter.Zero(ctx.Request.Username)
-					}
+				if err := counter.Zero(ctx.Ctx, FailByUsername, ctx.Request.Username); err != nil {
+					ctx.Logger.Warn("清除登录失败次数失败", log.String("username", ctx.Request.Username), log.Error(err))
+				}
+				return nil
+			}
+
+			if err := counter.Fail(ctx.Ctx, ctx.Request.Username, ctx.Request.Address); err != nil {
+				ctx.Logger.Warn("记录登录失败次数失败", log.String("username", ctx.Request.Username), log.Error(err))
+				return nil
+			}
+
+			record, err := counter.Count(ctx.Ctx, FailByUsername, ctx.Request.Username)
+			if err != nil {
+				ctx.Logger.Warn("查询登录失败次数失败", log.String("username", ctx.Request.Username), log.Error(err))
+				return nil
+			}
+			ctx.ErrorCount = record.Count
+			if record.Count >= policy.MaxUserFailCount {
+				lockUser(ctx, record.Count)
+			}
+
+			if policy.MaxAddressFailCount > 0 && ctx.Request.Address != "" {
+				record, err := counter.Count(ctx.Ctx, FailByAddress, ctx.Request.Address)
+				if err != nil {
+					ctx.Logger.Warn("查询登录失败次数失败", log.String("address", ctx.Request.Address), log.Error(err))
+					return nil
+				}
+				// 只在刚达到上限时通知一次
+				if record.Count == policy.MaxAddressFailCount {
+					lockout(ctx, FailByAddress, ctx.Request.Address, record.Count)
 				}
 			}
 			return nil
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemFailCounterWindow(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter := NewMemFailCounter(10 * time.Minute).(*memFailCounter)
+	counter.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	counter.Fail(ctx, "Admin", "192.168.1.2")
+	now = now.Add(6 * time.Minute)
+	counter.Fail(ctx, "admin", "192.168.1.3")
+
+	record, _ := counter.Count(ctx, FailByUsername, "ADMIN")
+	if record.Count != 2 {
+		t.Error("want 2 got", record.Count)
+	}
+	record, _ = counter.Count(ctx, FailByAddress, "192.168.1.2")
+	if record.Count != 1 {
+		t.Error("want 1 got", record.Count)
+	}
+
+	// 第一次失败已经滑出时间窗口
+	now = now.Add(5 * time.Minute)
+	record, _ = counter.Count(ctx, FailByUsername, "admin")
+	if record.Count != 1 {
+		t.Error("want 1 got", record.Count)
+	}
+	if list, _ := counter.List(ctx); len(list) != 2 {
+		t.Error("want 2 got", len(list))
+	}
+
+	counter.Zero(ctx, FailByUsername, "admin")
+	record, _ = counter.Count(ctx, FailByUsername, "admin")
+	if record.Count != 0 {
+		t.Error("want 0 got", record.Count)
+	}
+	record, _ = counter.Count(ctx, FailByAddress, "192.168.1.3")
+	if record.Count != 1 {
+		t.Error("want 1 got", record.Count)
+	}
+}
+
+func TestFailPolicyDelay(t *testing.T) {
+	policy := FailPolicy{DelayBase: time.Second, DelayMax: 10 * time.Second}
+	for _, test := range []struct {
+		count int
+		delay time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	} {
+		if delay := policy.Delay(test.count); delay != test.delay {
+			t.Error(test.count, ": want", test.delay, "got", delay)
+		}
+	}
+
+	if delay := (&FailPolicy{}).Delay(3); delay != 0 {
+		t.Error("want 0 got", delay)
+	}
+}
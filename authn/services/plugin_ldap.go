@@ -1,16 +1,16 @@
 package services
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/runner-mei/goutils/netutil"
 	"github.com/runner-mei/log"
 	"github.com/runner-mei/moo"
 	"github.com/runner-mei/moo/api"
-	"github.com/runner-mei/goutils/netutil"
 	ldap "gopkg.in/ldap.v3"
 )
 
@@ -20,6 +20,9 @@ type HasSource interface {
 
 func isConnectError(err error) bool {
 	if ldapErr, ok := err.(*ldap.Error); ok {
+		if ldapErr.ResultCode == ldap.ErrorNetwork {
+			return true
+		}
 		if opErr, ok := ldapErr.Err.(*net.OpError); ok && opErr.Op == "dial" {
 			return true
 		}
@@ -27,6 +30,32 @@ func isConnectError(err error) bool {
 	return false
 }
 
+// escapeDNValue 转义 DN 中属性值的特殊字符, 见 RFC 4514
+func escapeDNValue(s string) string {
+	var sb strings.Builder
+	for idx, r := range s {
+		switch r {
+		case ',', '+', '"', '\\', '<', '>', ';', '=':
+			sb.WriteByte('\\')
+		case '#':
+			if idx == 0 {
+				sb.WriteByte('\\')
+			}
+		case ' ':
+			if idx == 0 || idx == len(s)-1 {
+				sb.WriteByte('\\')
+			}
+		case 0:
+			sb.WriteString("\\00")
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// LdapUserCheck 用 LDAP 验证用户, 配置了服务帐号时先用服务帐号查询用户的 DN 再用该 DN 登录,
+// 否则按 users.ldap_user_format 生成 DN 直接登录
 func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 	return AuthOptionFunc(func(auth *AuthService) error {
 		ldapServer := env.Config.StringWithDefault(api.CfgUserLdapAddress, "")
@@ -34,6 +63,14 @@ func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 			logger.Warn("ldap 没有配置，跳过它")
 			return nil
 		}
+		servers, err := ParseLdapServers(ldapServer)
+		if err != nil {
+			return err
+		}
+		tlsConfig, err := ReadLdapTLSConfig(env)
+		if err != nil {
+			return err
+		}
 		ldapTLS := env.Config.BoolWithDefault(api.CfgUserLdapTLS, false)
 		ldapDN := env.Config.StringWithDefault(api.CfgUserLdapBaseDN, "")
 		ldapFilter := env.Config.StringWithDefault(api.CfgUserLdapFilter, "(&(objectClass=organizationalPerson)(sAMAccountName=%s))")
@@ -45,17 +82,54 @@ func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 				ldapUserFormat = "%s"
 			}
 		}
+		bindDN := env.Config.StringWithDefault(api.CfgUserLdapBindDN, "")
+		searchBind := env.Config.BoolWithDefault(api.CfgUserLdapSearchBind, bindDN != "")
+
 		defaultRoles := strings.Split(env.Config.StringWithDefault(api.CfgUserLdapDefaultRoles, ""), ",")
-		ldapRoles := env.Config.StringWithDefault(api.CfgUserLdapLoginRoleField, 
+		ldapRoles := env.Config.StringWithDefault(api.CfgUserLdapLoginRoleField,
 			env.Config.StringWithDefault("users.ldap_roles", "memberOf"))
 		exceptedRole := env.Config.StringWithDefault(api.CfgUserLdapLoginRoleName, "")
 
+		pool := &LdapPool{
+			Dialer: &LdapDialer{
+				Servers:   servers,
+				StartTLS:  ldapTLS,
+				TLSConfig: tlsConfig,
+				Timeout:   env.Config.DurationWithDefault(api.CfgUserLdapTimeout, 10*time.Second),
+			},
+			BindDN:              bindDN,
+			BindPassword:        env.Config.StringWithDefault(api.CfgUserLdapBindPassword, ""),
+			MaxIdle:             env.Config.IntWithDefault(api.CfgUserLdapPoolSize, 4),
+			HealthCheckInterval: env.Config.DurationWithDefault(api.CfgUserLdapHealthCheckInterval, 30*time.Second),
+		}
+		auth.OnClose(pool.Close)
+
 		logFields := []log.Field{
 			log.String("ldapServer", ldapServer),
 			log.Bool("ldapTLS", ldapTLS),
 			log.String("ldapDN", ldapDN),
 			log.String("ldapFilter", ldapFilter),
 			log.String("ldapUserFormat", ldapUserFormat),
+			log.Bool("searchBind", searchBind),
+		}
+
+		// search 查询用户，连接已失效时重新连接一次
+		search := func(l *ldap.Conn, filter string) (*ldap.Conn, *ldap.SearchResult, error) {
+			req := ldap.NewSearchRequest(
+				ldapDN,
+				ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+				filter, []string{ldapRoles}, nil,
+			)
+			result, err := l.Search(req)
+			if err != nil && isConnectError(err) {
+				pool.Discard(l)
+				l, err = pool.Get()
+				if err != nil {
+					return nil, nil, err
+				}
+				result, err = l.Search(req)
+			}
+			return l, result, err
 		}
 
 		auth.OnAuth(func(ctx *AuthContext) (bool, error) {
@@ -67,10 +141,6 @@ func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 					return false, nil
 				}
 
-				// if o := u.Data["source"]; o != nil {
-				// 	method = strings.ToLower(fmt.Sprint(o))
-				// }
-
 				var method = u.Source()
 				if method != "ldap" {
 					return false, nil
@@ -80,77 +150,96 @@ func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 				isNew = true
 			}
 
-			l, err := ldap.Dial("tcp", ldapServer)
+			if ctx.Request.Password == "" {
+				// 空密码在 LDAP 中是匿名登录，会总是成功
+				return isLdap, ErrPasswordEmpty
+			}
+
+			name := ctx.Request.Username
+			if idx := strings.Index(name, "@"); idx > 0 {
+				name = name[:idx]
+			}
+			filter := LdapFilterFormat(ldapFilter, name)
+
+			l, err := pool.Get()
 			if err != nil {
 				logger.Info("无法连接到 LDAP 服务器", log.Error(err))
-				return isLdap, &ErrExternalServer{Msg: "无法连接到 LDAP 服务器" + err.Error()}
+				return isLdap, &ErrExternalServer{Msg: "无法连接到 LDAP 服务器" + err.Error(), Err: err}
 			}
-			defer l.Close()
 
-			if ldapTLS {
-				// Reconnect with TLS
-				err = l.StartTLS(&tls.Config{InsecureSkipVerify: true})
+			var searchResult *ldap.SearchResult
+			var userDN string
+			if searchBind {
+				l, searchResult, err = search(l, filter)
 				if err != nil {
-					logger.Info("无法连接到 LDAP 服务器", log.Error(err))
-					return isLdap, &ErrExternalServer{Msg: "无法连接到 LDAP 服务器" + err.Error()}
+					if l != nil {
+						pool.Discard(l)
+					}
+					logger.Info("用服务帐号查询用户失败", log.String("filter", filter), log.Error(err))
+					if isConnectError(err) {
+						return isLdap, &ErrExternalServer{Msg: "无法连接到 LDAP 服务器" + err.Error(), Err: err}
+					}
+					return isLdap, err
 				}
+				switch len(searchResult.Entries) {
+				case 0:
+					pool.Put(l, false)
+					return isLdap, ErrUserNotFound
+				case 1:
+					userDN = searchResult.Entries[0].DN
+				default:
+					pool.Put(l, false)
+					return isLdap, ErrMutiUsers
+				}
+			} else if ldapUserFormat == "%s" {
+				userDN = ctx.Request.Username
+			} else {
+				userDN = fmt.Sprintf(ldapUserFormat, escapeDNValue(ctx.Request.Username))
 			}
-			username := fmt.Sprintf(ldapUserFormat, ctx.Request.Username)
-			// First bind with a read only user
-			err = l.Bind(username, ctx.Request.Password)
+
+			err = l.Bind(userDN, ctx.Request.Password)
 			if err != nil {
-				logger.Info("无法连接到 LDAP 服务器", log.Error(err))
+				pool.Put(l, true)
+				logger.Info("LDAP 登录失败", log.String("dn", userDN), log.Error(err))
+				if isConnectError(err) {
+					return isLdap, &ErrExternalServer{Msg: "无法连接到 LDAP 服务器" + err.Error(), Err: err}
+				}
 				return isLdap, err
 			}
 
-			logger := ctx.Logger.With(logFields...).With(log.String("username", username), log.String("password", "********"))
+			logger := ctx.Logger.With(logFields...).With(log.String("username", userDN), log.String("password", "********"))
 			logger.Info("尝试 ldap 验证, 用户名和密码正确")
 
 			if !isNew {
 				if exceptedRole == "" {
+					pool.Put(l, true)
 					return true, nil
 				}
 			}
 
-			var userRoles []string
-
-			ldapFilterForUser := fmt.Sprintf(ldapFilter, username)
-			if idx := strings.Index(username, "@"); idx > 0 {
-				ldapFilterForUser = fmt.Sprintf(ldapFilter, username[:idx])
+			if searchResult == nil {
+				l, searchResult, err = search(l, filter)
+			}
+			if l != nil {
+				pool.Put(l, true)
 			}
 
-			// dn := "cn=" + username + "," + ldapDN
-			//获取数据
-			searchResult, err := l.Search(ldap.NewSearchRequest(
-				ldapDN,
-				ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-				ldapFilterForUser, nil, nil,
-			))
+			var userRoles []string
 			if err == nil {
 				userRoles = make([]string, 0, 4)
 				for _, ent := range searchResult.Entries {
-					for _, attr := range ent.Attributes {
-						// fmt.Println(attr.Name, attr.Values)
-						if len(attr.Values) > 0 {
-							if ldapRoles == attr.Name {
-								for _, roleName := range attr.Values {
-									dn, err := ldap.ParseDN(roleName)
-									if err != nil {
-										userRoles = append(userRoles, roleName)
-										continue
-									}
-
-									if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
-										continue
-									}
-
-									userRoles = append(userRoles, dn.RDNs[0].Attributes[0].Value)
-								}
-								// userData["roles"] = userRoles
-								// userData["raw_roles"] = attr.Values
-							}
-							// userData[attr.Name] = attr.Values[0]
+					for _, roleName := range ent.GetAttributeValues(ldapRoles) {
+						dn, err := ldap.ParseDN(roleName)
+						if err != nil {
+							userRoles = append(userRoles, roleName)
+							continue
 						}
+
+						if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+							continue
+						}
+
+						userRoles = append(userRoles, dn.RDNs[0].Attributes[0].Value)
 					}
 				}
 
@@ -185,7 +274,7 @@ func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 				ctx.Response.IsNewUser = true
 
 				userInfo := &ldapUser{
-					name: ctx.Request.Username,
+					name:  ctx.Request.Username,
 					roles: userRoles,
 				}
 				if len(defaultRoles) > 0 {
@@ -211,15 +300,15 @@ func LdapUserCheck(env *moo.Environment, logger log.Logger) AuthOption {
 var _ User = &ldapUser{}
 
 type ldapUser struct {
-	name string
+	name  string
 	roles []string
 }
 
-func (*ldapUser)	IsLocked() bool {
+func (*ldapUser) IsLocked() bool {
 	return false
 }
 
-func (*ldapUser)	Source() string {
+func (*ldapUser) Source() string {
 	return "ldap"
 }
 
@@ -228,6 +317,5 @@ func (*ldapUser) IngressIPList() ([]netutil.IPChecker, error) {
 }
 
 func (u *ldapUser) Roles() []string {
-		return u.roles
+	return u.roles
 }
-
@@ -10,6 +10,22 @@ type OnlineChecker interface {
 	IsOnlineExists(ctx context.Context, userid interface{}, username, loginAddress string) error
 }
 
+// SessionLimiter 用于限制一个用户同时在线的会话数
+type SessionLimiter interface {
+	// OnlineSessions 返回用户在其它地址上的会话 ID，按最后活动时间从早到晚排列
+	OnlineSessions(ctx context.Context, userid interface{}, username, loginAddress string) ([]string, error)
+
+	// EvictSession 将会话踢下线
+	EvictSession(ctx context.Context, sessionID, reason string) error
+}
+
+const (
+	// MaxSessionsReject 会话数达到上限时拒绝新的登录
+	MaxSessionsReject = "reject"
+	// MaxSessionsEvictOldest 会话数达到上限时踢掉最早的会话
+	MaxSessionsEvictOldest = "evict_oldest"
+)
+
 // IsOnlineExists(userid interface{}, username, loginAddress string) error
 
 //   // 判断用户是不是已经在其它主机上登录
@@ -32,7 +48,7 @@ type OnlineChecker interface {
 //   }
 //   return nil
 
-func OnlineCheck(online OnlineChecker, loginConflict string) AuthOption {
+func OnlineCheck(online OnlineChecker, loginConflict string, maxSessions int, maxSessionsPolicy string) AuthOption {
 	return AuthOptionFunc(func(auth *AuthService) error {
 		if online == nil {
 			return errors.New("online is missing")
@@ -65,6 +81,49 @@ func OnlineCheck(online OnlineChecker, loginConflict string) AuthOption {
 			return online.IsOnlineExists(ctx.Ctx, ctx.Request.UserID, ctx.Request.Username, ctx.Request.Address)
 		}))
 
+		if maxSessions <= 0 {
+			return nil
+		}
+
+		limiter, ok := online.(SessionLimiter)
+		if !ok {
+			return errors.New("online isnot SessionLimiter, max sessions is unsupported")
+		}
+
+		maxSessionsPolicy = strings.ToLower(maxSessionsPolicy)
+		switch maxSessionsPolicy {
+		case "":
+			maxSessionsPolicy = MaxSessionsReject
+		case MaxSessionsReject, MaxSessionsEvictOldest:
+		default:
+			return errors.New("maxSessionsPolicy is invalid - " + maxSessionsPolicy)
+		}
+
+		// 密码验证通过后才检查会话数，避免未通过验证的请求将别人踢下线
+		auth.OnAfterAuth(AuthFunc(func(ctx *AuthContext) error {
+			if !ctx.Response.IsOK {
+				return nil
+			}
+
+			sessions, err := limiter.OnlineSessions(ctx.Ctx, ctx.Request.UserID, ctx.Request.Username, ctx.Request.Address)
+			if err != nil {
+				return err
+			}
+			if len(sessions) < maxSessions {
+				return nil
+			}
+
+			if maxSessionsPolicy == MaxSessionsReject {
+				return ErrMaxSessionsExceeded
+			}
+
+			for _, id := range sessions[:len(sessions)-maxSessions+1] {
+				if err := limiter.EvictSession(ctx.Ctx, id, "max_sessions"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
 		return nil
 	})
 }
@@ -0,0 +1,36 @@
+package services
+
+import "time"
+
+// HasPasswordState 用户的密码状态
+type HasPasswordState interface {
+	// PasswordState 返回用户是否必须修改密码，密码的过期时间，以及是否要提醒用户密码即将过期
+	PasswordState(now time.Time) (mustChange bool, expiresAt time.Time, warn bool)
+}
+
+// PasswordStateCheck 登录成功后检查用户的密码是否需要修改或即将过期
+func PasswordStateCheck() AuthOption {
+	return AuthOptionFunc(func(auth *AuthService) error {
+		auth.OnAfterAuth(AuthFunc(func(ctx *AuthContext) error {
+			if !ctx.Response.IsOK || ctx.Authentication == nil {
+				return nil
+			}
+			u, ok := ctx.Authentication.(HasPasswordState)
+			if !ok {
+				return nil
+			}
+
+			mustChange, expiresAt, warn := u.PasswordState(time.Now())
+			ctx.Response.MustChangePassword = mustChange
+			ctx.Response.PasswordExpiresAt = expiresAt
+			if warn {
+				if ctx.Response.Data == nil {
+					ctx.Response.Data = map[string]interface{}{}
+				}
+				ctx.Response.Data["password_warning"] = "您的密码将于 " + expiresAt.Format("2006-01-02 15:04") + " 过期，请尽快修改"
+			}
+			return nil
+		}))
+		return nil
+	})
+}
@@ -0,0 +1,195 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+)
+
+// PermissionManageSessions 查询和终止其它用户的会话的权限
+const PermissionManageSessions = "um.sessions.manage"
+
+// ErrSessionTerminated 会话已过期或被终止（如被管理员踢下线）后, 原来的 cookie 和 JWT 都不能再用了
+var ErrSessionTerminated = errors.NewError(http.StatusUnauthorized, "会话不存在或已被终止")
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionManageSessions, Title: "管理在线会话", Group: "会话管理"})
+}
+
+// sessionLimiter 为 services.OnlineCheck 提供会话数限制
+type sessionLimiter struct {
+	Sessions
+
+	mgr *LoginManager
+}
+
+func (l *sessionLimiter) OnlineSessions(ctx context.Context, userid interface{}, username, loginAddress string) ([]string, error) {
+	list, err := l.Query(ctx, username, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdatedAt.UnixNano() < list[j].UpdatedAt.UnixNano()
+	})
+
+	idlist := make([]string, 0, len(list))
+	for idx := range list {
+		// 同一个地址登录时会沿用原来的会话, 模拟登录的会话也不是用户自己的, 都不算在内
+		if list[idx].Address == loginAddress || list[idx].ImpersonatorID != 0 {
+			continue
+		}
+		idlist = append(idlist, list[idx].UUID)
+	}
+	return idlist, nil
+}
+
+func (l *sessionLimiter) EvictSession(ctx context.Context, sessionID, reason string) error {
+	return l.mgr.Terminate(ctx, sessionID, reason, "")
+}
+
+// QuerySessions 按用户名和地址查询会话, limit 为 0 时不分页
+func (mgr *LoginManager) QuerySessions(ctx context.Context, username, address string, offset, limit int64) ([]SessionInfo, error) {
+	return mgr.online.Query(ctx, username, address, offset, limit)
+}
+
+// Terminate 终止一个会话, 并在 Bus 上发送 api.BusSessionTerminated 事件
+func (mgr *LoginManager) Terminate(ctx context.Context, sessionID, reason, operator string) error {
+	sessionInfo, err := mgr.online.Get(ctx, sessionID)
+	if err != nil {
+		return errors.Wrap(err, "读会话失败")
+	}
+	if sessionInfo == nil {
+		return errors.ErrNotFoundWithText("会话不存在!")
+	}
+
+	if err := mgr.online.Logout(ctx, sessionID); err != nil {
+		return errors.Wrap(err, "unregistr user from online table fail")
+	}
+
+	mgr.logger.Info("会话被终止", log.String("session", sessionID),
+		log.String("username", sessionInfo.Username),
+		log.String("address", sessionInfo.Address),
+		log.String("reason", reason),
+		log.String("operator", operator))
+
+	if mgr.bus != nil {
+		err = mgr.bus.Emit(ctx, api.BusSessionTerminated, &SessionTerminated{
+			Session:  *sessionInfo,
+			Reason:   reason,
+			Operator: operator,
+		})
+		if err != nil {
+			mgr.logger.Warn("发送会话终止事件失败", log.String("session", sessionID), log.Error(err))
+		}
+	}
+	return nil
+}
+
+// TerminateSessions 终止符合条件的所有会话，返回终止的会话数
+func (mgr *LoginManager) TerminateSessions(ctx context.Context, username, address, reason, operator string) (int, error) {
+	list, err := mgr.QuerySessions(ctx, username, address, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for idx := range list {
+		if err := mgr.Terminate(ctx, list[idx].UUID, reason, operator); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func readInt64Param(queryParams url.Values, name string) (int64, error) {
+	s := queryParams.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || i < 0 {
+		return 0, errors.New("'" + name + "' is invalid")
+	}
+	return i, nil
+}
+
+func checkManageSessions(ctx context.Context) (api.User, error) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionManageSessions)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, errors.NewError(http.StatusForbidden, "没有管理会话的权限")
+	}
+	return currentUser, nil
+}
+
+// Query 管理员按用户名和地址查询会话
+func (mgr *LoginManager) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, err := checkManageSessions(ctx); err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	queryParams := r.URL.Query()
+	offset, err := readInt64Param(queryParams, "offset")
+	if err != nil {
+		ReturnError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := readInt64Param(queryParams, "limit")
+	if err != nil {
+		ReturnError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := mgr.QuerySessions(ctx, queryParams.Get("username"), queryParams.Get("address"), offset, limit)
+	if err != nil {
+		ReturnError(w, r, "query online users fail - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ReturnJSON(w, r, list, http.StatusOK)
+}
+
+// Kick 管理员终止指定的会话, 或者终止某个用户（或地址）的所有会话
+func (mgr *LoginManager) Kick(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := checkManageSessions(ctx)
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	queryParams := r.URL.Query()
+	if id := queryParams.Get("id"); id != "" {
+		if err := mgr.Terminate(ctx, id, "kick", currentUser.Name()); err != nil {
+			ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+			return
+		}
+		ReturnJSON(w, r, map[string]interface{}{"count": 1}, http.StatusOK)
+		return
+	}
+
+	username := queryParams.Get("username")
+	address := queryParams.Get("address")
+	if username == "" && address == "" {
+		ReturnError(w, r, "id, username or address is missing", http.StatusBadRequest)
+		return
+	}
+
+	count, err := mgr.TerminateSessions(ctx, username, address, "kick", currentUser.Name())
+	if err != nil {
+		ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	ReturnJSON(w, r, map[string]interface{}{"count": count}, http.StatusOK)
+}
@@ -0,0 +1,79 @@
+package authn
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/runner-mei/log"
+)
+
+type testSessions struct {
+	Sessions
+
+	list    map[string]*SessionInfo
+	touched []string
+	seq     int
+}
+
+func (s *testSessions) Get(ctx context.Context, id string) (*SessionInfo, error) {
+	return s.list[id], nil
+}
+
+// Login 和 sessions/inmem 一样, 同一个用户从同一个地址登录时沿用原来的会话
+func (s *testSessions) Login(ctx context.Context, userid interface{}, username, address string) (string, error) {
+	for id, si := range s.list {
+		if si.Username == username && si.Address == address && si.ImpersonatorID == 0 {
+			return id, nil
+		}
+	}
+	return s.Impersonate(ctx, userid, username, address, 0)
+}
+
+func (s *testSessions) Impersonate(ctx context.Context, userid interface{}, username, address string, impersonatorID int64) (string, error) {
+	s.seq++
+	id := "s" + strconv.Itoa(s.seq)
+	s.list[id] = &SessionInfo{UUID: id, UserID: userid, Username: username, Address: address, ImpersonatorID: impersonatorID}
+	return id, nil
+}
+
+func (s *testSessions) Logout(ctx context.Context, id string) error {
+	delete(s.list, id)
+	return nil
+}
+
+func (s *testSessions) IsTracked() bool {
+	return true
+}
+
+func (s *testSessions) UpdateNow(ctx context.Context, id string) error {
+	s.touched = append(s.touched, id)
+	return nil
+}
+
+func TestCheckSessionAfterTerminate(t *testing.T) {
+	ctx := context.Background()
+	online := &testSessions{list: map[string]*SessionInfo{
+		"abc": {UUID: "abc", Username: "tom", Address: "192.168.1.2"},
+	}}
+	mgr := &LoginManager{logger: log.Empty(), online: online}
+
+	if err := mgr.checkSession(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(online.touched) != 1 || online.touched[0] != "abc" {
+		t.Error("session isnot touched", online.touched)
+	}
+
+	if err := mgr.Terminate(ctx, "abc", "kick", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.checkSession(ctx, "abc"); err != ErrSessionTerminated {
+		t.Error("kicked session is accepted -", err)
+	}
+
+	// 不保存会话时会话 ID 为空
+	if err := mgr.checkSession(ctx, ""); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,46 @@
+package authn_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/runner-mei/moo/api/authclient"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authn/sessions/empty"
+)
+
+func TestValidateSessionCookieWithoutStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := &authn.Config{
+		SessionKey:       authclient.DefaultSessionKey,
+		SessionHashFunc:  "sha1",
+		SessionSecretKey: []byte("secret"),
+	}
+	online := empty.EmptySessions{}
+	mgr := authn.NewLoginManagerForTest(cfg, online)
+
+	sessionID, err := online.Login(ctx, int64(1), "tom", "192.168.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 和 Renderer.setSessionCookie 一样生成 cookie, 会话 ID 为空时 Encode 会生成一个随机的
+	values := url.Values{}
+	values.Set(authclient.SESSION_ID_KEY, sessionID)
+	values.Set(authclient.SESSION_EXPIRE_KEY, "session")
+	values.Set(authclient.SESSION_VALID_KEY, "true")
+	values.Set(authclient.SESSION_USER_KEY, "tom")
+	cookie := authclient.Encode(values, cfg.GetSessionHashFunc(), cfg.SessionSecretKey)
+	if values.Get(authclient.SESSION_ID_KEY) == "" {
+		t.Fatal("session id isnot generated")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cfg.SessionKey, Value: cookie})
+	if _, err := mgr.ValidateSessionCookie(ctx, req); err != nil {
+		t.Error("session isnot tracked, but it is rejected -", err)
+	}
+}
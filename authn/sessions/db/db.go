@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	moodb "github.com/runner-mei/moo/db"
+	"github.com/runner-mei/moo/users/usermodels"
+	"go.uber.org/fx"
+)
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return fx.Provide(func(lifecycle fx.Lifecycle, env *moo.Environment, model moodb.InModelFactory, logger log.Logger) (authn.Sessions, authn.SessionsForTest) {
+			mgr := NewSessionManager(env, usermodels.NewOnlineUserDao(model.Factory.SessionReference()), logger)
+
+			var timer util.Timer
+
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					timer.Start(env.Config.DurationWithDefault("sessions.db.check_interval", 60*time.Second),
+						func() bool {
+							if err := mgr.DeleteExpired(context.Background()); err != nil {
+								mgr.logger.Warn("删除过期的会话失败", log.Error(err))
+							}
+							return true
+						})
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					timer.Stop()
+					return nil
+				},
+			})
+			return mgr, mgr
+		})
+	})
+}
+
+// SessionManager 将会话保存在 moo_online_users 表中，多个节点共用一个数据库时会话是共享的
+type SessionManager struct {
+	logger        log.Logger
+	dao           usermodels.OnlineUserDao
+	expires       string
+	loginConflict string
+}
+
+func NewSessionManager(env *moo.Environment, dao usermodels.OnlineUserDao, logger log.Logger) *SessionManager {
+	return &SessionManager{
+		logger:        logger.Named("sessions"),
+		dao:           dao,
+		expires:       env.Config.StringWithDefault("sessions.db.expires", env.Config.StringWithDefault(api.CfgUserOnlineExpired, "30 MINUTE")),
+		loginConflict: strings.ToLower(env.Config.StringWithDefault(api.CfgUserLoginConflict, "")),
+	}
+}
+
+func toSessionInfo(ou *usermodels.OnlineUser) authn.SessionInfo {
+	return authn.SessionInfo{
+		UUID:      ou.Uuid,
+		UserID:    ou.UserID,
+		Username:  ou.Username,
+		Address:   ou.Address,
+		CreatedAt: util.ToUnixTime(ou.CreatedAt),
+		UpdatedAt: util.ToUnixTime(ou.UpdatedAt),
+
+		ImpersonatorID: ou.ImpersonatorID,
+	}
+}
+
+func toSessionInfos(list []usermodels.OnlineUser) []authn.SessionInfo {
+	results := make([]authn.SessionInfo, 0, len(list))
+	for idx := range list {
+		results = append(results, toSessionInfo(&list[idx]))
+	}
+	return results
+}
+
+func toUserID(userid interface{}) (int64, error) {
+	if userid == nil {
+		return 0, errors.New("userid is missing")
+	}
+	id := as.Int64WithDefault(userid, 0)
+	if id == 0 {
+		return 0, errors.New("userid is invalid")
+	}
+	return id, nil
+}
+
+func (mgr *SessionManager) Count(ctx context.Context, username string, address string) (int, error) {
+	list, err := mgr.dao.Query(ctx, 0, username, address, mgr.expires, 0, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "查询在线用户失败")
+	}
+	return len(list), nil
+}
+
+func (mgr *SessionManager) UpdateNow(ctx context.Context, id string) error {
+	_, err := mgr.dao.TouchByUUID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "更新会话的活动时间失败")
+	}
+	return nil
+}
+
+func (mgr *SessionManager) IsTracked() bool {
+	return true
+}
+
+func (mgr *SessionManager) Get(ctx context.Context, id string) (*authn.SessionInfo, error) {
+	var ou usermodels.OnlineUser
+	err := mgr.dao.GetByUUID(ctx, id, mgr.expires)(&ou)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, errors.ErrNotFoundWithText("会话不存在或已过期")
+		}
+		return nil, errors.Wrap(err, "查询会话失败")
+	}
+	si := toSessionInfo(&ou)
+	return &si, nil
+}
+
+func (mgr *SessionManager) All(ctx context.Context) ([]authn.SessionInfo, error) {
+	list, err := mgr.dao.Query(ctx, 0, "", "", mgr.expires, 0, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询在线用户失败")
+	}
+	return toSessionInfos(list), nil
+}
+
+func (mgr *SessionManager) Query(ctx context.Context, username, address string, offset, limit int64) ([]authn.SessionInfo, error) {
+	list, err := mgr.dao.Query(ctx, 0, username, address, mgr.expires, offset, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询在线用户失败")
+	}
+	return toSessionInfos(list), nil
+}
+
+func (mgr *SessionManager) Login(ctx context.Context, userid interface{}, username, loginAddress string) (string, error) {
+	userID, err := toUserID(userid)
+	if err != nil {
+		return "", err
+	}
+
+	// 同一个用户从同一个地址登录时沿用原来的会话
+	list, err := mgr.dao.Query(ctx, userID, "", loginAddress, mgr.expires, 0, 0)
+	if err != nil {
+		return "", errors.Wrap(err, "查询在线用户失败")
+	}
+	for idx := range list {
+		// 模拟登录的会话不是用户自己的, 不能沿用
+		if list[idx].ImpersonatorID != 0 {
+			continue
+		}
+		if _, err := mgr.dao.TouchByUUID(ctx, list[idx].Uuid); err != nil {
+			return "", errors.Wrap(err, "更新会话的活动时间失败")
+		}
+		return list[idx].Uuid, nil
+	}
+
+	uuid := authn.GenerateID()
+	if _, err := mgr.dao.Upsert(ctx, userID, loginAddress, uuid); err != nil {
+		return "", errors.Wrap(err, "创建会话失败")
+	}
+
+	// 强制登录时将用户在其它地址上的会话踢下线
+	if mgr.loginConflict == "force" {
+		if _, err := mgr.dao.DeleteByUserID(ctx, userID, uuid); err != nil {
+			mgr.logger.Warn("删除用户的其它会话失败", log.String("username", username), log.Error(err))
+		}
+	}
+	return uuid, nil
+}
+
+func (mgr *SessionManager) Impersonate(ctx context.Context, userid interface{}, username, loginAddress string, impersonatorID int64) (string, error) {
+	userID, err := toUserID(userid)
+	if err != nil {
+		return "", err
+	}
+
+	uuid := authn.GenerateID()
+	if _, err := mgr.dao.CreateImpersonation(ctx, userID, loginAddress, uuid, impersonatorID); err != nil {
+		return "", errors.Wrap(err, "创建会话失败")
+	}
+	return uuid, nil
+}
+
+func (mgr *SessionManager) Logout(ctx context.Context, id string) error {
+	_, err := mgr.dao.DeleteByUUID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "删除会话失败")
+	}
+	return nil
+}
+
+func (mgr *SessionManager) IsOnlineExists(ctx context.Context, userid interface{}, username, loginAddress string) error {
+	if mgr.loginConflict == "force" {
+		return nil
+	}
+
+	// 这个方法在 OnBeforeLoad 中调用, 这时还没有读用户, 所以 userid 往往是空的, 按用户名来查
+	// (和 inmem 中的实现一样), 新用户在表中没有记录, 肯定不在线
+	userID, _ := toUserID(userid)
+
+	// 判断用户是不是已经在其它主机上登录
+	list, err := mgr.dao.Query(ctx, userID, username, "", mgr.expires, 0, 0)
+	if err != nil {
+		return errors.Wrap(err, "查询在线用户失败")
+	}
+
+	var onlineList = make([]authn.SessionInfo, 0, len(list))
+	for idx := range list {
+		if list[idx].ImpersonatorID != 0 {
+			continue
+		}
+		if list[idx].Address == loginAddress {
+			return nil
+		}
+		onlineList = append(onlineList, toSessionInfo(&list[idx]))
+	}
+
+	if len(onlineList) > 0 {
+		return &authn.ErrOnline{OnlineList: onlineList}
+	}
+	return nil
+}
+
+func (mgr *SessionManager) DeleteExpired(ctx context.Context) error {
+	_, err := mgr.dao.DeleteExpired(ctx, mgr.expires)
+	return err
+}
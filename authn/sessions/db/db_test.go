@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type testDao struct {
+	usermodels.OnlineUserDao
+
+	names map[int64]string
+	list  []usermodels.OnlineUser
+}
+
+func (dao *testDao) Query(ctx context.Context, userID int64, username, address, interval string, offset, limit int64) ([]usermodels.OnlineUser, error) {
+	var results []usermodels.OnlineUser
+	for _, ou := range dao.list {
+		if userID > 0 && ou.UserID != userID {
+			continue
+		}
+		if username != "" && ou.Username != username {
+			continue
+		}
+		if address != "" && ou.Address != address {
+			continue
+		}
+		results = append(results, ou)
+	}
+	return results, nil
+}
+
+func (dao *testDao) Upsert(ctx context.Context, userID int64, address, uuid string) (int64, error) {
+	dao.list = append(dao.list, usermodels.OnlineUser{
+		UserID:   userID,
+		Username: dao.names[userID],
+		Address:  address,
+		Uuid:     uuid,
+	})
+	return 1, nil
+}
+
+func (dao *testDao) CreateImpersonation(ctx context.Context, userID int64, address, uuid string, impersonatorID int64) (int64, error) {
+	dao.list = append(dao.list, usermodels.OnlineUser{
+		UserID:         userID,
+		Username:       dao.names[userID],
+		Address:        address,
+		Uuid:           uuid,
+		ImpersonatorID: impersonatorID,
+	})
+	return 1, nil
+}
+
+func (dao *testDao) TouchByUUID(ctx context.Context, uuid string) (int64, error) {
+	return 1, nil
+}
+
+func TestIsOnlineExists(t *testing.T) {
+	ctx := context.Background()
+	mgr := &SessionManager{
+		logger:  log.Empty(),
+		dao:     &testDao{names: map[int64]string{1: "tom"}},
+		expires: "30 MINUTE",
+	}
+
+	if _, err := mgr.Login(ctx, int64(1), "tom", "192.168.1.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// OnBeforeLoad 中调用时还没有 userid
+	err := mgr.IsOnlineExists(ctx, nil, "tom", "192.168.1.3")
+	if e, ok := err.(*authn.ErrOnline); !ok {
+		t.Fatal("second login isnot detected -", err)
+	} else if len(e.OnlineList) != 1 || e.OnlineList[0].Address != "192.168.1.2" {
+		t.Error(e.OnlineList)
+	}
+
+	if err := mgr.IsOnlineExists(ctx, nil, "tom", "192.168.1.2"); err != nil {
+		t.Error("login from same address -", err)
+	}
+	if err := mgr.IsOnlineExists(ctx, nil, "jerry", "192.168.1.3"); err != nil {
+		t.Error("other user -", err)
+	}
+
+	mgr.loginConflict = "force"
+	if err := mgr.IsOnlineExists(ctx, nil, "tom", "192.168.1.3"); err != nil {
+		t.Error("force login -", err)
+	}
+}
+
+func TestImpersonateSession(t *testing.T) {
+	ctx := context.Background()
+	mgr := &SessionManager{
+		logger:  log.Empty(),
+		dao:     &testDao{names: map[int64]string{1: "tom"}},
+		expires: "30 MINUTE",
+	}
+
+	impersonated, err := mgr.Impersonate(ctx, int64(1), "tom", "192.168.1.2", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 用户自己从同一个地址登录时不能沿用模拟登录的会话, 也不算在其它地址上登录
+	if err := mgr.IsOnlineExists(ctx, nil, "tom", "192.168.1.3"); err != nil {
+		t.Error("impersonated session is counted -", err)
+	}
+	sessionID, err := mgr.Login(ctx, int64(1), "tom", "192.168.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID == impersonated {
+		t.Fatal("impersonated session is reused")
+	}
+
+	other, err := mgr.Impersonate(ctx, int64(1), "tom", "192.168.1.2", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == sessionID || other == impersonated {
+		t.Error("session of impersonation is reused")
+	}
+}
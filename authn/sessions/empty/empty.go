@@ -24,13 +24,16 @@ func (sess EmptySessions) Count(ctx context.Context, username string, address st
 func (sess EmptySessions) Login(ctx context.Context, userid interface{}, username, address string) (string, error) {
 	return "", nil
 }
+func (sess EmptySessions) Impersonate(ctx context.Context, userid interface{}, username, address string, impersonatorID int64) (string, error) {
+	return "", nil
+}
 func (sess EmptySessions) Logout(ctx context.Context, key string) error {
 	return nil
 }
 func (sess EmptySessions) Get(ctx context.Context, id string) (*authn.SessionInfo, error) {
 	return nil, nil
 }
-func (sess EmptySessions) Query(ctx context.Context, username string) ([]authn.SessionInfo, error) {
+func (sess EmptySessions) Query(ctx context.Context, username, address string, offset, limit int64) ([]authn.SessionInfo, error) {
 	return nil, nil
 }
 func (sess EmptySessions) All(ctx context.Context) ([]authn.SessionInfo, error) {
@@ -39,6 +42,9 @@ func (sess EmptySessions) All(ctx context.Context) ([]authn.SessionInfo, error)
 func (sess EmptySessions) UpdateNow(ctx context.Context, key string) error {
 	return nil
 }
+func (sess EmptySessions) IsTracked() bool {
+	return false
+}
 func (sess EmptySessions) DeleteExpired(ctx context.Context) error {
 	return nil
 }
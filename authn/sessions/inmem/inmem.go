@@ -3,6 +3,7 @@ package inmem
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -84,16 +85,50 @@ func (mgr *SessionManager) UpdateNow(ctx context.Context, id string) error {
 	s := mgr.list[id]
 	mgr.mu.RUnlock()
 
-	s.UpdatedAt.AtomicSet(time.Now())
+	if s != nil {
+		s.UpdatedAt.AtomicSet(time.Now())
+	}
 	return nil
 }
 
+func (mgr *SessionManager) IsTracked() bool {
+	return true
+}
+
 func (mgr *SessionManager) Get(ctx context.Context, id string) (*authn.SessionInfo, error) {
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
 	return mgr.list[id], nil
 }
 
+func (mgr *SessionManager) Query(ctx context.Context, username, address string, offset, limit int64) ([]authn.SessionInfo, error) {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	var results []authn.SessionInfo
+
+	for _, s := range mgr.list {
+		if username != "" && s.Username != username {
+			continue
+		}
+		if address != "" && s.Address != address {
+			continue
+		}
+		results = append(results, *s)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].UpdatedAt.UnixNano() > results[j].UpdatedAt.UnixNano()
+	})
+
+	if offset >= int64(len(results)) {
+		return nil, nil
+	}
+	results = results[offset:]
+	if limit > 0 && limit < int64(len(results)) {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
 func (mgr *SessionManager) All(ctx context.Context) ([]authn.SessionInfo, error) {
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
@@ -115,7 +150,8 @@ func (mgr *SessionManager) Login(ctx context.Context, userid interface{}, userna
 	var old *authn.SessionInfo
 
 	for _, s := range mgr.list {
-		if s.Username == username && s.Address == loginAddress {
+		// 模拟登录的会话不是用户自己的, 不能沿用
+		if s.Username == username && s.Address == loginAddress && s.ImpersonatorID == 0 {
 			old = s
 			break
 		}
@@ -136,6 +172,26 @@ func (mgr *SessionManager) Login(ctx context.Context, userid interface{}, userna
 	return uuid, nil
 }
 
+func (mgr *SessionManager) Impersonate(ctx context.Context, userid interface{}, username, loginAddress string, impersonatorID int64) (string, error) {
+	if userid == nil {
+		return "", errors.New("userid is missing")
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	uuid := authn.GenerateID()
+	mgr.list[uuid] = &authn.SessionInfo{
+		UUID:           uuid,
+		UserID:         userid,
+		Username:       username,
+		Address:        loginAddress,
+		CreatedAt:      util.ToUnixTime(time.Now()),
+		UpdatedAt:      util.ToUnixTime(time.Now()),
+		ImpersonatorID: impersonatorID,
+	}
+	return uuid, nil
+}
+
 func (mgr *SessionManager) Logout(ctx context.Context, id string) error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
@@ -150,7 +206,7 @@ func (mgr *SessionManager) IsOnlineExists(ctx context.Context, userid interface{
 	var onlineList = make([]authn.SessionInfo, 0, 4)
 
 	for _, s := range mgr.list {
-		if s.Username == username {
+		if s.Username == username && s.ImpersonatorID == 0 {
 			if s.Address == loginAddress {
 				return nil
 			}
@@ -31,6 +31,7 @@ func init() {
 			}))
 			sessionuiMux.GET("/login", loong.WrapContextHandler(sessions.LoginGet))
 			sessionuiMux.POST("/login", loong.WrapContextHandler(sessions.LoginPost))
+			sessionuiMux.POST("/change_password", loong.WrapContextHandler(sessions.ChangePassword))
 			// sessionuiMux.GET(urlutil.Join(sessionPrefix, "logout"), loong.WrapContextHandler(sessions.Logout))
 			sessionuiMux.Any("/logout", loong.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				// 这里需要根据不同的用户跳到不同的退出界面上
@@ -77,6 +78,25 @@ func init() {
 			sessionMux.GET("/current", getFunc)
 			sessionMux.GET("/current/", getFunc)
 
+			queryHTTPFunc := loong.RawHTTPAuth(ReturnError, sessions.AuthValidates()...)(sessions.Query)
+			sessionMux.GET("/query", loong.WrapContextHandler(queryHTTPFunc))
+
+			kickHTTPFunc := loong.RawHTTPAuth(ReturnError, sessions.AuthValidates()...)(sessions.Kick)
+			sessionMux.DELETE("/kick", loong.WrapContextHandler(kickHTTPFunc))
+			sessionMux.POST("/kick", loong.WrapContextHandler(kickHTTPFunc))
+
+			listFailuresHTTPFunc := loong.RawHTTPAuth(ReturnError, sessions.AuthValidates()...)(sessions.ListLoginFailures)
+			sessionMux.GET("/login_failures", loong.WrapContextHandler(listFailuresHTTPFunc))
+
+			clearFailuresHTTPFunc := loong.RawHTTPAuth(ReturnError, sessions.AuthValidates()...)(sessions.ClearLoginFailures)
+			sessionMux.DELETE("/login_failures", loong.WrapContextHandler(clearFailuresHTTPFunc))
+
+			impersonateHTTPFunc := loong.RawHTTPAuth(ReturnError, sessions.AuthValidates()...)(sessions.Impersonate)
+			sessionMux.POST("/impersonate", loong.WrapContextHandler(impersonateHTTPFunc))
+			getImpersonationHTTPFunc := loong.RawHTTPAuth(ReturnError, sessions.AuthValidates()...)(sessions.GetImpersonation)
+			sessionMux.GET("/impersonate", loong.WrapContextHandler(getImpersonationHTTPFunc))
+			sessionMux.DELETE("/impersonate", loong.WrapContextHandler(sessions.EndImpersonation))
+
 			getTokenFunc := loong.WrapContextHandler(sessions.GetCurrentToken)
 			sessionMux.GET("/current_token", getTokenFunc)
 			sessionMux.GET("/current_token/", getTokenFunc)
@@ -0,0 +1,76 @@
+/*
+ * 模拟登录的提示条, 在页面中引用本文件即可
+ *   <script src="<prefix>/sessions/static/js/impersonation.js"></script>
+ * 当前会话是管理员模拟其它用户登录时, 在页面顶部显示提示和 "结束模拟" 按钮
+ */
+(function () {
+  var script = document.currentScript;
+  var prefix = '';
+  if (script && script.src) {
+    var idx = script.src.indexOf('/sessions/static/');
+    if (idx >= 0) {
+      prefix = script.src.substring(0, idx);
+    }
+  }
+  var url = prefix + '/api/sessions/impersonate';
+
+  function request(method, cb) {
+    var xhr = new XMLHttpRequest();
+    xhr.open(method, url, true);
+    xhr.setRequestHeader('Accept', 'application/json');
+    xhr.setRequestHeader('Content-Type', 'application/json');
+    xhr.onreadystatechange = function () {
+      if (xhr.readyState !== 4) {
+        return;
+      }
+      var data = null;
+      try {
+        data = JSON.parse(xhr.responseText);
+      } catch (e) {}
+      cb(xhr.status, data);
+    };
+    xhr.send();
+  }
+
+  function showBanner(data) {
+    var banner = document.createElement('div');
+    banner.id = 'moo-impersonation-banner';
+    banner.style.cssText = 'position:fixed;top:0;left:0;right:0;z-index:10000;padding:6px 12px;' +
+      'background:#f8ac59;color:#fff;text-align:center;font-size:14px;';
+
+    var text = document.createElement('span');
+    text.appendChild(document.createTextNode('你正在以其它用户的身份登录 (管理员: ' + data.impersonator_name + ') '));
+    banner.appendChild(text);
+
+    var button = document.createElement('a');
+    button.href = 'javascript:void(0)';
+    button.style.cssText = 'color:#fff;text-decoration:underline;margin-left:8px;';
+    button.appendChild(document.createTextNode('结束模拟'));
+    button.onclick = function () {
+      request('DELETE', function (status, result) {
+        if (status >= 200 && status < 300) {
+          window.location.reload();
+          return;
+        }
+        alert('结束模拟失败: ' + ((result && result.message) || status));
+      });
+    };
+    banner.appendChild(button);
+
+    document.body.appendChild(banner);
+  }
+
+  function init() {
+    request('GET', function (status, data) {
+      if (status === 200 && data && data.impersonating) {
+        showBanner(data);
+      }
+    });
+  }
+
+  if (document.readyState === 'loading') {
+    document.addEventListener('DOMContentLoaded', init);
+  } else {
+    init();
+  }
+})();
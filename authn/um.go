@@ -69,6 +69,25 @@ func (u *tokenUser) IngressIPList() ([]netutil.IPChecker, error) {
 	return []netutil.IPChecker{}, nil
 }
 
+// checkTokenSession 在 JWT 校验通过后检查它所属的会话是否还在, JWT 的 Id 就是会话的 ID
+func checkTokenSession(check func(ctx context.Context, sessionID string) error, cb loong.TokenCheckFunc) loong.TokenCheckFunc {
+	return func(ctx context.Context, req *http.Request, tokenStr string) (context.Context, error) {
+		ctx, err := cb(ctx, req, tokenStr)
+		if err != nil {
+			return ctx, err
+		}
+
+		if token, ok := loong.TokenFromContext(ctx).(*jwt.Token); ok {
+			if claims, ok := token.Claims.(*jwt.StandardClaims); ok {
+				if err := check(ctx, claims.Id); err != nil {
+					return ctx, err
+				}
+			}
+		}
+		return ctx, nil
+	}
+}
+
 func tokenToUser(um api.UserManager, cb loong.TokenCheckFunc) loong.TokenCheckFunc {
 	if um == nil {
 		return cb
@@ -85,6 +104,14 @@ func tokenToUser(um api.UserManager, cb loong.TokenCheckFunc) loong.TokenCheckFu
 			return ctx, err
 		}
 
+		if token, ok := loong.TokenFromContext(ctx).(*jwt.Token); ok {
+			if claims, ok := token.Claims.(*jwt.StandardClaims); ok {
+				if impersonation := impersonationFromSubject(claims.Subject); impersonation != nil {
+					ctx = api.ContextWithImpersonation(ctx, impersonation)
+				}
+			}
+		}
+
 		return api.ContextWithReadCurrentUser(ctx, api.ReadCurrentUserFunc(func(ctx context.Context) (api.User, error) {
 			o := loong.TokenFromContext(ctx)
 			if o == nil {
@@ -9,7 +9,6 @@ import (
 	"strings"
 
 	"github.com/runner-mei/errors"
-	"github.com/runner-mei/goutils/tid"
 	"github.com/runner-mei/log"
 	"github.com/runner-mei/moo"
 	"github.com/runner-mei/moo/authn"
@@ -141,8 +140,10 @@ func (c *UsbKey) Login(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		io.WriteString(w, strings.Replace(to, "\"", "'", -1))
 		if isUUID {
 			io.WriteString(w, "\", \"uuid\": \"")
-			uuid := tid.GenerateID()
-			c.uuidLogin.Set(ctx, uuid, username)
+			uuid, err := c.uuidLogin.IssueForSelf(ctx, username, authn.RealIP(r))
+			if err != nil {
+				c.logger.Warn("生成登录链接失败", log.Error(err))
+			}
 			io.WriteString(w, uuid)
 		}
 
@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"database/sql/driver"
 	"encoding/binary"
 	"encoding/hex"
@@ -223,6 +224,14 @@ func RealIP(req *http.Request) string {
 	return ra
 }
 
+// ClientCertificate 返回客户端在 TLS 握手时出示的证书, 没有时返回 nil
+func ClientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return req.TLS.PeerCertificates[0]
+}
+
 func IsConsumeJSON(r *http.Request) bool {
 	accept := r.Header.Get(HeaderAccept)
 	contentType := r.Header.Get(HeaderContentType)
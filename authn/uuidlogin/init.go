@@ -4,8 +4,11 @@ import (
 	"net/http"
 
 	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
 	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
 	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/db"
 	"github.com/runner-mei/moo/users/usermodels"
 	"go.uber.org/fx"
 )
@@ -16,17 +19,45 @@ type Params struct {
 	Renderer *authn.Renderer
 	Sessions authn.Sessions
 	Users    *usermodels.Users
+	OpLogger api.OperationLogger
+	Model    db.InModelFactory
 }
 
 func init() {
 	moo.On(func(*moo.Environment) moo.Option {
-		return fx.Invoke(func(env *moo.Environment, params Params, httpSrv *moo.HTTPServer, middlewares moo.Middlewares, logger log.Logger) error {
-			uuidProxy := NewUuidLogin(env, params.Renderer, params.Sessions, params.Users)
+		return moo.Provide(func(env *moo.Environment, params Params) (*UuidLogin, error) {
+			return NewUuidLogin(env, params.Renderer, params.Sessions, params.Users,
+				usermodels.NewUserTokenDao(params.Model.Factory.SessionReference()), params.OpLogger)
+		})
+	})
+
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Invoke(func(uuidProxy *UuidLogin, httpSrv *moo.HTTPServer, logger log.Logger) {
 			httpSrv.FastRoute(false, "uuid", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				uuidProxy.Login(r.Context(), w, r)
 			}))
+
+			mux := httpSrv.Engine().Group("api/login_links", httpSrv.AuthMiddlewares())
+			issue := func(ctx *loong.Context) error {
+				currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+				if err != nil {
+					return ctx.ReturnError(err, http.StatusUnauthorized)
+				}
+
+				var req IssueRequest
+				if err := ctx.Bind(&req); err != nil {
+					return ctx.ReturnError(loong.ErrBadArgument("body", "", err), http.StatusBadRequest)
+				}
+
+				result, err := uuidProxy.Issue(ctx.StdContext, currentUser, &req)
+				if err != nil {
+					return ctx.ReturnError(err)
+				}
+				return ctx.ReturnCreatedResult(result)
+			}
+			mux.POST("", issue)
+			mux.POST("/", issue)
 			logger.Info("uuid login started")
-			return nil
 		})
 	})
 }
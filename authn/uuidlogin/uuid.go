@@ -2,73 +2,357 @@ package uuidlogin
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/urlutil"
 	"github.com/runner-mei/log"
 	"github.com/runner-mei/moo"
 	"github.com/runner-mei/moo/api"
 	"github.com/runner-mei/moo/authn"
 	"github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/authz"
 	"github.com/runner-mei/moo/users/usermodels"
 )
 
+// PermissionIssue 给其它用户生成一次性登录链接的权限
+const PermissionIssue = "um.login_links.issue"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionIssue, Title: "生成一次性登录链接", Group: "会话管理"})
+}
+
+var (
+	ErrLinkInvalid      = errors.NewError(http.StatusUnauthorized, "登录链接无效")
+	ErrLinkExpired      = errors.NewError(http.StatusUnauthorized, "登录链接已过期")
+	ErrLinkUsed         = errors.NewError(http.StatusUnauthorized, "登录链接已经使用过了")
+	ErrLinkAddress      = errors.NewError(http.StatusUnauthorized, "登录链接不允许从该地址使用")
+	ErrUserDisabled     = errors.NewError(http.StatusUnauthorized, "用户已被禁用")
+	ErrTargetURLInvalid = errors.NewError(http.StatusBadRequest, "跳转地址必须是本站的相对路径")
+	ErrAddressInvalid   = errors.NewError(http.StatusBadRequest, "绑定的地址不是有效的 IP 地址")
+	ErrExpiresTooLong   = errors.NewError(http.StatusBadRequest, "有效期超过了允许的最大值")
+	ErrPermissionDenny  = errors.NewError(http.StatusForbidden, "没有生成登录链接的权限")
+)
+
+// IssueRequest 生成登录链接的参数
+type IssueRequest struct {
+	Username string `json:"username"`
+	// TargetURL 登录成功后跳转的地址, 只能是本站的相对路径
+	TargetURL string `json:"target_url,omitempty"`
+	// Address 只允许从这个地址使用, 为空时不限制
+	Address string `json:"address,omitempty"`
+	// ExpiresIn 有效期(秒), 为 0 时使用 users.login_link.expires
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+// IssueResult 生成登录链接的结果
+type IssueResult struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UuidLogin 一次性登录链接，链接中的令牌带有签名，服务端只保存令牌的摘要，
+// 使用时在数据库中标记为已用，所以在集群中也只能用一次
 type UuidLogin struct {
 	logger      log.Logger
 	renderer    *authn.Renderer
 	users       *usermodels.Users
 	sessions    authn.Sessions
-	mu          sync.Mutex
-	keys        map[string]string
+	tokens      usermodels.UserTokenDao
+	opLogger    api.OperationLogger
+	signer      signer
 	redirectURL string
+	baseURL     string
+	expires     time.Duration
+	maxExpires  time.Duration
 }
 
 // NewUuidLogin creates a Client with the provided Options.
 func NewUuidLogin(env *moo.Environment,
 	renderer *authn.Renderer,
 	sessions authn.Sessions,
-	users *usermodels.Users) *UuidLogin {
+	users *usermodels.Users,
+	tokens usermodels.UserTokenDao,
+	opLogger api.OperationLogger) (*UuidLogin, error) {
+	logger := env.Logger.Named("uuidlogin")
+
 	redirectURL := env.Config.StringWithDefault(api.CfgUserRedirectTo, "")
 	if redirectURL != "" {
 		redirectURL = strings.Replace(redirectURL, "\\$\\{appRoot}", env.DaemonUrlPath, -1)
 		redirectURL = strings.Replace(redirectURL, "${appRoot}", env.DaemonUrlPath, -1)
 	}
+
+	secret := env.Config.StringWithDefault(api.CfgUserLoginLinkSecretKey, env.Config.StringWithDefault(api.CfgUserAppSecret, ""))
+	var secretKey = []byte(secret)
+	if secret == "" {
+		secretKey = make([]byte, 32)
+		if _, err := rand.Read(secretKey); err != nil {
+			return nil, errors.Wrap(err, "生成签名密钥失败")
+		}
+		logger.Warn("'" + api.CfgUserLoginLinkSecretKey + "' 没有配置，使用随机的密钥，集群中其它节点无法识别本节点发出的登录链接")
+	}
+
 	return &UuidLogin{
-		logger:      env.Logger.Named("uuidlogin"),
+		logger:      logger,
 		renderer:    renderer,
 		users:       users,
 		sessions:    sessions,
-		keys:        map[string]string{},
+		tokens:      tokens,
+		opLogger:    opLogger,
+		signer:      signer{secret: secretKey},
 		redirectURL: redirectURL,
+		baseURL:     env.Config.StringWithDefault(api.CfgUserLoginLinkBaseURL, env.DaemonUrlPath),
+		expires:     env.Config.DurationWithDefault(api.CfgUserLoginLinkExpires, 5*time.Minute),
+		maxExpires:  env.Config.DurationWithDefault(api.CfgUserLoginLinkMaxExpires, 1*time.Hour),
+	}, nil
+}
+
+// isRelativeURL 跳转地址只能是本站的路径, 防止被用来跳转到其它网站
+func isRelativeURL(s string) bool {
+	if s == "" {
+		return true
+	}
+	if !strings.HasPrefix(s, "/") ||
+		strings.HasPrefix(s, "//") ||
+		strings.HasPrefix(s, "/\\") {
+		return false
 	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == ""
 }
 
-func (c *UuidLogin) Set(ctx context.Context, uuid, username string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.keys[uuid] = username
-	return nil
+// redirectFromQuery 读请求参数中的跳转地址, 调用者必须用 isRelativeURL 检查它
+func redirectFromQuery(queryParams url.Values) string {
+	for _, name := range []string{"redirect", "service", "returnTo"} {
+		if s := queryParams.Get(name); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Issue 给指定的用户生成一次性登录链接
+func (c *UuidLogin) Issue(ctx context.Context, currentUser api.User, req *IssueRequest) (*IssueResult, error) {
+	ok, err := currentUser.HasPermission(ctx, PermissionIssue)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, ErrPermissionDenny
+	}
+
+	if req.Username == "" {
+		return nil, errors.NewError(http.StatusBadRequest, "用户名不能为空")
+	}
+	if !isRelativeURL(req.TargetURL) {
+		return nil, ErrTargetURLInvalid
+	}
+	if req.Address != "" && net.ParseIP(req.Address) == nil {
+		return nil, ErrAddressInvalid
+	}
+	expires := c.expires
+	if req.ExpiresIn > 0 {
+		expires = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if c.maxExpires > 0 && expires > c.maxExpires {
+		return nil, ErrExpiresTooLong
+	}
+
+	user, err := c.users.GetUserByName(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDisabled() {
+		return nil, ErrUserDisabled
+	}
+
+	result, err := c.issue(ctx, user, req.TargetURL, req.Address, expires, currentUser.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "add_login_link",
+		Successful: true,
+		Content:    "给用户 '" + user.Name + "' 生成一次性登录链接",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+			Records: []api.ChangeRecord{
+				{Name: "target_url", NewValue: req.TargetURL},
+				{Name: "address", NewValue: req.Address},
+				{Name: "expires_at", NewValue: result.ExpiresAt},
+			},
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "添加操作日志失败")
+	}
+	return result, nil
+}
+
+// IssueForSelf 给已经通过其它方式(如 usbkey)验证过的用户生成登录链接, 链接绑定到用户当前的地址
+func (c *UuidLogin) IssueForSelf(ctx context.Context, username, address string) (string, error) {
+	user, err := c.users.GetUserByName(ctx, username)
+	if err != nil {
+		return "", err
+	}
+	if user.IsDisabled() {
+		return "", ErrUserDisabled
+	}
+
+	result, err := c.issue(ctx, user, "", address, c.expires, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.opLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     user.ID,
+		Username:   user.Name,
+		Type:       "add_login_link",
+		Successful: true,
+		Content:    "用户 '" + user.Name + "' 生成一次性登录链接",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+			Records: []api.ChangeRecord{
+				{Name: "address", NewValue: address},
+				{Name: "expires_at", NewValue: result.ExpiresAt},
+			},
+		},
+	}); err != nil {
+		return "", errors.Wrap(err, "添加操作日志失败")
+	}
+	return result.Token, nil
+}
+
+func (c *UuidLogin) issue(ctx context.Context, user *usermodels.User, targetURL, address string, expires time.Duration, createdBy int64) (*IssueResult, error) {
+	expiresAt := time.Now().Add(expires)
+	token, err := c.signer.Sign(usermodels.UserTokenLoginLink, user.ID, expiresAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "生成令牌失败")
+	}
+
+	if _, err := c.tokens.DeleteExpired(ctx); err != nil {
+		c.logger.Warn("删除过期的令牌失败", log.Error(err))
+	}
+
+	_, err = c.tokens.Create(ctx, &usermodels.UserToken{
+		UserID:    user.ID,
+		Purpose:   usermodels.UserTokenLoginLink,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+		TargetURL: targetURL,
+		Address:   address,
+		CreatedBy: createdBy,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "保存令牌失败")
+	}
+
+	return &IssueResult{
+		URL:       urlutil.Join(c.baseURL, "uuid") + "?uuid=" + url.QueryEscape(token),
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// redeem 检查令牌并标记为已用, 返回令牌记录和对应的用户
+func (c *UuidLogin) redeem(ctx context.Context, token, address string) (*usermodels.UserToken, *usermodels.User, error) {
+	purpose, userID, expiresAt, err := c.signer.Parse(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if purpose != usermodels.UserTokenLoginLink {
+		return nil, nil, ErrLinkInvalid
+	}
+	if time.Now().After(expiresAt) {
+		return nil, nil, ErrLinkExpired
+	}
+
+	var record usermodels.UserToken
+	err = c.tokens.GetByHash(ctx, hashToken(token))(&record)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, nil, ErrLinkInvalid
+		}
+		return nil, nil, errors.Wrap(err, "查询令牌失败")
+	}
+	if record.Purpose != usermodels.UserTokenLoginLink || record.UserID != userID {
+		return nil, nil, ErrLinkInvalid
+	}
+
+	user, err := c.users.GetUserByID(ctx, record.UserID)
+	if err != nil {
+		return &record, nil, err
+	}
+	if record.IsUsed() {
+		return &record, user, ErrLinkUsed
+	}
+	if record.IsExpired(time.Now()) {
+		return &record, user, ErrLinkExpired
+	}
+	if record.Address != "" && record.Address != address {
+		return &record, user, ErrLinkAddress
+	}
+	if user.IsDisabled() {
+		return &record, user, ErrUserDisabled
+	}
+
+	// 用条件更新保证集群中只有一个请求能用成功
+	count, err := c.tokens.Redeem(ctx, record.ID, address)
+	if err != nil {
+		return &record, user, errors.Wrap(err, "更新令牌失败")
+	}
+	if count == 0 {
+		return &record, user, ErrLinkUsed
+	}
+	return &record, user, nil
+}
+
+func (c *UuidLogin) logRedeem(ctx context.Context, user *usermodels.User, address string, err error) {
+	ol := &api.OperationLog{
+		UserID:     user.ID,
+		Username:   user.Name,
+		Type:       "login_link_redeem",
+		Successful: err == nil,
+		Content:    "用户 '" + user.Name + "' 从 " + address + " 使用一次性登录链接登录",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+		},
+	}
+	if err != nil {
+		ol.Content = ol.Content + "失败: " + err.Error()
+	}
+	if e := c.opLogger.LogRecord(ctx, ol); e != nil {
+		c.logger.Warn("添加操作日志失败", log.Error(e))
+	}
 }
 
 func (c *UuidLogin) Login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
-	uuid := queryParams.Get("uuid")
-
-	c.mu.Lock()
-	username, ok := c.keys[uuid]
-	if ok {
-		delete(c.keys, uuid)
+	token := queryParams.Get("uuid")
+	if token == "" {
+		token = queryParams.Get("token")
 	}
-	c.mu.Unlock()
 
 	renderError := func(statusCode int, err string) {
 		if c.redirectURL == "" {
-			w.WriteHeader(http.StatusOK)
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(statusCode)
 			io.WriteString(w, err)
 			return
 		}
@@ -76,24 +360,21 @@ func (c *UuidLogin) Login(ctx context.Context, w http.ResponseWriter, r *http.Re
 		http.Redirect(w, r, c.redirectURL+"?message="+url.QueryEscape(err), http.StatusTemporaryRedirect)
 	}
 
-	if !ok {
-		c.logger.Info("UUID 不可识别", log.String("uuid", uuid))
-		renderError(http.StatusUnauthorized, "没有找到这个 UUID, 可能已经用过了")
-		return
+	address := authn.RealIP(r)
+	record, user, err := c.redeem(ctx, token, address)
+	if user != nil {
+		c.logRedeem(ctx, user, address, err)
 	}
-
-	user, err := c.users.GetUserByName(ctx, username)
 	if err != nil {
-		c.logger.Info("读用户信息失败", log.Error(err))
+		c.logger.Info("登录链接不可用", log.String("address", address), log.Error(err))
 		if errors.IsNotFound(err) {
-			renderError(http.StatusUnauthorized, "用户信息没找到，请添加这个用户： "+err.Error())
+			renderError(http.StatusUnauthorized, "用户信息没找到： "+err.Error())
 			return
 		}
-		renderError(http.StatusUnauthorized, "读用户信息失败： "+err.Error())
+		renderError(errors.HTTPCode(err), err.Error())
 		return
 	}
 
-	address := authn.RealIP(r)
 	sessionID, err := c.sessions.Login(ctx, user.ID, user.Name, address)
 	if err != nil && errors.IsNotFound(err) {
 		c.logger.Info("创建在线用户信息失败", log.Error(err))
@@ -102,12 +383,12 @@ func (c *UuidLogin) Login(ctx context.Context, w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	q := r.URL.Query()
-	redirect := q.Get("redirect")
+	redirect := record.TargetURL
 	if redirect == "" {
-		redirect = q.Get("service")
-		if redirect == "" {
-			redirect = q.Get("returnTo")
+		redirect = redirectFromQuery(queryParams)
+		if !isRelativeURL(redirect) {
+			c.logger.Warn("跳转地址不是本站的相对路径，忽略它", log.String("redirect", redirect))
+			redirect = ""
 		}
 	}
 	authCtx := &services.AuthContext{
@@ -115,7 +396,7 @@ func (c *UuidLogin) Login(ctx context.Context, w http.ResponseWriter, r *http.Re
 		Logger: c.logger,
 		Request: services.LoginRequest{
 			UserID:   user.ID,
-			Username: username,
+			Username: user.Name,
 			Service:  redirect,
 		},
 		Response: services.LoginResult{
@@ -0,0 +1,225 @@
+package uuidlogin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+func TestIsRelativeURL(t *testing.T) {
+	for s, excepted := range map[string]bool{
+		"":                    true,
+		"/":                   true,
+		"/web/home?a=b":       true,
+		"//evil.com":          false,
+		"/\\evil.com":         false,
+		"http://evil.com":     false,
+		"javascript:alert(1)": false,
+		"web/home":            false,
+		"/web/../x#frag":      true,
+	} {
+		if actual := isRelativeURL(s); actual != excepted {
+			t.Error(s, ": want", excepted, "got", actual)
+		}
+	}
+}
+
+func TestRedirectFromQuery(t *testing.T) {
+	for query, excepted := range map[string]string{
+		"redirect=/web/home":                         "/web/home",
+		"service=/web/a&returnTo=/web/b":             "/web/a",
+		"returnTo=/web/b":                            "/web/b",
+		"redirect=https://evil.com/":                 "",
+		"service=//evil.com":                         "",
+		"returnTo=" + url.QueryEscape("/\\evil.com"): "",
+	} {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redirect := redirectFromQuery(values)
+		if !isRelativeURL(redirect) {
+			redirect = ""
+		}
+		if redirect != excepted {
+			t.Error(query, ": want", excepted, "got", redirect)
+		}
+	}
+}
+
+func TestSignerTampering(t *testing.T) {
+	s := &signer{secret: []byte("secret")}
+	expiresAt := time.Now().Add(time.Hour)
+
+	token, err := s.Sign(usermodels.UserTokenLoginLink, 12, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purpose, userID, actualExpiresAt, err := s.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purpose != usermodels.UserTokenLoginLink || userID != 12 || actualExpiresAt.Unix() != expiresAt.Unix() {
+		t.Error(purpose, userID, actualExpiresAt)
+	}
+
+	// 修改用户 ID 后签名不对
+	idx := strings.IndexByte(token, '.')
+	payload, err := base64.RawURLEncoding.DecodeString(token[:idx])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(payload), "|12|", "|1|", 1))) + token[idx:]
+
+	other := &signer{secret: []byte("other")}
+	for name, test := range map[string]struct {
+		s     *signer
+		token string
+	}{
+		"forged payload": {s: s, token: forged},
+		"bad signature":  {s: s, token: token[:idx+1] + "AAAA"},
+		"no signature":   {s: s, token: token[:idx]},
+		"other secret":   {s: other, token: token},
+		"garbage":        {s: s, token: "abc"},
+	} {
+		if _, _, _, err := test.s.Parse(test.token); err != ErrLinkInvalid {
+			t.Error(name, ": want ErrLinkInvalid, got", err)
+		}
+	}
+}
+
+type testTokens struct {
+	usermodels.UserTokenDao
+
+	records []usermodels.UserToken
+}
+
+func (dao *testTokens) Create(ctx context.Context, token *usermodels.UserToken) (int64, error) {
+	token.ID = int64(len(dao.records) + 1)
+	dao.records = append(dao.records, *token)
+	return token.ID, nil
+}
+
+func (dao *testTokens) GetByHash(ctx context.Context, hash string) func(*usermodels.UserToken) error {
+	return func(token *usermodels.UserToken) error {
+		for idx := range dao.records {
+			if dao.records[idx].TokenHash == hash {
+				*token = dao.records[idx]
+				return nil
+			}
+		}
+		return sql.ErrNoRows
+	}
+}
+
+func (dao *testTokens) Redeem(ctx context.Context, id int64, address string) (int64, error) {
+	for idx := range dao.records {
+		if dao.records[idx].ID == id && !dao.records[idx].IsUsed() {
+			now := time.Now()
+			dao.records[idx].UsedAt = &now
+			dao.records[idx].UsedAddress = address
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (dao *testTokens) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+type testUserDao struct {
+	usermodels.UserDao
+
+	users map[int64]usermodels.User
+}
+
+func (dao *testUserDao) GetUserByID(ctx context.Context, id int64) func(*usermodels.User) error {
+	return func(u *usermodels.User) error {
+		found, ok := dao.users[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*u = found
+		return nil
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	ctx := context.Background()
+	tokens := &testTokens{}
+	c := &UuidLogin{
+		logger: log.Empty(),
+		users: &usermodels.Users{UserDao: &testUserDao{users: map[int64]usermodels.User{
+			1: {ID: 1, Name: "tom"},
+		}}},
+		tokens:  tokens,
+		signer:  signer{secret: []byte("secret")},
+		baseURL: "/moo",
+		expires: time.Minute,
+	}
+	user := &usermodels.User{ID: 1, Name: "tom"}
+
+	// 只能用一次
+	result, err := c.issue(ctx, user, "/web/home", "", time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result.URL, "/moo/uuid?uuid=") {
+		t.Error(result.URL)
+	}
+	record, redeemed, err := c.redeem(ctx, result.Token, "192.168.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed.ID != 1 || record.TargetURL != "/web/home" {
+		t.Error(redeemed, record)
+	}
+	if _, _, err := c.redeem(ctx, result.Token, "192.168.1.2"); err != ErrLinkUsed {
+		t.Error("redeem twice: want ErrLinkUsed, got", err)
+	}
+
+	// 绑定了地址
+	result, err = c.issue(ctx, user, "", "192.168.1.2", time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.redeem(ctx, result.Token, "192.168.1.3"); err != ErrLinkAddress {
+		t.Error("other address: want ErrLinkAddress, got", err)
+	}
+	if _, _, err := c.redeem(ctx, result.Token, "192.168.1.2"); err != nil {
+		t.Error("bound address:", err)
+	}
+
+	// 过期
+	result, err = c.issue(ctx, user, "", "", -time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.redeem(ctx, result.Token, "192.168.1.2"); err != ErrLinkExpired {
+		t.Error("expired: want ErrLinkExpired, got", err)
+	}
+
+	// 其它用途的令牌和没有保存的令牌
+	token, err := c.signer.Sign(usermodels.UserTokenResetPassword, 1, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.redeem(ctx, token, "192.168.1.2"); err != ErrLinkInvalid {
+		t.Error("other purpose: want ErrLinkInvalid, got", err)
+	}
+	token, err = c.signer.Sign(usermodels.UserTokenLoginLink, 1, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.redeem(ctx, token, "192.168.1.2"); err != ErrLinkInvalid {
+		t.Error("not saved: want ErrLinkInvalid, got", err)
+	}
+}
@@ -0,0 +1,107 @@
+package authz
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// PermissionMeta 权限的说明, 各个模块在启动时把它们用到的权限注册到 Catalogue 中, 管理界面按它来显示和分配权限
+type PermissionMeta struct {
+	ID          Resource `json:"id"`
+	Title       string   `json:"title"`
+	Group       string   `json:"group,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Catalogue 权限目录
+type Catalogue struct {
+	mu    sync.RWMutex
+	items map[Resource]PermissionMeta
+}
+
+// NewCatalogue 创建一个空的权限目录
+func NewCatalogue() *Catalogue {
+	return &Catalogue{items: map[Resource]PermissionMeta{}}
+}
+
+// Add 添加权限, ID 已存在时返回错误
+func (c *Catalogue) Add(permissions ...PermissionMeta) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range permissions {
+		if p.ID == "" || p.Title == "" {
+			return ErrFieldIncomplete
+		}
+		if old, ok := c.items[p.ID]; ok && old != p {
+			return errors.New("permission '" + p.ID + "' is already exists")
+		}
+	}
+	for _, p := range permissions {
+		c.items[p.ID] = p
+	}
+	return nil
+}
+
+// Get 按 ID 查找权限
+func (c *Catalogue) Get(id Resource) (PermissionMeta, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.items[id]
+	return p, ok
+}
+
+// Has 权限是否在目录中
+func (c *Catalogue) Has(id Resource) bool {
+	_, ok := c.Get(id)
+	return ok
+}
+
+// All 返回所有的权限, 按分组和 ID 排序, group 不为空时只返回该分组的权限
+func (c *Catalogue) All(group string) []PermissionMeta {
+	c.mu.RLock()
+	list := make([]PermissionMeta, 0, len(c.items))
+	for _, p := range c.items {
+		if group != "" && p.Group != group {
+			continue
+		}
+		list = append(list, p)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Group != list[j].Group {
+			return list[i].Group < list[j].Group
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Groups 返回所有的分组
+func (c *Catalogue) Groups() []string {
+	c.mu.RLock()
+	seen := map[string]struct{}{}
+	for _, p := range c.items {
+		seen[p.Group] = struct{}{}
+	}
+	c.mu.RUnlock()
+
+	groups := make([]string, 0, len(seen))
+	for group := range seen {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// DefaultCatalogue 默认的权限目录, 各个模块在 init() 中用 Register 注册权限
+var DefaultCatalogue = NewCatalogue()
+
+// Register 注册权限到 DefaultCatalogue 中, 出错时 panic, 它只应该在 init() 中调用
+func Register(permissions ...PermissionMeta) {
+	if err := DefaultCatalogue.Add(permissions...); err != nil {
+		panic(err)
+	}
+}
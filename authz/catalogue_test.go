@@ -0,0 +1,35 @@
+package authz
+
+import "testing"
+
+func TestCatalogue(t *testing.T) {
+	c := NewCatalogue()
+	if err := c.Add(PermissionMeta{ID: "b.view", Title: "查看 b", Group: "b"},
+		PermissionMeta{ID: "a.view", Title: "查看 a", Group: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	// 重复注册相同的权限不报错
+	if err := c.Add(PermissionMeta{ID: "a.view", Title: "查看 a", Group: "a"}); err != nil {
+		t.Error(err)
+	}
+	if err := c.Add(PermissionMeta{ID: "a.view", Title: "查看", Group: "a"}); err == nil {
+		t.Error("want error")
+	}
+	if err := c.Add(PermissionMeta{ID: "c.view"}); err != ErrFieldIncomplete {
+		t.Error("want ErrFieldIncomplete, got", err)
+	}
+
+	all := c.All("")
+	if len(all) != 2 || all[0].ID != "a.view" || all[1].ID != "b.view" {
+		t.Error(all)
+	}
+	if list := c.All("b"); len(list) != 1 || list[0].ID != "b.view" {
+		t.Error(list)
+	}
+	if groups := c.Groups(); len(groups) != 2 || groups[0] != "a" || groups[1] != "b" {
+		t.Error(groups)
+	}
+	if !c.Has("a.view") || c.Has("c.view") {
+		t.Error("Has is wrong")
+	}
+}
@@ -0,0 +1,559 @@
+package dbauthz
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gobatis "github.com/runner-mei/GoBatis"
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+const (
+	// PermissionView 查看权限目录和授权的权限
+	PermissionView = "um.permissions.view"
+	// PermissionManage 管理授权的权限
+	PermissionManage = "um.permissions.manage"
+)
+
+func init() {
+	authz.Register(
+		authz.PermissionMeta{ID: PermissionView, Title: "查看授权", Group: "权限管理"},
+		authz.PermissionMeta{ID: PermissionManage, Title: "管理授权", Group: "权限管理"},
+	)
+}
+
+var (
+	ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有管理授权的权限")
+	ErrGrantNotFound   = errors.ErrNotFoundWithText("授权不存在!")
+	ErrGrantExists     = errors.NewError(http.StatusConflict, "该资源的授权已存在")
+	ErrResourceEmpty   = errors.NewError(http.StatusBadRequest, "资源不能为空")
+	ErrResourceTooLong = errors.NewError(http.StatusBadRequest, "资源的长度不能超过 200 个字符")
+	ErrRoleConflict    = errors.NewError(http.StatusBadRequest, "同一个角色不能既被允许又被禁止")
+	ErrSubjectNotFound = errors.ErrNotFoundWithText("用户不存在!")
+)
+
+// Grant 一个资源的授权
+type Grant struct {
+	ID              int64            `json:"id"`
+	Resource        string           `json:"resource"`
+	Title           string           `json:"title,omitempty"`
+	Group           string           `json:"group,omitempty"`
+	AllowAnyone     bool             `json:"allow_anyone"`
+	AuthorizedRoles []int64          `json:"authorized_roles"`
+	ForbiddenRoles  []int64          `json:"forbidden_roles"`
+	Conditions      authz.Conditions `json:"conditions,omitempty"`
+	Description     string           `json:"description,omitempty"`
+	CreatedAt       time.Time        `json:"created_at,omitempty"`
+	UpdatedAt       time.Time        `json:"updated_at,omitempty"`
+}
+
+// GrantRequest 创建或修改授权的参数, 修改时忽略 Resource
+type GrantRequest struct {
+	Resource        string           `json:"resource"`
+	AllowAnyone     bool             `json:"allow_anyone"`
+	AuthorizedRoles []int64          `json:"authorized_roles"`
+	ForbiddenRoles  []int64          `json:"forbidden_roles"`
+	Conditions      authz.Conditions `json:"conditions,omitempty"`
+	Description     string           `json:"description,omitempty"`
+}
+
+// Authorizer 按数据库中的授权检查权限, 授权缓存在内存中, 由 Refresh 重新加载
+//
+// 检查的规则如下:
+//  1. 拥有超级角色(默认为 super 和 administrator)的用户拥有全部权限
+//  2. 资源有授权时按 authz.Permission 的规则检查, 通过后还必须满足授权的所有条件
+//  3. 资源没有授权但在权限目录中时, 返回 PermissionUngranted
+//  4. 其它情况返回 PermissionNeglected
+//
+// IsGrantedFor 检查时用户在用户组内的角色也参与上面的规则, 但只对属于该用户组或它的下级用户组的对象有效
+type Authorizer struct {
+	logger         log.Logger
+	catalogue      *authz.Catalogue
+	factory        *gobatis.SessionFactory
+	grants         usermodels.PermissionGrantDao
+	usergroups     usermodels.UsergroupQueryer
+	users          *usermodels.Users
+	opLogger       api.OperationLogger
+	superRoleNames []string
+
+	mu          sync.RWMutex
+	permissions map[authz.Resource]*authz.Permission
+	conditions  map[authz.Resource]authz.Conditions
+	superRoles  []int64
+
+	// descendants 缓存用户组和它的所有下级用户组, 由 Refresh 清空
+	descendants     map[int64]map[int64]struct{}
+	loadDescendants func(ctx context.Context, groupID int64) ([]int64, error)
+	now             func() time.Time
+}
+
+func NewAuthorizer(env *moo.Environment, factory *gobatis.SessionFactory, users *usermodels.Users, opLogger api.OperationLogger, catalogue *authz.Catalogue) *Authorizer {
+	if catalogue == nil {
+		catalogue = authz.DefaultCatalogue
+	}
+
+	var superRoleNames []string
+	for _, name := range env.Config.StringsWithDefault(api.CfgUserAuthzSuperRoles, []string{api.RoleSuper, api.RoleAdministrator}) {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			superRoleNames = append(superRoleNames, name)
+		}
+	}
+
+	a := &Authorizer{
+		logger:         env.Logger.Named("authz"),
+		catalogue:      catalogue,
+		factory:        factory,
+		grants:         usermodels.NewPermissionGrantDao(factory.SessionReference()),
+		usergroups:     usermodels.NewUsergroupQueryer(factory.SessionReference()),
+		users:          users,
+		opLogger:       opLogger,
+		superRoleNames: superRoleNames,
+		permissions:    map[authz.Resource]*authz.Permission{},
+		conditions:     map[authz.Resource]authz.Conditions{},
+		descendants:    map[int64]map[int64]struct{}{},
+		now:            time.Now,
+	}
+	a.loadDescendants = a.readDescendants
+	return a
+}
+
+// Catalogue 权限目录
+func (a *Authorizer) Catalogue() *authz.Catalogue {
+	return a.catalogue
+}
+
+// Refresh 从数据库中重新加载授权和超级角色
+func (a *Authorizer) Refresh() error {
+	ctx := context.Background()
+
+	grants, err := a.loadGrants(ctx)
+	if err != nil {
+		return err
+	}
+	permissions := make(map[authz.Resource]*authz.Permission, len(grants))
+	conditions := map[authz.Resource]authz.Conditions{}
+	for idx := range grants {
+		permissions[grants[idx].Resource] = &authz.Permission{
+			AuthorizedRoles: grants[idx].AuthorizedRoles,
+			ForbiddenRoles:  grants[idx].ForbiddenRoles,
+			AllowAnyone:     grants[idx].AllowAnyone,
+		}
+		if len(grants[idx].Conditions) > 0 {
+			conditions[grants[idx].Resource] = grants[idx].Conditions
+		}
+	}
+
+	var superRoles []int64
+	if len(a.superRoleNames) > 0 {
+		next, closer := a.users.UserDao.GetRolesByNames(ctx, a.superRoleNames)
+		defer util.CloseWith(closer)
+		for {
+			var role usermodels.Role
+			ok, err := next(&role)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					break
+				}
+				return errors.Wrap(err, "读超级角色失败")
+			}
+			if !ok {
+				break
+			}
+			superRoles = append(superRoles, role.ID)
+		}
+	}
+
+	a.mu.Lock()
+	a.permissions = permissions
+	a.conditions = conditions
+	a.superRoles = superRoles
+	a.descendants = map[int64]map[int64]struct{}{}
+	a.mu.Unlock()
+	return nil
+}
+
+// IsGranted 检查角色是否可以访问资源, 它没有对象, 所以授权中有 owner 或 attribute 条件时不会通过
+func (a *Authorizer) IsGranted(ctx context.Context, res authz.Resource, roles []int64) (authz.PermissionState, error) {
+	return a.evaluate(ctx, res, &authz.Subject{Roles: roles}, nil, nil)
+}
+
+func (a *Authorizer) loadGrants(ctx context.Context) ([]Grant, error) {
+	list, err := a.grants.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "读授权失败")
+	}
+	roles, err := a.grants.ListRoles(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "读授权的角色失败")
+	}
+
+	byID := make(map[int64]*Grant, len(list))
+	grants := make([]Grant, len(list))
+	for idx := range list {
+		grants[idx] = a.toGrant(&list[idx], nil)
+		byID[list[idx].ID] = &grants[idx]
+	}
+	for _, role := range roles {
+		grant := byID[role.GrantID]
+		if grant == nil {
+			continue
+		}
+		if role.Forbidden {
+			grant.ForbiddenRoles = append(grant.ForbiddenRoles, role.RoleID)
+		} else {
+			grant.AuthorizedRoles = append(grant.AuthorizedRoles, role.RoleID)
+		}
+	}
+	return grants, nil
+}
+
+func (a *Authorizer) toGrant(record *usermodels.PermissionGrant, roles []usermodels.PermissionGrantRole) Grant {
+	grant := Grant{
+		ID:              record.ID,
+		Resource:        record.Resource,
+		AllowAnyone:     record.AllowAnyone,
+		Conditions:      a.parseConditions(record),
+		AuthorizedRoles: []int64{},
+		ForbiddenRoles:  []int64{},
+		Description:     record.Description,
+		CreatedAt:       record.CreatedAt,
+		UpdatedAt:       record.UpdatedAt,
+	}
+	if meta, ok := a.catalogue.Get(record.Resource); ok {
+		grant.Title = meta.Title
+		grant.Group = meta.Group
+	}
+	for _, role := range roles {
+		if role.Forbidden {
+			grant.ForbiddenRoles = append(grant.ForbiddenRoles, role.RoleID)
+		} else {
+			grant.AuthorizedRoles = append(grant.AuthorizedRoles, role.RoleID)
+		}
+	}
+	return grant
+}
+
+func checkPermission(ctx context.Context, currentUser api.User, permissions ...string) error {
+	ok, err := currentUser.HasPermissionAny(ctx, permissions)
+	if err != nil {
+		return errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return ErrPermissionDenny
+	}
+	return nil
+}
+
+// List 返回所有的授权
+func (a *Authorizer) List(ctx context.Context, currentUser api.User) ([]Grant, error) {
+	if err := checkPermission(ctx, currentUser, PermissionView, PermissionManage); err != nil {
+		return nil, err
+	}
+	grants, err := a.loadGrants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if grants == nil {
+		grants = []Grant{}
+	}
+	return grants, nil
+}
+
+// Get 按 id 返回授权
+func (a *Authorizer) Get(ctx context.Context, currentUser api.User, id int64) (*Grant, error) {
+	if err := checkPermission(ctx, currentUser, PermissionView, PermissionManage); err != nil {
+		return nil, err
+	}
+	return a.get(ctx, a.grants, id)
+}
+
+func (a *Authorizer) get(ctx context.Context, dao usermodels.PermissionGrantDao, id int64) (*Grant, error) {
+	var record usermodels.PermissionGrant
+	if err := dao.GetByID(ctx, id)(&record); err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, ErrGrantNotFound
+		}
+		return nil, errors.Wrap(err, "查询授权失败")
+	}
+	roles, err := dao.ListRolesByGrantID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询授权的角色失败")
+	}
+	grant := a.toGrant(&record, roles)
+	return &grant, nil
+}
+
+// parseConditions 解析授权的条件, 条件不正确时用一个不能满足的条件代替, 以免放宽了授权
+func (a *Authorizer) parseConditions(record *usermodels.PermissionGrant) authz.Conditions {
+	conditions, err := authz.ParseConditions(record.Conditions)
+	if err != nil {
+		a.logger.Warn("授权的条件不正确", log.String("resource", record.Resource), log.Error(err))
+		return authz.Conditions{{Type: "invalid"}}
+	}
+	return conditions
+}
+
+func (a *Authorizer) validate(ctx context.Context, req *GrantRequest) error {
+	if err := req.Conditions.Validate(); err != nil {
+		return errors.WithHTTPCode(err, http.StatusBadRequest)
+	}
+
+	seen := map[int64]bool{}
+	for _, id := range req.AuthorizedRoles {
+		seen[id] = false
+	}
+	for _, id := range req.ForbiddenRoles {
+		if _, ok := seen[id]; ok {
+			return ErrRoleConflict
+		}
+		seen[id] = true
+	}
+
+	ids := make([]int64, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		var role usermodels.Role
+		if err := a.users.UserDao.GetRoleByID(ctx, id)(&role); err != nil {
+			if err == sql.ErrNoRows || errors.IsNotFound(err) {
+				return errors.NewError(http.StatusBadRequest, "角色 '"+strconv.FormatInt(id, 10)+"' 不存在")
+			}
+			return errors.Wrap(err, "查询角色失败")
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) saveRoles(ctx context.Context, dao usermodels.PermissionGrantDao, grantID int64, req *GrantRequest) error {
+	if _, err := dao.DeleteRolesByGrantID(ctx, grantID); err != nil {
+		return errors.Wrap(err, "删除授权的角色失败")
+	}
+	for _, roleID := range req.AuthorizedRoles {
+		if err := dao.AddRole(ctx, grantID, roleID, false); err != nil {
+			return errors.Wrap(err, "添加授权的角色失败")
+		}
+	}
+	for _, roleID := range req.ForbiddenRoles {
+		if err := dao.AddRole(ctx, grantID, roleID, true); err != nil {
+			return errors.Wrap(err, "添加授权的角色失败")
+		}
+	}
+	return nil
+}
+
+// Create 创建资源的授权, 一个资源只能有一个授权
+func (a *Authorizer) Create(ctx context.Context, currentUser api.User, req *GrantRequest) (*Grant, error) {
+	if err := checkPermission(ctx, currentUser, PermissionManage); err != nil {
+		return nil, err
+	}
+
+	req.Resource = strings.TrimSpace(req.Resource)
+	if req.Resource == "" {
+		return nil, ErrResourceEmpty
+	}
+	if len(req.Resource) > 200 {
+		return nil, ErrResourceTooLong
+	}
+	if err := a.validate(ctx, req); err != nil {
+		return nil, err
+	}
+
+	var old usermodels.PermissionGrant
+	err := a.grants.GetByResource(ctx, req.Resource)(&old)
+	if err == nil {
+		return nil, ErrGrantExists
+	}
+	if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "查询授权失败")
+	}
+	if !a.catalogue.Has(req.Resource) {
+		a.logger.Warn("资源不在权限目录中", log.String("resource", req.Resource))
+	}
+
+	tx, err := a.factory.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "启动事务失败")
+	}
+	defer util.RollbackWith(tx)
+	dao := usermodels.NewPermissionGrantDao(tx.SessionReference())
+
+	id, err := dao.Create(ctx, &usermodels.PermissionGrant{
+		Resource:    req.Resource,
+		AllowAnyone: req.AllowAnyone,
+		Conditions:  req.Conditions.String(),
+		Description: req.Description,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "创建授权失败")
+	}
+	if err := a.saveRoles(ctx, dao, id, req); err != nil {
+		return nil, err
+	}
+	grant, err := a.get(ctx, dao, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.opLogger.Tx(tx).LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "add_permission_grant",
+		Successful: true,
+		Content:    "创建资源 '" + req.Resource + "' 的授权",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "permission_grant",
+			ObjectID:   id,
+			Records: []api.ChangeRecord{
+				{Name: "allow_anyone", NewValue: grant.AllowAnyone},
+				{Name: "authorized_roles", NewValue: grant.AuthorizedRoles},
+				{Name: "forbidden_roles", NewValue: grant.ForbiddenRoles},
+				{Name: "conditions", NewValue: grant.Conditions},
+			},
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "添加操作日志失败")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "提交事务失败")
+	}
+	a.refreshAfterChange()
+	return grant, nil
+}
+
+// Update 修改授权
+func (a *Authorizer) Update(ctx context.Context, currentUser api.User, id int64, req *GrantRequest) (*Grant, error) {
+	if err := checkPermission(ctx, currentUser, PermissionManage); err != nil {
+		return nil, err
+	}
+	if err := a.validate(ctx, req); err != nil {
+		return nil, err
+	}
+
+	old, err := a.get(ctx, a.grants, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := a.factory.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "启动事务失败")
+	}
+	defer util.RollbackWith(tx)
+	dao := usermodels.NewPermissionGrantDao(tx.SessionReference())
+
+	if _, err := dao.Update(ctx, id, &usermodels.PermissionGrant{
+		AllowAnyone: req.AllowAnyone,
+		Conditions:  req.Conditions.String(),
+		Description: req.Description,
+	}); err != nil {
+		return nil, errors.Wrap(err, "修改授权失败")
+	}
+	if err := a.saveRoles(ctx, dao, id, req); err != nil {
+		return nil, err
+	}
+	grant, err := a.get(ctx, dao, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.opLogger.Tx(tx).LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "update_permission_grant",
+		Successful: true,
+		Content:    "修改资源 '" + old.Resource + "' 的授权",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "permission_grant",
+			ObjectID:   id,
+			Records: []api.ChangeRecord{
+				{Name: "allow_anyone", OldValue: old.AllowAnyone, NewValue: grant.AllowAnyone},
+				{Name: "authorized_roles", OldValue: old.AuthorizedRoles, NewValue: grant.AuthorizedRoles},
+				{Name: "forbidden_roles", OldValue: old.ForbiddenRoles, NewValue: grant.ForbiddenRoles},
+				{Name: "conditions", OldValue: old.Conditions, NewValue: grant.Conditions},
+			},
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "添加操作日志失败")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "提交事务失败")
+	}
+	a.refreshAfterChange()
+	return grant, nil
+}
+
+// Delete 删除授权, 删除后该资源按没有授权处理
+func (a *Authorizer) Delete(ctx context.Context, currentUser api.User, id int64) error {
+	if err := checkPermission(ctx, currentUser, PermissionManage); err != nil {
+		return err
+	}
+
+	old, err := a.get(ctx, a.grants, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.factory.Begin()
+	if err != nil {
+		return errors.Wrap(err, "启动事务失败")
+	}
+	defer util.RollbackWith(tx)
+	dao := usermodels.NewPermissionGrantDao(tx.SessionReference())
+
+	if _, err := dao.DeleteRolesByGrantID(ctx, id); err != nil {
+		return errors.Wrap(err, "删除授权的角色失败")
+	}
+	if _, err := dao.DeleteByID(ctx, id); err != nil {
+		return errors.Wrap(err, "删除授权失败")
+	}
+
+	if err := a.opLogger.Tx(tx).LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "delete_permission_grant",
+		Successful: true,
+		Content:    "删除资源 '" + old.Resource + "' 的授权",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "permission_grant",
+			ObjectID:   id,
+			Records: []api.ChangeRecord{
+				{Name: "allow_anyone", OldValue: old.AllowAnyone},
+				{Name: "authorized_roles", OldValue: old.AuthorizedRoles},
+				{Name: "forbidden_roles", OldValue: old.ForbiddenRoles},
+				{Name: "conditions", OldValue: old.Conditions},
+			},
+		},
+	}); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "提交事务失败")
+	}
+	a.refreshAfterChange()
+	return nil
+}
+
+// refreshAfterChange 授权修改后马上重新加载, 不用等到下一次定时刷新
+func (a *Authorizer) refreshAfterChange() {
+	if err := a.Refresh(); err != nil {
+		a.logger.Warn("重新加载授权失败", log.Error(err))
+	}
+}
@@ -0,0 +1,125 @@
+package dbauthz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/moo/authz"
+)
+
+func TestIsGranted(t *testing.T) {
+	catalogue := authz.NewCatalogue()
+	catalogue.Add(authz.PermissionMeta{ID: "a.view", Title: "a"},
+		authz.PermissionMeta{ID: "b.view", Title: "b"})
+
+	a := &Authorizer{
+		catalogue: catalogue,
+		permissions: map[authz.Resource]*authz.Permission{
+			"a.view": {AuthorizedRoles: []int64{2}, ForbiddenRoles: []int64{3}},
+			"c.view": {AllowAnyone: true},
+		},
+		superRoles: []int64{1},
+	}
+
+	tests := []struct {
+		res   string
+		roles []int64
+		want  authz.PermissionState
+	}{
+		{res: "a.view", roles: []int64{1}, want: authz.PermissionGranted},
+		{res: "x.view", roles: []int64{1}, want: authz.PermissionGranted},
+		{res: "a.view", roles: []int64{2}, want: authz.PermissionGranted},
+		{res: "a.view", roles: []int64{3, 2}, want: authz.PermissionUngranted},
+		{res: "a.view", roles: []int64{4}, want: authz.PermissionUngranted},
+		{res: "b.view", roles: []int64{2}, want: authz.PermissionUngranted},
+		{res: "c.view", roles: nil, want: authz.PermissionGranted},
+		{res: "x.view", roles: []int64{2}, want: authz.PermissionNeglected},
+	}
+	for idx, test := range tests {
+		got, err := a.IsGranted(context.Background(), test.res, test.roles)
+		if err != nil {
+			t.Error(idx, err)
+			continue
+		}
+		if got != test.want {
+			t.Error(idx, test.res, test.roles, "want", test.want, "got", got)
+		}
+	}
+}
+
+func TestIsGrantedFor(t *testing.T) {
+	catalogue := authz.NewCatalogue()
+	catalogue.Add(authz.PermissionMeta{ID: "doc.edit", Title: "doc"})
+
+	// 用户组 10 的下级是 11, 11 的下级是 12, 20 是另一个用户组
+	tree := map[int64][]int64{
+		10: {10, 11, 12},
+		11: {11, 12},
+		20: {20},
+	}
+	a := &Authorizer{
+		catalogue: catalogue,
+		permissions: map[authz.Resource]*authz.Permission{
+			"doc.edit":  {AuthorizedRoles: []int64{2}},
+			"doc.owner": {AllowAnyone: true},
+			"doc.night": {AuthorizedRoles: []int64{2}},
+		},
+		conditions: map[authz.Resource]authz.Conditions{
+			"doc.owner": {{Type: authz.ConditionOwner}},
+			"doc.night": {{Type: authz.ConditionTime, Start: "22:00", End: "06:00"}},
+		},
+		superRoles:  []int64{1},
+		descendants: map[int64]map[int64]struct{}{},
+		loadDescendants: func(ctx context.Context, groupID int64) ([]int64, error) {
+			return tree[groupID], nil
+		},
+		now: func() time.Time {
+			return time.Date(2020, 1, 1, 23, 0, 0, 0, time.Local)
+		},
+	}
+
+	subject := &authz.Subject{
+		UserID:     100,
+		Roles:      []int64{3},
+		GroupRoles: map[int64][]int64{11: {2}, 20: {1}},
+	}
+
+	tests := []struct {
+		res    string
+		object *authz.Object
+		want   authz.PermissionState
+	}{
+		{res: "doc.edit", object: nil, want: authz.PermissionUngranted},
+		{res: "doc.edit", object: &authz.Object{GroupID: 10}, want: authz.PermissionUngranted},
+		{res: "doc.edit", object: &authz.Object{GroupID: 11}, want: authz.PermissionGranted},
+		{res: "doc.edit", object: &authz.Object{GroupID: 12}, want: authz.PermissionGranted},
+		{res: "x.view", object: &authz.Object{GroupID: 20}, want: authz.PermissionGranted},
+		{res: "x.view", object: &authz.Object{GroupID: 12}, want: authz.PermissionNeglected},
+		{res: "doc.owner", object: &authz.Object{OwnerID: 100}, want: authz.PermissionGranted},
+		{res: "doc.owner", object: &authz.Object{OwnerID: 101}, want: authz.PermissionUngranted},
+		{res: "doc.owner", object: nil, want: authz.PermissionUngranted},
+		{res: "doc.night", object: &authz.Object{GroupID: 12}, want: authz.PermissionGranted},
+	}
+	for idx, test := range tests {
+		got, err := a.IsGrantedFor(context.Background(), test.res, subject, test.object)
+		if err != nil {
+			t.Error(idx, err)
+			continue
+		}
+		if got != test.want {
+			t.Error(idx, test.res, test.object, "want", test.want, "got", got)
+		}
+	}
+
+	a.now = func() time.Time {
+		return time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	}
+	explain, err := a.Explain(context.Background(), "doc.night", subject, &authz.Object{GroupID: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain.Granted || len(explain.Steps) == 0 {
+		t.Error("want ungranted, got", explain)
+	}
+}
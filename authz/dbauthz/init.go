@@ -0,0 +1,169 @@
+package dbauthz
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/db"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+func isEnabled(env *moo.Environment) bool {
+	return env.Config.BoolWithDefault(api.CfgUserAuthzEnabled, false)
+}
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, model db.InModelFactory, users *usermodels.Users, opLogger api.OperationLogger) (*Authorizer, authz.Authorizer, authz.ScopedAuthorizer) {
+			authorizer := NewAuthorizer(env, model.Factory, users, opLogger, authz.DefaultCatalogue)
+			return authorizer, authorizer, authorizer
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Invoke(func(authorizer *Authorizer, httpSrv *moo.HTTPServer, logger log.Logger) {
+			mux := httpSrv.Engine().Group("api/permissions", httpSrv.AuthMiddlewares())
+			initRoutes(mux, authorizer)
+			logger.Info("db authorizer started")
+		})
+	})
+}
+
+func paramID(ctx *loong.Context) (int64, error) {
+	s := ctx.Param("id")
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, loong.ErrBadArgument("id", s, err)
+	}
+	return id, nil
+}
+
+func initRoutes(mux loong.Party, authorizer *Authorizer) {
+	mux.GET("/catalogue", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		if err := checkPermission(ctx.StdContext, currentUser, PermissionView, PermissionManage); err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(authorizer.Catalogue().All(ctx.QueryParam("group")))
+	})
+
+	mux.GET("/catalogue/groups", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		if err := checkPermission(ctx.StdContext, currentUser, PermissionView, PermissionManage); err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(authorizer.Catalogue().Groups())
+	})
+
+	mux.GET("/grants", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		list, err := authorizer.List(ctx.StdContext, currentUser)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(list)
+	})
+
+	mux.GET("/grants/:id", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		id, err := paramID(ctx)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusBadRequest)
+		}
+		grant, err := authorizer.Get(ctx.StdContext, currentUser, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(grant)
+	})
+
+	mux.POST("/grants", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		var req GrantRequest
+		if err := ctx.Bind(&req); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("body", "", err), http.StatusBadRequest)
+		}
+		grant, err := authorizer.Create(ctx.StdContext, currentUser, &req)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult(grant)
+	})
+
+	mux.PUT("/grants/:id", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		id, err := paramID(ctx)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusBadRequest)
+		}
+		var req GrantRequest
+		if err := ctx.Bind(&req); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("body", "", err), http.StatusBadRequest)
+		}
+		grant, err := authorizer.Update(ctx.StdContext, currentUser, id, &req)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult(grant)
+	})
+
+	mux.DELETE("/grants/:id", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		id, err := paramID(ctx)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusBadRequest)
+		}
+		if err := authorizer.Delete(ctx.StdContext, currentUser, id); err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+
+	mux.POST("/explain", func(ctx *loong.Context) error {
+		currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err, http.StatusUnauthorized)
+		}
+		var req ExplainRequest
+		if err := ctx.Bind(&req); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("body", "", err), http.StatusBadRequest)
+		}
+		explain, err := authorizer.ExplainFor(ctx.StdContext, currentUser, &req)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(explain)
+	})
+}
@@ -0,0 +1,259 @@
+package dbauthz
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+var _ authz.ScopedAuthorizer = &Authorizer{}
+
+// ExplainRequest 调试授权的参数, UserID 和 Username 都为空时使用当前用户
+type ExplainRequest struct {
+	Resource string        `json:"resource"`
+	UserID   int64         `json:"user_id,omitempty"`
+	Username string        `json:"username,omitempty"`
+	Object   *authz.Object `json:"object,omitempty"`
+}
+
+// IsGrantedFor 检查访问者是否可以访问对象上的资源
+func (a *Authorizer) IsGrantedFor(ctx context.Context, res authz.Resource, subject *authz.Subject, object *authz.Object) (authz.PermissionState, error) {
+	return a.evaluate(ctx, res, subject, object, nil)
+}
+
+// Explain 和 IsGrantedFor 相同, 但返回求值的过程
+func (a *Authorizer) Explain(ctx context.Context, res authz.Resource, subject *authz.Subject, object *authz.Object) (*authz.Explanation, error) {
+	explain := &authz.Explanation{Resource: res, EffectiveRoles: []int64{}}
+	state, err := a.evaluate(ctx, res, subject, object, explain)
+	if err != nil {
+		return nil, err
+	}
+	explain.SetState(state)
+	return explain, nil
+}
+
+// IsUserGranted 检查用户是否可以访问对象上的资源, 用户在用户组内的角色从数据库中读取
+func (a *Authorizer) IsUserGranted(ctx context.Context, u api.User, res authz.Resource, object *authz.Object) (authz.PermissionState, error) {
+	subject, err := a.Subject(ctx, u.ID())
+	if err != nil {
+		return authz.PermissionUnknown, err
+	}
+	return a.evaluate(ctx, res, subject, object, nil)
+}
+
+func (a *Authorizer) evaluate(ctx context.Context, res authz.Resource, subject *authz.Subject, object *authz.Object, explain *authz.Explanation) (authz.PermissionState, error) {
+	if subject == nil {
+		subject = &authz.Subject{}
+	}
+
+	a.mu.RLock()
+	permission := a.permissions[res]
+	conditions := a.conditions[res]
+	superRoles := a.superRoles
+	a.mu.RUnlock()
+
+	explain.Step("全局角色: %v", subject.Roles)
+	var inScope func(groupID int64) (bool, error)
+	if object != nil && object.GroupID != 0 {
+		inScope = func(groupID int64) (bool, error) {
+			ok, err := a.inScope(ctx, groupID, object.GroupID)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				explain.Step("用户组 %d 中的角色 %v 适用于对象所属的用户组 %d", groupID, subject.GroupRoles[groupID], object.GroupID)
+			} else {
+				explain.Step("用户组 %d 中的角色 %v 不适用于对象所属的用户组 %d", groupID, subject.GroupRoles[groupID], object.GroupID)
+			}
+			return ok, nil
+		}
+	} else if len(subject.GroupRoles) > 0 {
+		explain.Step("对象不属于任何用户组, 忽略用户组中的角色")
+	}
+	roles, err := subject.EffectiveRoles(inScope)
+	if err != nil {
+		return authz.PermissionUnknown, err
+	}
+	if explain != nil {
+		explain.EffectiveRoles = append(explain.EffectiveRoles, roles...)
+	}
+
+	for _, role := range roles {
+		for _, super := range superRoles {
+			if role == super {
+				explain.Step("角色 %d 是超级角色", role)
+				return authz.PermissionGranted, nil
+			}
+		}
+	}
+
+	if permission == nil {
+		if a.catalogue.Has(res) {
+			explain.Step("资源在权限目录中, 但没有授权")
+			return authz.PermissionUngranted, nil
+		}
+		explain.Step("资源不在权限目录中, 也没有授权")
+		return authz.PermissionNeglected, nil
+	}
+
+	state, err := permission.IsGranted(roles)
+	if err != nil {
+		return authz.PermissionUnknown, err
+	}
+	explain.Step("按授权的角色检查: %s(允许 %v, 禁止 %v, 任何人 %v)", state,
+		permission.AuthorizedRoles, permission.ForbiddenRoles, permission.AllowAnyone)
+	if !state.IsGranted() || len(conditions) == 0 {
+		return state, nil
+	}
+
+	if !conditions.Match(subject, object, a.now(), explain) {
+		return authz.PermissionUngranted, nil
+	}
+	return authz.PermissionGranted, nil
+}
+
+// inScope 对象所属的用户组是不是 groupID 或它的下级用户组
+func (a *Authorizer) inScope(ctx context.Context, groupID, objectGroupID int64) (bool, error) {
+	if groupID == objectGroupID {
+		return true, nil
+	}
+
+	a.mu.RLock()
+	descendants, ok := a.descendants[groupID]
+	a.mu.RUnlock()
+
+	if !ok {
+		ids, err := a.loadDescendants(ctx, groupID)
+		if err != nil {
+			return false, err
+		}
+		descendants = make(map[int64]struct{}, len(ids))
+		for _, id := range ids {
+			descendants[id] = struct{}{}
+		}
+
+		a.mu.Lock()
+		a.descendants[groupID] = descendants
+		a.mu.Unlock()
+	}
+	_, ok = descendants[objectGroupID]
+	return ok, nil
+}
+
+// readDescendants 用递归查询读用户组和它的所有下级用户组
+func (a *Authorizer) readDescendants(ctx context.Context, groupID int64) ([]int64, error) {
+	next, closer := a.usergroups.GetUsergroupsByRecursive(ctx, groupID)
+	defer util.CloseWith(closer)
+
+	groups, err := usermodels.GetUsergroups(ctx, next)
+	if err != nil {
+		return nil, errors.Wrap(err, "读下级用户组失败")
+	}
+	ids := make([]int64, 0, len(groups))
+	for idx := range groups {
+		ids = append(ids, groups[idx].ID)
+	}
+	return ids, nil
+}
+
+// Subject 从数据库中读用户的全局角色, 用户组内的角色和用户属性, 角色都包含了从上级角色继承的角色
+func (a *Authorizer) Subject(ctx context.Context, userID int64) (*authz.Subject, error) {
+	u, err := a.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, ErrSubjectNotFound
+		}
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+
+	subject := &authz.Subject{
+		UserID:     u.ID,
+		Username:   u.Name,
+		Roles:      []int64{},
+		Attributes: map[string]interface{}{},
+	}
+	u.ForEach(func(key string, value interface{}) {
+		subject.Attributes[key] = value
+	})
+
+	roles, err := a.users.UserDao.GetEffectiveRolesByUserID(ctx, u.ID, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户的角色失败")
+	}
+	for idx := range roles {
+		subject.Roles = append(subject.Roles, roles[idx].ID)
+	}
+
+	inherited := map[int64][]int64{}
+	next, closer := a.usergroups.GetUserAndGroupList(ctx, sql.NullInt64{Int64: u.ID, Valid: true}, true)
+	defer util.CloseWith(closer)
+	for {
+		var uug usermodels.UserAndUsergroup
+		ok, err := next(&uug)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				break
+			}
+			return nil, errors.Wrap(err, "查询用户在用户组中的角色失败")
+		}
+		if !ok {
+			break
+		}
+		if uug.RoleID <= 0 {
+			continue
+		}
+		if subject.GroupRoles == nil {
+			subject.GroupRoles = map[int64][]int64{}
+		}
+
+		ids, ok := inherited[uug.RoleID]
+		if !ok {
+			ancestors, err := a.users.UserDao.GetRoleAncestors(ctx, uug.RoleID)
+			if err != nil {
+				return nil, errors.Wrap(err, "查询上级角色失败")
+			}
+			for idx := range ancestors {
+				ids = append(ids, ancestors[idx].ID)
+			}
+			inherited[uug.RoleID] = ids
+		}
+		subject.GroupRoles[uug.GroupID] = append(subject.GroupRoles[uug.GroupID], ids...)
+	}
+	return subject, nil
+}
+
+// ExplainFor 按请求返回求值的过程, 需要查看授权的权限
+func (a *Authorizer) ExplainFor(ctx context.Context, currentUser api.User, req *ExplainRequest) (*authz.Explanation, error) {
+	if err := checkPermission(ctx, currentUser, PermissionView, PermissionManage); err != nil {
+		return nil, err
+	}
+	if req.Resource == "" {
+		return nil, ErrResourceEmpty
+	}
+
+	userID := req.UserID
+	if userID == 0 && req.Username != "" {
+		u, err := a.users.GetUserByName(ctx, req.Username)
+		if err != nil {
+			if err == sql.ErrNoRows || errors.IsNotFound(err) {
+				return nil, ErrSubjectNotFound
+			}
+			return nil, errors.Wrap(err, "查询用户失败")
+		}
+		userID = u.ID
+	}
+	if userID == 0 {
+		userID = currentUser.ID()
+	}
+
+	subject, err := a.Subject(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return a.Explain(ctx, req.Resource, subject, req.Object)
+}
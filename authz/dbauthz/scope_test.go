@@ -0,0 +1,100 @@
+package dbauthz
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type testCloser struct{}
+
+func (testCloser) Close() error { return nil }
+
+type testUserDao struct {
+	usermodels.UserDao
+
+	roles     map[int64]usermodels.Role
+	userRoles []int64
+	calls     int
+}
+
+func (dao *testUserDao) GetUserByID(ctx context.Context, id int64) func(*usermodels.User) error {
+	return func(u *usermodels.User) error {
+		u.ID = id
+		u.Name = "tom"
+		return nil
+	}
+}
+
+func (dao *testUserDao) GetEffectiveRolesByUserID(ctx context.Context, userID int64, groupScoped bool) ([]usermodels.Role, error) {
+	var results []usermodels.Role
+	for _, id := range dao.userRoles {
+		results = append(results, dao.roles[id])
+	}
+	return results, nil
+}
+
+func (dao *testUserDao) GetRoleAncestors(ctx context.Context, id int64) ([]usermodels.Role, error) {
+	dao.calls++
+	var results []usermodels.Role
+	for r, ok := dao.roles[id]; ok; r, ok = dao.roles[r.ParentID] {
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+type testUsergroupDao struct {
+	usermodels.UsergroupQueryer
+
+	list []usermodels.UserAndUsergroup
+}
+
+func (dao *testUsergroupDao) GetUserAndGroupList(ctx context.Context, userid sql.NullInt64, groupEnabled bool) (func(*usermodels.UserAndUsergroup) (bool, error), io.Closer) {
+	idx := 0
+	return func(uug *usermodels.UserAndUsergroup) (bool, error) {
+		if idx >= len(dao.list) {
+			return false, nil
+		}
+		*uug = dao.list[idx]
+		idx++
+		return true, nil
+	}, testCloser{}
+}
+
+func TestSubjectInheritedRoles(t *testing.T) {
+	// 4 的上级是 3, 5 的上级是 4
+	users := &testUserDao{
+		roles: map[int64]usermodels.Role{
+			3: {ID: 3, Name: "manager"},
+			4: {ID: 4, Name: "operator", ParentID: 3},
+			5: {ID: 5, Name: "viewer", ParentID: 4},
+		},
+		userRoles: []int64{4, 3},
+	}
+	a := &Authorizer{
+		users: &usermodels.Users{UserDao: users},
+		usergroups: &testUsergroupDao{list: []usermodels.UserAndUsergroup{
+			{UserID: 7, GroupID: 10, RoleID: 5},
+			{UserID: 7, GroupID: 20},
+			{UserID: 7, GroupID: 30, RoleID: 5},
+		}},
+	}
+
+	subject, err := a.Subject(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(subject.Roles, []int64{4, 3}) {
+		t.Error(subject.Roles)
+	}
+	if want := map[int64][]int64{10: {5, 4, 3}, 30: {5, 4, 3}}; !reflect.DeepEqual(subject.GroupRoles, want) {
+		t.Error("want", want, "got", subject.GroupRoles)
+	}
+	if users.calls != 1 {
+		t.Error("ancestors of role isnot cached, calls =", users.calls)
+	}
+}
@@ -0,0 +1,314 @@
+package routeauthz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+)
+
+// PermissionViewRoutes 查看路由和权限对应关系的权限
+const PermissionViewRoutes = "um.permissions.routes"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionViewRoutes, Title: "查看路由的权限", Group: "权限管理"})
+}
+
+// Rule 路由和权限的对应关系
+//
+// Pattern 按 '/' 分段匹配, ':name' 匹配任意一段, 最后一段为 '*' 时匹配剩下的所有段,
+// Method 为空或 '*' 时匹配所有的方法
+type Rule struct {
+	Method     string `json:"method"`
+	Pattern    string `json:"pattern"`
+	Permission string `json:"permission"`
+
+	segments []string
+}
+
+// Route 已知的路由
+type Route struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Permission string `json:"permission,omitempty"`
+}
+
+// DeniedError 没有访问路由的权限
+type DeniedError struct {
+	Permission string
+	Method     string
+	Path       string
+	State      authz.PermissionState
+}
+
+func (e *DeniedError) Error() string {
+	return "没有访问 '" + e.Method + " " + e.Path + "' 的权限(" + e.Permission + ")"
+}
+
+func (e *DeniedError) HTTPCode() int {
+	return http.StatusForbidden
+}
+
+func splitPath(pa string) []string {
+	pa = strings.Trim(pa, "/")
+	if pa == "" {
+		return nil
+	}
+	return strings.Split(pa, "/")
+}
+
+func normalizeMethod(method string) string {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method == "" {
+		return "*"
+	}
+	return method
+}
+
+// matchSegments 路径是否和规则匹配, 路径也可以是路由的模板(如 /api/users/:id)
+func matchSegments(pattern, segments []string) bool {
+	for idx, seg := range pattern {
+		if seg == "*" && idx == len(pattern)-1 {
+			return true
+		}
+		if idx >= len(segments) {
+			return false
+		}
+		if strings.HasPrefix(seg, ":") {
+			continue
+		}
+		if seg != segments[idx] {
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
+
+func (rule *Rule) match(method string, segments []string) bool {
+	if rule.Method != "*" && rule.Method != method {
+		return false
+	}
+	return matchSegments(rule.segments, segments)
+}
+
+// Guard 按路由检查权限, 路由和权限的对应关系由 Protect 注册
+type Guard struct {
+	logger log.Logger
+	strict bool
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// NewGuard 创建 Guard, strict 为 true 时权限必须是 PermissionGranted, 否则 PermissionNeglected 也可以访问
+func NewGuard(logger log.Logger, strict bool) *Guard {
+	return &Guard{
+		logger: logger,
+		strict: strict,
+	}
+}
+
+// Protect 注册路由的权限, 先注册的规则优先
+func (g *Guard) Protect(method, pattern, permission string) *Guard {
+	if permission == "" {
+		panic(errors.New("permission of '" + pattern + "' is empty"))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rules = append(g.rules, Rule{
+		Method:     normalizeMethod(method),
+		Pattern:    "/" + strings.Trim(pattern, "/"),
+		Permission: permission,
+		segments:   splitPath(pattern),
+	})
+	return g
+}
+
+// ProtectAll 注册一组路由的权限, prefix 下的所有路由都使用同一个权限
+func (g *Guard) ProtectAll(prefix, permission string) *Guard {
+	return g.Protect("*", strings.TrimSuffix(prefix, "/")+"/*", permission)
+}
+
+// Rules 返回所有的规则
+func (g *Guard) Rules() []Rule {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]Rule(nil), g.rules...)
+}
+
+// Permission 返回路由对应的权限, 没有对应的规则时返回空字符串
+func (g *Guard) Permission(method, pa string) string {
+	method = normalizeMethod(method)
+	segments := splitPath(pa)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for idx := range g.rules {
+		if g.rules[idx].match(method, segments) {
+			return g.rules[idx].Permission
+		}
+	}
+	return ""
+}
+
+// Check 检查当前用户是否可以访问路由, 路由没有对应的权限时不检查
+func (g *Guard) Check(ctx context.Context, method, pa string) error {
+	permission := g.Permission(method, pa)
+	if permission == "" {
+		return nil
+	}
+	return g.checkPermission(ctx, permission, normalizeMethod(method), pa)
+}
+
+func (g *Guard) checkPermission(ctx context.Context, permission, method, pa string) error {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	if currentUser == nil {
+		return errors.NewError(http.StatusUnauthorized, "用户未登录")
+	}
+
+	state, err := permissionState(ctx, currentUser, permission)
+	if err != nil {
+		return errors.Wrap(err, "检查权限失败")
+	}
+	if g.strict {
+		if state.IsGranted() {
+			return nil
+		}
+	} else if state.IsLooselyGranted() {
+		return nil
+	}
+	return &DeniedError{
+		Permission: permission,
+		Method:     method,
+		Path:       pa,
+		State:      state,
+	}
+}
+
+type stateChecker interface {
+	IsGranted(ctx context.Context, permissionID string) (authz.PermissionState, error)
+}
+
+type unwrapper interface {
+	Unwrap() api.User
+}
+
+// permissionState 返回用户对权限的状态, 用户的 IsGranted 使用配置的 authz.Authorizer,
+// 用户没有实现 IsGranted 时只能用 HasPermission 区分 Granted 和 Ungranted
+func permissionState(ctx context.Context, u api.User, permission string) (authz.PermissionState, error) {
+	for {
+		if checker, ok := u.(stateChecker); ok {
+			return checker.IsGranted(ctx, permission)
+		}
+		w, ok := u.(unwrapper)
+		if !ok {
+			break
+		}
+		u = w.Unwrap()
+	}
+
+	ok, err := u.HasPermission(ctx, permission)
+	if err != nil {
+		return authz.PermissionUnknown, err
+	}
+	if ok {
+		return authz.PermissionGranted, nil
+	}
+	return authz.PermissionUngranted, nil
+}
+
+// Middleware 返回 loong 的中间件, 它必须放在认证的中间件之后
+func (g *Guard) Middleware() loong.MiddlewareFunc {
+	return loong.MiddlewareFunc(func(next loong.HandlerFunc) loong.HandlerFunc {
+		return func(ctx *loong.Context) error {
+			req := ctx.Request()
+			if err := g.Check(ctx.StdContext, req.Method, req.URL.Path); err != nil {
+				g.returnError(ctx.Response(), req, err)
+				return nil
+			}
+			return next(ctx)
+		}
+	})
+}
+
+// FastRoute 注册一个需要权限的 fast route, 它不经过 loong 的中间件, 所以先认证用户再检查权限
+func (g *Guard) FastRoute(httpSrv *moo.HTTPServer, stripPrefix bool, name, permission string, handler http.Handler) {
+	pattern := "/" + strings.Trim(name, "/") + "/*"
+	g.Protect("*", pattern, permission)
+
+	h := loong.RawHTTPAuth(returnError, httpSrv.AuthValidates()...)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if err := g.checkPermission(ctx, permission, r.Method, r.URL.Path); err != nil {
+			g.returnError(w, r, err)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+	httpSrv.FastRoute(stripPrefix, name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(r.Context(), w, r)
+	}))
+}
+
+func (g *Guard) returnError(w http.ResponseWriter, r *http.Request, err error) {
+	if denied, ok := err.(*DeniedError); ok {
+		g.logger.Info("没有访问路由的权限", log.String("method", denied.Method),
+			log.String("path", denied.Path),
+			log.String("permission", denied.Permission),
+			log.Stringer("state", denied.State))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":       http.StatusForbidden,
+			"error":      denied.Error(),
+			"message":    denied.Error(),
+			"permission": denied.Permission,
+			"method":     denied.Method,
+			"path":       denied.Path,
+			"state":      denied.State.String(),
+		})
+		return
+	}
+	returnError(w, r, err.Error(), errors.HTTPCode(err))
+}
+
+func returnError(w http.ResponseWriter, r *http.Request, errText string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    statusCode,
+		"error":   errText,
+		"message": errText,
+	})
+}
+
+// Routes 按规则给路由标上权限, unprotectedOnly 为 true 时只返回没有权限的路由
+func (g *Guard) Routes(routes []Route, unprotectedOnly bool) []Route {
+	results := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		route.Permission = g.Permission(route.Method, route.Path)
+		if unprotectedOnly && route.Permission != "" {
+			continue
+		}
+		results = append(results, route)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].Method < results[j].Method
+	})
+	return results
+}
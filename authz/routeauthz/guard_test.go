@@ -0,0 +1,41 @@
+package routeauthz
+
+import "testing"
+
+func TestPermission(t *testing.T) {
+	g := NewGuard(nil, false)
+	g.Protect("GET", "/api/users/:id", "users.view").
+		Protect("", "/api/users/:id/roles", "users.roles").
+		ProtectAll("/api/permissions", "permissions.manage").
+		Protect("*", "/api/users", "users.list")
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: "GET", path: "/api/users/1", want: "users.view"},
+		{method: "get", path: "/api/users/:id", want: "users.view"},
+		{method: "DELETE", path: "/api/users/1", want: ""},
+		{method: "PUT", path: "/api/users/1/roles", want: "users.roles"},
+		{method: "GET", path: "/api/users/1/roles/2", want: ""},
+		{method: "POST", path: "/api/permissions", want: "permissions.manage"},
+		{method: "DELETE", path: "/api/permissions/grants/1", want: "permissions.manage"},
+		{method: "GET", path: "/api/users/", want: "users.list"},
+		{method: "GET", path: "/api/permissionsx", want: ""},
+	}
+	for _, test := range tests {
+		if got := g.Permission(test.method, test.path); got != test.want {
+			t.Error(test.method, test.path, "want", test.want, "got", got)
+		}
+	}
+
+	routes := g.Routes([]Route{
+		{Method: "GET", Path: "/api/users/:id"},
+		{Method: "DELETE", Path: "/api/users/:id"},
+		{Method: "*", Path: "/uuid/*"},
+	}, true)
+	if len(routes) != 2 || routes[0].Path != "/api/users/:id" || routes[0].Method != "DELETE" || routes[1].Path != "/uuid/*" {
+		t.Error(routes)
+	}
+}
@@ -0,0 +1,68 @@
+package routeauthz
+
+import (
+	"net/http"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+)
+
+var ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有查看路由权限的权限")
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Provide(func(env *moo.Environment, logger log.Logger) *Guard {
+			return NewGuard(logger.Named("routeauthz"), env.Config.BoolWithDefault(api.CfgUserAuthzRoutesStrict, false))
+		})
+	})
+
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Invoke(func(guard *Guard, httpSrv *moo.HTTPServer) {
+			mux := httpSrv.Engine().Group("api/authz", httpSrv.AuthMiddlewares())
+			mux.GET("/routes", func(ctx *loong.Context) error {
+				currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+				if err != nil {
+					return ctx.ReturnError(err, http.StatusUnauthorized)
+				}
+				ok, err := currentUser.HasPermission(ctx.StdContext, PermissionViewRoutes)
+				if err != nil {
+					return ctx.ReturnError(err, http.StatusInternalServerError)
+				}
+				if !ok {
+					return ctx.ReturnError(ErrPermissionDenny)
+				}
+
+				return ctx.ReturnQueryResult(guard.Routes(allRoutes(httpSrv), ctx.QueryParam("unprotected") == "true"))
+			})
+			mux.GET("/rules", func(ctx *loong.Context) error {
+				currentUser, err := api.ReadUserFromContext(ctx.StdContext)
+				if err != nil {
+					return ctx.ReturnError(err, http.StatusUnauthorized)
+				}
+				ok, err := currentUser.HasPermission(ctx.StdContext, PermissionViewRoutes)
+				if err != nil {
+					return ctx.ReturnError(err, http.StatusInternalServerError)
+				}
+				if !ok {
+					return ctx.ReturnError(ErrPermissionDenny)
+				}
+				return ctx.ReturnQueryResult(guard.Rules())
+			})
+		})
+	})
+}
+
+// allRoutes 返回 loong 中的路由和所有的 fast route
+func allRoutes(httpSrv *moo.HTTPServer) []Route {
+	var routes []Route
+	for _, r := range httpSrv.Engine().Routes() {
+		routes = append(routes, Route{Method: r.Method, Path: r.Path})
+	}
+	for _, name := range httpSrv.FastRouteNames() {
+		routes = append(routes, Route{Method: "*", Path: "/" + name + "/*"})
+	}
+	return routes
+}
@@ -0,0 +1,313 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subject 访问者
+//
+// Roles 是用户的全局角色, GroupRoles 是用户在用户组内的角色(用户组 ID -> 角色),
+// 用户组内的角色只对属于该用户组或它的下级用户组的对象有效
+type Subject struct {
+	UserID     int64                  `json:"user_id"`
+	Username   string                 `json:"username,omitempty"`
+	Roles      []int64                `json:"roles"`
+	GroupRoles map[int64][]int64      `json:"group_roles,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Object 被访问的对象, GroupID 为对象所属的用户组, OwnerID 为对象的所有者
+type Object struct {
+	Type       string                 `json:"type,omitempty"`
+	ID         int64                  `json:"id,omitempty"`
+	OwnerID    int64                  `json:"owner_id,omitempty"`
+	GroupID    int64                  `json:"group_id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// ScopedAuthorizer 按访问者和对象检查权限
+type ScopedAuthorizer interface {
+	Authorizer
+
+	// IsGrantedFor 检查访问者是否可以访问对象上的资源, object 为 nil 时只使用全局角色
+	IsGrantedFor(ctx context.Context, res Resource, subject *Subject, object *Object) (PermissionState, error)
+
+	// Explain 和 IsGrantedFor 相同, 但返回求值的过程, 用于调试
+	Explain(ctx context.Context, res Resource, subject *Subject, object *Object) (*Explanation, error)
+}
+
+// Explanation 策略求值的过程
+type Explanation struct {
+	Resource       Resource `json:"resource"`
+	State          string   `json:"state"`
+	Granted        bool     `json:"granted"`
+	EffectiveRoles []int64  `json:"effective_roles"`
+	Steps          []string `json:"steps"`
+}
+
+// Step 记录一个求值的步骤, e 为 nil 时什么也不做
+func (e *Explanation) Step(format string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+	e.Steps = append(e.Steps, fmt.Sprintf(format, args...))
+}
+
+// SetState 记录求值的结果, e 为 nil 时什么也不做
+func (e *Explanation) SetState(state PermissionState) {
+	if e == nil {
+		return
+	}
+	e.State = state.String()
+	e.Granted = state.IsGranted()
+}
+
+// EffectiveRoles 返回访问者在对象上有效的角色, inScope 判断用户组内的角色是否适用于对象
+func (s *Subject) EffectiveRoles(inScope func(groupID int64) (bool, error)) ([]int64, error) {
+	roles := append([]int64(nil), s.Roles...)
+	if len(s.GroupRoles) == 0 || inScope == nil {
+		return roles, nil
+	}
+
+	groupIDs := make([]int64, 0, len(s.GroupRoles))
+	for groupID := range s.GroupRoles {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Slice(groupIDs, func(i, j int) bool { return groupIDs[i] < groupIDs[j] })
+
+	for _, groupID := range groupIDs {
+		ok, err := inScope(groupID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, role := range s.GroupRoles[groupID] {
+			if !containsInt64(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles, nil
+}
+
+func containsInt64(list []int64, v int64) bool {
+	for _, a := range list {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 条件的类型
+const (
+	// ConditionOwner 访问者必须是对象的所有者
+	ConditionOwner = "owner"
+	// ConditionTime 只能在指定的时间段内访问
+	ConditionTime = "time"
+	// ConditionAttribute 对象的属性必须等于访问者的属性或指定的值
+	ConditionAttribute = "attribute"
+)
+
+// Condition 授权的属性条件, 授权的角色检查通过后还要满足所有的条件
+//
+//	{"type": "owner"}
+//	{"type": "time", "start": "09:00", "end": "18:00", "weekdays": [1, 2, 3, 4, 5]}
+//	{"type": "attribute", "name": "department", "subject_attribute": "department"}
+//	{"type": "attribute", "name": "level", "value": "public"}
+type Condition struct {
+	Type string `json:"type"`
+
+	// 时间段, 格式为 HH:MM, End 小于 Start 时表示跨过零点, Weekdays 为空时表示每天, 0 为星期日
+	Start    string `json:"start,omitempty"`
+	End      string `json:"end,omitempty"`
+	Weekdays []int  `json:"weekdays,omitempty"`
+
+	// 对象的属性名, 和访问者的属性(SubjectAttribute)或 Value 比较
+	Name             string      `json:"name,omitempty"`
+	SubjectAttribute string      `json:"subject_attribute,omitempty"`
+	Value            interface{} `json:"value,omitempty"`
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (c *Condition) invalid(msg string) error {
+	return errors.New("条件(" + c.Type + ")不正确: " + msg)
+}
+
+// Validate 检查条件是否合法
+func (c *Condition) Validate() error {
+	switch c.Type {
+	case ConditionOwner:
+		return nil
+	case ConditionTime:
+		if c.Start == "" && c.End == "" && len(c.Weekdays) == 0 {
+			return c.invalid("时间段不能为空")
+		}
+		if c.Start != "" || c.End != "" {
+			if _, err := parseClock(c.Start); err != nil {
+				return c.invalid("开始时间 '" + c.Start + "' 格式不正确")
+			}
+			if _, err := parseClock(c.End); err != nil {
+				return c.invalid("结束时间 '" + c.End + "' 格式不正确")
+			}
+		}
+		for _, day := range c.Weekdays {
+			if day < 0 || day > 6 {
+				return c.invalid("星期 '" + strconv.Itoa(day) + "' 不正确")
+			}
+		}
+		return nil
+	case ConditionAttribute:
+		if c.Name == "" {
+			return c.invalid("属性名不能为空")
+		}
+		if c.SubjectAttribute == "" && c.Value == nil {
+			return c.invalid("subject_attribute 和 value 不能都为空")
+		}
+		return nil
+	default:
+		return c.invalid("不支持的条件类型")
+	}
+}
+
+// Match 访问者和对象是否满足条件, 返回的字符串为匹配或不匹配的原因
+func (c *Condition) Match(subject *Subject, object *Object, now time.Time) (bool, string) {
+	switch c.Type {
+	case ConditionOwner:
+		if object == nil || object.OwnerID == 0 {
+			return false, "对象没有所有者"
+		}
+		if subject == nil || object.OwnerID != subject.UserID {
+			return false, "不是对象的所有者(" + strconv.FormatInt(object.OwnerID, 10) + ")"
+		}
+		return true, "是对象的所有者"
+	case ConditionTime:
+		if len(c.Weekdays) > 0 {
+			found := false
+			for _, day := range c.Weekdays {
+				if time.Weekday(day) == now.Weekday() {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, "今天(" + now.Weekday().String() + ")不在允许的日期内"
+			}
+		}
+		if c.Start != "" || c.End != "" {
+			start, err := parseClock(c.Start)
+			if err != nil {
+				return false, "开始时间格式不正确"
+			}
+			end, err := parseClock(c.End)
+			if err != nil {
+				return false, "结束时间格式不正确"
+			}
+			current := now.Hour()*60 + now.Minute()
+			var ok bool
+			if start <= end {
+				ok = current >= start && current < end
+			} else {
+				ok = current >= start || current < end
+			}
+			if !ok {
+				return false, "当前时间 " + now.Format("15:04") + " 不在 " + c.Start + "-" + c.End + " 之间"
+			}
+		}
+		return true, "在允许的时间段内"
+	case ConditionAttribute:
+		var actual interface{}
+		if object != nil && object.Attributes != nil {
+			actual = object.Attributes[c.Name]
+		}
+		if actual == nil {
+			return false, "对象没有属性 '" + c.Name + "'"
+		}
+		expected := c.Value
+		if c.SubjectAttribute != "" {
+			if subject != nil && subject.Attributes != nil {
+				expected = subject.Attributes[c.SubjectAttribute]
+			} else {
+				expected = nil
+			}
+			if expected == nil {
+				return false, "访问者没有属性 '" + c.SubjectAttribute + "'"
+			}
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(expected) {
+			return false, fmt.Sprintf("对象的属性 '%s' 的值 '%v' 不等于 '%v'", c.Name, actual, expected)
+		}
+		return true, "对象的属性 '" + c.Name + "' 匹配"
+	default:
+		return false, "不支持的条件类型 '" + c.Type + "'"
+	}
+}
+
+// Conditions 条件列表, 所有的条件都满足时才算满足
+type Conditions []Condition
+
+// ParseConditions 解析 JSON 格式的条件列表, 空字符串表示没有条件
+func ParseConditions(s string) (Conditions, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var conditions Conditions
+	if err := json.Unmarshal([]byte(s), &conditions); err != nil {
+		return nil, errors.New("条件不正确: " + err.Error())
+	}
+	if err := conditions.Validate(); err != nil {
+		return nil, err
+	}
+	return conditions, nil
+}
+
+// Validate 检查所有的条件是否合法
+func (conditions Conditions) Validate() error {
+	for idx := range conditions {
+		if err := conditions[idx].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String 返回 JSON 格式的条件列表, 没有条件时返回空字符串
+func (conditions Conditions) String() string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	bs, err := json.Marshal(conditions)
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
+
+// Match 是否满足所有的条件, 每个条件的结果记录在 explain 中
+func (conditions Conditions) Match(subject *Subject, object *Object, now time.Time, explain *Explanation) bool {
+	for idx := range conditions {
+		ok, reason := conditions[idx].Match(subject, object, now)
+		explain.Step("条件 %d(%s): %s", idx+1, conditions[idx].Type, reason)
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package bcrypto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2idHasher argon2id 算法, 参数保存在密码中, 格式为
+// [8]$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // 单位为 KiB
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+// DefaultArgon2idHasher 参数为 OWASP 推荐的值
+func DefaultArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    2,
+		Memory:  19 * 1024,
+		Threads: 1,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func (h *Argon2idHasher) slot() int {
+	return SlotArgon2id
+}
+
+func (h *Argon2idHasher) Algorithm() string {
+	return AlgorithmArgon2id
+}
+
+func (h *Argon2idHasher) Hash(ctx context.Context, s string) (string, error) {
+	salt, err := randomSalt(h.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(s), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	params := fmt.Sprintf("v=%d$m=%d,t=%d,p=%d", argon2.Version, h.Memory, h.Time, h.Threads)
+	return encodePHC(SlotArgon2id, AlgorithmArgon2id, params, salt, key), nil
+}
+
+func (h *Argon2idHasher) decode(hashed string) (*phc, error) {
+	p, err := decodePHC(hashed)
+	if err != nil {
+		return nil, err
+	}
+	if p.id != AlgorithmArgon2id {
+		return nil, errors.New("不是 argon2id 算法加密的密码")
+	}
+	if p.params["t"] <= 0 || p.params["m"] <= 0 || p.params["p"] <= 0 || p.params["p"] > 255 || len(p.key) == 0 {
+		return nil, errors.New("密码格式不正确")
+	}
+	return p, nil
+}
+
+func (h *Argon2idHasher) compare(password, hashed string) error {
+	p, err := h.decode(hashed)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), p.salt,
+		uint32(p.params["t"]), uint32(p.params["m"]), uint8(p.params["p"]), uint32(len(p.key)))
+	return compareKey(p.key, key)
+}
+
+func (h *Argon2idHasher) weaker(hashed string) bool {
+	p, err := h.decode(hashed)
+	if err != nil {
+		return true
+	}
+	return uint32(p.params["t"]) < h.Time ||
+		uint32(p.params["m"]) < h.Memory ||
+		uint32(len(p.key)) < h.KeyLen
+}
+
+func (h *Argon2idHasher) NeedsRehash(hashed string) bool {
+	return needsRehash(h, hashed)
+}
@@ -0,0 +1,268 @@
+package bcrypto
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn/services"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 各个算法在 Hashers 中的位置, 加密后的密码以 "[位置]" 开头
+const (
+	SlotPBKDF2   = 6
+	SlotScrypt   = 7
+	SlotArgon2id = 8
+	SlotBcrypt   = 9
+)
+
+// 算法的名称
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmScrypt   = "scrypt"
+	AlgorithmPBKDF2   = "pbkdf2-sha256"
+	// AlgorithmLegacy 由 users.signing.method 中的其它算法加密的密码
+	AlgorithmLegacy = "legacy"
+	// AlgorithmNone 没有设置密码
+	AlgorithmNone = "none"
+)
+
+// Hasher 密码加密算法, 它可以判断一个已加密的密码是否需要用当前的算法和参数重新加密
+type Hasher interface {
+	api.UserPasswordHasher
+
+	Algorithm() string
+
+	// NeedsRehash 密码不是用当前的算法或比当前弱的参数加密的
+	NeedsRehash(hashed string) bool
+}
+
+// slotHasher 一个在 Hashers 中占有位置的算法
+type slotHasher interface {
+	Hasher
+
+	slot() int
+	// compare 比较密码, hashed 不包含 "[位置]" 前缀
+	compare(password, hashed string) error
+	// weaker hashed 的参数比当前的弱, hashed 不包含 "[位置]" 前缀
+	weaker(hashed string) bool
+}
+
+func register(h slotHasher) {
+	Hashers[h.slot()].Hasher = h.Hash
+	Hashers[h.slot()].Comparer = func(signingString, signature string, key interface{}) error {
+		return h.compare(signingString, signature)
+	}
+}
+
+func init() {
+	register(DefaultPBKDF2Hasher())
+	register(DefaultScryptHasher())
+	register(DefaultArgon2idHasher())
+}
+
+func prefix(slot int) string {
+	return "[" + strconv.Itoa(slot) + "]"
+}
+
+// splitSlot 返回密码的位置和去掉 "[位置]" 后的部分，不是这种格式时位置为 -1
+func splitSlot(hashed string) (int, string) {
+	if len(hashed) > 3 && hashed[0] == '[' && hashed[2] == ']' {
+		if c := hashed[1]; c >= '0' && c <= '9' {
+			return int(c - '0'), hashed[3:]
+		}
+	}
+	return -1, hashed
+}
+
+func needsRehash(h slotHasher, hashed string) bool {
+	slot, rest := splitSlot(hashed)
+	if slot != h.slot() {
+		return true
+	}
+	return h.weaker(rest)
+}
+
+// AlgorithmOf 返回密码是用哪个算法加密的
+func AlgorithmOf(hashed string) string {
+	if hashed == "" {
+		return AlgorithmNone
+	}
+	switch slot, _ := splitSlot(hashed); slot {
+	case SlotBcrypt:
+		return AlgorithmBcrypt
+	case SlotArgon2id:
+		return AlgorithmArgon2id
+	case SlotScrypt:
+		return AlgorithmScrypt
+	case SlotPBKDF2:
+		return AlgorithmPBKDF2
+	}
+	return AlgorithmLegacy
+}
+
+// NewHasher 按名称创建算法, 参数为默认值
+func NewHasher(algorithm string) (Hasher, error) {
+	switch strings.ToLower(algorithm) {
+	case "", AlgorithmBcrypt:
+		return &BcryptHasher{Cost: bcrypt.DefaultCost}, nil
+	case AlgorithmArgon2id, "argon2":
+		return DefaultArgon2idHasher(), nil
+	case AlgorithmScrypt:
+		return DefaultScryptHasher(), nil
+	case AlgorithmPBKDF2, "pbkdf2":
+		return DefaultPBKDF2Hasher(), nil
+	}
+	return nil, errors.New("密码加密算法 '" + algorithm + "' 不支持, 只支持 bcrypt, argon2id, scrypt 和 pbkdf2-sha256")
+}
+
+// ReadHasher 按 users.password.algorithm 及其参数创建算法
+func ReadHasher(env *moo.Environment) (Hasher, error) {
+	algorithm := env.Config.StringWithDefault(api.CfgUserPasswordAlgorithm, AlgorithmBcrypt)
+	h, err := NewHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hasher := h.(type) {
+	case *BcryptHasher:
+		hasher.Cost = env.Config.IntWithDefault(api.CfgUserPasswordBcryptCost, hasher.Cost)
+		if hasher.Cost < bcrypt.MinCost || hasher.Cost > bcrypt.MaxCost {
+			return nil, errors.New("users.password.bcrypt.cost '" + strconv.Itoa(hasher.Cost) + "' 不正确")
+		}
+	case *Argon2idHasher:
+		hasher.Time = uint32(env.Config.IntWithDefault(api.CfgUserPasswordArgon2Time, int(hasher.Time)))
+		hasher.Memory = uint32(env.Config.IntWithDefault(api.CfgUserPasswordArgon2Memory, int(hasher.Memory)))
+		hasher.Threads = uint8(env.Config.IntWithDefault(api.CfgUserPasswordArgon2Threads, int(hasher.Threads)))
+		if hasher.Time == 0 || hasher.Memory < 8*uint32(hasher.Threads) || hasher.Threads == 0 {
+			return nil, errors.New("users.password.argon2id 的参数不正确")
+		}
+	case *ScryptHasher:
+		hasher.LogN = env.Config.IntWithDefault(api.CfgUserPasswordScryptLogN, hasher.LogN)
+		hasher.R = env.Config.IntWithDefault(api.CfgUserPasswordScryptR, hasher.R)
+		hasher.P = env.Config.IntWithDefault(api.CfgUserPasswordScryptP, hasher.P)
+		if hasher.LogN <= 1 || hasher.LogN >= 31 || hasher.R <= 0 || hasher.P <= 0 {
+			return nil, errors.New("users.password.scrypt 的参数不正确")
+		}
+	case *PBKDF2Hasher:
+		hasher.Iterations = env.Config.IntWithDefault(api.CfgUserPasswordPBKDF2Iterations, hasher.Iterations)
+		if hasher.Iterations <= 0 {
+			return nil, errors.New("users.password.pbkdf2.iterations 不正确")
+		}
+	}
+	return h, nil
+}
+
+// BcryptHasher bcrypt 算法, 与 GoHasher 的格式相同
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) slot() int {
+	return SlotBcrypt
+}
+
+func (h *BcryptHasher) Algorithm() string {
+	return AlgorithmBcrypt
+}
+
+func (h *BcryptHasher) Hash(ctx context.Context, s string) (string, error) {
+	sum, err := bcrypt.GenerateFromPassword([]byte(s), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return prefix(SlotBcrypt) + base64.StdEncoding.EncodeToString(sum), nil
+}
+
+func (h *BcryptHasher) compare(password, hashed string) error {
+	return GoComparer(password, hashed, nil)
+}
+
+func (h *BcryptHasher) weaker(hashed string) bool {
+	sum, err := base64.StdEncoding.DecodeString(hashed)
+	if err != nil {
+		return true
+	}
+	cost, err := bcrypt.Cost(sum)
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+func (h *BcryptHasher) NeedsRehash(hashed string) bool {
+	return needsRehash(h, hashed)
+}
+
+func randomSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "生成随机数失败")
+	}
+	return salt, nil
+}
+
+var b64 = base64.RawStdEncoding
+
+// encodePHC 按 PHC 格式编码: $<id>$<params>$<salt>$<hash>
+func encodePHC(slot int, id, params string, salt, key []byte) string {
+	return prefix(slot) + "$" + id + "$" + params + "$" + b64.EncodeToString(salt) + "$" + b64.EncodeToString(key)
+}
+
+type phc struct {
+	id     string
+	params map[string]int
+	salt   []byte
+	key    []byte
+}
+
+// decodePHC 解码 PHC 格式, 忽略 v=19 这样的版本字段
+func decodePHC(s string) (*phc, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) > 0 && parts[0] == "" {
+		parts = parts[1:]
+	}
+	if len(parts) < 4 {
+		return nil, errors.New("密码格式不正确")
+	}
+
+	id := parts[0]
+	paramsStr := parts[len(parts)-3]
+	salt, err := b64.DecodeString(parts[len(parts)-2])
+	if err != nil {
+		return nil, errors.Wrap(err, "密码格式不正确")
+	}
+	key, err := b64.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return nil, errors.Wrap(err, "密码格式不正确")
+	}
+
+	params := map[string]int{}
+	for _, kv := range strings.Split(paramsStr, ",") {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			return nil, errors.New("密码格式不正确")
+		}
+		value, err := strconv.Atoi(kv[idx+1:])
+		if err != nil || value < 0 {
+			return nil, errors.New("密码格式不正确")
+		}
+		params[kv[:idx]] = value
+	}
+	return &phc{id: id, params: params, salt: salt, key: key}, nil
+}
+
+func compareKey(excepted, actual []byte) error {
+	if subtle.ConstantTimeCompare(excepted, actual) != 1 {
+		return services.ErrPasswordNotMatch
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package bcrypto
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/runner-mei/moo/authn/services"
+)
+
+func TestHashers(t *testing.T) {
+	for _, h := range []slotHasher{
+		&Argon2idHasher{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32},
+		&ScryptHasher{LogN: 4, R: 8, P: 1, SaltLen: 16, KeyLen: 32},
+		&PBKDF2Hasher{Iterations: 10, SaltLen: 16, KeyLen: 32},
+		&BcryptHasher{Cost: 4},
+	} {
+		hashed, err := h.Hash(context.Background(), "abc@123")
+		if err != nil {
+			t.Error(h.Algorithm(), err)
+			continue
+		}
+		if !strings.HasPrefix(hashed, prefix(h.slot())) {
+			t.Error(h.Algorithm(), "got", hashed)
+		}
+		if algorithm := AlgorithmOf(hashed); algorithm != h.Algorithm() {
+			t.Error(h.Algorithm(), "got", algorithm)
+		}
+
+		if err := Hashers[h.slot()].Comparer("abc@123", hashed[3:], nil); err != nil {
+			t.Error(h.Algorithm(), err)
+		}
+		if err := Hashers[h.slot()].Comparer("abc@1234", hashed[3:], nil); err != services.ErrPasswordNotMatch {
+			t.Error(h.Algorithm(), "want ErrPasswordNotMatch got", err)
+		}
+		if h.NeedsRehash(hashed) {
+			t.Error(h.Algorithm(), "should not need rehash")
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weak := &PBKDF2Hasher{Iterations: 10, SaltLen: 16, KeyLen: 32}
+	hashed, err := weak.Hash(context.Background(), "abc@123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strong := &PBKDF2Hasher{Iterations: 20, SaltLen: 16, KeyLen: 32}
+	if !strong.NeedsRehash(hashed) {
+		t.Error("iterations is weaker")
+	}
+
+	argon2id := &Argon2idHasher{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}
+	for _, s := range []string{hashed, "[9]abc", "plain", "[HS256]abc"} {
+		if !argon2id.NeedsRehash(s) {
+			t.Error(s, "should need rehash")
+		}
+	}
+}
+
+func TestAlgorithmOf(t *testing.T) {
+	for s, excepted := range map[string]string{
+		"":                     AlgorithmNone,
+		"abc":                  AlgorithmLegacy,
+		"[HS256]abc":           AlgorithmLegacy,
+		"[9]abc":               AlgorithmBcrypt,
+		"[8]$argon2id$v=19$":   AlgorithmArgon2id,
+		"[7]$scrypt$ln=15$":    AlgorithmScrypt,
+		"[6]$pbkdf2-sha256$i=": AlgorithmPBKDF2,
+	} {
+		if actual := AlgorithmOf(s); actual != excepted {
+			t.Error(s, ": want", excepted, "got", actual)
+		}
+	}
+}
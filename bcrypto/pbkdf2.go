@@ -0,0 +1,79 @@
+package bcrypto
+
+import (
+	"context"
+	"crypto/sha256"
+	"strconv"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PBKDF2Hasher PBKDF2-SHA256 算法, 参数保存在密码中, 格式为
+// [6]$pbkdf2-sha256$i=<iterations>$<salt>$<hash>
+type PBKDF2Hasher struct {
+	Iterations int
+	SaltLen    int
+	KeyLen     int
+}
+
+// DefaultPBKDF2Hasher 参数为 OWASP 推荐的值
+func DefaultPBKDF2Hasher() *PBKDF2Hasher {
+	return &PBKDF2Hasher{
+		Iterations: 600000,
+		SaltLen:    16,
+		KeyLen:     32,
+	}
+}
+
+func (h *PBKDF2Hasher) slot() int {
+	return SlotPBKDF2
+}
+
+func (h *PBKDF2Hasher) Algorithm() string {
+	return AlgorithmPBKDF2
+}
+
+func (h *PBKDF2Hasher) Hash(ctx context.Context, s string) (string, error) {
+	salt, err := randomSalt(h.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(s), salt, h.Iterations, h.KeyLen, sha256.New)
+	return encodePHC(SlotPBKDF2, AlgorithmPBKDF2, "i="+strconv.Itoa(h.Iterations), salt, key), nil
+}
+
+func (h *PBKDF2Hasher) decode(hashed string) (*phc, error) {
+	p, err := decodePHC(hashed)
+	if err != nil {
+		return nil, err
+	}
+	if p.id != AlgorithmPBKDF2 {
+		return nil, errors.New("不是 pbkdf2-sha256 算法加密的密码")
+	}
+	if p.params["i"] <= 0 || len(p.key) == 0 {
+		return nil, errors.New("密码格式不正确")
+	}
+	return p, nil
+}
+
+func (h *PBKDF2Hasher) compare(password, hashed string) error {
+	p, err := h.decode(hashed)
+	if err != nil {
+		return err
+	}
+	key := pbkdf2.Key([]byte(password), p.salt, p.params["i"], len(p.key), sha256.New)
+	return compareKey(p.key, key)
+}
+
+func (h *PBKDF2Hasher) weaker(hashed string) bool {
+	p, err := h.decode(hashed)
+	if err != nil {
+		return true
+	}
+	return p.params["i"] < h.Iterations || len(p.key) < h.KeyLen
+}
+
+func (h *PBKDF2Hasher) NeedsRehash(hashed string) bool {
+	return needsRehash(h, hashed)
+}
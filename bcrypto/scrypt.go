@@ -0,0 +1,93 @@
+package bcrypto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ScryptHasher scrypt 算法, 参数保存在密码中, 格式为
+// [7]$scrypt$ln=<log2(N)>,r=<r>,p=<p>$<salt>$<hash>
+type ScryptHasher struct {
+	LogN    int
+	R       int
+	P       int
+	SaltLen int
+	KeyLen  int
+}
+
+// DefaultScryptHasher 参数为 OWASP 推荐的值中内存用得最少的一组, 每次计算约用 32 MiB 内存
+// (128 * r * N 字节), 登录不需要认证, 内存用得太多时并发登录会耗尽服务器的内存
+func DefaultScryptHasher() *ScryptHasher {
+	return &ScryptHasher{
+		LogN:    15,
+		R:       8,
+		P:       3,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func (h *ScryptHasher) slot() int {
+	return SlotScrypt
+}
+
+func (h *ScryptHasher) Algorithm() string {
+	return AlgorithmScrypt
+}
+
+func (h *ScryptHasher) Hash(ctx context.Context, s string) (string, error) {
+	salt, err := randomSalt(h.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(s), salt, 1<<uint(h.LogN), h.R, h.P, h.KeyLen)
+	if err != nil {
+		return "", errors.Wrap(err, "密码加密失败")
+	}
+	params := fmt.Sprintf("ln=%d,r=%d,p=%d", h.LogN, h.R, h.P)
+	return encodePHC(SlotScrypt, AlgorithmScrypt, params, salt, key), nil
+}
+
+func (h *ScryptHasher) decode(hashed string) (*phc, error) {
+	p, err := decodePHC(hashed)
+	if err != nil {
+		return nil, err
+	}
+	if p.id != AlgorithmScrypt {
+		return nil, errors.New("不是 scrypt 算法加密的密码")
+	}
+	if p.params["ln"] <= 1 || p.params["ln"] >= 31 || p.params["r"] <= 0 || p.params["p"] <= 0 || len(p.key) == 0 {
+		return nil, errors.New("密码格式不正确")
+	}
+	return p, nil
+}
+
+func (h *ScryptHasher) compare(password, hashed string) error {
+	p, err := h.decode(hashed)
+	if err != nil {
+		return err
+	}
+	key, err := scrypt.Key([]byte(password), p.salt, 1<<uint(p.params["ln"]), p.params["r"], p.params["p"], len(p.key))
+	if err != nil {
+		return errors.Wrap(err, "密码加密失败")
+	}
+	return compareKey(p.key, key)
+}
+
+func (h *ScryptHasher) weaker(hashed string) bool {
+	p, err := h.decode(hashed)
+	if err != nil {
+		return true
+	}
+	return p.params["ln"] < h.LogN ||
+		p.params["r"] < h.R ||
+		p.params["p"] < h.P ||
+		len(p.key) < h.KeyLen
+}
+
+func (h *ScryptHasher) NeedsRehash(hashed string) bool {
+	return needsRehash(h, hashed)
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/runner-mei/errors"
+)
+
+var fileSeq uint32
+
+// FileSender 将邮件保存为 .eml 文件
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(ctx context.Context, msg *Message) error {
+	from, _, err := msg.validate(s.From)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return errors.Wrap(err, "创建邮件目录 '"+s.Dir+"' 失败")
+	}
+
+	name := time.Now().Format("20060102150405") + "_" + strconv.FormatUint(uint64(atomic.AddUint32(&fileSeq, 1)), 10) + ".eml"
+	filename := filepath.Join(s.Dir, name)
+	if err := ioutil.WriteFile(filename, msg.Bytes(from), 0644); err != nil {
+		return errors.Wrap(err, "保存邮件 '"+filename+"' 失败")
+	}
+	return nil
+}
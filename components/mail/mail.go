@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+)
+
+const (
+	// SenderSMTP 通过 SMTP 服务器发送邮件
+	SenderSMTP = "smtp"
+	// SenderFile 将邮件保存到目录中, 用于测试或没有邮件服务器的环境
+	SenderFile = "file"
+	// SenderLog 将邮件输出到日志中
+	SenderLog = "log"
+)
+
+// Message 一封邮件
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	HTML    bool
+}
+
+// Sender 邮件发送接口
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Provide(func(env *moo.Environment, logger log.Logger) (Sender, error) {
+			return NewSender(env, logger)
+		})
+	})
+}
+
+// NewSender 按配置 mail.sender 创建邮件发送器，缺省时输出到日志中
+func NewSender(env *moo.Environment, logger log.Logger) (Sender, error) {
+	from := env.Config.StringWithDefault(api.CfgMailFrom, "")
+
+	switch name := strings.ToLower(env.Config.StringWithDefault(api.CfgMailSender, SenderLog)); name {
+	case SenderSMTP:
+		address := env.Config.StringWithDefault(api.CfgMailSMTPAddress, "")
+		if address == "" {
+			return nil, errors.New("邮件服务器地址 '" + api.CfgMailSMTPAddress + "' 没有配置")
+		}
+		return &SMTPSender{
+			Address:            address,
+			Username:           env.Config.StringWithDefault(api.CfgMailSMTPUsername, ""),
+			Password:           env.Config.StringWithDefault(api.CfgMailSMTPPassword, ""),
+			TLS:                strings.ToLower(env.Config.StringWithDefault(api.CfgMailSMTPTLS, TLSNone)),
+			InsecureSkipVerify: env.Config.BoolWithDefault(api.CfgMailSMTPSkipVerify, false),
+			From:               from,
+		}, nil
+	case SenderFile:
+		dir := env.Config.StringWithDefault(api.CfgMailFileDir, "")
+		if dir == "" {
+			dir = env.Fs.FromData("mails")
+		}
+		return &FileSender{Dir: dir, From: from}, nil
+	case SenderLog, "":
+		return &LogSender{Logger: logger.Named("mail"), From: from}, nil
+	default:
+		return nil, errors.New("邮件发送方式 '" + name + "' 不支持")
+	}
+}
+
+func (msg *Message) validate(defaultFrom string) (string, []string, error) {
+	from := msg.From
+	if from == "" {
+		from = defaultFrom
+	}
+	if from == "" {
+		return "", nil, errors.New("发件人没有配置")
+	}
+	if len(msg.To) == 0 {
+		return "", nil, errors.New("收件人为空")
+	}
+
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "发件人 '"+from+"' 不正确")
+	}
+	to := make([]string, 0, len(msg.To))
+	for _, s := range msg.To {
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "收件人 '"+s+"' 不正确")
+		}
+		to = append(to, addr.Address)
+	}
+	return fromAddr.Address, to, nil
+}
+
+// Bytes 生成邮件的原始内容
+func (msg *Message) Bytes(from string) []byte {
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		buf.WriteString(key)
+		buf.WriteString(": ")
+		buf.WriteString(value)
+		buf.WriteString("\r\n")
+	}
+
+	writeHeader("From", from)
+	writeHeader("To", strings.Join(msg.To, ", "))
+	writeHeader("Subject", mime.BEncoding.Encode("utf-8", msg.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("MIME-Version", "1.0")
+	if msg.HTML {
+		writeHeader("Content-Type", "text/html; charset=utf-8")
+	} else {
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+	}
+	writeHeader("Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// CanDeliver 邮件是否会真的发给收件人, LogSender 只是输出到日志中
+func CanDeliver(sender Sender) bool {
+	if sender == nil {
+		return false
+	}
+	_, ok := sender.(*LogSender)
+	return !ok
+}
+
+var linkRe = regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://[^\s"'<>]+`)
+
+// redactLinks 隐藏邮件中的链接, 链接中可能带有重置密码之类的令牌, 不能写到日志中
+func redactLinks(body string) string {
+	return linkRe.ReplaceAllString(body, "[链接已隐藏]")
+}
+
+// LogSender 将邮件输出到日志中, 邮件中的链接会被隐藏
+type LogSender struct {
+	Logger log.Logger
+	From   string
+}
+
+func (s *LogSender) Send(ctx context.Context, msg *Message) error {
+	from := msg.From
+	if from == "" {
+		from = s.From
+	}
+	s.Logger.Info("发送邮件", log.String("from", from),
+		log.Any("to", msg.To),
+		log.String("subject", msg.Subject),
+		log.String("body", redactLinks(msg.Body)))
+	return nil
+}
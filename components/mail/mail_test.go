@@ -0,0 +1,191 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer 一个只能接收一封邮件的 SMTP 服务器
+type fakeSMTPServer struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeSMTPServer{listener: listener, done: make(chan struct{})}
+	go srv.serve()
+	return srv
+}
+
+func (srv *fakeSMTPServer) serve() {
+	defer close(srv.done)
+
+	conn, err := srv.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) {
+		conn.Write([]byte(s + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			srv.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			srv.to = append(srv.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var sb strings.Builder
+			for {
+				s, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if s == ".\r\n" {
+					break
+				}
+				sb.WriteString(s)
+			}
+			srv.data = sb.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func decodeBody(t *testing.T, data string) string {
+	t.Helper()
+
+	idx := strings.Index(data, "\r\n\r\n")
+	if idx < 0 {
+		t.Fatal("body is missing -", data)
+	}
+	bs, err := base64.StdEncoding.DecodeString(strings.Replace(data[idx+4:], "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestSMTPSender(t *testing.T) {
+	srv := startFakeSMTPServer(t)
+	defer srv.listener.Close()
+
+	sender := &SMTPSender{
+		Address: srv.listener.Addr().String(),
+		From:    "moo <noreply@example.com>",
+	}
+	err := sender.Send(context.Background(), &Message{
+		To:      []string{"alice@example.com"},
+		Subject: "重置密码",
+		Body:    "点击链接重置密码",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-srv.done
+
+	if srv.from != "noreply@example.com" {
+		t.Error("want noreply@example.com got", srv.from)
+	}
+	if len(srv.to) != 1 || srv.to[0] != "alice@example.com" {
+		t.Error("want alice@example.com got", srv.to)
+	}
+	if !strings.Contains(srv.data, "To: alice@example.com") {
+		t.Error("header To is missing -", srv.data)
+	}
+	if body := decodeBody(t, srv.data); body != "点击链接重置密码" {
+		t.Error("want 点击链接重置密码 got", body)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &FileSender{Dir: dir, From: "noreply@example.com"}
+	err = sender.Send(context.Background(), &Message{
+		To:      []string{"alice@example.com"},
+		Subject: "激活帐号",
+		Body:    "点击链接激活帐号",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("want 1 file got", len(files))
+	}
+	bs, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := decodeBody(t, string(bs)); body != "点击链接激活帐号" {
+		t.Error("want 点击链接激活帐号 got", body)
+	}
+}
+
+func TestSenderRejectsMissingRecipient(t *testing.T) {
+	sender := &FileSender{Dir: "", From: "noreply@example.com"}
+	if err := sender.Send(context.Background(), &Message{Subject: "x"}); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestRedactLinks(t *testing.T) {
+	body := "请点击下面的链接设置新密码:\r\n\r\nhttps://example.com/moo/sessions/reset_password?token=abc%2Bdef\r\n\r\n该链接只能使用一次"
+	actual := redactLinks(body)
+	if strings.Contains(actual, "token=") || strings.Contains(actual, "example.com") {
+		t.Error(actual)
+	}
+	if !strings.Contains(actual, "[链接已隐藏]") || !strings.HasSuffix(actual, "该链接只能使用一次") {
+		t.Error(actual)
+	}
+
+	if CanDeliver(&LogSender{}) || CanDeliver(nil) {
+		t.Error("LogSender cannot deliver")
+	}
+	if !CanDeliver(&FileSender{}) || !CanDeliver(&SMTPSender{}) {
+		t.Error("FileSender and SMTPSender can deliver")
+	}
+}
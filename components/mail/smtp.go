@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/runner-mei/errors"
+)
+
+const (
+	// TLSNone 不加密
+	TLSNone = "none"
+	// TLSStartTLS 连接后通过 STARTTLS 加密
+	TLSStartTLS = "starttls"
+	// TLSImplicit 直接用 TLS 连接（一般是 465 端口）
+	TLSImplicit = "tls"
+)
+
+// SMTPSender 通过 SMTP 服务器发送邮件
+type SMTPSender struct {
+	Address            string
+	Username           string
+	Password           string
+	TLS                string
+	InsecureSkipVerify bool
+	From               string
+	Timeout            time.Duration
+}
+
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "邮件服务器地址 '"+s.Address+"' 不正确")
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "连接邮件服务器 '"+s.Address+"' 失败")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.TLS == TLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "连接邮件服务器 '"+s.Address+"' 失败")
+	}
+
+	if s.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("邮件服务器 '" + s.Address + "' 不支持 STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, "邮件服务器 '"+s.Address+"' STARTTLS 失败")
+		}
+	}
+
+	if s.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+				client.Close()
+				return nil, errors.Wrap(err, "登录邮件服务器 '"+s.Address+"' 失败")
+			}
+		}
+	}
+	return client, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	from, to, err := msg.validate(s.From)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(from); err != nil {
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return errors.Wrap(err, "收件人 '"+addr+"' 被拒绝")
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	if _, err := w.Write(msg.Bytes(from)); err != nil {
+		w.Close()
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	return client.Quit()
+}
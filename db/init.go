@@ -48,13 +48,22 @@ func initDb(env *moo.Environment, logger log.Logger, db *sql.DB) error {
 
 func GetTableNames() map[string]string {
 	return map[string]string{
-		"moo_operation_logs":       "moo_operation_logs",
-		"moo_online_users":         "moo_online_users",
-		"moo_users_and_roles":      "moo_users_and_roles",
-		"moo_users":                "moo_users",
-		"moo_roles":                "moo_roles",
-		"moo_usergroups":           "moo_usergroups",
-		"moo_users_and_usergroups": "moo_users_and_usergroups",
+		"moo_operation_logs":         "moo_operation_logs",
+		"moo_online_users":           "moo_online_users",
+		"moo_api_tokens":             "moo_api_tokens",
+		"moo_user_certificates":      "moo_user_certificates",
+		"moo_permission_grants":      "moo_permission_grants",
+		"moo_permission_grant_roles": "moo_permission_grant_roles",
+		"moo_password_histories":     "moo_password_histories",
+		"moo_user_field_schemas":     "moo_user_field_schemas",
+		"moo_user_tokens":            "moo_user_tokens",
+		"moo_login_failures":         "moo_login_failures",
+		"moo_users_and_roles":        "moo_users_and_roles",
+		"moo_users":                  "moo_users",
+		"moo_roles":                  "moo_roles",
+		"moo_usergroups":             "moo_usergroups",
+		"moo_users_and_usergroups":   "moo_users_and_usergroups",
+		"moo_usergroups_and_roles":   "moo_usergroups_and_roles",
 	}
 }
 
@@ -86,8 +95,17 @@ return ReplaceTableName(`
 
 DELETE FROM moo_operation_logs;
 DELETE FROM moo_online_users;
+DELETE FROM moo_api_tokens;
+DELETE FROM moo_user_certificates;
+DELETE FROM moo_permission_grant_roles;
+DELETE FROM moo_permission_grants;
+DELETE FROM moo_password_histories;
+DELETE FROM moo_user_field_schemas;
+DELETE FROM moo_user_tokens;
+DELETE FROM moo_login_failures;
 DELETE FROM moo_users_and_roles;
 DELETE FROM moo_users_and_usergroups;
+DELETE FROM moo_usergroups_and_roles;
 DELETE FROM moo_user_profiles;
 DELETE FROM moo_users;
 DELETE FROM moo_roles;
@@ -101,8 +119,17 @@ return ReplaceTableName(`
 
 DROP TABLE IF EXISTS moo_operation_logs CASCADE;
 DROP TABLE IF EXISTS moo_online_users CASCADE;
+DROP TABLE IF EXISTS moo_api_tokens CASCADE;
+DROP TABLE IF EXISTS moo_user_certificates CASCADE;
+DROP TABLE IF EXISTS moo_permission_grant_roles CASCADE;
+DROP TABLE IF EXISTS moo_permission_grants CASCADE;
+DROP TABLE IF EXISTS moo_password_histories CASCADE;
+DROP TABLE IF EXISTS moo_user_field_schemas CASCADE;
+DROP TABLE IF EXISTS moo_user_tokens CASCADE;
+DROP TABLE IF EXISTS moo_login_failures CASCADE;
 DROP TABLE IF EXISTS moo_users_and_roles CASCADE;
 DROP TABLE IF EXISTS moo_users_and_usergroups CASCADE;
+DROP TABLE IF EXISTS moo_usergroups_and_roles CASCADE;
 DROP TABLE IF EXISTS moo_user_profiles CASCADE;
 DROP TABLE IF EXISTS moo_users CASCADE;
 DROP TABLE IF EXISTS moo_roles CASCADE;
@@ -132,9 +159,59 @@ CREATE TABLE IF NOT EXISTS moo_users (
 	source      character varying(50),
 	locked_at   timestamp WITH TIME ZONE,
 	created_at  timestamp WITH TIME ZONE,
-	updated_at  timestamp WITH TIME ZONE
+	updated_at  timestamp WITH TIME ZONE,
+	password_changed_at  timestamp WITH TIME ZONE,
+	must_change_password boolean
 );
 
+ALTER TABLE moo_users ADD COLUMN IF NOT EXISTS password_changed_at  timestamp WITH TIME ZONE;
+ALTER TABLE moo_users ADD COLUMN IF NOT EXISTS must_change_password boolean;
+ALTER TABLE moo_users ADD COLUMN IF NOT EXISTS expires_at           timestamp WITH TIME ZONE;
+ALTER TABLE moo_users ADD COLUMN IF NOT EXISTS last_login_at        timestamp WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS moo_password_histories (
+		id          bigserial PRIMARY KEY,
+		user_id     bigint NOT NULL REFERENCES moo_users ON DELETE CASCADE,
+		password    varchar(500) NOT NULL,
+		created_at  timestamp WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS moo_user_field_schemas (
+		id          bigserial PRIMARY KEY,
+		version     varchar(100) NOT NULL UNIQUE,
+		content     text NOT NULL,
+		created_at  timestamp WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS moo_user_tokens (
+		id          bigserial PRIMARY KEY,
+		user_id     bigint NOT NULL REFERENCES moo_users ON DELETE CASCADE,
+		purpose     varchar(50) NOT NULL,
+		token_hash  varchar(100) NOT NULL UNIQUE,
+		expires_at  timestamp WITH TIME ZONE NOT NULL,
+		used_at     timestamp WITH TIME ZONE,
+		created_at  timestamp WITH TIME ZONE,
+		target_url  varchar(2000),
+		address     varchar(100),
+		used_address varchar(100),
+		created_by  bigint
+);
+
+ALTER TABLE moo_user_tokens ADD COLUMN IF NOT EXISTS target_url   varchar(2000);
+ALTER TABLE moo_user_tokens ADD COLUMN IF NOT EXISTS address      varchar(100);
+ALTER TABLE moo_user_tokens ADD COLUMN IF NOT EXISTS used_address varchar(100);
+ALTER TABLE moo_user_tokens ADD COLUMN IF NOT EXISTS created_by   bigint;
+
+CREATE TABLE IF NOT EXISTS moo_login_failures (
+		id          bigserial PRIMARY KEY,
+		kind        varchar(20) NOT NULL,
+		target      varchar(200) NOT NULL,
+		address     varchar(100),
+		failed_at   timestamp WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS moo_login_failures_target_idx ON moo_login_failures (kind, target, failed_at);
+
 CREATE TABLE IF NOT EXISTS moo_user_profiles (
 		id          bigserial PRIMARY KEY,
 		user_id     bigint REFERENCES moo_users ON DELETE CASCADE,
@@ -152,9 +229,11 @@ CREATE TABLE IF NOT EXISTS moo_roles (
     type        integer,
 		is_default  boolean,
 		description text,
+		parent_id   bigint REFERENCES moo_roles ON DELETE SET NULL,
 		created_at  timestamp,
 		updated_at  timestamp
 );
+ALTER TABLE moo_roles ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES moo_roles ON DELETE SET NULL;
 
 CREATE TABLE IF NOT EXISTS moo_users_and_roles (
 		user_id   bigint REFERENCES moo_users ON DELETE CASCADE,
@@ -163,16 +242,66 @@ CREATE TABLE IF NOT EXISTS moo_users_and_roles (
 );
 
 CREATE TABLE IF NOT EXISTS moo_online_users (
-		user_id     bigint REFERENCES moo_users ON DELETE CASCADE,
-		address     inet,
-		uuid        varchar(50),
-		created_at  timestamp,
-		updated_at  timestamp,
+		user_id         bigint REFERENCES moo_users ON DELETE CASCADE,
+		address         inet,
+		uuid            varchar(50),
+		impersonator_id bigint,
+		created_at      timestamp,
+		updated_at      timestamp,
 
-		PRIMARY KEY(user_id, address),
 		UNIQUE(uuid)
 );
 
+-- 模拟登录的会话和用户自己的会话是分开的, 同一个用户从同一个地址只能有一个自己的会话
+ALTER TABLE moo_online_users ADD COLUMN IF NOT EXISTS impersonator_id bigint;
+ALTER TABLE moo_online_users DROP CONSTRAINT IF EXISTS moo_online_users_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS moo_online_users_user_address_idx ON moo_online_users (user_id, address) WHERE impersonator_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS moo_api_tokens (
+		id                bigserial PRIMARY KEY,
+		user_id           bigint NOT NULL REFERENCES moo_users ON DELETE CASCADE,
+		name              varchar(100) NOT NULL,
+		prefix            varchar(20) NOT NULL,
+		token_hash        varchar(100) NOT NULL UNIQUE,
+		ip_list           jsonb,
+		expires_at        timestamp WITH TIME ZONE,
+		last_used_at      timestamp WITH TIME ZONE,
+		last_used_address varchar(100),
+		revoked_at        timestamp WITH TIME ZONE,
+		created_at        timestamp WITH TIME ZONE,
+		updated_at        timestamp WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS moo_user_certificates (
+		id             bigserial PRIMARY KEY,
+		user_id        bigint NOT NULL REFERENCES moo_users ON DELETE CASCADE,
+		fingerprint    varchar(100) NOT NULL UNIQUE,
+		subject        varchar(500) NOT NULL,
+		issuer         varchar(500) NOT NULL,
+		serial_number  varchar(100) NOT NULL,
+		not_after      timestamp WITH TIME ZONE NOT NULL,
+		last_used_at   timestamp WITH TIME ZONE,
+		created_at     timestamp WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS moo_permission_grants (
+		id             bigserial PRIMARY KEY,
+		resource       varchar(200) NOT NULL UNIQUE,
+		allow_anyone   boolean NOT NULL DEFAULT false,
+		conditions     text,
+		description    text,
+		created_at     timestamp WITH TIME ZONE,
+		updated_at     timestamp WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS moo_permission_grant_roles (
+		grant_id       bigint NOT NULL REFERENCES moo_permission_grants ON DELETE CASCADE,
+		role_id        bigint NOT NULL REFERENCES moo_roles ON DELETE CASCADE,
+		forbidden      boolean NOT NULL DEFAULT false,
+		UNIQUE(grant_id, role_id)
+);
+ALTER TABLE moo_permission_grants ADD COLUMN IF NOT EXISTS conditions text;
+
 CREATE TABLE IF NOT EXISTS moo_usergroups
 (
 		id          bigserial PRIMARY KEY,
@@ -185,6 +314,7 @@ CREATE TABLE IF NOT EXISTS moo_usergroups
     updated_at  timestamp with time zone,
     disabled    boolean
 );
+ALTER TABLE moo_usergroups ADD COLUMN IF NOT EXISTS sequence integer;
 
 CREATE TABLE IF NOT EXISTS moo_users_and_usergroups
 (
@@ -200,6 +330,12 @@ CREATE TABLE IF NOT EXISTS moo_users_and_usergroups
     UNIQUE (user_id, group_id, role_id)
 );
 
+CREATE TABLE IF NOT EXISTS moo_usergroups_and_roles (
+		group_id  bigint NOT NULL REFERENCES moo_usergroups ON DELETE CASCADE,
+		role_id   bigint NOT NULL REFERENCES moo_roles ON DELETE CASCADE,
+		UNIQUE(group_id, role_id)
+);
+
 CREATE TABLE IF NOT EXISTS moo_operation_logs (
 	id           BIGSERIAL PRIMARY KEY,
 	userid       bigint REFERENCES moo_users ON DELETE SET NULL,
@@ -208,9 +344,14 @@ CREATE TABLE IF NOT EXISTS moo_operation_logs (
 	successful   boolean,
 	content      text,
 	attributes   jsonb,
-	created_at   timestamp without time zone
+	created_at   timestamp without time zone,
+	impersonator_id   bigint,
+	impersonator_name varchar(100)
 );
 
+ALTER TABLE moo_operation_logs ADD COLUMN IF NOT EXISTS impersonator_id   bigint;
+ALTER TABLE moo_operation_logs ADD COLUMN IF NOT EXISTS impersonator_name varchar(100);
+
 -- +statementBegin
 CREATE OR REPLACE FUNCTION add_admin_user() RETURNS VOID AS $$ 
 BEGIN 
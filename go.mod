@@ -7,15 +7,17 @@ require (
 	github.com/ThreeDotsLabs/watermill v1.1.1
 	github.com/ThreeDotsLabs/watermill-http v1.1.2
 	github.com/astaxie/beego v1.12.1
+	github.com/beevik/etree v1.1.0
 	github.com/certifi/gocertifi v0.0.0-20200104152315-a6d78f326758 // indirect
 	github.com/cockroachdb/errors v1.2.4 // indirect
 	github.com/cockroachdb/logtags v0.0.0-20190617123548-eb05cc24525f // indirect
+	github.com/crewjam/saml v0.4.6
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/digitalcrab/browscap_go v0.0.0-20160912072603-465055751e36
 	github.com/getsentry/raven-go v0.2.0 // indirect
 	github.com/go-playground/validator/v10 v10.4.0 // indirect
 	github.com/gogo/protobuf v1.3.1 // indirect
-	github.com/google/go-cmp v0.4.0
+	github.com/google/go-cmp v0.5.6
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1 // indirect
 	github.com/jaypipes/ghw v0.5.0 // indirect
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0
@@ -38,6 +40,7 @@ require (
 	github.com/runner-mei/loong v1.1.6
 	github.com/runner-mei/resty v0.0.0-20210226135634-e706d59d23f3
 	github.com/runner-mei/validation v0.0.0-20200908120153-bc4aa6175f56
+	github.com/russellhaering/goxmldsig v1.1.1
 	github.com/stretchr/testify v1.7.0
 	github.com/twinj/uuid v1.0.0 // indirect
 	github.com/xeonx/timeago v1.0.0-rc4 // indirect
@@ -45,9 +48,9 @@ require (
 	go.uber.org/fx v1.13.0
 	go.uber.org/multierr v1.7.0 // indirect
 	go.uber.org/zap v1.16.0
-	golang.org/x/crypto v0.0.0-20201203163018-be400aefbc4c
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
-	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 	golang.org/x/sys v0.0.0-20201204225414-ed752295db88 // indirect
 	golang.org/x/text v0.3.4 // indirect
 	golang.org/x/tools v0.0.0-20200804011535-6c149bb5ef0d // indirect
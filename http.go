@@ -2,11 +2,13 @@ package moo
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 	nhttputil "net/http/httputil"
 	_ "net/http/pprof"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -58,6 +60,13 @@ type InAddress struct {
 	HttpsFunc func() (string, string, error) `name:"https-address" optional:"true"`
 }
 
+// InHTTPSConfig 由其它模块提供的 https 的 TLS 配置, 如要求客户端证书
+type InHTTPSConfig struct {
+	In
+
+	TLSConfig *tls.Config `name:"https-tls-config" optional:"true"`
+}
+
 type OutAddress struct {
 	Out
 
@@ -119,7 +128,7 @@ func init() {
 	})
 
 	On(func(*Environment) Option {
-		return Invoke(func(lifecycle Lifecycle, env *Environment, httpSrv *HTTPServer, inAddress InAddress, httpLifecycle InHTTPLifecycle) error {
+		return Invoke(func(lifecycle Lifecycle, env *Environment, httpSrv *HTTPServer, inAddress InAddress, httpLifecycle InHTTPLifecycle, httpsConfig InHTTPSConfig) error {
 			var noListen = true
 
 			if inAddress.HttpFunc == nil {
@@ -229,6 +238,9 @@ func init() {
 							httpSrv.logger.Info("https listen at: " + httpsNetwork + "+" + httpsListenAt)
 
 							hsrv = &http.Server{Addr: httpsListenAt, Handler: httpSrv}
+							if httpsConfig.TLSConfig != nil {
+								hsrv.TLSConfig = httpsConfig.TLSConfig.Clone()
+							}
 							ln, err := netutil.Listen(httpsNetwork, httpsListenAt)
 							if err != nil {
 								return err
@@ -314,6 +326,21 @@ func (srv *HTTPServer) AuthMiddlewares() loong.MiddlewareFunc {
 	return loong.HTTPAuth(srv.authFuncs...)
 }
 
+// AuthValidates 返回认证函数, 用于在 loong 之外(如 fast route)认证用户
+func (srv *HTTPServer) AuthValidates() []loong.AuthValidateFunc {
+	return srv.authFuncs
+}
+
+// FastRouteNames 返回所有 fast route 的名称
+func (srv *HTTPServer) FastRouteNames() []string {
+	names := make([]string, 0, len(srv.fastRoutes))
+	for name := range srv.fastRoutes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (srv *HTTPServer) Engine() *loong.Engine {
 	return srv.engine
 }
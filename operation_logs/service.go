@@ -86,6 +86,13 @@ func (logger operationLogger) WithTx(tx gobatis.DBRunner) OperationLogger {
 }
 
 func (logger operationLogger) LogRecord(ctx context.Context, ol *OperationLog) error {
+	if ol.ImpersonatorID == 0 {
+		if impersonation := api.ImpersonationFromContext(ctx); impersonation != nil {
+			ol.ImpersonatorID = impersonation.ImpersonatorID
+			ol.ImpersonatorName = impersonation.ImpersonatorName
+		}
+	}
+
 	if logger.tx != nil {
 		if ctx == nil {
 			ctx = gobatis.WithDbConnection(context.Background(), logger.tx)
@@ -128,6 +135,10 @@ func (logger oldOperationLogger) LogRecord(ctx context.Context, ol *OperationLog
 	if username == "" {
 		username = "system"
 	}
+	// 旧的表中没有记录管理员的字段, 记在用户名中
+	if impersonation := api.ImpersonationFromContext(ctx); impersonation != nil {
+		username = username + "(" + impersonation.ImpersonatorName + ")"
+	}
 	return logger.dao.Insert(ctx, &OldOperationLog{
 		Username:   username,
 		Successful: ol.Successful,
@@ -0,0 +1,116 @@
+package bulk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RowError 导入失败的行
+type RowError struct {
+	Line  int    `json:"line"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report 一次导入的结果, dry run 时 Created 为可以导入的用户数
+type Report struct {
+	ID          string     `json:"id"`
+	Format      string     `json:"format"`
+	DryRun      bool       `json:"dry_run"`
+	Transaction bool       `json:"transaction"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  time.Time  `json:"finished_at"`
+	Total       int        `json:"total"`
+	Created     int        `json:"created"`
+	Failed      int        `json:"failed"`
+	Ignored     []string   `json:"ignored_columns,omitempty"`
+	Errors      []RowError `json:"errors,omitempty"`
+
+	// UserID 执行导入的用户, 只有他才能下载错误报告
+	UserID int64 `json:"-"`
+
+	columns []string
+	failed  []Row
+}
+
+func (report *Report) fail(row *Row, name string, err error) {
+	report.Failed++
+	report.Errors = append(report.Errors, RowError{Line: row.Line, Name: name, Error: err.Error()})
+	report.failed = append(report.failed, *row)
+}
+
+func (report *Report) String() string {
+	return "共 " + strconv.Itoa(report.Total) +
+		", 导入 " + strconv.Itoa(report.Created) +
+		", 失败 " + strconv.Itoa(report.Failed)
+}
+
+// ErrorTable 返回错误报告, 包含失败的行的原始数据和错误原因, 修改后可以直接重新导入
+func (report *Report) ErrorTable() *Table {
+	table := &Table{Columns: make([]string, 0, len(report.columns)+2)}
+	table.Columns = append(table.Columns, "行号")
+	table.Columns = append(table.Columns, report.columns...)
+	table.Columns = append(table.Columns, "错误")
+
+	for idx, row := range report.failed {
+		values := make([]string, 0, len(table.Columns))
+		values = append(values, strconv.Itoa(row.Line))
+		for cidx := range report.columns {
+			values = append(values, row.Value(cidx))
+		}
+		values = append(values, report.Errors[idx].Error)
+		table.Add(values...)
+	}
+	return table
+}
+
+// Reports 保存最近的导入结果, 用于下载错误报告
+type Reports struct {
+	mu    sync.Mutex
+	max   int
+	items []*Report
+}
+
+// NewReports 创建一个最多保存 max 个导入结果的 Reports
+func NewReports(max int) *Reports {
+	if max <= 0 {
+		max = 50
+	}
+	return &Reports{max: max}
+}
+
+func newReportID() string {
+	var bs [12]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(bs[:])
+}
+
+// Add 保存导入结果, 超过容量时删除最早的结果
+func (reports *Reports) Add(report *Report) {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+
+	if len(reports.items) >= reports.max {
+		copy(reports.items, reports.items[1:])
+		reports.items = reports.items[:len(reports.items)-1]
+	}
+	reports.items = append(reports.items, report)
+}
+
+// Get 按 ID 查询导入结果, 没有时返回 nil
+func (reports *Reports) Get(id string) *Report {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+
+	for _, report := range reports.items {
+		if report.ID == id {
+			return report
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package bulk
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// 内置的列, 用户属性的列为 AttributePrefix 加上属性名
+const (
+	ColumnName        = "name"
+	ColumnNickname    = "nickname"
+	ColumnPassword    = "password"
+	ColumnDescription = "description"
+	ColumnCanLogin    = "can_login"
+	ColumnDisabled    = "disabled"
+	ColumnSource      = "source"
+	ColumnRoles       = "roles"
+	ColumnUsergroups  = "usergroups"
+
+	AttributePrefix = "attributes."
+)
+
+var builtinColumns = []string{
+	ColumnName,
+	ColumnNickname,
+	ColumnPassword,
+	ColumnDescription,
+	ColumnCanLogin,
+	ColumnDisabled,
+	ColumnSource,
+	ColumnRoles,
+	ColumnUsergroups,
+}
+
+// columnAliases 内置列的中文列名
+var columnAliases = map[string]string{
+	"用户名":  ColumnName,
+	"姓名":   ColumnNickname,
+	"密码":   ColumnPassword,
+	"描述":   ColumnDescription,
+	"允许登录": ColumnCanLogin,
+	"禁用":   ColumnDisabled,
+	"来源":   ColumnSource,
+	"角色":   ColumnRoles,
+	"用户组":  ColumnUsergroups,
+}
+
+// Attribute 在 user_fields*.json 中声明的用户属性
+type Attribute struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// Schema 导入导出时可以使用的列, 由内置列和声明的用户属性组成
+type Schema struct {
+	Attributes []Attribute
+}
+
+// NewSchema 用 ReadFieldsFromDir 读到的字段创建 Schema, 属性名为前缀加字段的 ID
+func NewSchema(fieldsList []userservices.Fields) *Schema {
+	schema := &Schema{}
+	seen := map[string]bool{}
+	for _, fields := range fieldsList {
+		for _, field := range fields.Fields {
+			key := fields.Prefix + field.ID
+			if field.ID == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			schema.Attributes = append(schema.Attributes, Attribute{Key: key, Name: field.Name})
+		}
+	}
+	return schema
+}
+
+// Columns 返回导出时的所有列
+func (schema *Schema) Columns() []string {
+	columns := make([]string, 0, len(builtinColumns)+len(schema.Attributes))
+	for _, column := range builtinColumns {
+		if column != ColumnPassword {
+			columns = append(columns, column)
+		}
+	}
+	for _, attr := range schema.Attributes {
+		columns = append(columns, AttributePrefix+attr.Key)
+	}
+	return columns
+}
+
+// Target 返回列名对应的字段, 列名可以是内置列, 内置列的中文名, 属性名, 属性的显示名或加了 AttributePrefix 的属性名,
+// 都不是时返回空字符串
+func (schema *Schema) Target(column string) string {
+	column = strings.TrimSpace(column)
+	lower := strings.ToLower(column)
+	for _, name := range builtinColumns {
+		if lower == name {
+			return name
+		}
+	}
+	if name, ok := columnAliases[column]; ok {
+		return name
+	}
+
+	key := strings.TrimPrefix(column, AttributePrefix)
+	for _, attr := range schema.Attributes {
+		if attr.Key == key {
+			return AttributePrefix + attr.Key
+		}
+	}
+	for _, attr := range schema.Attributes {
+		if attr.Name != "" && attr.Name == column {
+			return AttributePrefix + attr.Key
+		}
+	}
+	return ""
+}
+
+// Resolve 将表格的列映射到用户的字段
+//
+// mapping 为列名到字段的映射, 值为空或 "-" 时忽略该列; 没有在 mapping 中的列按 Target 自动映射, 无法映射的列被忽略。
+// 返回的 targets 和 columns 一一对应, 被忽略的列对应空字符串
+func (schema *Schema) Resolve(columns []string, mapping map[string]string) (targets []string, ignored []string, err error) {
+	targets = make([]string, len(columns))
+	used := map[string]string{}
+	for idx, column := range columns {
+		var target string
+		if value, ok := mapping[column]; ok {
+			if value == "" || value == "-" {
+				ignored = append(ignored, column)
+				continue
+			}
+			target = schema.Target(value)
+			if target == "" {
+				return nil, nil, errors.New("列 '" + column + "' 映射的字段 '" + value + "' 不存在")
+			}
+		} else {
+			target = schema.Target(column)
+			if target == "" {
+				ignored = append(ignored, column)
+				continue
+			}
+		}
+
+		if old, ok := used[target]; ok {
+			return nil, nil, errors.New("列 '" + old + "' 和 '" + column + "' 都映射到了 '" + target + "'")
+		}
+		used[target] = column
+		targets[idx] = target
+	}
+
+	if _, ok := used[ColumnName]; !ok {
+		return nil, nil, errors.New("缺少用户名列 '" + ColumnName + "'")
+	}
+	return targets, ignored, nil
+}
+
+func parseBool(s string, defaultValue bool) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return defaultValue, nil
+	case "1", "t", "true", "y", "yes", "on", "是":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off", "否":
+		return false, nil
+	}
+	return false, errors.New("'" + s + "' 不是有效的布尔值")
+}
+
+func splitList(s, sep string) []string {
+	var results []string
+	for _, a := range strings.Split(s, sep) {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			results = append(results, a)
+		}
+	}
+	return results
+}
+
+// UsergroupPaths 用户组的路径, 路径由上级用户组到该用户组的名称用分隔符连接而成, 如 总部/研发部
+type UsergroupPaths struct {
+	sep   string
+	paths map[int64]string
+	ids   map[string]int64
+	names map[string][]int64
+}
+
+// NewUsergroupPaths 计算所有用户组的路径, sep 为空时使用 /
+func NewUsergroupPaths(groups []usermodels.Usergroup, sep string) *UsergroupPaths {
+	if sep == "" {
+		sep = "/"
+	}
+	byID := make(map[int64]*usermodels.Usergroup, len(groups))
+	for idx := range groups {
+		byID[groups[idx].ID] = &groups[idx]
+	}
+
+	p := &UsergroupPaths{
+		sep:   sep,
+		paths: make(map[int64]string, len(groups)),
+		ids:   make(map[string]int64, len(groups)),
+		names: map[string][]int64{},
+	}
+	var pathOf func(id int64, depth int) string
+	pathOf = func(id int64, depth int) string {
+		if s, ok := p.paths[id]; ok {
+			return s
+		}
+		group := byID[id]
+		s := group.Name
+		// depth 用于防止数据中存在环时无限递归
+		if parent := byID[group.ParentID]; parent != nil && group.ParentID != id && depth < len(groups) {
+			s = pathOf(parent.ID, depth+1) + sep + group.Name
+		}
+		p.paths[id] = s
+		return s
+	}
+	for idx := range groups {
+		path := pathOf(groups[idx].ID, 0)
+		p.ids[path] = groups[idx].ID
+		p.names[groups[idx].Name] = append(p.names[groups[idx].Name], groups[idx].ID)
+	}
+	return p
+}
+
+// Path 返回用户组的路径
+func (p *UsergroupPaths) Path(id int64) string {
+	return p.paths[id]
+}
+
+// Lookup 按路径查找用户组, 不含分隔符且名称唯一时也可以只用名称
+func (p *UsergroupPaths) Lookup(path string) (int64, error) {
+	path = strings.Trim(strings.TrimSpace(path), p.sep)
+	if id, ok := p.ids[path]; ok {
+		return id, nil
+	}
+	if !strings.Contains(path, p.sep) {
+		switch ids := p.names[path]; len(ids) {
+		case 0:
+		case 1:
+			return ids[0], nil
+		default:
+			return 0, errors.New("用户组 '" + path + "' 有 " + strconv.Itoa(len(ids)) + " 个同名的, 请使用完整的路径")
+		}
+	}
+	return 0, errors.New("用户组 '" + path + "' 不存在")
+}
@@ -0,0 +1,374 @@
+package bulk
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+var (
+	errDryRun  = errors.New("dry run")
+	errAborted = errors.New("aborted")
+)
+
+// deletedTag 与 userservices 中软删除用户时加在用户名后的标记一致
+const deletedTag = "(deleted:"
+
+// Options 导入的选项
+type Options struct {
+	Format string `json:"format"`
+
+	// Mapping 列名到字段的映射, 参见 Schema.Resolve
+	Mapping map[string]string `json:"mapping,omitempty"`
+
+	// Transaction 为 true 时所有用户在一个事务中导入, 有任何一行失败都不会导入任何用户, 否则跳过失败的行
+	Transaction bool `json:"transaction"`
+
+	// DryRun 为 true 时只检查, 不会真的导入
+	DryRun bool `json:"dry_run"`
+
+	// Separator 角色和用户组的分隔符, 默认为逗号
+	Separator string `json:"separator,omitempty"`
+
+	// PathSeparator 用户组路径的分隔符, 默认为 /
+	PathSeparator string `json:"path_separator,omitempty"`
+}
+
+// Service 批量导入导出用户
+type Service struct {
+	Env   *moo.Environment
+	Users *userservices.Service
+}
+
+type importRecord struct {
+	row        *Row
+	user       *usermodels.User
+	roles      []string
+	usergroups []int64
+}
+
+type importState struct {
+	opts      *Options
+	targets   []string
+	roles     map[string]bool
+	groups    *UsergroupPaths
+	names     map[string]int
+	nicknames map[string]int
+}
+
+func (svc *Service) schema() (*Schema, error) {
+	fields, err := userservices.ReadFieldsFromDir(svc.Env)
+	if err != nil {
+		return nil, errors.Wrap(err, "读用户属性的定义失败")
+	}
+	return NewSchema(fields), nil
+}
+
+func (svc *Service) usergroupPaths(ctx *userservices.RequestContext, sep string) (*UsergroupPaths, error) {
+	groups, err := svc.Users.GetUsergroups(ctx, sql.NullInt64{})
+	if err != nil {
+		return nil, err
+	}
+	return NewUsergroupPaths(groups, sep), nil
+}
+
+// Import 导入用户, 每个用户都通过 userservices.Service 创建, 所以会有和单个创建用户相同的校验和操作日志
+func (svc *Service) Import(ctx *userservices.RequestContext, data []byte, opts *Options) (*Report, error) {
+	report := &Report{
+		ID:          newReportID(),
+		Format:      opts.Format,
+		DryRun:      opts.DryRun,
+		Transaction: opts.Transaction,
+		StartedAt:   time.Now(),
+	}
+	if ctx.CurrentUser != nil {
+		report.UserID = ctx.CurrentUser.ID()
+	}
+	if opts.Separator == "" {
+		opts.Separator = ","
+	}
+
+	table, err := ReadTable(opts.Format, data)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, 400)
+	}
+	schema, err := svc.schema()
+	if err != nil {
+		return nil, err
+	}
+	targets, ignored, err := schema.Resolve(table.Columns, opts.Mapping)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, 400)
+	}
+	report.columns = table.Columns
+	report.Ignored = ignored
+
+	state := &importState{
+		opts:      opts,
+		targets:   targets,
+		roles:     map[string]bool{},
+		names:     map[string]int{},
+		nicknames: map[string]int{},
+	}
+	roleList, err := ctx.Users.GetRoles(ctx.Ctx, "", 0, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询角色失败")
+	}
+	for idx := range roleList {
+		state.roles[roleList[idx].Name] = true
+	}
+	state.groups, err = svc.usergroupPaths(ctx, opts.PathSeparator)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*importRecord
+	for idx := range table.Rows {
+		row := &table.Rows[idx]
+		report.Total++
+
+		record, err := svc.toRecord(ctx, state, row)
+		if err != nil {
+			name := ""
+			if record != nil {
+				name = record.user.Name
+			}
+			report.fail(row, name, err)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	if opts.Transaction {
+		if report.Failed == 0 {
+			err = ctx.InTransaction(func(ctx *userservices.RequestContext) error {
+				for _, record := range records {
+					if err := svc.create(ctx, record); err != nil {
+						report.fail(record.row, record.user.Name, err)
+						return errAborted
+					}
+					report.Created++
+				}
+				if opts.DryRun {
+					return errDryRun
+				}
+				return nil
+			})
+			if err != nil && err != errDryRun {
+				report.Created = 0
+				if err != errAborted {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		for _, record := range records {
+			err := ctx.InTransaction(func(ctx *userservices.RequestContext) error {
+				if err := svc.create(ctx, record); err != nil {
+					return err
+				}
+				if opts.DryRun {
+					return errDryRun
+				}
+				return nil
+			})
+			if err != nil && err != errDryRun {
+				report.fail(record.row, record.user.Name, err)
+				continue
+			}
+			report.Created++
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	if !opts.DryRun && ctx.CurrentUser != nil {
+		if err := ctx.OpLogger.LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "import_users",
+			Successful: report.Failed == 0,
+			Content:    "批量导入用户: " + report.String(),
+		}); err != nil {
+			return nil, errors.Wrap(err, "添加操作日志失败")
+		}
+	}
+	return report, nil
+}
+
+// toRecord 将一行转换为用户, 并检查用户名, 角色和用户组, 返回错误时 record 可能不为 nil, 以便报告用户名
+func (svc *Service) toRecord(ctx *userservices.RequestContext, state *importState, row *Row) (*importRecord, error) {
+	record := &importRecord{
+		row:  row,
+		user: &usermodels.User{CanLogin: true, Attributes: map[string]interface{}{}},
+	}
+
+	for idx, target := range state.targets {
+		if target == "" {
+			continue
+		}
+		value := strings.TrimSpace(row.Value(idx))
+
+		var err error
+		switch target {
+		case ColumnName:
+			record.user.Name = value
+		case ColumnNickname:
+			record.user.Nickname = value
+		case ColumnPassword:
+			record.user.Password = row.Value(idx)
+		case ColumnDescription:
+			record.user.Description = value
+		case ColumnSource:
+			record.user.Source = value
+		case ColumnCanLogin:
+			record.user.CanLogin, err = parseBool(value, true)
+		case ColumnDisabled:
+			record.user.Disabled, err = parseBool(value, false)
+		case ColumnRoles:
+			for _, name := range splitList(value, state.opts.Separator) {
+				if !state.roles[name] {
+					return record, errors.New("角色 '" + name + "' 不存在")
+				}
+				record.roles = append(record.roles, name)
+			}
+		case ColumnUsergroups:
+			for _, path := range splitList(value, state.opts.Separator) {
+				groupID, err := state.groups.Lookup(path)
+				if err != nil {
+					return record, err
+				}
+				record.usergroups = append(record.usergroups, groupID)
+			}
+		default:
+			if value != "" {
+				record.user.Attributes[strings.TrimPrefix(target, AttributePrefix)] = value
+			}
+		}
+		if err != nil {
+			return record, errors.Wrap(err, "列 '"+target+"' 不正确")
+		}
+	}
+
+	if record.user.Name == "" {
+		return record, errors.New("用户名不能为空")
+	}
+	if record.user.Nickname == "" {
+		record.user.Nickname = record.user.Name
+	}
+
+	key := strings.ToLower(record.user.Name)
+	if line, ok := state.names[key]; ok {
+		return record, errors.New("用户名 '" + record.user.Name + "' 和第 " + strconv.Itoa(line) + " 行重复")
+	}
+	state.names[key] = row.Line
+	if line, ok := state.nicknames[record.user.Nickname]; ok {
+		return record, errors.New("用户姓名 '" + record.user.Nickname + "' 和第 " + strconv.Itoa(line) + " 行重复")
+	}
+	state.nicknames[record.user.Nickname] = row.Line
+
+	exists, err := ctx.Users.UsernameExists(ctx.Ctx, record.user.Name)
+	if err != nil {
+		return record, errors.Wrap(err, "查询该用户是否存在失败")
+	}
+	if exists {
+		return record, errors.New("该用户名 '" + record.user.Name + "' 已存在!")
+	}
+	exists, err = ctx.Users.NicknameExists(ctx.Ctx, record.user.Nickname)
+	if err != nil {
+		return record, errors.Wrap(err, "查询该用户是否存在失败")
+	}
+	if exists {
+		return record, errors.New("该用户姓名 '" + record.user.Nickname + "' 已存在!")
+	}
+	return record, nil
+}
+
+func (svc *Service) create(ctx *userservices.RequestContext, record *importRecord) error {
+	// CreateUser 会将密码替换为加密后的值, 所以用一个副本创建, 以便 dry run 或失败后 record 不变
+	user := *record.user
+	userID, err := svc.Users.CreateUserWithRoleNames(ctx, &user, record.roles, false)
+	if err != nil {
+		return err
+	}
+	for _, groupID := range record.usergroups {
+		if err := ctx.Usergroups.AddUserToGroup(ctx.Ctx, groupID, userID, 0); err != nil {
+			return errors.Wrap(err, "将用户加入用户组失败")
+		}
+	}
+	if len(record.usergroups) > 0 {
+		ctx.Notify(api.BusUsergroupChanged, api.ChangeMembersChanged, record.usergroups...)
+	}
+	return nil
+}
+
+// Export 导出满足条件的用户, 不会导出密码, 已删除的用户和隐藏的用户
+func (svc *Service) Export(ctx *userservices.RequestContext, query *usermodels.UserQueryParams) (*Table, error) {
+	schema, err := svc.schema()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := svc.usergroupPaths(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	users, err := svc.Users.GetUsers(ctx, query, &userservices.UserQueryOptions{
+		HasRoleInfo:      true,
+		HasUsergroupInfo: true,
+	}, 0, 0, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	table := &Table{Columns: schema.Columns()}
+	for idx := range users {
+		u := &users[idx]
+		if u.IsHidden() || strings.Contains(u.Name, deletedTag) {
+			continue
+		}
+
+		values := make([]string, 0, len(table.Columns))
+		for _, column := range table.Columns {
+			var value string
+			switch column {
+			case ColumnName:
+				value = u.Name
+			case ColumnNickname:
+				value = u.Nickname
+			case ColumnDescription:
+				value = u.Description
+			case ColumnCanLogin:
+				value = api.BoolToString(u.CanLogin)
+			case ColumnDisabled:
+				value = api.BoolToString(u.Disabled)
+			case ColumnSource:
+				value = u.Source
+			case ColumnRoles:
+				var names []string
+				for _, role := range u.RolesFromExtensions() {
+					names = append(names, role.Name)
+				}
+				value = strings.Join(names, ",")
+			case ColumnUsergroups:
+				var paths []string
+				for _, group := range u.UsergroupsFromExtensions() {
+					paths = append(paths, groups.Path(group.ID))
+				}
+				value = strings.Join(paths, ",")
+			default:
+				if v := u.Attributes[strings.TrimPrefix(column, AttributePrefix)]; v != nil {
+					value = jsonString(v)
+				}
+			}
+			values = append(values, value)
+		}
+		table.Add(values...)
+	}
+	return table, nil
+}
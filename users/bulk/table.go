@@ -0,0 +1,270 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+)
+
+// 支持的文件格式
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatJSON = "json"
+)
+
+// Row 表格中的一行, Line 为它在文件中的行号(表头为第 1 行), JSON 文件中为第几个对象。
+// 注意 CSV 文件中的空行不计入行号, 这是 encoding/csv 的行为。
+type Row struct {
+	Line   int
+	Values []string
+}
+
+// Table 导入或导出的表格
+type Table struct {
+	Columns []string
+	Rows    []Row
+}
+
+// Index 返回列的位置, 没有时返回 -1
+func (table *Table) Index(column string) int {
+	for idx := range table.Columns {
+		if table.Columns[idx] == column {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Value 返回行中某一列的值
+func (row *Row) Value(idx int) string {
+	if idx < 0 || idx >= len(row.Values) {
+		return ""
+	}
+	return row.Values[idx]
+}
+
+// Add 添加一行
+func (table *Table) Add(values ...string) {
+	table.Rows = append(table.Rows, Row{Line: len(table.Rows) + 2, Values: values})
+}
+
+// DetectFormat 按 format 参数, 文件名或 Content-Type 判断文件格式, 都无法判断时返回空字符串
+func DetectFormat(format, filename, contentType string) string {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case FormatCSV:
+		return FormatCSV
+	case FormatXLSX, "excel":
+		return FormatXLSX
+	case FormatJSON:
+		return FormatJSON
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return FormatCSV
+	case ".xlsx":
+		return FormatXLSX
+	case ".json":
+		return FormatJSON
+	}
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return FormatCSV
+	case strings.Contains(contentType, "spreadsheetml"):
+		return FormatXLSX
+	case strings.Contains(contentType, "json"):
+		return FormatJSON
+	}
+	return ""
+}
+
+// ContentType 返回文件格式对应的 Content-Type
+func ContentType(format string) string {
+	switch format {
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatJSON:
+		return "application/json; charset=utf-8"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// ReadTable 读取表格, CSV 和 XLSX 的第一行为列名, JSON 为对象数组或 {"data": [...]}, 空行会被忽略
+func ReadTable(format string, data []byte) (*Table, error) {
+	var lines [][]string
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\uFEFF"))))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		all, err := reader.ReadAll()
+		if err != nil {
+			return nil, errors.Wrap(err, "读取 CSV 文件失败")
+		}
+		lines = all
+	case FormatXLSX:
+		all, err := ReadXLSX(data)
+		if err != nil {
+			return nil, err
+		}
+		lines = all
+	case FormatJSON:
+		return readJSONTable(data)
+	default:
+		return nil, errors.New("不支持的文件格式 '" + format + "', 只支持 csv, xlsx 和 json")
+	}
+
+	table := &Table{}
+	for idx, values := range lines {
+		if table.Columns == nil {
+			if isBlank(values) {
+				continue
+			}
+			for _, column := range values {
+				table.Columns = append(table.Columns, strings.TrimSpace(column))
+			}
+			continue
+		}
+		if isBlank(values) {
+			continue
+		}
+		table.Rows = append(table.Rows, Row{Line: idx + 1, Values: values})
+	}
+	return table, nil
+}
+
+func isBlank(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func readJSONTable(data []byte) (*Table, error) {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		var wrapped struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, errors.Wrap(err, "不是有效的 JSON 文件")
+		}
+		objects = wrapped.Data
+	}
+
+	seen := map[string]bool{}
+	table := &Table{}
+	for _, object := range objects {
+		for key := range object {
+			if !seen[key] {
+				seen[key] = true
+				table.Columns = append(table.Columns, key)
+			}
+		}
+	}
+	sort.Strings(table.Columns)
+
+	for idx, object := range objects {
+		values := make([]string, len(table.Columns))
+		for cidx, column := range table.Columns {
+			values[cidx] = jsonString(object[column])
+		}
+		if isBlank(values) {
+			continue
+		}
+		table.Rows = append(table.Rows, Row{Line: idx + 1, Values: values})
+	}
+	return table, nil
+}
+
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, a := range v {
+			ss = append(ss, jsonString(a))
+		}
+		return strings.Join(ss, ",")
+	default:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(bs)
+	}
+}
+
+// WriteTable 按格式写表格, CSV 文件带有 UTF-8 的 BOM, 以便 Excel 能正确识别中文
+func WriteTable(w io.Writer, format string, table *Table) error {
+	switch format {
+	case FormatXLSX:
+		rows := make([][]string, 0, len(table.Rows)+1)
+		rows = append(rows, table.Columns)
+		for _, row := range table.Rows {
+			rows = append(rows, row.Values)
+		}
+		return WriteXLSX(w, rows)
+	case FormatJSON:
+		objects := make([]map[string]string, 0, len(table.Rows))
+		for _, row := range table.Rows {
+			object := map[string]string{}
+			for idx, column := range table.Columns {
+				if value := row.Value(idx); value != "" {
+					object[column] = value
+				}
+			}
+			objects = append(objects, object)
+		}
+		return json.NewEncoder(w).Encode(objects)
+	case FormatCSV:
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(table.Columns); err != nil {
+			return err
+		}
+		for _, row := range table.Rows {
+			if err := writer.Write(row.Values); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return errors.New("不支持的文件格式 '" + format + "', 只支持 csv, xlsx 和 json")
+	}
+}
+
+// ReadAll 读取上传的文件, 文件超过 limit 字节时返回错误
+func ReadAll(r io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "读取文件失败")
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New("文件太大, 不能超过 " + strconv.FormatInt(limit/1024/1024, 10) + "MB")
+	}
+	return data, nil
+}
@@ -0,0 +1,130 @@
+package bulk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+func TestTableRoundTrip(t *testing.T) {
+	table := &Table{Columns: []string{"name", "nickname", "attributes.email"}}
+	table.Add("zhangsan", "张三", "a@b.com")
+	table.Add("lisi", "李四, \"小李\"", "")
+	table.Add("wangwu", "<王五>&", "c@d.com")
+
+	for _, format := range []string{FormatCSV, FormatXLSX, FormatJSON} {
+		var buf bytes.Buffer
+		if err := WriteTable(&buf, format, table); err != nil {
+			t.Error(format, err)
+			continue
+		}
+
+		result, err := ReadTable(format, buf.Bytes())
+		if err != nil {
+			t.Error(format, err)
+			continue
+		}
+
+		if format == FormatJSON {
+			// JSON 的列按名称排序
+			if !reflect.DeepEqual(result.Columns, []string{"attributes.email", "name", "nickname"}) {
+				t.Error(format, result.Columns)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(result.Columns, table.Columns) {
+			t.Error(format, result.Columns)
+		}
+		if len(result.Rows) != len(table.Rows) {
+			t.Error(format, "want", len(table.Rows), "got", len(result.Rows))
+			continue
+		}
+		for idx := range table.Rows {
+			if result.Rows[idx].Line != idx+2 {
+				t.Error(format, "line: want", idx+2, "got", result.Rows[idx].Line)
+			}
+			for cidx := range table.Columns {
+				if result.Rows[idx].Value(cidx) != table.Rows[idx].Value(cidx) {
+					t.Error(format, "want", table.Rows[idx].Value(cidx), "got", result.Rows[idx].Value(cidx))
+				}
+			}
+		}
+	}
+}
+
+func TestReadTableSkipBlank(t *testing.T) {
+	table, err := ReadTable(FormatCSV, []byte("\uFEFF\nname,nickname\n\na,b\n,\nc,d\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(table.Columns, []string{"name", "nickname"}) {
+		t.Error(table.Columns)
+	}
+	if len(table.Rows) != 2 || table.Rows[0].Line != 2 || table.Rows[1].Line != 4 {
+		t.Error(table.Rows)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	schema := NewSchema([]userservices.Fields{
+		{Prefix: "", Fields: []userservices.Field{{ID: "email", Name: "邮箱"}}},
+	})
+
+	targets, ignored, err := schema.Resolve([]string{"用户名", "邮箱", "remark", "Nickname"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []string{ColumnName, "attributes.email", "", ColumnNickname}) {
+		t.Error(targets)
+	}
+	if !reflect.DeepEqual(ignored, []string{"remark"}) {
+		t.Error(ignored)
+	}
+
+	targets, _, err = schema.Resolve([]string{"account", "邮箱"}, map[string]string{"account": "name", "邮箱": "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []string{ColumnName, ""}) {
+		t.Error(targets)
+	}
+
+	if _, _, err = schema.Resolve([]string{"name", "用户名"}, nil); err == nil {
+		t.Error("want error for duplicated columns")
+	}
+	if _, _, err = schema.Resolve([]string{"nickname"}, nil); err == nil {
+		t.Error("want error for missing name")
+	}
+}
+
+func TestUsergroupPaths(t *testing.T) {
+	paths := NewUsergroupPaths([]usermodels.Usergroup{
+		{ID: 1, Name: "总部"},
+		{ID: 2, Name: "研发部", ParentID: 1},
+		{ID: 3, Name: "测试组", ParentID: 2},
+		{ID: 4, Name: "研发部", ParentID: 5},
+		{ID: 5, Name: "分部", ParentID: 4},
+	}, "")
+
+	if s := paths.Path(3); s != "总部/研发部/测试组" {
+		t.Error(s)
+	}
+	for path, id := range map[string]int64{
+		"总部/研发部/测试组": 3,
+		"/总部/研发部/":   2,
+		"测试组":        3,
+	} {
+		if got, err := paths.Lookup(path); err != nil || got != id {
+			t.Error(path, got, err)
+		}
+	}
+	if _, err := paths.Lookup("研发部"); err == nil {
+		t.Error("want error for duplicated name")
+	}
+	if _, err := paths.Lookup("总部/测试组"); err == nil {
+		t.Error("want error for not found")
+	}
+}
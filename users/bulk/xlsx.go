@@ -0,0 +1,265 @@
+package bulk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+)
+
+// 只实现了导入导出需要的 XLSX 的一个子集: 读取第一个工作表的文本和数字, 写入只包含内联字符串的工作表
+
+type xlsxText struct {
+	Text []string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	var sb strings.Builder
+	for _, s := range t.Text {
+		sb.WriteString(s)
+	}
+	for _, r := range t.Runs {
+		sb.WriteString(r.Text)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"`
+	Type   string   `xml:"t,attr"`
+	Value  string   `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+type xlsxRow struct {
+	Ref   int        `xml:"r,attr"`
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxWorksheet struct {
+	Rows []xlsxRow `xml:"sheetData>row"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+func readZipXML(files map[string]*zip.File, name string, value interface{}) (bool, error) {
+	f := files[name]
+	if f == nil {
+		return false, nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(r).Decode(value); err != nil {
+		return false, errors.Wrap(err, "解析 '"+name+"' 失败")
+	}
+	return true, nil
+}
+
+// firstSheet 返回第一个工作表的文件名
+func firstSheet(files map[string]*zip.File) (string, error) {
+	var workbook xlsxWorkbook
+	var rels xlsxRelationships
+	ok, err := readZipXML(files, "xl/workbook.xml", &workbook)
+	if err != nil {
+		return "", err
+	}
+	if ok && len(workbook.Sheets) > 0 {
+		if _, err := readZipXML(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+			return "", err
+		}
+		for _, rel := range rels.Items {
+			if rel.ID != workbook.Sheets[0].RID {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return "xl/worksheets/sheet1.xml", nil
+}
+
+// columnIndex 将单元格的引用(如 AB12)转换为从 0 开始的列号
+func columnIndex(ref string) int {
+	idx := 0
+	for _, c := range ref {
+		if c >= 'a' && c <= 'z' {
+			c = c - 'a' + 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		idx = idx*26 + int(c-'A'+1)
+	}
+	return idx - 1
+}
+
+// columnName 将从 0 开始的列号转换为列名(如 AB)
+func columnName(idx int) string {
+	var bs []byte
+	for idx++; idx > 0; idx = (idx - 1) / 26 {
+		bs = append([]byte{byte('A' + (idx-1)%26)}, bs...)
+	}
+	return string(bs)
+}
+
+// ReadXLSX 读取 XLSX 文件中第一个工作表的所有行, 返回的第 i 行对应表格中的第 i+1 行
+func ReadXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "不是有效的 XLSX 文件")
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var sst xlsxSharedStrings
+	if _, err := readZipXML(files, "xl/sharedStrings.xml", &sst); err != nil {
+		return nil, err
+	}
+	sheetName, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	var sheet xlsxWorksheet
+	ok, err := readZipXML(files, sheetName, &sheet)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("XLSX 文件中没有工作表")
+	}
+
+	var results [][]string
+	for _, row := range sheet.Rows {
+		var values []string
+		for idx, cell := range row.Cells {
+			col := idx
+			if cell.Ref != "" {
+				col = columnIndex(cell.Ref)
+			}
+			if col < 0 {
+				continue
+			}
+
+			var value string
+			switch cell.Type {
+			case "s":
+				n, err := strconv.Atoi(strings.TrimSpace(cell.Value))
+				if err != nil || n < 0 || n >= len(sst.Items) {
+					return nil, errors.New("单元格 " + cell.Ref + " 引用的字符串不存在")
+				}
+				value = sst.Items[n].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "b":
+				value = strconv.FormatBool(cell.Value == "1")
+			default:
+				value = cell.Value
+			}
+
+			for len(values) < col {
+				values = append(values, "")
+			}
+			if col < len(values) {
+				values[col] = value
+			} else {
+				values = append(values, value)
+			}
+		}
+
+		// 按行号补上文件中省略的空行, 保证行号和表格中的一致
+		for row.Ref > 0 && len(results) < row.Ref-1 {
+			results = append(results, nil)
+		}
+		results = append(results, values)
+	}
+	return results, nil
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+)
+
+// WriteXLSX 将所有行写为只有一个工作表的 XLSX 文件
+func WriteXLSX(w io.Writer, rows [][]string) error {
+	zw := zip.NewWriter(w)
+	for _, item := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbookXML},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := zw.Create(item.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, item.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	buf.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for ridx, row := range rows {
+		r := strconv.Itoa(ridx + 1)
+		buf.WriteString(`<row r="` + r + `">`)
+		for cidx, value := range row {
+			if value == "" {
+				continue
+			}
+			buf.WriteString(`<c r="` + columnName(cidx) + r + `" t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(&buf, []byte(value)); err != nil {
+				return err
+			}
+			buf.WriteString(`</t></is></c>`)
+		}
+		buf.WriteString(`</row>`)
+	}
+	buf.WriteString(`</sheetData></worksheet>`)
+	if _, err := io.Copy(f, &buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,294 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/components/pubsub"
+)
+
+// PermissionManageUserCache 查看和刷新用户缓存的权限
+const PermissionManageUserCache = "um.users.cache"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionManageUserCache, Title: "查看和刷新用户缓存", Group: "用户管理"})
+}
+
+// CacheStats 缓存的统计信息, Evictions 为按事件删除的次数, Flushes 为按事件清空的次数
+type CacheStats struct {
+	Items     int    `json:"items"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Flushes   uint64 `json:"flushes"`
+}
+
+// cacheCounters 单独分配以保证 64 位对齐, 为 nil 时不统计
+type cacheCounters struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	flushes   uint64
+}
+
+func (c *cacheCounters) hit() {
+	if c != nil {
+		atomic.AddUint64(&c.hits, 1)
+	}
+}
+
+func (c *cacheCounters) miss() {
+	if c != nil {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+func (c *cacheCounters) evict() {
+	if c != nil {
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+func (c *cacheCounters) flush() {
+	if c != nil {
+		atomic.AddUint64(&c.flushes, 1)
+	}
+}
+
+func (c *cacheCounters) stats(items int) CacheStats {
+	if c == nil {
+		return CacheStats{Items: items}
+	}
+	return CacheStats{
+		Items:     items,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Flushes:   atomic.LoadUint64(&c.flushes),
+	}
+}
+
+// CacheStats 返回用户缓存的统计信息
+func (um *UserManager) CacheStats() CacheStats {
+	return um.userCache.Stats()
+}
+
+// CacheStatsReport 用户和用户组缓存的统计信息
+type CacheStatsReport struct {
+	Node       string     `json:"node"`
+	Users      CacheStats `json:"users"`
+	Usergroups CacheStats `json:"usergroups"`
+}
+
+// changeMessage 在节点之间同步的修改事件
+type changeMessage struct {
+	Topic string `json:"topic"`
+	api.ChangedEvent
+}
+
+// CacheSync 通过 pubsub 将本节点的用户和用户组修改事件发给其它节点, 并将其它节点的事件转发到本节点的 Bus 上
+//
+// 注意使用 NATS 时, 同一个队列组(nats.pubsub.queue_group)中只有一个节点能收到消息, 所以各个节点的队列组不能相同
+type CacheSync struct {
+	logger    log.Logger
+	bus       *moo.Bus
+	publisher pubsub.Publisher
+	topic     string
+	node      string
+}
+
+func defaultNodeID() string {
+	hostname, _ := os.Hostname()
+	return hostname + ":" + strconv.Itoa(os.Getpid())
+}
+
+// NewCacheSync 创建 CacheSync, publisher 为 nil 时只接收其它节点的事件
+func NewCacheSync(env *moo.Environment, bus *moo.Bus, publisher pubsub.Publisher, logger log.Logger) *CacheSync {
+	return &CacheSync{
+		logger:    logger,
+		bus:       bus,
+		publisher: publisher,
+		topic:     env.Config.StringWithDefault(api.CfgUserCacheSyncTopic, "moo.users.changes"),
+		node:      env.Config.StringWithDefault(api.CfgUserCacheSyncNodeID, defaultNodeID()),
+	}
+}
+
+// Publish 处理本节点 Bus 上的修改事件, 将它发给其它节点, 从其它节点转发来的事件不会再发出
+func (cs *CacheSync) Publish(ctx context.Context, topicName string, value interface{}) {
+	evt, ok := value.(*api.ChangedEvent)
+	if !ok || evt.Node != "" || cs.publisher == nil {
+		return
+	}
+	msg := &changeMessage{Topic: topicName, ChangedEvent: *evt}
+	msg.Node = cs.node
+	if err := cs.publisher.Publish(cs.topic, pubsub.NewMessage(cs.node, msg)); err != nil {
+		cs.logger.Warn("发送用户修改事件失败", log.String("topic", topicName), log.String("action", evt.Action), log.Error(err))
+	}
+}
+
+// Drain 将其它节点的修改事件转发到本节点的 Bus 上, 忽略本节点发出的事件
+func (cs *CacheSync) Drain(ctx context.Context, ch <-chan *pubsub.Message) {
+	for msg := range ch {
+		var evt changeMessage
+		if err := json.Unmarshal(msg.Payload, &evt); err != nil {
+			// 消息格式不对, 重发也没有用
+			msg.Ack()
+			cs.logger.Warn("解析用户修改事件失败", log.Error(err))
+			continue
+		}
+		msg.Ack()
+		if evt.Node == cs.node {
+			continue
+		}
+		if evt.Node == "" {
+			evt.Node = "unknown"
+		}
+		switch evt.Topic {
+		case api.BusUserChanged, api.BusUsergroupChanged:
+		default:
+			cs.logger.Warn("用户修改事件的主题不正确", log.String("topic", evt.Topic))
+			continue
+		}
+
+		if err := cs.bus.Emit(ctx, evt.Topic, &evt.ChangedEvent); err != nil {
+			cs.logger.Warn("转发用户修改事件失败", log.String("topic", evt.Topic), log.Error(err))
+		}
+	}
+}
+
+// Refresh 清空本节点和其它节点的用户和用户组缓存
+func (cs *CacheSync) Refresh(ctx context.Context) error {
+	for _, topicName := range []string{api.BusUserChanged, api.BusUsergroupChanged} {
+		if err := cs.bus.Emit(ctx, topicName, &api.ChangedEvent{Action: api.ChangeRefresh}); err != nil {
+			return errors.Wrap(err, "刷新用户缓存失败")
+		}
+	}
+	return nil
+}
+
+type InCacheSync struct {
+	moo.In
+
+	Publisher  pubsub.Publisher  `optional:"true"`
+	Subscriber pubsub.Subscriber `optional:"true"`
+}
+
+func checkManageUserCache(ctx context.Context) error {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionManageUserCache)
+	if err != nil {
+		return errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return errors.NewError(http.StatusForbidden, "没有查看和刷新用户缓存的权限")
+	}
+	return nil
+}
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Invoke(func(env *moo.Environment, lifecycle moo.Lifecycle, bus *moo.Bus, userManager api.UserManager, usergroupManager api.UsergroupManager,
+			in InCacheSync, httpSrv *moo.HTTPServer, logger log.Logger) error {
+			logger = logger.Named("users.cache_sync")
+
+			// 确保在 userservices.Service 没有初始化时也能注册处理函数
+			bus.RegisterTopics(api.BusUserChanged, api.BusUsergroupChanged)
+
+			publisher := in.Publisher
+			subscriber := in.Subscriber
+			if env.Config.BoolWithDefault(api.CfgUserCacheSyncDisabled, false) {
+				publisher = nil
+				subscriber = nil
+			}
+			cs := NewCacheSync(env, bus, publisher, logger)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if subscriber != nil {
+				ch, err := subscriber.Subscribe(ctx, cs.topic)
+				if err != nil {
+					cancel()
+					return errors.Wrap(err, "订阅用户修改事件失败")
+				}
+				go cs.Drain(ctx, ch)
+			} else {
+				logger.Info("没有启用 pubsub, 用户和用户组的修改事件不会在节点之间同步")
+			}
+
+			um, _ := userManager.(*UserManager)
+			groupCache, _ := usergroupManager.(*UsergroupCache)
+			lifecycle.Append(moo.Hook{
+				OnStart: func(context.Context) error {
+					if um != nil {
+						bus.Register("users.usercache", &moo.BusHandler{
+							Matcher: api.BusUserChanged,
+							Handle:  um.userCache.OnChanged,
+						})
+					}
+					if groupCache != nil {
+						bus.Register("users.usergroupcache", &moo.BusHandler{
+							Matcher: api.BusUsergroupChanged,
+							Handle:  groupCache.OnChanged,
+						})
+					}
+					if publisher != nil {
+						bus.Register("users.cache_sync", &moo.BusHandler{
+							Matcher: "^(" + regexp.QuoteMeta(api.BusUserChanged) + "|" + regexp.QuoteMeta(api.BusUsergroupChanged) + ")$",
+							Handle:  cs.Publish,
+						})
+					}
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					bus.Unregister("users.usercache")
+					bus.Unregister("users.usergroupcache")
+					bus.Unregister("users.cache_sync")
+					cancel()
+					return nil
+				},
+			})
+
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.GET("/cache/stats", loong.WrapContextHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				if err := checkManageUserCache(ctx); err != nil {
+					authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+					return
+				}
+				report := &CacheStatsReport{Node: cs.node}
+				if um != nil {
+					report.Users = um.CacheStats()
+				}
+				if groupCache != nil {
+					report.Usergroups = groupCache.Stats()
+				}
+				authn.ReturnJSON(w, r, report, http.StatusOK)
+			}))
+			mux.POST("/cache/refresh", loong.WrapContextHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				if err := checkManageUserCache(ctx); err != nil {
+					authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+					return
+				}
+				if err := cs.Refresh(ctx); err != nil {
+					authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+					return
+				}
+				authn.ReturnJSON(w, r, "OK", http.StatusOK)
+			}))
+			return nil
+		})
+	})
+}
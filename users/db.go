@@ -15,6 +15,7 @@ import (
 	"github.com/runner-mei/moo/authn"
 	"github.com/runner-mei/moo/authn/services"
 	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/bcrypto"
 	userservices "github.com/runner-mei/moo/users/services"
 	"github.com/runner-mei/moo/users/usermodels"
 )
@@ -68,6 +69,23 @@ func (um *UserManager) Create(ctx context.Context, name, nickname, source, passw
 	return id, nil
 }
 
+var _ authn.PasswordChanger = &UserManager{}
+
+// ChangePassword 用户登录时修改自已的密码
+func (um *UserManager) ChangePassword(ctx context.Context, userID interface{}, username, newPassword string) error {
+	id := as.Int64WithDefault(userID, 0)
+	if id == 0 {
+		return errors.New("用户 '" + username + "' 的 id 不正确")
+	}
+	currentUser, err := um.UserByID(ctx, id, api.UserIncludeDisabled())
+	if err != nil {
+		return err
+	}
+
+	reqCtx := um.Service.NewContext(ctx, currentUser, "")
+	return um.Service.UpdateUserPassword(reqCtx, id, newPassword)
+}
+
 func (um *UserManager) Read(ctx *services.AuthContext) (interface{}, services.User, error) {
 	var user = &userInfo{
 		um:   um,
@@ -96,6 +114,7 @@ func (um *UserManager) Lock(ctx *services.AuthContext) error {
 
 var _ services.User = &userInfo{}
 var _ services.Authenticator = &userInfo{}
+var _ services.HasPasswordState = &userInfo{}
 
 type userInfo struct {
 	um   *UserManager
@@ -138,6 +157,18 @@ func (u *userInfo) Auth(ctx *services.AuthContext) (bool, error) {
 		}
 		return true, err
 	}
+
+	// 密码是用旧的或比较弱的算法加密的, 登录成功时用当前的算法重新加密它
+	if u.um.rehashPassword && u.um.Users.PasswordNeedsRehash(u.user.Password) {
+		newPassword, err := u.um.Users.RehashUserPassword(ctx.Ctx, u.user.ID, ctx.Request.Password)
+		if err != nil {
+			u.um.logger.Warn("重新加密用户密码失败", log.String("username", u.user.Name), log.Error(err))
+		} else {
+			u.um.logger.Info("用户密码已重新加密", log.String("username", u.user.Name),
+				log.String("algorithm", bcrypto.AlgorithmOf(u.user.Password)))
+			u.user.Password = newPassword
+		}
+	}
 	return true, nil
 }
 
@@ -155,6 +186,10 @@ func (u *userInfo) IsLocked() bool {
 	return false
 }
 
+func (u *userInfo) PasswordState(now time.Time) (mustChange bool, expiresAt time.Time, warn bool) {
+	return u.um.Service.PasswordPolicy.PasswordState(u.user, now)
+}
+
 func (u *userInfo) Source() string {
 	return u.user.Source
 }
@@ -215,4 +250,21 @@ func init() {
 			return um, um, err
 		})
 	})
+
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Provide(func(env *moo.Environment, userManager api.UserManager, userSvc *userservices.Service) (api.UsergroupManager, error) {
+			return NewUsergroupManager(env, userManager, userSvc.Usergroups)
+		})
+	})
+
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Provide(func(env *moo.Environment) (userservices.PasswordComparer, error) {
+			verify, err := authn.CreateVerify(env.Config.StringWithDefault(api.CfgUserSigningMethod, api.CfgUserSigningMethodDefault),
+				[]byte(env.Config.StringWithDefault(api.CfgUserSigningSecretKey, "")))
+			if err != nil {
+				return nil, errors.Wrap(err, "初始化密码比较器失败")
+			}
+			return userservices.PasswordComparer(verify), nil
+		})
+	})
 }
@@ -35,9 +35,16 @@ func IsUsergroupDisabled(err error) bool {
 	return ok
 }
 
+// usergroupTree 用户组的上下级关系
+type usergroupTree interface {
+	// childIDs 返回直接下级用户组的 ID, 按顺序排列
+	childIDs(ctx context.Context, id int64) ([]int64, error)
+}
+
 type usergroup struct {
 	userManager      api.UserManager
 	usergroupManager api.UsergroupManager
+	tree             usergroupTree
 	ug               usermodels.Usergroup
 	userids          []int64
 }
@@ -96,8 +103,171 @@ func (ug *usergroup) Parent(ctx context.Context) Usergroup {
 	return parent
 }
 
+// 所有的上级用户组, 从父用户组开始一直到顶级用户组, 禁用的上级用户组也会返回
+func (ug *usergroup) Ancestors(ctx context.Context) ([]Usergroup, error) {
+	var list []Usergroup
+	seen := map[int64]bool{ug.ug.ID: true}
+	for id := ug.ug.ParentID; id != 0 && !seen[id]; {
+		seen[id] = true
+		parent, err := ug.usergroupManager.UsergroupByID(ctx, id, api.UsergroupIncludeDisabled())
+		if err != nil {
+			if errors.IsNotFound(err) {
+				break
+			}
+			return nil, err
+		}
+		list = append(list, parent)
+		id = parent.ParentID()
+	}
+	return list, nil
+}
+
+func (ug *usergroup) children(ctx context.Context, id int64) ([]Usergroup, error) {
+	if ug.tree == nil {
+		return nil, nil
+	}
+	ids, err := ug.tree.childIDs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Usergroup, 0, len(ids))
+	for _, childID := range ids {
+		child, err := ug.usergroupManager.UsergroupByID(ctx, childID, api.UsergroupIncludeDisabled())
+		if err != nil {
+			// 已被删除的用户组
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		list = append(list, child)
+	}
+	return list, nil
+}
+
+func isDisabledUsergroup(group Usergroup) bool {
+	ug, ok := group.(*usergroup)
+	return ok && ug.IsDisabled()
+}
+
+// 直接下级用户组, 按顺序排列
+func (ug *usergroup) Children(ctx context.Context, opts ...Option) ([]Usergroup, error) {
+	list, err := ug.children(ctx, ug.ug.ID)
+	if err != nil {
+		return nil, err
+	}
+	if api.InternalApply(opts...).GroupIncludeDisabled {
+		return list, nil
+	}
+	enabled := list[:0]
+	for _, child := range list {
+		if !isDisabledUsergroup(child) {
+			enabled = append(enabled, child)
+		}
+	}
+	return enabled, nil
+}
+
+// 所有的下级用户组, 按层次先序排列, 禁用的用户组不返回时它的下级用户组仍会返回
+func (ug *usergroup) Descendants(ctx context.Context, opts ...Option) ([]Usergroup, error) {
+	includeDisabled := api.InternalApply(opts...).GroupIncludeDisabled
+
+	var list []Usergroup
+	seen := map[int64]bool{ug.ug.ID: true}
+	var walk func(id int64) error
+	walk = func(id int64) error {
+		children, err := ug.children(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if seen[child.ID()] {
+				continue
+			}
+			seen[child.ID()] = true
+			if includeDisabled || !isDisabledUsergroup(child) {
+				list = append(list, child)
+			}
+			if err := walk(child.ID()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(ug.ug.ID); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (ug *usergroup) userIDsRecursive(ctx context.Context) ([]int64, error) {
+	descendants, err := ug.Descendants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{}
+	var userIDs []int64
+	add := func(ids []int64) {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				userIDs = append(userIDs, id)
+			}
+		}
+	}
+	add(ug.userids)
+	for _, group := range descendants {
+		if child, ok := group.(*usergroup); ok {
+			add(child.userids)
+		}
+	}
+	return userIDs, nil
+}
+
+func (ug *usergroup) UsersRecursive(ctx context.Context, opts ...Option) ([]User, error) {
+	userIDs, err := ug.userIDsRecursive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var list = make([]User, 0, len(userIDs))
+	for _, userID := range userIDs {
+		u, err := ug.userManager.UserByID(ctx, userID, opts...)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+func (ug *usergroup) HasUserIDRecursive(ctx context.Context, userID int64) bool {
+	if ug.HasUserID(ctx, userID) {
+		return true
+	}
+	descendants, err := ug.Descendants(ctx)
+	if err != nil {
+		return false
+	}
+	for _, group := range descendants {
+		if group.HasUserID(ctx, userID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ug *usergroup) HasUserRecursive(ctx context.Context, user User) bool {
+	return ug.HasUserIDRecursive(ctx, user.ID())
+}
+
 func NewUsergroupManager(env *moo.Environment, userManager api.UserManager, queryer usermodels.UsergroupQueryer) (api.UsergroupManager, error) {
 	return &UsergroupCache{
+		UsergroupCacheBase: UsergroupCacheBase{
+			defaultExpiration: DefaultTimeout,
+			items:             map[int64]usergroupCacheItem{},
+			counters:          &cacheCounters{},
+		},
 		userManager: userManager,
 		queryer:     queryer,
 		name2id:     map[string]int64{},
@@ -0,0 +1,440 @@
+// Please don't edit this file!
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/runner-mei/moo/api"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/resty"
+)
+
+// UserRequest is skipped
+// PasswordRequest is skipped
+// MemberRequest is skipped
+
+type UsersClient struct {
+	Proxy *resty.Proxy
+}
+
+func (client UsersClient) Count(ctx context.Context, nameLike string, enabled sql.NullBool, canLogin sql.NullBool, source string, roles []int64, usergroups []int64, usergroupRecursive bool, attributes []string) (int64, error) {
+	var result int64
+
+	request := resty.NewRequest(client.Proxy, "/count").
+		SetParam("name_like", nameLike)
+	if enabled.Valid {
+		request = request.SetParam("enabled", api.BoolToString(enabled.Bool))
+	}
+	if canLogin.Valid {
+		request = request.SetParam("can_login", api.BoolToString(canLogin.Bool))
+	}
+	request = request.SetParam("source", source)
+	for idx := range roles {
+		request = request.AddParam("roles", strconv.FormatInt(roles[idx], 10))
+	}
+	for idx := range usergroups {
+		request = request.AddParam("usergroups", strconv.FormatInt(usergroups[idx], 10))
+	}
+	request = request.SetParam("usergroup_recursive", api.BoolToString(usergroupRecursive))
+	for idx := range attributes {
+		request = request.AddParam("attributes", attributes[idx])
+	}
+	request = request.
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsersClient) List(ctx context.Context, nameLike string, enabled sql.NullBool, canLogin sql.NullBool, source string, roles []int64, usergroups []int64, usergroupRecursive bool, attributes []string, offset int64, limit int64, sortBy string) ([]usermodels.User, error) {
+	var result []usermodels.User
+
+	request := resty.NewRequest(client.Proxy, "/").
+		SetParam("name_like", nameLike)
+	if enabled.Valid {
+		request = request.SetParam("enabled", api.BoolToString(enabled.Bool))
+	}
+	if canLogin.Valid {
+		request = request.SetParam("can_login", api.BoolToString(canLogin.Bool))
+	}
+	request = request.SetParam("source", source)
+	for idx := range roles {
+		request = request.AddParam("roles", strconv.FormatInt(roles[idx], 10))
+	}
+	for idx := range usergroups {
+		request = request.AddParam("usergroups", strconv.FormatInt(usergroups[idx], 10))
+	}
+	request = request.SetParam("usergroup_recursive", api.BoolToString(usergroupRecursive))
+	for idx := range attributes {
+		request = request.AddParam("attributes", attributes[idx])
+	}
+	request = request.
+		SetParam("offset", strconv.FormatInt(offset, 10)).
+		SetParam("limit", strconv.FormatInt(limit, 10)).
+		SetParam("sort_by", sortBy).
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsersClient) Fields(ctx context.Context) ([]userservices.Fields, error) {
+	var result []userservices.Fields
+
+	request := resty.NewRequest(client.Proxy, "/fields").
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsersClient) FieldSchema(ctx context.Context) (*userservices.FieldSchema, error) {
+	var result userservices.FieldSchema
+
+	request := resty.NewRequest(client.Proxy, "/fields/schema").
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client UsersClient) FieldVersions(ctx context.Context) ([]usermodels.UserFieldSchema, error) {
+	var result []usermodels.UserFieldSchema
+
+	request := resty.NewRequest(client.Proxy, "/fields/versions").
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsersClient) Get(ctx context.Context, id int64) (*usermodels.User, error) {
+	var result usermodels.User
+
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client UsersClient) Create(ctx context.Context, user *UserRequest) (int64, error) {
+	var result int64
+
+	request := resty.NewRequest(client.Proxy, "/").
+		SetBody(user).
+		Result(&result)
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsersClient) Update(ctx context.Context, id int64, user *UserRequest) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		SetBody(user)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsersClient) UpdateRoles(ctx context.Context, id int64, roles []int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/roles").
+		SetBody(roles)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsersClient) UpdatePassword(ctx context.Context, id int64, password *PasswordRequest) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/password").
+		SetBody(password)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsersClient) Enable(ctx context.Context, id int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/enable")
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsersClient) Disable(ctx context.Context, id int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/disable")
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsersClient) Delete(ctx context.Context, id int64, notDelete bool) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		SetParam("not_delete", api.BoolToString(notDelete))
+
+	err := request.DELETE(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsersClient) Recovery(ctx context.Context, id int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/recovery")
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+type UsergroupsClient struct {
+	Proxy *resty.Proxy
+}
+
+func (client UsergroupsClient) List(ctx context.Context, userID sql.NullInt64) ([]usermodels.Usergroup, error) {
+	var result []usermodels.Usergroup
+
+	request := resty.NewRequest(client.Proxy, "/")
+	if userID.Valid {
+		request = request.SetParam("user_id", strconv.FormatInt(userID.Int64, 10))
+	}
+	request = request.Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsergroupsClient) Tree(ctx context.Context) ([]*userservices.UsergroupNode, error) {
+	var result []*userservices.UsergroupNode
+
+	request := resty.NewRequest(client.Proxy, "/tree").
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsergroupsClient) Get(ctx context.Context, id int64) (*usermodels.Usergroup, error) {
+	var result usermodels.Usergroup
+
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client UsergroupsClient) Create(ctx context.Context, group *usermodels.Usergroup) (int64, error) {
+	var result int64
+
+	request := resty.NewRequest(client.Proxy, "/").
+		SetBody(group).
+		Result(&result)
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsergroupsClient) Update(ctx context.Context, id int64, group *usermodels.Usergroup) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		SetBody(group)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) Delete(ctx context.Context, id int64, recursive bool) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		SetParam("recursive", api.BoolToString(recursive))
+
+	err := request.DELETE(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) Move(ctx context.Context, id int64, parentID int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/parent").
+		SetParam("parent_id", strconv.FormatInt(parentID, 10))
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) Merge(ctx context.Context, id int64, target int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/merge").
+		SetParam("target", strconv.FormatInt(target, 10))
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) Sort(ctx context.Context, parentID int64, ids []int64) error {
+	request := resty.NewRequest(client.Proxy, "/sort").
+		SetParam("parent_id", strconv.FormatInt(parentID, 10)).
+		SetBody(ids)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) Users(ctx context.Context, id int64, recursive bool, enabled sql.NullBool) ([]usermodels.User, error) {
+	var result []usermodels.User
+
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/users").
+		SetParam("recursive", api.BoolToString(recursive))
+	if enabled.Valid {
+		request = request.SetParam("enabled", api.BoolToString(enabled.Bool))
+	}
+	request = request.Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsergroupsClient) AddUser(ctx context.Context, id int64, member *MemberRequest) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/users").
+		SetBody(member)
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) RemoveUser(ctx context.Context, id int64, userID int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/users/"+strconv.FormatInt(userID, 10))
+
+	err := request.DELETE(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client UsergroupsClient) DefaultRoles(ctx context.Context, id int64) ([]usermodels.Role, error) {
+	var result []usermodels.Role
+
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/roles").
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client UsergroupsClient) UpdateDefaultRoles(ctx context.Context, id int64, roles []int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/roles").
+		SetBody(roles)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+type RolesClient struct {
+	Proxy *resty.Proxy
+}
+
+func (client RolesClient) List(ctx context.Context, nameLike string, offset int64, limit int64) ([]usermodels.Role, error) {
+	var result []usermodels.Role
+
+	request := resty.NewRequest(client.Proxy, "/").
+		SetParam("name_like", nameLike).
+		SetParam("offset", strconv.FormatInt(offset, 10)).
+		SetParam("limit", strconv.FormatInt(limit, 10)).
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client RolesClient) Get(ctx context.Context, id int64) (*usermodels.Role, error) {
+	var result usermodels.Role
+
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		Result(&result)
+
+	err := request.GET(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client RolesClient) Create(ctx context.Context, role *usermodels.Role) (int64, error) {
+	var result int64
+
+	request := resty.NewRequest(client.Proxy, "/").
+		SetBody(role).
+		Result(&result)
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return result, err
+}
+
+func (client RolesClient) Update(ctx context.Context, id int64, role *usermodels.Role) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)).
+		SetBody(role)
+
+	err := request.PUT(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client RolesClient) Delete(ctx context.Context, id int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10))
+
+	err := request.DELETE(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client RolesClient) AddUsers(ctx context.Context, id int64, users []int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/users").
+		SetBody(users)
+
+	err := request.POST(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
+
+func (client RolesClient) RemoveUsers(ctx context.Context, id int64, users []int64) error {
+	request := resty.NewRequest(client.Proxy, "/"+strconv.FormatInt(id, 10)+"/users")
+	for idx := range users {
+		request = request.AddParam("users", strconv.FormatInt(users[idx], 10))
+	}
+
+	err := request.DELETE(ctx)
+	resty.ReleaseRequest(client.Proxy, request)
+	return err
+}
@@ -0,0 +1,180 @@
+//go:generate gogen server -pre_init_object=true -ext=.server-gen.go -config=@loong api.go
+//go:generate gogen client -ext=.client-gen.go api.go
+
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/runner-mei/moo/authz"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+const (
+	// PermissionViewUsers 查看用户的权限
+	PermissionViewUsers = "um.users.view"
+	// PermissionManageUsers 管理用户的权限
+	PermissionManageUsers = "um.users.manage"
+	// PermissionViewUsergroups 查看用户组的权限
+	PermissionViewUsergroups = "um.usergroups.view"
+	// PermissionManageUsergroups 管理用户组和用户组成员的权限
+	PermissionManageUsergroups = "um.usergroups.manage"
+	// PermissionViewRoles 查看角色的权限
+	PermissionViewRoles = "um.roles.view"
+	// PermissionManageRoles 管理角色和授于角色的权限
+	PermissionManageRoles = "um.roles.manage"
+	// PermissionImportUsers 批量导入用户的权限
+	PermissionImportUsers = "um.users.import"
+	// PermissionExportUsers 批量导出用户的权限, 没有查看用户的权限时只能导出自己所在用户组(含下级用户组)的用户
+	PermissionExportUsers = "um.users.export"
+)
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionViewUsers, Title: "查看用户", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionManageUsers, Title: "管理用户", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionViewUsergroups, Title: "查看用户组", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionManageUsergroups, Title: "管理用户组", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionViewRoles, Title: "查看角色", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionManageRoles, Title: "管理角色", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionImportUsers, Title: "导入用户", Group: "用户管理"})
+	authz.Register(authz.PermissionMeta{ID: PermissionExportUsers, Title: "导出用户", Group: "用户管理"})
+}
+
+// UserRequest 创建或修改用户的参数
+//
+// 创建用户时 Roles 为用户的角色, 修改用户时只有 UpdateRoles 为 true 才会用 Roles 替换用户的角色
+type UserRequest struct {
+	usermodels.User
+
+	Roles       []int64 `json:"roles,omitempty"`
+	UpdateRoles bool    `json:"update_roles,omitempty"`
+}
+
+// PasswordRequest 修改用户密码的参数
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// MemberRequest 将用户加入用户组的参数, RoleID 为用户在用户组内的角色, 可以为 0
+type MemberRequest struct {
+	UserID int64 `json:"user_id"`
+	RoleID int64 `json:"role_id,omitempty"`
+}
+
+// Users 用户管理的接口, 路径的前缀为 /api/users
+type Users interface {
+	// Count 和 List 的 attributes 为按属性查询的条件, 格式为 key=value(相等) 或 key~value(模糊匹配)
+
+	// @http.GET(path="/count")
+	Count(ctx context.Context, nameLike string, enabled, canLogin sql.NullBool, source string, roles, usergroups []int64, usergroupRecursive bool, attributes []string) (int64, error)
+
+	// @http.GET(path="")
+	List(ctx context.Context, nameLike string, enabled, canLogin sql.NullBool, source string, roles, usergroups []int64, usergroupRecursive bool, attributes []string, offset, limit int64, sortBy string) ([]usermodels.User, error)
+
+	// @http.GET(path="/fields")
+	Fields(ctx context.Context) ([]userservices.Fields, error)
+
+	// @http.GET(path="/fields/schema")
+	FieldSchema(ctx context.Context) (*userservices.FieldSchema, error)
+
+	// @http.GET(path="/fields/versions")
+	FieldVersions(ctx context.Context) ([]usermodels.UserFieldSchema, error)
+
+	// @http.GET(path="/:id")
+	Get(ctx context.Context, id int64) (*usermodels.User, error)
+
+	// @http.POST(path="", data="user")
+	Create(ctx context.Context, user *UserRequest) (int64, error)
+
+	// @http.PUT(path="/:id", data="user")
+	Update(ctx context.Context, id int64, user *UserRequest) error
+
+	// @http.PUT(path="/:id/roles", data="roles")
+	UpdateRoles(ctx context.Context, id int64, roles []int64) error
+
+	// @http.PUT(path="/:id/password", data="password")
+	UpdatePassword(ctx context.Context, id int64, password *PasswordRequest) error
+
+	// @http.POST(path="/:id/enable")
+	Enable(ctx context.Context, id int64) error
+
+	// @http.POST(path="/:id/disable")
+	Disable(ctx context.Context, id int64) error
+
+	// @http.DELETE(path="/:id")
+	Delete(ctx context.Context, id int64, notDelete bool) error
+
+	// @http.POST(path="/:id/recovery")
+	Recovery(ctx context.Context, id int64) error
+}
+
+// Usergroups 用户组管理的接口, 路径的前缀为 /api/usergroups
+type Usergroups interface {
+	// @http.GET(path="")
+	List(ctx context.Context, userID sql.NullInt64) ([]usermodels.Usergroup, error)
+
+	// @http.GET(path="/tree")
+	Tree(ctx context.Context) ([]*userservices.UsergroupNode, error)
+
+	// @http.GET(path="/:id")
+	Get(ctx context.Context, id int64) (*usermodels.Usergroup, error)
+
+	// @http.POST(path="", data="group")
+	Create(ctx context.Context, group *usermodels.Usergroup) (int64, error)
+
+	// @http.PUT(path="/:id", data="group")
+	Update(ctx context.Context, id int64, group *usermodels.Usergroup) error
+
+	// @http.DELETE(path="/:id")
+	Delete(ctx context.Context, id int64, recursive bool) error
+
+	// @http.PUT(path="/:id/parent")
+	Move(ctx context.Context, id int64, parentID int64) error
+
+	// @http.POST(path="/:id/merge")
+	Merge(ctx context.Context, id int64, target int64) error
+
+	// @http.PUT(path="/sort", data="ids")
+	Sort(ctx context.Context, parentID int64, ids []int64) error
+
+	// @http.GET(path="/:id/users")
+	Users(ctx context.Context, id int64, recursive bool, enabled sql.NullBool) ([]usermodels.User, error)
+
+	// @http.POST(path="/:id/users", data="member")
+	AddUser(ctx context.Context, id int64, member *MemberRequest) error
+
+	// @http.DELETE(path="/:id/users/:user_id")
+	RemoveUser(ctx context.Context, id, userID int64) error
+
+	// @http.GET(path="/:id/roles")
+	DefaultRoles(ctx context.Context, id int64) ([]usermodels.Role, error)
+
+	// @http.PUT(path="/:id/roles", data="roles")
+	UpdateDefaultRoles(ctx context.Context, id int64, roles []int64) error
+}
+
+// Roles 角色管理的接口, 路径的前缀为 /api/roles
+type Roles interface {
+	// @http.GET(path="")
+	List(ctx context.Context, nameLike string, offset, limit int64) ([]usermodels.Role, error)
+
+	// @http.GET(path="/:id")
+	Get(ctx context.Context, id int64) (*usermodels.Role, error)
+
+	// @http.POST(path="", data="role")
+	Create(ctx context.Context, role *usermodels.Role) (int64, error)
+
+	// @http.PUT(path="/:id", data="role")
+	Update(ctx context.Context, id int64, role *usermodels.Role) error
+
+	// @http.DELETE(path="/:id")
+	Delete(ctx context.Context, id int64) error
+
+	// @http.POST(path="/:id/users", data="users")
+	AddUsers(ctx context.Context, id int64, users []int64) error
+
+	// @http.DELETE(path="/:id/users")
+	RemoveUsers(ctx context.Context, id int64, users []int64) error
+}
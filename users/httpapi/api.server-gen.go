@@ -0,0 +1,603 @@
+// Please don't edit this file!
+package httpapi
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// UserRequest is skipped
+// PasswordRequest is skipped
+// MemberRequest is skipped
+
+func InitUsers(mux loong.Party, svc Users) {
+	mux.GET("/count", func(ctx *loong.Context) error {
+		var nameLike = ctx.QueryParam("name_like")
+		var enabled sql.NullBool
+		if s := ctx.QueryParam("enabled"); s != "" {
+			enabled.Valid = true
+			enabled.Bool = api.ToBool(s)
+		}
+		var canLogin sql.NullBool
+		if s := ctx.QueryParam("can_login"); s != "" {
+			canLogin.Valid = true
+			canLogin.Bool = api.ToBool(s)
+		}
+		var source = ctx.QueryParam("source")
+		var roles []int64
+		if ss := ctx.QueryParamArray("roles"); len(ss) != 0 {
+			rolesValue, err := api.ToInt64Array(ss)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("roles", ss, err), http.StatusBadRequest)
+			}
+			roles = rolesValue
+		}
+		var usergroups []int64
+		if ss := ctx.QueryParamArray("usergroups"); len(ss) != 0 {
+			usergroupsValue, err := api.ToInt64Array(ss)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("usergroups", ss, err), http.StatusBadRequest)
+			}
+			usergroups = usergroupsValue
+		}
+		var usergroupRecursive bool
+		if s := ctx.QueryParam("usergroup_recursive"); s != "" {
+			usergroupRecursive = api.ToBool(s)
+		}
+		var attributes = ctx.QueryParamArray("attributes")
+
+		result, err := svc.Count(ctx.StdContext, nameLike, enabled, canLogin, source, roles, usergroups, usergroupRecursive, attributes)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("", func(ctx *loong.Context) error {
+		var nameLike = ctx.QueryParam("name_like")
+		var enabled sql.NullBool
+		if s := ctx.QueryParam("enabled"); s != "" {
+			enabled.Valid = true
+			enabled.Bool = api.ToBool(s)
+		}
+		var canLogin sql.NullBool
+		if s := ctx.QueryParam("can_login"); s != "" {
+			canLogin.Valid = true
+			canLogin.Bool = api.ToBool(s)
+		}
+		var source = ctx.QueryParam("source")
+		var roles []int64
+		if ss := ctx.QueryParamArray("roles"); len(ss) != 0 {
+			rolesValue, err := api.ToInt64Array(ss)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("roles", ss, err), http.StatusBadRequest)
+			}
+			roles = rolesValue
+		}
+		var usergroups []int64
+		if ss := ctx.QueryParamArray("usergroups"); len(ss) != 0 {
+			usergroupsValue, err := api.ToInt64Array(ss)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("usergroups", ss, err), http.StatusBadRequest)
+			}
+			usergroups = usergroupsValue
+		}
+		var usergroupRecursive bool
+		if s := ctx.QueryParam("usergroup_recursive"); s != "" {
+			usergroupRecursive = api.ToBool(s)
+		}
+		var attributes = ctx.QueryParamArray("attributes")
+		var offset int64
+		if s := ctx.QueryParam("offset"); s != "" {
+			offsetValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("offset", s, err), http.StatusBadRequest)
+			}
+			offset = offsetValue
+		}
+		var limit int64
+		if s := ctx.QueryParam("limit"); s != "" {
+			limitValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("limit", s, err), http.StatusBadRequest)
+			}
+			limit = limitValue
+		}
+		var sortBy = ctx.QueryParam("sort_by")
+
+		result, err := svc.List(ctx.StdContext, nameLike, enabled, canLogin, source, roles, usergroups, usergroupRecursive, attributes, offset, limit, sortBy)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/fields", func(ctx *loong.Context) error {
+		result, err := svc.Fields(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/fields/schema", func(ctx *loong.Context) error {
+		result, err := svc.FieldSchema(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/fields/versions", func(ctx *loong.Context) error {
+		result, err := svc.FieldVersions(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		result, err := svc.Get(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.POST("", func(ctx *loong.Context) error {
+		var user UserRequest
+		if err := ctx.Bind(&user); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("user", "body", err), http.StatusBadRequest)
+		}
+
+		result, err := svc.Create(ctx.StdContext, &user)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult(result)
+	})
+	mux.PUT("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var user UserRequest
+		if err := ctx.Bind(&user); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("user", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.Update(ctx.StdContext, id, &user)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.PUT("/:id/roles", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var roles []int64
+		if err := ctx.Bind(&roles); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("roles", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.UpdateRoles(ctx.StdContext, id, roles)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.PUT("/:id/password", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var password PasswordRequest
+		if err := ctx.Bind(&password); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("password", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.UpdatePassword(ctx.StdContext, id, &password)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.POST("/:id/enable", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		err = svc.Enable(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.POST("/:id/disable", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		err = svc.Disable(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.DELETE("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var notDelete bool
+		if s := ctx.QueryParam("not_delete"); s != "" {
+			notDelete = api.ToBool(s)
+		}
+
+		err = svc.Delete(ctx.StdContext, id, notDelete)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+	mux.POST("/:id/recovery", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		err = svc.Recovery(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+}
+
+func InitUsergroups(mux loong.Party, svc Usergroups) {
+	mux.GET("", func(ctx *loong.Context) error {
+		var userID sql.NullInt64
+		if s := ctx.QueryParam("user_id"); s != "" {
+			userIDValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("user_id", s, err), http.StatusBadRequest)
+			}
+			userID.Valid = true
+			userID.Int64 = userIDValue
+		}
+
+		result, err := svc.List(ctx.StdContext, userID)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/tree", func(ctx *loong.Context) error {
+		result, err := svc.Tree(ctx.StdContext)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		result, err := svc.Get(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.POST("", func(ctx *loong.Context) error {
+		var group usermodels.Usergroup
+		if err := ctx.Bind(&group); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("group", "body", err), http.StatusBadRequest)
+		}
+
+		result, err := svc.Create(ctx.StdContext, &group)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult(result)
+	})
+	mux.PUT("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var group usermodels.Usergroup
+		if err := ctx.Bind(&group); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("group", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.Update(ctx.StdContext, id, &group)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.DELETE("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var recursive bool
+		if s := ctx.QueryParam("recursive"); s != "" {
+			recursive = api.ToBool(s)
+		}
+
+		err = svc.Delete(ctx.StdContext, id, recursive)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+	mux.PUT("/:id/parent", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var parentID int64
+		if s := ctx.QueryParam("parent_id"); s != "" {
+			parentIDValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("parent_id", s, err), http.StatusBadRequest)
+			}
+			parentID = parentIDValue
+		}
+
+		err = svc.Move(ctx.StdContext, id, parentID)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.POST("/:id/merge", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var target int64
+		if s := ctx.QueryParam("target"); s != "" {
+			targetValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("target", s, err), http.StatusBadRequest)
+			}
+			target = targetValue
+		}
+
+		err = svc.Merge(ctx.StdContext, id, target)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.PUT("/sort", func(ctx *loong.Context) error {
+		var parentID int64
+		if s := ctx.QueryParam("parent_id"); s != "" {
+			parentIDValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("parent_id", s, err), http.StatusBadRequest)
+			}
+			parentID = parentIDValue
+		}
+		var ids []int64
+		if err := ctx.Bind(&ids); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("ids", "body", err), http.StatusBadRequest)
+		}
+
+		err := svc.Sort(ctx.StdContext, parentID, ids)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.GET("/:id/users", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var recursive bool
+		if s := ctx.QueryParam("recursive"); s != "" {
+			recursive = api.ToBool(s)
+		}
+		var enabled sql.NullBool
+		if s := ctx.QueryParam("enabled"); s != "" {
+			enabled.Valid = true
+			enabled.Bool = api.ToBool(s)
+		}
+
+		result, err := svc.Users(ctx.StdContext, id, recursive, enabled)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.POST("/:id/users", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var member MemberRequest
+		if err := ctx.Bind(&member); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("member", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.AddUser(ctx.StdContext, id, &member)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult("OK")
+	})
+	mux.DELETE("/:id/users/:user_id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		userID, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("user_id", ctx.Param("user_id"), err), http.StatusBadRequest)
+		}
+
+		err = svc.RemoveUser(ctx.StdContext, id, userID)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+	mux.GET("/:id/roles", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		result, err := svc.DefaultRoles(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.PUT("/:id/roles", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var roles []int64
+		if err := ctx.Bind(&roles); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("roles", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.UpdateDefaultRoles(ctx.StdContext, id, roles)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+}
+
+func InitRoles(mux loong.Party, svc Roles) {
+	mux.GET("", func(ctx *loong.Context) error {
+		var nameLike = ctx.QueryParam("name_like")
+		var offset int64
+		if s := ctx.QueryParam("offset"); s != "" {
+			offsetValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("offset", s, err), http.StatusBadRequest)
+			}
+			offset = offsetValue
+		}
+		var limit int64
+		if s := ctx.QueryParam("limit"); s != "" {
+			limitValue, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("limit", s, err), http.StatusBadRequest)
+			}
+			limit = limitValue
+		}
+
+		result, err := svc.List(ctx.StdContext, nameLike, offset, limit)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.GET("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		result, err := svc.Get(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnQueryResult(result)
+	})
+	mux.POST("", func(ctx *loong.Context) error {
+		var role usermodels.Role
+		if err := ctx.Bind(&role); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("role", "body", err), http.StatusBadRequest)
+		}
+
+		result, err := svc.Create(ctx.StdContext, &role)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult(result)
+	})
+	mux.PUT("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var role usermodels.Role
+		if err := ctx.Bind(&role); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("role", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.Update(ctx.StdContext, id, &role)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnUpdatedResult("OK")
+	})
+	mux.DELETE("/:id", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+
+		err = svc.Delete(ctx.StdContext, id)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+	mux.POST("/:id/users", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var users []int64
+		if err := ctx.Bind(&users); err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("users", "body", err), http.StatusBadRequest)
+		}
+
+		err = svc.AddUsers(ctx.StdContext, id, users)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnCreatedResult("OK")
+	})
+	mux.DELETE("/:id/users", func(ctx *loong.Context) error {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			return ctx.ReturnError(loong.ErrBadArgument("id", ctx.Param("id"), err), http.StatusBadRequest)
+		}
+		var users []int64
+		if ss := ctx.QueryParamArray("users"); len(ss) != 0 {
+			usersValue, err := api.ToInt64Array(ss)
+			if err != nil {
+				return ctx.ReturnError(loong.ErrBadArgument("users", ss, err), http.StatusBadRequest)
+			}
+			users = usersValue
+		}
+
+		err = svc.RemoveUsers(ctx.StdContext, id, users)
+		if err != nil {
+			return ctx.ReturnError(err)
+		}
+		return ctx.ReturnDeletedResult("OK")
+	})
+}
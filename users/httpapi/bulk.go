@@ -0,0 +1,274 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/users/bulk"
+	userservices "github.com/runner-mei/moo/users/services"
+)
+
+// maxImportFileSize 导入文件的最大字节数
+const maxImportFileSize = 10 * 1024 * 1024
+
+type bulkHandlers struct {
+	*server
+	bulk    *bulk.Service
+	reports *bulk.Reports
+}
+
+func formBool(r *http.Request, name string) (bool, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.NewError(http.StatusBadRequest, name+" '"+s+"' is invalid")
+	}
+	return b, nil
+}
+
+func readImportOptions(r *http.Request, filename string) (*bulk.Options, error) {
+	opts := &bulk.Options{
+		Format:        bulk.DetectFormat(r.FormValue("format"), filename, r.Header.Get("Content-Type")),
+		Separator:     r.FormValue("separator"),
+		PathSeparator: r.FormValue("path_separator"),
+	}
+	if opts.Format == "" {
+		return nil, errors.NewError(http.StatusBadRequest, "无法识别文件格式, 请用参数 format 指定为 csv, xlsx 或 json")
+	}
+
+	var err error
+	opts.DryRun, err = formBool(r, "dry_run")
+	if err != nil {
+		return nil, err
+	}
+	opts.Transaction, err = formBool(r, "transaction")
+	if err != nil {
+		return nil, err
+	}
+	if s := r.FormValue("mapping"); s != "" {
+		if err := json.Unmarshal([]byte(s), &opts.Mapping); err != nil {
+			return nil, errors.NewError(http.StatusBadRequest, "mapping '"+s+"' is invalid")
+		}
+	}
+	return opts, nil
+}
+
+// readImportFile 读取上传的文件, 可以是 multipart 表单中的 file 字段, 也可以直接是请求的 body
+func readImportFile(r *http.Request) ([]byte, string, error) {
+	if r.MultipartForm != nil {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", errors.NewError(http.StatusBadRequest, "缺少上传的文件 'file'")
+		}
+		defer file.Close()
+		data, err := bulk.ReadAll(file, maxImportFileSize)
+		if err != nil {
+			return nil, "", errors.WithHTTPCode(err, http.StatusBadRequest)
+		}
+		return data, header.Filename, nil
+	}
+	data, err := bulk.ReadAll(r.Body, maxImportFileSize)
+	if err != nil {
+		return nil, "", errors.WithHTTPCode(err, http.StatusBadRequest)
+	}
+	return data, "", nil
+}
+
+// Import 批量导入用户, 参数 dry_run 为 true 时只检查不导入, transaction 为 true 时有任何一行失败都不导入
+func (h *bulkHandlers) Import(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	reqCtx, err := h.newContext(ctx, PermissionImportUsers, PermissionManageUsers)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxImportFileSize); err != nil {
+			authn.ReturnError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	data, filename, err := readImportFile(r)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	opts, err := readImportOptions(r, filename)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	report, err := h.bulk.Import(reqCtx, data, opts)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	h.reports.Add(report)
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
+
+func writeTable(w http.ResponseWriter, format, filename string, table *bulk.Table) {
+	w.Header().Set("Content-Type", bulk.ContentType(format))
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"."+format+"\"")
+	w.WriteHeader(http.StatusOK)
+	if err := bulk.WriteTable(w, format, table); err != nil {
+		// 头已经写出了, 只能中断输出
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func exportFormat(r *http.Request) (string, error) {
+	s := r.URL.Query().Get("format")
+	if s == "" {
+		return bulk.FormatCSV, nil
+	}
+	format := bulk.DetectFormat(s, "", "")
+	if format == "" {
+		return "", errors.NewError(http.StatusBadRequest, "format '"+s+"' is invalid")
+	}
+	return format, nil
+}
+
+// ImportErrors 下载导入失败的行和错误原因, 只有执行导入的用户才能下载
+func (h *bulkHandlers) ImportErrors(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	reqCtx, err := h.newContext(ctx, PermissionImportUsers, PermissionManageUsers)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	report := h.reports.Get(r.URL.Query().Get("id"))
+	if report == nil || report.UserID != reqCtx.CurrentUser.ID() {
+		authn.ReturnError(w, r, "导入结果不存在或已过期", http.StatusNotFound)
+		return
+	}
+
+	format := report.Format
+	if s := r.URL.Query().Get("format"); s != "" {
+		format, err = exportFormat(r)
+		if err != nil {
+			authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+			return
+		}
+	}
+	writeTable(w, format, "import_errors_"+report.ID, report.ErrorTable())
+}
+
+// exportScope 返回当前用户可以导出的用户组, 返回 nil 表示不受限制
+func (h *bulkHandlers) exportScope(reqCtx *userservices.RequestContext) (map[int64]bool, error) {
+	ok, err := reqCtx.CurrentUser.HasPermissionAny(reqCtx.Ctx, []string{PermissionViewUsers, PermissionManageUsers})
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if ok {
+		return nil, nil
+	}
+
+	own, err := h.svc.GetUsergroups(reqCtx, sql.NullInt64{Valid: true, Int64: reqCtx.CurrentUser.ID()})
+	if err != nil {
+		return nil, err
+	}
+	all, err := h.svc.GetUsergroups(reqCtx, sql.NullInt64{})
+	if err != nil {
+		return nil, err
+	}
+
+	scope := map[int64]bool{}
+	for idx := range own {
+		scope[own[idx].ID] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for idx := range all {
+			if !scope[all[idx].ID] && scope[all[idx].ParentID] {
+				scope[all[idx].ID] = true
+				changed = true
+			}
+		}
+	}
+	return scope, nil
+}
+
+// Export 导出用户, 查询参数和用户列表的相同, format 为 csv(默认), xlsx 或 json
+func (h *bulkHandlers) Export(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	reqCtx, err := h.newContext(ctx, PermissionExportUsers, PermissionManageUsers)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	format, err := exportFormat(r)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	queryParams := r.URL.Query()
+	var enabled, canLogin sql.NullBool
+	if s := queryParams.Get("enabled"); s != "" {
+		enabled = sql.NullBool{Valid: true, Bool: api.ToBool(s)}
+	}
+	if s := queryParams.Get("can_login"); s != "" {
+		canLogin = sql.NullBool{Valid: true, Bool: api.ToBool(s)}
+	}
+	roles, err := api.ToInt64Array(queryParams["roles"])
+	if err != nil {
+		authn.ReturnError(w, r, "roles '"+queryParams.Get("roles")+"' is invalid", http.StatusBadRequest)
+		return
+	}
+	usergroups, err := api.ToInt64Array(queryParams["usergroups"])
+	if err != nil {
+		authn.ReturnError(w, r, "usergroups '"+queryParams.Get("usergroups")+"' is invalid", http.StatusBadRequest)
+		return
+	}
+	query := toUserQueryParams(queryParams.Get("name_like"), enabled, canLogin, queryParams.Get("source"),
+		roles, usergroups, api.ToBool(queryParams.Get("usergroup_recursive")))
+	query.Attributes, err = h.parseAttributeQueries(ctx, queryParams["attributes"])
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	scope, err := h.exportScope(reqCtx)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	if scope != nil {
+		if len(scope) == 0 {
+			authn.ReturnError(w, r, ErrPermissionDenny.Error(), http.StatusForbidden)
+			return
+		}
+		if len(query.UsergroupIDs) == 0 {
+			for id := range scope {
+				query.UsergroupIDs = append(query.UsergroupIDs, id)
+			}
+		} else {
+			for _, id := range query.UsergroupIDs {
+				if !scope[id] {
+					authn.ReturnError(w, r, "没有导出用户组 '"+strconv.FormatInt(id, 10)+"' 的权限", http.StatusForbidden)
+					return
+				}
+			}
+		}
+		// scope 已经包含了所有的下级用户组, 这里递归是为了包含 usergroups 参数指定的用户组的下级
+		query.UsergroupRecursive = true
+	}
+
+	table, err := h.bulk.Export(reqCtx, query)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	writeTable(w, format, "users_"+time.Now().Format("20060102150405"), table)
+}
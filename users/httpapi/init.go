@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/bulk"
+	userservices "github.com/runner-mei/moo/users/services"
+)
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserHTTPAPIDisabled, false) {
+			return moo.None
+		}
+		return moo.Invoke(func(env *moo.Environment, svc *userservices.Service, httpSrv *moo.HTTPServer, logger log.Logger) {
+			srv := &server{env: env, svc: svc}
+
+			InitUsers(httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares()), &userServer{srv})
+			InitUsergroups(httpSrv.Engine().Group("api/usergroups", httpSrv.AuthMiddlewares()), &usergroupServer{srv})
+			InitRoles(httpSrv.Engine().Group("api/roles", httpSrv.AuthMiddlewares()), &roleServer{srv})
+
+			h := &bulkHandlers{
+				server:  srv,
+				bulk:    &bulk.Service{Env: env, Users: svc},
+				reports: bulk.NewReports(50),
+			}
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.POST("/import", loong.WrapContextHandler(h.Import))
+			mux.GET("/import/errors", loong.WrapContextHandler(h.ImportErrors))
+			mux.GET("/export", loong.WrapContextHandler(h.Export))
+			logger.Info("user management api started")
+		})
+	})
+}
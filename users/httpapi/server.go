@@ -0,0 +1,430 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+var ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有执行该操作的权限")
+
+var (
+	_ Users      = &userServer{}
+	_ Usergroups = &usergroupServer{}
+	_ Roles      = &roleServer{}
+)
+
+type server struct {
+	env *moo.Environment
+	svc *userservices.Service
+}
+
+// newContext 检查当前用户是否有任意一个权限, 并创建服务的请求上下文
+func (srv *server) newContext(ctx context.Context, permissions ...string) (*userservices.RequestContext, error) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermissionAny(ctx, permissions)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, ErrPermissionDenny
+	}
+	return srv.svc.NewContext(ctx, currentUser, ""), nil
+}
+
+func clearPasswords(users []usermodels.User) {
+	for idx := range users {
+		users[idx].Password = ""
+	}
+}
+
+type userServer struct {
+	*server
+}
+
+func toUserQueryParams(nameLike string, enabled, canLogin sql.NullBool, source string, roles, usergroups []int64, usergroupRecursive bool) *usermodels.UserQueryParams {
+	return &usermodels.UserQueryParams{
+		NameLike:           nameLike,
+		Enabled:            enabled,
+		CanLogin:           canLogin,
+		Source:             sql.NullString{String: source, Valid: source != ""},
+		Roles:              roles,
+		UsergroupIDs:       usergroups,
+		UsergroupRecursive: usergroupRecursive,
+	}
+}
+
+// parseAttributeQueries 解析按属性查询的条件, 条件不正确时返回 400
+func (srv *server) parseAttributeQueries(ctx context.Context, attributes []string) ([]usermodels.AttributeQuery, error) {
+	queries, err := srv.svc.ParseAttributeQueries(ctx, attributes)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusBadRequest)
+	}
+	return queries, nil
+}
+
+func (srv *userServer) Count(ctx context.Context, nameLike string, enabled, canLogin sql.NullBool, source string, roles, usergroups []int64, usergroupRecursive bool, attributes []string) (int64, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsers, PermissionManageUsers)
+	if err != nil {
+		return 0, err
+	}
+	params := toUserQueryParams(nameLike, enabled, canLogin, source, roles, usergroups, usergroupRecursive)
+	params.Attributes, err = srv.parseAttributeQueries(ctx, attributes)
+	if err != nil {
+		return 0, err
+	}
+	return srv.svc.GetUserCount(reqCtx, params)
+}
+
+func (srv *userServer) List(ctx context.Context, nameLike string, enabled, canLogin sql.NullBool, source string, roles, usergroups []int64, usergroupRecursive bool, attributes []string, offset, limit int64, sortBy string) ([]usermodels.User, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsers, PermissionManageUsers)
+	if err != nil {
+		return nil, err
+	}
+	params := toUserQueryParams(nameLike, enabled, canLogin, source, roles, usergroups, usergroupRecursive)
+	params.Attributes, err = srv.parseAttributeQueries(ctx, attributes)
+	if err != nil {
+		return nil, err
+	}
+	users, err := srv.svc.GetUsers(reqCtx, params,
+		&userservices.UserQueryOptions{HasRoleInfo: true},
+		offset, limit, sortBy)
+	if err != nil {
+		return nil, err
+	}
+	if users == nil {
+		users = []usermodels.User{}
+	}
+	clearPasswords(users)
+	return users, nil
+}
+
+func (srv *userServer) Fields(ctx context.Context) ([]userservices.Fields, error) {
+	if _, err := srv.newContext(ctx, PermissionViewUsers, PermissionManageUsers); err != nil {
+		return nil, err
+	}
+	return userservices.ReadFieldsFromDir(srv.env)
+}
+
+func (srv *userServer) FieldSchema(ctx context.Context) (*userservices.FieldSchema, error) {
+	if _, err := srv.newContext(ctx, PermissionViewUsers, PermissionManageUsers); err != nil {
+		return nil, err
+	}
+	return srv.svc.FieldSchema(ctx)
+}
+
+func (srv *userServer) FieldVersions(ctx context.Context) ([]usermodels.UserFieldSchema, error) {
+	if _, err := srv.newContext(ctx, PermissionViewUsers, PermissionManageUsers); err != nil {
+		return nil, err
+	}
+	versions, err := srv.svc.FieldSchemaVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if versions == nil {
+		versions = []usermodels.UserFieldSchema{}
+	}
+	return versions, nil
+}
+
+func (srv *userServer) Get(ctx context.Context, id int64) (*usermodels.User, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsers, PermissionManageUsers)
+	if err != nil {
+		return nil, err
+	}
+	u, err := srv.svc.GetUserByID(reqCtx, id, &userservices.UserQueryOptions{
+		HasOnlineInfo:    true,
+		HasRoleInfo:      true,
+		HasUsergroupInfo: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.Password = ""
+	return u, nil
+}
+
+func (srv *userServer) Create(ctx context.Context, user *UserRequest) (int64, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return 0, err
+	}
+	return srv.svc.CreateUser(reqCtx, &user.User, user.Roles)
+}
+
+func (srv *userServer) Update(ctx context.Context, id int64, user *UserRequest) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	mode := userservices.RoleUpdateModeSkip
+	if user.UpdateRoles {
+		mode = userservices.RoleUpdateModeUpdate
+	}
+	return srv.svc.UpdateUser(reqCtx, id, &user.User, mode, user.Roles)
+}
+
+func (srv *userServer) UpdateRoles(ctx context.Context, id int64, roles []int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	return srv.svc.UpdateUserRoles(reqCtx, id, roles)
+}
+
+func (srv *userServer) UpdatePassword(ctx context.Context, id int64, password *PasswordRequest) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	return srv.svc.UpdateUserPassword(reqCtx, id, password.Password)
+}
+
+func (srv *userServer) Enable(ctx context.Context, id int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	return srv.svc.EnableUser(reqCtx, id)
+}
+
+func (srv *userServer) Disable(ctx context.Context, id int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	return srv.svc.DisableUser(reqCtx, id)
+}
+
+func (srv *userServer) Delete(ctx context.Context, id int64, notDelete bool) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	return srv.svc.DeleteUser(reqCtx, id, notDelete)
+}
+
+func (srv *userServer) Recovery(ctx context.Context, id int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	return srv.svc.RecoveryUser(reqCtx, id)
+}
+
+type usergroupServer struct {
+	*server
+}
+
+func (srv *usergroupServer) List(ctx context.Context, userID sql.NullInt64) ([]usermodels.Usergroup, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsergroups, PermissionManageUsergroups)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := srv.svc.GetUsergroups(reqCtx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if groups == nil {
+		groups = []usermodels.Usergroup{}
+	}
+	return groups, nil
+}
+
+func (srv *usergroupServer) Tree(ctx context.Context) ([]*userservices.UsergroupNode, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsergroups, PermissionManageUsergroups)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.GetUsergroupTree(reqCtx)
+}
+
+func (srv *usergroupServer) Get(ctx context.Context, id int64) (*usermodels.Usergroup, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsergroups, PermissionManageUsergroups)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.GetUsergroupByID(reqCtx, id)
+}
+
+func (srv *usergroupServer) Create(ctx context.Context, group *usermodels.Usergroup) (int64, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return 0, err
+	}
+	return srv.svc.CreateUsergroup(reqCtx, group)
+}
+
+func (srv *usergroupServer) Update(ctx context.Context, id int64, group *usermodels.Usergroup) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.UpdateUsergroup(reqCtx, id, group)
+}
+
+func (srv *usergroupServer) Delete(ctx context.Context, id int64, recursive bool) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.DeleteUsergroup(reqCtx, id, recursive)
+}
+
+func (srv *usergroupServer) Move(ctx context.Context, id int64, parentID int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.MoveUsergroup(reqCtx, id, parentID)
+}
+
+func (srv *usergroupServer) Merge(ctx context.Context, id int64, target int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.MergeUsergroup(reqCtx, id, target)
+}
+
+func (srv *usergroupServer) Sort(ctx context.Context, parentID int64, ids []int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.SortUsergroups(reqCtx, parentID, ids)
+}
+
+func (srv *usergroupServer) Users(ctx context.Context, id int64, recursive bool, enabled sql.NullBool) ([]usermodels.User, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsergroups, PermissionManageUsergroups)
+	if err != nil {
+		return nil, err
+	}
+	users, err := srv.svc.GetUsergroupUsers(reqCtx, id, recursive, enabled)
+	if err != nil {
+		return nil, err
+	}
+	if users == nil {
+		users = []usermodels.User{}
+	}
+	clearPasswords(users)
+	return users, nil
+}
+
+func (srv *usergroupServer) AddUser(ctx context.Context, id int64, member *MemberRequest) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.AddUserToUsergroup(reqCtx, id, member.UserID, member.RoleID)
+}
+
+func (srv *usergroupServer) RemoveUser(ctx context.Context, id, userID int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.RemoveUserFromUsergroup(reqCtx, id, userID)
+}
+
+func (srv *usergroupServer) DefaultRoles(ctx context.Context, id int64) ([]usermodels.Role, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewUsergroups, PermissionManageUsergroups)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := srv.svc.GetUsergroupByID(reqCtx, id); err != nil {
+		return nil, err
+	}
+	roles, err := srv.svc.GetUsergroupDefaultRoles(reqCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []usermodels.Role{}
+	}
+	return roles, nil
+}
+
+func (srv *usergroupServer) UpdateDefaultRoles(ctx context.Context, id int64, roles []int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsergroups)
+	if err != nil {
+		return err
+	}
+	return srv.svc.UpdateUsergroupDefaultRoles(reqCtx, id, roles)
+}
+
+type roleServer struct {
+	*server
+}
+
+func (srv *roleServer) List(ctx context.Context, nameLike string, offset, limit int64) ([]usermodels.Role, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewRoles, PermissionManageRoles)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := srv.svc.GetRoles(reqCtx, nameLike, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []usermodels.Role{}
+	}
+	return roles, nil
+}
+
+func (srv *roleServer) Get(ctx context.Context, id int64) (*usermodels.Role, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionViewRoles, PermissionManageRoles)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.GetRoleByID(reqCtx, id)
+}
+
+func (srv *roleServer) Create(ctx context.Context, role *usermodels.Role) (int64, error) {
+	reqCtx, err := srv.newContext(ctx, PermissionManageRoles)
+	if err != nil {
+		return 0, err
+	}
+	return srv.svc.CreateRole(reqCtx, role)
+}
+
+func (srv *roleServer) Update(ctx context.Context, id int64, role *usermodels.Role) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageRoles)
+	if err != nil {
+		return err
+	}
+	return srv.svc.UpdateRole(reqCtx, id, role)
+}
+
+func (srv *roleServer) Delete(ctx context.Context, id int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageRoles)
+	if err != nil {
+		return err
+	}
+	return srv.svc.DeleteRole(reqCtx, id)
+}
+
+func (srv *roleServer) AddUsers(ctx context.Context, id int64, users []int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageRoles)
+	if err != nil {
+		return err
+	}
+	return srv.svc.AddUsersToRole(reqCtx, id, users)
+}
+
+func (srv *roleServer) RemoveUsers(ctx context.Context, id int64, users []int64) error {
+	reqCtx, err := srv.newContext(ctx, PermissionManageRoles)
+	if err != nil {
+		return err
+	}
+	return srv.svc.RemoveUsersFromRole(reqCtx, id, users)
+}
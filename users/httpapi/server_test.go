@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo/api"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type testUser struct {
+	api.User
+
+	permissions []string
+}
+
+func (u *testUser) ID() int64    { return 1 }
+func (u *testUser) Name() string { return "tom" }
+func (u *testUser) HasPermissionAny(ctx context.Context, permissionIDs []string) (bool, error) {
+	for _, id := range permissionIDs {
+		for _, p := range u.permissions {
+			if p == id {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func TestNewContext(t *testing.T) {
+	srv := &server{svc: &userservices.Service{}}
+
+	_, err := srv.newContext(context.Background(), PermissionViewUsers)
+	if err == nil || errors.HTTPCode(err) != http.StatusUnauthorized {
+		t.Error("want 401, got", err)
+	}
+
+	viewer := &testUser{permissions: []string{PermissionViewUsers, PermissionViewRoles}}
+	ctx := api.ContextWithUser(context.Background(), viewer)
+
+	_, err = srv.newContext(ctx, PermissionManageUsers)
+	if err != ErrPermissionDenny {
+		t.Error("want ErrPermissionDenny, got", err)
+	}
+
+	reqCtx, err := srv.newContext(ctx, PermissionManageUsers, PermissionViewUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqCtx.CurrentUser != viewer || reqCtx.Ctx != ctx {
+		t.Error("current user isnot set")
+	}
+
+	// 只有查看权限时不能修改
+	roles := &roleServer{server: srv}
+	if _, err := roles.Create(ctx, &usermodels.Role{Name: "abc"}); err != ErrPermissionDenny {
+		t.Error("want ErrPermissionDenny, got", err)
+	}
+	if err := roles.Delete(ctx, 2); err != ErrPermissionDenny {
+		t.Error("want ErrPermissionDenny, got", err)
+	}
+	groups := &usergroupServer{server: srv}
+	if err := groups.Move(ctx, 2, 3); err != ErrPermissionDenny {
+		t.Error("want ErrPermissionDenny, got", err)
+	}
+}
@@ -0,0 +1,271 @@
+package ldapsync
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn/services"
+	ldap "gopkg.in/ldap.v3"
+)
+
+const (
+	// GroupsFromOU 按用户 DN 中的 OU 层次建立用户组
+	GroupsFromOU = "ou"
+	// GroupsFromMemberOf 按 memberOf 中的组建立用户组
+	GroupsFromMemberOf = "memberOf"
+	// GroupsNone 不同步用户组
+	GroupsNone = "none"
+)
+
+// AD 中 userAccountControl 的 ACCOUNTDISABLE 标志
+const adAccountDisable = 0x2
+
+// Config LDAP 同步的配置
+type Config struct {
+	Address      string
+	TLS          bool
+	TLSConfig    *tls.Config
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	Filter       string
+	PageSize     uint32
+	Timeout      time.Duration
+
+	UsernameAttribute string
+	NicknameAttribute string
+	RoleAttribute     string
+	// Fields 用户字段名到 LDAP 属性名的映射
+	Fields map[string]string
+	// Groups 用户组的来源, 为 ou, memberOf 或 none
+	Groups       string
+	DefaultRoles []string
+
+	// DisableMissing 禁用在目录中已不存在或已被禁用的用户
+	DisableMissing bool
+	Interval       time.Duration
+}
+
+func ReadConfig(env *moo.Environment) (*Config, error) {
+	tlsConfig, err := services.ReadLdapTLSConfig(env)
+	if err != nil {
+		return nil, err
+	}
+	baseDN := env.Config.StringWithDefault(api.CfgUserLdapSyncBaseDN, env.Config.StringWithDefault(api.CfgUserLdapBaseDN, ""))
+	cfg := &Config{
+		Address:      env.Config.StringWithDefault(api.CfgUserLdapSyncAddress, env.Config.StringWithDefault(api.CfgUserLdapAddress, "")),
+		TLS:          env.Config.BoolWithDefault(api.CfgUserLdapSyncTLS, env.Config.BoolWithDefault(api.CfgUserLdapTLS, false)),
+		TLSConfig:    tlsConfig,
+		BindDN:       env.Config.StringWithDefault(api.CfgUserLdapSyncBindDN, env.Config.StringWithDefault(api.CfgUserLdapBindDN, "")),
+		BindPassword: env.Config.StringWithDefault(api.CfgUserLdapSyncBindPassword, env.Config.StringWithDefault(api.CfgUserLdapBindPassword, "")),
+		BaseDN:       baseDN,
+		Filter:       env.Config.StringWithDefault(api.CfgUserLdapSyncFilter, "(&(objectClass=organizationalPerson)(sAMAccountName=*))"),
+		PageSize:     uint32(env.Config.IntWithDefault(api.CfgUserLdapSyncPageSize, 500)),
+		Timeout:      env.Config.DurationWithDefault(api.CfgUserLdapSyncTimeout, 30*time.Second),
+
+		UsernameAttribute: env.Config.StringWithDefault(api.CfgUserLdapSyncUsernameAttribute, "sAMAccountName"),
+		NicknameAttribute: env.Config.StringWithDefault(api.CfgUserLdapSyncNicknameAttribute, "displayName"),
+		RoleAttribute: env.Config.StringWithDefault(api.CfgUserLdapLoginRoleField,
+			env.Config.StringWithDefault("users.ldap_roles", "memberOf")),
+		Fields: map[string]string{
+			"email": "mail",
+			"phone": "telephoneNumber",
+		},
+		Groups:         env.Config.StringWithDefault(api.CfgUserLdapSyncGroups, GroupsFromOU),
+		DisableMissing: env.Config.BoolWithDefault(api.CfgUserLdapSyncDisableMissing, true),
+		Interval:       env.Config.DurationWithDefault(api.CfgUserLdapSyncInterval, 0),
+	}
+	env.Config.ForEachWithPrefix(api.CfgUserLdapSyncFieldPrefix, func(key string, value interface{}) {
+		key = strings.TrimPrefix(key, api.CfgUserLdapSyncFieldPrefix)
+		attr := strings.TrimSpace(fmt.Sprint(value))
+		if attr == "" {
+			delete(cfg.Fields, key)
+			return
+		}
+		cfg.Fields[key] = attr
+	})
+	for _, role := range strings.Split(env.Config.StringWithDefault(api.CfgUserLdapDefaultRoles, ""), ",") {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			cfg.DefaultRoles = append(cfg.DefaultRoles, role)
+		}
+	}
+	return cfg, nil
+}
+
+// Entry 目录中的一个用户
+type Entry struct {
+	DN       string                 `json:"dn"`
+	Username string                 `json:"username"`
+	Nickname string                 `json:"nickname"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	// Groups 用户所属的组，每个组是从根开始的路径
+	Groups   [][]string `json:"groups,omitempty"`
+	Roles    []string   `json:"roles,omitempty"`
+	Disabled bool       `json:"disabled,omitempty"`
+}
+
+// Directory 用服务帐号从 LDAP 中读取用户
+type Directory struct {
+	cfg *Config
+}
+
+func NewDirectory(cfg *Config) *Directory {
+	return &Directory{cfg: cfg}
+}
+
+func (dir *Directory) connect() (*ldap.Conn, error) {
+	servers, err := services.ParseLdapServers(dir.cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &services.LdapDialer{
+		Servers:   servers,
+		StartTLS:  dir.cfg.TLS,
+		TLSConfig: dir.cfg.TLSConfig,
+		Timeout:   dir.cfg.Timeout,
+	}
+	l, err := dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	if dir.cfg.BindDN != "" {
+		if err = l.Bind(dir.cfg.BindDN, dir.cfg.BindPassword); err != nil {
+			l.Close()
+			return nil, errors.Wrap(err, "LDAP 服务帐号登录失败")
+		}
+	}
+	return l, nil
+}
+
+func (dir *Directory) attributes() []string {
+	attrs := []string{dir.cfg.UsernameAttribute, dir.cfg.NicknameAttribute, "userAccountControl"}
+	if dir.cfg.RoleAttribute != "" {
+		attrs = append(attrs, dir.cfg.RoleAttribute)
+	}
+	if dir.cfg.Groups == GroupsFromMemberOf && dir.cfg.RoleAttribute != "memberOf" {
+		attrs = append(attrs, "memberOf")
+	}
+	for _, attr := range dir.cfg.Fields {
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// Search 分页查询目录中所有的用户
+func (dir *Directory) Search() ([]Entry, error) {
+	l, err := dir.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	pageSize := dir.cfg.PageSize
+	if pageSize == 0 {
+		pageSize = 500
+	}
+	result, err := l.SearchWithPaging(ldap.NewSearchRequest(
+		dir.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		dir.cfg.Filter, dir.attributes(), nil,
+	), pageSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询 LDAP 用户失败")
+	}
+
+	entries := make([]Entry, 0, len(result.Entries))
+	for _, ent := range result.Entries {
+		entry, ok := dir.toEntry(ent)
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (dir *Directory) toEntry(ent *ldap.Entry) (Entry, bool) {
+	username := strings.ToLower(strings.TrimSpace(ent.GetAttributeValue(dir.cfg.UsernameAttribute)))
+	if username == "" {
+		return Entry{}, false
+	}
+	entry := Entry{
+		DN:       ent.DN,
+		Username: username,
+		Nickname: strings.TrimSpace(ent.GetAttributeValue(dir.cfg.NicknameAttribute)),
+		Fields:   map[string]interface{}{},
+	}
+	if entry.Nickname == "" {
+		entry.Nickname = username
+	}
+	for field, attr := range dir.cfg.Fields {
+		if value := ent.GetAttributeValue(attr); value != "" {
+			entry.Fields[field] = value
+		}
+	}
+	if s := ent.GetAttributeValue("userAccountControl"); s != "" {
+		if flags, err := strconv.ParseInt(s, 10, 64); err == nil && flags&adAccountDisable != 0 {
+			entry.Disabled = true
+		}
+	}
+	if dir.cfg.RoleAttribute != "" {
+		for _, value := range ent.GetAttributeValues(dir.cfg.RoleAttribute) {
+			if name := firstRDNValue(value); name != "" {
+				entry.Roles = append(entry.Roles, name)
+			}
+		}
+	}
+
+	switch dir.cfg.Groups {
+	case GroupsFromOU:
+		if path := ouPath(ent.DN, dir.cfg.BaseDN); len(path) > 0 {
+			entry.Groups = append(entry.Groups, path)
+		}
+	case GroupsFromMemberOf:
+		for _, value := range ent.GetAttributeValues("memberOf") {
+			if name := firstRDNValue(value); name != "" {
+				entry.Groups = append(entry.Groups, []string{name})
+			}
+		}
+	}
+	return entry, true
+}
+
+// firstRDNValue 取 DN 中第一个 RDN 的值，如 cn=admins,ou=groups,dc=example 返回 admins, 不是 DN 时返回原值
+func firstRDNValue(s string) string {
+	dn, err := ldap.ParseDN(s)
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+// ouPath 返回 DN 中 baseDN 以下的 OU 路径, 从根开始排列
+func ouPath(s, baseDN string) []string {
+	dn, err := ldap.ParseDN(s)
+	if err != nil {
+		return nil
+	}
+	rdns := dn.RDNs
+	if base, err := ldap.ParseDN(baseDN); err == nil && len(base.RDNs) <= len(rdns) {
+		rdns = rdns[:len(rdns)-len(base.RDNs)]
+	}
+
+	var path []string
+	for idx := len(rdns) - 1; idx >= 0; idx-- {
+		for _, attr := range rdns[idx].Attributes {
+			if strings.EqualFold(attr.Type, "ou") {
+				path = append(path, attr.Value)
+			}
+		}
+	}
+	return path
+}
@@ -0,0 +1,72 @@
+package ldapsync
+
+import (
+	"reflect"
+	"testing"
+
+	ldap "gopkg.in/ldap.v3"
+)
+
+func TestOUPath(t *testing.T) {
+	for _, test := range []struct {
+		dn     string
+		baseDN string
+		path   []string
+	}{
+		{"CN=Tom,OU=Dev,OU=IT,DC=example,DC=com", "DC=example,DC=com", []string{"IT", "Dev"}},
+		{"CN=Tom,OU=IT,DC=example,DC=com", "OU=IT,DC=example,DC=com", nil},
+		{"CN=Tom,DC=example,DC=com", "DC=example,DC=com", nil},
+		{"not a dn", "DC=example,DC=com", nil},
+	} {
+		if path := ouPath(test.dn, test.baseDN); !reflect.DeepEqual(path, test.path) {
+			t.Error(test.dn, ": want", test.path, "got", path)
+		}
+	}
+
+	if name := firstRDNValue("CN=Admins,OU=Groups,DC=example,DC=com"); name != "Admins" {
+		t.Error("want Admins got", name)
+	}
+	if name := firstRDNValue("admins"); name != "admins" {
+		t.Error("want admins got", name)
+	}
+}
+
+func TestToEntry(t *testing.T) {
+	dir := NewDirectory(&Config{
+		BaseDN:            "DC=example,DC=com",
+		UsernameAttribute: "sAMAccountName",
+		NicknameAttribute: "displayName",
+		RoleAttribute:     "memberOf",
+		Fields:            map[string]string{"email": "mail"},
+		Groups:            GroupsFromOU,
+	})
+
+	entry, ok := dir.toEntry(ldap.NewEntry("CN=Tom,OU=Dev,OU=IT,DC=example,DC=com", map[string][]string{
+		"sAMAccountName":     {"Tom"},
+		"mail":               {"tom@example.com"},
+		"memberOf":           {"CN=Admins,OU=Groups,DC=example,DC=com", "CN=Ops,OU=Groups,DC=example,DC=com"},
+		"userAccountControl": {"514"},
+	}))
+	if !ok {
+		t.Fatal("entry is skipped")
+	}
+	if entry.Username != "tom" || entry.Nickname != "tom" {
+		t.Error("want tom got", entry.Username, entry.Nickname)
+	}
+	if entry.Fields["email"] != "tom@example.com" {
+		t.Error("want tom@example.com got", entry.Fields["email"])
+	}
+	if !reflect.DeepEqual(entry.Roles, []string{"Admins", "Ops"}) {
+		t.Error("want [Admins Ops] got", entry.Roles)
+	}
+	if !reflect.DeepEqual(entry.Groups, [][]string{{"IT", "Dev"}}) {
+		t.Error("want [[IT Dev]] got", entry.Groups)
+	}
+	if !entry.Disabled {
+		t.Error("want disabled")
+	}
+
+	if _, ok := dir.toEntry(ldap.NewEntry("CN=NoName,DC=example,DC=com", map[string][]string{})); ok {
+		t.Error("entry without username should be skipped")
+	}
+}
@@ -0,0 +1,139 @@
+package ldapsync
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	userservices "github.com/runner-mei/moo/users/services"
+	"go.uber.org/fx"
+)
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, users *userservices.Service, logger log.Logger) (*Syncer, error) {
+			cfg, err := ReadConfig(env)
+			if err != nil {
+				return nil, err
+			}
+			return NewSyncer(cfg, users, logger.Named("ldap_sync")), nil
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Invoke(func(lifecycle fx.Lifecycle, syncer *Syncer, userManager api.UserManager, httpSrv *moo.HTTPServer, logger log.Logger) {
+			h := &handlers{syncer: syncer}
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.POST("/ldap_sync", loong.WrapContextHandler(h.Sync))
+			mux.GET("/ldap_sync", loong.WrapContextHandler(h.LastReport))
+
+			if syncer.cfg.Interval <= 0 {
+				logger.Info("ldap sync started")
+				return
+			}
+
+			var timer util.Timer
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					timer.Start(syncer.cfg.Interval, func() bool {
+						ctx := context.Background()
+						bgUser, err := userManager.UserByName(ctx, api.UserBgOperator, api.UserIncludeDisabled())
+						if err != nil {
+							syncer.logger.Warn("查询后台用户失败", log.Error(err))
+							return true
+						}
+						report, err := syncer.Sync(ctx, bgUser, false)
+						if err != nil {
+							syncer.logger.Warn("LDAP 同步失败", log.Error(err))
+							return true
+						}
+						syncer.logger.Info("LDAP 同步完成", log.String("result", report.String()))
+						return true
+					})
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					timer.Stop()
+					return nil
+				},
+			})
+			logger.Info("ldap sync started", log.String("interval", syncer.cfg.Interval.String()))
+		})
+	})
+}
+
+func isEnabled(env *moo.Environment) bool {
+	return env.Config.StringWithDefault(api.CfgUserLdapSyncAddress, env.Config.StringWithDefault(api.CfgUserLdapAddress, "")) != ""
+}
+
+type handlers struct {
+	syncer *Syncer
+}
+
+func checkPermission(ctx context.Context) (api.User, error) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionLdapSync)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, ErrPermissionDenny
+	}
+	return currentUser, nil
+}
+
+// Sync 立即执行一次同步, 参数 dry_run 为 true 时只返回会做哪些修改
+func (h *handlers) Sync(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := checkPermission(ctx)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	var dryRun bool
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		dryRun, err = strconv.ParseBool(s)
+		if err != nil {
+			authn.ReturnError(w, r, "dry_run '"+s+"' is invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.syncer.Sync(ctx, currentUser, dryRun)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
+
+// LastReport 返回最近一次同步的结果
+func (h *handlers) LastReport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, err := checkPermission(ctx); err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	report := h.syncer.LastReport()
+	if report == nil {
+		authn.ReturnError(w, r, "还没有执行过 LDAP 同步", http.StatusNotFound)
+		return
+	}
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
@@ -0,0 +1,630 @@
+package ldapsync
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// PermissionLdapSync 执行 LDAP 同步的权限
+const PermissionLdapSync = "um.users.ldap_sync"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionLdapSync, Title: "从 LDAP 同步用户", Group: "用户管理"})
+}
+
+var (
+	ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有执行 LDAP 同步的权限")
+	ErrSyncRunning     = errors.NewError(http.StatusConflict, "LDAP 同步正在进行中")
+	ErrEmptyDirectory  = errors.New("LDAP 中没有找到任何用户, 为了安全不做同步")
+)
+
+const (
+	ActionCreateUser  = "create_user"
+	ActionUpdateUser  = "update_user"
+	ActionDisableUser = "disable_user"
+	ActionEnableUser  = "enable_user"
+	ActionCreateGroup = "create_group"
+	ActionJoinGroup   = "join_group"
+	ActionLeaveGroup  = "leave_group"
+	ActionAddRole     = "add_role"
+	ActionRemoveRole  = "remove_role"
+	ActionSkip        = "skip"
+)
+
+// 同步时保存在用户属性中的信息，用来区分哪些是同步加上的
+const (
+	attrDN       = "ldap_dn"
+	attrGroups   = "ldap_groups"
+	attrRoles    = "ldap_roles"
+	attrDisabled = "ldap_disabled"
+)
+
+// Action 同步时对用户或用户组做的一个修改
+type Action struct {
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report 一次同步的结果, dry run 时只报告会做哪些修改
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Total      int       `json:"total"`
+	Created    int       `json:"created"`
+	Updated    int       `json:"updated"`
+	Disabled   int       `json:"disabled"`
+	Enabled    int       `json:"enabled"`
+	Failed     int       `json:"failed"`
+	Actions    []Action  `json:"actions"`
+}
+
+func (report *Report) add(action Action) {
+	report.Actions = append(report.Actions, action)
+}
+
+func (report *Report) String() string {
+	return "新建 " + strconv.Itoa(report.Created) +
+		", 更新 " + strconv.Itoa(report.Updated) +
+		", 禁用 " + strconv.Itoa(report.Disabled) +
+		", 启用 " + strconv.Itoa(report.Enabled) +
+		", 失败 " + strconv.Itoa(report.Failed)
+}
+
+// Syncer 将 LDAP 中的用户，组和角色同步到 moo_users, moo_usergroups 中
+type Syncer struct {
+	logger log.Logger
+	cfg    *Config
+	search func() ([]Entry, error)
+	users  *userservices.Service
+	// inTransaction 在事务中执行修改, 测试时替换它以免访问数据库
+	inTransaction func(ctx *userservices.RequestContext, cb func(*userservices.RequestContext) error) error
+
+	lock    sync.Mutex
+	running bool
+	last    *Report
+}
+
+func NewSyncer(cfg *Config, users *userservices.Service, logger log.Logger) *Syncer {
+	return &Syncer{
+		logger: logger,
+		cfg:    cfg,
+		search: NewDirectory(cfg).Search,
+		users:  users,
+		inTransaction: func(ctx *userservices.RequestContext, cb func(*userservices.RequestContext) error) error {
+			return ctx.InTransaction(cb)
+		},
+	}
+}
+
+// LastReport 返回最近一次同步的结果
+func (s *Syncer) LastReport() *Report {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.last
+}
+
+// Sync 执行一次同步，currentUser 为执行同步的用户
+func (s *Syncer) Sync(ctx context.Context, currentUser api.User, dryRun bool) (*Report, error) {
+	s.lock.Lock()
+	if s.running {
+		s.lock.Unlock()
+		return nil, ErrSyncRunning
+	}
+	s.running = true
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.running = false
+		s.lock.Unlock()
+	}()
+
+	report := &Report{DryRun: dryRun, StartedAt: time.Now()}
+	entries, err := s.search()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmptyDirectory
+	}
+	report.Total = len(entries)
+
+	reqCtx := s.users.NewContext(ctx, currentUser, "")
+	state, err := s.load(reqCtx, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for idx := range entries {
+		entry := &entries[idx]
+		if seen[entry.Username] {
+			report.add(Action{Type: ActionSkip, Username: entry.Username, Message: "目录中有重复的用户 " + entry.DN})
+			continue
+		}
+		seen[entry.Username] = true
+
+		old := state.users[entry.Username]
+		if old == nil {
+			err = s.createUser(reqCtx, state, report, entry)
+		} else {
+			err = s.updateUser(reqCtx, state, report, old, entry)
+		}
+		if err != nil {
+			report.Failed++
+			report.add(Action{Type: ActionSkip, Username: entry.Username, Error: err.Error()})
+			s.logger.Warn("同步 LDAP 用户失败", log.String("username", entry.Username), log.Error(err))
+		}
+	}
+
+	if s.cfg.DisableMissing {
+		names := make([]string, 0, len(state.users))
+		for name := range state.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			old := state.users[name]
+			if old.Disabled || old.IsBuiltin() {
+				continue
+			}
+			if err := s.disableUser(reqCtx, report, old, "目录中已不存在该用户"); err != nil {
+				report.Failed++
+				report.add(Action{Type: ActionSkip, Username: name, Error: err.Error()})
+				s.logger.Warn("禁用 LDAP 用户失败", log.String("username", name), log.Error(err))
+			}
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	if !dryRun {
+		if err := s.logRecord(reqCtx, &api.OperationLog{
+			Type:       "ldap_sync",
+			Successful: report.Failed == 0,
+			Content:    "LDAP 同步: " + report.String(),
+		}); err != nil {
+			return nil, err
+		}
+
+		s.lock.Lock()
+		s.last = report
+		s.lock.Unlock()
+	}
+	return report, nil
+}
+
+func (s *Syncer) logRecord(ctx *userservices.RequestContext, ol *api.OperationLog) error {
+	if ctx.CurrentUser != nil {
+		ol.UserID = ctx.CurrentUser.ID()
+		ol.Username = ctx.CurrentUser.Name()
+	}
+	if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, ol); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
+
+type groupKey struct {
+	parentID int64
+	name     string
+}
+
+type syncState struct {
+	dryRun bool
+	users  map[string]*usermodels.User
+	roles  map[string]*usermodels.Role
+	groups map[groupKey]int64
+	nextID int64
+}
+
+func (s *Syncer) load(ctx *userservices.RequestContext, dryRun bool) (*syncState, error) {
+	state := &syncState{
+		dryRun: dryRun,
+		users:  map[string]*usermodels.User{},
+		roles:  map[string]*usermodels.Role{},
+		groups: map[groupKey]int64{},
+	}
+
+	userList, err := ctx.Users.GetUsers(ctx.Ctx, &usermodels.UserQueryParams{}, 0, 0, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	for idx := range userList {
+		if userList[idx].Source != "ldap" {
+			continue
+		}
+		state.users[strings.ToLower(userList[idx].Name)] = &userList[idx]
+	}
+
+	roleList, err := ctx.Users.GetRoles(ctx.Ctx, "", 0, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询角色失败")
+	}
+	for idx := range roleList {
+		state.roles[roleList[idx].Name] = &roleList[idx]
+	}
+
+	next, closer := ctx.Usergroups.GetUsergroups(ctx.Ctx, sql.NullInt64{})
+	groupList, err := usermodels.GetUsergroups(ctx.Ctx, next)
+	util.CloseWith(closer)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户组失败")
+	}
+	for idx := range groupList {
+		state.groups[groupKey{parentID: groupList[idx].ParentID, name: groupList[idx].Name}] = groupList[idx].ID
+	}
+	return state, nil
+}
+
+// resolveGroups 查找用户组，不存在时创建它
+func (s *Syncer) resolveGroups(ctx *userservices.RequestContext, state *syncState, report *Report, entry *Entry) ([]int64, error) {
+	var idList []int64
+	for _, path := range entry.Groups {
+		var parentID int64
+		for _, name := range path {
+			key := groupKey{parentID: parentID, name: name}
+			id, ok := state.groups[key]
+			if !ok {
+				if state.dryRun {
+					state.nextID--
+					id = state.nextID
+				} else {
+					var err error
+					id, err = ctx.Usergroups.CreateUsergroup(ctx.Ctx, &usermodels.Usergroup{
+						Name:        name,
+						ParentID:    parentID,
+						Description: "从 LDAP 同步",
+					})
+					if err != nil {
+						return nil, errors.Wrap(err, "创建用户组 '"+name+"' 失败")
+					}
+					ctx.Notify(api.BusUsergroupChanged, api.ChangeCreated, id)
+				}
+				state.groups[key] = id
+				report.add(Action{Type: ActionCreateGroup, Group: strings.Join(path, "/")})
+			}
+			parentID = id
+		}
+		if parentID != 0 {
+			idList = append(idList, parentID)
+		}
+	}
+	return idList, nil
+}
+
+func (s *Syncer) roleIDs(state *syncState, names []string) ([]string, []int64) {
+	var found []string
+	var idList []int64
+	for _, name := range names {
+		role := state.roles[name]
+		if role == nil {
+			continue
+		}
+		found = append(found, name)
+		idList = append(idList, role.ID)
+	}
+	return found, idList
+}
+
+func (s *Syncer) createUser(ctx *userservices.RequestContext, state *syncState, report *Report, entry *Entry) error {
+	if entry.Disabled {
+		report.add(Action{Type: ActionSkip, Username: entry.Username, Message: "目录中的用户已被禁用"})
+		return nil
+	}
+
+	if local, err := ctx.Users.GetUserByName(ctx.Ctx, entry.Username); err == nil {
+		report.add(Action{Type: ActionSkip, Username: entry.Username, Message: "已存在来源为 '" + local.Source + "' 的同名用户"})
+		return nil
+	} else if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+		return errors.Wrap(err, "查询用户失败")
+	}
+
+	roleNames, roles := s.roleIDs(state, entry.Roles)
+	_, defaultRoles := s.roleIDs(state, s.cfg.DefaultRoles)
+	groups, err := s.resolveGroups(ctx, state, report, entry)
+	if err != nil {
+		return err
+	}
+
+	user := &usermodels.User{
+		Name:       entry.Username,
+		Nickname:   entry.Nickname,
+		Source:     "ldap",
+		CanLogin:   true,
+		Attributes: map[string]interface{}{},
+	}
+	for k, v := range entry.Fields {
+		user.Attributes[k] = v
+	}
+	user.Attributes[attrDN] = entry.DN
+	user.Attributes[attrGroups] = groups
+	user.Attributes[attrRoles] = roleNames
+
+	report.Created++
+	report.add(Action{Type: ActionCreateUser, Username: entry.Username, Message: entry.DN})
+	for _, name := range roleNames {
+		report.add(Action{Type: ActionAddRole, Username: entry.Username, Role: name})
+	}
+	for _, path := range entry.Groups {
+		report.add(Action{Type: ActionJoinGroup, Username: entry.Username, Group: strings.Join(path, "/")})
+	}
+	if state.dryRun {
+		return nil
+	}
+
+	return s.inTransaction(ctx, func(ctx *userservices.RequestContext) error {
+		userID, err := ctx.Users.CreateUser(ctx.Ctx, user, append(roles, defaultRoles...))
+		if err != nil {
+			return err
+		}
+		for _, groupID := range groups {
+			if err := ctx.Usergroups.AddUserToGroup(ctx.Ctx, groupID, userID, 0); err != nil {
+				return errors.Wrap(err, "添加用户到用户组失败")
+			}
+		}
+		ctx.Notify(api.BusUserChanged, api.ChangeCreated, userID)
+		if len(groups) > 0 {
+			ctx.Notify(api.BusUsergroupChanged, api.ChangeMembersChanged, groups...)
+		}
+		return s.logRecord(ctx, &api.OperationLog{
+			Type:       "add_user",
+			Successful: true,
+			Content:    "LDAP 同步: 创建用户 " + user.Name,
+			Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: userID},
+		})
+	})
+}
+
+func toInt64s(value interface{}) []int64 {
+	var results []int64
+	switch list := value.(type) {
+	case []int64:
+		return list
+	case []interface{}:
+		for _, v := range list {
+			if id := as.Int64WithDefault(v, 0); id != 0 {
+				results = append(results, id)
+			}
+		}
+	}
+	return results
+}
+
+func toStrings(value interface{}) []string {
+	var results []string
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		for _, v := range list {
+			if s := as.StringWithDefault(v, ""); s != "" {
+				results = append(results, s)
+			}
+		}
+	}
+	return results
+}
+
+func containsInt64(list []int64, v int64) bool {
+	for _, a := range list {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, v string) bool {
+	for _, a := range list {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Syncer) groupName(state *syncState, id int64) string {
+	for key, groupID := range state.groups {
+		if groupID == id {
+			return key.name
+		}
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+func (s *Syncer) updateUser(ctx *userservices.RequestContext, state *syncState, report *Report, old *usermodels.User, entry *Entry) error {
+	if entry.Disabled {
+		if !s.cfg.DisableMissing || old.Disabled || old.IsBuiltin() {
+			return nil
+		}
+		return s.disableUser(ctx, report, old, "目录中的用户已被禁用")
+	}
+
+	if old.Attributes == nil {
+		old.Attributes = map[string]interface{}{}
+	}
+
+	var actions []Action
+	changed := false
+	if old.Nickname != entry.Nickname {
+		old.Nickname = entry.Nickname
+		changed = true
+	}
+	for k, v := range entry.Fields {
+		if as.StringWithDefault(old.Attributes[k], "") != as.StringWithDefault(v, "") {
+			old.Attributes[k] = v
+			changed = true
+		}
+	}
+	if as.StringWithDefault(old.Attributes[attrDN], "") != entry.DN {
+		old.Attributes[attrDN] = entry.DN
+		changed = true
+	}
+
+	enable := old.Disabled && as.BoolWithDefault(old.Attributes[attrDisabled], false)
+	if enable {
+		delete(old.Attributes, attrDisabled)
+		actions = append(actions, Action{Type: ActionEnableUser, Username: old.Name})
+	}
+
+	groups, err := s.resolveGroups(ctx, state, report, entry)
+	if err != nil {
+		return err
+	}
+	oldGroups := toInt64s(old.Attributes[attrGroups])
+	var joinGroups, leaveGroups []int64
+	for _, id := range groups {
+		if !containsInt64(oldGroups, id) {
+			joinGroups = append(joinGroups, id)
+			actions = append(actions, Action{Type: ActionJoinGroup, Username: old.Name, Group: s.groupName(state, id)})
+		}
+	}
+	for _, id := range oldGroups {
+		if !containsInt64(groups, id) {
+			leaveGroups = append(leaveGroups, id)
+			actions = append(actions, Action{Type: ActionLeaveGroup, Username: old.Name, Group: s.groupName(state, id)})
+		}
+	}
+	if len(joinGroups) > 0 || len(leaveGroups) > 0 {
+		old.Attributes[attrGroups] = groups
+	}
+
+	roleNames, _ := s.roleIDs(state, entry.Roles)
+	oldRoles := toStrings(old.Attributes[attrRoles])
+	var addRoles, removeRoles []int64
+	for _, name := range roleNames {
+		if !containsString(oldRoles, name) {
+			addRoles = append(addRoles, state.roles[name].ID)
+			actions = append(actions, Action{Type: ActionAddRole, Username: old.Name, Role: name})
+		}
+	}
+	for _, name := range oldRoles {
+		if containsString(roleNames, name) {
+			continue
+		}
+		actions = append(actions, Action{Type: ActionRemoveRole, Username: old.Name, Role: name})
+		if role := state.roles[name]; role != nil {
+			removeRoles = append(removeRoles, role.ID)
+		}
+	}
+	if len(addRoles) > 0 || len(removeRoles) > 0 {
+		old.Attributes[attrRoles] = roleNames
+	}
+
+	if !changed && len(actions) == 0 {
+		return nil
+	}
+	if enable {
+		report.Enabled++
+	}
+	if changed {
+		report.Updated++
+		report.add(Action{Type: ActionUpdateUser, Username: old.Name})
+	}
+	for _, action := range actions {
+		report.add(action)
+	}
+	if state.dryRun {
+		return nil
+	}
+
+	return s.inTransaction(ctx, func(ctx *userservices.RequestContext) error {
+		// 直接用 UserDao 更新，避免已有的密码被再次加密
+		if _, err := ctx.Users.UserDao.UpdateUser(ctx.Ctx, old.ID, old); err != nil {
+			return errors.Wrap(err, "更新用户失败")
+		}
+		if enable {
+			if err := ctx.Users.UserDao.EnableUser(ctx.Ctx, old.ID, sql.NullString{}, sql.NullString{}); err != nil {
+				return errors.Wrap(err, "启用用户失败")
+			}
+		}
+		for _, groupID := range joinGroups {
+			if err := ctx.Usergroups.AddUserToGroup(ctx.Ctx, groupID, old.ID, 0); err != nil {
+				return errors.Wrap(err, "添加用户到用户组失败")
+			}
+		}
+		for _, groupID := range leaveGroups {
+			if err := ctx.Usergroups.RemoveUserFromGroup(ctx.Ctx, groupID, old.ID); err != nil {
+				return errors.Wrap(err, "从用户组中删除用户失败")
+			}
+		}
+		for _, roleID := range addRoles {
+			if err := ctx.Users.UserDao.AddRoleToUser(ctx.Ctx, old.ID, roleID); err != nil {
+				return errors.Wrap(err, "授于角色失败")
+			}
+		}
+		for _, roleID := range removeRoles {
+			if err := ctx.Users.UserDao.RemoveRoleFromUser(ctx.Ctx, old.ID, roleID); err != nil {
+				return errors.Wrap(err, "收回角色失败")
+			}
+		}
+		ctx.Notify(api.BusUserChanged, api.ChangeUpdated, old.ID)
+		if len(joinGroups) > 0 || len(leaveGroups) > 0 {
+			ctx.Notify(api.BusUsergroupChanged, api.ChangeMembersChanged, append(joinGroups, leaveGroups...)...)
+		}
+
+		var changes = make([]string, 0, len(actions))
+		for _, action := range actions {
+			changes = append(changes, action.Type+" "+action.Group+action.Role)
+		}
+		return s.logRecord(ctx, &api.OperationLog{
+			Type:       "update_user",
+			Successful: true,
+			Content:    "LDAP 同步: 更新用户 " + old.Name + " " + strings.Join(changes, ", "),
+			Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: old.ID},
+		})
+	})
+}
+
+func (s *Syncer) disableUser(ctx *userservices.RequestContext, report *Report, old *usermodels.User, reason string) error {
+	report.Disabled++
+	report.add(Action{Type: ActionDisableUser, Username: old.Name, Message: reason})
+	if report.DryRun {
+		return nil
+	}
+
+	if old.Attributes == nil {
+		old.Attributes = map[string]interface{}{}
+	}
+	old.Attributes[attrDisabled] = true
+
+	return s.inTransaction(ctx, func(ctx *userservices.RequestContext) error {
+		if _, err := ctx.Users.UserDao.UpdateUser(ctx.Ctx, old.ID, old); err != nil {
+			return errors.Wrap(err, "更新用户失败")
+		}
+		if err := ctx.Users.UserDao.DisableUser(ctx.Ctx, old.ID, sql.NullString{}, sql.NullString{}); err != nil {
+			return errors.Wrap(err, "禁用用户失败")
+		}
+		ctx.Notify(api.BusUserChanged, api.ChangeDisabled, old.ID)
+		return s.logRecord(ctx, &api.OperationLog{
+			Type:       "disable_user",
+			Successful: true,
+			Content:    "LDAP 同步: 禁用用户 " + old.Name + ", " + reason,
+			Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: old.ID},
+		})
+	})
+}
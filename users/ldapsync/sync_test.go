@@ -0,0 +1,385 @@
+package ldapsync
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	gobatis "github.com/runner-mei/GoBatis"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type testCloser struct{}
+
+func (testCloser) Close() error { return nil }
+
+// testUserDao 保存在内存中的用户和角色, 所有修改都记录在 writes 中
+type testUserDao struct {
+	usermodels.UserDao
+
+	users  []usermodels.User
+	roles  []usermodels.Role
+	nextID int64
+	writes []string
+}
+
+func (dao *testUserDao) GetUsers(ctx context.Context, params *usermodels.UserQueryParams, offset, limit int64, sort string) (func(*usermodels.User) (bool, error), io.Closer) {
+	list := append([]usermodels.User(nil), dao.users...)
+	idx := 0
+	return func(u *usermodels.User) (bool, error) {
+		if idx >= len(list) {
+			return false, nil
+		}
+		*u = list[idx]
+		idx++
+		return true, nil
+	}, testCloser{}
+}
+
+func (dao *testUserDao) GetRoles(ctx context.Context, nameLike string, _type sql.NullInt64, offset, limit int64) (func(*usermodels.Role) (bool, error), io.Closer) {
+	idx := 0
+	return func(role *usermodels.Role) (bool, error) {
+		if idx >= len(dao.roles) {
+			return false, nil
+		}
+		*role = dao.roles[idx]
+		idx++
+		return true, nil
+	}, testCloser{}
+}
+
+func (dao *testUserDao) GetUserByName(ctx context.Context, name string) func(*usermodels.User) error {
+	return func(u *usermodels.User) error {
+		for idx := range dao.users {
+			if strings.EqualFold(dao.users[idx].Name, name) {
+				*u = dao.users[idx]
+				return nil
+			}
+		}
+		return sql.ErrNoRows
+	}
+}
+
+func (dao *testUserDao) get(id int64) *usermodels.User {
+	for idx := range dao.users {
+		if dao.users[idx].ID == id {
+			return &dao.users[idx]
+		}
+	}
+	return nil
+}
+
+func (dao *testUserDao) CreateUser(ctx context.Context, user *usermodels.User) (int64, error) {
+	dao.nextID++
+	user.ID = dao.nextID
+	dao.users = append(dao.users, *user)
+	dao.writes = append(dao.writes, "create_user "+user.Name)
+	return user.ID, nil
+}
+
+func (dao *testUserDao) UpdateUser(ctx context.Context, id int64, user *usermodels.User) (int64, error) {
+	*dao.get(id) = *user
+	dao.writes = append(dao.writes, "update_user "+user.Name)
+	return 1, nil
+}
+
+func (dao *testUserDao) DisableUser(ctx context.Context, id int64, name, nickname sql.NullString) error {
+	u := dao.get(id)
+	u.Disabled = true
+	dao.writes = append(dao.writes, "disable_user "+u.Name)
+	return nil
+}
+
+func (dao *testUserDao) EnableUser(ctx context.Context, id int64, name, nickname sql.NullString) error {
+	u := dao.get(id)
+	u.Disabled = false
+	dao.writes = append(dao.writes, "enable_user "+u.Name)
+	return nil
+}
+
+func (dao *testUserDao) AddRoleToUser(ctx context.Context, userid, roleid int64) error {
+	dao.writes = append(dao.writes, "add_role "+dao.get(userid).Name+" "+strconv.FormatInt(roleid, 10))
+	return nil
+}
+
+func (dao *testUserDao) RemoveRoleFromUser(ctx context.Context, userid, roleid int64) error {
+	dao.writes = append(dao.writes, "remove_role "+dao.get(userid).Name+" "+strconv.FormatInt(roleid, 10))
+	return nil
+}
+
+type testUsergroupDao struct {
+	usermodels.UsergroupDao
+
+	groups []usermodels.Usergroup
+	writes []string
+}
+
+func (dao *testUsergroupDao) GetUsergroups(ctx context.Context, userid sql.NullInt64) (func(*usermodels.Usergroup) (bool, error), io.Closer) {
+	list := append([]usermodels.Usergroup(nil), dao.groups...)
+	idx := 0
+	return func(group *usermodels.Usergroup) (bool, error) {
+		if idx >= len(list) {
+			return false, nil
+		}
+		*group = list[idx]
+		idx++
+		return true, nil
+	}, testCloser{}
+}
+
+func (dao *testUsergroupDao) CreateUsergroup(ctx context.Context, group *usermodels.Usergroup) (int64, error) {
+	group.ID = int64(100 + len(dao.groups))
+	dao.groups = append(dao.groups, *group)
+	dao.writes = append(dao.writes, "create_group "+group.Name+" "+strconv.FormatInt(group.ParentID, 10))
+	return group.ID, nil
+}
+
+func (dao *testUsergroupDao) AddUserToGroup(ctx context.Context, groupid, userid, roleid int64) error {
+	dao.writes = append(dao.writes, "join_group "+strconv.FormatInt(groupid, 10)+" "+strconv.FormatInt(userid, 10))
+	return nil
+}
+
+func (dao *testUsergroupDao) RemoveUserFromGroup(ctx context.Context, groupid, userid int64) error {
+	dao.writes = append(dao.writes, "leave_group "+strconv.FormatInt(groupid, 10)+" "+strconv.FormatInt(userid, 10))
+	return nil
+}
+
+type testOperationLogger struct {
+	records []string
+}
+
+func (logger *testOperationLogger) Tx(tx *gobatis.Tx) api.OperationLogger          { return logger }
+func (logger *testOperationLogger) WithTx(tx gobatis.DBRunner) api.OperationLogger { return logger }
+func (logger *testOperationLogger) LogRecord(ctx context.Context, ol *api.OperationLog) error {
+	logger.records = append(logger.records, ol.Type)
+	return nil
+}
+
+type testSyncer struct {
+	*Syncer
+
+	users   *testUserDao
+	groups  *testUsergroupDao
+	ologger *testOperationLogger
+	entries []Entry
+}
+
+// newTestSyncer 创建一个用内存中的目录和数据访问对象的 Syncer, 修改不在事务中执行
+func newTestSyncer(cfg *Config, users []usermodels.User, groups []usermodels.Usergroup) *testSyncer {
+	ts := &testSyncer{
+		users: &testUserDao{
+			users:  users,
+			roles:  []usermodels.Role{{ID: 1, Name: "admins"}, {ID: 2, Name: "ops"}},
+			nextID: 10,
+		},
+		groups:  &testUsergroupDao{groups: groups},
+		ologger: &testOperationLogger{},
+	}
+	ts.Syncer = NewSyncer(cfg, &userservices.Service{
+		OpLogger:   ts.ologger,
+		Users:      &usermodels.Users{UserDao: ts.users},
+		Usergroups: ts.groups,
+	}, log.Empty())
+	ts.search = func() ([]Entry, error) {
+		return ts.entries, nil
+	}
+	ts.inTransaction = func(ctx *userservices.RequestContext, cb func(*userservices.RequestContext) error) error {
+		return cb(ctx)
+	}
+	return ts
+}
+
+func (ts *testSyncer) writes() []string {
+	return append(append([]string(nil), ts.users.writes...), ts.groups.writes...)
+}
+
+func TestSyncCreateUser(t *testing.T) {
+	ts := newTestSyncer(&Config{}, nil, []usermodels.Usergroup{{ID: 1, Name: "IT"}})
+	ts.entries = []Entry{{
+		DN:       "CN=Tom,OU=Dev,OU=IT,DC=example,DC=com",
+		Username: "tom",
+		Nickname: "Tom",
+		Fields:   map[string]interface{}{"email": "tom@example.com"},
+		Groups:   [][]string{{"IT", "Dev"}},
+		Roles:    []string{"admins", "unknown"},
+	}}
+
+	report, err := ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || report.Failed != 0 {
+		t.Error("got", report.String())
+	}
+
+	if want := []string{"create_user tom", "add_role tom 1", "create_group Dev 1", "join_group 101 11"}; !reflect.DeepEqual(ts.writes(), want) {
+		t.Error("want", want, "got", ts.writes())
+	}
+	u := ts.users.get(11)
+	if u.Source != "ldap" || u.Nickname != "Tom" || !u.CanLogin {
+		t.Error("got", u)
+	}
+	if u.Attributes[attrDN] != "CN=Tom,OU=Dev,OU=IT,DC=example,DC=com" || u.Attributes["email"] != "tom@example.com" {
+		t.Error("got", u.Attributes)
+	}
+	if want := []string{"add_user", "ldap_sync"}; !reflect.DeepEqual(ts.ologger.records, want) {
+		t.Error("want", want, "got", ts.ologger.records)
+	}
+	if ts.LastReport() != report {
+		t.Error("last report isnot saved")
+	}
+
+	// 同名的本地用户不会被覆盖
+	ts = newTestSyncer(&Config{}, []usermodels.User{{ID: 1, Name: "tom"}}, nil)
+	ts.entries = []Entry{{DN: "CN=Tom,DC=example,DC=com", Username: "tom"}}
+	report, err = ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || len(ts.writes()) != 0 {
+		t.Error("local user is overwritten -", report.String(), ts.writes())
+	}
+}
+
+func TestSyncUpdateUser(t *testing.T) {
+	ts := newTestSyncer(&Config{}, []usermodels.User{{
+		ID:       1,
+		Name:     "tom",
+		Nickname: "old",
+		Source:   "ldap",
+		Attributes: map[string]interface{}{
+			attrDN:    "CN=Tom,OU=Old,DC=example,DC=com",
+			attrRoles: []interface{}{"ops"},
+		},
+	}}, nil)
+	ts.entries = []Entry{{
+		DN:       "CN=Tom,DC=example,DC=com",
+		Username: "tom",
+		Nickname: "Tom",
+		Roles:    []string{"admins"},
+	}}
+
+	report, err := ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Created != 0 || report.Failed != 0 {
+		t.Error("got", report.String())
+	}
+	if want := []string{"update_user tom", "add_role tom 1", "remove_role tom 2"}; !reflect.DeepEqual(ts.writes(), want) {
+		t.Error("want", want, "got", ts.writes())
+	}
+	u := ts.users.get(1)
+	if u.Nickname != "Tom" || u.Attributes[attrDN] != "CN=Tom,DC=example,DC=com" {
+		t.Error("got", u)
+	}
+
+	// 没有变化时不修改
+	ts.users.writes = nil
+	report, err = ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 0 || len(ts.writes()) != 0 {
+		t.Error("unchanged user is updated -", report.String(), ts.writes())
+	}
+}
+
+func TestSyncDisableMissing(t *testing.T) {
+	users := func() []usermodels.User {
+		return []usermodels.User{
+			{ID: 1, Name: "tom", Source: "ldap", Attributes: map[string]interface{}{attrDN: "CN=Tom,DC=example,DC=com"}},
+			{ID: 2, Name: "jerry", Source: "ldap"},
+			{ID: 3, Name: api.UserAdmin, Source: "ldap"},
+			{ID: 4, Name: "bob"},
+		}
+	}
+	entries := []Entry{{DN: "CN=Tom,DC=example,DC=com", Username: "tom"}}
+
+	ts := newTestSyncer(&Config{DisableMissing: true}, users(), nil)
+	ts.entries = entries
+	report, err := ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Disabled != 1 || report.Failed != 0 {
+		t.Error("got", report.String())
+	}
+	if want := []string{"update_user jerry", "disable_user jerry"}; !reflect.DeepEqual(ts.writes(), want) {
+		t.Error("want", want, "got", ts.writes())
+	}
+	if u := ts.users.get(2); !u.Disabled || !as.BoolWithDefault(u.Attributes[attrDisabled], false) {
+		t.Error("got", u)
+	}
+
+	// 重新出现在目录中时启用它
+	ts.users.writes = nil
+	ts.entries = append(entries, Entry{DN: "CN=Jerry,DC=example,DC=com", Username: "jerry"})
+	report, err = ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Enabled != 1 {
+		t.Error("got", report.String())
+	}
+	if want := []string{"update_user jerry", "enable_user jerry"}; !reflect.DeepEqual(ts.writes(), want) {
+		t.Error("want", want, "got", ts.writes())
+	}
+
+	ts = newTestSyncer(&Config{DisableMissing: false}, users(), nil)
+	ts.entries = entries
+	report, err = ts.Sync(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Disabled != 0 || len(ts.writes()) != 0 {
+		t.Error("missing user is disabled -", report.String(), ts.writes())
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	ts := newTestSyncer(&Config{DisableMissing: true}, []usermodels.User{
+		{ID: 1, Name: "tom", Nickname: "old", Source: "ldap"},
+		{ID: 2, Name: "jerry", Source: "ldap"},
+	}, nil)
+	ts.entries = []Entry{
+		{DN: "CN=Tom,DC=example,DC=com", Username: "tom", Nickname: "Tom"},
+		{DN: "CN=Lily,OU=IT,DC=example,DC=com", Username: "lily", Groups: [][]string{{"IT"}}, Roles: []string{"ops"}},
+	}
+
+	report, err := ts.Sync(context.Background(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Created != 1 || report.Updated != 1 || report.Disabled != 1 {
+		t.Error("got", report.String())
+	}
+	var types []string
+	for _, action := range report.Actions {
+		types = append(types, action.Type)
+	}
+	if want := []string{ActionUpdateUser, ActionCreateGroup, ActionCreateUser, ActionAddRole, ActionJoinGroup, ActionDisableUser}; !reflect.DeepEqual(types, want) {
+		t.Error("want", want, "got", types)
+	}
+
+	if len(ts.writes()) != 0 || len(ts.ologger.records) != 0 {
+		t.Error("dry run writes -", ts.writes(), ts.ologger.records)
+	}
+	if u := ts.users.get(1); u.Nickname != "old" {
+		t.Error("dry run changes user -", u.Nickname)
+	}
+	if u := ts.users.get(2); u.Disabled || u.Attributes != nil {
+		t.Error("dry run changes user -", u)
+	}
+	if ts.LastReport() != nil {
+		t.Error("dry run report is saved")
+	}
+}
@@ -0,0 +1,178 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/components/mail"
+	userservices "github.com/runner-mei/moo/users/services"
+	"go.uber.org/fx"
+)
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserLifecycleDisabled, false) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, users *userservices.Service, sender mail.Sender, logger log.Logger) *Service {
+			return NewService(ReadConfig(env), users, sender, logger.Named("lifecycle"))
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserLifecycleDisabled, false) {
+			return moo.None
+		}
+		return moo.Invoke(func(lifecycle fx.Lifecycle, svc *Service, bus *moo.Bus, userManager api.UserManager, httpSrv *moo.HTTPServer, logger log.Logger) {
+			h := &handlers{svc: svc}
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.POST("/lifecycle", loong.WrapContextHandler(h.Run))
+			mux.GET("/lifecycle", loong.WrapContextHandler(h.LastReport))
+			mux.PUT("/lifecycle/expires", loong.WrapContextHandler(h.SetExpires))
+
+			bus.RegisterTopics(api.BusUserLogin)
+
+			var timer util.Timer
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					bus.Register("users.lifecycle", &moo.BusHandler{
+						Matcher: api.BusUserLogin,
+						Handle: func(ctx context.Context, topicName string, value interface{}) {
+							evt, ok := value.(*authn.UserLogin)
+							if !ok {
+								return
+							}
+							if err := svc.OnLogin(ctx, evt.Username); err != nil {
+								svc.logger.Warn("记录登录时间失败", log.String("username", evt.Username), log.Error(err))
+							}
+						},
+					})
+
+					if svc.cfg.Interval <= 0 {
+						return nil
+					}
+					timer.Start(svc.cfg.Interval, func() bool {
+						ctx := context.Background()
+						bgUser, err := userManager.UserByName(ctx, api.UserBgOperator, api.UserIncludeDisabled())
+						if err != nil {
+							svc.logger.Warn("查询后台用户失败", log.Error(err))
+							return true
+						}
+						report, err := svc.Run(ctx, bgUser, false)
+						if err != nil {
+							svc.logger.Warn("用户生命周期检查失败", log.Error(err))
+							return true
+						}
+						svc.logger.Info("用户生命周期检查完成", log.String("result", report.String()))
+						return true
+					})
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					bus.Unregister("users.lifecycle")
+					timer.Stop()
+					return nil
+				},
+			})
+			logger.Info("user lifecycle started", log.String("interval", svc.cfg.Interval.String()))
+		})
+	})
+}
+
+type handlers struct {
+	svc *Service
+}
+
+func checkPermission(ctx context.Context) (api.User, error) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionUserLifecycle)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, ErrPermissionDenny
+	}
+	return currentUser, nil
+}
+
+// Run 立即执行一次检查, 参数 dry_run 为 true 时只返回会做哪些处理
+func (h *handlers) Run(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := checkPermission(ctx)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	var dryRun bool
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		dryRun, err = strconv.ParseBool(s)
+		if err != nil {
+			authn.ReturnError(w, r, "dry_run '"+s+"' is invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.svc.Run(ctx, currentUser, dryRun)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
+
+// LastReport 返回最近一次检查的结果
+func (h *handlers) LastReport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, err := checkPermission(ctx); err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	report := h.svc.LastReport()
+	if report == nil {
+		authn.ReturnError(w, r, "还没有执行过用户生命周期检查", http.StatusNotFound)
+		return
+	}
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
+
+// SetExpires 设置帐号的过期时间, 请求为 {"user_id": 1, "expires_at": "2006-01-02T15:04:05Z07:00"}, expires_at 为 null 时永不过期
+func (h *handlers) SetExpires(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := checkPermission(ctx)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	var req struct {
+		UserID    int64      `json:"user_id"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		authn.ReturnError(w, r, "请求不正确: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID <= 0 {
+		authn.ReturnError(w, r, "user_id 不能为空", http.StatusBadRequest)
+		return
+	}
+
+	reqCtx := h.svc.users.NewContext(ctx, currentUser, "")
+	if err := h.svc.users.SetUserExpiresAt(reqCtx, req.UserID, req.ExpiresAt); err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	authn.ReturnJSON(w, r, "OK", http.StatusOK)
+}
@@ -0,0 +1,496 @@
+package lifecycle
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/components/mail"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// PermissionUserLifecycle 管理用户生命周期的权限
+const PermissionUserLifecycle = "um.users.lifecycle"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionUserLifecycle, Title: "管理用户生命周期", Group: "用户管理"})
+}
+
+var (
+	ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有管理用户生命周期的权限")
+	ErrRunning         = errors.NewError(http.StatusConflict, "用户生命周期检查正在进行中")
+)
+
+const (
+	ActionExpireUser   = "expire_user"
+	ActionDisableUser  = "disable_inactive_user"
+	ActionPurgeUser    = "purge_user"
+	ActionWarnExpire   = "warn_expire"
+	ActionWarnInactive = "warn_inactive"
+	ActionWarnPurge    = "warn_purge"
+	ActionSkip         = "skip"
+)
+
+// profileWarned 保存在用户 profile 中的最近一次警告, 值为警告类型和发出警告的时间
+const profileWarned = "lifecycle.warned"
+
+const day = 24 * time.Hour
+
+// Config 用户生命周期的配置
+type Config struct {
+	Interval   time.Duration
+	Inactive   time.Duration
+	PurgeAfter time.Duration
+	WarnBefore time.Duration
+	NotifyTo   []string
+}
+
+func ReadConfig(env *moo.Environment) *Config {
+	cfg := &Config{
+		Interval:   env.Config.DurationWithDefault(api.CfgUserLifecycleInterval, day),
+		Inactive:   time.Duration(env.Config.IntWithDefault(api.CfgUserLifecycleInactiveDays, 0)) * day,
+		PurgeAfter: time.Duration(env.Config.IntWithDefault(api.CfgUserLifecyclePurgeAfterDays, 0)) * day,
+		WarnBefore: time.Duration(env.Config.IntWithDefault(api.CfgUserLifecycleWarnBeforeDays, 7)) * day,
+	}
+	for _, s := range strings.Split(env.Config.StringWithDefault(api.CfgUserLifecycleNotifyTo, ""), ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			cfg.NotifyTo = append(cfg.NotifyTo, s)
+		}
+	}
+	return cfg
+}
+
+// Action 对一个用户做的处理
+type Action struct {
+	Type     string     `json:"type"`
+	UserID   int64      `json:"user_id,omitempty"`
+	Username string     `json:"username,omitempty"`
+	Due      *time.Time `json:"due,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Report 一次检查的结果, dry run 时只报告会做哪些处理
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Total      int       `json:"total"`
+	Expired    int       `json:"expired"`
+	Disabled   int       `json:"disabled"`
+	Purged     int       `json:"purged"`
+	Warned     int       `json:"warned"`
+	Failed     int       `json:"failed"`
+	Actions    []Action  `json:"actions"`
+}
+
+func (report *Report) add(action Action) {
+	report.Actions = append(report.Actions, action)
+}
+
+func (report *Report) String() string {
+	return "过期禁用 " + strconv.Itoa(report.Expired) +
+		", 长期未登录禁用 " + strconv.Itoa(report.Disabled) +
+		", 彻底删除 " + strconv.Itoa(report.Purged) +
+		", 警告 " + strconv.Itoa(report.Warned) +
+		", 失败 " + strconv.Itoa(report.Failed)
+}
+
+// deadline 用户的一个到期时间, 到期时执行 Action, 执行前要先发出 Warn 类型的警告
+type deadline struct {
+	Action  string
+	Warn    string
+	Due     time.Time
+	Message string
+}
+
+// warning 已经发给用户的警告, 保存在用户的 profile 中
+type warning struct {
+	Type string
+	At   time.Time
+}
+
+func parseWarning(s string) warning {
+	ss := strings.SplitN(s, " ", 2)
+	if len(ss) != 2 {
+		return warning{}
+	}
+	at, err := time.Parse(time.RFC3339, ss[1])
+	if err != nil {
+		return warning{}
+	}
+	return warning{Type: ss[0], At: at}
+}
+
+func (w warning) String() string {
+	return w.Type + " " + w.At.Format(time.RFC3339)
+}
+
+// deadlines 按策略返回用户的到期时间, lastActive 为用户最后一次活动的时间
+func (cfg *Config) deadlines(user *usermodels.User, lastActive time.Time) []deadline {
+	if userservices.IsDeleted(user) {
+		if cfg.PurgeAfter <= 0 {
+			return nil
+		}
+		deletedAt, ok := userservices.DeletedAt(user)
+		if !ok {
+			return nil
+		}
+		return []deadline{{Action: ActionPurgeUser, Warn: ActionWarnPurge, Due: deletedAt.Add(cfg.PurgeAfter),
+			Message: "已删除超过 " + strconv.Itoa(int(cfg.PurgeAfter/day)) + " 天"}}
+	}
+	if user.Disabled {
+		return nil
+	}
+
+	var results []deadline
+	if user.ExpiresAt != nil {
+		results = append(results, deadline{Action: ActionExpireUser, Warn: ActionWarnExpire, Due: *user.ExpiresAt,
+			Message: "帐号在 " + user.ExpiresAt.Format("2006-01-02 15:04") + " 过期"})
+	}
+	// 不能登录的用户(如接口帐号)不会有登录记录
+	if cfg.Inactive > 0 && user.CanLogin && !lastActive.IsZero() {
+		results = append(results, deadline{Action: ActionDisableUser, Warn: ActionWarnInactive, Due: lastActive.Add(cfg.Inactive),
+			Message: "超过 " + strconv.Itoa(int(cfg.Inactive/day)) + " 天没有登录"})
+	}
+	return results
+}
+
+// decide 决定对用户做什么处理, 没有要做的处理时返回 false
+//
+// 配置了 WarnBefore 时, 到期前 WarnBefore 内发出警告, 发出警告至少 WarnBefore 后才执行,
+// 所以第一次检查时已经到期的用户也会先收到警告. 有多个到期时间时取最早的
+func (cfg *Config) decide(list []deadline, warned warning, now time.Time) (Action, bool) {
+	if len(list) == 0 {
+		return Action{}, false
+	}
+	dl := list[0]
+	for _, d := range list[1:] {
+		if d.Due.Before(dl.Due) {
+			dl = d
+		}
+	}
+
+	if cfg.WarnBefore <= 0 {
+		if now.Before(dl.Due) {
+			return Action{}, false
+		}
+		return Action{Type: dl.Action, Due: &dl.Due, Message: dl.Message}, true
+	}
+
+	start := dl.Due.Add(-cfg.WarnBefore)
+	if now.Before(start) {
+		return Action{}, false
+	}
+
+	// 警告早于本次的警告期时是上一个周期的(如用户之后又登录过), 不算数
+	if warned.Type == dl.Warn && !warned.At.Before(start) {
+		due := dl.Due
+		if t := warned.At.Add(cfg.WarnBefore); t.After(due) {
+			due = t
+		}
+		if now.Before(due) {
+			return Action{}, false
+		}
+		return Action{Type: dl.Action, Due: &due, Message: dl.Message}, true
+	}
+
+	due := dl.Due
+	if t := now.Add(cfg.WarnBefore); t.After(due) {
+		due = t
+	}
+	return Action{Type: dl.Warn, Due: &due, Message: dl.Message}, true
+}
+
+// lastActive 返回用户最后一次活动的时间, 取最后登录时间, 在线会话的最后活动时间和创建时间中最晚的
+func lastActive(user *usermodels.User, sessions map[int64]time.Time) time.Time {
+	t := user.CreatedAt
+	if user.LastLoginAt != nil && user.LastLoginAt.After(t) {
+		t = *user.LastLoginAt
+	}
+	if s, ok := sessions[user.ID]; ok && s.After(t) {
+		t = s
+	}
+	return t
+}
+
+// Service 按配置自动禁用过期和长期未登录的用户, 彻底删除软删除超过保留期的用户, 并在处理前发出警告
+type Service struct {
+	logger log.Logger
+	cfg    *Config
+	users  *userservices.Service
+	sender mail.Sender
+
+	lock    sync.Mutex
+	running bool
+	last    *Report
+}
+
+func NewService(cfg *Config, users *userservices.Service, sender mail.Sender, logger log.Logger) *Service {
+	return &Service{
+		logger: logger,
+		cfg:    cfg,
+		users:  users,
+		sender: sender,
+	}
+}
+
+// LastReport 返回最近一次检查的结果
+func (svc *Service) LastReport() *Report {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+	return svc.last
+}
+
+// OnLogin 记录用户最后一次登录的时间
+func (svc *Service) OnLogin(ctx context.Context, username string) error {
+	_, err := svc.users.Users.UserDao.TouchLastLogin(ctx, username)
+	if err != nil {
+		return errors.Wrap(err, "更新用户 '"+username+"' 的最后登录时间失败")
+	}
+	return nil
+}
+
+// Run 执行一次检查, currentUser 为执行检查的用户
+func (svc *Service) Run(ctx context.Context, currentUser api.User, dryRun bool) (*Report, error) {
+	svc.lock.Lock()
+	if svc.running {
+		svc.lock.Unlock()
+		return nil, ErrRunning
+	}
+	svc.running = true
+	svc.lock.Unlock()
+
+	defer func() {
+		svc.lock.Lock()
+		svc.running = false
+		svc.lock.Unlock()
+	}()
+
+	report := &Report{DryRun: dryRun, StartedAt: time.Now()}
+	reqCtx := svc.users.NewContext(ctx, currentUser, "")
+
+	userList, err := reqCtx.Users.GetUsers(reqCtx.Ctx, &usermodels.UserQueryParams{}, 0, 0, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	sessions, err := svc.sessions(reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(userList, func(i, j int) bool {
+		return userList[i].ID < userList[j].ID
+	})
+
+	now := time.Now()
+	for idx := range userList {
+		user := &userList[idx]
+		if user.IsBuiltin() {
+			continue
+		}
+		report.Total++
+
+		list := svc.cfg.deadlines(user, lastActive(user, sessions))
+		if len(list) == 0 {
+			continue
+		}
+		warned, err := svc.readWarning(reqCtx, user)
+		if err != nil {
+			report.Failed++
+			report.add(Action{Type: ActionSkip, UserID: user.ID, Username: user.Name, Error: err.Error()})
+			continue
+		}
+		action, ok := svc.cfg.decide(list, warned, now)
+		if !ok {
+			continue
+		}
+		action.UserID = user.ID
+		action.Username = user.Name
+		if err := svc.apply(reqCtx, report, user, action, now); err != nil {
+			report.Failed++
+			action.Type = ActionSkip
+			action.Error = err.Error()
+			report.add(action)
+			svc.logger.Warn("处理用户失败", log.String("username", user.Name), log.Error(err))
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	if !dryRun {
+		if err := svc.logRecord(reqCtx, &api.OperationLog{
+			Type:       "user_lifecycle",
+			Successful: report.Failed == 0,
+			Content:    "用户生命周期检查: " + report.String(),
+		}); err != nil {
+			return nil, err
+		}
+
+		svc.lock.Lock()
+		svc.last = report
+		svc.lock.Unlock()
+	}
+	return report, nil
+}
+
+// sessions 返回每个用户在线会话的最后活动时间
+func (svc *Service) sessions(ctx *userservices.RequestContext) (map[int64]time.Time, error) {
+	list, err := ctx.OnlineUsers.List(ctx.Ctx, "")
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "查询在线用户失败")
+	}
+	results := map[int64]time.Time{}
+	for idx := range list {
+		if t, ok := results[list[idx].UserID]; !ok || list[idx].UpdatedAt.After(t) {
+			results[list[idx].UserID] = list[idx].UpdatedAt
+		}
+	}
+	return results, nil
+}
+
+func (svc *Service) apply(ctx *userservices.RequestContext, report *Report, user *usermodels.User, action Action, now time.Time) error {
+	switch action.Type {
+	case ActionExpireUser, ActionDisableUser:
+		if !report.DryRun {
+			if err := svc.disableUser(ctx, user, action); err != nil {
+				return err
+			}
+		}
+		if action.Type == ActionExpireUser {
+			report.Expired++
+		} else {
+			report.Disabled++
+		}
+	case ActionPurgeUser:
+		if !report.DryRun {
+			if err := svc.purgeUser(ctx, user, action); err != nil {
+				return err
+			}
+		}
+		report.Purged++
+	default:
+		if !report.DryRun {
+			if err := svc.warn(ctx, user, &action, now); err != nil {
+				return err
+			}
+		}
+		report.Warned++
+	}
+	report.add(action)
+	return nil
+}
+
+func (svc *Service) disableUser(ctx *userservices.RequestContext, user *usermodels.User, action Action) error {
+	return ctx.InTransaction(func(ctx *userservices.RequestContext) error {
+		if err := ctx.Users.UserDao.DisableUser(ctx.Ctx, user.ID, sql.NullString{}, sql.NullString{}); err != nil {
+			return errors.Wrap(err, "禁用用户失败")
+		}
+		ctx.Notify(api.BusUserChanged, api.ChangeDisabled, user.ID)
+		return svc.logRecord(ctx, &api.OperationLog{
+			Type:       action.Type,
+			Successful: true,
+			Content:    "用户生命周期检查: 禁用用户 " + user.Name + ", " + action.Message,
+			Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: user.ID},
+		})
+	})
+}
+
+func (svc *Service) purgeUser(ctx *userservices.RequestContext, user *usermodels.User, action Action) error {
+	return ctx.InTransaction(func(ctx *userservices.RequestContext) error {
+		if _, err := ctx.Users.UserDao.DeleteUser(ctx.Ctx, user.ID); err != nil {
+			return errors.Wrap(err, "删除用户失败")
+		}
+		ctx.Notify(api.BusUserChanged, api.ChangeDeleted, user.ID)
+		return svc.logRecord(ctx, &api.OperationLog{
+			Type:       action.Type,
+			Successful: true,
+			Content:    "用户生命周期检查: 彻底删除用户 " + user.Name + ", " + action.Message,
+			Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: user.ID},
+		})
+	})
+}
+
+// readWarning 读取已经发给用户的警告
+func (svc *Service) readWarning(ctx *userservices.RequestContext, user *usermodels.User) (warning, error) {
+	value, err := ctx.Users.ReadProfile(ctx.Ctx, user.ID, profileWarned)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return warning{}, nil
+		}
+		return warning{}, errors.Wrap(err, "查询用户的警告记录失败")
+	}
+	return parseWarning(value), nil
+}
+
+func emailOf(user *usermodels.User) string {
+	if user.Attributes == nil {
+		return ""
+	}
+	return strings.TrimSpace(as.StringWithDefault(user.Attributes[userservices.Email.ID], ""))
+}
+
+// warn 发出警告, 帐号过期和长期未登录发给用户本人, 彻底删除发给 users.lifecycle.notify_to 配置的管理员
+func (svc *Service) warn(ctx *userservices.RequestContext, user *usermodels.User, action *Action, now time.Time) error {
+	var to []string
+	var subject, body string
+	due := action.Due.Format("2006-01-02 15:04")
+	switch action.Type {
+	case ActionWarnExpire:
+		to = []string{emailOf(user)}
+		subject = "帐号即将过期"
+		body = "您好, " + user.Nickname + ":\r\n\r\n" +
+			"您的帐号 " + user.Name + " 将在 " + due + " 过期, 过期后将被禁用。如需继续使用请联系管理员。\r\n"
+	case ActionWarnInactive:
+		to = []string{emailOf(user)}
+		subject = "帐号即将因长期未登录被禁用"
+		body = "您好, " + user.Nickname + ":\r\n\r\n" +
+			"您的帐号 " + user.Name + " " + action.Message + ", 如果在 " + due + " 前仍没有登录, 帐号将被禁用。\r\n"
+	default:
+		to = svc.cfg.NotifyTo
+		subject = "已删除的用户即将被彻底删除"
+		body = "用户 " + user.Name + " " + action.Message + ", 将在 " + due + " 被彻底删除, 删除后不能恢复。\r\n"
+	}
+
+	if len(to) == 0 || to[0] == "" {
+		action.Message = action.Message + ", 没有接收警告的邮箱"
+	} else if err := svc.sender.Send(ctx.Ctx, &mail.Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}); err != nil {
+		return errors.Wrap(err, "发送警告邮件失败")
+	}
+
+	if err := ctx.Users.WriteProfile(ctx.Ctx, user.ID, profileWarned, warning{Type: action.Type, At: now}.String()); err != nil {
+		return errors.Wrap(err, "保存用户的警告记录失败")
+	}
+	return svc.logRecord(ctx, &api.OperationLog{
+		Type:       action.Type,
+		Successful: true,
+		Content:    "用户生命周期检查: 警告用户 " + user.Name + " " + action.Message + ", 到期时间 " + due,
+		Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: user.ID},
+	})
+}
+
+func (svc *Service) logRecord(ctx *userservices.RequestContext, ol *api.OperationLog) error {
+	if ctx.CurrentUser != nil {
+		ol.UserID = ctx.CurrentUser.ID()
+		ol.Username = ctx.CurrentUser.Name()
+	}
+	if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, ol); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
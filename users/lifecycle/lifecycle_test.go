@@ -0,0 +1,84 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+func TestDeadlines(t *testing.T) {
+	cfg := &Config{Inactive: 90 * day, PurgeAfter: 30 * day, WarnBefore: 7 * day}
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(3 * day)
+
+	list := cfg.deadlines(&usermodels.User{CanLogin: true, ExpiresAt: &expiresAt}, now.Add(-100*day))
+	if len(list) != 2 || list[0].Action != ActionExpireUser || list[1].Action != ActionDisableUser {
+		t.Fatal(list)
+	}
+	if !list[1].Due.Equal(now.Add(-10 * day)) {
+		t.Error(list[1].Due)
+	}
+
+	if list := cfg.deadlines(&usermodels.User{CanLogin: false}, now.Add(-100*day)); len(list) != 0 {
+		t.Error("can_login is false", list)
+	}
+	if list := cfg.deadlines(&usermodels.User{CanLogin: true, Disabled: true, ExpiresAt: &expiresAt}, now); len(list) != 0 {
+		t.Error("disabled", list)
+	}
+
+	deleted := &usermodels.User{Name: "a(deleted: " + now.Add(-40*day).Format(time.RFC3339) + ")", Disabled: true}
+	list = cfg.deadlines(deleted, now)
+	if len(list) != 1 || list[0].Action != ActionPurgeUser || !list[0].Due.Equal(now.Add(-10*day)) {
+		t.Error(list)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	cfg := &Config{WarnBefore: 7 * day}
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	dl := func(due time.Time) []deadline {
+		return []deadline{{Action: ActionExpireUser, Warn: ActionWarnExpire, Due: due}}
+	}
+
+	for _, test := range []struct {
+		name   string
+		due    time.Time
+		warned warning
+		action string
+		at     time.Time
+	}{
+		{name: "not yet", due: now.Add(8 * day)},
+		{name: "warn", due: now.Add(3 * day), action: ActionWarnExpire, at: now.Add(7 * day)},
+		{name: "warned", due: now.Add(3 * day), warned: warning{Type: ActionWarnExpire, At: now.Add(-day)}},
+		{name: "expired without warning", due: now.Add(-day), action: ActionWarnExpire, at: now.Add(7 * day)},
+		{name: "expired after warning", due: now.Add(-day), warned: warning{Type: ActionWarnExpire, At: now.Add(-8 * day)},
+			action: ActionExpireUser, at: now.Add(-day)},
+		{name: "grace after late warning", due: now.Add(-5 * day), warned: warning{Type: ActionWarnExpire, At: now.Add(-2 * day)}},
+		{name: "stale warning", due: now.Add(-day), warned: warning{Type: ActionWarnExpire, At: now.Add(-30 * day)},
+			action: ActionWarnExpire, at: now.Add(7 * day)},
+		{name: "other warning", due: now.Add(-day), warned: warning{Type: ActionWarnInactive, At: now.Add(-8 * day)},
+			action: ActionWarnExpire, at: now.Add(7 * day)},
+	} {
+		action, ok := cfg.decide(dl(test.due), test.warned, now)
+		if test.action == "" {
+			if ok {
+				t.Error(test.name, "want nothing, got", action.Type)
+			}
+			continue
+		}
+		if !ok || action.Type != test.action || !action.Due.Equal(test.at) {
+			t.Error(test.name, "want", test.action, test.at, "got", ok, action.Type, action.Due)
+		}
+	}
+
+	action, ok := (&Config{}).decide(dl(now), warning{}, now)
+	if !ok || action.Type != ActionExpireUser {
+		t.Error("without warning", ok, action.Type)
+	}
+
+	w := parseWarning(warning{Type: ActionWarnPurge, At: now}.String())
+	if w.Type != ActionWarnPurge || !w.At.Equal(now) {
+		t.Error(w)
+	}
+}
@@ -41,6 +41,9 @@ func Create(env *moo.Environment, users *usermodels.Users, userSvc *userservices
 		secretKey:         env.Config.StringWithDefault(api.CfgUserSigningSecretKey, ""),
 		lockedTimeExpires: env.Config.DurationWithDefault(api.CfgUserLockedTimeExpiresKey, 0),
 		userFormat:        env.Config.StringWithDefault(api.CfgUserDisplayFormatKey, ""),
+		// 只有默认的算法才能验证 bcrypto 中各个算法加密的密码
+		rehashPassword: signingMethod == api.CfgUserSigningMethodDefault &&
+			env.Config.BoolWithDefault(api.CfgUserPasswordRehash, true),
 	}
 	if um.signingMethod == nil {
 		return nil, errors.New("users.signing.method '" + signingMethod + "' is missing")
@@ -50,6 +53,7 @@ func Create(env *moo.Environment, users *usermodels.Users, userSvc *userservices
 
 	um.userCache.defaultExpiration = um.lockedTimeExpires
 	um.userCache.items = map[int64]userCacheItem{}
+	um.userCache.counters = &cacheCounters{}
 	um.userCache.findByName = users.GetUserByName
 	um.userCache.findByID = users.GetUserByID
 	um.userCache.load = um.loadUser2
@@ -79,6 +83,7 @@ type UserManager struct {
 
 	signingMethod     authn.SigningMethod
 	secretKey         string
+	rehashPassword    bool
 	lockedTimeExpires time.Duration
 	userFormat        string
 
@@ -243,15 +248,15 @@ func (um *UserManager) loadUser(ctx context.Context, u *user) (err error) {
 	return nil
 }
 
+// loadRolesForUser 载入用户的有效角色, 包括用户组的默认角色和从上级角色继承的角色
 func (um *UserManager) loadRolesForUser(ctx context.Context, u *user) (err error) {
-	if len(u.roles) == 0 {
-		u.roles, err = um.Users.UserDao.GetRolesByUserID(ctx, u.ID())
-		if err != nil {
-			return errors.Wrap(err, "载入用户 "+u.Name()+" 的角色列表失败")
-		}
+	u.roles, err = um.Users.UserDao.GetEffectiveRolesByUserID(ctx, u.ID(), true)
+	if err != nil {
+		return errors.Wrap(err, "载入用户 "+u.Name()+" 的角色列表失败")
 	}
 
 	u.roleNames = nil
+	u.roleIDs = nil
 	u.Roles() // 缓存 roleNames
 
 	// if um.superRole.ID != 0 {
@@ -6,6 +6,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -32,6 +33,7 @@ type UsergroupCacheBase struct {
 	items             map[int64]usergroupCacheItem
 	mu                sync.RWMutex
 	onEvicted         func(int64, Usergroup)
+	counters          *cacheCounters
 }
 
 // Set add an item to the cache, replacing any existing item. If the duration is 0
@@ -126,15 +128,18 @@ func (c *UsergroupCacheBase) Get(k int64) (Usergroup, bool) {
 	item, found := c.items[k]
 	if !found {
 		c.mu.RUnlock()
+		c.counters.miss()
 		return nil, false
 	}
 	if item.Expiration > 0 {
 		if time.Now().UnixNano() > item.Expiration {
 			c.mu.RUnlock()
+			c.counters.miss()
 			return nil, false
 		}
 	}
 	c.mu.RUnlock()
+	c.counters.hit()
 	return item.Object, true
 }
 
@@ -284,6 +289,11 @@ func (c *UsergroupCacheBase) Flush() {
 	c.mu.Unlock()
 }
 
+// Stats 返回缓存的命中率等统计信息
+func (c *UsergroupCacheBase) Stats() CacheStats {
+	return c.counters.stats(c.ItemCount())
+}
+
 type UsergroupCache struct {
 	UsergroupCacheBase
 	userManager api.UserManager
@@ -291,6 +301,45 @@ type UsergroupCache struct {
 
 	nmu     sync.RWMutex
 	name2id map[string]int64
+
+	tmu            sync.Mutex
+	children       map[int64][]int64
+	childrenExpire int64
+}
+
+// childIDs 返回直接下级用户组的 ID, 上下级关系一次全部读出并和用户组一样缓存 defaultExpiration
+func (c *UsergroupCache) childIDs(ctx context.Context, id int64) ([]int64, error) {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+
+	if c.children == nil || (c.childrenExpire > 0 && time.Now().UnixNano() > c.childrenExpire) {
+		next, closer := c.queryer.GetUsergroups(ctx, sql.NullInt64{})
+		defer util.CloseWith(closer)
+
+		groups, err := usermodels.GetUsergroups(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			if groups[i].Sequence != groups[j].Sequence {
+				return groups[i].Sequence < groups[j].Sequence
+			}
+			return groups[i].ID < groups[j].ID
+		})
+
+		children := map[int64][]int64{}
+		for idx := range groups {
+			if groups[idx].ParentID != 0 {
+				children[groups[idx].ParentID] = append(children[groups[idx].ParentID], groups[idx].ID)
+			}
+		}
+		c.children = children
+		c.childrenExpire = 0
+		if c.defaultExpiration > 0 {
+			c.childrenExpire = time.Now().Add(c.defaultExpiration).UnixNano()
+		}
+	}
+	return c.children[id], nil
 }
 
 func (c *UsergroupCache) UsergroupsByUserID(ctx context.Context, userID int64, opts ...Option) ([]Usergroup, error) {
@@ -384,13 +433,14 @@ func (c *UsergroupCache) load(ctx context.Context, group *usergroup) (Usergroup,
 
 	group.userManager = c.userManager
 	group.usergroupManager = c
+	group.tree = c
 	group.userids = userids
 	return group, nil
 }
 
 func (c *UsergroupCache) usergroupByID(ctx context.Context, id int64, forceUpdate bool, opts []Option, read func(ctx context.Context, id int64) (Usergroup, error)) (Usergroup, error) {
 	u, ok := c.Get(id)
-	if ok {
+	if !ok || forceUpdate {
 		var err error
 		u, err = read(ctx, id)
 		if err != nil {
@@ -409,3 +459,25 @@ func (c *UsergroupCache) usergroupByID(ctx context.Context, id int64, forceUpdat
 	}
 	return u, nil
 }
+
+// OnChanged 处理 api.BusUsergroupChanged 事件, 按 ID 删除缓存的用户组, 事件中没有 ID 时清空缓存,
+// 用户组的上下级关系总是重新读取
+func (c *UsergroupCache) OnChanged(ctx context.Context, topicName string, value interface{}) {
+	c.tmu.Lock()
+	c.children = nil
+	c.tmu.Unlock()
+
+	evt, ok := value.(*api.ChangedEvent)
+	if !ok || evt.IsRefresh() {
+		c.Flush()
+		c.nmu.Lock()
+		c.name2id = map[string]int64{}
+		c.nmu.Unlock()
+		c.counters.flush()
+		return
+	}
+	for _, id := range evt.IDs {
+		c.Delete(id)
+		c.counters.evict()
+	}
+}
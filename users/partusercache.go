@@ -40,6 +40,7 @@ type UserCacheBase struct {
 	items             map[int64]userCacheItem
 	mu                sync.RWMutex
 	onEvicted         func(int64, api.User)
+	counters          *cacheCounters
 }
 
 // Set add an item to the cache, replacing any existing item. If the duration is 0
@@ -134,15 +135,18 @@ func (c *UserCacheBase) Get(k int64) (api.User, bool) {
 	item, found := c.items[k]
 	if !found {
 		c.mu.RUnlock()
+		c.counters.miss()
 		return nil, false
 	}
 	if item.Expiration > 0 {
 		if time.Now().UnixNano() > item.Expiration {
 			c.mu.RUnlock()
+			c.counters.miss()
 			return nil, false
 		}
 	}
 	c.mu.RUnlock()
+	c.counters.hit()
 	return item.Object, true
 }
 
@@ -292,6 +296,11 @@ func (c *UserCacheBase) Flush() {
 	c.mu.Unlock()
 }
 
+// Stats 返回缓存的命中率等统计信息
+func (c *UserCacheBase) Stats() CacheStats {
+	return c.counters.stats(c.ItemCount())
+}
+
 type LoadUserFunc func(ctx context.Context, user *usermodels.User) (api.User, error)
 type ReadUserByNameFunc func(ctx context.Context, userName string) (*usermodels.User, error)
 type ReadUserByIDFunc func(ctx context.Context, userID int64) (*usermodels.User, error)
@@ -381,3 +390,20 @@ func (c *UserCache) userByID(ctx context.Context, userID int64, forceUpdate bool
 	}
 	return u, nil
 }
+
+// OnChanged 处理 api.BusUserChanged 事件, 按 ID 删除缓存的用户, 事件中没有 ID 时清空缓存
+func (c *UserCache) OnChanged(ctx context.Context, topicName string, value interface{}) {
+	evt, ok := value.(*api.ChangedEvent)
+	if !ok || evt.IsRefresh() {
+		c.Flush()
+		c.nmu.Lock()
+		c.name2id = nil
+		c.nmu.Unlock()
+		c.counters.flush()
+		return
+	}
+	for _, id := range evt.IDs {
+		c.Delete(id)
+		c.counters.evict()
+	}
+}
@@ -0,0 +1,64 @@
+package users
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/bcrypto"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// PermissionViewPasswordAlgorithms 查看密码加密算法统计的权限
+const PermissionViewPasswordAlgorithms = "um.users.password_algorithms"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionViewPasswordAlgorithms, Title: "查看用户的密码算法", Group: "用户管理"})
+}
+
+// PasswordAlgorithmsReport 各个加密算法的用户数, 用来判断还有多少用户的密码没有用新的算法重新加密
+type PasswordAlgorithmsReport struct {
+	Default    string         `json:"default"`
+	Algorithms map[string]int `json:"algorithms"`
+}
+
+func init() {
+	moo.On(func(*moo.Environment) moo.Option {
+		return moo.Invoke(func(env *moo.Environment, httpSrv *moo.HTTPServer, users *usermodels.Users) {
+			defaultAlgorithm := env.Config.StringWithDefault(api.CfgUserPasswordAlgorithm, bcrypto.AlgorithmBcrypt)
+
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.GET("/password_algorithms", loong.WrapContextHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				currentUser, err := api.ReadUserFromContext(ctx)
+				if err != nil {
+					authn.ReturnError(w, r, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				ok, err := currentUser.HasPermission(ctx, PermissionViewPasswordAlgorithms)
+				if err != nil {
+					authn.ReturnError(w, r, errors.Wrap(err, "检查权限失败").Error(), http.StatusInternalServerError)
+					return
+				}
+				if !ok {
+					authn.ReturnError(w, r, "没有查看密码加密算法统计的权限", http.StatusForbidden)
+					return
+				}
+
+				algorithms, err := users.CountPasswordAlgorithms(ctx)
+				if err != nil {
+					authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+					return
+				}
+				authn.ReturnJSON(w, r, &PasswordAlgorithmsReport{
+					Default:    defaultAlgorithm,
+					Algorithms: algorithms,
+				}, http.StatusOK)
+			}))
+		})
+	})
+}
@@ -0,0 +1,262 @@
+package provision
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/db"
+	userservices "github.com/runner-mei/moo/users/services"
+	"go.uber.org/fx"
+)
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, conn *db.InModelDB, users *userservices.Service, logger log.Logger) (*Syncer, error) {
+			cfg, err := ReadConfig(env)
+			if err != nil {
+				return nil, err
+			}
+			source, err := NewSource(env, conn.DB)
+			if err != nil {
+				return nil, err
+			}
+			return NewSyncer(cfg, source, users, logger.Named("provision")), nil
+		})
+	})
+
+	// 没有配置旧的 users.sync.db.find 时, 登录模块用外部目录补全新用户的信息
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) || env.Config.StringWithDefault(api.CfgUserSyncDbFind, "") != "" {
+			return moo.None
+		}
+		return moo.Provide(func(syncer *Syncer) api.UserSyncer {
+			return syncer
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if !isEnabled(env) {
+			return moo.None
+		}
+		return moo.Invoke(func(lifecycle fx.Lifecycle, syncer *Syncer, userManager api.UserManager, httpSrv *moo.HTTPServer, logger log.Logger) {
+			h := &handlers{syncer: syncer}
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.POST("/provision", loong.WrapContextHandler(h.Sync))
+			mux.GET("/provision", loong.WrapContextHandler(h.LastReport))
+
+			run := func(full bool) func() bool {
+				return func() bool {
+					ctx := context.Background()
+					bgUser, err := userManager.UserByName(ctx, api.UserBgOperator, api.UserIncludeDisabled())
+					if err != nil {
+						syncer.logger.Warn("查询后台用户失败", log.Error(err))
+						return true
+					}
+					report, err := syncer.Reconcile(ctx, bgUser, full, false)
+					if err != nil {
+						syncer.logger.Warn("用户同步失败", log.Bool("full", full), log.Error(err))
+						return true
+					}
+					syncer.logger.Info("用户同步完成", log.Bool("full", report.Full), log.String("result", report.String()))
+					return true
+				}
+			}
+
+			var incrTimer, fullTimer util.Timer
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					if syncer.cfg.Interval > 0 {
+						incrTimer.Start(syncer.cfg.Interval, run(false))
+					}
+					if syncer.cfg.FullInterval > 0 {
+						fullTimer.Start(syncer.cfg.FullInterval, run(true))
+					}
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					incrTimer.Stop()
+					fullTimer.Stop()
+					return nil
+				},
+			})
+			logger.Info("user provision started",
+				log.String("interval", syncer.cfg.Interval.String()),
+				log.String("full_interval", syncer.cfg.FullInterval.String()))
+		})
+	})
+}
+
+func isEnabled(env *moo.Environment) bool {
+	return env.Config.StringWithDefault(api.CfgUserProvisionSource, "") != ""
+}
+
+// ReadConfig 读取同步和映射规则的配置
+func ReadConfig(env *moo.Environment) (*Config, error) {
+	cfg := &Config{
+		SourceName: env.Config.StringWithDefault(api.CfgUserProvisionSourceName, "provision"),
+		Mapping: Mapping{
+			NameField:      env.Config.StringWithDefault(api.CfgUserProvisionNameField, "name"),
+			NicknameField:  env.Config.StringWithDefault(api.CfgUserProvisionNicknameField, "nickname"),
+			DisabledField:  env.Config.StringWithDefault(api.CfgUserProvisionDisabledField, ""),
+			UpdatedAtField: env.Config.StringWithDefault(api.CfgUserProvisionUpdatedAtField, ""),
+			Fields:         map[string]string{},
+			RoleField:      env.Config.StringWithDefault(api.CfgUserProvisionRoleField, ""),
+			RoleSeparator:  env.Config.StringWithDefault(api.CfgUserProvisionRoleSeparator, ","),
+			RoleMap:        map[string]string{},
+			DefaultRoles:   env.Config.StringsWithDefault(api.CfgUserProvisionRoles, nil),
+		},
+		DisableMissing: env.Config.BoolWithDefault(api.CfgUserProvisionDisableMissing, false),
+		Interval:       env.Config.DurationWithDefault(api.CfgUserProvisionInterval, 0),
+		FullInterval:   env.Config.DurationWithDefault(api.CfgUserProvisionFullInterval, 24*time.Hour),
+	}
+	if cfg.SourceName == "" {
+		return nil, errors.New("users.provision.source_name 不能为空")
+	}
+
+	env.Config.ForEachWithPrefix(api.CfgUserProvisionFieldPrefix, func(key string, value interface{}) {
+		key = strings.TrimPrefix(key, api.CfgUserProvisionFieldPrefix)
+		cfg.Mapping.Fields[key] = fmt.Sprint(value)
+	})
+	env.Config.ForEachWithPrefix(api.CfgUserProvisionRoleMapPrefix, func(key string, value interface{}) {
+		key = strings.TrimPrefix(key, api.CfgUserProvisionRoleMapPrefix)
+		cfg.Mapping.RoleMap[key] = fmt.Sprint(value)
+	})
+	return cfg, nil
+}
+
+// NewSource 按 users.provision.source 创建数据源, 支持 sql, file 和 http
+func NewSource(env *moo.Environment, conn *sql.DB) (Source, error) {
+	switch kind := env.Config.StringWithDefault(api.CfgUserProvisionSource, ""); kind {
+	case "sql", "db":
+		if drv := env.Config.StringWithDefault(api.CfgUserProvisionSQLDriver, ""); drv != "" {
+			var err error
+			conn, err = sql.Open(drv, env.Config.StringWithDefault(api.CfgUserProvisionSQLURL, ""))
+			if err != nil {
+				return nil, errors.Wrap(err, "连接外部用户数据库失败")
+			}
+		}
+		src := &SQLSource{
+			Conn:           conn,
+			ListSQL:        env.Config.StringWithDefault(api.CfgUserProvisionSQLList, ""),
+			IncrementalSQL: env.Config.StringWithDefault(api.CfgUserProvisionSQLIncremental, ""),
+			FindSQL:        env.Config.StringWithDefault(api.CfgUserProvisionSQLFind, ""),
+		}
+		if src.ListSQL == "" {
+			return nil, errors.New("users.provision.sql.list 没有配置")
+		}
+		return src, nil
+	case "file":
+		filename := env.Config.StringWithDefault(api.CfgUserProvisionFile, "users.csv")
+		if !filepath.IsAbs(filename) {
+			filename = env.Fs.FromData(filename)
+		}
+		return &FileSource{Filename: filename}, nil
+	case "http", "rest":
+		src := &HTTPSource{
+			Client: &http.Client{
+				Timeout: env.Config.DurationWithDefault(api.CfgUserProvisionHTTPTimeout, 30*time.Second),
+			},
+			URL:     env.Config.StringWithDefault(api.CfgUserProvisionHTTPURL, ""),
+			Headers: map[string]string{},
+		}
+		if src.URL == "" {
+			return nil, errors.New("users.provision.http.url 没有配置")
+		}
+		env.Config.ForEachWithPrefix(api.CfgUserProvisionHTTPHeaders, func(key string, value interface{}) {
+			key = strings.TrimPrefix(key, api.CfgUserProvisionHTTPHeaders)
+			src.Headers[key] = fmt.Sprint(value)
+		})
+		return src, nil
+	default:
+		return nil, errors.New("users.provision.source '" + kind + "' 不支持, 只支持 sql, file 和 http")
+	}
+}
+
+type handlers struct {
+	syncer *Syncer
+}
+
+func checkPermission(ctx context.Context) (api.User, error) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	ok, err := currentUser.HasPermission(ctx, PermissionProvision)
+	if err != nil {
+		return nil, errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return nil, ErrPermissionDenny
+	}
+	return currentUser, nil
+}
+
+func queryBool(r *http.Request, name string) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.NewError(http.StatusBadRequest, name+" '"+s+"' is invalid")
+	}
+	return b, nil
+}
+
+// Sync 立即执行一次同步, 参数 full 为 true 时做全量同步, dry_run 为 true 时只返回会做哪些修改
+func (h *handlers) Sync(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := checkPermission(ctx)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	full, err := queryBool(r, "full")
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	dryRun, err := queryBool(r, "dry_run")
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	report, err := h.syncer.Reconcile(ctx, currentUser, full, dryRun)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
+
+// LastReport 返回最近一次同步的结果
+func (h *handlers) LastReport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, err := checkPermission(ctx); err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+
+	report := h.syncer.LastReport()
+	if report == nil {
+		authn.ReturnError(w, r, "还没有执行过用户同步", http.StatusNotFound)
+		return
+	}
+	authn.ReturnJSON(w, r, report, http.StatusOK)
+}
@@ -0,0 +1,229 @@
+package provision
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+)
+
+// Record 经过 Mapping 转换后的外部用户
+type Record struct {
+	Name      string                 `json:"name"`
+	Nickname  string                 `json:"nickname"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Roles     []string               `json:"roles,omitempty"`
+	Disabled  bool                   `json:"disabled,omitempty"`
+	UpdatedAt time.Time              `json:"updated_at,omitempty"`
+}
+
+// Mapping 外部记录到用户的映射规则
+type Mapping struct {
+	// NameField 用户名的字段名，默认为 name
+	NameField string
+	// NicknameField 用户姓名的字段名，为空或没有值时用用户名
+	NicknameField string
+	// DisabledField 用户是否被禁用的字段名
+	DisabledField string
+	// UpdatedAtField 用户最后修改时间的字段名, 增量同步时用它过滤没有修改的用户
+	UpdatedAtField string
+
+	// Fields 外部字段名到用户属性名的映射, 为空时复制除上面几个字段以外的所有字段
+	Fields map[string]string
+
+	// RoleField 角色的字段名, 值可以是数组或用 RoleSeparator 分隔的字符串
+	RoleField     string
+	RoleSeparator string
+	// RoleMap 外部角色名到本地角色名的映射, 没有映射的角色按原名查找
+	RoleMap map[string]string
+	// DefaultRoles 新建用户时默认授予的角色
+	DefaultRoles []string
+}
+
+func (m *Mapping) nameField() string {
+	if m.NameField == "" {
+		return "name"
+	}
+	return m.NameField
+}
+
+// ToRecord 按规则将外部记录转换成用户
+func (m *Mapping) ToRecord(row Row) (*Record, error) {
+	name := strings.TrimSpace(toString(row[m.nameField()]))
+	if name == "" {
+		return nil, errors.New("记录中缺少用户名字段 '" + m.nameField() + "'")
+	}
+
+	record := &Record{
+		Name:   name,
+		Fields: map[string]interface{}{},
+	}
+	if m.NicknameField != "" {
+		record.Nickname = strings.TrimSpace(toString(row[m.NicknameField]))
+	}
+	if record.Nickname == "" {
+		record.Nickname = name
+	}
+
+	if m.DisabledField != "" {
+		disabled, err := toBool(row[m.DisabledField])
+		if err != nil {
+			return nil, errors.Wrap(err, "用户 '"+name+"' 的字段 '"+m.DisabledField+"' 不正确")
+		}
+		record.Disabled = disabled
+	}
+
+	if m.UpdatedAtField != "" {
+		updatedAt, err := toTime(row[m.UpdatedAtField])
+		if err != nil {
+			return nil, errors.Wrap(err, "用户 '"+name+"' 的字段 '"+m.UpdatedAtField+"' 不正确")
+		}
+		record.UpdatedAt = updatedAt
+	}
+
+	if len(m.Fields) > 0 {
+		for key, field := range m.Fields {
+			if value, ok := row[key]; ok && value != nil {
+				record.Fields[field] = value
+			}
+		}
+	} else {
+		for key, value := range row {
+			switch key {
+			case m.nameField(), m.NicknameField, m.DisabledField, m.UpdatedAtField, m.RoleField:
+				continue
+			}
+			if value != nil {
+				record.Fields[key] = value
+			}
+		}
+	}
+
+	if m.RoleField != "" {
+		record.Roles = m.roles(row[m.RoleField])
+	}
+	return record, nil
+}
+
+func (m *Mapping) roles(value interface{}) []string {
+	var names []string
+	switch v := value.(type) {
+	case nil:
+	case []string:
+		names = v
+	case []interface{}:
+		for _, a := range v {
+			names = append(names, toString(a))
+		}
+	default:
+		sep := m.RoleSeparator
+		if sep == "" {
+			sep = ","
+		}
+		names = strings.Split(toString(v), sep)
+	}
+
+	var results []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if mapped, ok := m.RoleMap[name]; ok {
+			name = mapped
+		}
+		if name != "" && !containsString(results, name) {
+			results = append(results, name)
+		}
+	}
+	return results
+}
+
+// StringFields 将属性都转为字符串, 供 api.UserSyncer 使用
+func (record *Record) StringFields() map[string]string {
+	results := map[string]string{}
+	for key, value := range record.Fields {
+		results[key] = toString(value)
+	}
+	return results
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return as.StringWithDefault(value, fmt.Sprint(value))
+	}
+}
+
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	}
+
+	s := strings.ToLower(strings.TrimSpace(toString(value)))
+	switch s {
+	case "", "0", "f", "false", "n", "no", "off", "enabled", "active":
+		return false, nil
+	case "1", "t", "true", "y", "yes", "on", "disabled", "inactive":
+		return true, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	}
+
+	s := strings.TrimSpace(toString(value))
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("'" + s + "' 不是有效的时间")
+}
+
+func containsString(list []string, v string) bool {
+	for _, a := range list {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
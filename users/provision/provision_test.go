@@ -0,0 +1,108 @@
+package provision
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToRecord(t *testing.T) {
+	mapping := &Mapping{
+		NameField:      "uid",
+		NicknameField:  "cn",
+		DisabledField:  "status",
+		UpdatedAtField: "modified",
+		Fields:         map[string]string{"mail": "email"},
+		RoleField:      "groups",
+		RoleSeparator:  ";",
+		RoleMap:        map[string]string{"ops": "operator", "guest": ""},
+	}
+
+	record, err := mapping.ToRecord(Row{
+		"uid":      " tom ",
+		"cn":       "Tom",
+		"status":   "disabled",
+		"modified": "2021-03-04 05:06:07",
+		"mail":     "tom@example.com",
+		"phone":    "123",
+		"groups":   "ops; admin;guest;ops",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Name != "tom" || record.Nickname != "Tom" || !record.Disabled {
+		t.Error("got", record)
+	}
+	if excepted := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local); !record.UpdatedAt.Equal(excepted) {
+		t.Error("got", record.UpdatedAt)
+	}
+	if !reflect.DeepEqual(record.Fields, map[string]interface{}{"email": "tom@example.com"}) {
+		t.Error("got", record.Fields)
+	}
+	if !reflect.DeepEqual(record.Roles, []string{"operator", "admin"}) {
+		t.Error("got", record.Roles)
+	}
+
+	if _, err := mapping.ToRecord(Row{"cn": "Tom"}); err == nil {
+		t.Error("want error got ok")
+	}
+	if _, err := mapping.ToRecord(Row{"uid": "tom", "status": "abc"}); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestToRecordCopyAllFields(t *testing.T) {
+	mapping := &Mapping{NicknameField: "nickname", RoleField: "roles"}
+	record, err := mapping.ToRecord(Row{
+		"name":  "jerry",
+		"phone": "123",
+		"roles": []interface{}{"admin", "ops"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Nickname != "jerry" {
+		t.Error("got", record.Nickname)
+	}
+	if !reflect.DeepEqual(record.Fields, map[string]interface{}{"phone": "123"}) {
+		t.Error("got", record.Fields)
+	}
+	if !reflect.DeepEqual(record.Roles, []string{"admin", "ops"}) {
+		t.Error("got", record.Roles)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	rows, err := ReadCSV(strings.NewReader("\uFEFFname, nickname,mail\r\ntom,Tom,tom@example.com\r\njerry,\"Jerry, J\",\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	excepted := []Row{
+		{"name": "tom", "nickname": "Tom", "mail": "tom@example.com"},
+		{"name": "jerry", "nickname": "Jerry, J", "mail": ""},
+	}
+	if !reflect.DeepEqual(rows, excepted) {
+		t.Error("got", rows)
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	for _, s := range []string{
+		`[{"name":"tom","disabled":true}]`,
+		`{"data":[{"name":"tom","disabled":true}]}`,
+	} {
+		rows, err := ReadJSON([]byte(s))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(rows, []Row{{"name": "tom", "disabled": true}}) {
+			t.Error("got", rows)
+		}
+	}
+
+	if _, err := ReadJSON([]byte(`abc`)); err == nil {
+		t.Error("want error got ok")
+	}
+}
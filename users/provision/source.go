@@ -0,0 +1,262 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+)
+
+// ErrFindNotSupported 数据源没有配置按用户名查询的方法
+var ErrFindNotSupported = errors.New("数据源不支持按用户名查询")
+
+// Row 数据源中的一条原始记录, 经过 Mapping 转换后才是用户
+type Row map[string]interface{}
+
+// Source 外部用户目录
+type Source interface {
+	// List 读取用户列表, since 为零值时返回全部用户, 否则可以只返回 since 之后有修改的用户
+	List(ctx context.Context, since time.Time) ([]Row, error)
+
+	// Find 按用户名查询一个用户, 没有时返回 sql.ErrNoRows
+	Find(ctx context.Context, name string) (Row, error)
+}
+
+// SQLSource 用 SQL 语句从数据库中读取用户
+type SQLSource struct {
+	Conn *sql.DB
+
+	// ListSQL 查询全部用户
+	ListSQL string
+	// IncrementalSQL 查询 $1 之后有修改的用户, 为空时增量同步也读取全部用户
+	IncrementalSQL string
+	// FindSQL 按用户名 $1 查询一个用户
+	FindSQL string
+}
+
+func (src *SQLSource) List(ctx context.Context, since time.Time) ([]Row, error) {
+	if !since.IsZero() && src.IncrementalSQL != "" {
+		return src.query(ctx, src.IncrementalSQL, since)
+	}
+	return src.query(ctx, src.ListSQL)
+}
+
+func (src *SQLSource) Find(ctx context.Context, name string) (Row, error) {
+	if src.FindSQL == "" {
+		return nil, ErrFindNotSupported
+	}
+	rows, err := src.query(ctx, src.FindSQL, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return rows[0], nil
+}
+
+func (src *SQLSource) query(ctx context.Context, sqlStr string, args ...interface{}) ([]Row, error) {
+	rows, err := src.Conn.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询外部用户失败")
+	}
+	defer util.CloseWith(rows)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Wrap(err, "查询外部用户失败")
+	}
+
+	var results []Row
+	for rows.Next() {
+		var values = make([]interface{}, len(columns))
+		var args = make([]interface{}, len(columns))
+		for idx := range values {
+			args[idx] = &values[idx]
+		}
+		if err := rows.Scan(args...); err != nil {
+			return nil, errors.Wrap(err, "读取外部用户失败")
+		}
+
+		row := Row{}
+		for idx, column := range columns {
+			if bs, ok := values[idx].([]byte); ok {
+				row[column] = string(bs)
+			} else {
+				row[column] = values[idx]
+			}
+		}
+		results = append(results, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "读取外部用户失败")
+	}
+	return results, nil
+}
+
+// FileSource 从数据目录下的 CSV 或 JSON 文件中读取用户, 文件由外部系统定期生成
+//
+// CSV 文件的第一行是列名, JSON 文件是一个对象数组
+type FileSource struct {
+	Filename string
+}
+
+func (src *FileSource) List(ctx context.Context, since time.Time) ([]Row, error) {
+	data, err := ioutil.ReadFile(src.Filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取用户文件失败")
+	}
+
+	switch strings.ToLower(filepath.Ext(src.Filename)) {
+	case ".csv":
+		return ReadCSV(bytes.NewReader(data))
+	case ".json":
+		return ReadJSON(data)
+	default:
+		return nil, errors.New("不支持的用户文件格式 '" + src.Filename + "', 只支持 csv 和 json")
+	}
+}
+
+func (src *FileSource) Find(ctx context.Context, name string) (Row, error) {
+	return nil, ErrFindNotSupported
+}
+
+// ReadCSV 读取 CSV 格式的用户列表, 第一行为列名, UTF-8 的 BOM 会被忽略
+func ReadCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "读取用户文件失败")
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\uFEFF")
+	}
+	for idx := range header {
+		header[idx] = strings.TrimSpace(header[idx])
+	}
+
+	var results []Row
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "读取用户文件失败")
+		}
+
+		row := Row{}
+		for idx, value := range record {
+			if idx < len(header) && header[idx] != "" {
+				row[header[idx]] = value
+			}
+		}
+		results = append(results, row)
+	}
+	return results, nil
+}
+
+// ReadJSON 读取 JSON 格式的用户列表, 可以是对象数组或 {"data": [...]}
+func ReadJSON(data []byte) ([]Row, error) {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+
+	var results []Row
+	if err := json.Unmarshal(data, &results); err == nil {
+		return results, nil
+	}
+
+	var wrapped struct {
+		Data []Row `json:"data"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, errors.Wrap(err, "用户列表不是有效的 JSON")
+	}
+	return wrapped.Data, nil
+}
+
+// HTTPSource 从 REST 接口读取用户
+//
+// 全量时 GET URL, 增量时 GET URL?since=<RFC3339>, 按用户名查询时 GET URL?name=<name>
+type HTTPSource struct {
+	Client  *http.Client
+	URL     string
+	Headers map[string]string
+}
+
+func (src *HTTPSource) List(ctx context.Context, since time.Time) ([]Row, error) {
+	params := url.Values{}
+	if !since.IsZero() {
+		params.Set("since", since.Format(time.RFC3339))
+	}
+	return src.get(ctx, params)
+}
+
+func (src *HTTPSource) Find(ctx context.Context, name string) (Row, error) {
+	rows, err := src.get(ctx, url.Values{"name": []string{name}})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return rows[0], nil
+}
+
+func (src *HTTPSource) get(ctx context.Context, params url.Values) ([]Row, error) {
+	u := src.URL
+	if len(params) > 0 {
+		if strings.Contains(u, "?") {
+			u = u + "&" + params.Encode()
+		} else {
+			u = u + "?" + params.Encode()
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建请求失败")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	for key, value := range src.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := src.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取外部用户失败")
+	}
+	defer util.CloseWith(resp.Body)
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取外部用户失败")
+	}
+	if resp.StatusCode == http.StatusNotFound && params.Get("name") != "" {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("读取外部用户失败: " + resp.Status + "\r\n" + string(data))
+	}
+	return ReadJSON(data)
+}
@@ -0,0 +1,599 @@
+package provision
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authz"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// PermissionProvision 执行用户同步的权限
+const PermissionProvision = "um.users.provision"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionProvision, Title: "从外部数据源同步用户", Group: "用户管理"})
+}
+
+var (
+	ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有执行用户同步的权限")
+	ErrSyncRunning     = errors.NewError(http.StatusConflict, "用户同步正在进行中")
+	ErrEmptySource     = errors.New("外部目录中没有任何用户, 为了安全不做全量同步")
+)
+
+const (
+	ActionCreateUser  = "create_user"
+	ActionUpdateUser  = "update_user"
+	ActionDisableUser = "disable_user"
+	ActionEnableUser  = "enable_user"
+	ActionRecoverUser = "recover_user"
+	ActionAddRole     = "add_role"
+	ActionRemoveRole  = "remove_role"
+	ActionSkip        = "skip"
+)
+
+// 同步时保存在用户属性中的信息，用来区分哪些是同步加上的
+const (
+	attrRoles    = "provision_roles"
+	attrDisabled = "provision_disabled"
+)
+
+// deletedTag 与 userservices 中软删除用户时加在用户名后的标记一致
+const deletedTag = "(deleted:"
+
+// Action 同步时对用户做的一个修改
+type Action struct {
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Conflict 外部用户与本地用户冲突, 这些用户不会被同步
+type Conflict struct {
+	Username string `json:"username"`
+	Source   string `json:"source,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// Report 一次同步的结果, dry run 时只报告会做哪些修改
+type Report struct {
+	DryRun     bool       `json:"dry_run"`
+	Full       bool       `json:"full"`
+	Since      time.Time  `json:"since,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+	Total      int        `json:"total"`
+	Created    int        `json:"created"`
+	Updated    int        `json:"updated"`
+	Disabled   int        `json:"disabled"`
+	Enabled    int        `json:"enabled"`
+	Recovered  int        `json:"recovered"`
+	Failed     int        `json:"failed"`
+	Conflicts  []Conflict `json:"conflicts,omitempty"`
+	Actions    []Action   `json:"actions"`
+}
+
+func (report *Report) add(action Action) {
+	report.Actions = append(report.Actions, action)
+}
+
+func (report *Report) conflict(c Conflict) {
+	report.Conflicts = append(report.Conflicts, c)
+}
+
+func (report *Report) String() string {
+	return "新建 " + strconv.Itoa(report.Created) +
+		", 更新 " + strconv.Itoa(report.Updated) +
+		", 禁用 " + strconv.Itoa(report.Disabled) +
+		", 启用 " + strconv.Itoa(report.Enabled) +
+		", 恢复 " + strconv.Itoa(report.Recovered) +
+		", 冲突 " + strconv.Itoa(len(report.Conflicts)) +
+		", 失败 " + strconv.Itoa(report.Failed)
+}
+
+// Config 同步的配置
+type Config struct {
+	// SourceName 同步创建的用户的来源(users.source), 只有这个来源的用户才会被更新或禁用
+	SourceName string
+	Mapping    Mapping
+
+	// DisableMissing 全量同步时禁用外部目录中已不存在的用户
+	DisableMissing bool
+	// Interval 增量同步的间隔, 为 0 时不自动同步
+	Interval time.Duration
+	// FullInterval 全量同步的间隔, 为 0 时不自动全量同步
+	FullInterval time.Duration
+}
+
+// Syncer 将外部目录中的用户同步到 moo_users 中
+type Syncer struct {
+	logger log.Logger
+	cfg    *Config
+	source Source
+	users  *userservices.Service
+
+	lock     sync.Mutex
+	running  bool
+	last     *Report
+	syncedAt time.Time
+}
+
+func NewSyncer(cfg *Config, source Source, users *userservices.Service, logger log.Logger) *Syncer {
+	return &Syncer{
+		logger: logger,
+		cfg:    cfg,
+		source: source,
+		users:  users,
+	}
+}
+
+// LastReport 返回最近一次同步的结果
+func (s *Syncer) LastReport() *Report {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.last
+}
+
+// Read 从外部目录中查询一个用户的姓名和属性, 实现了 api.UserSyncer
+func (s *Syncer) Read(ctx context.Context, name string) (string, map[string]string, error) {
+	row, err := s.source.Find(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if row == nil {
+		return "", nil, sql.ErrNoRows
+	}
+	record, err := s.cfg.Mapping.ToRecord(row)
+	if err != nil {
+		return "", nil, err
+	}
+	return record.Nickname, record.StringFields(), nil
+}
+
+// Reconcile 执行一次同步，currentUser 为执行同步的用户
+//
+// full 为 false 时只同步上次成功同步后有修改的用户, 从来没有成功同步过时总是做全量同步。
+// 只有全量同步才会禁用外部目录中已不存在的用户。
+func (s *Syncer) Reconcile(ctx context.Context, currentUser api.User, full, dryRun bool) (*Report, error) {
+	s.lock.Lock()
+	if s.running {
+		s.lock.Unlock()
+		return nil, ErrSyncRunning
+	}
+	s.running = true
+	since := s.syncedAt
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.running = false
+		s.lock.Unlock()
+	}()
+
+	if full || since.IsZero() {
+		full = true
+		since = time.Time{}
+	}
+
+	report := &Report{DryRun: dryRun, Full: full, Since: since, StartedAt: time.Now()}
+	rows, err := s.source.List(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	if full && len(rows) == 0 {
+		return nil, ErrEmptySource
+	}
+
+	reqCtx := s.users.NewContext(ctx, currentUser, "")
+	state, err := s.load(reqCtx, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, row := range rows {
+		record, err := s.cfg.Mapping.ToRecord(row)
+		if err != nil {
+			report.Failed++
+			report.add(Action{Type: ActionSkip, Error: err.Error()})
+			continue
+		}
+		if !full && !record.UpdatedAt.IsZero() && record.UpdatedAt.Before(since) {
+			continue
+		}
+		report.Total++
+
+		key := strings.ToLower(record.Name)
+		if seen[key] {
+			report.conflict(Conflict{Username: record.Name, Reason: "外部目录中有重复的用户"})
+			continue
+		}
+		seen[key] = true
+
+		if err := s.reconcile(reqCtx, state, report, record); err != nil {
+			report.Failed++
+			report.add(Action{Type: ActionSkip, Username: record.Name, Error: err.Error()})
+			s.logger.Warn("同步用户失败", log.String("username", record.Name), log.Error(err))
+		}
+	}
+
+	if full && s.cfg.DisableMissing {
+		names := make([]string, 0, len(state.users))
+		for name := range state.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			old := state.users[name]
+			if old.Disabled || old.IsBuiltin() {
+				continue
+			}
+			if err := s.disableUser(reqCtx, report, old, "外部目录中已不存在该用户"); err != nil {
+				report.Failed++
+				report.add(Action{Type: ActionSkip, Username: old.Name, Error: err.Error()})
+				s.logger.Warn("禁用用户失败", log.String("username", old.Name), log.Error(err))
+			}
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	if !dryRun {
+		if err := s.logRecord(reqCtx, &api.OperationLog{
+			Type:       "user_provision",
+			Successful: report.Failed == 0,
+			Content:    "用户同步: " + report.String(),
+		}); err != nil {
+			return nil, err
+		}
+
+		s.lock.Lock()
+		s.last = report
+		// 有失败时不前移增量同步的起点, 下次还会重试这些用户
+		if report.Failed == 0 {
+			s.syncedAt = report.StartedAt
+		}
+		s.lock.Unlock()
+	}
+	return report, nil
+}
+
+func (s *Syncer) logRecord(ctx *userservices.RequestContext, ol *api.OperationLog) error {
+	if ctx.CurrentUser != nil {
+		ol.UserID = ctx.CurrentUser.ID()
+		ol.Username = ctx.CurrentUser.Name()
+	}
+	if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, ol); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
+
+type syncState struct {
+	dryRun bool
+
+	// users 来源为 SourceName 的用户
+	users map[string]*usermodels.User
+	// deleted 来源为 SourceName 且已被删除的用户, 按删除前的用户名索引
+	deleted map[string]*usermodels.User
+	// others 其它来源的用户
+	others map[string]*usermodels.User
+	// nicknames 所有用户的姓名
+	nicknames map[string]string
+
+	roles map[string]*usermodels.Role
+}
+
+func (s *Syncer) load(ctx *userservices.RequestContext, dryRun bool) (*syncState, error) {
+	state := &syncState{
+		dryRun:    dryRun,
+		users:     map[string]*usermodels.User{},
+		deleted:   map[string]*usermodels.User{},
+		others:    map[string]*usermodels.User{},
+		nicknames: map[string]string{},
+		roles:     map[string]*usermodels.Role{},
+	}
+
+	userList, err := ctx.Users.GetUsers(ctx.Ctx, &usermodels.UserQueryParams{}, 0, 0, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	for idx := range userList {
+		u := &userList[idx]
+		state.nicknames[u.Nickname] = strings.ToLower(u.Name)
+
+		if idx := strings.Index(u.Name, deletedTag); idx >= 0 {
+			if u.Source == s.cfg.SourceName {
+				state.deleted[strings.ToLower(u.Name[:idx])] = u
+			}
+			continue
+		}
+		if u.Source == s.cfg.SourceName {
+			state.users[strings.ToLower(u.Name)] = u
+		} else {
+			state.others[strings.ToLower(u.Name)] = u
+		}
+	}
+
+	roleList, err := ctx.Users.GetRoles(ctx.Ctx, "", 0, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询角色失败")
+	}
+	for idx := range roleList {
+		state.roles[roleList[idx].Name] = &roleList[idx]
+	}
+	return state, nil
+}
+
+// existingRoles 过滤掉本地不存在的角色
+func (state *syncState) existingRoles(names []string) []string {
+	found := []string{}
+	for _, name := range names {
+		if state.roles[name] != nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// nicknameConflict 姓名已被其它用户使用时返回 true
+func (state *syncState) nicknameConflict(nickname, username string) bool {
+	owner, ok := state.nicknames[nickname]
+	return ok && owner != strings.ToLower(username)
+}
+
+func (s *Syncer) reconcile(ctx *userservices.RequestContext, state *syncState, report *Report, record *Record) error {
+	key := strings.ToLower(record.Name)
+	if other := state.others[key]; other != nil {
+		report.conflict(Conflict{Username: record.Name, Source: other.Source, Reason: "已存在其它来源的同名用户"})
+		return nil
+	}
+
+	old := state.users[key]
+	if old == nil {
+		if deleted := state.deleted[key]; deleted != nil && !record.Disabled {
+			recovered, err := s.recoverUser(ctx, state, report, deleted, record)
+			if err != nil {
+				return err
+			}
+			old = recovered
+		}
+	}
+	if old == nil {
+		return s.createUser(ctx, state, report, record)
+	}
+	return s.updateUser(ctx, state, report, old, record)
+}
+
+func (s *Syncer) createUser(ctx *userservices.RequestContext, state *syncState, report *Report, record *Record) error {
+	if record.Disabled {
+		report.add(Action{Type: ActionSkip, Username: record.Name, Message: "外部目录中的用户已被禁用"})
+		return nil
+	}
+
+	nickname := record.Nickname
+	if state.nicknameConflict(nickname, record.Name) {
+		report.conflict(Conflict{Username: record.Name, Reason: "姓名 '" + nickname + "' 已被其它用户使用, 改为 '" + nickname + " - " + record.Name + "'"})
+		nickname = nickname + " - " + record.Name
+	}
+
+	roleNames := state.existingRoles(record.Roles)
+	user := &usermodels.User{
+		Name:       record.Name,
+		Nickname:   nickname,
+		Source:     s.cfg.SourceName,
+		CanLogin:   true,
+		Attributes: map[string]interface{}{},
+	}
+	for k, v := range record.Fields {
+		user.Attributes[k] = v
+	}
+	user.Attributes[attrRoles] = roleNames
+
+	report.Created++
+	report.add(Action{Type: ActionCreateUser, Username: record.Name})
+	for _, name := range roleNames {
+		report.add(Action{Type: ActionAddRole, Username: record.Name, Role: name})
+	}
+	state.nicknames[nickname] = strings.ToLower(record.Name)
+	if state.dryRun {
+		return nil
+	}
+
+	roles := append(append([]string{}, roleNames...), s.cfg.Mapping.DefaultRoles...)
+	userID, err := s.users.CreateUserWithRoleNames(ctx, user, roles, true)
+	if err != nil {
+		return err
+	}
+	user.ID = userID
+	state.users[strings.ToLower(user.Name)] = user
+	return nil
+}
+
+// recoverUser 恢复被删除的用户, 恢复后再按外部记录更新它
+func (s *Syncer) recoverUser(ctx *userservices.RequestContext, state *syncState, report *Report, deleted *usermodels.User, record *Record) (*usermodels.User, error) {
+	report.Recovered++
+	report.add(Action{Type: ActionRecoverUser, Username: record.Name})
+	if state.dryRun {
+		recovered := *deleted
+		recovered.Name = record.Name
+		recovered.Disabled = false
+		return &recovered, nil
+	}
+
+	if err := s.users.RecoveryUser(ctx, deleted.ID); err != nil {
+		return nil, err
+	}
+	recovered, err := ctx.Users.GetUserByID(ctx.Ctx, deleted.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	if !strings.EqualFold(recovered.Name, record.Name) {
+		return nil, errors.New("恢复用户 '" + record.Name + "' 失败, 用户名已被占用")
+	}
+	delete(state.deleted, strings.ToLower(record.Name))
+	state.users[strings.ToLower(record.Name)] = recovered
+	return recovered, nil
+}
+
+func (s *Syncer) updateUser(ctx *userservices.RequestContext, state *syncState, report *Report, old *usermodels.User, record *Record) error {
+	if old.IsBuiltin() {
+		report.add(Action{Type: ActionSkip, Username: old.Name, Message: "内置用户不会被同步"})
+		return nil
+	}
+
+	if record.Disabled {
+		if old.Disabled {
+			return nil
+		}
+		return s.disableUser(ctx, report, old, "外部目录中的用户已被禁用")
+	}
+
+	if old.Attributes == nil {
+		old.Attributes = map[string]interface{}{}
+	}
+
+	var actions []Action
+	changed := false
+	if old.Nickname != record.Nickname {
+		if state.nicknameConflict(record.Nickname, old.Name) {
+			report.conflict(Conflict{Username: old.Name, Reason: "姓名 '" + record.Nickname + "' 已被其它用户使用, 保留原姓名"})
+		} else {
+			delete(state.nicknames, old.Nickname)
+			state.nicknames[record.Nickname] = strings.ToLower(old.Name)
+			old.Nickname = record.Nickname
+			changed = true
+		}
+	}
+	for k, v := range record.Fields {
+		if toString(old.Attributes[k]) != toString(v) {
+			old.Attributes[k] = v
+			changed = true
+		}
+	}
+
+	roleNames := state.existingRoles(record.Roles)
+	oldRoles := as.ToStrings(old.Attributes[attrRoles])
+	var addRoles, removeRoles []int64
+	for _, name := range roleNames {
+		if !containsString(oldRoles, name) {
+			addRoles = append(addRoles, state.roles[name].ID)
+			actions = append(actions, Action{Type: ActionAddRole, Username: old.Name, Role: name})
+		}
+	}
+	for _, name := range oldRoles {
+		if containsString(roleNames, name) {
+			continue
+		}
+		actions = append(actions, Action{Type: ActionRemoveRole, Username: old.Name, Role: name})
+		if role := state.roles[name]; role != nil {
+			removeRoles = append(removeRoles, role.ID)
+		}
+	}
+	if len(actions) > 0 {
+		old.Attributes[attrRoles] = roleNames
+	}
+
+	// 只启用由同步禁用的用户, 管理员手工禁用的用户保持不变
+	disabledBySync, _ := toBool(old.Attributes[attrDisabled])
+	enable := old.Disabled && disabledBySync
+	if enable {
+		delete(old.Attributes, attrDisabled)
+	}
+
+	if !changed && len(actions) == 0 && !enable {
+		return nil
+	}
+	if changed || len(actions) > 0 {
+		report.Updated++
+		report.add(Action{Type: ActionUpdateUser, Username: old.Name})
+		for _, action := range actions {
+			report.add(action)
+		}
+	}
+	if enable {
+		report.Enabled++
+		report.add(Action{Type: ActionEnableUser, Username: old.Name})
+	}
+	if state.dryRun {
+		return nil
+	}
+
+	err := ctx.InTransaction(func(ctx *userservices.RequestContext) error {
+		// 直接用 UserDao 更新，避免已有的密码被再次加密
+		if _, err := ctx.Users.UserDao.UpdateUser(ctx.Ctx, old.ID, old); err != nil {
+			return errors.Wrap(err, "更新用户失败")
+		}
+		for _, roleID := range addRoles {
+			if err := ctx.Users.UserDao.AddRoleToUser(ctx.Ctx, old.ID, roleID); err != nil {
+				return errors.Wrap(err, "授于角色失败")
+			}
+		}
+		for _, roleID := range removeRoles {
+			if err := ctx.Users.UserDao.RemoveRoleFromUser(ctx.Ctx, old.ID, roleID); err != nil {
+				return errors.Wrap(err, "收回角色失败")
+			}
+		}
+		ctx.Notify(api.BusUserChanged, api.ChangeUpdated, old.ID)
+		if !changed && len(actions) == 0 {
+			return nil
+		}
+
+		var changes = make([]string, 0, len(actions))
+		for _, action := range actions {
+			changes = append(changes, action.Type+" "+action.Role)
+		}
+		return s.logRecord(ctx, &api.OperationLog{
+			Type:       "update_user",
+			Successful: true,
+			Content:    "用户同步: 更新用户 " + old.Name + " " + strings.Join(changes, ", "),
+			Fields:     &api.OperationLogRecord{ObjectType: "user", ObjectID: old.ID},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if enable {
+		return s.users.EnableUser(ctx, old.ID)
+	}
+	return nil
+}
+
+func (s *Syncer) disableUser(ctx *userservices.RequestContext, report *Report, old *usermodels.User, reason string) error {
+	report.Disabled++
+	report.add(Action{Type: ActionDisableUser, Username: old.Name, Message: reason})
+	if report.DryRun {
+		return nil
+	}
+
+	if old.Attributes == nil {
+		old.Attributes = map[string]interface{}{}
+	}
+	old.Attributes[attrDisabled] = true
+
+	// 先标记是由同步禁用的, 以后外部目录中用户恢复时才会自动启用它
+	if _, err := ctx.Users.UserDao.UpdateUser(ctx.Ctx, old.ID, old); err != nil {
+		return errors.Wrap(err, "更新用户失败")
+	}
+	if err := s.users.DisableUser(ctx, old.ID); err != nil {
+		return err
+	}
+	old.Disabled = true
+	return nil
+}
@@ -0,0 +1,204 @@
+package recovery
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/urlutil"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/authn"
+	"github.com/runner-mei/moo/components/mail"
+	"github.com/runner-mei/moo/db"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserRecoveryDisabled, false) {
+			return moo.None
+		}
+		return moo.Provide(func(env *moo.Environment, model db.InModelFactory, users *userservices.Service, userManager api.UserManager, sender mail.Sender, loginManager *authn.LoginManager) (*Service, error) {
+			return NewService(env, users, userManager, usermodels.NewUserTokenDao(model.Factory.SessionReference()), sender, loginManager)
+		})
+	})
+
+	moo.On(func(env *moo.Environment) moo.Option {
+		if env.Config.BoolWithDefault(api.CfgUserRecoveryDisabled, false) {
+			return moo.None
+		}
+		return moo.Invoke(func(env *moo.Environment, svc *Service, renderer *authn.Renderer, httpSrv *moo.HTTPServer, logger log.Logger) {
+			// 不能发送邮件时不显示 "忘记密码" 的链接, 也不注册相关的页面
+			if !svc.Enabled() {
+				logger.Warn("password recovery is disabled", log.Error(svc.checkDelivery()))
+				return
+			}
+			renderer.SetGlobal("forgot_password_url", urlutil.Join(env.DaemonUrlPath, "sessions/forgot_password"))
+
+			h := &handlers{env: env, svc: svc, renderer: renderer, logger: logger.Named("recovery")}
+
+			sessionuiMux := httpSrv.Engine().Group("/sessions")
+			sessionuiMux.GET("/forgot_password", loong.WrapContextHandler(h.ForgotPasswordGet))
+			sessionuiMux.POST("/forgot_password", loong.WrapContextHandler(h.ForgotPasswordPost))
+			sessionuiMux.GET("/reset_password", loong.WrapContextHandler(h.resetGet(usermodels.UserTokenResetPassword)))
+			sessionuiMux.POST("/reset_password", loong.WrapContextHandler(h.resetPost(usermodels.UserTokenResetPassword)))
+			sessionuiMux.GET("/activate", loong.WrapContextHandler(h.resetGet(usermodels.UserTokenActivate)))
+			sessionuiMux.POST("/activate", loong.WrapContextHandler(h.resetPost(usermodels.UserTokenActivate)))
+
+			mux := httpSrv.Engine().Group("api/users", httpSrv.AuthMiddlewares())
+			mux.POST("/send_activation", loong.WrapContextHandler(h.SendActivation))
+			logger.Info("password recovery started")
+		})
+	})
+}
+
+type handlers struct {
+	env      *moo.Environment
+	svc      *Service
+	renderer *authn.Renderer
+	logger   log.Logger
+}
+
+func (h *handlers) render(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	if err := h.renderer.RenderPage(w, r, name, data); err != nil {
+		h.logger.Warn("生成页面 '"+name+"' 出错", log.Error(err))
+	}
+}
+
+func (h *handlers) ForgotPasswordGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, "forgot_password.html", map[string]interface{}{
+		"title":   "忘记密码",
+		"service": r.URL.Query().Get("service"),
+	})
+}
+
+func (h *handlers) ForgotPasswordPost(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"title": "忘记密码",
+	}
+	if err := r.ParseForm(); err != nil {
+		data["errorMessage"] = err.Error()
+		h.render(w, r, "forgot_password.html", data)
+		return
+	}
+	account := r.Form.Get("account")
+	data["account"] = account
+	data["service"] = r.Form.Get("service")
+
+	if err := h.svc.RequestPasswordReset(ctx, account, authn.RealIP(r)); err != nil {
+		h.logger.Warn("发送重置密码的邮件失败", log.String("account", account), log.Error(err))
+		if code := errors.HTTPCode(err); code == http.StatusBadRequest || code == http.StatusTooManyRequests {
+			data["errorMessage"] = err.Error()
+		} else {
+			data["errorMessage"] = "发送邮件失败，请联系管理员"
+		}
+		h.render(w, r, "forgot_password.html", data)
+		return
+	}
+	data["successMessage"] = "如果该帐号存在并且设置了邮箱，我们已经发送了重置密码的邮件，请查收"
+	h.render(w, r, "forgot_password.html", data)
+}
+
+// SendActivation 管理员给用户发送激活邮件, 参数 id 为用户的 id
+func (h *handlers) SendActivation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	currentUser, err := api.ReadUserFromContext(ctx)
+	if err != nil {
+		authn.ReturnError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	s := r.URL.Query().Get("id")
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		authn.ReturnError(w, r, "id '"+s+"' is invalid", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SendActivation(ctx, currentUser, id); err != nil {
+		authn.ReturnError(w, r, err.Error(), errors.HTTPCode(err))
+		return
+	}
+	authn.ReturnJSON(w, r, "OK", http.StatusOK)
+}
+
+func titleOf(purpose string) string {
+	if purpose == usermodels.UserTokenActivate {
+		return "激活帐号"
+	}
+	return "重置密码"
+}
+
+func actionOf(purpose string) string {
+	if purpose == usermodels.UserTokenActivate {
+		return "activate"
+	}
+	return "reset_password"
+}
+
+func (h *handlers) resetGet(purpose string) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		data := map[string]interface{}{
+			"title":  titleOf(purpose),
+			"action": actionOf(purpose),
+			"token":  token,
+		}
+		if _, err := h.svc.Check(ctx, token, purpose); err != nil {
+			data["errorMessage"] = err.Error()
+		}
+		h.render(w, r, "reset_password.html", data)
+	}
+}
+
+func (h *handlers) resetPost(purpose string) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{
+			"title":  titleOf(purpose),
+			"action": actionOf(purpose),
+		}
+		if err := r.ParseForm(); err != nil {
+			data["errorMessage"] = err.Error()
+			h.render(w, r, "reset_password.html", data)
+			return
+		}
+		token := r.Form.Get("token")
+		data["token"] = token
+
+		newPassword := r.Form.Get("new_password")
+		if newPassword == "" {
+			data["errorMessage"] = "新密码不能为空"
+			h.render(w, r, "reset_password.html", data)
+			return
+		}
+		if newPassword != r.Form.Get("confirm_password") {
+			data["errorMessage"] = "两次输入的新密码不一致"
+			h.render(w, r, "reset_password.html", data)
+			return
+		}
+
+		var err error
+		if purpose == usermodels.UserTokenActivate {
+			err = h.svc.Activate(ctx, token, newPassword)
+		} else {
+			err = h.svc.ResetPassword(ctx, token, newPassword)
+		}
+		if err != nil {
+			h.logger.Warn(titleOf(purpose)+"失败", log.Error(err))
+			data["errorMessage"] = err.Error()
+			h.render(w, r, "reset_password.html", data)
+			return
+		}
+
+		if purpose == usermodels.UserTokenActivate {
+			data["successMessage"] = "帐号已激活，请用新密码登录"
+		} else {
+			data["successMessage"] = "密码已重置，请用新密码登录"
+		}
+		h.render(w, r, "reset_password.html", data)
+	}
+}
@@ -0,0 +1,433 @@
+package recovery
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/as"
+	"github.com/runner-mei/goutils/urlutil"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	authnservices "github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/authz"
+	"github.com/runner-mei/moo/components/mail"
+	userservices "github.com/runner-mei/moo/users/services"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+// PermissionSendActivation 给其它用户发送激活邮件的权限
+const PermissionSendActivation = "um.users.send_activation"
+
+func init() {
+	authz.Register(authz.PermissionMeta{ID: PermissionSendActivation, Title: "发送帐号激活邮件", Group: "用户管理"})
+}
+
+var (
+	ErrTokenInvalid    = errors.NewError(http.StatusBadRequest, "链接无效")
+	ErrTokenExpired    = errors.NewError(http.StatusBadRequest, "链接已过期")
+	ErrTokenUsed       = errors.NewError(http.StatusBadRequest, "链接已经使用过了")
+	ErrEmailMissing    = errors.NewError(http.StatusBadRequest, "用户没有设置邮箱")
+	ErrPermissionDenny = errors.NewError(http.StatusForbidden, "没有发送激活邮件的权限")
+	ErrTooManyRequests = errors.NewError(http.StatusTooManyRequests, "请求太频繁，请稍后再试")
+
+	// ErrBaseURLMissing 邮件中的链接不能用请求中的 Host 来生成, 它可以被伪造, 所以必须配置
+	ErrBaseURLMissing = errors.NewError(http.StatusInternalServerError, "没有配置 '"+api.CfgUserRecoveryBaseURL+"'，不能发送邮件")
+	// ErrSenderMissing 邮件只输出到日志中时不能发送带令牌的链接, 否则看得到日志的人就可以重置别人的密码
+	ErrSenderMissing = errors.NewError(http.StatusInternalServerError, "没有配置邮件发送方式 '"+api.CfgMailSender+"'(smtp 或 file)，不能发送邮件")
+)
+
+// SessionTerminator 终止用户的会话, 由 authn.LoginManager 实现
+type SessionTerminator interface {
+	TerminateSessions(ctx context.Context, username, address, reason, operator string) (int, error)
+}
+
+// Service 通过邮件找回密码和激活帐号
+type Service struct {
+	logger          log.Logger
+	users           *userservices.Service
+	userManager     api.UserManager
+	tokens          usermodels.UserTokenDao
+	sender          mail.Sender
+	sessions        SessionTerminator
+	signer          signer
+	baseURL         string
+	resetExpires    time.Duration
+	activateExpires time.Duration
+
+	// 重置密码的请求是不需要登录的, 要限制次数, 防止被用来给别人发送大量的邮件
+	requests        authnservices.FailCounter
+	accountRequests int
+	addressRequests int
+}
+
+func NewService(env *moo.Environment, users *userservices.Service, userManager api.UserManager, tokens usermodels.UserTokenDao, sender mail.Sender, sessions SessionTerminator) (*Service, error) {
+	logger := env.Logger.Named("recovery")
+
+	secret := env.Config.StringWithDefault(api.CfgUserRecoverySecretKey, env.Config.StringWithDefault(api.CfgUserAppSecret, ""))
+	var secretKey = []byte(secret)
+	if secret == "" {
+		secretKey = make([]byte, 32)
+		if _, err := rand.Read(secretKey); err != nil {
+			return nil, errors.Wrap(err, "生成签名密钥失败")
+		}
+		logger.Warn("'" + api.CfgUserRecoverySecretKey + "' 没有配置，使用随机的密钥，重启后已发出的链接将失效")
+	}
+
+	baseURL := env.Config.StringWithDefault(api.CfgUserRecoveryBaseURL, env.Config.StringWithDefault(api.CfgHomeURL, ""))
+	if baseURL == "" {
+		logger.Warn("'" + api.CfgUserRecoveryBaseURL + "' 没有配置，不能发送重置密码和激活帐号的邮件")
+	}
+	if !mail.CanDeliver(sender) {
+		logger.Warn("'" + api.CfgMailSender + "' 没有配置为 smtp 或 file，不能发送重置密码和激活帐号的邮件")
+	}
+
+	return &Service{
+		logger:          logger,
+		users:           users,
+		userManager:     userManager,
+		tokens:          tokens,
+		sender:          sender,
+		sessions:        sessions,
+		signer:          signer{secret: secretKey},
+		baseURL:         baseURL,
+		resetExpires:    env.Config.DurationWithDefault(api.CfgUserRecoveryResetExpires, 30*time.Minute),
+		activateExpires: env.Config.DurationWithDefault(api.CfgUserRecoveryActivateExpires, 72*time.Hour),
+		requests:        authnservices.NewMemFailCounter(env.Config.DurationWithDefault(api.CfgUserRecoveryRequestWindow, time.Hour)),
+		accountRequests: env.Config.IntWithDefault(api.CfgUserRecoveryAccountRequests, 3),
+		addressRequests: env.Config.IntWithDefault(api.CfgUserRecoveryAddressRequests, 10),
+	}, nil
+}
+
+func emailOf(user *usermodels.User) string {
+	if user.Attributes == nil {
+		return ""
+	}
+	return strings.TrimSpace(as.StringWithDefault(user.Attributes[userservices.Email.ID], ""))
+}
+
+func (svc *Service) findUser(ctx context.Context, account string) (*usermodels.User, error) {
+	user, err := svc.users.Users.GetUserByName(ctx, account)
+	if err == nil {
+		return user, nil
+	}
+	if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	if !strings.Contains(account, "@") {
+		return nil, nil
+	}
+
+	list, err := svc.users.Users.UserDao.GetUsersByEmail(ctx, account)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	// 多个用户用同一个邮箱时无法确定是哪个用户
+	if len(list) != 1 {
+		return nil, nil
+	}
+	return &list[0], nil
+}
+
+func (svc *Service) issue(ctx context.Context, user *usermodels.User, purpose string, expires time.Duration) (string, error) {
+	expiresAt := time.Now().Add(expires)
+	token, err := svc.signer.Sign(purpose, user.ID, expiresAt)
+	if err != nil {
+		return "", errors.Wrap(err, "生成令牌失败")
+	}
+
+	// 以前发出的同类链接作废
+	if _, err := svc.tokens.Invalidate(ctx, user.ID, purpose); err != nil {
+		return "", errors.Wrap(err, "作废以前的令牌失败")
+	}
+	if _, err := svc.tokens.DeleteExpired(ctx); err != nil {
+		svc.logger.Warn("删除过期的令牌失败", log.Error(err))
+	}
+
+	_, err = svc.tokens.Create(ctx, &usermodels.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "保存令牌失败")
+	}
+	return token, nil
+}
+
+// checkDelivery 检查是否可以发送带链接的邮件
+func (svc *Service) checkDelivery() error {
+	if svc.baseURL == "" {
+		return ErrBaseURLMissing
+	}
+	if !mail.CanDeliver(svc.sender) {
+		return ErrSenderMissing
+	}
+	return nil
+}
+
+// Enabled 是否可以通过邮件找回密码和激活帐号
+func (svc *Service) Enabled() bool {
+	return svc.checkDelivery() == nil
+}
+
+func (svc *Service) link(pa, token string) string {
+	return urlutil.Join(svc.baseURL, pa) + "?token=" + url.QueryEscape(token)
+}
+
+// throttle 检查同一个帐号或同一个地址在时间窗口内的请求次数, 不管用户是否存在都计数, 以免泄露用户是否存在
+func (svc *Service) throttle(ctx context.Context, account, address string) error {
+	if svc.requests == nil {
+		return nil
+	}
+
+	if svc.accountRequests > 0 {
+		record, err := svc.requests.Count(ctx, authnservices.FailByUsername, account)
+		if err != nil {
+			return errors.Wrap(err, "查询请求次数失败")
+		}
+		if record.Count >= svc.accountRequests {
+			svc.logger.Warn("重置密码的请求太频繁", log.String("account", account), log.String("address", address))
+			return ErrTooManyRequests
+		}
+	}
+	if svc.addressRequests > 0 && address != "" {
+		record, err := svc.requests.Count(ctx, authnservices.FailByAddress, address)
+		if err != nil {
+			return errors.Wrap(err, "查询请求次数失败")
+		}
+		if record.Count >= svc.addressRequests {
+			svc.logger.Warn("重置密码的请求太频繁", log.String("account", account), log.String("address", address))
+			return ErrTooManyRequests
+		}
+	}
+	if err := svc.requests.Fail(ctx, account, address); err != nil {
+		return errors.Wrap(err, "记录请求次数失败")
+	}
+	return nil
+}
+
+// RequestPasswordReset 给用户发送重置密码的邮件，为了不泄露用户是否存在，用户不存在时也不返回错误
+func (svc *Service) RequestPasswordReset(ctx context.Context, account, address string) error {
+	account = strings.TrimSpace(account)
+	if account == "" {
+		return errors.NewError(http.StatusBadRequest, "请输入用户名或邮箱")
+	}
+	if err := svc.checkDelivery(); err != nil {
+		return err
+	}
+	if err := svc.throttle(ctx, account, address); err != nil {
+		return err
+	}
+
+	user, err := svc.findUser(ctx, account)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		svc.logger.Info("重置密码的用户不存在", log.String("account", account))
+		return nil
+	}
+	if user.IsDisabled() || user.Source == "ldap" || user.Source == "cas" {
+		svc.logger.Info("用户不能通过邮件重置密码", log.String("account", account), log.String("source", user.Source))
+		return nil
+	}
+	email := emailOf(user)
+	if email == "" {
+		svc.logger.Info("用户没有设置邮箱，不能重置密码", log.String("account", account))
+		return nil
+	}
+
+	token, err := svc.issue(ctx, user, usermodels.UserTokenResetPassword, svc.resetExpires)
+	if err != nil {
+		return err
+	}
+
+	err = svc.sender.Send(ctx, &mail.Message{
+		To:      []string{email},
+		Subject: "重置密码",
+		Body: "您好, " + user.Nickname + ":\r\n\r\n" +
+			"我们收到了重置您的帐号 " + user.Name + " 的密码的请求，请点击下面的链接设置新密码:\r\n\r\n" +
+			svc.link("sessions/reset_password", token) + "\r\n\r\n" +
+			"该链接只能使用一次，将在 " + svc.resetExpires.String() + " 后失效。如果这不是您本人的操作，请忽略本邮件。\r\n",
+	})
+	if err != nil {
+		return errors.Wrap(err, "发送重置密码的邮件失败")
+	}
+	svc.logger.Info("已发送重置密码的邮件", log.String("username", user.Name), log.String("email", email))
+	return nil
+}
+
+// SendActivation 给新建的用户发送激活邮件，用户通过邮件中的链接设置密码并激活帐号
+func (svc *Service) SendActivation(ctx context.Context, currentUser api.User, userID int64) error {
+	ok, err := currentUser.HasPermission(ctx, PermissionSendActivation)
+	if err != nil {
+		return errors.Wrap(err, "检查权限失败")
+	}
+	if !ok {
+		return ErrPermissionDenny
+	}
+	if err := svc.checkDelivery(); err != nil {
+		return err
+	}
+
+	user, err := svc.users.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return errors.ErrNotFoundWithText("该用户不存在!")
+		}
+		return errors.Wrap(err, "查询用户失败")
+	}
+	email := emailOf(user)
+	if email == "" {
+		return ErrEmailMissing
+	}
+
+	token, err := svc.issue(ctx, user, usermodels.UserTokenActivate, svc.activateExpires)
+	if err != nil {
+		return err
+	}
+
+	err = svc.sender.Send(ctx, &mail.Message{
+		To:      []string{email},
+		Subject: "激活帐号",
+		Body: "您好, " + user.Nickname + ":\r\n\r\n" +
+			"已经为您创建了帐号 " + user.Name + "，请点击下面的链接设置密码并激活帐号:\r\n\r\n" +
+			svc.link("sessions/activate", token) + "\r\n\r\n" +
+			"该链接只能使用一次，将在 " + svc.activateExpires.String() + " 后失效。\r\n",
+	})
+	if err != nil {
+		return errors.Wrap(err, "发送激活邮件失败")
+	}
+
+	if err := svc.users.OpLogger.LogRecord(ctx, &api.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Name(),
+		Type:       "send_activation",
+		Successful: true,
+		Content:    "给用户 '" + user.Name + "' 发送激活邮件",
+		Fields: &api.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+		},
+	}); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	return nil
+}
+
+// Check 检查令牌是否有效
+func (svc *Service) Check(ctx context.Context, token, purpose string) (*usermodels.UserToken, error) {
+	tokenPurpose, userID, expiresAt, err := svc.signer.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if tokenPurpose != purpose {
+		return nil, ErrTokenInvalid
+	}
+	now := time.Now()
+	if now.After(expiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	var record usermodels.UserToken
+	err = svc.tokens.GetByHash(ctx, hashToken(token))(&record)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, errors.Wrap(err, "查询令牌失败")
+	}
+	if record.UserID != userID || record.Purpose != purpose {
+		return nil, ErrTokenInvalid
+	}
+	if record.IsUsed() {
+		return nil, ErrTokenUsed
+	}
+	if record.IsExpired(now) {
+		return nil, ErrTokenExpired
+	}
+	return &record, nil
+}
+
+func (svc *Service) consume(ctx context.Context, token, purpose string, cb func(*userservices.RequestContext, *usermodels.User) error) (*usermodels.User, error) {
+	record, err := svc.Check(ctx, token, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := svc.users.Users.GetUserByID(ctx, record.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	currentUser, err := svc.userManager.UserByID(ctx, user.ID, api.UserIncludeDisabled())
+	if err != nil {
+		return nil, err
+	}
+
+	reqCtx := svc.users.NewContext(ctx, currentUser, "")
+	err = reqCtx.InTransaction(func(reqCtx *userservices.RequestContext) error {
+		count, err := usermodels.NewUserTokenDao(reqCtx.Tx.SessionReference()).MarkUsed(reqCtx.Ctx, record.ID)
+		if err != nil {
+			return errors.Wrap(err, "更新令牌失败")
+		}
+		if count == 0 {
+			return ErrTokenUsed
+		}
+		return cb(reqCtx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResetPassword 通过重置密码的链接设置新密码，新密码同样要符合密码策略
+func (svc *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	user, err := svc.consume(ctx, token, usermodels.UserTokenResetPassword, func(reqCtx *userservices.RequestContext, user *usermodels.User) error {
+		return svc.users.UpdateUserPassword(reqCtx, user.ID, newPassword)
+	})
+	if err != nil {
+		return err
+	}
+	svc.logger.Info("用户通过邮件重置了密码", log.String("username", user.Name))
+
+	// 密码可能已经泄露了, 重置后将用户已经登录的会话都踢下线
+	if svc.sessions != nil {
+		count, err := svc.sessions.TerminateSessions(ctx, user.Name, "", "password_reset", "")
+		if err != nil {
+			svc.logger.Warn("重置密码后终止用户的会话失败", log.String("username", user.Name), log.Error(err))
+		} else if count > 0 {
+			svc.logger.Info("重置密码后终止了用户的会话", log.String("username", user.Name), log.Any("count", count))
+		}
+	}
+	return nil
+}
+
+// Activate 通过激活链接设置密码并启用帐号
+func (svc *Service) Activate(ctx context.Context, token, newPassword string) error {
+	user, err := svc.consume(ctx, token, usermodels.UserTokenActivate, func(reqCtx *userservices.RequestContext, user *usermodels.User) error {
+		if err := svc.users.UpdateUserPassword(reqCtx, user.ID, newPassword); err != nil {
+			return err
+		}
+		if user.Disabled {
+			return svc.users.EnableUser(reqCtx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	svc.logger.Info("用户激活了帐号", log.String("username", user.Name))
+	return nil
+}
@@ -0,0 +1,76 @@
+package recovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/log"
+	authnservices "github.com/runner-mei/moo/authn/services"
+	"github.com/runner-mei/moo/components/mail"
+)
+
+func TestBaseURLRequired(t *testing.T) {
+	svc := &Service{logger: log.Empty()}
+	if err := svc.RequestPasswordReset(context.Background(), "tom", "192.168.1.2"); err != ErrBaseURLMissing {
+		t.Error("want ErrBaseURLMissing, got", err)
+	}
+
+	svc.baseURL = "https://example.com/moo"
+	if s := svc.link("sessions/reset_password", "a+b"); s != "https://example.com/moo/sessions/reset_password?token=a%2Bb" {
+		t.Error(s)
+	}
+}
+
+func TestSenderRequired(t *testing.T) {
+	svc := &Service{logger: log.Empty(), baseURL: "https://example.com/moo", sender: &mail.LogSender{Logger: log.Empty()}}
+	if err := svc.RequestPasswordReset(context.Background(), "tom", "192.168.1.2"); err != ErrSenderMissing {
+		t.Error("want ErrSenderMissing, got", err)
+	}
+	if svc.Enabled() {
+		t.Error("recovery is enabled without a mail sender")
+	}
+
+	svc.sender = &mail.FileSender{Dir: t.TempDir()}
+	if !svc.Enabled() {
+		t.Error("recovery is disabled with a file sender")
+	}
+}
+
+func TestRequestPasswordResetThrottle(t *testing.T) {
+	ctx := context.Background()
+	svc := &Service{
+		logger:          log.Empty(),
+		baseURL:         "https://example.com/moo",
+		sender:          &mail.FileSender{Dir: t.TempDir()},
+		requests:        authnservices.NewMemFailCounter(time.Hour),
+		accountRequests: 3,
+		addressRequests: 5,
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := svc.throttle(ctx, "tom", "192.168.1.2"); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	// 同一个帐号超过次数后, 换了地址或大小写也不行
+	if err := svc.throttle(ctx, "TOM", "192.168.1.3"); err != ErrTooManyRequests {
+		t.Error("want ErrTooManyRequests, got", err)
+	}
+	if err := svc.RequestPasswordReset(ctx, "tom", "192.168.1.4"); err != ErrTooManyRequests {
+		t.Error("want ErrTooManyRequests, got", err)
+	}
+
+	// 同一个地址超过次数后, 换了帐号也不行
+	for _, account := range []string{"jerry", "lucy"} {
+		if err := svc.throttle(ctx, account, "192.168.1.2"); err != nil {
+			t.Fatal(account, err)
+		}
+	}
+	if err := svc.throttle(ctx, "lily", "192.168.1.2"); err != ErrTooManyRequests {
+		t.Error("want ErrTooManyRequests, got", err)
+	}
+	if err := svc.throttle(ctx, "lily", "192.168.1.5"); err != nil {
+		t.Error(err)
+	}
+}
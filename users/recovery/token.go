@@ -0,0 +1,72 @@
+package recovery
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signer 生成和校验带签名的令牌, 令牌的格式为 base64(purpose|userID|expiresAt|nonce).base64(hmac)
+type signer struct {
+	secret []byte
+}
+
+func (s *signer) sum(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (s *signer) Sign(purpose string, userID int64, expiresAt time.Time) (string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+
+	payload := purpose + "|" + strconv.FormatInt(userID, 10) + "|" +
+		strconv.FormatInt(expiresAt.Unix(), 10) + "|" + hex.EncodeToString(nonce[:])
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sum(payload)), nil
+}
+
+func (s *signer) Parse(token string) (purpose string, userID int64, expiresAt time.Time, err error) {
+	idx := strings.IndexByte(token, '.')
+	if idx <= 0 {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:idx])
+	if err != nil {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+	if err != nil {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+	if !hmac.Equal(signature, s.sum(string(payload))) {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+
+	ss := strings.Split(string(payload), "|")
+	if len(ss) != 4 {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+	userID, err = strconv.ParseInt(ss[1], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+	unix, err := strconv.ParseInt(ss[2], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, ErrTokenInvalid
+	}
+	return ss[0], userID, time.Unix(unix, 0), nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
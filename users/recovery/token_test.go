@@ -0,0 +1,40 @@
+package recovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := &signer{secret: []byte("secret")}
+	expiresAt := time.Now().Add(time.Hour)
+
+	token, err := s.Sign("reset_password", 12, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purpose, userID, actualExpiresAt, err := s.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purpose != "reset_password" || userID != 12 || actualExpiresAt.Unix() != expiresAt.Unix() {
+		t.Error("got", purpose, userID, actualExpiresAt)
+	}
+
+	other := &signer{secret: []byte("other")}
+	if _, _, _, err := other.Parse(token); err != ErrTokenInvalid {
+		t.Error("want ErrTokenInvalid got", err)
+	}
+
+	tampered := []byte(token)
+	tampered[2] ^= 1
+	if _, _, _, err := s.Parse(string(tampered)); err != ErrTokenInvalid {
+		t.Error("want ErrTokenInvalid got", err)
+	}
+
+	token2, _ := s.Sign("reset_password", 12, expiresAt)
+	if token2 == token {
+		t.Error("tokens must be different")
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type changedEvent struct {
+	topic string
+	evt   *api.ChangedEvent
+}
+
+// changeSet 事务中产生的修改事件, 事务提交后才发送, 回滚时丢弃
+type changeSet struct {
+	events []changedEvent
+}
+
+func (cs *changeSet) add(topic, action string, ids []int64) {
+	for idx := range cs.events {
+		evt := cs.events[idx].evt
+		if cs.events[idx].topic == topic && evt.Action == action {
+			evt.IDs = appendIDs(evt.IDs, ids)
+			return
+		}
+	}
+	cs.events = append(cs.events, changedEvent{
+		topic: topic,
+		evt:   &api.ChangedEvent{Action: action, IDs: appendIDs(nil, ids)},
+	})
+}
+
+func appendIDs(list, ids []int64) []int64 {
+	for _, id := range ids {
+		found := false
+		for _, old := range list {
+			if old == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+func emitChanged(req *RequestContext, topic string, evt *api.ChangedEvent) {
+	if err := req.bus.Emit(req.Ctx, topic, evt); err != nil {
+		log.Println(topic, evt.Action, "emit fail:", err)
+	}
+}
+
+func (req *RequestContext) flushChanges() {
+	if req.changes == nil || req.bus == nil {
+		return
+	}
+	events := req.changes.events
+	req.changes.events = nil
+	for _, e := range events {
+		emitChanged(req, e.topic, e.evt)
+	}
+}
+
+// Notify 在 Bus 上发送用户或用户组的修改事件, 在事务中时等事务提交后才发送,
+// topic 为 api.BusUserChanged 或 api.BusUsergroupChanged, ids 为空时表示刷新全部缓存
+func (req *RequestContext) Notify(topic, action string, ids ...int64) {
+	if req.bus == nil {
+		return
+	}
+	if req.changes != nil {
+		req.changes.add(topic, action, ids)
+		return
+	}
+	emitChanged(req, topic, &api.ChangedEvent{Action: action, IDs: appendIDs(nil, ids)})
+}
+
+func (req *RequestContext) notifyUsers(action string, ids ...int64) {
+	req.Notify(api.BusUserChanged, action, ids...)
+}
+
+func (req *RequestContext) notifyUsergroups(action string, ids ...int64) {
+	req.Notify(api.BusUsergroupChanged, action, ids...)
+}
+
+// notifyUsergroupMembers 通知用户组当前的成员发生了变化, 删除用户组等会改变成员的操作要在修改前调用
+func (req *RequestContext) notifyUsergroupMembers(action string, groupIDs []int64, recursive bool) error {
+	if req.bus == nil || len(groupIDs) == 0 {
+		return nil
+	}
+	userIDs, err := req.Usergroups.GetUserIDsByGroupIDs(req.Ctx, groupIDs, recursive, sql.NullBool{})
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "查询用户组的成员失败")
+	}
+	if len(userIDs) > 0 {
+		req.notifyUsers(action, userIDs...)
+	}
+	return nil
+}
+
+// notifyUsergroupsOfUser 通知用户所在的用户组的成员发生了变化, 要在修改前调用
+func (req *RequestContext) notifyUsergroupsOfUser(userID int64) error {
+	if req.bus == nil {
+		return nil
+	}
+	next, closer := req.Usergroups.GetUsergroups(req.Ctx, sql.NullInt64{Valid: true, Int64: userID})
+	defer util.CloseWith(closer)
+
+	groups, err := usermodels.GetUsergroups(req.Ctx, next)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "查询用户所在的用户组失败")
+	}
+	if len(groups) > 0 {
+		ids := make([]int64, 0, len(groups))
+		for idx := range groups {
+			ids = append(ids, groups[idx].ID)
+		}
+		req.notifyUsergroups(api.ChangeMembersChanged, ids...)
+	}
+	return nil
+}
+
+// RegisterTopics 在 Bus 上注册用户和用户组的修改事件
+func RegisterTopics(bus *moo.Bus) {
+	bus.RegisterTopics(api.BusUserChanged, api.BusUsergroupChanged)
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/runner-mei/moo/api"
+)
+
+func TestChangeSet(t *testing.T) {
+	var cs changeSet
+	cs.add(api.BusUserChanged, api.ChangeUpdated, []int64{1, 2})
+	cs.add(api.BusUserChanged, api.ChangeUpdated, []int64{2, 3})
+	cs.add(api.BusUsergroupChanged, api.ChangeUpdated, []int64{1})
+	cs.add(api.BusUserChanged, api.ChangeRefresh, nil)
+
+	if len(cs.events) != 3 {
+		t.Fatal(cs.events)
+	}
+	if e := cs.events[0]; e.topic != api.BusUserChanged || !reflect.DeepEqual(e.evt.IDs, []int64{1, 2, 3}) {
+		t.Error(e.topic, e.evt)
+	}
+	if e := cs.events[1]; e.topic != api.BusUsergroupChanged || !reflect.DeepEqual(e.evt.IDs, []int64{1}) {
+		t.Error(e.topic, e.evt)
+	}
+	if e := cs.events[2]; !e.evt.IsRefresh() {
+		t.Error(e.topic, e.evt)
+	}
+}
@@ -0,0 +1,570 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+	"go.uber.org/fx"
+)
+
+// 用户属性的类型, 其它的类型不做检查
+const (
+	FieldTypeText     = "text"
+	FieldTypeString   = "string"
+	FieldTypeTextarea = "textarea"
+	FieldTypeInteger  = "integer"
+	FieldTypeNumber   = "number"
+	FieldTypeBoolean  = "boolean"
+	FieldTypeDate     = "date"
+	FieldTypeDatetime = "datetime"
+	FieldTypeEmail    = "email"
+	FieldTypePhone    = "phone"
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9\- ()]{2,30}$`)
+
+func isTextType(typ string) bool {
+	switch typ {
+	case "", FieldTypeText, FieldTypeString, FieldTypeTextarea:
+		return true
+	}
+	return false
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
+
+func toScalarString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case bool, int, int32, int64, uint, uint32, uint64:
+		return fmt.Sprint(v), nil
+	}
+	return "", errors.New("不能是 " + fmt.Sprintf("%T", value))
+}
+
+// Normalize 按类型检查属性的值, 并转换为保存的格式, 有枚举值时值必须是其中之一
+func (field *Field) Normalize(value interface{}) (interface{}, error) {
+	var list []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		list = v
+	case []string:
+		for _, s := range v {
+			list = append(list, s)
+		}
+	default:
+		return field.normalize(value)
+	}
+
+	// 多选的时候会是一个数组
+	if !isTextType(field.Type) && len(field.Enumerations) == 0 {
+		return nil, errors.New("不能是多个值")
+	}
+	results := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		v, err := field.normalize(item)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, nil
+}
+
+func (field *Field) normalize(value interface{}) (interface{}, error) {
+	var result interface{}
+	switch field.Type {
+	case "", FieldTypeText, FieldTypeString, FieldTypeTextarea:
+		s, err := toScalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		result = s
+	case FieldTypeInteger, "int":
+		s, err := toScalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if ferr != nil || f != float64(int64(f)) {
+				return nil, errors.New("'" + s + "' 不是整数")
+			}
+			i = int64(f)
+		}
+		result = i
+	case FieldTypeNumber, "float", "decimal":
+		s, err := toScalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, errors.New("'" + s + "' 不是数字")
+		}
+		result = f
+	case FieldTypeBoolean, "bool":
+		s, err := toScalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, errors.New("'" + s + "' 不是布尔值")
+		}
+		result = b
+	case FieldTypeDate:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("必须是日期, 格式为 2006-01-02")
+		}
+		s = strings.TrimSpace(s)
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, errors.New("'" + s + "' 不是日期, 格式为 2006-01-02")
+			}
+		}
+		result = t.Format("2006-01-02")
+	case FieldTypeDatetime:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("必须是时间, 格式为 2006-01-02T15:04:05Z07:00")
+		}
+		s = strings.TrimSpace(s)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+			if err != nil {
+				return nil, errors.New("'" + s + "' 不是时间, 格式为 2006-01-02T15:04:05Z07:00")
+			}
+		}
+		result = t.Format(time.RFC3339)
+	case FieldTypeEmail:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("必须是邮箱地址")
+		}
+		s = strings.TrimSpace(s)
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return nil, errors.New("'" + s + "' 不是正确的邮箱地址")
+		}
+		result = s
+	case FieldTypePhone:
+		s, err := toScalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		s = strings.TrimSpace(s)
+		if !phonePattern.MatchString(s) {
+			return nil, errors.New("'" + s + "' 不是正确的电话号码")
+		}
+		result = s
+	default:
+		return value, nil
+	}
+
+	if len(field.Enumerations) > 0 {
+		s := fmt.Sprint(result)
+		found := false
+		for idx := range field.Enumerations {
+			if field.Enumerations[idx].Value == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("'" + s + "' 不是可选的值")
+		}
+	}
+	return result, nil
+}
+
+// FieldSchema 用户属性的定义, Version 为定义的摘要, 定义文件修改后它会变化
+type FieldSchema struct {
+	Version string   `json:"version"`
+	Fields  []Fields `json:"fields"`
+
+	keys   []string
+	fields map[string]*Field
+}
+
+// NewFieldSchema 创建 FieldSchema, 属性名为 Fields.Prefix 加上 Field.ID
+func NewFieldSchema(fields []Fields) *FieldSchema {
+	schema := &FieldSchema{
+		Fields: fields,
+		fields: map[string]*Field{},
+	}
+	for idx := range fields {
+		for fidx := range fields[idx].Fields {
+			key := fields[idx].Prefix + fields[idx].Fields[fidx].ID
+			if _, exists := schema.fields[key]; exists {
+				continue
+			}
+			schema.keys = append(schema.keys, key)
+			schema.fields[key] = &fields[idx].Fields[fidx]
+		}
+	}
+
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	schema.Version = hex.EncodeToString(sum[:8])
+	return schema
+}
+
+// ReadFieldSchema 读取当前的用户属性定义
+func ReadFieldSchema(env *moo.Environment) (*FieldSchema, error) {
+	fields, err := ReadFieldsFromDir(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "读用户属性的定义失败")
+	}
+	return NewFieldSchema(fields), nil
+}
+
+// Field 返回属性的定义, 没有定义时返回 nil
+func (schema *FieldSchema) Field(key string) *Field {
+	return schema.fields[key]
+}
+
+// Keys 返回所有属性名, 按定义的顺序
+func (schema *FieldSchema) Keys() []string {
+	return schema.keys
+}
+
+func attributeKey(key string) string {
+	return "Attributes[" + key + "]"
+}
+
+func isExternalUser(user *usermodels.User) bool {
+	return user.Source == "ldap" || user.Source == "cas"
+}
+
+// ValidateAttributes 检查用户的属性, 并将值转换为保存的格式, 没有定义的属性不检查.
+//
+// old 为 nil 时表示新建用户, 会为没有填写的属性设置默认值; 修改用户时, 必填的属性只有原来有值的不能清空,
+// 没有修改的值也不检查类型. 来自 LDAP 和 CAS 的用户的属性由目录决定, 所以只转换能转换的值
+func (schema *FieldSchema) ValidateAttributes(validator *validation.Validation, user *usermodels.User, old map[string]interface{}) bool {
+	external := isExternalUser(user)
+	for _, key := range schema.keys {
+		field := schema.fields[key]
+		value := user.Attributes[key]
+
+		if isEmptyValue(value) {
+			if old == nil && field.DefaultValue != "" {
+				value = field.DefaultValue
+			} else {
+				if field.Required && !external && (old == nil || !isEmptyValue(old[key])) {
+					validator.Error(attributeKey(key), field.Name+" 不能为空")
+				}
+				continue
+			}
+		}
+
+		normalized, err := field.Normalize(value)
+		if err != nil {
+			// 没有修改的值不检查, 以免修改定义后已有的用户都不能修改
+			if !external && (old == nil || !reflect.DeepEqual(old[key], value)) {
+				validator.Error(attributeKey(key), field.Name+" "+err.Error())
+			}
+			continue
+		}
+		user.Attributes[key] = normalized
+	}
+	return validator.HasErrors()
+}
+
+// checkUniqueAttributes 检查 Unique 的属性是否和其它用户的相同, 只检查新填写或修改了的值
+func (svc *Service) checkUniqueAttributes(ctx *RequestContext, schema *FieldSchema, user *usermodels.User, old map[string]interface{}) error {
+	if isExternalUser(user) {
+		return nil
+	}
+	for _, key := range schema.keys {
+		field := schema.fields[key]
+		if !field.Unique || isEmptyValue(user.Attributes[key]) {
+			continue
+		}
+		s, err := toScalarString(user.Attributes[key])
+		if err != nil {
+			continue
+		}
+		if old != nil && !isEmptyValue(old[key]) {
+			if oldValue, err := toScalarString(old[key]); err == nil && strings.EqualFold(oldValue, s) {
+				continue
+			}
+		}
+
+		idList, err := ctx.Users.UserDao.GetUserIDsByAttribute(ctx.Ctx, key, s, user.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Wrap(err, "查询 "+field.Name+" 是否已被使用失败")
+		}
+		if len(idList) > 0 {
+			return validation.NewValidationError(attributeKey(key), field.Name+" '"+s+"' 已被其它用户使用!")
+		}
+	}
+	return nil
+}
+
+// validateAttributes 按属性定义检查用户的属性, oldUser 为 nil 时表示新建用户
+func (svc *Service) validateAttributes(ctx *RequestContext, user, oldUser *usermodels.User) error {
+	if svc.FieldValidationDisabled {
+		return nil
+	}
+	schema, err := svc.FieldSchema(ctx.Ctx)
+	if err != nil {
+		return err
+	}
+
+	var old map[string]interface{}
+	if oldUser != nil {
+		old = oldUser.Attributes
+		if old == nil {
+			old = map[string]interface{}{}
+		}
+	}
+	if user.Attributes == nil {
+		user.Attributes = map[string]interface{}{}
+	}
+
+	validator := svc.Validator.New()
+	if schema.ValidateAttributes(validator, user, old) {
+		return validator.ToError()
+	}
+	return svc.checkUniqueAttributes(ctx, schema, user, old)
+}
+
+// ParseQueries 解析按属性查询的条件, 每个条件为 key=value(相等) 或 key~value(模糊匹配), key 必须是已定义的属性
+func (schema *FieldSchema) ParseQueries(ss []string) ([]usermodels.AttributeQuery, error) {
+	var results []usermodels.AttributeQuery
+	for _, s := range ss {
+		idx := strings.IndexAny(s, "=~")
+		if idx <= 0 {
+			return nil, errors.New("属性查询条件 '" + s + "' 不正确, 格式为 key=value 或 key~value")
+		}
+		query := usermodels.AttributeQuery{
+			Key:   strings.TrimSpace(s[:idx]),
+			Value: s[idx+1:],
+			Like:  s[idx] == '~',
+		}
+		field := schema.Field(query.Key)
+		if field == nil {
+			return nil, errors.New("属性 '" + query.Key + "' 不存在")
+		}
+		if !query.Like {
+			// 保存的是转换后的值, 查询时也要转换, 如 1.0 转换为 1
+			if v, err := field.normalize(query.Value); err == nil {
+				query.Value = fmt.Sprint(v)
+			}
+		}
+		results = append(results, query)
+	}
+	return results, nil
+}
+
+// ParseAttributeQueries 按当前的属性定义解析按属性查询的条件, 参见 FieldSchema.ParseQueries
+func (svc *Service) ParseAttributeQueries(ctx context.Context, ss []string) ([]usermodels.AttributeQuery, error) {
+	if len(ss) == 0 {
+		return nil, nil
+	}
+	schema, err := ReadFieldSchema(svc.Env)
+	if err != nil {
+		return nil, err
+	}
+	return schema.ParseQueries(ss)
+}
+
+// DiffFieldSchemas 比较两个版本的属性定义, 返回新增, 删除和修改了的属性名
+func DiffFieldSchemas(old, new *FieldSchema) (added, removed, changed []string) {
+	for _, key := range new.keys {
+		oldField := old.fields[key]
+		if oldField == nil {
+			added = append(added, key)
+			continue
+		}
+		a, _ := json.Marshal(oldField)
+		b, _ := json.Marshal(new.fields[key])
+		if string(a) != string(b) {
+			changed = append(changed, key)
+		}
+	}
+	for _, key := range old.keys {
+		if new.fields[key] == nil {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// FieldSchema 读取当前的用户属性定义, 它的版本在启动时和定义文件改变后记录, 参见 RecordFieldSchema
+func (svc *Service) FieldSchema(ctx context.Context) (*FieldSchema, error) {
+	return ReadFieldSchema(svc.Env)
+}
+
+// RecordFieldSchema 读取当前的用户属性定义, 定义和上次记录的版本不同时记录一个新的版本
+func (svc *Service) RecordFieldSchema(ctx context.Context) error {
+	schema, err := ReadFieldSchema(svc.Env)
+	if err != nil {
+		return err
+	}
+	return svc.recordFieldSchema(ctx, schema)
+}
+
+func (svc *Service) recordFieldSchema(ctx context.Context, schema *FieldSchema) error {
+	if svc.FieldSchemas == nil {
+		return nil
+	}
+	svc.fieldSchemaLock.Lock()
+	defer svc.fieldSchemaLock.Unlock()
+	if svc.fieldSchemaVersion == schema.Version {
+		return nil
+	}
+
+	var latest usermodels.UserFieldSchema
+	err := svc.FieldSchemas.Latest(ctx)(&latest)
+	if err != nil {
+		if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+			return errors.Wrap(err, "查询用户属性定义的版本失败")
+		}
+		latest = usermodels.UserFieldSchema{}
+	}
+	if latest.Version == schema.Version {
+		svc.fieldSchemaVersion = schema.Version
+		return nil
+	}
+
+	content, err := json.Marshal(schema.Fields)
+	if err != nil {
+		return errors.Wrap(err, "序列化用户属性定义失败")
+	}
+
+	var exists usermodels.UserFieldSchema
+	err = svc.FieldSchemas.GetByVersion(ctx, schema.Version)(&exists)
+	if err != nil {
+		if err != sql.ErrNoRows && !errors.IsNotFound(err) {
+			return errors.Wrap(err, "查询用户属性定义的版本失败")
+		}
+		// 改回以前的版本时不再新建, 这时 Latest 不是当前的版本, 所以每次启动都会记一条操作日志
+		if _, err := svc.FieldSchemas.Insert(ctx, &usermodels.UserFieldSchema{
+			Version: schema.Version,
+			Content: string(content),
+		}); err != nil {
+			return errors.Wrap(err, "保存用户属性定义的版本失败")
+		}
+	}
+
+	msg := "用户属性定义的版本变为 " + schema.Version
+	if latest.Version != "" {
+		var oldFields []Fields
+		if err := json.Unmarshal([]byte(latest.Content), &oldFields); err == nil {
+			added, removed, changed := DiffFieldSchemas(NewFieldSchema(oldFields), schema)
+			msg = "用户属性定义的版本从 " + latest.Version + " 变为 " + schema.Version
+			if len(added) > 0 {
+				msg += ", 新增 " + strings.Join(added, ",")
+			}
+			if len(removed) > 0 {
+				msg += ", 删除 " + strings.Join(removed, ",")
+			}
+			if len(changed) > 0 {
+				msg += ", 修改 " + strings.Join(changed, ",")
+			}
+		}
+	}
+	if err := svc.OpLogger.LogRecord(ctx, &api.OperationLog{
+		Type:       "user_fields_changed",
+		Successful: true,
+		Content:    msg,
+	}); err != nil {
+		return errors.Wrap(err, "添加操作日志失败")
+	}
+	svc.fieldSchemaVersion = schema.Version
+	return nil
+}
+
+// FieldSchemaVersions 返回记录过的用户属性定义的版本, 最新的在前面, 不包括定义的内容
+func (svc *Service) FieldSchemaVersions(ctx context.Context) ([]usermodels.UserFieldSchema, error) {
+	if svc.FieldSchemas == nil {
+		return nil, nil
+	}
+	list, err := svc.FieldSchemas.List(ctx)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "查询用户属性定义的版本失败")
+	}
+	return list, nil
+}
+
+func init() {
+	moo.On(func(env *moo.Environment) moo.Option {
+		return moo.Invoke(func(lifecycle fx.Lifecycle, svc *Service, logger log.Logger) {
+			logger = logger.Named("user_fields")
+			interval := env.Config.DurationWithDefault(api.CfgUserFieldsCheckInterval, time.Minute)
+
+			var timer util.Timer
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					// 记录版本失败不影响使用
+					if err := svc.RecordFieldSchema(context.Background()); err != nil {
+						logger.Warn("记录用户属性定义的版本失败", log.Error(err))
+					}
+					if interval <= 0 {
+						return nil
+					}
+
+					// 定时重读定义文件, 版本没有变化时 recordFieldSchema 不访问数据库
+					timer.Start(interval, func() bool {
+						if err := svc.RecordFieldSchema(context.Background()); err != nil {
+							logger.Warn("记录用户属性定义的版本失败", log.Error(err))
+						}
+						return true
+					})
+					return nil
+				},
+				OnStop: func(context.Context) error {
+					timer.Stop()
+					return nil
+				},
+			})
+		})
+	})
+}
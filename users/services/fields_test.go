@@ -0,0 +1,141 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/moo/users/welcome"
+	"github.com/runner-mei/validation"
+)
+
+func TestFieldNormalize(t *testing.T) {
+	for _, test := range []struct {
+		field Field
+		value interface{}
+		want  interface{}
+		ok    bool
+	}{
+		{field: Field{Type: FieldTypeText}, value: float64(12), want: "12", ok: true},
+		{field: Field{Type: FieldTypeText}, value: []interface{}{"a", "b"}, want: []interface{}{"a", "b"}, ok: true},
+		{field: Field{Type: FieldTypeInteger}, value: "12", want: int64(12), ok: true},
+		{field: Field{Type: FieldTypeInteger}, value: float64(12), want: int64(12), ok: true},
+		{field: Field{Type: FieldTypeInteger}, value: "1.5", ok: false},
+		{field: Field{Type: FieldTypeInteger}, value: []interface{}{"1"}, ok: false},
+		{field: Field{Type: FieldTypeNumber}, value: "1.5", want: 1.5, ok: true},
+		{field: Field{Type: FieldTypeBoolean}, value: "true", want: true, ok: true},
+		{field: Field{Type: FieldTypeBoolean}, value: "yes", ok: false},
+		{field: Field{Type: FieldTypeDate}, value: "2020-06-01", want: "2020-06-01", ok: true},
+		{field: Field{Type: FieldTypeDate}, value: "2020/06/01", ok: false},
+		{field: Field{Type: FieldTypeDatetime}, value: "2020-06-01T08:00:00Z", want: "2020-06-01T08:00:00Z", ok: true},
+		{field: Field{Type: FieldTypeEmail}, value: "a@example.com", want: "a@example.com", ok: true},
+		{field: Field{Type: FieldTypeEmail}, value: "abc", ok: false},
+		{field: Field{Type: FieldTypeEmail}, value: "A <a@example.com>", ok: false},
+		{field: Field{Type: FieldTypePhone}, value: "+86 138-0000-0000", want: "+86 138-0000-0000", ok: true},
+		{field: Field{Type: FieldTypePhone}, value: "abc", ok: false},
+		{field: Field{Type: "ipaddress"}, value: 12, want: 12, ok: true},
+		{field: Field{Type: FieldTypeInteger, Enumerations: []welcome.InputOption{{Value: "1"}, {Value: "2"}}},
+			value: []interface{}{"1", float64(2)}, want: []interface{}{int64(1), int64(2)}, ok: true},
+		{field: Field{Type: FieldTypeText, Enumerations: []welcome.InputOption{{Value: "a"}}}, value: "b", ok: false},
+	} {
+		value, err := test.field.Normalize(test.value)
+		if !test.ok {
+			if err == nil {
+				t.Error(test.field.Type, test.value, "want error, got", value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(test.field.Type, test.value, err)
+		} else if !reflect.DeepEqual(value, test.want) {
+			t.Errorf("%s %v: want %#v, got %#v", test.field.Type, test.value, test.want, value)
+		}
+	}
+}
+
+func TestFieldSchemaValidateAttributes(t *testing.T) {
+	schema := NewFieldSchema([]Fields{
+		{Fields: []Field{
+			{ID: "age", Name: "年龄", Type: FieldTypeInteger},
+			{ID: "level", Name: "级别", Type: FieldTypeText, DefaultValue: "normal"},
+		}},
+		{Prefix: "hr.", Fields: []Field{
+			{ID: "no", Name: "工号", Type: FieldTypeText, Required: true},
+		}},
+	})
+
+	user := &usermodels.User{Attributes: map[string]interface{}{"age": "30", "hr.no": "001", "other": 1}}
+	if schema.ValidateAttributes(validation.Default.New(), user, nil) {
+		t.Fatal("want ok")
+	}
+	want := map[string]interface{}{"age": int64(30), "level": "normal", "hr.no": "001", "other": 1}
+	if !reflect.DeepEqual(user.Attributes, want) {
+		t.Error(user.Attributes)
+	}
+
+	if !schema.ValidateAttributes(validation.Default.New(), &usermodels.User{Attributes: map[string]interface{}{"age": "x", "hr.no": "001"}}, nil) {
+		t.Error("want error when type is invalid")
+	}
+	if !schema.ValidateAttributes(validation.Default.New(), &usermodels.User{Attributes: map[string]interface{}{}}, nil) {
+		t.Error("want error when required is empty")
+	}
+
+	// 修改时原来就没有值的必填属性和没有修改的值不检查
+	old := map[string]interface{}{"age": "x"}
+	if schema.ValidateAttributes(validation.Default.New(), &usermodels.User{Attributes: map[string]interface{}{"age": "x"}}, old) {
+		t.Error("want ok when not changed")
+	}
+	old = map[string]interface{}{"hr.no": "001"}
+	if !schema.ValidateAttributes(validation.Default.New(), &usermodels.User{Attributes: map[string]interface{}{}}, old) {
+		t.Error("want error when required is cleared")
+	}
+	if schema.ValidateAttributes(validation.Default.New(), &usermodels.User{Source: "ldap", Attributes: map[string]interface{}{"age": "x"}}, nil) {
+		t.Error("want ok for ldap")
+	}
+}
+
+func TestFieldSchemaParseQueries(t *testing.T) {
+	schema := NewFieldSchema([]Fields{{Fields: []Field{
+		{ID: "age", Type: FieldTypeInteger},
+		{ID: "email", Type: FieldTypeEmail},
+	}}})
+
+	queries, err := schema.ParseQueries([]string{"age=30.0", "email~example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []usermodels.AttributeQuery{
+		{Key: "age", Value: "30"},
+		{Key: "email", Value: "example.com", Like: true},
+	}
+	if !reflect.DeepEqual(queries, want) {
+		t.Error(queries)
+	}
+
+	for _, s := range []string{"age", "=30", "name=a"} {
+		if _, err := schema.ParseQueries([]string{s}); err == nil {
+			t.Error(s, "want error")
+		}
+	}
+}
+
+func TestDiffFieldSchemas(t *testing.T) {
+	old := NewFieldSchema([]Fields{{Fields: []Field{
+		{ID: "a", Type: FieldTypeText},
+		{ID: "b", Type: FieldTypeText},
+	}}})
+	new := NewFieldSchema([]Fields{{Fields: []Field{
+		{ID: "b", Type: FieldTypeInteger},
+		{ID: "c", Type: FieldTypeText},
+	}}})
+	if old.Version == new.Version {
+		t.Error("version isn't changed")
+	}
+
+	added, removed, changed := DiffFieldSchemas(old, new)
+	if !reflect.DeepEqual(added, []string{"c"}) ||
+		!reflect.DeepEqual(removed, []string{"a"}) ||
+		!reflect.DeepEqual(changed, []string{"b"}) {
+		t.Error(added, removed, changed)
+	}
+}
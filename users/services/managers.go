@@ -6,6 +6,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -31,13 +32,22 @@ type RequestContext struct {
 	OnlineUsers usermodels.OnlineUsers
 	Users       *usermodels.Users
 	Usergroups  usermodels.UsergroupDao
+
+	PasswordHistories usermodels.PasswordHistoryDao
+
+	bus     *moo.Bus
+	changes *changeSet
 }
 
 func (req *RequestContext) Commit() error {
 	if req.Tx == nil {
 		return nil
 	}
-	return req.Tx.Commit()
+	if err := req.Tx.Commit(); err != nil {
+		return err
+	}
+	req.flushChanges()
+	return nil
 }
 
 func (req *RequestContext) Rollback() error {
@@ -62,12 +72,14 @@ func (req *RequestContext) Begin(nativeTx ...gobatis.DBRunner) (*RequestContext,
 	newReq := &RequestContext{}
 	*newReq = *req
 	newReq.Tx = tx
+	newReq.changes = &changeSet{}
 
 	session := tx.SessionReference()
 	newReq.OnlineUsers = usermodels.NewOnlineUserDao(session)
 	newReq.OpLogger = req.OpLogger.Tx(tx)
 	newReq.Users = req.Users.Tx(session)
 	newReq.Usergroups = usermodels.NewUsergroupDao(session, usermodels.NewUsergroupQueryer(session))
+	newReq.PasswordHistories = usermodels.NewPasswordHistoryDao(session)
 	return newReq, nil
 }
 
@@ -97,6 +109,21 @@ type Service struct {
 	OnlineExpired  string
 	WelcomeRootURL string
 	Validator      *validation.Validation
+
+	PasswordHistories usermodels.PasswordHistoryDao
+	PasswordPolicy    *PasswordPolicy
+	PasswordComparer  PasswordComparer
+
+	// Bus 用于发送用户和用户组的修改事件, 为 nil 时不发送
+	Bus *moo.Bus
+
+	// FieldSchemas 记录用户属性定义的各个版本, 为 nil 时不记录
+	FieldSchemas usermodels.UserFieldSchemaDao
+	// FieldValidationDisabled 为 true 时不按定义检查用户属性
+	FieldValidationDisabled bool
+
+	fieldSchemaLock    sync.Mutex
+	fieldSchemaVersion string
 }
 
 func (svc *Service) NewContext(ctx context.Context, currentUser api.User, locale string) *RequestContext {
@@ -111,6 +138,10 @@ func (svc *Service) NewContext(ctx context.Context, currentUser api.User, locale
 		OnlineUsers: svc.OnlineUsers,
 		Users:       svc.Users,
 		Usergroups:  svc.Usergroups,
+
+		PasswordHistories: svc.PasswordHistories,
+
+		bus: svc.Bus,
 	}
 }
 
@@ -126,6 +157,10 @@ func (svc *Service) NewContextWithTx(ctx context.Context, nativeTx gobatis.DBRun
 		OnlineUsers: svc.OnlineUsers,
 		Users:       svc.Users,
 		Usergroups:  svc.Usergroups,
+
+		PasswordHistories: svc.PasswordHistories,
+
+		bus: svc.Bus,
 	}
 
 	tx, err := req.Factory.Begin(nativeTx)
@@ -134,12 +169,14 @@ func (svc *Service) NewContextWithTx(ctx context.Context, nativeTx gobatis.DBRun
 	}
 
 	req.Tx = tx
+	req.changes = &changeSet{}
 
 	session := tx.SessionReference()
 	req.OnlineUsers = usermodels.NewOnlineUserDao(session)
 	req.OpLogger = req.OpLogger.Tx(tx)
 	req.Users = req.Users.Tx(session)
 	req.Usergroups = usermodels.NewUsergroupDao(session, usermodels.NewUsergroupQueryer(session))
+	req.PasswordHistories = usermodels.NewPasswordHistoryDao(session)
 	return req, nil
 }
 
@@ -207,6 +244,14 @@ func (svc *Service) GetUsers(ctx *RequestContext, query *usermodels.UserQueryPar
 	return userList, nil
 }
 
+func (svc *Service) GetUserCount(ctx *RequestContext, query *usermodels.UserQueryParams) (int64, error) {
+	count, err := ctx.Users.UserDao.GetUserCount(ctx.Ctx, query)
+	if err != nil {
+		return 0, errors.Wrap(err, "查询用户数失败")
+	}
+	return count, nil
+}
+
 func (svc *Service) GetUserByID(ctx *RequestContext, id int64, opts *UserQueryOptions) (*usermodels.User, error) {
 	u, err := ctx.Users.GetUserByID(ctx.Ctx, id)
 	if err != nil {
@@ -332,6 +377,17 @@ func (svc *Service) CreateUser(ctx *RequestContext, user *usermodels.User, selec
 		return 0, validation.NewValidationError("Nickname", "该用户姓名 '"+user.Nickname+"' 已存在!")
 	}
 
+	if err := svc.validateAttributes(ctx, user, nil); err != nil {
+		return 0, err
+	}
+
+	if user.Password != "" && user.Source != "cas" && user.Source != "ldap" {
+		validator := svc.Validator.New()
+		if svc.PasswordPolicy.Validate(validator, user, user.Password) {
+			return 0, validator.ToError()
+		}
+	}
+
 	ctx, err = ctx.Begin()
 	if err != nil {
 		return 0, err
@@ -353,6 +409,8 @@ func (svc *Service) CreateUser(ctx *RequestContext, user *usermodels.User, selec
 		return 0, errors.Wrap(err, "添加操作日志失败")
 	}
 
+	ctx.notifyUsers(api.ChangeCreated, userID)
+
 	if err := ctx.Commit(); err != nil {
 		return 0, err
 	}
@@ -368,12 +426,20 @@ func (svc *Service) UpdateUserFields(ctx *RequestContext, userID int64, values m
 		return errors.Wrap(err, "查询用户失败")
 	}
 
+	newUser := *oldUser
+	newUser.Attributes = map[string]interface{}{}
+	for k, v := range oldUser.Attributes {
+		newUser.Attributes[k] = v
+	}
 	for k, v := range values {
-		oldUser.Attributes[k] = v
+		newUser.Attributes[k] = v
+	}
+	if err := svc.validateAttributes(ctx, &newUser, oldUser); err != nil {
+		return err
 	}
 
 	return ctx.InTransaction(func(ctx *RequestContext) error {
-		err = ctx.Users.UpdateUser(ctx.Ctx, userID, oldUser)
+		err = ctx.Users.UpdateUser(ctx.Ctx, userID, &newUser)
 		if err != nil {
 			return errors.Wrap(err, "更新用户失败")
 		}
@@ -389,6 +455,7 @@ func (svc *Service) UpdateUserFields(ctx *RequestContext, userID int64, values m
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeUpdated, userID)
 		return nil
 	})
 }
@@ -468,6 +535,10 @@ func (svc *Service) UpdateUser(ctx *RequestContext, userID int64, user *usermode
 		}
 	}
 
+	if err := svc.validateAttributes(ctx, user, oldUser); err != nil {
+		return err
+	}
+
 	hasPassword := user.Password != ""
 	if user.Source == "cas" || user.Source == "ldap" {
 		hasPassword = false
@@ -485,17 +556,25 @@ func (svc *Service) UpdateUser(ctx *RequestContext, userID int64, user *usermode
 		return validator.ToError()
 	}
 
+	var newPassword string
 	if hasPassword {
-		user.Password = user.Password
-	} else {
-		user.Password = oldUser.Password
+		if err := svc.checkPassword(ctx, oldUser, user.Password); err != nil {
+			return err
+		}
+		newPassword = user.Password
 	}
+	user.Password = oldUser.Password
 
 	return ctx.InTransaction(func(ctx *RequestContext) error {
 		err = ctx.Users.UpdateUser(ctx.Ctx, userID, user)
 		if err != nil {
 			return errors.Wrap(err, "更新用户失败")
 		}
+		if hasPassword {
+			if err := svc.savePassword(ctx, oldUser, newPassword); err != nil {
+				return err
+			}
+		}
 
 		content := "更新用户: " + user.Name
 		switch updateRole {
@@ -543,6 +622,7 @@ func (svc *Service) UpdateUser(ctx *RequestContext, userID int64, user *usermode
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeUpdated, userID)
 		return nil
 	})
 }
@@ -578,6 +658,7 @@ func (svc *Service) UpdateUserRoles(ctx *RequestContext, userID int64, newRoles
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeRolesChanged, userID)
 		return nil
 	})
 }
@@ -604,11 +685,69 @@ func (svc *Service) UpdateUserPasswordByName(ctx *RequestContext, name string, n
 	return svc.updateUserPassword(ctx, oldUser, newPassword)
 }
 
+// checkPassword 检查新密码是否符合密码策略，以及是否重用了最近用过的密码
+func (svc *Service) checkPassword(ctx *RequestContext, user *usermodels.User, newPassword string) error {
+	validator := svc.Validator.New()
+	if svc.PasswordPolicy.Validate(validator, user, newPassword) {
+		return validator.ToError()
+	}
+
+	if svc.PasswordPolicy.HistoryCount <= 0 || svc.PasswordComparer == nil {
+		return nil
+	}
+
+	hashedList := []string{user.Password}
+	histories, err := ctx.PasswordHistories.List(ctx.Ctx, user.ID, svc.PasswordPolicy.HistoryCount)
+	if err != nil {
+		return errors.Wrap(err, "查询用户的历史密码失败")
+	}
+	for idx := range histories {
+		hashedList = append(hashedList, histories[idx].Password)
+	}
+	for _, hashed := range hashedList {
+		if hashed == "" {
+			continue
+		}
+		if svc.PasswordComparer(newPassword, hashed) == nil {
+			return validation.NewValidationError("Password", "不能使用最近 "+strconv.Itoa(svc.PasswordPolicy.HistoryCount)+" 次用过的密码")
+		}
+	}
+	return nil
+}
+
+// savePassword 保存新密码并记录历史密码，调用者需要在事务中调用它
+func (svc *Service) savePassword(ctx *RequestContext, user *usermodels.User, newPassword string) error {
+	err := ctx.Users.UpdateUserPassword(ctx.Ctx, user.ID, newPassword)
+	if err != nil {
+		return errors.Wrap(err, "更改用户密码失败")
+	}
+
+	if svc.PasswordPolicy.HistoryCount > 0 {
+		if err := ctx.PasswordHistories.AddCurrent(ctx.Ctx, user.ID); err != nil {
+			return errors.Wrap(err, "保存用户的历史密码失败")
+		}
+		if _, err := ctx.PasswordHistories.DeleteOld(ctx.Ctx, user.ID, svc.PasswordPolicy.HistoryCount); err != nil {
+			return errors.Wrap(err, "删除用户的历史密码失败")
+		}
+	}
+
+	// 管理员重置别人的密码后，该用户下次登录时必须修改密码
+	if svc.PasswordPolicy.ChangeAfterReset && ctx.CurrentUser.ID() != user.ID {
+		if _, err := ctx.Users.UserDao.SetMustChangePassword(ctx.Ctx, user.ID, true); err != nil {
+			return errors.Wrap(err, "设置用户下次登录时修改密码失败")
+		}
+	}
+	return nil
+}
+
 func (svc *Service) updateUserPassword(ctx *RequestContext, user *usermodels.User, newPassword string) error {
+	if err := svc.checkPassword(ctx, user, newPassword); err != nil {
+		return err
+	}
+
 	return ctx.InTransaction(func(ctx *RequestContext) error {
-		err := ctx.Users.UpdateUserPassword(ctx.Ctx, user.ID, newPassword)
-		if err != nil {
-			return errors.Wrap(err, "更改用户密码失败")
+		if err := svc.savePassword(ctx, user, newPassword); err != nil {
+			return err
 		}
 
 		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
@@ -620,6 +759,7 @@ func (svc *Service) updateUserPassword(ctx *RequestContext, user *usermodels.Use
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeUpdated, user.ID)
 		return nil
 	})
 }
@@ -662,6 +802,7 @@ func (svc *Service) enableUser(ctx *RequestContext, user *usermodels.User) error
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeEnabled, user.ID)
 		return nil
 	})
 }
@@ -704,6 +845,41 @@ func (svc *Service) disableUser(ctx *RequestContext, user *usermodels.User) erro
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeDisabled, user.ID)
+		return nil
+	})
+}
+
+// SetUserExpiresAt 设置帐号的过期时间, expiresAt 为 nil 时永不过期
+func (svc *Service) SetUserExpiresAt(ctx *RequestContext, userID int64, expiresAt *time.Time) error {
+	user, err := ctx.Users.GetUserByID(ctx.Ctx, userID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return errors.ErrNotFoundWithText("该用户不存在!")
+		}
+		return errors.Wrap(err, "查询用户失败")
+	}
+
+	content := "取消用户 '" + user.Name + "' 的过期时间"
+	if expiresAt != nil {
+		content = "设置用户 '" + user.Name + "' 的过期时间为 " + expiresAt.Format(time.RFC3339)
+	}
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		_, err := ctx.Users.UserDao.SetUserExpiresAt(ctx.Ctx, user.ID, expiresAt)
+		if err != nil {
+			return errors.Wrap(err, "设置用户 '"+user.Name+"' 的过期时间失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "set_user_expires",
+			Successful: true,
+			Content:    content,
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsers(api.ChangeUpdated, user.ID)
 		return nil
 	})
 }
@@ -768,6 +944,25 @@ func nullString(name string) sql.NullString {
 
 const deleteTag = "(deleted:"
 
+// IsDeleted 用户是否已被软删除
+func IsDeleted(user *usermodels.User) bool {
+	return strings.Contains(user.Name, deleteTag)
+}
+
+// DeletedAt 返回软删除用户的删除时间, 它记录在用户名后的删除标记中
+func DeletedAt(user *usermodels.User) (time.Time, bool) {
+	idx := strings.LastIndex(user.Name, deleteTag)
+	if idx < 0 {
+		return time.Time{}, false
+	}
+	s := strings.TrimSuffix(strings.TrimSpace(user.Name[idx+len(deleteTag):]), ")")
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
 // DeleteUser 删除用户
 func (svc *Service) DeleteUser(ctx *RequestContext, userID int64, notDelete bool) error {
 	oldUser, err := ctx.Users.GetUserByID(ctx.Ctx, userID)
@@ -794,6 +989,10 @@ func (svc *Service) DeleteUserByName(ctx *RequestContext, name string, notDelete
 
 func (svc *Service) deleteUser(ctx *RequestContext, user *usermodels.User, notDelete bool) error {
 	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if err := ctx.notifyUsergroupsOfUser(user.ID); err != nil {
+			return err
+		}
+
 		var err error
 		username := user.Name
 		if notDelete {
@@ -826,6 +1025,7 @@ func (svc *Service) deleteUser(ctx *RequestContext, user *usermodels.User, notDe
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeDeleted, user.ID)
 		return nil
 	})
 }
@@ -889,6 +1089,7 @@ func (svc *Service) recoveryUser(ctx *RequestContext, user *usermodels.User) err
 		}); err != nil {
 			return errors.Wrap(err, "添加操作日志失败")
 		}
+		ctx.notifyUsers(api.ChangeEnabled, user.ID)
 		return nil
 	})
 }
@@ -903,6 +1104,11 @@ func NewService(env *moo.Environment,
 	if welcomeRootURL == "" {
 		return nil, errors.New("初始用户服务失败： 缺少参数 '" + api.CfgRootEndpoint + "'")
 	}
+	passwordPolicy, err := ReadPasswordPolicy(env)
+	if err != nil {
+		return nil, err
+	}
+
 	session := factory.SessionReference()
 	return &Service{
 		Env:            env,
@@ -914,6 +1120,12 @@ func NewService(env *moo.Environment,
 		OnlineExpired:  env.Config.StringWithDefault(api.CfgUserOnlineExpired, "30 MINUTE"),
 		WelcomeRootURL: welcomeRootURL,
 		Validator:      validator,
+
+		PasswordHistories: usermodels.NewPasswordHistoryDao(session),
+		PasswordPolicy:    passwordPolicy,
+
+		FieldSchemas:            usermodels.NewUserFieldSchemaDao(session),
+		FieldValidationDisabled: env.Config.BoolWithDefault(api.CfgUserFieldsValidationDisabled, false),
 	}, nil
 }
 
@@ -923,14 +1135,31 @@ type OptValidation struct {
 	Validator *validation.Validation `optional:"true"`
 }
 
+// PasswordComparer 比较明文密码和加密后的密码，用来检查是否重用了最近用过的密码,
+// 它由 users 包用 authn 的加密算法提供，以免本包依赖 authn
+type PasswordComparer func(password, hashed string) error
+
+type OptPasswordComparer struct {
+	moo.In
+
+	Comparer PasswordComparer `optional:"true"`
+}
+
 func init() {
 	moo.On(func(*moo.Environment) moo.Option {
-		return moo.Provide(func(env *moo.Environment, model db.InModelFactory, users *usermodels.Users, opLogger api.OperationLogger, optValidator OptValidation) (*Service, error) {
+		return moo.Provide(func(env *moo.Environment, model db.InModelFactory, users *usermodels.Users, opLogger api.OperationLogger, optValidator OptValidation, optComparer OptPasswordComparer, bus *moo.Bus) (*Service, error) {
 			validator := optValidator.Validator
 			if validator == nil {
 				validator = validation.Default
 			}
-			return NewService(env, model.Factory, users, opLogger, validator)
+			svc, err := NewService(env, model.Factory, users, opLogger, validator)
+			if err != nil {
+				return nil, err
+			}
+			RegisterTopics(bus)
+			svc.Bus = bus
+			svc.PasswordComparer = optComparer.Comparer
+			return svc, nil
 		})
 	})
 }
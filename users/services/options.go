@@ -28,11 +28,15 @@ var (
 	Email = Field{ID: "email",
 		Name:      "邮箱",
 		IsDefault: "true",
-		Type:      "text"}
+		Type:      FieldTypeEmail,
+		Editor:    "text",
+		Unique:    true}
 	Phone = Field{ID: "phone",
 		Name:      "电话",
 		IsDefault: "true",
-		Type:      "text"}
+		Type:      FieldTypePhone,
+		Editor:    "text",
+		Unique:    true}
 
 	DefaultFields = []Field{
 		WhiteAddressList,
@@ -51,6 +55,11 @@ type Field struct {
 	IsDefault    string `json:"-"`
 	Editor       string `json:"editor,omitempty"`
 
+	// Required 为 true 时创建和修改用户时必须填写, 来自 LDAP 和 CAS 的用户除外
+	Required bool `json:"required,omitempty"`
+	// Unique 为 true 时不能和其它用户(不包括已删除的)的相同, 比较时忽略大小写
+	Unique bool `json:"unique,omitempty"`
+
 	Enumerations []welcome.InputOption `json:"enumerations,omitempty"`
 }
 
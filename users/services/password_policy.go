@@ -0,0 +1,220 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+)
+
+// 内置的弱密码，启用 users.password.reject_weak 时才检查，配置了字典文件时会合并进来
+var defaultWeakPasswords = []string{
+	"12345678", "123456789", "1234567890", "87654321",
+	"password", "password1", "password123", "passw0rd",
+	"qwerty123", "qwertyuiop", "1qaz2wsx", "1q2w3e4r",
+	"abc12345", "abcd1234", "iloveyou", "admin123",
+	"admin@123", "administrator", "welcome1", "11111111",
+	"88888888", "00000000", "a1234567", "aa123456",
+}
+
+// PasswordPolicy 密码策略
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	MinCharClasses int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+
+	// NotContainUsername 密码不能包含用户名（忽略大小写）
+	NotContainUsername bool
+	Dictionary         map[string]struct{}
+
+	// HistoryCount 不能重用最近几次的密码
+	HistoryCount int
+
+	// MaxAge 密码的最长使用时间，为 0 时表示不过期
+	MaxAge time.Duration
+	// WarnBefore 密码过期前多长时间开始提醒
+	WarnBefore time.Duration
+
+	// ChangeAfterReset 管理员重置密码后用户下次登录时必须修改密码
+	ChangeAfterReset bool
+}
+
+// ReadPasswordPolicy 读密码策略，缺省的策略和以前一样宽松（最短长度仍由 User.Validate 检查），
+// 更严格的规则需要在配置中启用，以免升级后已有的部署和调用者创建用户或修改密码失败
+func ReadPasswordPolicy(env *moo.Environment) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength:          env.Config.IntWithDefault(api.CfgUserPasswordMinLength, 0),
+		MaxLength:          env.Config.IntWithDefault(api.CfgUserPasswordMaxLength, 250),
+		MinCharClasses:     env.Config.IntWithDefault(api.CfgUserPasswordMinCharClasses, 0),
+		RequireUpper:       env.Config.BoolWithDefault(api.CfgUserPasswordRequireUpper, false),
+		RequireLower:       env.Config.BoolWithDefault(api.CfgUserPasswordRequireLower, false),
+		RequireDigit:       env.Config.BoolWithDefault(api.CfgUserPasswordRequireDigit, false),
+		RequireSpecial:     env.Config.BoolWithDefault(api.CfgUserPasswordRequireSpecial, false),
+		NotContainUsername: env.Config.BoolWithDefault(api.CfgUserPasswordNotContainUsername, false),
+		HistoryCount:       env.Config.IntWithDefault(api.CfgUserPasswordHistory, 0),
+		MaxAge:             env.Config.DurationWithDefault(api.CfgUserPasswordMaxAge, 0),
+		WarnBefore:         env.Config.DurationWithDefault(api.CfgUserPasswordWarnBefore, 7*24*time.Hour),
+		ChangeAfterReset:   env.Config.BoolWithDefault(api.CfgUserPasswordChangeAfterReset, false),
+		Dictionary:         map[string]struct{}{},
+	}
+	if env.Config.BoolWithDefault(api.CfgUserPasswordRejectWeak, false) {
+		for _, s := range defaultWeakPasswords {
+			policy.Dictionary[s] = struct{}{}
+		}
+	}
+
+	if filename := env.Config.StringWithDefault(api.CfgUserPasswordDictionary, ""); filename != "" {
+		if !filepath.IsAbs(filename) {
+			filename = env.Fs.FromConfig(filename)
+		}
+		if err := policy.LoadDictionary(filename); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// LoadDictionary 读弱密码字典，每行一个
+func (policy *PasswordPolicy) LoadDictionary(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "读弱密码字典 '"+filename+"' 失败")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		policy.Dictionary[strings.ToLower(s)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "读弱密码字典 '"+filename+"' 失败")
+	}
+	return nil
+}
+
+// Validate 检查密码是否符合策略，不符合的项会作为 Password 字段的错误加到 validator 中
+func (policy *PasswordPolicy) Validate(validator *validation.Validation, user *usermodels.User, password string) bool {
+	const key = "Password"
+
+	length := len([]rune(password))
+	if policy.MinLength > 0 && length < policy.MinLength {
+		validator.Error(key, "密码长度不能少于 "+strconv.Itoa(policy.MinLength)+" 个字符")
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		validator.Error(key, "密码长度不能超过 "+strconv.Itoa(policy.MaxLength)+" 个字符")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSpecial = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		validator.Error(key, "密码必须包含大写字母")
+	}
+	if policy.RequireLower && !hasLower {
+		validator.Error(key, "密码必须包含小写字母")
+	}
+	if policy.RequireDigit && !hasDigit {
+		validator.Error(key, "密码必须包含数字")
+	}
+	if policy.RequireSpecial && !hasSpecial {
+		validator.Error(key, "密码必须包含特殊字符")
+	}
+	if policy.MinCharClasses > 0 {
+		classes := 0
+		for _, b := range []bool{hasUpper, hasLower, hasDigit, hasSpecial} {
+			if b {
+				classes++
+			}
+		}
+		if classes < policy.MinCharClasses {
+			validator.Error(key, "密码必须包含大写字母、小写字母、数字和特殊字符中的至少 "+strconv.Itoa(policy.MinCharClasses)+" 种")
+		}
+	}
+
+	lower := strings.ToLower(password)
+	if _, exists := policy.Dictionary[lower]; exists {
+		validator.Error(key, "密码太简单，容易被猜到")
+	}
+	if policy.NotContainUsername && user != nil {
+		for _, name := range []string{user.Name, user.Nickname} {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if len(name) < 3 {
+				continue
+			}
+			if strings.Contains(lower, name) || strings.Contains(lower, reverseString(name)) {
+				validator.Error(key, "密码不能包含用户名")
+				break
+			}
+		}
+	}
+	return validator.HasErrors()
+}
+
+// ExpiresAt 返回密码的过期时间，不过期时返回零值
+func (policy *PasswordPolicy) ExpiresAt(user *usermodels.User) time.Time {
+	if policy.MaxAge <= 0 {
+		return time.Time{}
+	}
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil && !user.PasswordChangedAt.IsZero() {
+		changedAt = *user.PasswordChangedAt
+	}
+	if changedAt.IsZero() {
+		return time.Time{}
+	}
+	return changedAt.Add(policy.MaxAge)
+}
+
+// PasswordState 返回用户是否必须修改密码，密码的过期时间，以及是否要提醒用户密码即将过期
+func (policy *PasswordPolicy) PasswordState(user *usermodels.User, now time.Time) (mustChange bool, expiresAt time.Time, warn bool) {
+	if user.Source == "ldap" || user.Source == "cas" {
+		return false, time.Time{}, false
+	}
+
+	expiresAt = policy.ExpiresAt(user)
+	if user.MustChangePassword {
+		return true, expiresAt, false
+	}
+	if expiresAt.IsZero() {
+		return false, expiresAt, false
+	}
+	if !now.Before(expiresAt) {
+		return true, expiresAt, false
+	}
+	return false, expiresAt, now.Add(policy.WarnBefore).After(expiresAt)
+}
+
+func reverseString(s string) string {
+	rs := []rune(s)
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return string(rs)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:          8,
+		MinCharClasses:     3,
+		NotContainUsername: true,
+		Dictionary:         map[string]struct{}{"password123": {}},
+	}
+	user := &usermodels.User{Name: "alice", Nickname: "Alice"}
+
+	for _, test := range []struct {
+		password string
+		ok       bool
+	}{
+		{password: "Ab1!", ok: false},
+		{password: "abcdefgh", ok: false},
+		{password: "Password123", ok: false},
+		{password: "xAlice_2020", ok: false},
+		{password: "xecila_2020", ok: false},
+		{password: "Blue_Sky_2020", ok: true},
+	} {
+		validator := validation.Default.New()
+		hasErrors := policy.Validate(validator, user, test.password)
+		if hasErrors == test.ok {
+			t.Errorf("%q: want ok is %v, got errors %v", test.password, test.ok, validator.ToError())
+		}
+	}
+}
+
+func TestPasswordPolicyState(t *testing.T) {
+	policy := &PasswordPolicy{
+		MaxAge:     30 * 24 * time.Hour,
+		WarnBefore: 7 * 24 * time.Hour,
+	}
+	now := time.Now()
+
+	changedAt := now.Add(-10 * 24 * time.Hour)
+	user := &usermodels.User{PasswordChangedAt: &changedAt}
+	if mustChange, _, warn := policy.PasswordState(user, now); mustChange || warn {
+		t.Error("want not mustChange and not warn")
+	}
+
+	changedAt = now.Add(-25 * 24 * time.Hour)
+	if mustChange, _, warn := policy.PasswordState(user, now); mustChange || !warn {
+		t.Error("want warn")
+	}
+
+	changedAt = now.Add(-31 * 24 * time.Hour)
+	if mustChange, _, _ := policy.PasswordState(user, now); !mustChange {
+		t.Error("want mustChange")
+	}
+
+	user = &usermodels.User{MustChangePassword: true}
+	if mustChange, _, _ := policy.PasswordState(user, now); !mustChange {
+		t.Error("want mustChange")
+	}
+
+	user = &usermodels.User{Source: "ldap", MustChangePassword: true}
+	if mustChange, _, _ := policy.PasswordState(user, now); mustChange {
+		t.Error("ldap user want not mustChange")
+	}
+}
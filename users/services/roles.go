@@ -0,0 +1,388 @@
+package services
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+)
+
+var (
+	ErrRoleNotFound    = errors.ErrNotFoundWithText("该角色不存在!")
+	ErrRoleIsBuiltin   = errors.NewError(http.StatusBadRequest, "内置角色不能删除或改名")
+	ErrRoleCycle       = errors.NewError(http.StatusBadRequest, "上级角色不能是它自已或它的下级角色")
+	ErrRoleHasChildren = errors.NewError(http.StatusBadRequest, "该角色还有下级角色, 不能删除")
+)
+
+func (svc *Service) getRole(ctx *RequestContext, id int64) (*usermodels.Role, error) {
+	var role usermodels.Role
+	err := ctx.Users.UserDao.GetRoleByID(ctx.Ctx, id)(&role)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, errors.Wrap(err, "查询角色失败")
+	}
+	return &role, nil
+}
+
+// GetRoles 查询角色
+func (svc *Service) GetRoles(ctx *RequestContext, nameLike string, offset, limit int64) ([]usermodels.Role, error) {
+	return usermodels.GetRoles(ctx.Ctx, ctx.Users.UserDao, nameLike, offset, limit)
+}
+
+// GetRoleByID 按 id 查询角色
+func (svc *Service) GetRoleByID(ctx *RequestContext, id int64) (*usermodels.Role, error) {
+	return svc.getRole(ctx, id)
+}
+
+// checkRoleParent 检查上级角色是否存在, 并且不能形成环
+func (svc *Service) checkRoleParent(ctx *RequestContext, id, parentID int64) error {
+	if parentID == 0 {
+		return nil
+	}
+	if id != 0 && id == parentID {
+		return ErrRoleCycle
+	}
+	if _, err := svc.getRole(ctx, parentID); err != nil {
+		if errors.IsNotFound(err) {
+			return validation.NewValidationError("ParentID", "上级角色不存在!")
+		}
+		return err
+	}
+	if id == 0 {
+		return nil
+	}
+
+	ancestors, err := ctx.Users.UserDao.GetRoleAncestors(ctx.Ctx, parentID)
+	if err != nil {
+		return errors.Wrap(err, "查询上级角色失败")
+	}
+	for idx := range ancestors {
+		if ancestors[idx].ID == id {
+			return ErrRoleCycle
+		}
+	}
+	return nil
+}
+
+// CreateRole 创建角色
+func (svc *Service) CreateRole(ctx *RequestContext, role *usermodels.Role) (int64, error) {
+	role.Name = strings.TrimSpace(role.Name)
+	validator := svc.Validator.New()
+	if role.Validate(validator) {
+		return 0, validator.ToError()
+	}
+
+	exists, err := ctx.Users.UserDao.RolenameExists(ctx.Ctx, role.Name)
+	if err != nil {
+		return 0, errors.Wrap(err, "查询角色名是否已存在失败")
+	}
+	if exists {
+		return 0, validation.NewValidationError("Name", "该角色名 '"+role.Name+"' 已存在!")
+	}
+	if err := svc.checkRoleParent(ctx, 0, role.ParentID); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = ctx.InTransaction(func(ctx *RequestContext) error {
+		id, err = ctx.Users.UserDao.CreateRole(ctx.Ctx, role)
+		if err != nil {
+			return errors.Wrap(err, "创建角色失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "add_role",
+			Successful: true,
+			Content:    "创建角色: " + role.Name,
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateRole 修改角色, 内置角色不能改名
+func (svc *Service) UpdateRole(ctx *RequestContext, id int64, role *usermodels.Role) error {
+	oldRole, err := svc.getRole(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	role.Name = strings.TrimSpace(role.Name)
+	validator := svc.Validator.New()
+	if role.Validate(validator) {
+		return validator.ToError()
+	}
+	if oldRole.Name != role.Name {
+		if oldRole.IsBuiltin() {
+			return ErrRoleIsBuiltin
+		}
+		exists, err := ctx.Users.UserDao.RolenameExists(ctx.Ctx, role.Name)
+		if err != nil {
+			return errors.Wrap(err, "查询角色名是否已存在失败")
+		}
+		if exists {
+			return validation.NewValidationError("Name", "该角色名 '"+role.Name+"' 已存在!")
+		}
+	}
+	if err := svc.checkRoleParent(ctx, id, role.ParentID); err != nil {
+		return err
+	}
+	role.ID = id
+	role.IsDefault = oldRole.IsDefault
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if _, err := ctx.Users.UserDao.UpdateRole(ctx.Ctx, id, role); err != nil {
+			return errors.Wrap(err, "更新角色失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "update_role",
+			Successful: true,
+			Content:    "更新角色: " + role.Name,
+			Fields: &api.OperationLogRecord{
+				ObjectType: "role",
+				ObjectID:   id,
+				Records: []api.ChangeRecord{
+					{Name: "name", OldValue: oldRole.Name, NewValue: role.Name},
+					{Name: "description", OldValue: oldRole.Description, NewValue: role.Description},
+					{Name: "parent_id", OldValue: oldRole.ParentID, NewValue: role.ParentID},
+				},
+			},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		// 角色的上下级关系会影响用户的有效角色, 无法精确地计算受影响的用户, 所以刷新全部用户
+		ctx.notifyUsers(api.ChangeRefresh)
+		return nil
+	})
+}
+
+// DeleteRole 删除角色, 内置角色和有下级角色的角色不能删除
+func (svc *Service) DeleteRole(ctx *RequestContext, id int64) error {
+	oldRole, err := svc.getRole(ctx, id)
+	if err != nil {
+		return err
+	}
+	if oldRole.IsBuiltin() {
+		return ErrRoleIsBuiltin
+	}
+	children, err := ctx.Users.UserDao.GetChildRoles(ctx.Ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "查询下级角色失败")
+	}
+	if len(children) > 0 {
+		return ErrRoleHasChildren
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if _, err := ctx.Users.UserDao.DeleteRole(ctx.Ctx, id); err != nil {
+			return errors.Wrap(err, "删除角色失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "delete_role",
+			Successful: true,
+			Content:    "删除角色: " + oldRole.Name,
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsers(api.ChangeRefresh)
+		return nil
+	})
+}
+
+// getUsernames 按 id 查询用户名, 有用户不存在时返回错误
+func (svc *Service) getUsernames(ctx *RequestContext, userIDs []int64) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	names, err := ctx.Users.UserDao.GetUsernamesByUserIDs(ctx.Ctx, userIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	list := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		name, ok := names[id]
+		if !ok {
+			return nil, errors.ErrNotFoundWithText("用户 '" + strconv.FormatInt(id, 10) + "' 不存在!")
+		}
+		list = append(list, name)
+	}
+	return list, nil
+}
+
+// AddUsersToRole 批量将角色授于用户
+func (svc *Service) AddUsersToRole(ctx *RequestContext, roleID int64, userIDs []int64) error {
+	role, err := svc.getRole(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	usernames, err := svc.getUsernames(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		for _, userID := range userIDs {
+			if err := ctx.Users.UserDao.AddRoleToUser(ctx.Ctx, userID, roleID); err != nil {
+				return errors.Wrap(err, "授于角色失败")
+			}
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "add_users_to_role",
+			Successful: true,
+			Content:    "将角色 '" + role.Name + "' 授于用户 '" + strings.Join(usernames, ",") + "'",
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsers(api.ChangeRolesChanged, userIDs...)
+		return nil
+	})
+}
+
+// RemoveUsersFromRole 批量收回用户的角色
+func (svc *Service) RemoveUsersFromRole(ctx *RequestContext, roleID int64, userIDs []int64) error {
+	role, err := svc.getRole(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	usernames, err := svc.getUsernames(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		for _, userID := range userIDs {
+			if err := ctx.Users.UserDao.RemoveRoleFromUser(ctx.Ctx, userID, roleID); err != nil {
+				return errors.Wrap(err, "收回角色失败")
+			}
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "remove_users_from_role",
+			Successful: true,
+			Content:    "收回用户 '" + strings.Join(usernames, ",") + "' 的角色 '" + role.Name + "'",
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsers(api.ChangeRolesChanged, userIDs...)
+		return nil
+	})
+}
+
+// GetUsergroupDefaultRoles 查询用户组的默认角色
+func (svc *Service) GetUsergroupDefaultRoles(ctx *RequestContext, groupID int64) ([]usermodels.Role, error) {
+	roles, err := ctx.Usergroups.GetDefaultRolesByUsergroupID(ctx.Ctx, groupID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户组的默认角色失败")
+	}
+	return roles, nil
+}
+
+// UpdateUsergroupDefaultRoles 修改用户组的默认角色, 用户组的成员自动拥有这些角色
+func (svc *Service) UpdateUsergroupDefaultRoles(ctx *RequestContext, groupID int64, roleIDs []int64) error {
+	var group usermodels.Usergroup
+	if err := ctx.Usergroups.GetUsergroupByID(ctx.Ctx, groupID)(&group); err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return errors.ErrNotFoundWithText("该用户组不存在!")
+		}
+		return errors.Wrap(err, "查询用户组失败")
+	}
+	for _, roleID := range roleIDs {
+		if _, err := svc.getRole(ctx, roleID); err != nil {
+			return err
+		}
+	}
+	oldRoles, err := svc.GetUsergroupDefaultRoles(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		var created, deleted []string
+		for _, r := range oldRoles {
+			found := false
+			for _, roleID := range roleIDs {
+				if r.ID == roleID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				if err := ctx.Usergroups.RemoveDefaultRoleFromGroup(ctx.Ctx, groupID, r.ID); err != nil {
+					return errors.Wrap(err, "删除用户组的默认角色失败")
+				}
+				deleted = append(deleted, r.Name)
+			}
+		}
+		for _, roleID := range roleIDs {
+			found := false
+			for _, r := range oldRoles {
+				if r.ID == roleID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				if err := ctx.Usergroups.AddDefaultRoleToGroup(ctx.Ctx, groupID, roleID); err != nil {
+					return errors.Wrap(err, "添加用户组的默认角色失败")
+				}
+				var r usermodels.Role
+				if err := ctx.Users.UserDao.GetRoleByID(ctx.Ctx, roleID)(&r); err != nil {
+					return errors.Wrap(err, "查询新增角色失败")
+				}
+				created = append(created, r.Name)
+			}
+		}
+		if len(created) == 0 && len(deleted) == 0 {
+			return nil
+		}
+
+		content := "修改用户组 '" + group.Name + "' 的默认角色"
+		if len(created) > 0 {
+			content = content + ", 新增角色 '" + strings.Join(created, ",") + "'"
+		}
+		if len(deleted) > 0 {
+			content = content + ", 删除角色 '" + strings.Join(deleted, ",") + "'"
+		}
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "change_usergroup_roles",
+			Successful: true,
+			Content:    content,
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		return ctx.notifyUsergroupMembers(api.ChangeRolesChanged, []int64{groupID}, false)
+	})
+}
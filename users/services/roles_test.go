@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+)
+
+type testRoleDao struct {
+	usermodels.UserDao
+
+	roles map[int64]usermodels.Role
+}
+
+func (dao *testRoleDao) GetRoleByID(ctx context.Context, id int64) func(*usermodels.Role) error {
+	return func(role *usermodels.Role) error {
+		r, ok := dao.roles[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*role = r
+		return nil
+	}
+}
+
+func (dao *testRoleDao) RolenameExists(ctx context.Context, name string) (bool, error) {
+	for _, r := range dao.roles {
+		if r.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (dao *testRoleDao) GetRoleAncestors(ctx context.Context, id int64) ([]usermodels.Role, error) {
+	var results []usermodels.Role
+	for r, ok := dao.roles[id]; ok; r, ok = dao.roles[r.ParentID] {
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (dao *testRoleDao) GetChildRoles(ctx context.Context, parentID int64) ([]usermodels.Role, error) {
+	var results []usermodels.Role
+	for _, r := range dao.roles {
+		if r.ParentID == parentID {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func newTestRoleContext() (*Service, *RequestContext) {
+	// 4 的上级是 3, 5 的上级是 4
+	dao := &testRoleDao{roles: map[int64]usermodels.Role{
+		1: {ID: 1, Name: api.RoleSuper},
+		2: {ID: 2, Name: "ops", IsDefault: true},
+		3: {ID: 3, Name: "manager"},
+		4: {ID: 4, Name: "operator", ParentID: 3},
+		5: {ID: 5, Name: "viewer", ParentID: 4},
+	}}
+	svc := &Service{Validator: validation.Default}
+	return svc, &RequestContext{
+		Ctx:   context.Background(),
+		Users: &usermodels.Users{UserDao: dao},
+	}
+}
+
+func TestRoleBuiltinGuard(t *testing.T) {
+	svc, ctx := newTestRoleContext()
+
+	for _, id := range []int64{1, 2} {
+		if err := svc.DeleteRole(ctx, id); err != ErrRoleIsBuiltin {
+			t.Error(id, "want ErrRoleIsBuiltin, got", err)
+		}
+		if err := svc.UpdateRole(ctx, id, &usermodels.Role{Name: "renamed"}); err != ErrRoleIsBuiltin {
+			t.Error(id, "want ErrRoleIsBuiltin, got", err)
+		}
+	}
+
+	if err := svc.DeleteRole(ctx, 3); err != ErrRoleHasChildren {
+		t.Error("want ErrRoleHasChildren, got", err)
+	}
+	if err := svc.DeleteRole(ctx, 99); err != ErrRoleNotFound {
+		t.Error("want ErrRoleNotFound, got", err)
+	}
+}
+
+func TestCheckRoleParent(t *testing.T) {
+	svc, ctx := newTestRoleContext()
+
+	for _, test := range []struct {
+		id, parentID int64
+		want         error
+	}{
+		{id: 3, parentID: 0},
+		{id: 0, parentID: 5},
+		{id: 5, parentID: 3},
+		{id: 3, parentID: 3, want: ErrRoleCycle},
+		{id: 3, parentID: 4, want: ErrRoleCycle},
+		{id: 3, parentID: 5, want: ErrRoleCycle},
+	} {
+		if err := svc.checkRoleParent(ctx, test.id, test.parentID); err != test.want {
+			t.Error(test.id, test.parentID, "want", test.want, "got", err)
+		}
+	}
+
+	if err := svc.checkRoleParent(ctx, 3, 99); err == nil || err == ErrRoleCycle {
+		t.Error("parent isnot exists -", err)
+	}
+}
@@ -0,0 +1,565 @@
+package services
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/runner-mei/errors"
+	"github.com/runner-mei/goutils/util"
+	"github.com/runner-mei/moo/api"
+	"github.com/runner-mei/moo/users/usermodels"
+	"github.com/runner-mei/validation"
+)
+
+var (
+	ErrUsergroupNotFound    = errors.ErrNotFoundWithText("该用户组不存在!")
+	ErrUsergroupCycle       = errors.NewError(http.StatusBadRequest, "上级用户组不能是它自已或它的下级用户组")
+	ErrUsergroupHasChildren = errors.NewError(http.StatusBadRequest, "该用户组还有下级用户组, 不能删除")
+	ErrUsergroupMergeCycle  = errors.NewError(http.StatusBadRequest, "不能将用户组合并到它自已或它的下级用户组")
+)
+
+// UsergroupNode 用户组树的节点
+type UsergroupNode struct {
+	usermodels.Usergroup
+	Children []*UsergroupNode `json:"children,omitempty"`
+}
+
+func (svc *Service) getUsergroup(ctx *RequestContext, id int64) (*usermodels.Usergroup, error) {
+	var group usermodels.Usergroup
+	if err := ctx.Usergroups.GetUsergroupByID(ctx.Ctx, id)(&group); err != nil {
+		if err == sql.ErrNoRows || errors.IsNotFound(err) {
+			return nil, ErrUsergroupNotFound
+		}
+		return nil, errors.Wrap(err, "查询用户组失败")
+	}
+	return &group, nil
+}
+
+// GetUsergroups 查询所有的用户组, userID 有效时只返回该用户所在的用户组
+func (svc *Service) GetUsergroups(ctx *RequestContext, userID sql.NullInt64) ([]usermodels.Usergroup, error) {
+	next, closer := ctx.Usergroups.GetUsergroups(ctx.Ctx, userID)
+	defer util.CloseWith(closer)
+
+	groups, err := usermodels.GetUsergroups(ctx.Ctx, next)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户组失败")
+	}
+	return groups, nil
+}
+
+// GetUsergroupByID 按 id 查询用户组
+func (svc *Service) GetUsergroupByID(ctx *RequestContext, id int64) (*usermodels.Usergroup, error) {
+	return svc.getUsergroup(ctx, id)
+}
+
+// GetUsergroupTree 按上下级关系返回用户组树, 上级用户组不存在的用户组作为根节点
+func (svc *Service) GetUsergroupTree(ctx *RequestContext) ([]*UsergroupNode, error) {
+	groups, err := svc.GetUsergroups(ctx, sql.NullInt64{})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int64]*UsergroupNode, len(groups))
+	for idx := range groups {
+		nodes[groups[idx].ID] = &UsergroupNode{Usergroup: groups[idx]}
+	}
+
+	roots := []*UsergroupNode{}
+	for idx := range groups {
+		node := nodes[groups[idx].ID]
+		parent := nodes[groups[idx].ParentID]
+		if groups[idx].ParentID == 0 || parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	sortUsergroupNodes(roots)
+	return roots, nil
+}
+
+// sortUsergroupNodes 同级的用户组按 Sequence 排序, Sequence 相同时按 ID 排序
+func sortUsergroupNodes(nodes []*UsergroupNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Sequence != nodes[j].Sequence {
+			return nodes[i].Sequence < nodes[j].Sequence
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	for _, node := range nodes {
+		sortUsergroupNodes(node.Children)
+	}
+}
+
+func (svc *Service) getUsergroupDescendants(ctx *RequestContext, id int64) ([]usermodels.Usergroup, error) {
+	next, closer := ctx.Usergroups.GetUsergroupsByRecursive(ctx.Ctx, id)
+	defer util.CloseWith(closer)
+
+	descendants, err := usermodels.GetUsergroups(ctx.Ctx, next)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询下级用户组失败")
+	}
+	return descendants, nil
+}
+
+// checkUsergroupParent 检查上级用户组是否存在, 并且不能形成环
+func (svc *Service) checkUsergroupParent(ctx *RequestContext, id, parentID int64) error {
+	if parentID == 0 {
+		return nil
+	}
+	if id != 0 && id == parentID {
+		return ErrUsergroupCycle
+	}
+	if _, err := svc.getUsergroup(ctx, parentID); err != nil {
+		if errors.IsNotFound(err) {
+			return validation.NewValidationError("ParentID", "上级用户组不存在!")
+		}
+		return err
+	}
+	if id == 0 {
+		return nil
+	}
+
+	descendants, err := svc.getUsergroupDescendants(ctx, id)
+	if err != nil {
+		return err
+	}
+	for idx := range descendants {
+		if descendants[idx].ID == parentID {
+			return ErrUsergroupCycle
+		}
+	}
+	return nil
+}
+
+// CreateUsergroup 创建用户组
+func (svc *Service) CreateUsergroup(ctx *RequestContext, group *usermodels.Usergroup) (int64, error) {
+	group.Name = strings.TrimSpace(group.Name)
+	validator := svc.Validator.New()
+	if group.Validate(validator) {
+		return 0, validator.ToError()
+	}
+
+	exists, err := ctx.Usergroups.UsergroupnameExists(ctx.Ctx, group.Name)
+	if err != nil {
+		return 0, errors.Wrap(err, "查询用户组名是否已存在失败")
+	}
+	if exists {
+		return 0, validation.NewValidationError("Name", "该用户组名 '"+group.Name+"' 已存在!")
+	}
+	if err := svc.checkUsergroupParent(ctx, 0, group.ParentID); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = ctx.InTransaction(func(ctx *RequestContext) error {
+		id, err = ctx.Usergroups.CreateUsergroup(ctx.Ctx, group)
+		if err != nil {
+			return errors.Wrap(err, "创建用户组失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "add_usergroup",
+			Successful: true,
+			Content:    "创建用户组: " + group.Name,
+			Fields:     &api.OperationLogRecord{ObjectType: "usergroup", ObjectID: id},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeCreated, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateUsergroup 修改用户组
+func (svc *Service) UpdateUsergroup(ctx *RequestContext, id int64, group *usermodels.Usergroup) error {
+	oldGroup, err := svc.getUsergroup(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	group.Name = strings.TrimSpace(group.Name)
+	validator := svc.Validator.New()
+	if group.Validate(validator) {
+		return validator.ToError()
+	}
+	if oldGroup.Name != group.Name {
+		exists, err := ctx.Usergroups.UsergroupnameExists(ctx.Ctx, group.Name)
+		if err != nil {
+			return errors.Wrap(err, "查询用户组名是否已存在失败")
+		}
+		if exists {
+			return validation.NewValidationError("Name", "该用户组名 '"+group.Name+"' 已存在!")
+		}
+	}
+	if err := svc.checkUsergroupParent(ctx, id, group.ParentID); err != nil {
+		return err
+	}
+	group.ID = id
+	// 顺序只能通过 SortUsergroups 修改
+	group.Sequence = oldGroup.Sequence
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if _, err := ctx.Usergroups.UpdateUsergroup(ctx.Ctx, id, group); err != nil {
+			return errors.Wrap(err, "更新用户组失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "update_usergroup",
+			Successful: true,
+			Content:    "更新用户组: " + group.Name,
+			Fields: &api.OperationLogRecord{
+				ObjectType: "usergroup",
+				ObjectID:   id,
+				Records: []api.ChangeRecord{
+					{Name: "name", OldValue: oldGroup.Name, NewValue: group.Name},
+					{Name: "description", OldValue: oldGroup.Description, NewValue: group.Description},
+					{Name: "parent_id", OldValue: oldGroup.ParentID, NewValue: group.ParentID},
+					{Name: "disabled", OldValue: oldGroup.Disabled, NewValue: group.Disabled},
+				},
+			},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeUpdated, id)
+		if oldGroup.Disabled != group.Disabled {
+			// 禁用的用户组的默认角色不生效
+			if err := ctx.notifyUsergroupMembers(api.ChangeRolesChanged, []int64{id}, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteUsergroup 删除用户组, recursive 为 true 时同时删除所有的下级用户组, 否则有下级用户组时不能删除
+func (svc *Service) DeleteUsergroup(ctx *RequestContext, id int64, recursive bool) error {
+	oldGroup, err := svc.getUsergroup(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	descendants, err := svc.getUsergroupDescendants(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !recursive && len(descendants) > 1 {
+		return ErrUsergroupHasChildren
+	}
+
+	ids := make([]int64, 0, len(descendants))
+	for idx := range descendants {
+		ids = append(ids, descendants[idx].ID)
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		// 删除前通知成员, 删除后就查不到了
+		if err := ctx.notifyUsergroupMembers(api.ChangeUsergroupsChanged, ids, false); err != nil {
+			return err
+		}
+		ctx.notifyUsergroups(api.ChangeDeleted, ids...)
+
+		if _, err := ctx.Usergroups.DeleteUsergroup(ctx.Ctx, id, recursive); err != nil {
+			return errors.Wrap(err, "删除用户组失败")
+		}
+
+		content := "删除用户组: " + oldGroup.Name
+		if len(descendants) > 1 {
+			names := make([]string, 0, len(descendants)-1)
+			for idx := range descendants {
+				if descendants[idx].ID != id {
+					names = append(names, descendants[idx].Name)
+				}
+			}
+			content = content + ", 同时删除下级用户组 '" + strings.Join(names, ",") + "'"
+		}
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "delete_usergroup",
+			Successful: true,
+			Content:    content,
+			Fields:     &api.OperationLogRecord{ObjectType: "usergroup", ObjectID: id},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		return nil
+	})
+}
+
+// MoveUsergroup 将用户组及它的所有下级用户组移到 parentID 下, parentID 为 0 时移为顶级用户组
+func (svc *Service) MoveUsergroup(ctx *RequestContext, id, parentID int64) error {
+	group, err := svc.getUsergroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if group.ParentID == parentID {
+		return nil
+	}
+	if err := svc.checkUsergroupParent(ctx, id, parentID); err != nil {
+		return err
+	}
+
+	content := "将用户组 '" + group.Name + "' 移为顶级用户组"
+	if parentID != 0 {
+		parent, err := svc.getUsergroup(ctx, parentID)
+		if err != nil {
+			return err
+		}
+		content = "将用户组 '" + group.Name + "' 移到 '" + parent.Name + "' 下"
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if _, err := ctx.Usergroups.MoveUsergroup(ctx.Ctx, id, parentID); err != nil {
+			return errors.Wrap(err, "移动用户组失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "move_usergroup",
+			Successful: true,
+			Content:    content,
+			Fields: &api.OperationLogRecord{
+				ObjectType: "usergroup",
+				ObjectID:   id,
+				Records: []api.ChangeRecord{
+					{Name: "parent_id", OldValue: group.ParentID, NewValue: parentID},
+				},
+			},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeUpdated, id)
+		return nil
+	})
+}
+
+// MergeUsergroup 将用户组 sourceID 合并到 targetID 中, 它的成员, 默认角色和下级用户组都转到 targetID 下, 然后删除 sourceID
+func (svc *Service) MergeUsergroup(ctx *RequestContext, sourceID, targetID int64) error {
+	source, err := svc.getUsergroup(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+	target, err := svc.getUsergroup(ctx, targetID)
+	if err != nil {
+		return err
+	}
+
+	// 下级用户组会被移到 targetID 下, 所以 targetID 不能是 sourceID 的下级, 否则会形成环
+	descendants, err := svc.getUsergroupDescendants(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+	for idx := range descendants {
+		if descendants[idx].ID == targetID {
+			return ErrUsergroupMergeCycle
+		}
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if err := ctx.Usergroups.CopyUsersToGroup(ctx.Ctx, sourceID, targetID); err != nil {
+			return errors.Wrap(err, "转移用户组的成员失败")
+		}
+		if err := ctx.Usergroups.CopyDefaultRolesToGroup(ctx.Ctx, sourceID, targetID); err != nil {
+			return errors.Wrap(err, "转移用户组的默认角色失败")
+		}
+		// 此时 targetID 的成员已包含 sourceID 的成员, 他们的用户组和角色都变了
+		if err := ctx.notifyUsergroupMembers(api.ChangeUsergroupsChanged, []int64{targetID}, false); err != nil {
+			return err
+		}
+		if _, err := ctx.Usergroups.MoveChildUsergroups(ctx.Ctx, sourceID, targetID); err != nil {
+			return errors.Wrap(err, "转移下级用户组失败")
+		}
+		if _, err := ctx.Usergroups.DeleteUsergroup(ctx.Ctx, sourceID, false); err != nil {
+			return errors.Wrap(err, "删除用户组失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "merge_usergroup",
+			Successful: true,
+			Content:    "将用户组 '" + source.Name + "' 合并到 '" + target.Name + "'",
+			Fields: &api.OperationLogRecord{
+				ObjectType: "usergroup",
+				ObjectID:   targetID,
+				Records: []api.ChangeRecord{
+					{Name: "merged_id", OldValue: sourceID, NewValue: targetID},
+				},
+			},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeDeleted, sourceID)
+		ctx.notifyUsergroups(api.ChangeMembersChanged, targetID)
+		for idx := range descendants {
+			if descendants[idx].ParentID == sourceID {
+				ctx.notifyUsergroups(api.ChangeUpdated, descendants[idx].ID)
+			}
+		}
+		return nil
+	})
+}
+
+// SortUsergroups 按 ids 的顺序排列 parentID 的下级用户组, ids 必须正好是 parentID 的所有直接下级用户组,
+// parentID 为 0 时排列顶级用户组
+func (svc *Service) SortUsergroups(ctx *RequestContext, parentID int64, ids []int64) error {
+	groups, err := svc.GetUsergroups(ctx, sql.NullInt64{})
+	if err != nil {
+		return err
+	}
+
+	children := map[int64]string{}
+	for idx := range groups {
+		if groups[idx].ParentID == parentID {
+			children[groups[idx].ID] = groups[idx].Name
+		}
+	}
+	seen := map[int64]bool{}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		name, ok := children[id]
+		if !ok {
+			return validation.NewValidationError("IDs", "用户组 '"+strconv.FormatInt(id, 10)+"' 不是同一个上级用户组的下级")
+		}
+		if seen[id] {
+			return validation.NewValidationError("IDs", "用户组 '"+name+"' 重复了")
+		}
+		seen[id] = true
+		names = append(names, name)
+	}
+	if len(ids) != len(children) {
+		return validation.NewValidationError("IDs", "必须包含所有的同级用户组")
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		for idx, id := range ids {
+			if _, err := ctx.Usergroups.UpdateUsergroupSequence(ctx.Ctx, id, idx+1); err != nil {
+				return errors.Wrap(err, "更新用户组的顺序失败")
+			}
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "sort_usergroups",
+			Successful: true,
+			Content:    "调整用户组的顺序为: " + strings.Join(names, ","),
+			Fields:     &api.OperationLogRecord{ObjectType: "usergroup", ObjectID: parentID},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeUpdated, ids...)
+		return nil
+	})
+}
+
+// IsUserInUsergroup 用户是不是用户组的成员, recursive 为 true 时为下级用户组的成员也算
+func (svc *Service) IsUserInUsergroup(ctx *RequestContext, groupID, userID int64, recursive bool) (bool, error) {
+	userIDs, err := ctx.Usergroups.GetUserIDsByGroupIDs(ctx.Ctx, []int64{groupID}, recursive, sql.NullBool{})
+	if err != nil && err != sql.ErrNoRows {
+		return false, errors.Wrap(err, "查询用户组的成员失败")
+	}
+	for _, id := range userIDs {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetUsergroupUsers 查询用户组的成员, recursive 为 true 时包含下级用户组的成员
+func (svc *Service) GetUsergroupUsers(ctx *RequestContext, groupID int64, recursive bool, userEnabled sql.NullBool) ([]usermodels.User, error) {
+	if _, err := svc.getUsergroup(ctx, groupID); err != nil {
+		return nil, err
+	}
+	users, err := ctx.Usergroups.GetUsersByGroupIDs(ctx.Ctx, []int64{groupID}, recursive, userEnabled)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户组的成员失败")
+	}
+	return users, nil
+}
+
+// AddUserToUsergroup 将用户加入用户组, roleID 大于 0 时为用户在该用户组内的角色
+func (svc *Service) AddUserToUsergroup(ctx *RequestContext, groupID, userID, roleID int64) error {
+	group, err := svc.getUsergroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	usernames, err := svc.getUsernames(ctx, []int64{userID})
+	if err != nil {
+		return err
+	}
+	content := "将用户 '" + usernames[0] + "' 加入用户组 '" + group.Name + "'"
+	if roleID > 0 {
+		role, err := svc.getRole(ctx, roleID)
+		if err != nil {
+			return err
+		}
+		content = content + ", 角色为 '" + role.Name + "'"
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if err := ctx.Usergroups.AddUserToGroup(ctx.Ctx, groupID, userID, roleID); err != nil {
+			return errors.Wrap(err, "将用户加入用户组失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "add_user_to_usergroup",
+			Successful: true,
+			Content:    content,
+			Fields:     &api.OperationLogRecord{ObjectType: "usergroup", ObjectID: groupID},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeMembersChanged, groupID)
+		ctx.notifyUsers(api.ChangeUsergroupsChanged, userID)
+		return nil
+	})
+}
+
+// RemoveUserFromUsergroup 将用户从用户组中移除
+func (svc *Service) RemoveUserFromUsergroup(ctx *RequestContext, groupID, userID int64) error {
+	group, err := svc.getUsergroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	usernames, err := svc.getUsernames(ctx, []int64{userID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.InTransaction(func(ctx *RequestContext) error {
+		if err := ctx.Usergroups.RemoveUserFromGroup(ctx.Ctx, groupID, userID); err != nil {
+			return errors.Wrap(err, "将用户从用户组中移除失败")
+		}
+
+		if err := ctx.OpLogger.Tx(ctx.Tx).LogRecord(ctx.Ctx, &api.OperationLog{
+			UserID:     ctx.CurrentUser.ID(),
+			Username:   ctx.CurrentUser.Name(),
+			Type:       "remove_user_from_usergroup",
+			Successful: true,
+			Content:    "将用户 '" + usernames[0] + "' 从用户组 '" + group.Name + "' 中移除",
+			Fields:     &api.OperationLogRecord{ObjectType: "usergroup", ObjectID: groupID},
+		}); err != nil {
+			return errors.Wrap(err, "添加操作日志失败")
+		}
+		ctx.notifyUsergroups(api.ChangeMembersChanged, groupID)
+		ctx.notifyUsers(api.ChangeUsergroupsChanged, userID)
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/runner-mei/moo/users/usermodels"
+)
+
+type testCloser struct{}
+
+func (testCloser) Close() error { return nil }
+
+type testUsergroupDao struct {
+	usermodels.UsergroupDao
+
+	groups map[int64]usermodels.Usergroup
+}
+
+func (dao *testUsergroupDao) iterate(list []usermodels.Usergroup) (func(*usermodels.Usergroup) (bool, error), io.Closer) {
+	idx := 0
+	return func(group *usermodels.Usergroup) (bool, error) {
+		if idx >= len(list) {
+			return false, nil
+		}
+		*group = list[idx]
+		idx++
+		return true, nil
+	}, testCloser{}
+}
+
+func (dao *testUsergroupDao) GetUsergroupByID(ctx context.Context, id int64) func(*usermodels.Usergroup) error {
+	return func(group *usermodels.Usergroup) error {
+		g, ok := dao.groups[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*group = g
+		return nil
+	}
+}
+
+func (dao *testUsergroupDao) GetUsergroups(ctx context.Context, userid sql.NullInt64) (func(*usermodels.Usergroup) (bool, error), io.Closer) {
+	var list []usermodels.Usergroup
+	for _, g := range dao.groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return dao.iterate(list)
+}
+
+func (dao *testUsergroupDao) GetUsergroupsByRecursive(ctx context.Context, id int64, list ...int64) (func(*usermodels.Usergroup) (bool, error), io.Closer) {
+	var results []usermodels.Usergroup
+	for _, g := range dao.groups {
+		for p := g; ; p = dao.groups[p.ParentID] {
+			if p.ID == id {
+				results = append(results, g)
+				break
+			}
+			if p.ParentID == 0 {
+				break
+			}
+		}
+	}
+	return dao.iterate(results)
+}
+
+func newTestUsergroupContext() (*Service, *RequestContext) {
+	// 1 的下级是 2 和 3, 2 的下级是 4, 5 是另一个顶级用户组
+	dao := &testUsergroupDao{groups: map[int64]usermodels.Usergroup{
+		1: {ID: 1, Name: "g1"},
+		2: {ID: 2, Name: "g2", ParentID: 1},
+		3: {ID: 3, Name: "g3", ParentID: 1},
+		4: {ID: 4, Name: "g4", ParentID: 2},
+		5: {ID: 5, Name: "g5"},
+	}}
+	return &Service{}, &RequestContext{
+		Ctx:        context.Background(),
+		Usergroups: dao,
+	}
+}
+
+func TestMoveUsergroupCycle(t *testing.T) {
+	svc, ctx := newTestUsergroupContext()
+
+	for _, test := range []struct {
+		id, parentID int64
+	}{
+		{id: 1, parentID: 1},
+		{id: 1, parentID: 2},
+		{id: 1, parentID: 4},
+		{id: 2, parentID: 4},
+	} {
+		if err := svc.MoveUsergroup(ctx, test.id, test.parentID); err != ErrUsergroupCycle {
+			t.Error(test.id, test.parentID, "want ErrUsergroupCycle, got", err)
+		}
+	}
+
+	if err := svc.MoveUsergroup(ctx, 2, 99); err == nil || err == ErrUsergroupCycle {
+		t.Error("parent isnot exists -", err)
+	}
+	if err := svc.MoveUsergroup(ctx, 99, 1); err != ErrUsergroupNotFound {
+		t.Error("want ErrUsergroupNotFound, got", err)
+	}
+	// 上级没有变化时什么也不做
+	if err := svc.MoveUsergroup(ctx, 4, 2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeUsergroupCycle(t *testing.T) {
+	svc, ctx := newTestUsergroupContext()
+
+	for _, test := range []struct {
+		source, target int64
+	}{
+		{source: 1, target: 1},
+		{source: 1, target: 2},
+		{source: 1, target: 4},
+	} {
+		if err := svc.MergeUsergroup(ctx, test.source, test.target); err != ErrUsergroupMergeCycle {
+			t.Error(test.source, test.target, "want ErrUsergroupMergeCycle, got", err)
+		}
+	}
+	if err := svc.MergeUsergroup(ctx, 1, 99); err != ErrUsergroupNotFound {
+		t.Error("want ErrUsergroupNotFound, got", err)
+	}
+}
+
+func TestSortUsergroupsValidate(t *testing.T) {
+	svc, ctx := newTestUsergroupContext()
+
+	for _, ids := range [][]int64{
+		{3},          // 缺少同级用户组 2
+		{3, 2, 2},    // 重复
+		{3, 2, 4},    // 4 不是 1 的直接下级
+		{3, 5, 2},    // 5 是顶级用户组
+		{3, 2, 1000}, // 不存在
+	} {
+		if err := svc.SortUsergroups(ctx, 1, ids); err == nil {
+			t.Error(ids, "is accepted")
+		}
+	}
+}
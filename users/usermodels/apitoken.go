@@ -0,0 +1,72 @@
+//go:generate gobatis apitoken.go
+
+package usermodels
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/runner-mei/validation"
+)
+
+// APIToken 个人访问令牌, 令牌本身不保存，只保存它的摘要
+type APIToken struct {
+	TableName       struct{}   `json:"-" xorm:"moo_api_tokens"`
+	ID              int64      `json:"id" xorm:"id pk autoincr"`
+	UserID          int64      `json:"user_id" xorm:"user_id notnull"`
+	Name            string     `json:"name" xorm:"name notnull"`
+	Prefix          string     `json:"prefix" xorm:"prefix notnull"`
+	TokenHash       string     `json:"-" xorm:"token_hash unique notnull"`
+	IPList          []string   `json:"ip_list,omitempty" xorm:"ip_list jsonb null"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty" xorm:"expires_at null"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty" xorm:"last_used_at null"`
+	LastUsedAddress string     `json:"last_used_address,omitempty" xorm:"last_used_address null"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty" xorm:"revoked_at null"`
+	CreatedAt       time.Time  `json:"created_at,omitempty" xorm:"created_at created"`
+	UpdatedAt       time.Time  `json:"updated_at,omitempty" xorm:"updated_at updated"`
+}
+
+func (token *APIToken) Validate(v *validation.Validation) bool {
+	v.Required("Name", token.Name)
+	v.MaxSize("Name", token.Name, 100)
+	return v.HasErrors()
+}
+
+func (token *APIToken) IsRevoked() bool {
+	return token.RevokedAt != nil && !token.RevokedAt.IsZero()
+}
+
+func (token *APIToken) IsExpired(now time.Time) bool {
+	return token.ExpiresAt != nil && !token.ExpiresAt.IsZero() && now.After(*token.ExpiresAt)
+}
+
+type APITokenDao interface {
+	Create(ctx context.Context, token *APIToken) (int64, error)
+
+	// @record_type APIToken
+	GetByID(ctx context.Context, id int64) func(*APIToken) error
+
+	// @default SELECT * FROM <tablename type="APIToken" /> WHERE token_hash = #{hash}
+	GetByHash(ctx context.Context, hash string) func(*APIToken) error
+
+	// @default SELECT * FROM <tablename type="APIToken" />
+	//   <where>
+	//   <if test="userID.Valid"> user_id = #{userID} </if>
+	//   <if test="!includeRevoked"> AND revoked_at IS NULL </if>
+	//   </where>
+	//   ORDER BY id
+	List(ctx context.Context, userID sql.NullInt64, includeRevoked bool) ([]APIToken, error)
+
+	// @type update
+	// @default UPDATE <tablename type="APIToken" /> SET last_used_at = now(), last_used_address = #{address} WHERE id = #{id}
+	Touch(ctx context.Context, id int64, address string) error
+
+	// @type update
+	// @default UPDATE <tablename type="APIToken" /> SET revoked_at = now() WHERE id = #{id} AND revoked_at IS NULL
+	Revoke(ctx context.Context, id int64) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="APIToken" /> SET revoked_at = now() WHERE user_id = #{userID} AND revoked_at IS NULL
+	RevokeByUserID(ctx context.Context, userID int64) (int64, error)
+}
@@ -0,0 +1,282 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// APITokenDao.Create
+			if _, exists := ctx.Statements["APITokenDao.Create"]; !exists {
+				sqlStr, err := gobatis.GenerateInsertSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&APIToken{}),
+					[]string{
+						"token",
+					},
+					[]reflect.Type{
+						reflect.TypeOf((*APIToken)(nil)),
+					}, false)
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate APITokenDao.Create error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.Create",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.Create"] = stmt
+			}
+		}
+		{ //// APITokenDao.GetByID
+			if _, exists := ctx.Statements["APITokenDao.GetByID"]; !exists {
+				sqlStr, err := gobatis.GenerateSelectSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&APIToken{}),
+					[]string{
+						"id",
+					},
+					[]reflect.Type{
+						reflect.TypeOf(new(int64)).Elem(),
+					},
+					[]gobatis.Filter{})
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate APITokenDao.GetByID error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.GetByID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.GetByID"] = stmt
+			}
+		}
+		{ //// APITokenDao.GetByHash
+			if _, exists := ctx.Statements["APITokenDao.GetByHash"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&APIToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE token_hash = #{hash}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.GetByHash",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.GetByHash"] = stmt
+			}
+		}
+		{ //// APITokenDao.List
+			if _, exists := ctx.Statements["APITokenDao.List"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&APIToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n   <where>\r\n   <if test=\"userID.Valid\"> user_id = #{userID} </if>\r\n   <if test=\"!includeRevoked\"> AND revoked_at IS NULL </if>\r\n   </where>\r\n   ORDER BY id")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.List",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.List"] = stmt
+			}
+		}
+		{ //// APITokenDao.Touch
+			if _, exists := ctx.Statements["APITokenDao.Touch"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&APIToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET last_used_at = now(), last_used_address = #{address} WHERE id = #{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.Touch",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.Touch"] = stmt
+			}
+		}
+		{ //// APITokenDao.Revoke
+			if _, exists := ctx.Statements["APITokenDao.Revoke"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&APIToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET revoked_at = now() WHERE id = #{id} AND revoked_at IS NULL")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.Revoke",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.Revoke"] = stmt
+			}
+		}
+		{ //// APITokenDao.RevokeByUserID
+			if _, exists := ctx.Statements["APITokenDao.RevokeByUserID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&APIToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET revoked_at = now() WHERE user_id = #{userID} AND revoked_at IS NULL")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "APITokenDao.RevokeByUserID",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["APITokenDao.RevokeByUserID"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewAPITokenDao(ref gobatis.SqlSession) APITokenDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &APITokenDaoImpl{session: ref}
+}
+
+type APITokenDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *APITokenDaoImpl) Create(ctx context.Context, token *APIToken) (int64, error) {
+	return impl.session.Insert(ctx, "APITokenDao.Create",
+		[]string{
+			"token",
+		},
+		[]interface{}{
+			token,
+		})
+}
+
+func (impl *APITokenDaoImpl) GetByID(ctx context.Context, id int64) func(*APIToken) error {
+	result := impl.session.SelectOne(ctx, "APITokenDao.GetByID",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+	return func(value *APIToken) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *APITokenDaoImpl) GetByHash(ctx context.Context, hash string) func(*APIToken) error {
+	result := impl.session.SelectOne(ctx, "APITokenDao.GetByHash",
+		[]string{
+			"hash",
+		},
+		[]interface{}{
+			hash,
+		})
+	return func(value *APIToken) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *APITokenDaoImpl) List(ctx context.Context, userID sql.NullInt64, includeRevoked bool) ([]APIToken, error) {
+	var instances []APIToken
+	results := impl.session.Select(ctx, "APITokenDao.List",
+		[]string{
+			"userID",
+			"includeRevoked",
+		},
+		[]interface{}{
+			userID,
+			includeRevoked,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *APITokenDaoImpl) Touch(ctx context.Context, id int64, address string) error {
+	_, err := impl.session.Update(ctx, "APITokenDao.Touch",
+		[]string{
+			"id",
+			"address",
+		},
+		[]interface{}{
+			id,
+			address,
+		})
+	return err
+}
+
+func (impl *APITokenDaoImpl) Revoke(ctx context.Context, id int64) (int64, error) {
+	return impl.session.Update(ctx, "APITokenDao.Revoke",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+}
+
+func (impl *APITokenDaoImpl) RevokeByUserID(ctx context.Context, userID int64) (int64, error) {
+	return impl.session.Update(ctx, "APITokenDao.RevokeByUserID",
+		[]string{
+			"userID",
+		},
+		[]interface{}{
+			userID,
+		})
+}
@@ -0,0 +1,54 @@
+//go:generate gobatis login_failure.go
+
+package usermodels
+
+import (
+	"context"
+	"time"
+)
+
+// LoginFailure 一次登录失败的记录，kind 为 username 时 target 是用户名， 为 address 时 target 是来源地址
+type LoginFailure struct {
+	TableName struct{}  `json:"-" xorm:"moo_login_failures"`
+	ID        int64     `json:"id" xorm:"id pk autoincr"`
+	Kind      string    `json:"kind" xorm:"kind notnull"`
+	Target    string    `json:"target" xorm:"target notnull"`
+	Address   string    `json:"address,omitempty" xorm:"address null"`
+	FailedAt  time.Time `json:"failed_at" xorm:"failed_at created"`
+}
+
+// LoginFailureStat 按 kind 和 target 汇总的登录失败记录
+type LoginFailureStat struct {
+	Kind          string    `json:"kind" xorm:"kind"`
+	Target        string    `json:"target" xorm:"target"`
+	Count         int       `json:"count" xorm:"count"`
+	FirstFailedAt time.Time `json:"first_failed_at" xorm:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at" xorm:"last_failed_at"`
+}
+
+type LoginFailureDao interface {
+	// @type insert
+	// @default INSERT INTO <tablename type="LoginFailure" />(kind, target, address, failed_at)
+	//          VALUES(#{kind}, lower(#{target}), #{address}, now())
+	Insert(ctx context.Context, kind, target, address string) error
+
+	// @default SELECT kind, target, count(*) AS count, min(failed_at) AS first_failed_at, max(failed_at) AS last_failed_at
+	//          FROM <tablename type="LoginFailure" />
+	//          WHERE kind = #{kind} AND target = lower(#{target}) AND failed_at > (now() - #{interval}::INTERVAL)
+	//          GROUP BY kind, target
+	Count(ctx context.Context, kind, target, interval string) ([]LoginFailureStat, error)
+
+	// @default SELECT kind, target, count(*) AS count, min(failed_at) AS first_failed_at, max(failed_at) AS last_failed_at
+	//          FROM <tablename type="LoginFailure" />
+	//          WHERE failed_at > (now() - #{interval}::INTERVAL)
+	//          GROUP BY kind, target
+	List(ctx context.Context, interval string) ([]LoginFailureStat, error)
+
+	// @type delete
+	// @default DELETE FROM <tablename type="LoginFailure" /> WHERE kind = #{kind} AND target = lower(#{target})
+	Zero(ctx context.Context, kind, target string) (int64, error)
+
+	// @type delete
+	// @default DELETE FROM <tablename type="LoginFailure" /> WHERE now() > (failed_at + #{interval}::INTERVAL)
+	DeleteExpired(ctx context.Context, interval string) (int64, error)
+}
@@ -0,0 +1,221 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// LoginFailureDao.Insert
+			if _, exists := ctx.Statements["LoginFailureDao.Insert"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&LoginFailure{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(kind, target, address, failed_at)\r\n          VALUES(#{kind}, lower(#{target}), #{address}, now())")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "LoginFailureDao.Insert",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["LoginFailureDao.Insert"] = stmt
+			}
+		}
+		{ //// LoginFailureDao.Count
+			if _, exists := ctx.Statements["LoginFailureDao.Count"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT kind, target, count(*) AS count, min(failed_at) AS first_failed_at, max(failed_at) AS last_failed_at\r\n          FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&LoginFailure{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n          WHERE kind = #{kind} AND target = lower(#{target}) AND failed_at > (now() - #{interval}::INTERVAL)\r\n          GROUP BY kind, target")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "LoginFailureDao.Count",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["LoginFailureDao.Count"] = stmt
+			}
+		}
+		{ //// LoginFailureDao.List
+			if _, exists := ctx.Statements["LoginFailureDao.List"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT kind, target, count(*) AS count, min(failed_at) AS first_failed_at, max(failed_at) AS last_failed_at\r\n          FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&LoginFailure{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n          WHERE failed_at > (now() - #{interval}::INTERVAL)\r\n          GROUP BY kind, target")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "LoginFailureDao.List",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["LoginFailureDao.List"] = stmt
+			}
+		}
+		{ //// LoginFailureDao.Zero
+			if _, exists := ctx.Statements["LoginFailureDao.Zero"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&LoginFailure{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE kind = #{kind} AND target = lower(#{target})")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "LoginFailureDao.Zero",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["LoginFailureDao.Zero"] = stmt
+			}
+		}
+		{ //// LoginFailureDao.DeleteExpired
+			if _, exists := ctx.Statements["LoginFailureDao.DeleteExpired"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&LoginFailure{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE now() > (failed_at + #{interval}::INTERVAL)")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "LoginFailureDao.DeleteExpired",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["LoginFailureDao.DeleteExpired"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewLoginFailureDao(ref gobatis.SqlSession) LoginFailureDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &LoginFailureDaoImpl{session: ref}
+}
+
+type LoginFailureDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *LoginFailureDaoImpl) Insert(ctx context.Context, kind string, target string, address string) error {
+	_, err := impl.session.Insert(ctx, "LoginFailureDao.Insert",
+		[]string{
+			"kind",
+			"target",
+			"address",
+		},
+		[]interface{}{
+			kind,
+			target,
+			address,
+		},
+		true)
+	return err
+}
+
+func (impl *LoginFailureDaoImpl) Count(ctx context.Context, kind string, target string, interval string) ([]LoginFailureStat, error) {
+	var instances []LoginFailureStat
+	results := impl.session.Select(ctx, "LoginFailureDao.Count",
+		[]string{
+			"kind",
+			"target",
+			"interval",
+		},
+		[]interface{}{
+			kind,
+			target,
+			interval,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *LoginFailureDaoImpl) List(ctx context.Context, interval string) ([]LoginFailureStat, error) {
+	var instances []LoginFailureStat
+	results := impl.session.Select(ctx, "LoginFailureDao.List",
+		[]string{
+			"interval",
+		},
+		[]interface{}{
+			interval,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *LoginFailureDaoImpl) Zero(ctx context.Context, kind string, target string) (int64, error) {
+	return impl.session.Delete(ctx, "LoginFailureDao.Zero",
+		[]string{
+			"kind",
+			"target",
+		},
+		[]interface{}{
+			kind,
+			target,
+		})
+}
+
+func (impl *LoginFailureDaoImpl) DeleteExpired(ctx context.Context, interval string) (int64, error) {
+	return impl.session.Delete(ctx, "LoginFailureDao.DeleteExpired",
+		[]string{
+			"interval",
+		},
+		[]interface{}{
+			interval,
+		})
+}
@@ -0,0 +1,35 @@
+//go:generate gobatis password_history.go
+
+package usermodels
+
+import (
+	"context"
+	"time"
+)
+
+// PasswordHistory 用户用过的密码（已加密）
+type PasswordHistory struct {
+	TableName struct{}  `json:"-" xorm:"moo_password_histories"`
+	ID        int64     `json:"id" xorm:"id pk autoincr"`
+	UserID    int64     `json:"user_id" xorm:"user_id notnull"`
+	Password  string    `json:"-" xorm:"password notnull"`
+	CreatedAt time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+type PasswordHistoryDao interface {
+	// @type insert
+	// @default INSERT INTO <tablename type="PasswordHistory" />(user_id, password, created_at)
+	//          SELECT id, password, now() FROM <tablename type="User" />
+	//          WHERE id = #{userID} AND password IS NOT NULL AND password != ''
+	AddCurrent(ctx context.Context, userID int64) error
+
+	// @default SELECT * FROM <tablename type="PasswordHistory" /> WHERE user_id = #{userID}
+	//          ORDER BY id DESC LIMIT #{limit}
+	List(ctx context.Context, userID int64, limit int) ([]PasswordHistory, error)
+
+	// @type delete
+	// @default DELETE FROM <tablename type="PasswordHistory" /> WHERE user_id = #{userID} AND id NOT IN (
+	//          SELECT id FROM <tablename type="PasswordHistory" /> WHERE user_id = #{userID}
+	//          ORDER BY id DESC LIMIT #{keep})
+	DeleteOld(ctx context.Context, userID int64, keep int) (int64, error)
+}
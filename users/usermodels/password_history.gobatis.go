@@ -0,0 +1,157 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// PasswordHistoryDao.AddCurrent
+			if _, exists := ctx.Statements["PasswordHistoryDao.AddCurrent"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PasswordHistory{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(user_id, password, created_at)\r\n          SELECT id, password, now() FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n          WHERE id = #{userID} AND password IS NOT NULL AND password != ''")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PasswordHistoryDao.AddCurrent",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PasswordHistoryDao.AddCurrent"] = stmt
+			}
+		}
+		{ //// PasswordHistoryDao.List
+			if _, exists := ctx.Statements["PasswordHistoryDao.List"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PasswordHistory{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE user_id = #{userID}\r\n          ORDER BY id DESC LIMIT #{limit}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PasswordHistoryDao.List",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PasswordHistoryDao.List"] = stmt
+			}
+		}
+		{ //// PasswordHistoryDao.DeleteOld
+			if _, exists := ctx.Statements["PasswordHistoryDao.DeleteOld"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PasswordHistory{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE user_id = #{userID} AND id NOT IN (\r\n          SELECT id FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PasswordHistory{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE user_id = #{userID}\r\n          ORDER BY id DESC LIMIT #{keep})")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PasswordHistoryDao.DeleteOld",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PasswordHistoryDao.DeleteOld"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewPasswordHistoryDao(ref gobatis.SqlSession) PasswordHistoryDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &PasswordHistoryDaoImpl{session: ref}
+}
+
+type PasswordHistoryDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *PasswordHistoryDaoImpl) AddCurrent(ctx context.Context, userID int64) error {
+	_, err := impl.session.Insert(ctx, "PasswordHistoryDao.AddCurrent",
+		[]string{
+			"userID",
+		},
+		[]interface{}{
+			userID,
+		},
+		true)
+	return err
+}
+
+func (impl *PasswordHistoryDaoImpl) List(ctx context.Context, userID int64, limit int) ([]PasswordHistory, error) {
+	var instances []PasswordHistory
+	results := impl.session.Select(ctx, "PasswordHistoryDao.List",
+		[]string{
+			"userID",
+			"limit",
+		},
+		[]interface{}{
+			userID,
+			limit,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *PasswordHistoryDaoImpl) DeleteOld(ctx context.Context, userID int64, keep int) (int64, error) {
+	return impl.session.Delete(ctx, "PasswordHistoryDao.DeleteOld",
+		[]string{
+			"userID",
+			"keep",
+		},
+		[]interface{}{
+			userID,
+			keep,
+		})
+}
@@ -0,0 +1,65 @@
+//go:generate gobatis permission_grant.go
+
+package usermodels
+
+import (
+	"context"
+	"time"
+)
+
+// PermissionGrant 一个资源(权限)的授权, 允许和禁止的角色记在 PermissionGrantRole 中,
+// Conditions 是 JSON 格式的属性条件(见 authz.Conditions)
+type PermissionGrant struct {
+	TableName   struct{}  `json:"-" xorm:"moo_permission_grants"`
+	ID          int64     `json:"id" xorm:"id pk autoincr"`
+	Resource    string    `json:"resource" xorm:"resource unique notnull"`
+	AllowAnyone bool      `json:"allow_anyone" xorm:"allow_anyone notnull"`
+	Conditions  string    `json:"conditions,omitempty" xorm:"conditions null"`
+	Description string    `json:"description,omitempty" xorm:"description null"`
+	CreatedAt   time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
+}
+
+// PermissionGrantRole 授权中的角色, Forbidden 为 true 时表示禁止该角色访问
+type PermissionGrantRole struct {
+	TableName struct{} `json:"-" xorm:"moo_permission_grant_roles"`
+	GrantID   int64    `json:"grant_id" xorm:"grant_id unique(grant_role) notnull"`
+	RoleID    int64    `json:"role_id" xorm:"role_id unique(grant_role) notnull"`
+	Forbidden bool     `json:"forbidden" xorm:"forbidden notnull"`
+}
+
+type PermissionGrantDao interface {
+	Create(ctx context.Context, grant *PermissionGrant) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="PermissionGrant" /> SET allow_anyone = #{grant.AllowAnyone},
+	//       conditions = #{grant.Conditions}, description = #{grant.Description}, updated_at = now() WHERE id = #{id}
+	Update(ctx context.Context, id int64, grant *PermissionGrant) (int64, error)
+
+	// @record_type PermissionGrant
+	GetByID(ctx context.Context, id int64) func(*PermissionGrant) error
+
+	// @default SELECT * FROM <tablename type="PermissionGrant" /> WHERE resource = #{resource}
+	GetByResource(ctx context.Context, resource string) func(*PermissionGrant) error
+
+	// @default SELECT * FROM <tablename type="PermissionGrant" /> ORDER BY resource
+	List(ctx context.Context) ([]PermissionGrant, error)
+
+	// @record_type PermissionGrant
+	DeleteByID(ctx context.Context, id int64) (int64, error)
+
+	// @default SELECT * FROM <tablename type="PermissionGrantRole" />
+	ListRoles(ctx context.Context) ([]PermissionGrantRole, error)
+
+	// @default SELECT * FROM <tablename type="PermissionGrantRole" /> WHERE grant_id = #{grantID}
+	ListRolesByGrantID(ctx context.Context, grantID int64) ([]PermissionGrantRole, error)
+
+	// @type insert
+	// @default INSERT INTO <tablename type="PermissionGrantRole" />(grant_id, role_id, forbidden)
+	//       VALUES(#{grantID}, #{roleID}, #{forbidden})
+	AddRole(ctx context.Context, grantID, roleID int64, forbidden bool) error
+
+	// @type delete
+	// @default DELETE FROM <tablename type="PermissionGrantRole" /> WHERE grant_id = #{grantID}
+	DeleteRolesByGrantID(ctx context.Context, grantID int64) (int64, error)
+}
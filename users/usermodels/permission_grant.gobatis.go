@@ -0,0 +1,385 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// PermissionGrantDao.Create
+			if _, exists := ctx.Statements["PermissionGrantDao.Create"]; !exists {
+				sqlStr, err := gobatis.GenerateInsertSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&PermissionGrant{}),
+					[]string{
+						"grant",
+					},
+					[]reflect.Type{
+						reflect.TypeOf((*PermissionGrant)(nil)),
+					}, false)
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate PermissionGrantDao.Create error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.Create",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.Create"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.Update
+			if _, exists := ctx.Statements["PermissionGrantDao.Update"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrant{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET allow_anyone = #{grant.AllowAnyone},\r\n       conditions = #{grant.Conditions}, description = #{grant.Description}, updated_at = now() WHERE id = #{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.Update",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.Update"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.GetByID
+			if _, exists := ctx.Statements["PermissionGrantDao.GetByID"]; !exists {
+				sqlStr, err := gobatis.GenerateSelectSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&PermissionGrant{}),
+					[]string{
+						"id",
+					},
+					[]reflect.Type{
+						reflect.TypeOf(new(int64)).Elem(),
+					},
+					[]gobatis.Filter{})
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate PermissionGrantDao.GetByID error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.GetByID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.GetByID"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.GetByResource
+			if _, exists := ctx.Statements["PermissionGrantDao.GetByResource"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrant{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE resource = #{resource}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.GetByResource",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.GetByResource"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.List
+			if _, exists := ctx.Statements["PermissionGrantDao.List"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrant{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" ORDER BY resource")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.List",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.List"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.DeleteByID
+			if _, exists := ctx.Statements["PermissionGrantDao.DeleteByID"]; !exists {
+				sqlStr, err := gobatis.GenerateDeleteSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&PermissionGrant{}),
+					[]string{
+						"id",
+					},
+					[]reflect.Type{
+						reflect.TypeOf(new(int64)).Elem(),
+					},
+					[]gobatis.Filter{})
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate PermissionGrantDao.DeleteByID error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.DeleteByID",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.DeleteByID"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.ListRoles
+			if _, exists := ctx.Statements["PermissionGrantDao.ListRoles"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrantRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.ListRoles",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.ListRoles"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.ListRolesByGrantID
+			if _, exists := ctx.Statements["PermissionGrantDao.ListRolesByGrantID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrantRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE grant_id = #{grantID}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.ListRolesByGrantID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.ListRolesByGrantID"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.AddRole
+			if _, exists := ctx.Statements["PermissionGrantDao.AddRole"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrantRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(grant_id, role_id, forbidden)\r\n       VALUES(#{grantID}, #{roleID}, #{forbidden})")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.AddRole",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.AddRole"] = stmt
+			}
+		}
+		{ //// PermissionGrantDao.DeleteRolesByGrantID
+			if _, exists := ctx.Statements["PermissionGrantDao.DeleteRolesByGrantID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&PermissionGrantRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE grant_id = #{grantID}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "PermissionGrantDao.DeleteRolesByGrantID",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["PermissionGrantDao.DeleteRolesByGrantID"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewPermissionGrantDao(ref gobatis.SqlSession) PermissionGrantDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &PermissionGrantDaoImpl{session: ref}
+}
+
+type PermissionGrantDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *PermissionGrantDaoImpl) Create(ctx context.Context, grant *PermissionGrant) (int64, error) {
+	return impl.session.Insert(ctx, "PermissionGrantDao.Create",
+		[]string{
+			"grant",
+		},
+		[]interface{}{
+			grant,
+		})
+}
+
+func (impl *PermissionGrantDaoImpl) Update(ctx context.Context, id int64, grant *PermissionGrant) (int64, error) {
+	return impl.session.Update(ctx, "PermissionGrantDao.Update",
+		[]string{
+			"id",
+			"grant",
+		},
+		[]interface{}{
+			id,
+			grant,
+		})
+}
+
+func (impl *PermissionGrantDaoImpl) GetByID(ctx context.Context, id int64) func(*PermissionGrant) error {
+	result := impl.session.SelectOne(ctx, "PermissionGrantDao.GetByID",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+	return func(value *PermissionGrant) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *PermissionGrantDaoImpl) GetByResource(ctx context.Context, resource string) func(*PermissionGrant) error {
+	result := impl.session.SelectOne(ctx, "PermissionGrantDao.GetByResource",
+		[]string{
+			"resource",
+		},
+		[]interface{}{
+			resource,
+		})
+	return func(value *PermissionGrant) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *PermissionGrantDaoImpl) List(ctx context.Context) ([]PermissionGrant, error) {
+	var instances []PermissionGrant
+	results := impl.session.Select(ctx, "PermissionGrantDao.List",
+		[]string{},
+		[]interface{}{})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *PermissionGrantDaoImpl) DeleteByID(ctx context.Context, id int64) (int64, error) {
+	return impl.session.Delete(ctx, "PermissionGrantDao.DeleteByID",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+}
+
+func (impl *PermissionGrantDaoImpl) ListRoles(ctx context.Context) ([]PermissionGrantRole, error) {
+	var instances []PermissionGrantRole
+	results := impl.session.Select(ctx, "PermissionGrantDao.ListRoles",
+		[]string{},
+		[]interface{}{})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *PermissionGrantDaoImpl) ListRolesByGrantID(ctx context.Context, grantID int64) ([]PermissionGrantRole, error) {
+	var instances []PermissionGrantRole
+	results := impl.session.Select(ctx, "PermissionGrantDao.ListRolesByGrantID",
+		[]string{
+			"grantID",
+		},
+		[]interface{}{
+			grantID,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *PermissionGrantDaoImpl) AddRole(ctx context.Context, grantID int64, roleID int64, forbidden bool) error {
+	_, err := impl.session.Insert(ctx, "PermissionGrantDao.AddRole",
+		[]string{
+			"grantID",
+			"roleID",
+			"forbidden",
+		},
+		[]interface{}{
+			grantID,
+			roleID,
+			forbidden,
+		},
+		true)
+	return err
+}
+
+func (impl *PermissionGrantDaoImpl) DeleteRolesByGrantID(ctx context.Context, grantID int64) (int64, error) {
+	return impl.session.Delete(ctx, "PermissionGrantDao.DeleteRolesByGrantID",
+		[]string{
+			"grantID",
+		},
+		[]interface{}{
+			grantID,
+		})
+}
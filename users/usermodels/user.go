@@ -19,12 +19,14 @@ import (
 )
 
 type OnlineUser struct {
-	TableName struct{}  `json:"-" xorm:"moo_online_users"`
-	UserID    int64     `json:"user_id" xorm:"user_id pk(user_address)"`
-	Address   string    `json:"address" xorm:"address pk(user_address)"`
-	Uuid      string    `json:"uuid,omitempty" xorm:"uuid unique"`
-	CreatedAt time.Time `json:"created_at,omitempty" xorm:"created_at created"`
-	UpdatedAt time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
+	TableName      struct{}  `json:"-" xorm:"moo_online_users"`
+	UserID         int64     `json:"user_id" xorm:"user_id unique(user_address)"`
+	Address        string    `json:"address" xorm:"address unique(user_address)"`
+	Uuid           string    `json:"uuid,omitempty" xorm:"uuid unique"`
+	ImpersonatorID int64     `json:"impersonator_id,omitempty" xorm:"impersonator_id null"`
+	CreatedAt      time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+	UpdatedAt      time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
+	Username       string    `json:"username,omitempty" xorm:"username <- null"`
 }
 
 // @gobatis.ignore
@@ -39,7 +41,7 @@ type OnlineUserDao interface {
 
 	// @type insert
 	// @default INSERT INTO <tablename type="OnlineUser" />(user_id, address, uuid, created_at, updated_at)
-	//          VALUES(#{userID}, #{address}, #{uuid}, now(), now())  ON CONFLICT (user_id, address)
+	//          VALUES(#{userID}, #{address}, #{uuid}, now(), now())  ON CONFLICT (user_id, address) WHERE impersonator_id IS NULL
 	//          DO UPDATE SET updated = now()
 	CreateOrTouch(ctx context.Context, userID int64, address, uuid string) (int64, error)
 
@@ -49,6 +51,47 @@ type OnlineUserDao interface {
 
 	// @default DELETE FROM <tablename type="OnlineUser" /> WHERE user_id = #{userID} AND address = #{address}
 	Delete(ctx context.Context, userID int64, address string) (int64, error)
+
+	// @default SELECT o.*, u.name AS username FROM <tablename type="OnlineUser" as="o" />
+	//          INNER JOIN <tablename type="User" as="u" /> ON o.user_id = u.id
+	//          WHERE o.uuid = #{uuid} <if test="isNotEmpty(interval)"> AND (o.updated_at + #{interval}::INTERVAL) &gt; now() </if>
+	GetByUUID(ctx context.Context, uuid, interval string) func(*OnlineUser) error
+
+	// @default SELECT o.*, u.name AS username FROM <tablename type="OnlineUser" as="o" />
+	//          INNER JOIN <tablename type="User" as="u" /> ON o.user_id = u.id
+	//          <where>
+	//          <if test="userID &gt; 0"> o.user_id = #{userID} </if>
+	//          <if test="isNotEmpty(username)"> AND u.name = #{username} </if>
+	//          <if test="isNotEmpty(address)"> AND o.address = #{address}::INET </if>
+	//          <if test="isNotEmpty(interval)"> AND (o.updated_at + #{interval}::INTERVAL) &gt; now() </if>
+	//          </where>
+	//          ORDER BY o.updated_at DESC
+	//          <pagination />
+	Query(ctx context.Context, userID int64, username, address, interval string, offset, limit int64) ([]OnlineUser, error)
+
+	// @type insert
+	// @default INSERT INTO <tablename type="OnlineUser" />(user_id, address, uuid, created_at, updated_at)
+	//          VALUES(#{userID}, #{address}, #{uuid}, now(), now())  ON CONFLICT (user_id, address) WHERE impersonator_id IS NULL
+	//          DO UPDATE SET uuid = EXCLUDED.uuid, created_at = now(), updated_at = now()
+	Upsert(ctx context.Context, userID int64, address, uuid string) (int64, error)
+
+	// @type insert
+	// @default INSERT INTO <tablename type="OnlineUser" />(user_id, address, uuid, impersonator_id, created_at, updated_at)
+	//          VALUES(#{userID}, #{address}, #{uuid}, #{impersonatorID}, now(), now())
+	CreateImpersonation(ctx context.Context, userID int64, address, uuid string, impersonatorID int64) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="OnlineUser" /> SET updated_at = now() WHERE uuid = #{uuid}
+	TouchByUUID(ctx context.Context, uuid string) (int64, error)
+
+	// @default DELETE FROM <tablename type="OnlineUser" /> WHERE uuid = #{uuid}
+	DeleteByUUID(ctx context.Context, uuid string) (int64, error)
+
+	// @default DELETE FROM <tablename type="OnlineUser" /> WHERE user_id = #{userID} AND uuid != #{exceptUUID}
+	DeleteByUserID(ctx context.Context, userID int64, exceptUUID string) (int64, error)
+
+	// @default DELETE FROM <tablename type="OnlineUser" /> WHERE now() > (updated_at + #{interval}::INTERVAL)
+	DeleteExpired(ctx context.Context, interval string) (int64, error)
 }
 
 type User struct {
@@ -69,6 +112,15 @@ type User struct {
 	UpdatedAt   time.Time              `json:"updated_at,omitempty" xorm:"updated_at updated"`
 	Extensions  map[string]interface{} `json:"extensions,omitempty" xorm:"-"`
 
+	// 下面两个字段只能通过 UpdateUserPassword 和 SetMustChangePassword 修改
+	PasswordChangedAt  *time.Time `json:"password_changed_at,omitempty" xorm:"password_changed_at <- null"`
+	MustChangePassword bool       `json:"must_change_password,omitempty" xorm:"must_change_password <- null"`
+
+	// ExpiresAt 帐号的过期时间, 为空时永不过期, 只能通过 SetUserExpiresAt 修改
+	ExpiresAt *time.Time `json:"expires_at,omitempty" xorm:"expires_at <- null"`
+	// LastLoginAt 最后一次登录的时间, 只能通过 TouchLastLogin 修改
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" xorm:"last_login_at <- null"`
+
 	// Type        int                    `json:"type,omitempty" xorm:"type"`
 	Reserved1 map[string]string                                      `json:"profiles" xorm:"profiles <- null"`
 	Mapping   func(ctx context.Context, id int64, key string) string `json:"-" xorm:"-"`
@@ -301,6 +353,7 @@ type Role struct {
 	Type        int64     `json:"type" xorm:"type null"`
 	Name        string    `json:"name" xorm:"name unique notnull"`
 	Description string    `json:"description,omitempty" xorm:"description null"`
+	ParentID    int64     `json:"parent_id,omitempty" xorm:"parent_id null"`
 	IsDefault   bool      `json:"is_default,omitempty" xorm:"is_default null"`
 	CreatedAt   time.Time `json:"created_at,omitempty" xorm:"created_at created"`
 	UpdatedAt   time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
@@ -321,6 +374,13 @@ func (role *Role) IsBuiltin() bool {
 		role.Name == api.RoleGuest
 }
 
+// UsergroupAndRole 用户组的默认角色, 用户组的成员自动拥有这些角色
+type UsergroupAndRole struct {
+	TableName struct{} `json:"-" xorm:"moo_usergroups_and_roles"`
+	GroupID   int64    `json:"group_id" xorm:"group_id unique(group_role) notnull"`
+	RoleID    int64    `json:"role_id" xorm:"role_id unique(group_role) notnull"`
+}
+
 type UserAndRole struct {
 	TableName struct{} `json:"-" xorm:"moo_users_and_roles"`
 	Reserve1  int64    `json:"id" xorm:"id <-"`
@@ -341,6 +401,16 @@ type UserQueryParams struct {
 	UsergroupRecursive bool
 	UsergroupIDs       []int64
 	JobPositions       []int64
+
+	// Attributes 按用户的属性查询, 多个条件之间为 AND
+	Attributes []AttributeQuery
+}
+
+// AttributeQuery 按用户的一个属性查询, Like 为 true 时模糊匹配, 否则要相等
+type AttributeQuery struct {
+	Key   string
+	Value string
+	Like  bool
 }
 
 type UserQueryer interface {
@@ -384,6 +454,13 @@ type UserQueryer interface {
 	// @default SELECT * FROM <tablename type="User" /> WHERE lower(nickname) = lower(#{nickname})
 	GetUserByNickname(ctx context.Context, nickname string) func(*User) error
 
+	// @default SELECT * FROM <tablename type="User" /> WHERE lower(attributes->>'email') = lower(#{email})
+	GetUsersByEmail(ctx context.Context, email string) ([]User, error)
+
+	// @default SELECT id FROM <tablename type="User" /> WHERE lower(attributes->>#{key}) = lower(#{value})
+	//          AND id != #{excludeID} AND name NOT LIKE '%(deleted:%'
+	GetUserIDsByAttribute(ctx context.Context, key, value string, excludeID int64) ([]int64, error)
+
 	// @default SELECT * FROM <tablename type="User" /> WHERE lower(name) = lower(#{name}) OR lower(nickname) = lower(#{nickname})
 	GetUserByNameOrNickname(ctx context.Context, name, nickname string) func(*User) error
 
@@ -397,6 +474,10 @@ type UserQueryer interface {
 	//      WHERE r.name not in (<foreach collection="params.ExcludeRolenames" separator=",">#{item}</foreach>) AND u2r.user_id = users.id) AND
 	//  </if>
 	//  <if test="isNotEmpty(params.NameLike)"> (users.name like <like value="params.NameLike" /> OR users.nickname like <like value="params.NameLike" />) AND</if>
+	//  <foreach collection="params.Attributes" separator=" ">
+	//    <if test="item.Like"> users.attributes-&gt;&gt;#{item.Key} like <like value="item.Value" /> AND</if>
+	//    <if test="!item.Like"> users.attributes-&gt;&gt;#{item.Key} = #{item.Value} AND</if>
+	//  </foreach>
 	//  <if test="params.CanLogin.Valid"> users.can_login = #{params.CanLogin} AND </if>
 	//  <if test="params.Enabled.Valid"> (<if test="!params.Enabled.Bool"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>
 	//  <if test="len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0">
@@ -435,6 +516,10 @@ type UserQueryer interface {
 	//      WHERE r.name not in (<foreach collection="params.ExcludeRolenames" separator=",">#{item}</foreach>) AND u2r.user_id = users.id) AND
 	//  </if>
 	//  <if test="isNotEmpty(params.NameLike)"> (users.name like <like value="params.NameLike" /> OR users.nickname like <like value="params.NameLike" />) AND</if>
+	//  <foreach collection="params.Attributes" separator=" ">
+	//    <if test="item.Like"> users.attributes-&gt;&gt;#{item.Key} like <like value="item.Value" /> AND</if>
+	//    <if test="!item.Like"> users.attributes-&gt;&gt;#{item.Key} = #{item.Value} AND</if>
+	//  </foreach>
 	//  <if test="params.CanLogin.Valid"> users.can_login = #{params.CanLogin} AND </if>
 	//  <if test="params.Enabled.Valid"> (<if test="!params.Enabled.Bool"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>
 	//  <if test="len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0">
@@ -475,6 +560,10 @@ type UserQueryer interface {
 	//      WHERE r.name not in (<foreach collection="params.ExcludeRolenames" separator=",">#{item}</foreach>) AND u2r.user_id = users.id) AND
 	//  </if>
 	//  <if test="isNotEmpty(params.NameLike)"> (users.name like <like value="params.NameLike" /> OR users.nickname like <like value="params.NameLike" />) AND</if>
+	//  <foreach collection="params.Attributes" separator=" ">
+	//    <if test="item.Like"> users.attributes-&gt;&gt;#{item.Key} like <like value="item.Value" /> AND</if>
+	//    <if test="!item.Like"> users.attributes-&gt;&gt;#{item.Key} = #{item.Value} AND</if>
+	//  </foreach>
 	//  <if test="params.CanLogin.Valid"> users.can_login = #{params.CanLogin} AND </if>
 	//  <if test="params.Enabled.Valid"> (<if test="!params.Enabled.Bool"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>
 	//  <if test="len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0">
@@ -510,6 +599,36 @@ type UserQueryer interface {
 	//     where u2g.role_id = roles.id and u2g.user_id = #{userID})
 	GetRolesByUserID(ctx context.Context, userID int64) ([]Role, error)
 
+	// @default SELECT * FROM <tablename type="Role" /> WHERE id in (
+	//   WITH RECURSIVE ALLROLES (ID, PARENT_ID) AS (
+	//     SELECT roles.id, roles.parent_id FROM <tablename type="Role" as="roles" /> WHERE
+	//        exists (select * from <tablename type="UserAndRole" /> as users_roles
+	//           where users_roles.role_id = roles.id and users_roles.user_id = #{userID})
+	//        <if test="groupScoped">OR exists (select * from <tablename type="UserAndUsergroup" /> as u2g
+	//           where u2g.role_id = roles.id and u2g.user_id = #{userID})</if>
+	//        OR exists (select * from <tablename type="UsergroupAndRole" /> as g2r
+	//           JOIN <tablename type="UserAndUsergroup" /> as u2g ON g2r.group_id = u2g.group_id
+	//           JOIN <tablename type="Usergroup" /> as g ON g.id = u2g.group_id
+	//           where g2r.role_id = roles.id and u2g.user_id = #{userID} and (g.disabled IS NULL OR g.disabled = false))
+	//     UNION
+	//     SELECT P.ID, P.PARENT_ID FROM <tablename type="Role" as="P" /> JOIN ALLROLES ON P.ID = ALLROLES.PARENT_ID)
+	//   SELECT ID FROM ALLROLES)
+	GetEffectiveRolesByUserID(ctx context.Context, userID int64, groupScoped bool) ([]Role, error)
+
+	// @default SELECT * FROM <tablename type="Role" /> WHERE id in (
+	//   WITH RECURSIVE ALLROLES (ID, PARENT_ID) AS (
+	//     SELECT id, parent_id FROM <tablename type="Role" /> WHERE id = #{id}
+	//     UNION
+	//     SELECT P.ID, P.PARENT_ID FROM <tablename type="Role" as="P" /> JOIN ALLROLES ON P.ID = ALLROLES.PARENT_ID)
+	//   SELECT ID FROM ALLROLES)
+	GetRoleAncestors(ctx context.Context, id int64) ([]Role, error)
+
+	// @default SELECT * FROM <tablename type="Role" /> WHERE parent_id = #{parentID} ORDER BY name
+	GetChildRoles(ctx context.Context, parentID int64) ([]Role, error)
+
+	// @default SELECT count(*) FROM <tablename type="UserAndRole" /> WHERE role_id = #{roleID}
+	GetUserCountByRoleID(ctx context.Context, roleID int64) (int64, error)
+
 	// @default SELECT value FROM <tablename type="UserProfile" /> WHERE id = #{userID} AND name = #{name}
 	ReadProfile(ctx context.Context, userID int64, name string) (string, error)
 
@@ -573,13 +692,36 @@ type UserDao interface {
 	//       SET locked_at = NULL WHERE lower(name) = lower(#{username})
 	UnlockUserByUsername(ctx context.Context, username string) error
 
+	// @default SELECT * FROM <tablename type="User"/>
+	//       WHERE locked_at IS NOT NULL AND now() > (locked_at + #{interval}::INTERVAL)
+	GetExpiredLockedUsers(ctx context.Context, interval string) ([]User, error)
+
 	CreateUser(ctx context.Context, user *User) (int64, error)
 
 	UpdateUser(ctx context.Context, id int64, user *User) (int64, error)
 
-	// @record_type User
+	// @type update
+	// @default UPDATE <tablename type="User"/>
+	//       SET password = #{password}, password_changed_at = now(), must_change_password = false
+	//       WHERE id=#{id}
 	UpdateUserPassword(ctx context.Context, id int64, password string) (int64, error)
 
+	// @type update
+	// @default UPDATE <tablename type="User"/> SET password = #{password} WHERE id=#{id}
+	RehashUserPassword(ctx context.Context, id int64, password string) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="User"/> SET must_change_password = #{mustChange} WHERE id=#{id}
+	SetMustChangePassword(ctx context.Context, id int64, mustChange bool) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="User"/> SET expires_at = #{expiresAt} WHERE id=#{id}
+	SetUserExpiresAt(ctx context.Context, id int64, expiresAt *time.Time) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="User"/> SET last_login_at = now() WHERE lower(name) = lower(#{username})
+	TouchLastLogin(ctx context.Context, username string) (int64, error)
+
 	// @record_type User
 	DeleteUser(ctx context.Context, id int64) (int64, error)
 
@@ -8,6 +8,7 @@ import (
 	"io"
 	"reflect"
 	"strings"
+	"time"
 
 	gobatis "github.com/runner-mei/GoBatis"
 )
@@ -73,7 +74,7 @@ func init() {
 				} else {
 					sb.WriteString(tablename)
 				}
-				sb.WriteString("(user_id, address, uuid, created_at, updated_at)\r\n          VALUES(#{userID}, #{address}, #{uuid}, now(), now())  ON CONFLICT (user_id, address)\r\n          DO UPDATE SET updated = now()")
+				sb.WriteString("(user_id, address, uuid, created_at, updated_at)\r\n          VALUES(#{userID}, #{address}, #{uuid}, now(), now())  ON CONFLICT (user_id, address) WHERE impersonator_id IS NULL\r\n          DO UPDATE SET updated = now()")
 				sqlStr := sb.String()
 
 				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.CreateOrTouch",
@@ -130,6 +131,202 @@ func init() {
 				ctx.Statements["OnlineUserDao.Delete"] = stmt
 			}
 		}
+		{ //// OnlineUserDao.GetByUUID
+			if _, exists := ctx.Statements["OnlineUserDao.GetByUUID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT o.*, u.name AS username FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("o")
+				sb.WriteString("\r\n          INNER JOIN ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("u")
+				sb.WriteString(" ON o.user_id = u.id\r\n          WHERE o.uuid = #{uuid} <if test=\"isNotEmpty(interval)\"> AND (o.updated_at + #{interval}::INTERVAL) &gt; now() </if>")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.GetByUUID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.GetByUUID"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.Query
+			if _, exists := ctx.Statements["OnlineUserDao.Query"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT o.*, u.name AS username FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("o")
+				sb.WriteString("\r\n          INNER JOIN ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("u")
+				sb.WriteString(" ON o.user_id = u.id\r\n          <where>\r\n          <if test=\"userID &gt; 0\"> o.user_id = #{userID} </if>\r\n          <if test=\"isNotEmpty(username)\"> AND u.name = #{username} </if>\r\n          <if test=\"isNotEmpty(address)\"> AND o.address = #{address}::INET </if>\r\n          <if test=\"isNotEmpty(interval)\"> AND (o.updated_at + #{interval}::INTERVAL) &gt; now() </if>\r\n          </where>\r\n          ORDER BY o.updated_at DESC\r\n          <pagination />")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.Query",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.Query"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.Upsert
+			if _, exists := ctx.Statements["OnlineUserDao.Upsert"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(user_id, address, uuid, created_at, updated_at)\r\n          VALUES(#{userID}, #{address}, #{uuid}, now(), now())  ON CONFLICT (user_id, address) WHERE impersonator_id IS NULL\r\n          DO UPDATE SET uuid = EXCLUDED.uuid, created_at = now(), updated_at = now()")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.Upsert",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.Upsert"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.CreateImpersonation
+			if _, exists := ctx.Statements["OnlineUserDao.CreateImpersonation"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(user_id, address, uuid, impersonator_id, created_at, updated_at)\r\n          VALUES(#{userID}, #{address}, #{uuid}, #{impersonatorID}, now(), now())")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.CreateImpersonation",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.CreateImpersonation"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.TouchByUUID
+			if _, exists := ctx.Statements["OnlineUserDao.TouchByUUID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET updated_at = now() WHERE uuid = #{uuid}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.TouchByUUID",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.TouchByUUID"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.DeleteByUUID
+			if _, exists := ctx.Statements["OnlineUserDao.DeleteByUUID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE uuid = #{uuid}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.DeleteByUUID",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.DeleteByUUID"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.DeleteByUserID
+			if _, exists := ctx.Statements["OnlineUserDao.DeleteByUserID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE user_id = #{userID} AND uuid != #{exceptUUID}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.DeleteByUserID",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.DeleteByUserID"] = stmt
+			}
+		}
+		{ //// OnlineUserDao.DeleteExpired
+			if _, exists := ctx.Statements["OnlineUserDao.DeleteExpired"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&OnlineUser{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE now() > (updated_at + #{interval}::INTERVAL)")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "OnlineUserDao.DeleteExpired",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["OnlineUserDao.DeleteExpired"] = stmt
+			}
+		}
 		return nil
 	})
 }
@@ -224,6 +421,119 @@ func (impl *OnlineUserDaoImpl) Delete(ctx context.Context, userID int64, address
 		})
 }
 
+func (impl *OnlineUserDaoImpl) GetByUUID(ctx context.Context, uuid string, interval string) func(*OnlineUser) error {
+	result := impl.session.SelectOne(ctx, "OnlineUserDao.GetByUUID",
+		[]string{
+			"uuid",
+			"interval",
+		},
+		[]interface{}{
+			uuid,
+			interval,
+		})
+	return func(value *OnlineUser) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *OnlineUserDaoImpl) Query(ctx context.Context, userID int64, username string, address string, interval string, offset int64, limit int64) ([]OnlineUser, error) {
+	var instances []OnlineUser
+	results := impl.session.Select(ctx, "OnlineUserDao.Query",
+		[]string{
+			"userID",
+			"username",
+			"address",
+			"interval",
+			"offset",
+			"limit",
+		},
+		[]interface{}{
+			userID,
+			username,
+			address,
+			interval,
+			offset,
+			limit,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *OnlineUserDaoImpl) Upsert(ctx context.Context, userID int64, address string, uuid string) (int64, error) {
+	return impl.session.Insert(ctx, "OnlineUserDao.Upsert",
+		[]string{
+			"userID",
+			"address",
+			"uuid",
+		},
+		[]interface{}{
+			userID,
+			address,
+			uuid,
+		})
+}
+
+func (impl *OnlineUserDaoImpl) CreateImpersonation(ctx context.Context, userID int64, address string, uuid string, impersonatorID int64) (int64, error) {
+	return impl.session.Insert(ctx, "OnlineUserDao.CreateImpersonation",
+		[]string{
+			"userID",
+			"address",
+			"uuid",
+			"impersonatorID",
+		},
+		[]interface{}{
+			userID,
+			address,
+			uuid,
+			impersonatorID,
+		})
+}
+
+func (impl *OnlineUserDaoImpl) TouchByUUID(ctx context.Context, uuid string) (int64, error) {
+	return impl.session.Update(ctx, "OnlineUserDao.TouchByUUID",
+		[]string{
+			"uuid",
+		},
+		[]interface{}{
+			uuid,
+		})
+}
+
+func (impl *OnlineUserDaoImpl) DeleteByUUID(ctx context.Context, uuid string) (int64, error) {
+	return impl.session.Delete(ctx, "OnlineUserDao.DeleteByUUID",
+		[]string{
+			"uuid",
+		},
+		[]interface{}{
+			uuid,
+		})
+}
+
+func (impl *OnlineUserDaoImpl) DeleteByUserID(ctx context.Context, userID int64, exceptUUID string) (int64, error) {
+	return impl.session.Delete(ctx, "OnlineUserDao.DeleteByUserID",
+		[]string{
+			"userID",
+			"exceptUUID",
+		},
+		[]interface{}{
+			userID,
+			exceptUUID,
+		})
+}
+
+func (impl *OnlineUserDaoImpl) DeleteExpired(ctx context.Context, interval string) (int64, error) {
+	return impl.session.Delete(ctx, "OnlineUserDao.DeleteExpired",
+		[]string{
+			"interval",
+		},
+		[]interface{}{
+			interval,
+		})
+}
+
 func init() {
 	gobatis.Init(func(ctx *gobatis.InitContext) error {
 		{ //// UserQueryer.RolenameExists
@@ -486,6 +796,50 @@ func init() {
 				ctx.Statements["UserQueryer.GetUserByNickname"] = stmt
 			}
 		}
+		{ //// UserQueryer.GetUsersByEmail
+			if _, exists := ctx.Statements["UserQueryer.GetUsersByEmail"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE lower(attributes->>'email') = lower(#{email})")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetUsersByEmail",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserQueryer.GetUsersByEmail"] = stmt
+			}
+		}
+		{ //// UserQueryer.GetUserIDsByAttribute
+			if _, exists := ctx.Statements["UserQueryer.GetUserIDsByAttribute"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT id FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE lower(attributes->>#{key}) = lower(#{value})\r\n          AND id != #{excludeID} AND name NOT LIKE '%(deleted:%'")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetUserIDsByAttribute",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserQueryer.GetUserIDsByAttribute"] = stmt
+			}
+		}
 		{ //// UserQueryer.GetUserByNameOrNickname
 			if _, exists := ctx.Statements["UserQueryer.GetUserByNameOrNickname"]; !exists {
 				var sb strings.Builder
@@ -567,7 +921,7 @@ func init() {
 				}
 				sb.WriteString(" AS ")
 				sb.WriteString("r")
-				sb.WriteString(" ON u2r.role_id = r.id\r\n      WHERE r.name not in (<foreach collection=\"params.ExcludeRolenames\" separator=\",\">#{item}</foreach>) AND u2r.user_id = users.id) AND\r\n  </if>\r\n  <if test=\"isNotEmpty(params.NameLike)\"> (users.name like <like value=\"params.NameLike\" /> OR users.nickname like <like value=\"params.NameLike\" />) AND</if>\r\n  <if test=\"params.CanLogin.Valid\"> users.can_login = #{params.CanLogin} AND </if>\r\n  <if test=\"params.Enabled.Valid\"> (<if test=\"!params.Enabled.Bool\"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>\r\n  <if test=\"len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0\">\r\n     exists (select * from ")
+				sb.WriteString(" ON u2r.role_id = r.id\r\n      WHERE r.name not in (<foreach collection=\"params.ExcludeRolenames\" separator=\",\">#{item}</foreach>) AND u2r.user_id = users.id) AND\r\n  </if>\r\n  <if test=\"isNotEmpty(params.NameLike)\"> (users.name like <like value=\"params.NameLike\" /> OR users.nickname like <like value=\"params.NameLike\" />) AND</if>\r\n  <foreach collection=\"params.Attributes\" separator=\" \">\r\n    <if test=\"item.Like\"> users.attributes-&gt;&gt;#{item.Key} like <like value=\"item.Value\" /> AND</if>\r\n    <if test=\"!item.Like\"> users.attributes-&gt;&gt;#{item.Key} = #{item.Value} AND</if>\r\n  </foreach>\r\n  <if test=\"params.CanLogin.Valid\"> users.can_login = #{params.CanLogin} AND </if>\r\n  <if test=\"params.Enabled.Valid\"> (<if test=\"!params.Enabled.Bool\"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>\r\n  <if test=\"len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0\">\r\n     exists (select * from ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
 					return err
 				} else {
@@ -661,7 +1015,7 @@ func init() {
 				}
 				sb.WriteString(" AS ")
 				sb.WriteString("r")
-				sb.WriteString(" ON u2r.role_id = r.id\r\n      WHERE r.name not in (<foreach collection=\"params.ExcludeRolenames\" separator=\",\">#{item}</foreach>) AND u2r.user_id = users.id) AND\r\n  </if>\r\n  <if test=\"isNotEmpty(params.NameLike)\"> (users.name like <like value=\"params.NameLike\" /> OR users.nickname like <like value=\"params.NameLike\" />) AND</if>\r\n  <if test=\"params.CanLogin.Valid\"> users.can_login = #{params.CanLogin} AND </if>\r\n  <if test=\"params.Enabled.Valid\"> (<if test=\"!params.Enabled.Bool\"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>\r\n  <if test=\"len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0\">\r\n     exists (select * from ")
+				sb.WriteString(" ON u2r.role_id = r.id\r\n      WHERE r.name not in (<foreach collection=\"params.ExcludeRolenames\" separator=\",\">#{item}</foreach>) AND u2r.user_id = users.id) AND\r\n  </if>\r\n  <if test=\"isNotEmpty(params.NameLike)\"> (users.name like <like value=\"params.NameLike\" /> OR users.nickname like <like value=\"params.NameLike\" />) AND</if>\r\n  <foreach collection=\"params.Attributes\" separator=\" \">\r\n    <if test=\"item.Like\"> users.attributes-&gt;&gt;#{item.Key} like <like value=\"item.Value\" /> AND</if>\r\n    <if test=\"!item.Like\"> users.attributes-&gt;&gt;#{item.Key} = #{item.Value} AND</if>\r\n  </foreach>\r\n  <if test=\"params.CanLogin.Valid\"> users.can_login = #{params.CanLogin} AND </if>\r\n  <if test=\"params.Enabled.Valid\"> (<if test=\"!params.Enabled.Bool\"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>\r\n  <if test=\"len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0\">\r\n     exists (select * from ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
 					return err
 				} else {
@@ -755,7 +1109,7 @@ func init() {
 				}
 				sb.WriteString(" AS ")
 				sb.WriteString("r")
-				sb.WriteString(" ON u2r.role_id = r.id\r\n      WHERE r.name not in (<foreach collection=\"params.ExcludeRolenames\" separator=\",\">#{item}</foreach>) AND u2r.user_id = users.id) AND\r\n  </if>\r\n  <if test=\"isNotEmpty(params.NameLike)\"> (users.name like <like value=\"params.NameLike\" /> OR users.nickname like <like value=\"params.NameLike\" />) AND</if>\r\n  <if test=\"params.CanLogin.Valid\"> users.can_login = #{params.CanLogin} AND </if>\r\n  <if test=\"params.Enabled.Valid\"> (<if test=\"!params.Enabled.Bool\"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>\r\n  <if test=\"len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0\">\r\n     exists (select * from ")
+				sb.WriteString(" ON u2r.role_id = r.id\r\n      WHERE r.name not in (<foreach collection=\"params.ExcludeRolenames\" separator=\",\">#{item}</foreach>) AND u2r.user_id = users.id) AND\r\n  </if>\r\n  <if test=\"isNotEmpty(params.NameLike)\"> (users.name like <like value=\"params.NameLike\" /> OR users.nickname like <like value=\"params.NameLike\" />) AND</if>\r\n  <foreach collection=\"params.Attributes\" separator=\" \">\r\n    <if test=\"item.Like\"> users.attributes-&gt;&gt;#{item.Key} like <like value=\"item.Value\" /> AND</if>\r\n    <if test=\"!item.Like\"> users.attributes-&gt;&gt;#{item.Key} = #{item.Value} AND</if>\r\n  </foreach>\r\n  <if test=\"params.CanLogin.Valid\"> users.can_login = #{params.CanLogin} AND </if>\r\n  <if test=\"params.Enabled.Valid\"> (<if test=\"!params.Enabled.Bool\"> NOT </if> ( users.disabled IS NULL OR users.disabled = false )) AND </if>\r\n  <if test=\"len(params.UsergroupIDs) &gt; 0 || len(params.JobPositions) &gt; 0\">\r\n     exists (select * from ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
 					return err
 				} else {
@@ -776,22 +1130,162 @@ func init() {
 					sb.WriteString(tablename)
 				}
 				sb.WriteString(" AS ")
-				sb.WriteString("D")
-				sb.WriteString(" JOIN ALLGROUPS ON D.PARENT_ID = ALLGROUPS.ID)\r\n                SELECT ID FROM ALLGROUPS)\r\n           </if>\r\n           <if test=\"!params.UsergroupRecursive\">\r\n                  <if test=\"len(params.UsergroupIDs) == 1\"> and u2g.group_id = <foreach collection=\"params.UsergroupIDs\" separator=\",\">#{item}</foreach></if>\r\n                  <if test=\"len(params.UsergroupIDs) &gt; 1\"> and u2g.group_id in (<foreach collection=\"params.UsergroupIDs\" separator=\",\">#{item}</foreach>)</if>\r\n           </if>\r\n         </if>\r\n     )\r\n  </if>\r\n  </where>")
+				sb.WriteString("D")
+				sb.WriteString(" JOIN ALLGROUPS ON D.PARENT_ID = ALLGROUPS.ID)\r\n                SELECT ID FROM ALLGROUPS)\r\n           </if>\r\n           <if test=\"!params.UsergroupRecursive\">\r\n                  <if test=\"len(params.UsergroupIDs) == 1\"> and u2g.group_id = <foreach collection=\"params.UsergroupIDs\" separator=\",\">#{item}</foreach></if>\r\n                  <if test=\"len(params.UsergroupIDs) &gt; 1\"> and u2g.group_id in (<foreach collection=\"params.UsergroupIDs\" separator=\",\">#{item}</foreach>)</if>\r\n           </if>\r\n         </if>\r\n     )\r\n  </if>\r\n  </where>")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetUserIDs",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserQueryer.GetUserIDs"] = stmt
+			}
+		}
+		{ //// UserQueryer.GetRolesByUserID
+			if _, exists := ctx.Statements["UserQueryer.GetRolesByUserID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("roles")
+				sb.WriteString(" WHERE\r\n  exists (select * from ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as users_roles\r\n     where users_roles.role_id = roles.id and users_roles.user_id = #{userID})\r\n  OR exists (select * from ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as u2g\r\n     where u2g.role_id = roles.id and u2g.user_id = #{userID})")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetRolesByUserID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserQueryer.GetRolesByUserID"] = stmt
+			}
+		}
+		{ //// UserQueryer.GetEffectiveRolesByUserID
+			if _, exists := ctx.Statements["UserQueryer.GetEffectiveRolesByUserID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE id in (\r\n   WITH RECURSIVE ALLROLES (ID, PARENT_ID) AS (\r\n     SELECT roles.id, roles.parent_id FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("roles")
+				sb.WriteString(" WHERE\r\n        exists (select * from ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as users_roles\r\n           where users_roles.role_id = roles.id and users_roles.user_id = #{userID})\r\n        <if test=\"groupScoped\">OR exists (select * from ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as u2g\r\n           where u2g.role_id = roles.id and u2g.user_id = #{userID})</if>\r\n        OR exists (select * from ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UsergroupAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as g2r\r\n           JOIN ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as u2g ON g2r.group_id = u2g.group_id\r\n           JOIN ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" as g ON g.id = u2g.group_id\r\n           where g2r.role_id = roles.id and u2g.user_id = #{userID} and (g.disabled IS NULL OR g.disabled = false))\r\n     UNION\r\n     SELECT P.ID, P.PARENT_ID FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("P")
+				sb.WriteString(" JOIN ALLROLES ON P.ID = ALLROLES.PARENT_ID)\r\n   SELECT ID FROM ALLROLES)")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetEffectiveRolesByUserID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserQueryer.GetEffectiveRolesByUserID"] = stmt
+			}
+		}
+		{ //// UserQueryer.GetRoleAncestors
+			if _, exists := ctx.Statements["UserQueryer.GetRoleAncestors"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE id in (\r\n   WITH RECURSIVE ALLROLES (ID, PARENT_ID) AS (\r\n     SELECT id, parent_id FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE id = #{id}\r\n     UNION\r\n     SELECT P.ID, P.PARENT_ID FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("P")
+				sb.WriteString(" JOIN ALLROLES ON P.ID = ALLROLES.PARENT_ID)\r\n   SELECT ID FROM ALLROLES)")
 				sqlStr := sb.String()
 
-				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetUserIDs",
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetRoleAncestors",
 					gobatis.StatementTypeSelect,
 					gobatis.ResultStruct,
 					sqlStr)
 				if err != nil {
 					return err
 				}
-				ctx.Statements["UserQueryer.GetUserIDs"] = stmt
+				ctx.Statements["UserQueryer.GetRoleAncestors"] = stmt
 			}
 		}
-		{ //// UserQueryer.GetRolesByUserID
-			if _, exists := ctx.Statements["UserQueryer.GetRolesByUserID"]; !exists {
+		{ //// UserQueryer.GetChildRoles
+			if _, exists := ctx.Statements["UserQueryer.GetChildRoles"]; !exists {
 				var sb strings.Builder
 				sb.WriteString("SELECT * FROM ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
@@ -799,31 +1293,39 @@ func init() {
 				} else {
 					sb.WriteString(tablename)
 				}
-				sb.WriteString(" AS ")
-				sb.WriteString("roles")
-				sb.WriteString(" WHERE\r\n  exists (select * from ")
-				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndRole{})); err != nil {
+				sb.WriteString(" WHERE parent_id = #{parentID} ORDER BY name")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetChildRoles",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
 					return err
-				} else {
-					sb.WriteString(tablename)
 				}
-				sb.WriteString(" as users_roles\r\n     where users_roles.role_id = roles.id and users_roles.user_id = #{userID})\r\n  OR exists (select * from ")
-				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+				ctx.Statements["UserQueryer.GetChildRoles"] = stmt
+			}
+		}
+		{ //// UserQueryer.GetUserCountByRoleID
+			if _, exists := ctx.Statements["UserQueryer.GetUserCountByRoleID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT count(*) FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndRole{})); err != nil {
 					return err
 				} else {
 					sb.WriteString(tablename)
 				}
-				sb.WriteString(" as u2g\r\n     where u2g.role_id = roles.id and u2g.user_id = #{userID})")
+				sb.WriteString(" WHERE role_id = #{roleID}")
 				sqlStr := sb.String()
 
-				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetRolesByUserID",
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserQueryer.GetUserCountByRoleID",
 					gobatis.StatementTypeSelect,
 					gobatis.ResultStruct,
 					sqlStr)
 				if err != nil {
 					return err
 				}
-				ctx.Statements["UserQueryer.GetRolesByUserID"] = stmt
+				ctx.Statements["UserQueryer.GetUserCountByRoleID"] = stmt
 			}
 		}
 		{ //// UserQueryer.ReadProfile
@@ -1263,6 +1765,42 @@ func (impl *UserQueryerImpl) GetUserByNickname(ctx context.Context, nickname str
 	}
 }
 
+func (impl *UserQueryerImpl) GetUsersByEmail(ctx context.Context, email string) ([]User, error) {
+	var instances []User
+	results := impl.session.Select(ctx, "UserQueryer.GetUsersByEmail",
+		[]string{
+			"email",
+		},
+		[]interface{}{
+			email,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *UserQueryerImpl) GetUserIDsByAttribute(ctx context.Context, key string, value string, excludeID int64) ([]int64, error) {
+	var instances []int64
+	results := impl.session.Select(ctx, "UserQueryer.GetUserIDsByAttribute",
+		[]string{
+			"key",
+			"value",
+			"excludeID",
+		},
+		[]interface{}{
+			key,
+			value,
+			excludeID,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
 func (impl *UserQueryerImpl) GetUserByNameOrNickname(ctx context.Context, name string, nickname string) func(*User) error {
 	result := impl.session.SelectOne(ctx, "UserQueryer.GetUserByNameOrNickname",
 		[]string{
@@ -1357,6 +1895,78 @@ func (impl *UserQueryerImpl) GetRolesByUserID(ctx context.Context, userID int64)
 	return instances, nil
 }
 
+func (impl *UserQueryerImpl) GetEffectiveRolesByUserID(ctx context.Context, userID int64, groupScoped bool) ([]Role, error) {
+	var instances []Role
+	results := impl.session.Select(ctx, "UserQueryer.GetEffectiveRolesByUserID",
+		[]string{
+			"userID",
+			"groupScoped",
+		},
+		[]interface{}{
+			userID,
+			groupScoped,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *UserQueryerImpl) GetRoleAncestors(ctx context.Context, id int64) ([]Role, error) {
+	var instances []Role
+	results := impl.session.Select(ctx, "UserQueryer.GetRoleAncestors",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *UserQueryerImpl) GetChildRoles(ctx context.Context, parentID int64) ([]Role, error) {
+	var instances []Role
+	results := impl.session.Select(ctx, "UserQueryer.GetChildRoles",
+		[]string{
+			"parentID",
+		},
+		[]interface{}{
+			parentID,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *UserQueryerImpl) GetUserCountByRoleID(ctx context.Context, roleID int64) (int64, error) {
+	var instance int64
+	var nullable gobatis.Nullable
+	nullable.Value = &instance
+
+	err := impl.session.SelectOne(ctx, "UserQueryer.GetUserCountByRoleID",
+		[]string{
+			"roleID",
+		},
+		[]interface{}{
+			roleID,
+		}).Scan(&nullable)
+	if err != nil {
+		return 0, err
+	}
+	if !nullable.Valid {
+		return 0, sql.ErrNoRows
+	}
+
+	return instance, nil
+}
+
 func (impl *UserQueryerImpl) ReadProfile(ctx context.Context, userID int64, name string) (string, error) {
 	var instance string
 	var nullable gobatis.Nullable
@@ -1585,6 +2195,28 @@ func init() {
 				ctx.Statements["UserDao.UnlockUserByUsername"] = stmt
 			}
 		}
+		{ //// UserDao.GetExpiredLockedUsers
+			if _, exists := ctx.Statements["UserDao.GetExpiredLockedUsers"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n       WHERE locked_at IS NOT NULL AND now() > (locked_at + #{interval}::INTERVAL)")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserDao.GetExpiredLockedUsers",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserDao.GetExpiredLockedUsers"] = stmt
+			}
+		}
 		{ //// UserDao.CreateUser
 			if _, exists := ctx.Statements["UserDao.CreateUser"]; !exists {
 				sqlStr, err := gobatis.GenerateInsertSQL(ctx.Dialect, ctx.Mapper,
@@ -1633,13 +2265,16 @@ func init() {
 		}
 		{ //// UserDao.UpdateUserPassword
 			if _, exists := ctx.Statements["UserDao.UpdateUserPassword"]; !exists {
-				sqlStr, err := gobatis.GenerateUpdateSQL2(ctx.Dialect, ctx.Mapper,
-					reflect.TypeOf(&User{}), reflect.TypeOf(new(int64)), "id", []string{
-						"password",
-					})
-				if err != nil {
-					return gobatis.ErrForGenerateStmt(err, "generate UserDao.UpdateUserPassword error")
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
 				}
+				sb.WriteString("\r\n       SET password = #{password}, password_changed_at = now(), must_change_password = false\r\n       WHERE id=#{id}")
+				sqlStr := sb.String()
+
 				stmt, err := gobatis.NewMapppedStatement(ctx, "UserDao.UpdateUserPassword",
 					gobatis.StatementTypeUpdate,
 					gobatis.ResultStruct,
@@ -1650,6 +2285,94 @@ func init() {
 				ctx.Statements["UserDao.UpdateUserPassword"] = stmt
 			}
 		}
+		{ //// UserDao.RehashUserPassword
+			if _, exists := ctx.Statements["UserDao.RehashUserPassword"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET password = #{password} WHERE id=#{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserDao.RehashUserPassword",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserDao.RehashUserPassword"] = stmt
+			}
+		}
+		{ //// UserDao.SetMustChangePassword
+			if _, exists := ctx.Statements["UserDao.SetMustChangePassword"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET must_change_password = #{mustChange} WHERE id=#{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserDao.SetMustChangePassword",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserDao.SetMustChangePassword"] = stmt
+			}
+		}
+		{ //// UserDao.SetUserExpiresAt
+			if _, exists := ctx.Statements["UserDao.SetUserExpiresAt"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET expires_at = #{expiresAt} WHERE id=#{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserDao.SetUserExpiresAt",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserDao.SetUserExpiresAt"] = stmt
+			}
+		}
+		{ //// UserDao.TouchLastLogin
+			if _, exists := ctx.Statements["UserDao.TouchLastLogin"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&User{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET last_login_at = now() WHERE lower(name) = lower(#{username})")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserDao.TouchLastLogin",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserDao.TouchLastLogin"] = stmt
+			}
+		}
 		{ //// UserDao.DeleteUser
 			if _, exists := ctx.Statements["UserDao.DeleteUser"]; !exists {
 				sqlStr, err := gobatis.GenerateDeleteSQL(ctx.Dialect, ctx.Mapper,
@@ -1952,6 +2675,22 @@ func (impl *UserDaoImpl) UnlockUserByUsername(ctx context.Context, username stri
 	return err
 }
 
+func (impl *UserDaoImpl) GetExpiredLockedUsers(ctx context.Context, interval string) ([]User, error) {
+	var instances []User
+	results := impl.session.Select(ctx, "UserDao.GetExpiredLockedUsers",
+		[]string{
+			"interval",
+		},
+		[]interface{}{
+			interval,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
 func (impl *UserDaoImpl) CreateUser(ctx context.Context, user *User) (int64, error) {
 	return impl.session.Insert(ctx, "UserDao.CreateUser",
 		[]string{
@@ -1986,6 +2725,52 @@ func (impl *UserDaoImpl) UpdateUserPassword(ctx context.Context, id int64, passw
 		})
 }
 
+func (impl *UserDaoImpl) RehashUserPassword(ctx context.Context, id int64, password string) (int64, error) {
+	return impl.session.Update(ctx, "UserDao.RehashUserPassword",
+		[]string{
+			"id",
+			"password",
+		},
+		[]interface{}{
+			id,
+			password,
+		})
+}
+
+func (impl *UserDaoImpl) SetMustChangePassword(ctx context.Context, id int64, mustChange bool) (int64, error) {
+	return impl.session.Update(ctx, "UserDao.SetMustChangePassword",
+		[]string{
+			"id",
+			"mustChange",
+		},
+		[]interface{}{
+			id,
+			mustChange,
+		})
+}
+
+func (impl *UserDaoImpl) SetUserExpiresAt(ctx context.Context, id int64, expiresAt *time.Time) (int64, error) {
+	return impl.session.Update(ctx, "UserDao.SetUserExpiresAt",
+		[]string{
+			"id",
+			"expiresAt",
+		},
+		[]interface{}{
+			id,
+			expiresAt,
+		})
+}
+
+func (impl *UserDaoImpl) TouchLastLogin(ctx context.Context, username string) (int64, error) {
+	return impl.session.Update(ctx, "UserDao.TouchLastLogin",
+		[]string{
+			"username",
+		},
+		[]interface{}{
+			username,
+		})
+}
+
 func (impl *UserDaoImpl) DeleteUser(ctx context.Context, id int64) (int64, error) {
 	return impl.session.Delete(ctx, "UserDao.DeleteUser",
 		[]string{
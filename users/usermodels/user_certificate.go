@@ -0,0 +1,45 @@
+//go:generate gobatis user_certificate.go
+
+package usermodels
+
+import (
+	"context"
+	"time"
+)
+
+// UserCertificate 绑定到用户的客户端证书, 用证书登录或者作为密码登录的第二个因素
+type UserCertificate struct {
+	TableName    struct{}   `json:"-" xorm:"moo_user_certificates"`
+	ID           int64      `json:"id" xorm:"id pk autoincr"`
+	UserID       int64      `json:"user_id" xorm:"user_id notnull"`
+	Fingerprint  string     `json:"fingerprint" xorm:"fingerprint unique notnull"`
+	Subject      string     `json:"subject" xorm:"subject notnull"`
+	Issuer       string     `json:"issuer" xorm:"issuer notnull"`
+	SerialNumber string     `json:"serial_number" xorm:"serial_number notnull"`
+	NotAfter     time.Time  `json:"not_after" xorm:"not_after notnull"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" xorm:"last_used_at null"`
+	CreatedAt    time.Time  `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+type UserCertificateDao interface {
+	Create(ctx context.Context, cert *UserCertificate) (int64, error)
+
+	// @record_type UserCertificate
+	GetByID(ctx context.Context, id int64) func(*UserCertificate) error
+
+	// @default SELECT * FROM <tablename type="UserCertificate" /> WHERE fingerprint = #{fingerprint}
+	GetByFingerprint(ctx context.Context, fingerprint string) func(*UserCertificate) error
+
+	// @default SELECT * FROM <tablename type="UserCertificate" /> WHERE user_id = #{userID} ORDER BY id
+	ListByUserID(ctx context.Context, userID int64) ([]UserCertificate, error)
+
+	// @default SELECT count(*) FROM <tablename type="UserCertificate" /> WHERE user_id = #{userID}
+	CountByUserID(ctx context.Context, userID int64) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="UserCertificate" /> SET last_used_at = now() WHERE id = #{id}
+	Touch(ctx context.Context, id int64) error
+
+	// @record_type UserCertificate
+	DeleteByID(ctx context.Context, id int64) (int64, error)
+}
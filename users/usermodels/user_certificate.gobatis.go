@@ -0,0 +1,292 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// UserCertificateDao.Create
+			if _, exists := ctx.Statements["UserCertificateDao.Create"]; !exists {
+				sqlStr, err := gobatis.GenerateInsertSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&UserCertificate{}),
+					[]string{
+						"cert",
+					},
+					[]reflect.Type{
+						reflect.TypeOf((*UserCertificate)(nil)),
+					}, false)
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate UserCertificateDao.Create error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.Create",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.Create"] = stmt
+			}
+		}
+		{ //// UserCertificateDao.GetByID
+			if _, exists := ctx.Statements["UserCertificateDao.GetByID"]; !exists {
+				sqlStr, err := gobatis.GenerateSelectSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&UserCertificate{}),
+					[]string{
+						"id",
+					},
+					[]reflect.Type{
+						reflect.TypeOf(new(int64)).Elem(),
+					},
+					[]gobatis.Filter{})
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate UserCertificateDao.GetByID error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.GetByID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.GetByID"] = stmt
+			}
+		}
+		{ //// UserCertificateDao.GetByFingerprint
+			if _, exists := ctx.Statements["UserCertificateDao.GetByFingerprint"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserCertificate{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE fingerprint = #{fingerprint}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.GetByFingerprint",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.GetByFingerprint"] = stmt
+			}
+		}
+		{ //// UserCertificateDao.ListByUserID
+			if _, exists := ctx.Statements["UserCertificateDao.ListByUserID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserCertificate{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE user_id = #{userID} ORDER BY id")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.ListByUserID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.ListByUserID"] = stmt
+			}
+		}
+		{ //// UserCertificateDao.CountByUserID
+			if _, exists := ctx.Statements["UserCertificateDao.CountByUserID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT count(*) FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserCertificate{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE user_id = #{userID}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.CountByUserID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.CountByUserID"] = stmt
+			}
+		}
+		{ //// UserCertificateDao.Touch
+			if _, exists := ctx.Statements["UserCertificateDao.Touch"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserCertificate{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET last_used_at = now() WHERE id = #{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.Touch",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.Touch"] = stmt
+			}
+		}
+		{ //// UserCertificateDao.DeleteByID
+			if _, exists := ctx.Statements["UserCertificateDao.DeleteByID"]; !exists {
+				sqlStr, err := gobatis.GenerateDeleteSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&UserCertificate{}),
+					[]string{
+						"id",
+					},
+					[]reflect.Type{
+						reflect.TypeOf(new(int64)).Elem(),
+					},
+					[]gobatis.Filter{})
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate UserCertificateDao.DeleteByID error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserCertificateDao.DeleteByID",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserCertificateDao.DeleteByID"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewUserCertificateDao(ref gobatis.SqlSession) UserCertificateDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &UserCertificateDaoImpl{session: ref}
+}
+
+type UserCertificateDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *UserCertificateDaoImpl) Create(ctx context.Context, cert *UserCertificate) (int64, error) {
+	return impl.session.Insert(ctx, "UserCertificateDao.Create",
+		[]string{
+			"cert",
+		},
+		[]interface{}{
+			cert,
+		})
+}
+
+func (impl *UserCertificateDaoImpl) GetByID(ctx context.Context, id int64) func(*UserCertificate) error {
+	result := impl.session.SelectOne(ctx, "UserCertificateDao.GetByID",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+	return func(value *UserCertificate) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *UserCertificateDaoImpl) GetByFingerprint(ctx context.Context, fingerprint string) func(*UserCertificate) error {
+	result := impl.session.SelectOne(ctx, "UserCertificateDao.GetByFingerprint",
+		[]string{
+			"fingerprint",
+		},
+		[]interface{}{
+			fingerprint,
+		})
+	return func(value *UserCertificate) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *UserCertificateDaoImpl) ListByUserID(ctx context.Context, userID int64) ([]UserCertificate, error) {
+	var instances []UserCertificate
+	results := impl.session.Select(ctx, "UserCertificateDao.ListByUserID",
+		[]string{
+			"userID",
+		},
+		[]interface{}{
+			userID,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (impl *UserCertificateDaoImpl) CountByUserID(ctx context.Context, userID int64) (int64, error) {
+	var instance int64
+	var nullable gobatis.Nullable
+	nullable.Value = &instance
+
+	err := impl.session.SelectOne(ctx, "UserCertificateDao.CountByUserID",
+		[]string{
+			"userID",
+		},
+		[]interface{}{
+			userID,
+		}).Scan(&nullable)
+	if err != nil {
+		return 0, err
+	}
+	if !nullable.Valid {
+		return 0, sql.ErrNoRows
+	}
+
+	return instance, nil
+}
+
+func (impl *UserCertificateDaoImpl) Touch(ctx context.Context, id int64) error {
+	_, err := impl.session.Update(ctx, "UserCertificateDao.Touch",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+	return err
+}
+
+func (impl *UserCertificateDaoImpl) DeleteByID(ctx context.Context, id int64) (int64, error) {
+	return impl.session.Delete(ctx, "UserCertificateDao.DeleteByID",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+}
@@ -0,0 +1,30 @@
+//go:generate gobatis user_field_schema.go
+
+package usermodels
+
+import (
+	"context"
+	"time"
+)
+
+// UserFieldSchema 用户属性定义的一个版本, 属性定义文件修改后会记录一个新的版本
+type UserFieldSchema struct {
+	TableName struct{}  `json:"-" xorm:"moo_user_field_schemas"`
+	ID        int64     `json:"id" xorm:"id pk autoincr"`
+	Version   string    `json:"version" xorm:"version unique notnull"`
+	Content   string    `json:"content" xorm:"content notnull"`
+	CreatedAt time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+type UserFieldSchemaDao interface {
+	Insert(ctx context.Context, schema *UserFieldSchema) (int64, error)
+
+	// @default SELECT * FROM <tablename type="UserFieldSchema" /> ORDER BY id DESC LIMIT 1
+	Latest(ctx context.Context) func(*UserFieldSchema) error
+
+	// @default SELECT * FROM <tablename type="UserFieldSchema" /> WHERE version = #{version}
+	GetByVersion(ctx context.Context, version string) func(*UserFieldSchema) error
+
+	// @default SELECT id, version, created_at FROM <tablename type="UserFieldSchema" /> ORDER BY id DESC
+	List(ctx context.Context) ([]UserFieldSchema, error)
+}
@@ -0,0 +1,170 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// UserFieldSchemaDao.Insert
+			if _, exists := ctx.Statements["UserFieldSchemaDao.Insert"]; !exists {
+				sqlStr, err := gobatis.GenerateInsertSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&UserFieldSchema{}),
+					[]string{
+						"schema",
+					},
+					[]reflect.Type{
+						reflect.TypeOf((*UserFieldSchema)(nil)),
+					}, false)
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate UserFieldSchemaDao.Insert error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserFieldSchemaDao.Insert",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserFieldSchemaDao.Insert"] = stmt
+			}
+		}
+		{ //// UserFieldSchemaDao.Latest
+			if _, exists := ctx.Statements["UserFieldSchemaDao.Latest"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserFieldSchema{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" ORDER BY id DESC LIMIT 1")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserFieldSchemaDao.Latest",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserFieldSchemaDao.Latest"] = stmt
+			}
+		}
+		{ //// UserFieldSchemaDao.GetByVersion
+			if _, exists := ctx.Statements["UserFieldSchemaDao.GetByVersion"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserFieldSchema{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE version = #{version}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserFieldSchemaDao.GetByVersion",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserFieldSchemaDao.GetByVersion"] = stmt
+			}
+		}
+		{ //// UserFieldSchemaDao.List
+			if _, exists := ctx.Statements["UserFieldSchemaDao.List"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT id, version, created_at FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserFieldSchema{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" ORDER BY id DESC")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserFieldSchemaDao.List",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserFieldSchemaDao.List"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewUserFieldSchemaDao(ref gobatis.SqlSession) UserFieldSchemaDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &UserFieldSchemaDaoImpl{session: ref}
+}
+
+type UserFieldSchemaDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *UserFieldSchemaDaoImpl) Insert(ctx context.Context, schema *UserFieldSchema) (int64, error) {
+	return impl.session.Insert(ctx, "UserFieldSchemaDao.Insert",
+		[]string{
+			"schema",
+		},
+		[]interface{}{
+			schema,
+		})
+}
+
+func (impl *UserFieldSchemaDaoImpl) Latest(ctx context.Context) func(*UserFieldSchema) error {
+	result := impl.session.SelectOne(ctx, "UserFieldSchemaDao.Latest",
+		[]string{},
+		[]interface{}{})
+	return func(value *UserFieldSchema) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *UserFieldSchemaDaoImpl) GetByVersion(ctx context.Context, version string) func(*UserFieldSchema) error {
+	result := impl.session.SelectOne(ctx, "UserFieldSchemaDao.GetByVersion",
+		[]string{
+			"version",
+		},
+		[]interface{}{
+			version,
+		})
+	return func(value *UserFieldSchema) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *UserFieldSchemaDaoImpl) List(ctx context.Context) ([]UserFieldSchema, error) {
+	var instances []UserFieldSchema
+	results := impl.session.Select(ctx, "UserFieldSchemaDao.List",
+		[]string{},
+		[]interface{}{})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
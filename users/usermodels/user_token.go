@@ -0,0 +1,71 @@
+//go:generate gobatis user_token.go
+
+package usermodels
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// UserTokenResetPassword 重置密码的令牌
+	UserTokenResetPassword = "reset_password"
+	// UserTokenActivate 激活帐号的令牌
+	UserTokenActivate = "activate"
+	// UserTokenLoginLink 一次性登录链接的令牌
+	UserTokenLoginLink = "login_link"
+)
+
+// UserToken 通过邮件发给用户的一次性令牌，令牌本身不保存，只保存它的摘要
+type UserToken struct {
+	TableName struct{}   `json:"-" xorm:"moo_user_tokens"`
+	ID        int64      `json:"id" xorm:"id pk autoincr"`
+	UserID    int64      `json:"user_id" xorm:"user_id notnull"`
+	Purpose   string     `json:"purpose" xorm:"purpose notnull"`
+	TokenHash string     `json:"-" xorm:"token_hash unique notnull"`
+	ExpiresAt time.Time  `json:"expires_at" xorm:"expires_at notnull"`
+	UsedAt    *time.Time `json:"used_at,omitempty" xorm:"used_at null"`
+	CreatedAt time.Time  `json:"created_at,omitempty" xorm:"created_at created"`
+
+	// 下面几个字段只用于登录链接
+
+	// TargetURL 登录成功后跳转的地址
+	TargetURL string `json:"target_url,omitempty" xorm:"target_url null"`
+	// Address 只允许从这个地址使用, 为空时不限制
+	Address     string `json:"address,omitempty" xorm:"address null"`
+	UsedAddress string `json:"used_address,omitempty" xorm:"used_address null"`
+	CreatedBy   int64  `json:"created_by,omitempty" xorm:"created_by null"`
+}
+
+func (token *UserToken) IsUsed() bool {
+	return token.UsedAt != nil && !token.UsedAt.IsZero()
+}
+
+func (token *UserToken) IsExpired(now time.Time) bool {
+	return now.After(token.ExpiresAt)
+}
+
+type UserTokenDao interface {
+	Create(ctx context.Context, token *UserToken) (int64, error)
+
+	// @default SELECT * FROM <tablename type="UserToken" /> WHERE token_hash = #{hash}
+	GetByHash(ctx context.Context, hash string) func(*UserToken) error
+
+	// @type update
+	// @default UPDATE <tablename type="UserToken" /> SET used_at = now() WHERE id = #{id} AND used_at IS NULL
+	MarkUsed(ctx context.Context, id int64) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="UserToken" /> SET used_at = now(), used_address = #{address}
+	//       WHERE id = #{id} AND used_at IS NULL AND expires_at >= now()
+	Redeem(ctx context.Context, id int64, address string) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="UserToken" /> SET used_at = now()
+	//       WHERE user_id = #{userID} AND purpose = #{purpose} AND used_at IS NULL
+	Invalidate(ctx context.Context, userID int64, purpose string) (int64, error)
+
+	// @type delete
+	// @default DELETE FROM <tablename type="UserToken" /> WHERE now() > expires_at
+	DeleteExpired(ctx context.Context) (int64, error)
+}
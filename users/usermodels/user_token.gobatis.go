@@ -0,0 +1,233 @@
+// Please don't edit this file!
+package usermodels
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+func init() {
+	gobatis.Init(func(ctx *gobatis.InitContext) error {
+		{ //// UserTokenDao.Create
+			if _, exists := ctx.Statements["UserTokenDao.Create"]; !exists {
+				sqlStr, err := gobatis.GenerateInsertSQL(ctx.Dialect, ctx.Mapper,
+					reflect.TypeOf(&UserToken{}),
+					[]string{
+						"token",
+					},
+					[]reflect.Type{
+						reflect.TypeOf((*UserToken)(nil)),
+					}, false)
+				if err != nil {
+					return gobatis.ErrForGenerateStmt(err, "generate UserTokenDao.Create error")
+				}
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserTokenDao.Create",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserTokenDao.Create"] = stmt
+			}
+		}
+		{ //// UserTokenDao.GetByHash
+			if _, exists := ctx.Statements["UserTokenDao.GetByHash"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE token_hash = #{hash}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserTokenDao.GetByHash",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserTokenDao.GetByHash"] = stmt
+			}
+		}
+		{ //// UserTokenDao.MarkUsed
+			if _, exists := ctx.Statements["UserTokenDao.MarkUsed"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET used_at = now() WHERE id = #{id} AND used_at IS NULL")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserTokenDao.MarkUsed",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserTokenDao.MarkUsed"] = stmt
+			}
+		}
+		{ //// UserTokenDao.Redeem
+			if _, exists := ctx.Statements["UserTokenDao.Redeem"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET used_at = now(), used_address = #{address}\r\n       WHERE id = #{id} AND used_at IS NULL AND expires_at >= now()")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserTokenDao.Redeem",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserTokenDao.Redeem"] = stmt
+			}
+		}
+		{ //// UserTokenDao.Invalidate
+			if _, exists := ctx.Statements["UserTokenDao.Invalidate"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET used_at = now()\r\n       WHERE user_id = #{userID} AND purpose = #{purpose} AND used_at IS NULL")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserTokenDao.Invalidate",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserTokenDao.Invalidate"] = stmt
+			}
+		}
+		{ //// UserTokenDao.DeleteExpired
+			if _, exists := ctx.Statements["UserTokenDao.DeleteExpired"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserToken{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE now() > expires_at")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UserTokenDao.DeleteExpired",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UserTokenDao.DeleteExpired"] = stmt
+			}
+		}
+		return nil
+	})
+}
+
+func NewUserTokenDao(ref gobatis.SqlSession) UserTokenDao {
+	if ref == nil {
+		panic(errors.New("param 'ref' is nil"))
+	}
+	if reference, ok := ref.(*gobatis.Reference); ok {
+		if reference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	} else if valueReference, ok := ref.(gobatis.Reference); ok {
+		if valueReference.SqlSession == nil {
+			panic(errors.New("param 'ref.SqlSession' is nil"))
+		}
+	}
+	return &UserTokenDaoImpl{session: ref}
+}
+
+type UserTokenDaoImpl struct {
+	session gobatis.SqlSession
+}
+
+func (impl *UserTokenDaoImpl) Create(ctx context.Context, token *UserToken) (int64, error) {
+	return impl.session.Insert(ctx, "UserTokenDao.Create",
+		[]string{
+			"token",
+		},
+		[]interface{}{
+			token,
+		})
+}
+
+func (impl *UserTokenDaoImpl) GetByHash(ctx context.Context, hash string) func(*UserToken) error {
+	result := impl.session.SelectOne(ctx, "UserTokenDao.GetByHash",
+		[]string{
+			"hash",
+		},
+		[]interface{}{
+			hash,
+		})
+	return func(value *UserToken) error {
+		return result.Scan(value)
+	}
+}
+
+func (impl *UserTokenDaoImpl) MarkUsed(ctx context.Context, id int64) (int64, error) {
+	return impl.session.Update(ctx, "UserTokenDao.MarkUsed",
+		[]string{
+			"id",
+		},
+		[]interface{}{
+			id,
+		})
+}
+
+func (impl *UserTokenDaoImpl) Redeem(ctx context.Context, id int64, address string) (int64, error) {
+	return impl.session.Update(ctx, "UserTokenDao.Redeem",
+		[]string{
+			"id",
+			"address",
+		},
+		[]interface{}{
+			id,
+			address,
+		})
+}
+
+func (impl *UserTokenDaoImpl) Invalidate(ctx context.Context, userID int64, purpose string) (int64, error) {
+	return impl.session.Update(ctx, "UserTokenDao.Invalidate",
+		[]string{
+			"userID",
+			"purpose",
+		},
+		[]interface{}{
+			userID,
+			purpose,
+		})
+}
+
+func (impl *UserTokenDaoImpl) DeleteExpired(ctx context.Context) (int64, error) {
+	return impl.session.Delete(ctx, "UserTokenDao.DeleteExpired",
+		[]string{},
+		[]interface{}{})
+}
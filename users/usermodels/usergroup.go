@@ -26,6 +26,7 @@ type Usergroup struct {
 	Description string    `json:"description" xorm:"description null"`
 	ParentID    int64     `json:"parent_id" xorm:"parent_id null"`
 	Disabled    bool      `json:"disabled" xorm:"disabled null"`
+	Sequence    int       `json:"sequence" xorm:"sequence null"`
 	CreatedAt   time.Time `json:"created_at,omitempty" xorm:"created_at created"`
 	UpdatedAt   time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
 }
@@ -101,7 +102,7 @@ type UsergroupQueryer interface {
 	// </if>
 	// <if test="!recursive">
 	//  EXISTS (select * from <tablename type="UserAndUsergroup" /> as uug
-	//     where uug.user_id = users.id and uug.group_id = #{groupID})
+	//     where uug.user_id = users.id and uug.group_id in (<foreach collection="groupIDs" separator=",">#{item}</foreach>))
 	// </if>
 	GetUsersByGroupIDs(ctx context.Context, groupIDs []int64, recursive bool, userEnabled sql.NullBool) ([]User, error)
 
@@ -131,6 +132,10 @@ type UsergroupQueryer interface {
 	// @default SELECT * FROM <tablename name="Role" as="roles" /> WHERE roles.id in
 	//  (SELECT role_id from <tablename type="UserAndUsergroup" /> WHERE group_id = #{usergroupID} and user_id = #{userID})
 	GetRoleByUsergroupIDAndUserID(ctx context.Context, usergroupID, userID int64) ([]Role, error)
+
+	// @default SELECT * FROM <tablename type="Role" as="roles" /> WHERE roles.id in
+	//  (SELECT role_id from <tablename type="UsergroupAndRole" /> WHERE group_id = #{usergroupID}) ORDER BY roles.name
+	GetDefaultRolesByUsergroupID(ctx context.Context, usergroupID int64) ([]Role, error)
 }
 
 type UsergroupDao interface {
@@ -141,17 +146,17 @@ type UsergroupDao interface {
 	UpdateUsergroup(ctx context.Context, id int64, usergroup *Usergroup) (int64, error)
 
 	// @default <if test="recursive">
-	// SELECT * FROM <tablename type="Usergroup" /> where id in (
+	// DELETE FROM <tablename type="Usergroup" /> where id in (
 	//   WITH RECURSIVE ALLGROUPS (ID)  AS (
 	//     SELECT ID, name, PARENT_ID, ARRAY[ID] AS PATH, 1 AS DEPTH
-	//        FROM <tablename type="Usergroup" as="ug" /> WHERE id=#{groupID}
+	//        FROM <tablename type="Usergroup" as="ug" /> WHERE id=#{id}
 	//     UNION ALL
 	//     SELECT  D.ID, D.NAME, D.PARENT_ID, ALLGROUPS.PATH || D.ID, ALLGROUPS.DEPTH + 1 AS DEPTH
 	//        FROM <tablename type="Usergroup" as="D" /> JOIN ALLGROUPS ON D.PARENT_ID = ALLGROUPS.ID)
 	//   SELECT ID FROM ALLGROUPS ORDER BY PATH)
 	// </if>
 	// <if test="!recursive">
-	//    SELECT * FROM <tablename type="Usergroup" /> where id = #{id}
+	//    DELETE FROM <tablename type="Usergroup" /> where id = #{id}
 	// </if>
 	DeleteUsergroup(ctx context.Context, id int64, recursive bool) (int64, error)
 
@@ -172,6 +177,43 @@ type UsergroupDao interface {
 	// @default DELETE FROM <tablename type="UserAndUsergroup"/>
 	//           WHERE user_id = #{userid}
 	RemoveUserFromAllGroups(ctx context.Context, userid int64) error
+
+	// @default INSERT INTO <tablename type="UsergroupAndRole"/>(group_id, role_id)
+	//       VALUES(#{groupid}, #{roleid})
+	//       ON CONFLICT (group_id, role_id) DO NOTHING
+	AddDefaultRoleToGroup(ctx context.Context, groupid, roleid int64) error
+
+	// @default DELETE FROM <tablename type="UsergroupAndRole"/>
+	//           WHERE group_id = #{groupid} and role_id = #{roleid}
+	RemoveDefaultRoleFromGroup(ctx context.Context, groupid, roleid int64) error
+
+	// @type update
+	// @default UPDATE <tablename type="Usergroup" />
+	//    SET parent_id = <if test="parentID &gt; 0">#{parentID}</if><if test="parentID &lt;= 0">NULL</if>, updated_at = now()
+	//    WHERE id = #{id}
+	MoveUsergroup(ctx context.Context, id, parentID int64) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="Usergroup" /> SET parent_id = #{toID}, updated_at = now() WHERE parent_id = #{fromID}
+	MoveChildUsergroups(ctx context.Context, fromID, toID int64) (int64, error)
+
+	// @default UPDATE <tablename type="Usergroup" /> SET sequence = #{sequence} WHERE id = #{id}
+	UpdateUsergroupSequence(ctx context.Context, id int64, sequence int) (int64, error)
+
+	// @type insert
+	// @default INSERT INTO <tablename type="UserAndUsergroup"/>(group_id, user_id, role_id)
+	//   SELECT #{toID}, uug.user_id, uug.role_id FROM <tablename type="UserAndUsergroup" as="uug" />
+	//   WHERE uug.group_id = #{fromID} AND NOT EXISTS (
+	//     SELECT * FROM <tablename type="UserAndUsergroup" as="o" />
+	//     WHERE o.group_id = #{toID} AND o.user_id = uug.user_id
+	//       AND (o.role_id = uug.role_id OR (o.role_id IS NULL AND uug.role_id IS NULL)))
+	CopyUsersToGroup(ctx context.Context, fromID, toID int64) error
+
+	// @type insert
+	// @default INSERT INTO <tablename type="UsergroupAndRole"/>(group_id, role_id)
+	//   SELECT #{toID}, role_id FROM <tablename type="UsergroupAndRole"/> WHERE group_id = #{fromID}
+	//   ON CONFLICT (group_id, role_id) DO NOTHING
+	CopyDefaultRolesToGroup(ctx context.Context, fromID, toID int64) error
 }
 
 func GetUsergroups(ctx context.Context, next func(*Usergroup) (bool, error)) ([]Usergroup, error) {
@@ -259,7 +259,7 @@ func init() {
 				} else {
 					sb.WriteString(tablename)
 				}
-				sb.WriteString(" as uug\r\n     where uug.user_id = users.id and uug.group_id = #{groupID})\r\n </if>")
+				sb.WriteString(" as uug\r\n     where uug.user_id = users.id and uug.group_id in (<foreach collection=\"groupIDs\" separator=\",\">#{item}</foreach>))\r\n </if>")
 				sqlStr := sb.String()
 
 				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupQueryer.GetUsersByGroupIDs",
@@ -406,6 +406,36 @@ func init() {
 				ctx.Statements["UsergroupQueryer.GetRoleByUsergroupIDAndUserID"] = stmt
 			}
 		}
+		{ //// UsergroupQueryer.GetDefaultRolesByUsergroupID
+			if _, exists := ctx.Statements["UsergroupQueryer.GetDefaultRolesByUsergroupID"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Role{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("roles")
+				sb.WriteString(" WHERE roles.id in\r\n  (SELECT role_id from ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UsergroupAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE group_id = #{usergroupID}) ORDER BY roles.name")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupQueryer.GetDefaultRolesByUsergroupID",
+					gobatis.StatementTypeSelect,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupQueryer.GetDefaultRolesByUsergroupID"] = stmt
+			}
+		}
 		return nil
 	})
 }
@@ -617,6 +647,22 @@ func (impl *UsergroupQueryerImpl) GetRoleByUsergroupIDAndUserID(ctx context.Cont
 	return instances, nil
 }
 
+func (impl *UsergroupQueryerImpl) GetDefaultRolesByUsergroupID(ctx context.Context, usergroupID int64) ([]Role, error) {
+	var instances []Role
+	results := impl.session.Select(ctx, "UsergroupQueryer.GetDefaultRolesByUsergroupID",
+		[]string{
+			"usergroupID",
+		},
+		[]interface{}{
+			usergroupID,
+		})
+	err := results.ScanSlice(&instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
 func init() {
 	gobatis.Init(func(ctx *gobatis.InitContext) error {
 		{ //// UsergroupDao.CreateUsergroup
@@ -668,7 +714,7 @@ func init() {
 		{ //// UsergroupDao.DeleteUsergroup
 			if _, exists := ctx.Statements["UsergroupDao.DeleteUsergroup"]; !exists {
 				var sb strings.Builder
-				sb.WriteString("<if test=\"recursive\">\r\n SELECT * FROM ")
+				sb.WriteString("<if test=\"recursive\">\r\n DELETE FROM ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
 					return err
 				} else {
@@ -682,7 +728,7 @@ func init() {
 				}
 				sb.WriteString(" AS ")
 				sb.WriteString("ug")
-				sb.WriteString(" WHERE id=#{groupID}\r\n     UNION ALL\r\n     SELECT  D.ID, D.NAME, D.PARENT_ID, ALLGROUPS.PATH || D.ID, ALLGROUPS.DEPTH + 1 AS DEPTH\r\n        FROM ")
+				sb.WriteString(" WHERE id=#{id}\r\n     UNION ALL\r\n     SELECT  D.ID, D.NAME, D.PARENT_ID, ALLGROUPS.PATH || D.ID, ALLGROUPS.DEPTH + 1 AS DEPTH\r\n        FROM ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
 					return err
 				} else {
@@ -690,7 +736,7 @@ func init() {
 				}
 				sb.WriteString(" AS ")
 				sb.WriteString("D")
-				sb.WriteString(" JOIN ALLGROUPS ON D.PARENT_ID = ALLGROUPS.ID)\r\n   SELECT ID FROM ALLGROUPS ORDER BY PATH)\r\n </if>\r\n <if test=\"!recursive\">\r\n    SELECT * FROM ")
+				sb.WriteString(" JOIN ALLGROUPS ON D.PARENT_ID = ALLGROUPS.ID)\r\n   SELECT ID FROM ALLGROUPS ORDER BY PATH)\r\n </if>\r\n <if test=\"!recursive\">\r\n    DELETE FROM ")
 				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
 					return err
 				} else {
@@ -797,6 +843,182 @@ func init() {
 				ctx.Statements["UsergroupDao.RemoveUserFromAllGroups"] = stmt
 			}
 		}
+		{ //// UsergroupDao.AddDefaultRoleToGroup
+			if _, exists := ctx.Statements["UsergroupDao.AddDefaultRoleToGroup"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UsergroupAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(group_id, role_id)\r\n       VALUES(#{groupid}, #{roleid})\r\n       ON CONFLICT (group_id, role_id) DO NOTHING")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.AddDefaultRoleToGroup",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.AddDefaultRoleToGroup"] = stmt
+			}
+		}
+		{ //// UsergroupDao.RemoveDefaultRoleFromGroup
+			if _, exists := ctx.Statements["UsergroupDao.RemoveDefaultRoleFromGroup"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("DELETE FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UsergroupAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n           WHERE group_id = #{groupid} and role_id = #{roleid}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.RemoveDefaultRoleFromGroup",
+					gobatis.StatementTypeDelete,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.RemoveDefaultRoleFromGroup"] = stmt
+			}
+		}
+		{ //// UsergroupDao.MoveUsergroup
+			if _, exists := ctx.Statements["UsergroupDao.MoveUsergroup"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("\r\n    SET parent_id = <if test=\"parentID &gt; 0\">#{parentID}</if><if test=\"parentID &lt;= 0\">NULL</if>, updated_at = now()\r\n    WHERE id = #{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.MoveUsergroup",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.MoveUsergroup"] = stmt
+			}
+		}
+		{ //// UsergroupDao.MoveChildUsergroups
+			if _, exists := ctx.Statements["UsergroupDao.MoveChildUsergroups"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET parent_id = #{toID}, updated_at = now() WHERE parent_id = #{fromID}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.MoveChildUsergroups",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.MoveChildUsergroups"] = stmt
+			}
+		}
+		{ //// UsergroupDao.UpdateUsergroupSequence
+			if _, exists := ctx.Statements["UsergroupDao.UpdateUsergroupSequence"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("UPDATE ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&Usergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" SET sequence = #{sequence} WHERE id = #{id}")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.UpdateUsergroupSequence",
+					gobatis.StatementTypeUpdate,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.UpdateUsergroupSequence"] = stmt
+			}
+		}
+		{ //// UsergroupDao.CopyUsersToGroup
+			if _, exists := ctx.Statements["UsergroupDao.CopyUsersToGroup"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(group_id, user_id, role_id)\r\n   SELECT #{toID}, uug.user_id, uug.role_id FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("uug")
+				sb.WriteString("\r\n   WHERE uug.group_id = #{fromID} AND NOT EXISTS (\r\n     SELECT * FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UserAndUsergroup{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" AS ")
+				sb.WriteString("o")
+				sb.WriteString("\r\n     WHERE o.group_id = #{toID} AND o.user_id = uug.user_id\r\n       AND (o.role_id = uug.role_id OR (o.role_id IS NULL AND uug.role_id IS NULL)))")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.CopyUsersToGroup",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.CopyUsersToGroup"] = stmt
+			}
+		}
+		{ //// UsergroupDao.CopyDefaultRolesToGroup
+			if _, exists := ctx.Statements["UsergroupDao.CopyDefaultRolesToGroup"]; !exists {
+				var sb strings.Builder
+				sb.WriteString("INSERT INTO ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UsergroupAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString("(group_id, role_id)\r\n   SELECT #{toID}, role_id FROM ")
+				if tablename, err := gobatis.ReadTableName(ctx.Mapper, reflect.TypeOf(&UsergroupAndRole{})); err != nil {
+					return err
+				} else {
+					sb.WriteString(tablename)
+				}
+				sb.WriteString(" WHERE group_id = #{fromID}\r\n   ON CONFLICT (group_id, role_id) DO NOTHING")
+				sqlStr := sb.String()
+
+				stmt, err := gobatis.NewMapppedStatement(ctx, "UsergroupDao.CopyDefaultRolesToGroup",
+					gobatis.StatementTypeInsert,
+					gobatis.ResultStruct,
+					sqlStr)
+				if err != nil {
+					return err
+				}
+				ctx.Statements["UsergroupDao.CopyDefaultRolesToGroup"] = stmt
+			}
+		}
 		return nil
 	})
 }
@@ -922,3 +1144,94 @@ func (impl *UsergroupDaoImpl) RemoveUserFromAllGroups(ctx context.Context, useri
 		})
 	return err
 }
+
+func (impl *UsergroupDaoImpl) AddDefaultRoleToGroup(ctx context.Context, groupid int64, roleid int64) error {
+	_, err := impl.session.Insert(ctx, "UsergroupDao.AddDefaultRoleToGroup",
+		[]string{
+			"groupid",
+			"roleid",
+		},
+		[]interface{}{
+			groupid,
+			roleid,
+		},
+		true)
+	return err
+}
+
+func (impl *UsergroupDaoImpl) RemoveDefaultRoleFromGroup(ctx context.Context, groupid int64, roleid int64) error {
+	_, err := impl.session.Delete(ctx, "UsergroupDao.RemoveDefaultRoleFromGroup",
+		[]string{
+			"groupid",
+			"roleid",
+		},
+		[]interface{}{
+			groupid,
+			roleid,
+		})
+	return err
+}
+
+func (impl *UsergroupDaoImpl) MoveUsergroup(ctx context.Context, id int64, parentID int64) (int64, error) {
+	return impl.session.Update(ctx, "UsergroupDao.MoveUsergroup",
+		[]string{
+			"id",
+			"parentID",
+		},
+		[]interface{}{
+			id,
+			parentID,
+		})
+}
+
+func (impl *UsergroupDaoImpl) MoveChildUsergroups(ctx context.Context, fromID int64, toID int64) (int64, error) {
+	return impl.session.Update(ctx, "UsergroupDao.MoveChildUsergroups",
+		[]string{
+			"fromID",
+			"toID",
+		},
+		[]interface{}{
+			fromID,
+			toID,
+		})
+}
+
+func (impl *UsergroupDaoImpl) UpdateUsergroupSequence(ctx context.Context, id int64, sequence int) (int64, error) {
+	return impl.session.Update(ctx, "UsergroupDao.UpdateUsergroupSequence",
+		[]string{
+			"id",
+			"sequence",
+		},
+		[]interface{}{
+			id,
+			sequence,
+		})
+}
+
+func (impl *UsergroupDaoImpl) CopyUsersToGroup(ctx context.Context, fromID int64, toID int64) error {
+	_, err := impl.session.Insert(ctx, "UsergroupDao.CopyUsersToGroup",
+		[]string{
+			"fromID",
+			"toID",
+		},
+		[]interface{}{
+			fromID,
+			toID,
+		},
+		true)
+	return err
+}
+
+func (impl *UsergroupDaoImpl) CopyDefaultRolesToGroup(ctx context.Context, fromID int64, toID int64) error {
+	_, err := impl.session.Insert(ctx, "UsergroupDao.CopyDefaultRolesToGroup",
+		[]string{
+			"fromID",
+			"toID",
+		},
+		[]interface{}{
+			fromID,
+			toID,
+		},
+		true)
+	return err
+}
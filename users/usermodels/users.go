@@ -21,9 +21,16 @@ type InUserPasswordHasher struct {
 
 func init() {
 	moo.On(func(*moo.Environment) moo.Option {
-		return moo.Provide(func(env *moo.Environment, db db.InModelFactory, hasher InUserPasswordHasher) *Users {
+		return moo.Provide(func(env *moo.Environment, db db.InModelFactory, hasher InUserPasswordHasher) (*Users, error) {
+			if hasher.Hasher == nil {
+				h, err := bcrypto.ReadHasher(env)
+				if err != nil {
+					return nil, err
+				}
+				hasher.Hasher = h
+			}
 			sessionRef := db.Factory.SessionReference()
-			return NewUsers(env, sessionRef, hasher.Hasher)
+			return NewUsers(env, sessionRef, hasher.Hasher), nil
 		})
 	})
 }
@@ -221,6 +228,57 @@ func (c *Users) CreateUser(ctx context.Context, user *User, roles []int64) (int6
 	return userid, nil
 }
 
+// PasswordNeedsRehash 密码不是用当前配置的算法或参数加密的, 需要重新加密
+func (c *Users) PasswordNeedsRehash(hashed string) bool {
+	if hashed == "" {
+		return false
+	}
+	if h, ok := c.hasher.(bcrypto.Hasher); ok {
+		return h.NeedsRehash(hashed)
+	}
+	return false
+}
+
+// RehashUserPassword 用当前的算法重新加密用户的密码, 与 UpdateUserPassword 不同,
+// 它不会修改密码的修改时间, 返回新的密码
+func (c *Users) RehashUserPassword(ctx context.Context, userID int64, password string) (string, error) {
+	newPassword, err := c.hasher.Hash(ctx, password)
+	if err != nil {
+		return "", errors.WithTitle(err, "用户密码加密失败")
+	}
+	count, err := c.UserDao.RehashUserPassword(ctx, userID, newPassword)
+	if err != nil {
+		return "", errors.Wrap(err, "更新用户密码失败")
+	}
+	if count == 0 {
+		return "", errors.ErrNotFoundWithText("该用户不存在!")
+	}
+	return newPassword, nil
+}
+
+// CountPasswordAlgorithms 统计各个加密算法的用户数
+func (c *Users) CountPasswordAlgorithms(ctx context.Context) (map[string]int, error) {
+	next, closer := c.UserDao.GetUsers(ctx, &UserQueryParams{}, 0, 0, "")
+	defer util.CloseWith(closer)
+
+	var results = map[string]int{}
+	for {
+		var u User
+		ok, err := next(&u)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				break
+			}
+			return nil, errors.Wrap(err, "查询用户失败")
+		}
+		if !ok {
+			break
+		}
+		results[bcrypto.AlgorithmOf(u.Password)]++
+	}
+	return results, nil
+}
+
 func (c *Users) UpdateUserPassword(ctx context.Context, userID int64, password string) error {
 	if password != "" {
 		newPassword, err := c.hasher.Hash(ctx, password)